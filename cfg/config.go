@@ -59,6 +59,12 @@ type Config struct {
 
 	OnlyDir string `yaml:"only-dir"`
 
+	OverlayLower string `yaml:"overlay-lower"`
+
+	Read ReadConfig `yaml:"read"`
+
+	SnapshotTime string `yaml:"snapshot-time"`
+
 	Write WriteConfig `yaml:"write"`
 }
 
@@ -67,6 +73,8 @@ type DebugConfig struct {
 
 	Gcs bool `yaml:"gcs"`
 
+	GcsTrace string `yaml:"gcs-trace"`
+
 	LogMutex bool `yaml:"log-mutex"`
 }
 
@@ -79,11 +87,19 @@ type FileCacheConfig struct {
 
 	EnableParallelDownloads bool `yaml:"enable-parallel-downloads"`
 
+	EnableSparseFile bool `yaml:"enable-sparse-file"`
+
+	EvictionPolicy string `yaml:"eviction-policy"`
+
 	MaxDownloadParallelism int64 `yaml:"max-download-parallelism"`
 
 	MaxSizeMb int64 `yaml:"max-size-mb"`
 
 	ReadRequestSizeMb int64 `yaml:"read-request-size-mb"`
+
+	SharedCacheDir bool `yaml:"shared-cache-dir"`
+
+	SparseFileChunkSizeMb int64 `yaml:"sparse-file-chunk-size-mb"`
 }
 
 type FileSystemConfig struct {
@@ -91,6 +107,8 @@ type FileSystemConfig struct {
 
 	DisableParallelDirops bool `yaml:"disable-parallel-dirops"`
 
+	EnableVersionsDir bool `yaml:"enable-versions-dir"`
+
 	FileMode Octal `yaml:"file-mode"`
 
 	FuseOptions []string `yaml:"fuse-options"`
@@ -99,8 +117,12 @@ type FileSystemConfig struct {
 
 	IgnoreInterrupts bool `yaml:"ignore-interrupts"`
 
+	PreservePosixAttributes bool `yaml:"preserve-posix-attributes"`
+
 	RenameDirLimit int64 `yaml:"rename-dir-limit"`
 
+	RenameDirParallelism int64 `yaml:"rename-dir-parallelism"`
+
 	TempDir string `yaml:"temp-dir"`
 
 	Uid int64 `yaml:"uid"`
@@ -181,10 +203,14 @@ type MetadataCacheConfig struct {
 
 	ExperimentalMetadataPrefetchOnMount string `yaml:"experimental-metadata-prefetch-on-mount"`
 
+	StatCacheEvictionPolicy string `yaml:"stat-cache-eviction-policy"`
+
 	StatCacheMaxSizeMb int64 `yaml:"stat-cache-max-size-mb"`
 
 	TtlSecs int64 `yaml:"ttl-secs"`
 
+	TypeCacheEvictionPolicy string `yaml:"type-cache-eviction-policy"`
+
 	TypeCacheMaxSizeMb int64 `yaml:"type-cache-max-size-mb"`
 }
 
@@ -196,8 +222,50 @@ type MonitoringConfig struct {
 	ExperimentalOpentelemetryCollectorAddress string `yaml:"experimental-opentelemetry-collector-address"`
 }
 
+type ReadConfig struct {
+	BlockCacheBlockSizeMb int64 `yaml:"block-cache-block-size-mb"`
+
+	BlockCacheMaxSizeMb int64 `yaml:"block-cache-max-size-mb"`
+
+	BlockCachePromotionThreshold int64 `yaml:"block-cache-promotion-threshold"`
+
+	EnableReadAhead bool `yaml:"enable-read-ahead"`
+
+	ReadAheadChunkSizeMb int64 `yaml:"read-ahead-chunk-size-mb"`
+
+	ReadAheadMaxInFlight int64 `yaml:"read-ahead-max-in-flight"`
+
+	ReadAheadMemoryMb int64 `yaml:"read-ahead-memory-mb"`
+}
+
 type WriteConfig struct {
+	AutoSyncDirtyMb int64 `yaml:"auto-sync-dirty-mb"`
+
+	AutoSyncInterval time.Duration `yaml:"auto-sync-interval"`
+
+	ConflictPolicy string `yaml:"conflict-policy"`
+
 	CreateEmptyFile bool `yaml:"create-empty-file"`
+
+	DirtyDataJournalDir ResolvedPath `yaml:"dirty-data-journal-dir"`
+
+	DirtyDataRecovery string `yaml:"dirty-data-recovery"`
+
+	EnableParallelUploads bool `yaml:"enable-parallel-uploads"`
+
+	EnablePartialUpdates bool `yaml:"enable-partial-updates"`
+
+	EnableStreamingWrites bool `yaml:"enable-streaming-writes"`
+
+	ParallelUploadThresholdMb int64 `yaml:"parallel-upload-threshold-mb"`
+
+	PartialUpdateBlockSizeMb int64 `yaml:"partial-update-block-size-mb"`
+
+	PartialUpdateThresholdMb int64 `yaml:"partial-update-threshold-mb"`
+
+	UploadParallelismPerFile int64 `yaml:"upload-parallelism-per-file"`
+
+	UploadPartSizeMb int64 `yaml:"upload-part-size-mb"`
 }
 
 func BindFlags(flagSet *pflag.FlagSet) error {
@@ -217,6 +285,30 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.IntP("auto-sync-dirty-mb", "", 0, "Write out the dirty contents of a file in the background once this many MiB of it are dirty, without waiting for it to be flushed. 0 disables.")
+
+	err = flagSet.MarkHidden("auto-sync-dirty-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.auto-sync-dirty-mb", flagSet.Lookup("auto-sync-dirty-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.DurationP("auto-sync-interval", "", 0*time.Nanosecond, "Write out the dirty contents of a file in the background once it has been dirty for this long, without waiting for it to be flushed. 0s disables.")
+
+	err = flagSet.MarkHidden("auto-sync-interval")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.auto-sync-interval", flagSet.Lookup("auto-sync-interval"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.StringP("billing-project", "", "", "Project to use for billing when accessing a bucket enabled with \"Requester Pays\". (The default is none)")
 
 	err = viper.BindPFlag("gcs-connection.billing-project", flagSet.Lookup("billing-project"))
@@ -224,6 +316,42 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.IntP("block-cache-block-size-mb", "", 1, "The size in MiB of the blocks of the block cache.")
+
+	err = flagSet.MarkHidden("block-cache-block-size-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.block-cache-block-size-mb", flagSet.Lookup("block-cache-block-size-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("block-cache-max-size-mb", "", 0, "The memory in MiB of the cache of object blocks which serves small reads that don't continue the previous read of a file handle. 0 disables it.")
+
+	err = flagSet.MarkHidden("block-cache-max-size-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.block-cache-max-size-mb", flagSet.Lookup("block-cache-max-size-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("block-cache-promotion-threshold", "", 2, "The number of reads of a block demoted from the block cache to the file cache after which it is promoted back to memory.")
+
+	err = flagSet.MarkHidden("block-cache-promotion-threshold")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.block-cache-promotion-threshold", flagSet.Lookup("block-cache-promotion-threshold"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.StringP("cache-dir", "", "", "Enables file-caching. Specifies the directory to use for file-cache.")
 
 	err = viper.BindPFlag("cache-dir", flagSet.Lookup("cache-dir"))
@@ -238,13 +366,25 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
-	flagSet.StringP("client-protocol", "", "http1", "The protocol used for communicating with the GCS backend. Value can be 'http1' (HTTP/1.1), 'http2' (HTTP/2) or 'grpc'.")
+	flagSet.StringP("client-protocol", "", "http1", "The protocol used for communicating with the GCS backend. Value can be 'http1' (HTTP/1.1), 'http2' (HTTP/2) or 'grpc', or 's3' to mount a bucket of an S3-compatible store at custom-endpoint instead.")
 
 	err = viper.BindPFlag("gcs-connection.client-protocol", flagSet.Lookup("client-protocol"))
 	if err != nil {
 		return err
 	}
 
+	flagSet.StringP("conflict-policy", "", "discard", "What to do with the local changes to a file when its object has been modified or deleted remotely since it was opened: discard them, fail with an error, or upload them as a sibling conflict object. Supported values: discard, error, conflict-object.")
+
+	err = flagSet.MarkHidden("conflict-policy")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.conflict-policy", flagSet.Lookup("conflict-policy"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.BoolP("create-empty-file", "", false, "For a new file, it creates an empty file in Cloud Storage bucket as a hold.")
 
 	err = flagSet.MarkDeprecated("create-empty-file", "This flag will be deleted soon.")
@@ -297,6 +437,13 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("debug_gcs_trace", "", "", "Record every GCS request and its response to a trace file at this path, which can be served back by mounting replay://<path>. See docs/mounting for more information")
+
+	err = viper.BindPFlag("debug.gcs-trace", flagSet.Lookup("debug_gcs_trace"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.BoolP("debug_http", "", false, "This flag is currently unused.")
 
 	err = flagSet.MarkDeprecated("debug_http", "This flag is currently unused.")
@@ -325,6 +472,30 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("dirty-data-journal-dir", "", "", "Directory in which to keep the contents of files, along with manifests of those not yet synced, so that they survive a crash and are recovered the next time the bucket is mounted. Empty disables journaling.")
+
+	err = flagSet.MarkHidden("dirty-data-journal-dir")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.dirty-data-journal-dir", flagSet.Lookup("dirty-data-journal-dir"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.StringP("dirty-data-recovery", "", "upload", "How to recover the unsynced contents of files left behind in the dirty-data journal directory by a crash: upload them to their objects, provided the objects have not been modified since, or move them into the recovered directory within the journal directory. Contents that can't be uploaded are moved too. Supported values: upload, move.")
+
+	err = flagSet.MarkHidden("dirty-data-recovery")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.dirty-data-recovery", flagSet.Lookup("dirty-data-recovery"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.BoolP("disable-parallel-dirops", "", false, "Specifies whether to allow parallel dir operations (lookups and readers)")
 
 	err = flagSet.MarkHidden("disable-parallel-dirops")
@@ -379,6 +550,73 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.BoolP("enable-parallel-uploads", "", false, "Write out large files as parallel composite uploads: upload parts of the file concurrently as temporary objects, then compose them into the object.")
+
+	err = flagSet.MarkHidden("enable-parallel-uploads")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.enable-parallel-uploads", flagSet.Lookup("enable-parallel-uploads"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.BoolP("enable-partial-updates", "", false, "Write out large files as blocks kept as objects of their own and composed into the object, so that only the blocks containing modified ranges are uploaded again when the file is next written out. The blocks are kept for as long as the objects, doubling the storage they take up.")
+
+	err = flagSet.MarkHidden("enable-partial-updates")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.enable-partial-updates", flagSet.Lookup("enable-partial-updates"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.BoolP("enable-read-ahead", "", false, "Serve sequential and strided reads from GCS out of chunks fetched ahead of them, with several range requests in flight per file handle.")
+
+	err = flagSet.MarkHidden("enable-read-ahead")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.enable-read-ahead", flagSet.Lookup("enable-read-ahead"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.BoolP("enable-sparse-file", "", false, "Downloads only the chunks of the file which are read, instead of the whole file.")
+
+	err = viper.BindPFlag("file-cache.enable-sparse-file", flagSet.Lookup("enable-sparse-file"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.BoolP("enable-streaming-writes", "", false, "Stream the sequential writes to new files to their objects as they are written, rather than staging them in temp files until the files are flushed.")
+
+	err = flagSet.MarkHidden("enable-streaming-writes")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.enable-streaming-writes", flagSet.Lookup("enable-streaming-writes"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.BoolP("enable-versions-dir", "", false, "Exposes every generation of objects, including noncurrent and soft-deleted ones, as read-only files in the virtual .gcsfuse-versions directory in the root of the mount.")
+
+	err = flagSet.MarkHidden("enable-versions-dir")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("file-system.enable-versions-dir", flagSet.Lookup("enable-versions-dir"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.BoolP("experimental-enable-json-read", "", false, "By default, GCSFuse uses the GCS XML API to get and read objects. When this flag is specified, GCSFuse uses the GCS JSON API instead.\"")
 
 	err = flagSet.MarkDeprecated("experimental-enable-json-read", "Experimental flag: could be dropped even in a minor release.")
@@ -427,6 +665,18 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("file-cache-eviction-policy", "", "lru", "The eviction policy of the file cache: lru, lfu or 2q (scan-resistant).")
+
+	err = flagSet.MarkHidden("file-cache-eviction-policy")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("file-cache.eviction-policy", flagSet.Lookup("file-cache-eviction-policy"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.IntP("file-cache-max-size-mb", "", -1, "Maximum size of the file-cache in MiBs")
 
 	err = viper.BindPFlag("file-cache.max-size-mb", flagSet.Lookup("file-cache-max-size-mb"))
@@ -434,6 +684,18 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.BoolP("file-cache-shared-cache-dir", "", false, "Makes the file cache safe to share with other gcsfuse processes using the same cache-dir, within a common max-size-mb.")
+
+	err = flagSet.MarkHidden("file-cache-shared-cache-dir")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("file-cache.shared-cache-dir", flagSet.Lookup("file-cache-shared-cache-dir"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.StringP("file-mode", "", "0644", "Permissions bits for files, in octal.")
 
 	err = viper.BindPFlag("file-system.file-mode", flagSet.Lookup("file-mode"))
@@ -602,6 +864,97 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("overlay-lower", "", "", "Mount the bucket as the writable upper layer over this read-only lower bucket, optionally followed by a slash and a directory within it. See docs/semantics for more information")
+
+	err = viper.BindPFlag("overlay-lower", flagSet.Lookup("overlay-lower"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("parallel-upload-threshold-mb", "", 256, "The size in MiB from which files are written out as parallel composite uploads, when enabled.")
+
+	err = flagSet.MarkHidden("parallel-upload-threshold-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.parallel-upload-threshold-mb", flagSet.Lookup("parallel-upload-threshold-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("partial-update-block-size-mb", "", 64, "The size in MiB of each block of a file written out by partial updates.")
+
+	err = flagSet.MarkHidden("partial-update-block-size-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.partial-update-block-size-mb", flagSet.Lookup("partial-update-block-size-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("partial-update-threshold-mb", "", 1024, "The size in MiB from which files are written out by partial updates, when enabled.")
+
+	err = flagSet.MarkHidden("partial-update-threshold-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.partial-update-threshold-mb", flagSet.Lookup("partial-update-threshold-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.BoolP("preserve-posix-attributes", "", false, "Stores the mode, uid and gid of files and explicit directories set by chmod and chown in the goog-reserved-posix-mode, goog-reserved-posix-uid and goog-reserved-posix-gid metadata of their objects, and reports the stored values instead of the mount-wide defaults.")
+
+	err = flagSet.MarkHidden("preserve-posix-attributes")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("file-system.preserve-posix-attributes", flagSet.Lookup("preserve-posix-attributes"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("read-ahead-chunk-size-mb", "", 8, "The size in MiB of each range request issued ahead of sequential reads.")
+
+	err = flagSet.MarkHidden("read-ahead-chunk-size-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.read-ahead-chunk-size-mb", flagSet.Lookup("read-ahead-chunk-size-mb"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("read-ahead-max-in-flight", "", 8, "The maximum number of range requests in flight ahead of the reads of a file handle.")
+
+	err = flagSet.MarkHidden("read-ahead-max-in-flight")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.read-ahead-max-in-flight", flagSet.Lookup("read-ahead-max-in-flight"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("read-ahead-memory-mb", "", 512, "The memory in MiB which the chunks read ahead of all file handles may take.")
+
+	err = flagSet.MarkHidden("read-ahead-memory-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("read.read-ahead-memory-mb", flagSet.Lookup("read-ahead-memory-mb"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.IntP("read-request-size-mb", "", 0, "Size of chunks in MiB that each concurrent request downloads.")
 
 	err = viper.BindPFlag("file-cache.read-request-size-mb", flagSet.Lookup("read-request-size-mb"))
@@ -616,6 +969,18 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.IntP("rename-dir-parallelism", "", 16, "The maximum number of objects copied in parallel when renaming a directory in a bucket without a hierarchical namespace.")
+
+	err = flagSet.MarkHidden("rename-dir-parallelism")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("file-system.rename-dir-parallelism", flagSet.Lookup("rename-dir-parallelism"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.Float64P("retry-multiplier", "", 2, "Param for exponential backoff algorithm, which is used to increase waiting time b/w two consecutive retries.")
 
 	err = viper.BindPFlag("gcs-retries.multiplier", flagSet.Lookup("retry-multiplier"))
@@ -637,6 +1002,20 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("snapshot-time", "", "", "Mount a read-only view of the bucket as it was at this RFC 3339 timestamp, or at mount time if \"now\". See docs/semantics for more information")
+
+	err = viper.BindPFlag("snapshot-time", flagSet.Lookup("snapshot-time"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("sparse-file-chunk-size-mb", "", 8, "Size of chunks in MiB in which the file is downloaded and evicted from cache when sparse file is enabled.")
+
+	err = viper.BindPFlag("file-cache.sparse-file-chunk-size-mb", flagSet.Lookup("sparse-file-chunk-size-mb"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.DurationP("stackdriver-export-interval", "", 0*time.Nanosecond, "Export metrics to stackdriver with this interval. The default value 0 indicates no exporting.")
 
 	err = viper.BindPFlag("metrics.stackdriver-export-interval", flagSet.Lookup("stackdriver-export-interval"))
@@ -656,6 +1035,18 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("stat-cache-eviction-policy", "", "lru", "The eviction policy of stat-cache: lru, lfu, 2q (scan-resistant) or ttl (expired entries first, then lru).")
+
+	err = flagSet.MarkHidden("stat-cache-eviction-policy")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("metadata-cache.stat-cache-eviction-policy", flagSet.Lookup("stat-cache-eviction-policy"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.IntP("stat-cache-max-size-mb", "", 32, "The maximum size of stat-cache in MiBs. It can also be set to -1 for no-size-limit, 0 for no cache. Values below -1 are not supported.")
 
 	err = viper.BindPFlag("metadata-cache.stat-cache-max-size-mb", flagSet.Lookup("stat-cache-max-size-mb"))
@@ -689,6 +1080,18 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.StringP("type-cache-eviction-policy", "", "lru", "The eviction policy of the type-cache maps: lru, lfu, 2q (scan-resistant) or ttl (expired entries first, then lru).")
+
+	err = flagSet.MarkHidden("type-cache-eviction-policy")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("metadata-cache.type-cache-eviction-policy", flagSet.Lookup("type-cache-eviction-policy"))
+	if err != nil {
+		return err
+	}
+
 	flagSet.IntP("type-cache-max-size-mb", "", 4, "Max size of type-cache maps which are maintained at a per-directory level.")
 
 	err = viper.BindPFlag("metadata-cache.type-cache-max-size-mb", flagSet.Lookup("type-cache-max-size-mb"))
@@ -715,5 +1118,29 @@ func BindFlags(flagSet *pflag.FlagSet) error {
 		return err
 	}
 
+	flagSet.IntP("upload-parallelism-per-file", "", 8, "The maximum number of parts of a file uploaded at a time by a parallel composite upload.")
+
+	err = flagSet.MarkHidden("upload-parallelism-per-file")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.upload-parallelism-per-file", flagSet.Lookup("upload-parallelism-per-file"))
+	if err != nil {
+		return err
+	}
+
+	flagSet.IntP("upload-part-size-mb", "", 64, "The size in MiB of each part of a parallel composite upload.")
+
+	err = flagSet.MarkHidden("upload-part-size-mb")
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindPFlag("write.upload-part-size-mb", flagSet.Lookup("upload-part-size-mb"))
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -39,13 +39,13 @@ func (o *Octal) String() string {
 	return fmt.Sprintf("%o", *o)
 }
 
-// Protocol is the datatype that specifies the type of connection: http1/http2/grpc.
+// Protocol is the datatype that specifies the type of connection: http1/http2/grpc/s3.
 type Protocol string
 
 func (p *Protocol) UnmarshalText(text []byte) error {
 	txtStr := string(text)
 	protocol := strings.ToLower(txtStr)
-	v := []string{"http1", "http2", "grpc"}
+	v := []string{"http1", "http2", "grpc", "s3"}
 	if !slices.Contains(v, protocol) {
 		return fmt.Errorf("invalid protocol value: %s. It can only accept values in the list: %v", txtStr, v)
 	}
@@ -47,6 +47,8 @@ func init() {
 
 USAGE:
    {{.Name}} {{if .Flags}}[global options]{{end}} [bucket] mountpoint
+
+   The bucket may also be a local directory, given as file:///path/to/dir.
    {{if .Version}}
 VERSION:
    {{.Version}}
@@ -138,6 +140,16 @@ func newApp() (app *cli.App) {
 				Usage: "Mount only a specific directory within the bucket. See docs/mounting for more information",
 			},
 
+			cli.StringFlag{
+				Name:  "snapshot-time",
+				Usage: "Mount a read-only view of the bucket as it was at this RFC 3339 timestamp, or at mount time if \"now\". See docs/semantics for more information",
+			},
+
+			cli.StringFlag{
+				Name:  "overlay-lower",
+				Usage: "Mount the bucket as the writable upper layer over this read-only lower bucket, optionally followed by a slash and a directory within it. See docs/semantics for more information",
+			},
+
 			cli.IntFlag{
 				Name:  "rename-dir-limit",
 				Value: 0,
@@ -274,7 +286,8 @@ func newApp() (app *cli.App) {
 				Name:  "client-protocol",
 				Value: string(mountpkg.HTTP1),
 				Usage: "The protocol used for communicating with the GCS backend. " +
-					"Value can be 'http1' (HTTP/1.1) or 'http2' (HTTP/2) or grpc.",
+					"Value can be 'http1' (HTTP/1.1) or 'http2' (HTTP/2) or grpc, " +
+					"or 's3' to mount a bucket of an S3-compatible store at --custom-endpoint instead.",
 			},
 
 			cli.IntFlag{
@@ -374,6 +387,11 @@ func newApp() (app *cli.App) {
 				Usage: "Print debug messages when a mutex is held too long.",
 			},
 
+			cli.StringFlag{
+				Name:  "debug_gcs_trace",
+				Usage: "Record every GCS request and its response to a trace file at this path, which can be served back by mounting replay://<path>. See docs/mounting for more information",
+			},
+
 			/////////////////////////
 			// Post-mount actions
 			/////////////////////////
@@ -402,6 +420,8 @@ type flagStorage struct {
 	Gid              int64
 	ImplicitDirs     bool
 	OnlyDir          string
+	SnapshotTime     time.Time
+	OverlayLower     string
 	RenameDirLimit   int64
 	IgnoreInterrupts bool
 
@@ -447,6 +467,7 @@ type flagStorage struct {
 	DebugHTTP       bool
 	DebugInvariants bool
 	DebugMutex      bool
+	DebugGCSTrace   string
 
 	// Post-mount actions
 
@@ -500,6 +521,11 @@ func resolvePathForTheFlagsInContext(c *cli.Context) (err error) {
 		return fmt.Errorf("resolving for config-file: %w", err)
 	}
 
+	err = resolvePathForTheFlagInContext("debug_gcs_trace", c)
+	if err != nil {
+		return fmt.Errorf("resolving for debug_gcs_trace: %w", err)
+	}
+
 	return
 }
 
@@ -517,6 +543,11 @@ func resolveConfigFilePaths(mountConfig *config.MountConfig) (err error) {
 		return
 	}
 
+	mountConfig.WriteConfig.DirtyDataJournalDir, err = resolveFilePath(mountConfig.WriteConfig.DirtyDataJournalDir, "write: dirty-data-journal-dir")
+	if err != nil {
+		return
+	}
+
 	return
 }
 
@@ -536,6 +567,12 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 		}
 	}
 
+	snapshotTime, err := parseSnapshotTime(c.String("snapshot-time"))
+	if err != nil {
+		err = fmt.Errorf("could not parse snapshot-time: %w", err)
+		return
+	}
+
 	clientProtocolString := strings.ToLower(c.String("client-protocol"))
 	clientProtocol := mountpkg.ClientProtocol(clientProtocolString)
 	flags = &flagStorage{
@@ -551,6 +588,8 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 		Gid:              int64(c.Int("gid")),
 		ImplicitDirs:     c.Bool("implicit-dirs"),
 		OnlyDir:          c.String("only-dir"),
+		SnapshotTime:     snapshotTime,
+		OverlayLower:     c.String("overlay-lower"),
 		RenameDirLimit:   int64(c.Int("rename-dir-limit")),
 		IgnoreInterrupts: c.Bool(config.IgnoreInterruptsFlagName),
 
@@ -597,6 +636,7 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 		DebugFS:         c.Bool("debug_fs"),
 		DebugInvariants: c.Bool("debug_invariants"),
 		DebugMutex:      c.Bool("debug_mutex"),
+		DebugGCSTrace:   c.String("debug_gcs_trace"),
 
 		// Post-mount actions
 		ExperimentalMetadataPrefetchOnMount: c.String(ExperimentalMetadataPrefetchOnMountFlag),
@@ -607,11 +647,30 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 		mountpkg.ParseOptions(flags.MountOptions, o)
 	}
 
+	// Snapshots are read-only.
+	if !flags.SnapshotTime.IsZero() {
+		flags.MountOptions["ro"] = ""
+	}
+
 	err = validateFlags(flags)
 
 	return
 }
 
+// parseSnapshotTime parses the value of the snapshot-time flag, returning the
+// zero time if it's empty.
+func parseSnapshotTime(value string) (t time.Time, err error) {
+	switch value {
+	case "":
+		return
+	case "now":
+		t = time.Now()
+		return
+	default:
+		return time.Parse(time.RFC3339, value)
+	}
+}
+
 func validateExperimentalMetadataPrefetchOnMount(mode string) error {
 	switch mode {
 	case config.ExperimentalMetadataPrefetchOnMountDisabled:
@@ -642,6 +701,10 @@ func validateFlags(flags *flagStorage) (err error) {
 		return fmt.Errorf("kernelListCacheTtlSeconds: %w", err)
 	}
 
+	if flags.OverlayLower != "" && !flags.SnapshotTime.IsZero() {
+		return fmt.Errorf("overlay-lower can't be used with snapshot-time")
+	}
+
 	return
 }
 
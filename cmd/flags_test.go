@@ -74,6 +74,8 @@ func (t *FlagsTest) Defaults() {
 	assert.Equal(t.T(), -1, f.Uid)
 	assert.Equal(t.T(), -1, f.Gid)
 	assert.False(t.T(), f.ImplicitDirs)
+	assert.True(t.T(), f.SnapshotTime.IsZero())
+	assert.Equal(t.T(), "", f.OverlayLower)
 	assert.False(t.T(), f.IgnoreInterrupts)
 	assert.Equal(t.T(), config.DefaultKernelListCacheTtlSeconds, f.KernelListCacheTtlSeconds)
 
@@ -246,6 +248,28 @@ func (t *FlagsTest) Durations() {
 	assert.Equal(t.T(), 30*time.Second, f.MaxRetrySleep)
 }
 
+func (t *FlagsTest) TestSnapshotTime() {
+	f := parseArgs(t, []string{"--snapshot-time=2024-05-01T12:30:00Z"})
+
+	assert.Equal(t.T(), time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), f.SnapshotTime.UTC())
+	assert.Contains(t.T(), f.MountOptions, "ro")
+}
+
+func (t *FlagsTest) TestSnapshotTime_Now() {
+	before := time.Now()
+
+	f := parseArgs(t, []string{"--snapshot-time=now"})
+
+	assert.False(t.T(), f.SnapshotTime.Before(before))
+	assert.False(t.T(), f.SnapshotTime.After(time.Now()))
+}
+
+func (t *FlagsTest) TestParseSnapshotTime_Invalid() {
+	_, err := parseSnapshotTime("yesterday")
+
+	assert.Error(t.T(), err)
+}
+
 func (t *FlagsTest) Maps() {
 	args := []string{
 		"-o", "rw,nodev",
@@ -332,6 +356,20 @@ func (t *FlagsTest) TestValidateFlagsForValidSequentialReadSizeAndHTTP1ClientPro
 	assert.Equal(t.T(), nil, err)
 }
 
+func (t *FlagsTest) TestValidateFlagsForOverlayLowerAndSnapshotTime() {
+	flags := &flagStorage{
+		SequentialReadSizeMb:                10,
+		ClientProtocol:                      mountpkg.ClientProtocol("http1"),
+		ExperimentalMetadataPrefetchOnMount: config.DefaultExperimentalMetadataPrefetchOnMount,
+		OverlayLower:                        "golden-bucket/datasets",
+		SnapshotTime:                        time.Now(),
+	}
+
+	err := validateFlags(flags)
+
+	assert.EqualError(t.T(), err, "overlay-lower can't be used with snapshot-time")
+}
+
 func (t *FlagsTest) TestValidateFlagsForZeroSequentialReadSizeAndValidClientProtocol() {
 	flags := &flagStorage{
 		SequentialReadSizeMb:                0,
@@ -425,6 +463,7 @@ func (t *FlagsTest) Test_resolveConfigFilePaths() {
 		FilePath: "~/test.txt",
 	}
 	mountConfig.CacheDir = "~/cache-dir"
+	mountConfig.WriteConfig.DirtyDataJournalDir = "~/dirty-data"
 
 	err := resolveConfigFilePaths(mountConfig)
 
@@ -433,6 +472,7 @@ func (t *FlagsTest) Test_resolveConfigFilePaths() {
 	assert.Equal(t.T(), nil, err)
 	assert.Equal(t.T(), filepath.Join(homeDir, "test.txt"), mountConfig.LogConfig.FilePath)
 	assert.EqualValues(t.T(), filepath.Join(homeDir, "cache-dir"), mountConfig.CacheDir)
+	assert.Equal(t.T(), filepath.Join(homeDir, "dirty-data"), mountConfig.WriteConfig.DirtyDataJournalDir)
 }
 
 func (t *FlagsTest) Test_resolveConfigFilePaths_WithoutSettingPaths() {
@@ -443,6 +483,7 @@ func (t *FlagsTest) Test_resolveConfigFilePaths_WithoutSettingPaths() {
 	assert.Equal(t.T(), nil, err)
 	assert.Equal(t.T(), "", mountConfig.LogConfig.FilePath)
 	assert.EqualValues(t.T(), "", mountConfig.CacheDir)
+	assert.Equal(t.T(), "", mountConfig.WriteConfig.DirtyDataJournalDir)
 }
 
 func (t *FlagsTest) Test_KernelListCacheTtlSecs() {
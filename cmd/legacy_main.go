@@ -36,6 +36,8 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/mount"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/perf"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/localdir"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/replay"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
 	"github.com/jacobsa/daemonize"
@@ -117,6 +119,7 @@ func createStorageHandle(flags *flagStorage, mountConfig *config.MountConfig, us
 		ExperimentalEnableJsonRead: flags.ExperimentalEnableJsonRead,
 		GrpcConnPoolSize:           mountConfig.GCSConnection.GRPCConnPoolSize,
 		EnableHNS:                  mountConfig.EnableHNS,
+		TempDir:                    flags.TempDir,
 	}
 	logger.Infof("UserAgent = %s\n", storageClientConfig.UserAgent)
 	storageHandle, err = storage.NewStorageHandle(context.Background(), storageClientConfig)
@@ -143,10 +146,13 @@ func mountWithArgs(
 
 	// Grab the connection.
 	//
-	// Special case: if we're mounting the fake bucket, we don't need an actual
-	// connection.
+	// Special case: if we're mounting the fake bucket, a bucket backed by a
+	// local directory or a replayed trace, we don't need an actual connection,
+	// unless it's the upper layer over a lower bucket.
 	var storageHandle storage.StorageHandle
-	if bucketName != canned.FakeBucketName {
+	_, isLocalBucket := localdir.ParseBucketURL(bucketName)
+	_, isReplayedBucket := replay.ParseBucketURL(bucketName)
+	if (bucketName != canned.FakeBucketName && !isLocalBucket && !isReplayedBucket) || flags.OverlayLower != "" {
 		userAgent := getUserAgent(flags.AppName, getConfigForUserAgent(mountConfig))
 		logger.Info("Creating Storage handle...")
 		storageHandle, err = createStorageHandle(flags, mountConfig, userAgent)
@@ -205,6 +211,26 @@ func populateArgs(c *cli.Context) (
 		err = fmt.Errorf("canonicalizing mount point: %w", err)
 		return
 	}
+
+	// Likewise for the directory of a bucket backed by a local directory.
+	if dir, ok := localdir.ParseBucketURL(bucketName); ok {
+		dir, err = util.GetResolvedPath(dir)
+		if err != nil {
+			err = fmt.Errorf("canonicalizing bucket directory: %w", err)
+			return
+		}
+		bucketName = localdir.URLScheme + dir
+	}
+
+	// And for the trace of a replayed bucket.
+	if trace, ok := replay.ParseBucketURL(bucketName); ok {
+		trace, err = util.GetResolvedPath(trace)
+		if err != nil {
+			err = fmt.Errorf("canonicalizing trace path: %w", err)
+			return
+		}
+		bucketName = replay.URLScheme + trace
+	}
 	return
 }
 
@@ -194,6 +194,19 @@ func (t *MainTest) TestStringifyShouldReturnAllFlagsPassedInMountConfigAsMarshal
 
 	expected := strings.Join([]string{
 		`{"CreateEmptyFile":false`,
+		`"ConflictPolicy":""`,
+		`"EnableParallelUploads":false`,
+		`"ParallelUploadThresholdMB":0`,
+		`"UploadPartSizeMB":0`,
+		`"UploadParallelismPerFile":0`,
+		`"EnableStreamingWrites":false`,
+		`"DirtyDataJournalDir":""`,
+		`"DirtyDataRecovery":""`,
+		`"AutoSyncInterval":0`,
+		`"AutoSyncDirtyMB":0`,
+		`"EnablePartialUpdates":false`,
+		`"PartialUpdateThresholdMB":0`,
+		`"PartialUpdateBlockSizeMB":0`,
 		`"Severity":"TRACE"`,
 		`"Format":""`,
 		`"FilePath":"\"path\"to\"file\""`,
@@ -207,17 +220,33 @@ func (t *MainTest) TestStringifyShouldReturnAllFlagsPassedInMountConfigAsMarshal
 		`"MaxDownloadParallelism":0`,
 		`"ReadRequestSizeMB":0`,
 		`"EnableCrcCheck":false`,
+		`"EnableSparseFile":false`,
+		`"SparseFileChunkSizeMB":0`,
+		`"EvictionPolicy":""`,
+		`"SharedCacheDir":false`,
 		`"CacheDir":""`,
 		`"TtlInSeconds":0`,
 		`"TypeCacheMaxSizeMB":0`,
 		`"StatCacheMaxSizeMB":0`,
+		`"StatCacheEvictionPolicy":""`,
+		`"TypeCacheEvictionPolicy":""`,
 		`"EnableEmptyManagedFolders":false`,
 		`"KernelListCacheTtlSeconds":0`,
 		`"GRPCConnPoolSize":0`,
 		`"AnonymousAccess":false`,
 		`"EnableHNS":true`,
 		`"IgnoreInterrupts":false`,
-		`"DisableParallelDirops":false}`,
+		`"DisableParallelDirops":false`,
+		`"PreservePosixAttributes":false`,
+		`"EnableVersionsDir":false`,
+		`"RenameDirParallelism":0,"Seed":0,"Rules":null`,
+		`"EnableReadAhead":false`,
+		`"ReadAheadChunkSizeMB":0`,
+		`"ReadAheadMaxInFlight":0`,
+		`"ReadAheadMemoryMB":0`,
+		`"BlockCacheMaxSizeMB":0`,
+		`"BlockCacheBlockSizeMB":0`,
+		`"BlockCachePromotionThreshold":0}`,
 	}, ",")
 	assert.Equal(t.T(), expected, actual)
 }
@@ -232,6 +261,19 @@ func (t *MainTest) TestEnableHNSFlagFalse() {
 
 	expected := strings.Join([]string{
 		`{"CreateEmptyFile":false`,
+		`"ConflictPolicy":""`,
+		`"EnableParallelUploads":false`,
+		`"ParallelUploadThresholdMB":0`,
+		`"UploadPartSizeMB":0`,
+		`"UploadParallelismPerFile":0`,
+		`"EnableStreamingWrites":false`,
+		`"DirtyDataJournalDir":""`,
+		`"DirtyDataRecovery":""`,
+		`"AutoSyncInterval":0`,
+		`"AutoSyncDirtyMB":0`,
+		`"EnablePartialUpdates":false`,
+		`"PartialUpdateThresholdMB":0`,
+		`"PartialUpdateBlockSizeMB":0`,
 		`"Severity":""`,
 		`"Format":""`,
 		`"FilePath":""`,
@@ -245,17 +287,33 @@ func (t *MainTest) TestEnableHNSFlagFalse() {
 		`"MaxDownloadParallelism":0`,
 		`"ReadRequestSizeMB":0`,
 		`"EnableCrcCheck":false`,
+		`"EnableSparseFile":false`,
+		`"SparseFileChunkSizeMB":0`,
+		`"EvictionPolicy":""`,
+		`"SharedCacheDir":false`,
 		`"CacheDir":""`,
 		`"TtlInSeconds":0`,
 		`"TypeCacheMaxSizeMB":0`,
 		`"StatCacheMaxSizeMB":0`,
+		`"StatCacheEvictionPolicy":""`,
+		`"TypeCacheEvictionPolicy":""`,
 		`"EnableEmptyManagedFolders":false`,
 		`"KernelListCacheTtlSeconds":0`,
 		`"GRPCConnPoolSize":0`,
 		`"AnonymousAccess":false`,
 		`"EnableHNS":false`,
 		`"IgnoreInterrupts":false`,
-		`"DisableParallelDirops":false}`,
+		`"DisableParallelDirops":false`,
+		`"PreservePosixAttributes":false`,
+		`"EnableVersionsDir":false`,
+		`"RenameDirParallelism":0,"Seed":0,"Rules":null`,
+		`"EnableReadAhead":false`,
+		`"ReadAheadChunkSizeMB":0`,
+		`"ReadAheadMaxInFlight":0`,
+		`"ReadAheadMemoryMB":0`,
+		`"BlockCacheMaxSizeMB":0`,
+		`"BlockCacheBlockSizeMB":0`,
+		`"BlockCachePromotionThreshold":0}`,
 	}, ",")
 	assert.Equal(t.T(), expected, actual)
 }
@@ -289,6 +347,8 @@ func (t *MainTest) TestStringifyShouldReturnAllFlagsPassedInFlagStorageAsMarshal
 		`"Gid":0`,
 		`"ImplicitDirs":false`,
 		`"OnlyDir":""`,
+		`"SnapshotTime":"0001-01-01T00:00:00Z"`,
+		`"OverlayLower":""`,
 		`"RenameDirLimit":0`,
 		`"IgnoreInterrupts":false`,
 		`"CustomEndpoint":null`,
@@ -326,6 +386,7 @@ func (t *MainTest) TestStringifyShouldReturnAllFlagsPassedInFlagStorageAsMarshal
 		`"DebugHTTP":false`,
 		`"DebugInvariants":false`,
 		`"DebugMutex":false`,
+		`"DebugGCSTrace":""`,
 		`"ExperimentalMetadataPrefetchOnMount":""}`,
 	}, ",")
 	assert.Equal(t.T(), expected, actual)
@@ -27,6 +27,7 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/perms"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fsutil"
 	"github.com/jacobsa/timeutil"
@@ -90,16 +91,61 @@ be interacting with the file system.`)
 		return nil, fmt.Errorf("failed to calculate StatCacheMaxSizeMB from stat-cache-ttl=%v, metadata-cache:stat-cache-max-size-mb=%v: %w", flags.StatCacheCapacity, mountConfig.StatCacheMaxSizeMB, err)
 	}
 
+	var parallelUploads gcsx.ParallelUploadConfig
+	if writeConfig := mountConfig.WriteConfig; writeConfig.EnableParallelUploads {
+		parallelUploads = gcsx.ParallelUploadConfig{
+			Threshold:   int64(util.MiBsToBytes(uint64(writeConfig.ParallelUploadThresholdMB))),
+			PartSize:    int64(util.MiBsToBytes(uint64(writeConfig.UploadPartSizeMB))),
+			Parallelism: writeConfig.UploadParallelismPerFile,
+		}
+	}
+
+	var partialUpdates gcsx.PartialUpdateConfig
+	if writeConfig := mountConfig.WriteConfig; writeConfig.EnablePartialUpdates {
+		partialUpdates = gcsx.PartialUpdateConfig{
+			Threshold:   int64(util.MiBsToBytes(uint64(writeConfig.PartialUpdateThresholdMB))),
+			BlockSize:   int64(util.MiBsToBytes(uint64(writeConfig.PartialUpdateBlockSizeMB))),
+			Parallelism: writeConfig.UploadParallelismPerFile,
+		}
+	}
+
+	// The file system is read-only if mounted with "-o ro", as it is for
+	// snapshots.
+	_, readOnly := flags.MountOptions["ro"]
+
+	// One dirty-data journal is shared by the bucket manager, which recovers
+	// what a crash left behind in it, and the file system, which keeps the
+	// contents of files in it.
+	var dirtyJournal *gcsx.DirtyJournal
+	if dir := mountConfig.WriteConfig.DirtyDataJournalDir; dir != "" {
+		dirtyJournal, err = gcsx.NewDirtyJournal(dir, timeutil.RealClock())
+		if err != nil {
+			err = fmt.Errorf("NewDirtyJournal: %w", err)
+			return
+		}
+	}
+
 	bucketCfg := gcsx.BucketConfig{
 		BillingProject:                     flags.BillingProject,
 		OnlyDir:                            flags.OnlyDir,
+		SnapshotTime:                       flags.SnapshotTime,
+		ReadOnly:                           readOnly,
+		OverlayLower:                       flags.OverlayLower,
+		FaultInjection:                     mountConfig.FaultInjectionConfig,
+		GCSTracePath:                       flags.DebugGCSTrace,
 		EgressBandwidthLimitBytesPerSecond: flags.EgressBandwidthLimitBytesPerSecond,
 		OpRateLimitHz:                      flags.OpRateLimitHz,
 		StatCacheMaxSizeMB:                 statCacheMaxSizeMB,
 		StatCacheTTL:                       metadataCacheTTL,
+		StatCacheEvictionPolicy:            mountConfig.MetadataCacheConfig.StatCacheEvictionPolicy,
 		EnableMonitoring:                   flags.StackdriverExportInterval > 0,
 		AppendThreshold:                    1 << 21, // 2 MiB, a total guess.
 		TmpObjectPrefix:                    ".gcsfuse_tmp/",
+		ParallelUploads:                    parallelUploads,
+		PartialUpdates:                     partialUpdates,
+		RenameDirParallelism:               mountConfig.FileSystemConfig.RenameDirParallelism,
+		DirtyJournal:                       dirtyJournal,
+		UploadDirtyData:                    mountConfig.WriteConfig.DirtyDataRecovery == config.UploadDirtyDataRecovery,
 		DebugGCS:                           flags.DebugGCS,
 	}
 	bm := gcsx.NewBucketManager(bucketCfg, storageHandle)
@@ -108,6 +154,7 @@ be interacting with the file system.`)
 	serverCfg := &fs.ServerConfig{
 		CacheClock:                 timeutil.RealClock(),
 		BucketManager:              bm,
+		DirtyJournal:               dirtyJournal,
 		BucketName:                 bucketName,
 		LocalFileCache:             flags.LocalFileCache,
 		DebugFS:                    flags.DebugFS,
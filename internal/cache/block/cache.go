@@ -0,0 +1,580 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package block provides a cache of the contents of objects in blocks of a
+// fixed size, kept in memory and optionally demoted to the file cache on disk.
+package block
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file"
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/monitor"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+)
+
+// A fetch of a block from GCS, which all concurrent readers of the block wait
+// for.
+type fetch struct {
+	// Closed when the fetch is finished, after which data and err are set.
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// A change to the disk tier: writing out the contents of a block, or removing
+// it if data is nil.
+type diskOp struct {
+	key  string
+	data []byte
+}
+
+// A block in the disk tier, as an entry of the file cache.
+type diskBlock struct {
+	c    *Cache
+	key  string
+	size uint64
+}
+
+func (b *diskBlock) Size() uint64 {
+	return b.size
+}
+
+// Evict removes the block from the disk tier, unless it has been promoted
+// since. Called by the file cache, which may hold its lock.
+func (b *diskBlock) Evict() {
+	b.c.mu.Lock()
+	if b.c.policy.Tier(b.key) == DiskTier {
+		b.c.policy.Remove(b.key)
+	}
+	b.c.mu.Unlock()
+
+	if err := os.Remove(b.c.diskPath(b.key)); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Block cache: while removing %q from disk: %v", b.key, err)
+	}
+}
+
+// Cache is a cache of the contents of objects, in blocks of a fixed size
+// keyed by bucket, object name, generation and block index. Blocks are
+// fetched from GCS when first read, and kept in a memory tier and optionally
+// a disk tier as decided by a TieredPolicy: blocks evicted from memory are
+// demoted to disk, and blocks read repeatedly from disk are promoted back to
+// memory. A block is fetched once, however many readers miss it at the same
+// time.
+//
+// The disk tier is the file cache: demoted blocks are entries of it, kept in
+// a directory of their own under the file cache directory, and share its max
+// size and eviction policy with the files in cache.
+//
+// Safe for concurrent access.
+type Cache struct {
+	blockSize int64
+
+	// The file cache holding the disk tier, or nil if there's none, the
+	// directory in which the blocks are kept, and the file locked for as long
+	// as it is in use. See NewTieredCache.
+	fileCache *file.CacheHandler
+	dir       string
+	lockFile  *os.File
+
+	// The file cache calls back into the cache when evicting blocks, with its
+	// lock held, so mu is never held while calling the file cache.
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	policy *TieredPolicy
+
+	// The contents of the blocks in the memory tier, and of those being
+	// demoted until they have been written to the disk tier.
+	//
+	// GUARDED_BY(mu)
+	memory map[string][]byte
+
+	// The fetches in progress, by block key.
+	//
+	// GUARDED_BY(mu)
+	fetches map[string]*fetch
+
+	// Tracks the goroutines fetching blocks and changing the disk tier.
+	wg sync.WaitGroup
+}
+
+// NewCache creates an in-memory cache of blocks of blockSize bytes taking up
+// at most maxSize bytes, which must be at least blockSize.
+func NewCache(maxSize uint64, blockSize int64) *Cache {
+	return newCache(NewMemoryPolicy(maxSize), blockSize)
+}
+
+// The directory under the file cache directory holding the disk tiers of the
+// caches using it, which can't collide with the files in cache as bucket names
+// can't start with a dot.
+const diskTiersDir = ".blocks"
+
+// The suffix of the names of the files locked by the caches whose disk tiers
+// are in the directories with the same names less the suffix.
+const lockFileSuffix = ".lock"
+
+// NewTieredCache creates a cache of blocks of blockSize bytes with a memory
+// tier of at most memoryMaxSize bytes, which must be at least blockSize, and
+// with the file cache as its disk tier. Blocks are promoted from the disk tier
+// on their promotionThreshold'th read from it.
+//
+// The blocks in the disk tier don't outlive the cache, and aren't recorded in
+// the file cache's index. The file cache directory may be used by other
+// caches, e.g. of other mounts, each of which keeps its blocks in a directory
+// of its own. A cache locks a file alongside its directory for as long as it
+// is in use, so that the directories left behind by caches that weren't
+// destroyed, e.g. because of a crash, can be told apart and removed.
+func NewTieredCache(
+	memoryMaxSize uint64,
+	fileCache *file.CacheHandler,
+	dirPerm os.FileMode,
+	promotionThreshold int,
+	blockSize int64) (*Cache, error) {
+	parentDir := filepath.Join(fileCache.CacheDir(), diskTiersDir)
+	if err := os.MkdirAll(parentDir, dirPerm); err != nil {
+		return nil, fmt.Errorf("while creating the disk tier: %w", err)
+	}
+	removeAbandonedDirs(parentDir)
+
+	lockFile, err := createLockFile(parentDir)
+	if err != nil {
+		return nil, fmt.Errorf("while locking the disk tier: %w", err)
+	}
+	dir := strings.TrimSuffix(lockFile.Name(), lockFileSuffix)
+	if err := os.Mkdir(dir, dirPerm); err != nil {
+		os.Remove(lockFile.Name())
+		lockFile.Close()
+		return nil, fmt.Errorf("while creating the disk tier: %w", err)
+	}
+
+	c := newCache(NewTieredPolicy(memoryMaxSize, promotionThreshold), blockSize)
+	c.fileCache = fileCache
+	c.dir = dir
+	c.lockFile = lockFile
+	return c, nil
+}
+
+// Create and lock a new lock file in parentDir, making sure that it hasn't
+// been removed by removeAbandonedDirs before we locked it.
+func createLockFile(parentDir string) (*os.File, error) {
+	for {
+		f, err := os.CreateTemp(parentDir, "*"+lockFileSuffix)
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := cacheutil.TryLockFile(f)
+		if err == nil && !locked {
+			err = fmt.Errorf("%q is locked already", f.Name())
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			f.Close()
+			return nil, err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if linked, err := os.Stat(f.Name()); err == nil && os.SameFile(fi, linked) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// Remove the directories, and the lock files, of the disk tiers under
+// parentDir whose lock files aren't locked. Failures are logged, as they
+// needn't stop us using a disk tier of our own.
+func removeAbandonedDirs(parentDir string) {
+	lockFiles, err := filepath.Glob(filepath.Join(parentDir, "*"+lockFileSuffix))
+	if err != nil {
+		logger.Warnf("Block cache: while looking for abandoned disk tiers: %v", err)
+		return
+	}
+
+	for _, name := range lockFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+
+		locked, err := cacheutil.TryLockFile(f)
+		if err == nil && locked {
+			dir := strings.TrimSuffix(name, lockFileSuffix)
+			if err = os.RemoveAll(dir); err == nil {
+				err = os.Remove(name)
+			}
+			if err != nil {
+				logger.Warnf("Block cache: while removing the abandoned disk tier %q: %v", dir, err)
+			}
+		}
+		f.Close()
+	}
+}
+
+func newCache(policy *TieredPolicy, blockSize int64) *Cache {
+	return &Cache{
+		blockSize: blockSize,
+		policy:    policy,
+		memory:    make(map[string][]byte),
+		fetches:   make(map[string]*fetch),
+	}
+}
+
+// Destroy erases the blocks in the disk tier, if any, from the file cache once
+// the blocks being written to it have been, and removes their directory. The
+// cache mustn't be used afterwards.
+func (c *Cache) Destroy() {
+	c.wg.Wait()
+	if c.fileCache == nil {
+		return
+	}
+
+	c.mu.Lock()
+	var keys []string
+	for e := c.policy.disk.entries.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*policyEntry).key)
+	}
+	c.mu.Unlock()
+	for _, key := range keys {
+		c.fileCache.EraseEntry(key)
+	}
+
+	// Leave the lock file if the directory can't be removed, so that it is
+	// removed along with it once we're gone.
+	if err := os.RemoveAll(c.dir); err != nil {
+		logger.Warnf("Block cache: while removing the disk tier: %v", err)
+	} else {
+		os.Remove(c.lockFile.Name())
+	}
+	c.lockFile.Close()
+}
+
+// BlockSize returns the size of the blocks of the cache.
+func (c *Cache) BlockSize() int64 {
+	return c.blockSize
+}
+
+// Bucket names can't contain slashes, and the generation and index are
+// numbers, so this can't be ambiguous.
+func blockKey(bucketName string, o *gcs.MinObject, index int64) string {
+	return fmt.Sprintf("%s/%d/%d/%s", bucketName, o.Generation, index, o.Name)
+}
+
+// Read reads len(p) bytes of the supplied generation of an object at offset
+// into p, from the cached blocks covering them, fetching the missing blocks
+// from the bucket. It returns fewer than len(p) bytes only when the end of the
+// object comes first.
+func (c *Cache) Read(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	o *gcs.MinObject,
+	p []byte,
+	offset int64) (n int, err error) {
+	cacheHit := true
+	for len(p) > 0 && offset < int64(o.Size) {
+		index := offset / c.blockSize
+
+		var data []byte
+		var hit bool
+		data, hit, err = c.block(ctx, bucket, o, index)
+		if err != nil {
+			err = fmt.Errorf("while reading block %d of %q: %w", index, o.Name, err)
+			return
+		}
+		cacheHit = cacheHit && hit
+
+		m := copy(p, data[offset-index*c.blockSize:])
+		n += m
+		p = p[m:]
+		offset += int64(m)
+	}
+
+	monitor.CaptureBlockCacheMetrics(ctx, n, cacheHit)
+	return
+}
+
+// Return the contents of a block, fetching it if it isn't cached, or waiting
+// for its fetch if one is in progress.
+func (c *Cache) block(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	o *gcs.MinObject,
+	index int64) (data []byte, hit bool, err error) {
+	key := blockKey(bucket.Name(), o, index)
+	if data, ok := c.lookUp(key); ok {
+		return data, true, nil
+	}
+
+	c.mu.Lock()
+	f, ok := c.fetches[key]
+	if !ok {
+		// The block may have been fetched since the look-up above; fetches are
+		// forgotten only after admitting their blocks.
+		if data, ok := c.memory[key]; ok {
+			c.mu.Unlock()
+			return data, true, nil
+		}
+
+		f = &fetch{done: make(chan struct{})}
+		c.fetches[key] = f
+
+		// The fetch isn't bound to the context of any one reader, so that others
+		// waiting for it aren't failed by its cancellation.
+		c.wg.Add(1)
+		go c.fetch(key, f, bucket, o, index)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+
+	data, err = f.data, f.err
+	return
+}
+
+// Return the contents of a cached block from whichever tier it is in,
+// promoting it to the memory tier if the policy says so.
+func (c *Cache) lookUp(key string) (data []byte, ok bool) {
+	c.mu.Lock()
+	tier, promote := c.policy.Access(key)
+	data, ok = c.memory[key]
+	c.mu.Unlock()
+
+	if ok || tier != DiskTier {
+		return
+	}
+
+	// Looking the block up in the file cache also counts as a use for its
+	// eviction policy.
+	var err error
+	if !c.fileCache.LookUpEntry(key) {
+		err = errors.New("evicted from the file cache")
+	} else {
+		data, err = os.ReadFile(c.diskPath(key))
+	}
+	if err != nil {
+		// The block is being demoted again, or its file has been lost; fetch it
+		// anew.
+		logger.Tracef("Block cache: while reading %q from disk: %v", key, err)
+		c.mu.Lock()
+		lost := c.policy.Tier(key) == DiskTier
+		if lost {
+			c.policy.Remove(key)
+		}
+		c.mu.Unlock()
+
+		if lost {
+			c.fileCache.EraseEntry(key)
+		}
+		return nil, false
+	}
+
+	if promote {
+		c.mu.Lock()
+		var ops []diskOp
+		if moves := c.policy.Promote(key); len(moves) > 0 {
+			c.memory[key] = data
+			ops = c.applyLocked(moves)
+		}
+		c.mu.Unlock()
+
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.runDiskOps(ops)
+		}()
+	}
+
+	return data, true
+}
+
+func (c *Cache) fetch(
+	key string,
+	f *fetch,
+	bucket gcs.Bucket,
+	o *gcs.MinObject,
+	index int64) {
+	defer c.wg.Done()
+
+	f.data, f.err = c.readBlock(bucket, o, index)
+
+	var ops []diskOp
+	c.mu.Lock()
+	if f.err == nil {
+		ops = c.admitLocked(key, f.data)
+	}
+	delete(c.fetches, key)
+	c.mu.Unlock()
+	close(f.done)
+
+	c.runDiskOps(ops)
+}
+
+// Admit a fetched block, returning the changes to make to the disk tier.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *Cache) admitLocked(key string, data []byte) (ops []diskOp) {
+	tier, moves := c.policy.Admit(key, uint64(len(data)))
+	if tier == NotCached {
+		return nil
+	}
+
+	// Blocks admitted straight to the disk tier are kept in memory until
+	// written out, like demoted ones.
+	c.memory[key] = data
+	ops = c.applyLocked(moves)
+	if tier == DiskTier {
+		ops = append(ops, diskOp{key: key, data: data})
+	}
+	return
+}
+
+// Carry out the moves decided by the policy on the memory tier, returning the
+// changes to make to the disk tier.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *Cache) applyLocked(moves []Move) (ops []diskOp) {
+	for _, m := range moves {
+		switch {
+		case m.To == DiskTier:
+			// Keep the contents in memory until written out.
+			ops = append(ops, diskOp{key: m.Key, data: c.memory[m.Key]})
+
+		case m.From == MemoryTier:
+			delete(c.memory, m.Key)
+
+		case m.From == DiskTier:
+			ops = append(ops, diskOp{key: m.Key})
+		}
+	}
+	return
+}
+
+func (c *Cache) runDiskOps(ops []diskOp) {
+	for _, op := range ops {
+		if op.data == nil {
+			// Erasing the block's entry from the file cache removes its file.
+			c.fileCache.EraseEntry(op.key)
+			continue
+		}
+
+		path := c.diskPath(op.key)
+		err := c.writeFile(path, op.data)
+		if err == nil {
+			err = c.fileCache.InsertEntry(op.key, &diskBlock{c: c, key: op.key, size: uint64(len(op.data))})
+		}
+		if err != nil {
+			logger.Warnf("Block cache: while writing %q to disk: %v", op.key, err)
+		}
+
+		c.mu.Lock()
+		tier := c.policy.Tier(op.key)
+		if err != nil && tier == DiskTier {
+			c.policy.Remove(op.key)
+			tier = NotCached
+		}
+		if tier != MemoryTier {
+			delete(c.memory, op.key)
+		}
+		c.mu.Unlock()
+
+		// The block may have been evicted from the disk tier while being written.
+		if tier == NotCached {
+			c.fileCache.EraseEntry(op.key)
+			os.Remove(path)
+		}
+	}
+}
+
+func (c *Cache) diskPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Write a file atomically, so that readers never see part of it.
+func (c *Cache) writeFile(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(c.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (c *Cache) readBlock(
+	bucket gcs.Bucket,
+	o *gcs.MinObject,
+	index int64) (data []byte, err error) {
+	start := index * c.blockSize
+	limit := min(start+c.blockSize, int64(o.Size))
+
+	ctx := context.Background()
+	monitor.CaptureGCSReadMetrics(ctx, util.Random, limit-start)
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       o.Name,
+			Generation: o.Generation,
+			Range: &gcs.ByteRange{
+				Start: uint64(start),
+				Limit: uint64(limit),
+			},
+			ReadCompressed: o.HasContentEncodingGzip(),
+		})
+	if err != nil {
+		err = fmt.Errorf("NewReader: %w", err)
+		return
+	}
+	defer rc.Close()
+
+	data = make([]byte, limit-start)
+	if _, err = io.ReadFull(rc, data); err != nil {
+		err = fmt.Errorf("ReadFull: %w", err)
+		data = nil
+	}
+	return
+}
@@ -0,0 +1,359 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package block
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const testBlockSize = 10
+
+// A bucket which counts the calls to NewReader, makes them wait for the gate
+// to open, and fails them with err if set.
+type countingBucket struct {
+	gcs.Bucket
+
+	gate chan struct{}
+	err  error
+
+	mu    sync.Mutex
+	reads int
+}
+
+func (b *countingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (io.ReadCloser, error) {
+	b.mu.Lock()
+	b.reads++
+	b.mu.Unlock()
+
+	<-b.gate
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.Bucket.NewReader(ctx, req)
+}
+
+func (b *countingBucket) readCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reads
+}
+
+type cacheTest struct {
+	suite.Suite
+	ctx    context.Context
+	bucket *countingBucket
+	object *gcs.MinObject
+	cache  *Cache
+
+	// The disk tier of the cache, if tiered.
+	fileCache *file.CacheHandler
+}
+
+func TestCacheSuite(t *testing.T) {
+	suite.Run(t, new(cacheTest))
+}
+
+func (t *cacheTest) SetupTest() {
+	t.ctx = context.Background()
+	t.bucket = &countingBucket{
+		Bucket: fake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+		gate:   make(chan struct{}),
+	}
+	close(t.bucket.gate)
+	t.object = t.createObject("foo", "0123456789abcdefghijklmnopqrstuvwxy")
+	t.cache = NewCache(3*testBlockSize, testBlockSize)
+}
+
+func (t *cacheTest) createObject(name string, contents string) *gcs.MinObject {
+	o, err := storageutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	require.NoError(t.T(), err)
+	return storageutil.ConvertObjToMinObject(o)
+}
+
+func (t *cacheTest) read(o *gcs.MinObject, offset int64, size int) string {
+	p := make([]byte, size)
+	n, err := t.cache.Read(t.ctx, t.bucket, o, p, offset)
+	require.NoError(t.T(), err)
+	return string(p[:n])
+}
+
+func (t *cacheTest) TestReadFetchesMissingBlocks() {
+	assert.Equal(t.T(), "56789abcde", t.read(t.object, 5, 10))
+	assert.Equal(t.T(), 2, t.bucket.readCount())
+
+	assert.Equal(t.T(), "789abc", t.read(t.object, 7, 6))
+	assert.Equal(t.T(), "fghij", t.read(t.object, 15, 5))
+	assert.Equal(t.T(), 2, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestReadStopsAtEndOfObject() {
+	assert.Equal(t.T(), "uvwxy", t.read(t.object, 30, 10))
+	assert.Equal(t.T(), 1, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestBlocksAreKeyedByGeneration() {
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	newer := t.createObject("foo", "ABCDEFGHIJ")
+
+	assert.Equal(t.T(), "ABCDE", t.read(newer, 0, 5))
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), 2, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestBlocksAreKeyedByObject() {
+	other := t.createObject("bar", "ABCDEFGHIJ")
+
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), "ABCDE", t.read(other, 0, 5))
+	assert.Equal(t.T(), 2, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestEvictsLeastRecentlyUsedBlocks() {
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+	t.read(t.object, 0, 1)
+	require.Equal(t.T(), 3, t.bucket.readCount())
+
+	// Evicts the block at 10.
+	t.read(t.object, 30, 1)
+	t.read(t.object, 0, 1)
+	assert.Equal(t.T(), 4, t.bucket.readCount())
+
+	t.read(t.object, 10, 1)
+	assert.Equal(t.T(), 5, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestCoalescesConcurrentMisses() {
+	t.bucket.gate = make(chan struct{})
+	const readers = 8
+	var wg sync.WaitGroup
+	results := make([]string, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := make([]byte, 3)
+			n, err := t.cache.Read(t.ctx, t.bucket, t.object, p, int64(i))
+			assert.NoError(t.T(), err)
+			results[i] = string(p[:n])
+		}(i)
+	}
+
+	// Give the readers time to miss the block while it's being fetched.
+	time.Sleep(10 * time.Millisecond)
+	close(t.bucket.gate)
+	wg.Wait()
+
+	assert.Equal(t.T(), 1, t.bucket.readCount())
+	for i, r := range results {
+		assert.Equal(t.T(), "0123456789"[i:i+3], r)
+	}
+}
+
+func (t *cacheTest) TestFailedFetchIsNotCached() {
+	t.bucket.err = errors.New("taco")
+	p := make([]byte, 5)
+
+	_, err := t.cache.Read(t.ctx, t.bucket, t.object, p, 0)
+
+	assert.ErrorContains(t.T(), err, "taco")
+	t.bucket.err = nil
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), 2, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestCancelledWhileWaiting() {
+	t.bucket.gate = make(chan struct{})
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+	p := make([]byte, 5)
+
+	_, err := t.cache.Read(ctx, t.bucket, t.object, p, 0)
+
+	assert.ErrorIs(t.T(), err, context.Canceled)
+
+	// The fetch carries on for later readers.
+	close(t.bucket.gate)
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), 1, t.bucket.readCount())
+}
+
+// An entry of the file cache other than a block, taking up size bytes.
+type otherEntry uint64
+
+func (e otherEntry) Size() uint64 { return uint64(e) }
+
+func (e otherEntry) Evict() {}
+
+func (t *cacheTest) newTieredCache(fileCache *file.CacheHandler) *Cache {
+	c, err := NewTieredCache(2*testBlockSize, fileCache, 0700, 2, testBlockSize)
+	require.NoError(t.T(), err)
+	return c
+}
+
+// Use a tiered cache whose disk tier is a file cache with room for three
+// blocks, returning the directory holding the blocks.
+func (t *cacheTest) useTieredCache() string {
+	t.fileCache = file.NewCacheHandler(lru.NewCache(3*testBlockSize), nil, t.T().TempDir(), 0600, 0700, nil, nil)
+	t.cache = t.newTieredCache(t.fileCache)
+	return t.cache.dir
+}
+
+func (t *cacheTest) diskFiles(dir string) (names []string) {
+	t.cache.wg.Wait()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t.T(), err)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return
+}
+
+func (t *cacheTest) key(index int64) string {
+	return blockKey(t.bucket.Name(), t.object, index)
+}
+
+func (t *cacheTest) TestNewTieredCacheKeepsOtherDiskTiers() {
+	dir := t.useTieredCache()
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+	files := t.diskFiles(dir)
+
+	other := t.newTieredCache(t.fileCache)
+
+	assert.NotEqual(t.T(), dir, other.dir)
+	assert.Equal(t.T(), filepath.Dir(dir), filepath.Dir(other.dir))
+	assert.Empty(t.T(), t.diskFiles(other.dir))
+	assert.Equal(t.T(), files, t.diskFiles(dir))
+}
+
+func (t *cacheTest) TestNewTieredCacheRemovesAbandonedDiskTiers() {
+	t.useTieredCache()
+	abandoned := t.newTieredCache(t.fileCache)
+	require.NoError(t.T(), os.WriteFile(filepath.Join(abandoned.dir, "stale"), []byte("taco"), 0600))
+	// As if the process using it had died.
+	require.NoError(t.T(), abandoned.lockFile.Close())
+
+	c := t.newTieredCache(t.fileCache)
+
+	assert.NoDirExists(t.T(), abandoned.dir)
+	assert.NoFileExists(t.T(), abandoned.lockFile.Name())
+	assert.DirExists(t.T(), c.dir)
+	assert.FileExists(t.T(), c.lockFile.Name())
+}
+
+func (t *cacheTest) TestDestroyRemovesDiskTier() {
+	dir := t.useTieredCache()
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+	require.NotEmpty(t.T(), t.diskFiles(dir))
+
+	t.cache.Destroy()
+
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), entries)
+	assert.False(t.T(), t.fileCache.LookUpEntry(t.key(0)))
+}
+
+func (t *cacheTest) TestDemotesToFileCache() {
+	dir := t.useTieredCache()
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+
+	assert.Equal(t.T(), filepath.Join(t.fileCache.CacheDir(), diskTiersDir), filepath.Dir(dir))
+	assert.Equal(t.T(), []string{filepath.Base(t.cache.diskPath(t.key(0)))}, t.diskFiles(dir))
+	assert.Equal(t.T(), DiskTier, t.cache.policy.Tier(t.key(0)))
+	assert.True(t.T(), t.fileCache.LookUpEntry(t.key(0)))
+	assert.NotContains(t.T(), t.cache.memory, t.key(0))
+
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), 3, t.bucket.readCount())
+}
+
+func (t *cacheTest) TestPromotesFromFileCache() {
+	dir := t.useTieredCache()
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+	t.diskFiles(dir)
+
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), "56789", t.read(t.object, 5, 5))
+
+	assert.Equal(t.T(), MemoryTier, t.cache.policy.Tier(t.key(0)))
+	assert.Equal(t.T(), DiskTier, t.cache.policy.Tier(t.key(1)))
+	assert.Equal(t.T(), []string{filepath.Base(t.cache.diskPath(t.key(1)))}, t.diskFiles(dir))
+	assert.False(t.T(), t.fileCache.LookUpEntry(t.key(0)))
+	assert.True(t.T(), t.fileCache.LookUpEntry(t.key(1)))
+	assert.Equal(t.T(), "abcde", t.read(t.object, 10, 5))
+	assert.Equal(t.T(), 3, t.bucket.readCount())
+	t.cache.policy.CheckInvariants()
+}
+
+func (t *cacheTest) TestFileCacheEvictsDemotedBlocks() {
+	dir := t.useTieredCache()
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+	require.NotEmpty(t.T(), t.diskFiles(dir))
+
+	// As if a file had been cached.
+	require.NoError(t.T(), t.fileCache.InsertEntry("file", otherEntry(3*testBlockSize)))
+
+	assert.Empty(t.T(), t.diskFiles(dir))
+	assert.Equal(t.T(), NotCached, t.cache.policy.Tier(t.key(0)))
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+	assert.Equal(t.T(), 4, t.bucket.readCount())
+	t.cache.policy.CheckInvariants()
+}
+
+func (t *cacheTest) TestLostDiskBlockIsFetchedAgain() {
+	dir := t.useTieredCache()
+	t.read(t.object, 0, 1)
+	t.read(t.object, 10, 1)
+	t.read(t.object, 20, 1)
+	for _, name := range t.diskFiles(dir) {
+		require.NoError(t.T(), os.Remove(filepath.Join(dir, name)))
+	}
+
+	assert.Equal(t.T(), "01234", t.read(t.object, 0, 5))
+
+	assert.Equal(t.T(), 4, t.bucket.readCount())
+	assert.Equal(t.T(), MemoryTier, t.cache.policy.Tier(t.key(0)))
+}
@@ -0,0 +1,257 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package block
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+)
+
+// Tier is where a cached block is kept.
+type Tier int
+
+const (
+	// NotCached is the tier of blocks which aren't cached.
+	NotCached Tier = iota
+	MemoryTier
+	DiskTier
+)
+
+func (t Tier) String() string {
+	switch t {
+	case NotCached:
+		return "not-cached"
+	case MemoryTier:
+		return "memory"
+	case DiskTier:
+		return "disk"
+	}
+	return fmt.Sprintf("Tier(%d)", int(t))
+}
+
+// Move is a change of the tier of a block decided by the policy, which the
+// cache carries out on the storage of the block.
+type Move struct {
+	Key  string
+	From Tier
+	To   Tier
+}
+
+type policyEntry struct {
+	key  string
+	size uint64
+	tier Tier
+
+	// The number of reads of the block from the disk tier since it was last
+	// demoted.
+	diskHits int
+}
+
+type policyTier struct {
+	maxSize uint64
+	size    uint64
+
+	// The entries in the tier, with the least recently used at the back.
+	entries list.List
+}
+
+// TieredPolicy decides which blocks are kept in a memory tier and a disk tier,
+// and accounts for the size of each. Fetched blocks are admitted to the memory
+// tier, from which blocks are evicted least recently used first and demoted
+// to the disk tier. A block read promotionThreshold times from the disk tier is
+// promoted back to the memory tier.
+//
+// The disk tier is part of a cache on disk, the file cache, which bounds its
+// size and evicts blocks from it along with its other entries, whereupon they
+// are removed from the policy.
+//
+// Not safe for concurrent access.
+type TieredPolicy struct {
+	promotionThreshold int
+
+	memory policyTier
+	disk   policyTier
+
+	// INVARIANT: For each k, v: v.Value.(*policyEntry).key == k
+	// INVARIANT: Contains all and only the elements of memory and disk entries
+	index map[string]*list.Element
+}
+
+// NewMemoryPolicy creates a policy keeping blocks in a memory tier of maxSize
+// bytes only, dropping those evicted from it.
+func NewMemoryPolicy(maxSize uint64) *TieredPolicy {
+	p := &TieredPolicy{index: make(map[string]*list.Element)}
+	p.memory.maxSize = maxSize
+	return p
+}
+
+// NewTieredPolicy creates a policy for a memory tier of memoryMaxSize bytes and
+// a disk tier, promoting blocks on their promotionThreshold'th read from it.
+func NewTieredPolicy(memoryMaxSize uint64, promotionThreshold int) *TieredPolicy {
+	p := NewMemoryPolicy(memoryMaxSize)
+	p.promotionThreshold = promotionThreshold
+
+	// Sized by the cache on disk instead.
+	p.disk.maxSize = math.MaxUint64
+	return p
+}
+
+func (p *TieredPolicy) CheckInvariants() {
+	n := 0
+	for _, t := range []*policyTier{&p.memory, &p.disk} {
+		var size uint64
+		for e := t.entries.Front(); e != nil; e = e.Next() {
+			pe := e.Value.(*policyEntry)
+			if p.tier(t) != pe.tier {
+				panic(fmt.Sprintf("Entry %q in the %v tier thinks it's in %v", pe.key, p.tier(t), pe.tier))
+			}
+			if p.index[pe.key] != e {
+				panic(fmt.Sprintf("Entry %q isn't indexed", pe.key))
+			}
+			size += pe.size
+			n++
+		}
+		if size != t.size {
+			panic(fmt.Sprintf("Size of the %v tier is %d, not %d", p.tier(t), t.size, size))
+		}
+		if t.size > t.maxSize {
+			panic(fmt.Sprintf("Size of the %v tier is %d > %d", p.tier(t), t.size, t.maxSize))
+		}
+	}
+	if n != len(p.index) {
+		panic(fmt.Sprintf("Index has %d entries, tiers %d", len(p.index), n))
+	}
+}
+
+func (p *TieredPolicy) tier(t *policyTier) Tier {
+	if t == &p.memory {
+		return MemoryTier
+	}
+	return DiskTier
+}
+
+func (p *TieredPolicy) tierState(t Tier) *policyTier {
+	if t == MemoryTier {
+		return &p.memory
+	}
+	return &p.disk
+}
+
+// Tier returns the tier of the block with the given key.
+func (p *TieredPolicy) Tier(key string) Tier {
+	if e, ok := p.index[key]; ok {
+		return e.Value.(*policyEntry).tier
+	}
+	return NotCached
+}
+
+// Size returns the total size of the blocks in a tier.
+func (p *TieredPolicy) Size(t Tier) uint64 {
+	return p.tierState(t).size
+}
+
+// Access records a read of the block with the given key, returning its tier
+// and whether it should now be promoted from the disk tier.
+func (p *TieredPolicy) Access(key string) (tier Tier, promote bool) {
+	e, ok := p.index[key]
+	if !ok {
+		return NotCached, false
+	}
+
+	pe := e.Value.(*policyEntry)
+	p.tierState(pe.tier).entries.MoveToFront(e)
+	if pe.tier == DiskTier {
+		pe.diskHits++
+		promote = pe.diskHits >= p.promotionThreshold && pe.size <= p.memory.maxSize
+	}
+	return pe.tier, promote
+}
+
+// Admit a block of the given size fetched from GCS. It goes to the memory
+// tier, or straight to the disk tier if it's too large for the memory tier.
+// Returns the tier it was admitted to, NotCached if it fits in neither, and
+// the moves made to make room for it.
+func (p *TieredPolicy) Admit(key string, size uint64) (tier Tier, moves []Move) {
+	if p.Tier(key) != NotCached {
+		p.Remove(key)
+	}
+
+	pe := &policyEntry{key: key, size: size}
+	switch {
+	case size <= p.memory.maxSize:
+		pe.tier = MemoryTier
+	case size <= p.disk.maxSize:
+		pe.tier = DiskTier
+	default:
+		return NotCached, nil
+	}
+
+	moves = p.insert(pe, moves)
+	return pe.tier, moves
+}
+
+// Promote the block with the given key from the disk tier to the memory
+// tier, returning the moves made, starting with the promotion itself. Returns
+// no moves if the block isn't in the disk tier.
+func (p *TieredPolicy) Promote(key string) (moves []Move) {
+	if p.Tier(key) != DiskTier || p.index[key].Value.(*policyEntry).size > p.memory.maxSize {
+		return nil
+	}
+
+	pe := p.remove(key)
+	pe.tier = MemoryTier
+	moves = append(moves, Move{Key: key, From: DiskTier, To: MemoryTier})
+	return p.insert(pe, moves)
+}
+
+// Remove forgets the block with the given key, for instance when it has been
+// evicted from the disk tier or its storage has been lost.
+func (p *TieredPolicy) Remove(key string) {
+	if _, ok := p.index[key]; ok {
+		p.remove(key)
+	}
+}
+
+func (p *TieredPolicy) remove(key string) *policyEntry {
+	e := p.index[key]
+	pe := e.Value.(*policyEntry)
+	t := p.tierState(pe.tier)
+	t.entries.Remove(e)
+	t.size -= pe.size
+	delete(p.index, key)
+	return pe
+}
+
+// Insert an entry which fits in its tier, evicting the least recently used
+// entries of the tier to make room, and append the moves made.
+func (p *TieredPolicy) insert(pe *policyEntry, moves []Move) []Move {
+	t := p.tierState(pe.tier)
+	for t.size+pe.size > t.maxSize {
+		victim := p.remove(t.entries.Back().Value.(*policyEntry).key)
+		if victim.tier == MemoryTier && victim.size <= p.disk.maxSize {
+			victim.tier = DiskTier
+			victim.diskHits = 0
+			moves = append(moves, Move{Key: victim.key, From: MemoryTier, To: DiskTier})
+			moves = p.insert(victim, moves)
+			continue
+		}
+		moves = append(moves, Move{Key: victim.key, From: victim.tier, To: NotCached})
+	}
+
+	p.index[pe.key] = t.entries.PushFront(pe)
+	t.size += pe.size
+	return moves
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type policyTest struct {
+	suite.Suite
+	p *TieredPolicy
+}
+
+func TestPolicySuite(t *testing.T) {
+	suite.Run(t, new(policyTest))
+}
+
+func (t *policyTest) SetupTest() {
+	// Room for two blocks of 10 in memory.
+	t.p = NewTieredPolicy(20, 2)
+}
+
+func (t *policyTest) TearDownTest() {
+	t.p.CheckInvariants()
+}
+
+func (t *policyTest) admit(key string) []Move {
+	tier, moves := t.p.Admit(key, 10)
+	require.Equal(t.T(), MemoryTier, tier)
+	return moves
+}
+
+func (t *policyTest) TestAdmitToMemory() {
+	assert.Empty(t.T(), t.admit("a"))
+	assert.Empty(t.T(), t.admit("b"))
+
+	assert.Equal(t.T(), MemoryTier, t.p.Tier("a"))
+	assert.Equal(t.T(), uint64(20), t.p.Size(MemoryTier))
+	assert.Equal(t.T(), uint64(0), t.p.Size(DiskTier))
+}
+
+func (t *policyTest) TestDemotesLeastRecentlyUsed() {
+	t.admit("a")
+	t.admit("b")
+	tier, _ := t.p.Access("a")
+	require.Equal(t.T(), MemoryTier, tier)
+
+	moves := t.admit("c")
+
+	assert.Equal(t.T(), []Move{{Key: "b", From: MemoryTier, To: DiskTier}}, moves)
+	assert.Equal(t.T(), DiskTier, t.p.Tier("b"))
+	assert.Equal(t.T(), uint64(20), t.p.Size(MemoryTier))
+	assert.Equal(t.T(), uint64(10), t.p.Size(DiskTier))
+}
+
+func (t *policyTest) TestLeavesEvictionFromDiskToFileCache() {
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		t.admit(key)
+	}
+
+	moves := t.admit("f")
+
+	assert.Equal(t.T(), []Move{{Key: "d", From: MemoryTier, To: DiskTier}}, moves)
+	assert.Equal(t.T(), DiskTier, t.p.Tier("a"))
+	assert.Equal(t.T(), uint64(40), t.p.Size(DiskTier))
+
+	// As when the file cache evicts it.
+	t.p.Remove("a")
+	assert.Equal(t.T(), NotCached, t.p.Tier("a"))
+	assert.Equal(t.T(), uint64(30), t.p.Size(DiskTier))
+}
+
+func (t *policyTest) TestPromotesOnRepeatedDiskReads() {
+	t.admit("a")
+	t.admit("b")
+	t.admit("c")
+	require.Equal(t.T(), DiskTier, t.p.Tier("a"))
+
+	tier, promote := t.p.Access("a")
+	assert.Equal(t.T(), DiskTier, tier)
+	assert.False(t.T(), promote)
+	tier, promote = t.p.Access("a")
+	assert.Equal(t.T(), DiskTier, tier)
+	require.True(t.T(), promote)
+
+	moves := t.p.Promote("a")
+
+	assert.Equal(t.T(), []Move{
+		{Key: "a", From: DiskTier, To: MemoryTier},
+		{Key: "b", From: MemoryTier, To: DiskTier},
+	}, moves)
+	assert.Equal(t.T(), MemoryTier, t.p.Tier("a"))
+}
+
+func (t *policyTest) TestDemotionResetsDiskHits() {
+	t.admit("a")
+	t.admit("b")
+	t.admit("c")
+	t.p.Access("a")
+	t.p.Access("a")
+	t.p.Promote("a")
+	require.Equal(t.T(), DiskTier, t.p.Tier("b"))
+
+	// Demote a again.
+	t.p.Access("c")
+	t.admit("d")
+	require.Equal(t.T(), DiskTier, t.p.Tier("a"))
+
+	_, promote := t.p.Access("a")
+	assert.False(t.T(), promote)
+}
+
+func (t *policyTest) TestPromoteNotOnDisk() {
+	t.admit("a")
+
+	assert.Empty(t.T(), t.p.Promote("a"))
+	assert.Empty(t.T(), t.p.Promote("b"))
+}
+
+func (t *policyTest) TestMemoryOnly() {
+	t.p = NewMemoryPolicy(20)
+	t.admit("a")
+	t.admit("b")
+
+	moves := t.admit("c")
+
+	assert.Equal(t.T(), []Move{{Key: "a", From: MemoryTier, To: NotCached}}, moves)
+}
+
+func (t *policyTest) TestAdmitTooLargeForMemory() {
+	tier, moves := t.p.Admit("a", 25)
+
+	assert.Equal(t.T(), DiskTier, tier)
+	assert.Empty(t.T(), moves)
+
+	tier, _ = NewMemoryPolicy(20).Admit("b", 25)
+	assert.Equal(t.T(), NotCached, tier)
+}
+
+func (t *policyTest) TestRemove() {
+	t.admit("a")
+	t.admit("b")
+	t.admit("c")
+
+	t.p.Remove("a")
+	t.p.Remove("c")
+	t.p.Remove("z")
+
+	assert.Equal(t.T(), NotCached, t.p.Tier("a"))
+	assert.Equal(t.T(), uint64(10), t.p.Size(MemoryTier))
+	assert.Equal(t.T(), uint64(0), t.p.Size(DiskTier))
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// ChunkBitmap tracks which chunks of a sparse file in cache are present, i.e.
+// downloaded. Chunk i covers the range [i*chunkSize, (i+1)*chunkSize) of the
+// object.
+//
+// ChunkBitmap is shared by all the copies of a data.FileInfo and its
+// data.ChunkInfo entries, hence all methods are safe for concurrent access.
+type ChunkBitmap struct {
+	numChunks uint64
+
+	mu sync.RWMutex
+
+	// GUARDED_BY(mu)
+	words []uint64
+}
+
+// NewChunkBitmap returns a ChunkBitmap of the given number of chunks with no
+// chunk present.
+func NewChunkBitmap(numChunks uint64) *ChunkBitmap {
+	return &ChunkBitmap{
+		numChunks: numChunks,
+		words:     make([]uint64, (numChunks+63)/64),
+	}
+}
+
+// NumChunks returns the total number of chunks tracked by the bitmap.
+func (b *ChunkBitmap) NumChunks() uint64 {
+	return b.numChunks
+}
+
+// IsSet returns true if the given chunk is present.
+func (b *ChunkBitmap) IsSet(chunk uint64) bool {
+	if chunk >= b.numChunks {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.words[chunk/64]&(1<<(chunk%64)) != 0
+}
+
+// AreSet returns true if all the chunks in range [start, end) are present.
+func (b *ChunkBitmap) AreSet(start, end uint64) bool {
+	if end > b.numChunks {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for chunk := start; chunk < end; chunk++ {
+		if b.words[chunk/64]&(1<<(chunk%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Set marks the given chunk as present.
+func (b *ChunkBitmap) Set(chunk uint64) {
+	if chunk >= b.numChunks {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.words[chunk/64] |= 1 << (chunk % 64)
+}
+
+// Clear marks the given chunk as not present.
+func (b *ChunkBitmap) Clear(chunk uint64) {
+	if chunk >= b.numChunks {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.words[chunk/64] &^= 1 << (chunk % 64)
+}
+
+// Count returns the number of chunks present.
+func (b *ChunkBitmap) Count() (count uint64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, word := range b.words {
+		count += uint64(bits.OnesCount64(word))
+	}
+	return
+}
+
+// SetChunks returns the indices of all the chunks present, in increasing
+// order.
+func (b *ChunkBitmap) SetChunks() (chunks []uint64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i, word := range b.words {
+		for word != 0 {
+			chunks = append(chunks, uint64(i)*64+uint64(bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"reflect"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+type chunkBitmapTest struct {
+}
+
+func init() {
+	RegisterTestSuite(&chunkBitmapTest{})
+}
+
+func (t *chunkBitmapTest) TestNewChunkBitmap() {
+	b := NewChunkBitmap(130)
+
+	ExpectEq(130, b.NumChunks())
+	ExpectEq(0, b.Count())
+	ExpectFalse(b.IsSet(0))
+	ExpectEq(0, len(b.SetChunks()))
+}
+
+func (t *chunkBitmapTest) TestSetAndClear() {
+	b := NewChunkBitmap(130)
+
+	b.Set(0)
+	b.Set(63)
+	b.Set(64)
+	b.Set(129)
+	b.Clear(63)
+
+	ExpectTrue(b.IsSet(0))
+	ExpectFalse(b.IsSet(63))
+	ExpectTrue(b.IsSet(64))
+	ExpectTrue(b.IsSet(129))
+	ExpectEq(3, b.Count())
+	ExpectTrue(reflect.DeepEqual([]uint64{0, 64, 129}, b.SetChunks()))
+}
+
+func (t *chunkBitmapTest) TestOutOfRangeChunks() {
+	b := NewChunkBitmap(10)
+
+	b.Set(10)
+	b.Clear(10)
+
+	ExpectFalse(b.IsSet(10))
+	ExpectEq(0, b.Count())
+	ExpectFalse(b.AreSet(0, 11))
+}
+
+func (t *chunkBitmapTest) TestAreSet() {
+	b := NewChunkBitmap(100)
+	for i := uint64(60); i < 70; i++ {
+		b.Set(i)
+	}
+
+	ExpectTrue(b.AreSet(60, 70))
+	ExpectTrue(b.AreSet(62, 65))
+	ExpectTrue(b.AreSet(65, 65))
+	ExpectFalse(b.AreSet(59, 70))
+	ExpectFalse(b.AreSet(60, 71))
+}
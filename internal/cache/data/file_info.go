@@ -48,12 +48,67 @@ type FileInfo struct {
 	ObjectGeneration int64
 	Offset           uint64
 	FileSize         uint64
+
+	// ChunkSize and Chunks are set only for the sparse file in cache, which is
+	// downloaded and evicted in chunks of ChunkSize. Chunks tracks the chunks
+	// present in cache, whereas Offset is used otherwise to track the data
+	// present in range [0, Offset).
+	ChunkSize uint64       `json:",omitempty"`
+	Chunks    *ChunkBitmap `json:"-"`
+}
+
+// IsSparse returns true if the file in cache is sparse.
+func (fi FileInfo) IsSparse() bool {
+	return fi.Chunks != nil
 }
 
+// Size returns the size of the file in cache. The data of a sparse file is
+// accounted by the ChunkInfo entries of its chunks present in cache, hence
+// its size is 0.
 func (fi FileInfo) Size() uint64 {
+	if fi.IsSparse() {
+		return 0
+	}
 	return fi.FileSize
 }
 
+// ChunkRange returns the range [startChunk, endChunk) of the chunks of sparse
+// file overlapping the range [start, end) of the object.
+func (fi FileInfo) ChunkRange(start, end uint64) (startChunk, endChunk uint64) {
+	if end <= start {
+		return start / fi.ChunkSize, start / fi.ChunkSize
+	}
+	return start / fi.ChunkSize, (end-1)/fi.ChunkSize + 1
+}
+
+// ChunkInfo is the entry of a chunk of sparse file present in cache. The chunks
+// are separate entries in the file info cache, so that the sparse files are
+// evicted at chunk granularity.
+type ChunkInfo struct {
+	Key              FileInfoKey
+	ObjectGeneration int64
+
+	// Index is the index of chunk in the sparse file, and the chunk covers the
+	// range [Offset, Offset+Length) of the object.
+	Index  uint64
+	Offset uint64
+	Length uint64
+
+	// Chunks is the ChunkBitmap of the FileInfo to which the chunk belongs.
+	Chunks *ChunkBitmap
+}
+
+func (ci ChunkInfo) Size() uint64 {
+	return ci.Length
+}
+
+// GetChunkKeyName returns the key of chunk with given index of the sparse file
+// with given file info key name. GCS object names can't contain line feed, so
+// the chunk keys don't collide with the file info keys.
+func GetChunkKeyName(fileInfoKeyName string, index uint64) string {
+	return fmt.Sprintf("%s\n%d", fileInfoKeyName, index)
+}
+
 type FileSpec struct {
 	Path     string
 	FilePerm os.FileMode
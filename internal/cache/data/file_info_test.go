@@ -98,3 +98,61 @@ func (t *fileInfoTest) TestSizeMethod() {
 
 	ExpectEq(TestDataFileSize, fi.Size())
 }
+
+func (t *fileInfoTest) TestSizeMethodOfSparseFile() {
+	fi := FileInfo{
+		Key:              getTestFileInfoKey(),
+		ObjectGeneration: TestGeneration,
+		FileSize:         TestDataFileSize,
+		ChunkSize:        10,
+		Chunks:           NewChunkBitmap(3),
+	}
+
+	ExpectTrue(fi.IsSparse())
+	ExpectEq(0, fi.Size())
+}
+
+func (t *fileInfoTest) TestChunkRange() {
+	fi := FileInfo{
+		Key:              getTestFileInfoKey(),
+		ObjectGeneration: TestGeneration,
+		FileSize:         TestDataFileSize,
+		ChunkSize:        10,
+		Chunks:           NewChunkBitmap(3),
+	}
+
+	testCases := []struct {
+		start, end                 uint64
+		expectedStart, expectedEnd uint64
+	}{
+		{0, 23, 0, 3},
+		{0, 10, 0, 1},
+		{9, 11, 0, 2},
+		{10, 20, 1, 2},
+		{22, 23, 2, 3},
+		{15, 15, 1, 1},
+	}
+	for _, tc := range testCases {
+		startChunk, endChunk := fi.ChunkRange(tc.start, tc.end)
+
+		ExpectEq(tc.expectedStart, startChunk, "start: %d, end: %d", tc.start, tc.end)
+		ExpectEq(tc.expectedEnd, endChunk, "start: %d, end: %d", tc.start, tc.end)
+	}
+}
+
+func (t *fileInfoTest) TestChunkInfoSizeMethod() {
+	ci := ChunkInfo{
+		Key:              getTestFileInfoKey(),
+		ObjectGeneration: TestGeneration,
+		Index:            2,
+		Offset:           20,
+		Length:           3,
+	}
+
+	ExpectEq(3, ci.Size())
+}
+
+func (t *fileInfoTest) TestGetChunkKeyName() {
+	ExpectEq(ExpectedFileInfoKey+"\n2", GetChunkKeyName(ExpectedFileInfoKey, 2))
+	ExpectNe(GetChunkKeyName(ExpectedFileInfoKey, 12), GetChunkKeyName(ExpectedFileInfoKey+"1", 2))
+}
@@ -50,6 +50,11 @@ type CacheHandle struct {
 	// prevOffset stores the offset of previous cache handle read call. This is used
 	// to decide the type of read.
 	prevOffset int64
+
+	// cacheHandler is the CacheHandler which created this handle, set only if
+	// the file in cache is sparse. It inserts the chunks downloaded for read
+	// into fileInfoCache.
+	cacheHandler *CacheHandler
 }
 
 func NewCacheHandle(localFileHandle *os.File, fileDownloadJob *downloader.Job,
@@ -92,11 +97,13 @@ func (fch *CacheHandle) shouldReadFromCache(jobStatus *downloader.JobStatus, req
 }
 
 // validateEntryInFileInfoCache checks if entry is present for a given object in
-// file info cache with same generation and at least requiredOffset.
+// file info cache with same generation and data in range [offset,
+// requiredOffset). For a sparse file, the entries of chunks overlapping the
+// range must be present too.
 // It returns nil if entry is present, otherwise returns an appropriate error.
 // Whether to change the order in cache while lookup is controlled via
 // changeCacheOrder.
-func (fch *CacheHandle) validateEntryInFileInfoCache(bucket gcs.Bucket, object *gcs.MinObject, requiredOffset uint64, changeCacheOrder bool) error {
+func (fch *CacheHandle) validateEntryInFileInfoCache(bucket gcs.Bucket, object *gcs.MinObject, offset uint64, requiredOffset uint64, changeCacheOrder bool) error {
 	fileInfoKey := data.FileInfoKey{
 		BucketName: bucket.Name(),
 		ObjectName: object.Name,
@@ -125,6 +132,9 @@ func (fch *CacheHandle) validateEntryInFileInfoCache(bucket gcs.Bucket, object *
 		err = fmt.Errorf("%v: generation of cached object: %v is different from required generation: %v", util.InvalidFileInfoCacheErrMsg, fileInfoData.ObjectGeneration, object.Generation)
 		return err
 	}
+	if fileInfoData.IsSparse() {
+		return fch.validateChunksInFileInfoCache(fileInfoKeyName, fileInfoData, offset, requiredOffset, changeCacheOrder)
+	}
 	if fileInfoData.Offset < requiredOffset {
 		err = fmt.Errorf("%v offset of cached object: %v is less than required offset %v", util.InvalidFileInfoCacheErrMsg, fileInfoData.Offset, requiredOffset)
 		return err
@@ -133,6 +143,57 @@ func (fch *CacheHandle) validateEntryInFileInfoCache(bucket gcs.Bucket, object *
 	return nil
 }
 
+// validateChunksInFileInfoCache checks if the entries of chunks of the given
+// sparse file overlapping range [offset, requiredOffset) are present in file
+// info cache. Whether to change the order in cache while lookup is controlled
+// via changeCacheOrder.
+func (fch *CacheHandle) validateChunksInFileInfoCache(fileInfoKeyName string, fileInfo data.FileInfo, offset uint64, requiredOffset uint64, changeCacheOrder bool) error {
+	startChunk, endChunk := fileInfo.ChunkRange(offset, requiredOffset)
+	for chunk := startChunk; chunk < endChunk; chunk++ {
+		chunkKeyName := data.GetChunkKeyName(fileInfoKeyName, chunk)
+		var chunkInfo lru.ValueType
+		if changeCacheOrder {
+			chunkInfo = fch.fileInfoCache.LookUp(chunkKeyName)
+		} else {
+			chunkInfo = fch.fileInfoCache.LookUpWithoutChangingOrder(chunkKeyName)
+		}
+		// The chunk may be evicted during or after reading it from local cached
+		// file to `dst` buffer.
+		if chunkInfo == nil || !fileInfo.Chunks.IsSet(chunk) {
+			return fmt.Errorf("%v: chunk %d of cached object is not present", util.InvalidFileInfoCacheErrMsg, chunk)
+		}
+	}
+
+	// The entry of file has size 0 and must be more recently used than its
+	// chunks, otherwise evicting it would evict all of its chunks.
+	if changeCacheOrder {
+		_ = fch.fileInfoCache.LookUp(fileInfoKeyName)
+	}
+	return nil
+}
+
+// downloadChunks downloads the chunks of sparse file overlapping the range
+// [offset, requiredOffset) if not already present, and inserts them in file
+// info cache. cacheHit is true if all the chunks were already present.
+func (fch *CacheHandle) downloadChunks(ctx context.Context, offset int64, requiredOffset int64) (cacheHit bool, err error) {
+	if fch.fileDownloadJob == nil {
+		return false, fmt.Errorf("%s: no download job for sparse file", util.InvalidFileDownloadJobErrMsg)
+	}
+
+	downloaded, err := fch.fileDownloadJob.DownloadChunks(ctx, offset, requiredOffset)
+	if err != nil {
+		return false, fmt.Errorf("read: while downloading chunks through job: %w", err)
+	}
+
+	err = fch.cacheHandler.insertChunks(downloaded)
+	if err != nil {
+		// Chunks can't be accounted in cache, e.g. because the chunk is bigger
+		// than the cache.
+		return false, fmt.Errorf("%s: %w", util.FallbackToGCSErrMsg, err)
+	}
+	return len(downloaded) == 0, nil
+}
+
 // Read attempts to read the data from the cached location.
 // For sequential reads, it will wait to download the requested chunk
 // if it is not already present. For random reads, it does not wait for
@@ -167,9 +228,17 @@ func (fch *CacheHandle) Read(ctx context.Context, bucket gcs.Bucket, object *gcs
 		requiredOffset = objSize
 	}
 
-	// If fileDownloadJob is not nil, it's better to get status of cache file
-	// from the job itself than to use file info cache.
-	if fch.fileDownloadJob != nil {
+	if fch.cacheHandler != nil {
+		// Only the chunks being read are downloaded for sparse file, hence the
+		// download is not skipped for random reads.
+		fch.prevOffset = offset
+		cacheHit, err = fch.downloadChunks(ctx, offset, requiredOffset)
+		if err != nil {
+			return 0, false, err
+		}
+	} else if fch.fileDownloadJob != nil {
+		// If fileDownloadJob is not nil, it's better to get status of cache file
+		// from the job itself than to use file info cache.
 		jobStatus := fch.fileDownloadJob.GetStatus()
 		// If cacheFileForRangeRead is false and readType is random, download will
 		// not be initiated.
@@ -200,7 +269,7 @@ func (fch *CacheHandle) Read(ctx context.Context, bucket gcs.Bucket, object *gcs
 		// If fileDownloadJob is nil then it means either the job is successfully
 		// completed or failed. The offset must be equal to size of object for job
 		// to be completed.
-		err = fch.validateEntryInFileInfoCache(bucket, object, object.Size, false)
+		err = fch.validateEntryInFileInfoCache(bucket, object, 0, object.Size, false)
 		if err != nil {
 			return 0, false, err
 		}
@@ -231,7 +300,7 @@ func (fch *CacheHandle) Read(ctx context.Context, bucket gcs.Bucket, object *gcs
 	// Look up of file being read in file info cache is required to update the LRU
 	// order on every read request from kernel i.e. with every read request from
 	// kernel, the file being read becomes most recently used.
-	err = fch.validateEntryInFileInfoCache(bucket, object, uint64(requiredOffset), true)
+	err = fch.validateEntryInFileInfoCache(bucket, object, uint64(offset), uint64(requiredOffset), true)
 	if err != nil {
 		return 0, false, err
 	}
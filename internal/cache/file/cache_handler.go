@@ -20,8 +20,10 @@ import (
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file/downloader"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/index"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
@@ -49,21 +51,111 @@ type CacheHandler struct {
 	// dirPerm parameter specifies the permission of cache directory.
 	dirPerm os.FileMode
 
+	// fileInfoJournal is the durable index of file cache, from which
+	// fileInfoCache is rebuilt after restart. It is nil if the file info cache
+	// is not persisted.
+	fileInfoJournal *index.Journal
+
+	// fileCacheConfig decides whether the files are cached as sparse files, and
+	// the size of their chunks.
+	fileCacheConfig *config.FileCacheConfig
+
 	// mu guards the handling of insertion into and eviction from file cache.
 	mu locker.Locker
 }
 
-func NewCacheHandler(fileInfoCache *lru.Cache, jobManager *downloader.JobManager, cacheDir string, filePerm os.FileMode, dirPerm os.FileMode) *CacheHandler {
+func NewCacheHandler(fileInfoCache *lru.Cache, jobManager *downloader.JobManager, cacheDir string, filePerm os.FileMode, dirPerm os.FileMode, fileInfoJournal *index.Journal, fileCacheConfig *config.FileCacheConfig) *CacheHandler {
 	return &CacheHandler{
-		fileInfoCache: fileInfoCache,
-		jobManager:    jobManager,
-		cacheDir:      cacheDir,
-		filePerm:      filePerm,
-		dirPerm:       dirPerm,
-		mu:            locker.New("FileCacheHandler", func() {}),
+		fileInfoCache:   fileInfoCache,
+		jobManager:      jobManager,
+		cacheDir:        cacheDir,
+		filePerm:        filePerm,
+		dirPerm:         dirPerm,
+		fileInfoJournal: fileInfoJournal,
+		fileCacheConfig: fileCacheConfig,
+		mu:              locker.New("FileCacheHandler", func() {}),
 	}
 }
 
+// Entry is an entry of the file cache other than the files in cache and their
+// chunks, such as a block demoted from the memory of the block cache, which
+// takes up space in the file cache directory alongside the files. Entries are
+// evicted along with the files, by the same eviction policy, and count
+// towards the same max size.
+type Entry interface {
+	lru.ValueType
+
+	// Evict is called once the entry is evicted or erased from the file cache,
+	// to remove its data from the file cache directory.
+	Evict()
+}
+
+// entryKeyName returns the key in fileInfoCache of the Entry with the given
+// key. File info keys start with a bucket name, which can't contain a line
+// feed, so the two can't collide.
+func entryKeyName(key string) string {
+	return "\n" + key
+}
+
+// CacheDir returns the file cache directory. Entries keep their data in
+// directories under it whose names aren't valid bucket names, e.g. because
+// they start with a dot, so as not to collide with the files in cache.
+func (chr *CacheHandler) CacheDir() string {
+	return chr.cacheDir
+}
+
+// InsertEntry inserts the given entry into the file cache, replacing any
+// previous one with the same key, and cleans up the entries evicted as a
+// result, which may be files in cache.
+//
+// Acquires and releases LOCK(CacheHandler.mu)
+func (chr *CacheHandler) InsertEntry(key string, entry Entry) error {
+	chr.mu.Lock()
+	defer chr.mu.Unlock()
+
+	evictedValues, err := chr.fileInfoCache.Insert(entryKeyName(key), entry)
+	if err != nil {
+		return fmt.Errorf("InsertEntry: while inserting into the cache: %w", err)
+	}
+	for _, val := range evictedValues {
+		if err = chr.cleanUpEvictedValue(val); err != nil {
+			return fmt.Errorf("InsertEntry: while performing post eviction: %w", err)
+		}
+	}
+	return nil
+}
+
+// LookUpEntry returns whether the file cache has an Entry with the given key,
+// which is then the most recently used.
+func (chr *CacheHandler) LookUpEntry(key string) bool {
+	return chr.fileInfoCache.LookUp(entryKeyName(key)) != nil
+}
+
+// EraseEntry erases the Entry with the given key from the file cache, if
+// present, and calls its Evict method.
+//
+// Acquires and releases LOCK(CacheHandler.mu)
+func (chr *CacheHandler) EraseEntry(key string) {
+	chr.mu.Lock()
+	defer chr.mu.Unlock()
+
+	if val := chr.fileInfoCache.Erase(entryKeyName(key)); val != nil {
+		val.(Entry).Evict()
+	}
+}
+
+// isShared returns true if the file cache directory is shared with other
+// processes through fileInfoJournal.
+func (chr *CacheHandler) isShared() bool {
+	return chr.fileInfoJournal != nil && chr.fileInfoJournal.IsShared()
+}
+
+// isSparseFileEnabled returns true if the new entries of file cache are
+// created for sparse files.
+func (chr *CacheHandler) isSparseFileEnabled() bool {
+	return chr.fileCacheConfig != nil && chr.fileCacheConfig.EnableSparseFile
+}
+
 func (chr *CacheHandler) createLocalFileReadHandle(objectName string, bucketName string) (*os.File, error) {
 	fileSpec := data.FileSpec{
 		Path:     util.GetDownloadPath(chr.cacheDir, util.GetObjectPath(bucketName, objectName)),
@@ -74,36 +166,138 @@ func (chr *CacheHandler) createLocalFileReadHandle(objectName string, bucketName
 	return util.CreateFile(fileSpec, os.O_RDONLY)
 }
 
+// truncateLocalFile creates the file in cache for the given object if not
+// present and truncates it to zero size, so that no data of previously cached
+// generation remains in a new sparse file.
+func (chr *CacheHandler) truncateLocalFile(objectName string, bucketName string) error {
+	fileSpec := data.FileSpec{
+		Path:     util.GetDownloadPath(chr.cacheDir, util.GetObjectPath(bucketName, objectName)),
+		FilePerm: chr.filePerm,
+		DirPerm:  chr.dirPerm,
+	}
+
+	localFile, err := util.CreateFile(fileSpec, os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("truncateLocalFile: %w", err)
+	}
+	return localFile.Close()
+}
+
+// cleanUpEvictedValue is a utility method called for the evicted/deleted
+// values of fileInfoCache, which can be data.FileInfo, data.ChunkInfo or
+// Entry.
+func (chr *CacheHandler) cleanUpEvictedValue(val lru.ValueType) error {
+	switch v := val.(type) {
+	case data.FileInfo:
+		return chr.cleanUpEvictedFile(&v)
+	case data.ChunkInfo:
+		return chr.cleanUpEvictedChunk(&v)
+	case Entry:
+		v.Evict()
+		return nil
+	default:
+		return fmt.Errorf("cleanUpEvictedValue: unexpected value type %T", val)
+	}
+}
+
+// cleanUpEvictedChunk is a utility method called for the evicted chunk of
+// sparse file. It marks the chunk absent and deallocates the space of chunk in
+// the file in cache.
+func (chr *CacheHandler) cleanUpEvictedChunk(chunkInfo *data.ChunkInfo) error {
+	chunkInfo.Chunks.Clear(chunkInfo.Index)
+
+	// The file in cache may already belong to a different generation, if the
+	// file of evicted chunk is evicted too.
+	fileInfoKeyName, err := chunkInfo.Key.Key()
+	if err != nil {
+		return fmt.Errorf("cleanUpEvictedChunk: while creating key: %w", err)
+	}
+	fileInfo := chr.fileInfoCache.LookUpWithoutChangingOrder(fileInfoKeyName)
+	if fileInfo == nil || fileInfo.(data.FileInfo).Chunks != chunkInfo.Chunks {
+		return nil
+	}
+
+	localFilePath := util.GetDownloadPath(chr.cacheDir, util.GetObjectPath(chunkInfo.Key.BucketName, chunkInfo.Key.ObjectName))
+	localFile, err := os.OpenFile(localFilePath, os.O_WRONLY, chr.filePerm)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cleanUpEvictedChunk: while opening file: %s, error: %w", localFilePath, err)
+	}
+	defer localFile.Close()
+
+	// Failure to deallocate the space only makes the file in cache take more
+	// space than accounted, so it is not treated as an error.
+	err = util.PunchHole(localFile, int64(chunkInfo.Offset), int64(chunkInfo.Length))
+	if err != nil {
+		logger.Warnf("cleanUpEvictedChunk: while deallocating chunk %d of file: %s, error: %v", chunkInfo.Index, localFilePath, err)
+	}
+	return nil
+}
+
 // cleanUpEvictedFile is a utility method called for the evicted/deleted fileInfo.
-// As part of execution, it (a) stops and removes the download job (b) truncates
-// and deletes the file in cache.
+// As part of execution, it (a) stops and removes the download job (b) removes
+// the entries of chunks of sparse file from fileInfoCache (c) truncates and
+// deletes the file in cache (d) records the removal in fileInfoJournal. The
+// file is not truncated if the file cache directory is shared, as other
+// processes may still be reading it.
 func (chr *CacheHandler) cleanUpEvictedFile(fileInfo *data.FileInfo) error {
 	key := fileInfo.Key
-	_, err := key.Key()
+	fileInfoKeyName, err := key.Key()
 	if err != nil {
 		return fmt.Errorf("cleanUpEvictedFile: while creating key: %w", err)
 	}
 
 	chr.jobManager.InvalidateAndRemoveJob(key.ObjectName, key.BucketName)
 
+	if fileInfo.IsSparse() {
+		for _, chunk := range fileInfo.Chunks.SetChunks() {
+			_ = chr.fileInfoCache.Erase(data.GetChunkKeyName(fileInfoKeyName, chunk))
+			fileInfo.Chunks.Clear(chunk)
+		}
+	}
+
 	localFilePath := util.GetDownloadPath(chr.cacheDir, util.GetObjectPath(key.BucketName, key.ObjectName))
+	err = removeLocalFile(localFilePath, !chr.isShared())
+	if err != nil {
+		return fmt.Errorf("cleanUpEvictedFile: %w", err)
+	}
+
+	// Removal is recorded only after the file is deleted, and an entry whose
+	// file is missing is anyway evicted while rebuilding fileInfoCache, so
+	// failure to record is not fatal.
+	if chr.fileInfoJournal != nil {
+		if err = chr.fileInfoJournal.Erase(key); err != nil {
+			logger.Warnf("cleanUpEvictedFile: while erasing %s from file cache index: %v", key.ObjectName, err)
+		}
+	}
+	return nil
+}
+
+// removeLocalFile deletes the file in cache at the given path, truncating it
+// first if truncate is true. The file not being present is not treated as an
+// error.
+func removeLocalFile(localFilePath string, truncate bool) error {
 	// Truncate the file to 0 size, so that even if there are open file handles
 	// and linux doesn't delete the file, the file will not take space.
-	err = os.Truncate(localFilePath, 0)
-	if err != nil {
-		if os.IsNotExist(err) {
-			logger.Warnf("cleanUpEvictedFile: file was not present at the time of truncating: %v", err)
-			return nil
-		} else {
-			return fmt.Errorf("cleanUpEvictedFile: while truncating file: %s, error: %w", localFilePath, err)
+	if truncate {
+		err := os.Truncate(localFilePath, 0)
+		if err != nil {
+			if os.IsNotExist(err) {
+				logger.Warnf("cleanUpEvictedFile: file was not present at the time of truncating: %v", err)
+				return nil
+			} else {
+				return fmt.Errorf("while truncating file: %s, error: %w", localFilePath, err)
+			}
 		}
 	}
-	err = os.Remove(localFilePath)
+	err := os.Remove(localFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			logger.Warnf("cleanUpEvictedFile: file was not present at the time of deleting: %v", err)
 		} else {
-			return fmt.Errorf("cleanUpEvictedFile: while deleting file: %s, error: %w", localFilePath, err)
+			return fmt.Errorf("while deleting file: %s, error: %w", localFilePath, err)
 		}
 	}
 
@@ -131,6 +325,15 @@ func (chr *CacheHandler) addFileInfoEntryAndCreateDownloadJob(object *gcs.MinObj
 
 	addEntryToCache := false
 	fileInfo := chr.fileInfoCache.LookUpWithoutChangingOrder(fileInfoKeyName)
+	if fileInfo != nil && chr.isShared() {
+		// Another process sharing the file cache directory removes the file
+		// before recording the removal of entry in fileInfoJournal.
+		filePath := util.GetDownloadPath(chr.cacheDir, util.GetObjectPath(bucket.Name(), object.Name))
+		if _, err := os.Stat(filePath); err != nil && os.IsNotExist(err) {
+			chr.forget(fileInfoKey)
+			fileInfo = nil
+		}
+	}
 	if fileInfo == nil {
 		addEntryToCache = true
 	} else {
@@ -149,9 +352,13 @@ func (chr *CacheHandler) addFileInfoEntryAndCreateDownloadJob(object *gcs.MinObj
 		// Also, invalidate the cache if download job has failed or not invalid.
 		fileInfoData := fileInfo.(data.FileInfo)
 		// If offset in file info cache is less than object size and there is no
-		// reference to download job then it means the job has failed.
+		// reference to download job then it means the job has failed, unless
+		// the file cache directory is shared, when another process may be
+		// downloading the object. The job created below then waits for that
+		// download, or downloads the object itself if the other process has
+		// failed.
 		existingJob := chr.jobManager.GetJob(object.Name, bucket.Name())
-		shouldInvalidate := (existingJob == nil) && (fileInfoData.Offset < object.Size)
+		shouldInvalidate := (existingJob == nil) && (fileInfoData.Offset < object.Size) && !chr.isShared()
 		if (!shouldInvalidate) && (existingJob != nil) {
 			existingJobStatus := existingJob.GetStatus().Name
 			shouldInvalidate = (existingJobStatus == downloader.Failed) || (existingJobStatus == downloader.Invalid)
@@ -170,12 +377,31 @@ func (chr *CacheHandler) addFileInfoEntryAndCreateDownloadJob(object *gcs.MinObj
 	}
 
 	if addEntryToCache {
-		fileInfo = data.FileInfo{
+		newFileInfo := data.FileInfo{
 			Key:              fileInfoKey,
 			ObjectGeneration: object.Generation,
 			Offset:           0,
 			FileSize:         object.Size,
 		}
+		if chr.isSparseFileEnabled() {
+			err = chr.truncateLocalFile(object.Name, bucket.Name())
+			if err != nil {
+				return fmt.Errorf("addFileInfoEntryAndCreateDownloadJob: %w", err)
+			}
+			newFileInfo.ChunkSize = uint64(chr.fileCacheConfig.SparseFileChunkSizeMB) * util.MiB
+			newFileInfo.Chunks = data.NewChunkBitmap((object.Size + newFileInfo.ChunkSize - 1) / newFileInfo.ChunkSize)
+		}
+		fileInfo = newFileInfo
+
+		// The new entry must be recorded before the file in cache is
+		// overwritten by the download job, otherwise after restart the file
+		// could be trusted as a previously downloaded generation.
+		if chr.fileInfoJournal != nil {
+			err = chr.fileInfoJournal.Put(fileInfo.(data.FileInfo))
+			if err != nil {
+				return fmt.Errorf("addFileInfoEntryAndCreateDownloadJob: while recording in file cache index: %w", err)
+			}
+		}
 
 		evictedValues, err := chr.fileInfoCache.Insert(fileInfoKeyName, fileInfo)
 		if err != nil {
@@ -184,20 +410,90 @@ func (chr *CacheHandler) addFileInfoEntryAndCreateDownloadJob(object *gcs.MinObj
 		// Create download job for new entry added to cache.
 		_ = chr.jobManager.CreateJobIfNotExists(object, bucket)
 		for _, val := range evictedValues {
-			fileInfo := val.(data.FileInfo)
-			err := chr.cleanUpEvictedFile(&fileInfo)
+			err := chr.cleanUpEvictedValue(val)
 			if err != nil {
-				return fmt.Errorf("addFileInfoEntryAndCreateDownloadJob: while performing post eviction of %s object error: %w", fileInfo.Key.ObjectName, err)
+				return fmt.Errorf("addFileInfoEntryAndCreateDownloadJob: while performing post eviction: %w", err)
 			}
 		}
 	} else {
 		// Move this entry on top of LRU.
 		_ = chr.fileInfoCache.LookUp(fileInfoKeyName)
+		if chr.isShared() && fileInfo.(data.FileInfo).Offset < object.Size {
+			_ = chr.jobManager.CreateJobIfNotExists(object, bucket)
+		}
 	}
 
 	return nil
 }
 
+// refresh applies the changes made to the entries of file cache by other
+// processes sharing the file cache directory, as read from fileInfoJournal, to
+// fileInfoCache. The entries erased or replaced by them are forgotten, and the
+// entries put by them are inserted, evicting others as needed. This way, the
+// processes together keep the file cache within its max size.
+//
+// Requires Lock(chr.mu)
+func (chr *CacheHandler) refresh() error {
+	if !chr.isShared() {
+		return nil
+	}
+
+	puts, erased, err := chr.fileInfoJournal.Refresh()
+	if err != nil {
+		return fmt.Errorf("refresh: while reading file cache index: %w", err)
+	}
+	for _, key := range erased {
+		chr.forget(key)
+	}
+
+	for _, fileInfo := range puts {
+		fileInfoKeyName, err := fileInfo.Key.Key()
+		if err != nil {
+			return fmt.Errorf("refresh: while creating key: %w", err)
+		}
+
+		if val := chr.fileInfoCache.LookUpWithoutChangingOrder(fileInfoKeyName); val != nil {
+			current := val.(data.FileInfo)
+			if current.ObjectGeneration == fileInfo.ObjectGeneration {
+				// The object is downloaded by another process since.
+				if fileInfo.Offset > current.Offset {
+					current.Offset = fileInfo.Offset
+					_ = chr.fileInfoCache.UpdateWithoutChangingOrder(fileInfoKeyName, current)
+				}
+				continue
+			}
+			chr.forget(current.Key)
+		}
+
+		evictedValues, err := chr.fileInfoCache.Insert(fileInfoKeyName, fileInfo)
+		if err != nil {
+			logger.Warnf("refresh: while inserting %s into the cache: %v", fileInfo.Key.ObjectName, err)
+			continue
+		}
+		for _, val := range evictedValues {
+			if err = chr.cleanUpEvictedValue(val); err != nil {
+				return fmt.Errorf("refresh: while performing post eviction: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// forget removes the entry with the given key from fileInfoCache and
+// invalidates its download job, if any. Unlike eviction, it leaves the file in
+// cache and the entry in fileInfoJournal to the process sharing the file cache
+// directory which has erased or replaced the entry.
+//
+// Requires Lock(chr.mu)
+func (chr *CacheHandler) forget(key data.FileInfoKey) {
+	fileInfoKeyName, err := key.Key()
+	if err != nil {
+		return
+	}
+	_ = chr.fileInfoCache.Erase(fileInfoKeyName)
+	chr.jobManager.InvalidateAndRemoveJob(key.ObjectName, key.BucketName)
+}
+
 // GetCacheHandle creates an entry in fileInfoCache if it does not already exist. It
 // creates downloader.Job if not already exis and requiredt. Also, creates local
 // file into which the download job downloads the object content. Finally, it
@@ -206,17 +502,23 @@ func (chr *CacheHandler) addFileInfoEntryAndCreateDownloadJob(object *gcs.MinObj
 // tasks are completed in one uninterrupted sequence guarded by (CacheHandler.mu).
 // Note: It returns nil if cacheForRangeRead is set to False, initialOffset is
 // non-zero (i.e. random read) and entry for file doesn't already exist in
-// fileInfoCache then no need to create file in cache.
+// fileInfoCache then no need to create file in cache, unless sparse file is
+// enabled.
 //
 // Acquires and releases LOCK(CacheHandler.mu)
 func (chr *CacheHandler) GetCacheHandle(object *gcs.MinObject, bucket gcs.Bucket, cacheForRangeRead bool, initialOffset int64) (*CacheHandle, error) {
 	chr.mu.Lock()
 	defer chr.mu.Unlock()
 
+	if err := chr.refresh(); err != nil {
+		return nil, fmt.Errorf("GetCacheHandle: %w", err)
+	}
+
 	// If cacheForRangeRead is set to False, initialOffset is non-zero (i.e. random read)
 	// and entry for file doesn't already exist in fileInfoCache then no need to
-	// create file in cache.
-	if !cacheForRangeRead && initialOffset != 0 {
+	// create file in cache. Sparse files are cached even for random reads, as
+	// only the chunks being read are downloaded.
+	if !cacheForRangeRead && initialOffset != 0 && !chr.isSparseFileEnabled() {
 		fileInfoKey := data.FileInfoKey{
 			BucketName: bucket.Name(),
 			ObjectName: object.Name,
@@ -242,7 +544,65 @@ func (chr *CacheHandler) GetCacheHandle(object *gcs.MinObject, bucket gcs.Bucket
 		return nil, fmt.Errorf("GetCacheHandle: while creating local-file read handle: %w", err)
 	}
 
-	return NewCacheHandle(localFileReadHandle, chr.jobManager.GetJob(object.Name, bucket.Name()), chr.fileInfoCache, cacheForRangeRead, initialOffset), nil
+	cacheHandle := NewCacheHandle(localFileReadHandle, chr.jobManager.GetJob(object.Name, bucket.Name()), chr.fileInfoCache, cacheForRangeRead, initialOffset)
+	if chr.isSparseFile(object, bucket) {
+		cacheHandle.cacheHandler = chr
+	}
+	return cacheHandle, nil
+}
+
+// isSparseFile returns true if the file in cache for the given object is a
+// sparse file.
+func (chr *CacheHandler) isSparseFile(object *gcs.MinObject, bucket gcs.Bucket) bool {
+	fileInfoKey := data.FileInfoKey{
+		BucketName: bucket.Name(),
+		ObjectName: object.Name,
+	}
+	fileInfoKeyName, err := fileInfoKey.Key()
+	if err != nil {
+		return false
+	}
+	fileInfo := chr.fileInfoCache.LookUpWithoutChangingOrder(fileInfoKeyName)
+	return fileInfo != nil && fileInfo.(data.FileInfo).IsSparse()
+}
+
+// insertChunks inserts the entries of given chunks of sparse files, which are
+// downloaded by downloader.Job, into fileInfoCache and cleans up the entries
+// evicted as a result. The chunks whose file is evicted or replaced by another
+// generation in the meantime are skipped, as they are no longer part of the
+// file in cache.
+//
+// Acquires and releases LOCK(CacheHandler.mu)
+func (chr *CacheHandler) insertChunks(chunks []data.ChunkInfo) error {
+	chr.mu.Lock()
+	defer chr.mu.Unlock()
+
+	for _, chunkInfo := range chunks {
+		fileInfoKeyName, err := chunkInfo.Key.Key()
+		if err != nil {
+			return fmt.Errorf("insertChunks: while creating key: %w", err)
+		}
+
+		// Look up also makes the file most recently used, so that it is not
+		// evicted before the chunk being inserted.
+		fileInfo := chr.fileInfoCache.LookUp(fileInfoKeyName)
+		if fileInfo == nil || fileInfo.(data.FileInfo).Chunks != chunkInfo.Chunks {
+			continue
+		}
+
+		evictedValues, err := chr.fileInfoCache.Insert(data.GetChunkKeyName(fileInfoKeyName, chunkInfo.Index), chunkInfo)
+		if err != nil {
+			chunkInfo.Chunks.Clear(chunkInfo.Index)
+			return fmt.Errorf("insertChunks: while inserting chunk %d of %s into the cache: %w", chunkInfo.Index, chunkInfo.Key.ObjectName, err)
+		}
+		for _, val := range evictedValues {
+			err = chr.cleanUpEvictedValue(val)
+			if err != nil {
+				return fmt.Errorf("insertChunks: while performing post eviction: %w", err)
+			}
+		}
+	}
+	return nil
 }
 
 // InvalidateCache removes the file entry from the fileInfoCache and performs clean
@@ -262,6 +622,10 @@ func (chr *CacheHandler) InvalidateCache(objectName string, bucketName string) e
 	chr.mu.Lock()
 	defer chr.mu.Unlock()
 
+	if err = chr.refresh(); err != nil {
+		return fmt.Errorf("InvalidateCache: %w", err)
+	}
+
 	erasedVal := chr.fileInfoCache.Erase(fileInfoKeyName)
 	if erasedVal != nil {
 		fileInfo := erasedVal.(data.FileInfo)
@@ -273,9 +637,96 @@ func (chr *CacheHandler) InvalidateCache(objectName string, bucketName string) e
 	return nil
 }
 
-// Destroy destroys the job manager (i.e. invalidate all the jobs).
+// RebuildFileInfoCache populates fileInfoCache with the entries recorded in
+// fileInfoJournal, so that the files downloaded into cache before restart can
+// be served without downloading them again. Only the entries of completely
+// downloaded files are restored, as no download job exists for the rest,
+// unless the file cache directory is shared and another process is
+// downloading the file. The files of entries not restored are deleted from
+// cache. This method is expected to be called once, before the cache handler
+// is used.
+//
+// Acquires and releases LOCK(CacheHandler.mu)
+func (chr *CacheHandler) RebuildFileInfoCache() error {
+	if chr.fileInfoJournal == nil {
+		return nil
+	}
+
+	chr.mu.Lock()
+	defer chr.mu.Unlock()
+
+	// Entries are ordered from the least to the most recently used, so that
+	// inserting them in order restores the LRU order.
+	for _, fileInfo := range chr.fileInfoJournal.Entries() {
+		fileInfoKeyName, err := fileInfo.Key.Key()
+		if err != nil {
+			return fmt.Errorf("RebuildFileInfoCache: while creating key: %w", err)
+		}
+
+		filePath := util.GetDownloadPath(chr.cacheDir, util.GetObjectPath(fileInfo.Key.BucketName, fileInfo.Key.ObjectName))
+		if !isCompletelyDownloaded(filePath, fileInfo) && !(chr.isShared() && isBeingDownloaded(filePath)) {
+			logger.Tracef("RebuildFileInfoCache: evicting partially downloaded %s", fileInfo.Key.ObjectName)
+			if err = chr.cleanUpEvictedFile(&fileInfo); err != nil {
+				return fmt.Errorf("RebuildFileInfoCache: while performing clean-up of %s object, error: %w", fileInfo.Key.ObjectName, err)
+			}
+			continue
+		}
+
+		evictedValues, err := chr.fileInfoCache.Insert(fileInfoKeyName, fileInfo)
+		if err != nil {
+			// The entry doesn't fit in cache, e.g. because the max size of
+			// cache is reduced since the last mount.
+			logger.Warnf("RebuildFileInfoCache: while inserting %s into the cache: %v", fileInfo.Key.ObjectName, err)
+			if err = chr.cleanUpEvictedFile(&fileInfo); err != nil {
+				return fmt.Errorf("RebuildFileInfoCache: while performing clean-up of %s object, error: %w", fileInfo.Key.ObjectName, err)
+			}
+			continue
+		}
+		for _, val := range evictedValues {
+			if err = chr.cleanUpEvictedValue(val); err != nil {
+				return fmt.Errorf("RebuildFileInfoCache: while performing post eviction: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// isCompletelyDownloaded returns true if the given data.FileInfo is of a
+// completely downloaded object and the file in cache at the given path has
+// the size of object.
+func isCompletelyDownloaded(filePath string, fileInfo data.FileInfo) bool {
+	if fileInfo.Offset != fileInfo.FileSize {
+		return false
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	return stat.Mode().IsRegular() && uint64(stat.Size()) == fileInfo.FileSize
+}
+
+// isBeingDownloaded returns true if the file in cache at the given path is
+// locked by another process downloading the object into it.
+func isBeingDownloaded(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	locked, err := util.TryLockFile(file)
+	if err != nil || !locked {
+		return err == nil
+	}
+	_ = util.UnlockFile(file)
+	return false
+}
+
+// Destroy destroys the job manager (i.e. invalidate all the jobs) and closes
+// the file info journal.
 // Note: This method is expected to be called at the time of unmounting and
-// because file info cache is in-memory, it is not required to destroy it.
+// because file info cache is in-memory, it is not required to destroy it. It
+// is rebuilt from the file info journal at the time of next mount.
 //
 // Acquires and releases Lock(chr.mu)
 func (chr *CacheHandler) Destroy() (err error) {
@@ -283,5 +734,8 @@ func (chr *CacheHandler) Destroy() (err error) {
 	defer chr.mu.Unlock()
 
 	chr.jobManager.Destroy()
+	if chr.fileInfoJournal != nil {
+		err = chr.fileInfoJournal.Close()
+	}
 	return
 }
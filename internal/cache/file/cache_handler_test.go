@@ -21,6 +21,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file/downloader"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/index"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
@@ -55,6 +57,7 @@ type cacheHandlerTest struct {
 	downloadPath    string
 	fileInfoKeyName string
 	cacheDir        string
+	fileCacheConfig *config.FileCacheConfig
 }
 
 func init() { RegisterTestSuite(&cacheHandlerTest{}) }
@@ -78,13 +81,14 @@ func (chrT *cacheHandlerTest) SetUp(*TestInfo) {
 	chrT.cache = lru.NewCache(HandlerCacheMaxSize)
 
 	// Job manager
+	chrT.fileCacheConfig = &config.FileCacheConfig{
+		EnableCrcCheck: true,
+	}
 	chrT.jobManager = downloader.NewJobManager(chrT.cache, util.DefaultFilePerm,
-		util.DefaultDirPerm, chrT.cacheDir, DefaultSequentialReadSizeMb, &config.FileCacheConfig{
-			EnableCrcCheck: true,
-		})
+		util.DefaultDirPerm, chrT.cacheDir, DefaultSequentialReadSizeMb, chrT.fileCacheConfig, nil)
 
 	// Mocked cached handler object.
-	chrT.cacheHandler = NewCacheHandler(chrT.cache, chrT.jobManager, chrT.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm)
+	chrT.cacheHandler = NewCacheHandler(chrT.cache, chrT.jobManager, chrT.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm, nil, chrT.fileCacheConfig)
 
 	// Follow consistency, local-cache file, entry in fileInfo cache and job should exist initially.
 	chrT.fileInfoKeyName = chrT.addTestFileInfoEntryInCache(storage.TestBucketName, TestObjectName)
@@ -711,3 +715,422 @@ func (chrT *cacheHandlerTest) Test_Destroy() {
 	AssertEq(nil, chrT.jobManager.GetJob(minObject1.Name, chrT.bucket.Name()))
 	AssertEq(nil, chrT.jobManager.GetJob(minObject2.Name, chrT.bucket.Name()))
 }
+
+func (chrT *cacheHandlerTest) setUpFileInfoJournal() *index.Journal {
+	journal, err := index.OpenJournal(path.Join(chrT.cacheDir, util.FileCacheIndex), util.DefaultFilePerm)
+	AssertEq(nil, err)
+	chrT.jobManager = downloader.NewJobManager(chrT.cache, util.DefaultFilePerm,
+		util.DefaultDirPerm, chrT.cacheDir, DefaultSequentialReadSizeMb, chrT.fileCacheConfig, journal)
+	chrT.cacheHandler = NewCacheHandler(chrT.cache, chrT.jobManager, chrT.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm, journal, chrT.fileCacheConfig)
+	return journal
+}
+
+// restart simulates unmounting and mounting again with the same cache dir by
+// destroying the cache handler and creating a new one with empty fileInfoCache,
+// rebuilt from the file info journal.
+func (chrT *cacheHandlerTest) restart() {
+	AssertEq(nil, chrT.cacheHandler.Destroy())
+	chrT.cache = lru.NewCache(HandlerCacheMaxSize)
+	_ = chrT.setUpFileInfoJournal()
+	AssertEq(nil, chrT.cacheHandler.RebuildFileInfoCache())
+}
+
+func (chrT *cacheHandlerTest) downloadCompletely(minObject *gcs.MinObject) {
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	job := cacheHandle.fileDownloadJob
+	_, err = job.Download(context.Background(), int64(minObject.Size), true)
+	AssertEq(nil, err)
+	// Completion is recorded in journal after the whole object is downloaded,
+	// so wait for the job to complete.
+	for i := 0; i < 100 && job.GetStatus().Name != downloader.Completed; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	AssertEq(downloader.Completed, job.GetStatus().Name)
+	AssertEq(nil, cacheHandle.Close())
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_WithoutJournal() {
+	err := chrT.cacheHandler.RebuildFileInfoCache()
+
+	AssertEq(nil, err)
+	ExpectTrue(chrT.isEntryInFileInfoCache(chrT.object.Name, chrT.bucket.Name()))
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_RestoresCompletelyDownloadedFile() {
+	_ = chrT.setUpFileInfoJournal()
+	minObject := chrT.getMinObject("object_1", []byte("content of object_1"))
+	chrT.downloadCompletely(minObject)
+
+	chrT.restart()
+
+	ExpectTrue(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	defer cacheHandle.Close()
+	// No download job is needed for the restored entry.
+	ExpectEq(nil, cacheHandle.fileDownloadJob)
+	buf := make([]byte, 7)
+	n, cacheHit, err := cacheHandle.Read(context.Background(), chrT.bucket, minObject, 0, buf)
+	AssertEq(nil, err)
+	ExpectTrue(cacheHit)
+	ExpectEq("content", string(buf[:n]))
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_EvictsPartiallyDownloadedFile() {
+	_ = chrT.setUpFileInfoJournal()
+	minObject := chrT.getMinObject("object_1", []byte("content of object_1"))
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	AssertEq(nil, cacheHandle.Close())
+	downloadPath := util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject.Name))
+	AssertTrue(doesFileExist(downloadPath))
+
+	chrT.restart()
+
+	ExpectFalse(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+	ExpectFalse(doesFileExist(downloadPath))
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_EvictsFileWithDifferentSize() {
+	_ = chrT.setUpFileInfoJournal()
+	minObject := chrT.getMinObject("object_1", []byte("content of object_1"))
+	chrT.downloadCompletely(minObject)
+	downloadPath := util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject.Name))
+	AssertEq(nil, os.Truncate(downloadPath, 3))
+
+	chrT.restart()
+
+	ExpectFalse(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+	ExpectFalse(doesFileExist(downloadPath))
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_EvictsMissingFile() {
+	_ = chrT.setUpFileInfoJournal()
+	minObject := chrT.getMinObject("object_1", []byte("content of object_1"))
+	chrT.downloadCompletely(minObject)
+	downloadPath := util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject.Name))
+	AssertEq(nil, os.Remove(downloadPath))
+
+	chrT.restart()
+
+	ExpectFalse(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_DoesNotRestoreInvalidatedFile() {
+	_ = chrT.setUpFileInfoJournal()
+	minObject := chrT.getMinObject("object_1", []byte("content of object_1"))
+	chrT.downloadCompletely(minObject)
+	AssertEq(nil, chrT.cacheHandler.InvalidateCache(minObject.Name, chrT.bucket.Name()))
+
+	chrT.restart()
+
+	ExpectFalse(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+}
+
+func (chrT *cacheHandlerTest) Test_RebuildFileInfoCache_RestoresLRUOrder() {
+	_ = chrT.setUpFileInfoJournal()
+	// Cache has space for TestObjectSize + ObjectSizeToCauseEviction bytes.
+	minObject1 := chrT.getMinObject("object_1", make([]byte, ObjectSizeToCauseEviction))
+	minObject2 := chrT.getMinObject("object_2", make([]byte, ObjectSizeToCauseEviction))
+	chrT.downloadCompletely(minObject1)
+	chrT.downloadCompletely(minObject2)
+	// Reduce the size of cache, so that only one of the objects fits.
+	AssertEq(nil, chrT.cacheHandler.Destroy())
+	chrT.cache = lru.NewCache(ObjectSizeToCauseEviction)
+	_ = chrT.setUpFileInfoJournal()
+
+	err := chrT.cacheHandler.RebuildFileInfoCache()
+
+	AssertEq(nil, err)
+	ExpectFalse(chrT.isEntryInFileInfoCache(minObject1.Name, chrT.bucket.Name()))
+	ExpectTrue(chrT.isEntryInFileInfoCache(minObject2.Name, chrT.bucket.Name()))
+	ExpectFalse(doesFileExist(util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject1.Name))))
+}
+
+// setUpSparseFile recreates the cache handler with sparse file enabled, chunks
+// of 1 MiB and file info cache of given size.
+func (chrT *cacheHandlerTest) setUpSparseFile(cacheSize uint64) {
+	chrT.cache = lru.NewCache(cacheSize)
+	chrT.fileCacheConfig.EnableSparseFile = true
+	chrT.fileCacheConfig.SparseFileChunkSizeMB = 1
+	chrT.jobManager = downloader.NewJobManager(chrT.cache, util.DefaultFilePerm,
+		util.DefaultDirPerm, chrT.cacheDir, DefaultSequentialReadSizeMb, chrT.fileCacheConfig, nil)
+	chrT.cacheHandler = NewCacheHandler(chrT.cache, chrT.jobManager, chrT.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm, nil, chrT.fileCacheConfig)
+}
+
+func (chrT *cacheHandlerTest) getFileInfo(minObject *gcs.MinObject) data.FileInfo {
+	fileInfoKey := data.FileInfoKey{BucketName: chrT.bucket.Name(), ObjectName: minObject.Name}
+	fileInfoKeyName, err := fileInfoKey.Key()
+	AssertEq(nil, err)
+	fileInfo := chrT.cache.LookUpWithoutChangingOrder(fileInfoKeyName)
+	AssertTrue(fileInfo != nil)
+	return fileInfo.(data.FileInfo)
+}
+
+func (chrT *cacheHandlerTest) isChunkInFileInfoCache(minObject *gcs.MinObject, chunk uint64) bool {
+	fileInfoKey := data.FileInfoKey{BucketName: chrT.bucket.Name(), ObjectName: minObject.Name}
+	fileInfoKeyName, err := fileInfoKey.Key()
+	AssertEq(nil, err)
+	return chrT.cache.LookUpWithoutChangingOrder(data.GetChunkKeyName(fileInfoKeyName, chunk)) != nil
+}
+
+func (chrT *cacheHandlerTest) Test_GetCacheHandle_SparseFileForRandomRead() {
+	chrT.setUpSparseFile(4 * util.MiB)
+	objectContent := make([]byte, 5*util.MiB+7)
+	_, err := rand.Read(objectContent)
+	AssertEq(nil, err)
+	minObject := chrT.getMinObject("object_1", objectContent)
+	offset := int64(3*util.MiB + 10)
+
+	// Sparse file is cached for random reads even if cacheForRangeRead is false.
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, false, offset)
+
+	AssertEq(nil, err)
+	defer cacheHandle.Close()
+	fileInfo := chrT.getFileInfo(minObject)
+	ExpectTrue(fileInfo.IsSparse())
+	ExpectEq(util.MiB, fileInfo.ChunkSize)
+	ExpectEq(6, fileInfo.Chunks.NumChunks())
+	buf := make([]byte, util.MiB)
+	n, cacheHit, err := cacheHandle.Read(context.Background(), chrT.bucket, minObject, offset, buf)
+	AssertEq(nil, err)
+	ExpectFalse(cacheHit)
+	ExpectTrue(reflect.DeepEqual(objectContent[offset:offset+int64(n)], buf[:n]))
+	// Only the chunks being read are downloaded.
+	ExpectTrue(reflect.DeepEqual([]uint64{3, 4}, fileInfo.Chunks.SetChunks()))
+	ExpectTrue(chrT.isChunkInFileInfoCache(minObject, 3))
+	ExpectTrue(chrT.isChunkInFileInfoCache(minObject, 4))
+	// Read again from the downloaded chunk.
+	n, cacheHit, err = cacheHandle.Read(context.Background(), chrT.bucket, minObject, offset+5, buf[:100])
+	AssertEq(nil, err)
+	ExpectTrue(cacheHit)
+	ExpectTrue(reflect.DeepEqual(objectContent[offset+5:offset+5+int64(n)], buf[:n]))
+}
+
+func (chrT *cacheHandlerTest) Test_GetCacheHandle_SparseFileBiggerThanCache() {
+	chrT.setUpSparseFile(2 * util.MiB)
+	objectContent := make([]byte, 5*util.MiB)
+	_, err := rand.Read(objectContent)
+	AssertEq(nil, err)
+	minObject := chrT.getMinObject("object_1", objectContent)
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	defer cacheHandle.Close()
+	buf := make([]byte, util.MiB)
+
+	for chunk := int64(0); chunk < 5; chunk++ {
+		n, cacheHit, err := cacheHandle.Read(context.Background(), chrT.bucket, minObject, chunk*util.MiB, buf)
+
+		AssertEq(nil, err)
+		ExpectFalse(cacheHit)
+		ExpectTrue(reflect.DeepEqual(objectContent[chunk*util.MiB:chunk*util.MiB+int64(n)], buf[:n]))
+	}
+
+	// Least recently read chunks are evicted, but the file stays in cache.
+	fileInfo := chrT.getFileInfo(minObject)
+	ExpectTrue(reflect.DeepEqual([]uint64{3, 4}, fileInfo.Chunks.SetChunks()))
+	ExpectFalse(chrT.isChunkInFileInfoCache(minObject, 2))
+	ExpectTrue(chrT.isChunkInFileInfoCache(minObject, 3))
+	ExpectTrue(chrT.isChunkInFileInfoCache(minObject, 4))
+	ExpectNe(nil, chrT.jobManager.GetJob(minObject.Name, chrT.bucket.Name()))
+	// Evicted chunk is downloaded again.
+	_, cacheHit, err := cacheHandle.Read(context.Background(), chrT.bucket, minObject, 0, buf)
+	AssertEq(nil, err)
+	ExpectFalse(cacheHit)
+	ExpectTrue(reflect.DeepEqual(objectContent[:util.MiB], buf))
+}
+
+func (chrT *cacheHandlerTest) Test_GetCacheHandle_SparseFileEvictedByOtherFile() {
+	chrT.setUpSparseFile(2 * util.MiB)
+	minObject1 := chrT.getMinObject("object_1", make([]byte, 2*util.MiB))
+	minObject2 := chrT.getMinObject("object_2", make([]byte, 2*util.MiB))
+	cacheHandle1, err := chrT.cacheHandler.GetCacheHandle(minObject1, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	defer cacheHandle1.Close()
+	buf := make([]byte, 2*util.MiB)
+	_, _, err = cacheHandle1.Read(context.Background(), chrT.bucket, minObject1, 0, buf)
+	AssertEq(nil, err)
+	cacheHandle2, err := chrT.cacheHandler.GetCacheHandle(minObject2, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	defer cacheHandle2.Close()
+
+	_, _, err = cacheHandle2.Read(context.Background(), chrT.bucket, minObject2, 0, buf[:util.MiB])
+
+	AssertEq(nil, err)
+	ExpectTrue(reflect.DeepEqual([]uint64{1}, chrT.getFileInfo(minObject1).Chunks.SetChunks()))
+	ExpectTrue(reflect.DeepEqual([]uint64{0}, chrT.getFileInfo(minObject2).Chunks.SetChunks()))
+	// Evicted chunk of first file is downloaded again.
+	_, cacheHit, err := cacheHandle1.Read(context.Background(), chrT.bucket, minObject1, 0, buf[:10])
+	AssertEq(nil, err)
+	ExpectFalse(cacheHit)
+}
+
+func (chrT *cacheHandlerTest) Test_InvalidateCache_SparseFile() {
+	chrT.setUpSparseFile(4 * util.MiB)
+	minObject := chrT.getMinObject("object_1", make([]byte, 3*util.MiB))
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, true, 0)
+	AssertEq(nil, err)
+	defer cacheHandle.Close()
+	buf := make([]byte, 2*util.MiB)
+	_, _, err = cacheHandle.Read(context.Background(), chrT.bucket, minObject, 0, buf)
+	AssertEq(nil, err)
+	chunks := chrT.getFileInfo(minObject).Chunks
+
+	err = chrT.cacheHandler.InvalidateCache(minObject.Name, chrT.bucket.Name())
+
+	AssertEq(nil, err)
+	ExpectFalse(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+	ExpectFalse(chrT.isChunkInFileInfoCache(minObject, 0))
+	ExpectFalse(chrT.isChunkInFileInfoCache(minObject, 1))
+	ExpectEq(0, chunks.Count())
+	ExpectFalse(doesFileExist(util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject.Name))))
+	// Read through the existing cache handle fails as job is invalidated.
+	_, _, err = cacheHandle.Read(context.Background(), chrT.bucket, minObject, 0, buf)
+	AssertNe(nil, err)
+	ExpectTrue(util.IsCacheHandleInvalid(err))
+}
+
+// setUpSharedFileInfoJournal recreates the cache handler with a file info
+// journal shared with other processes, and returns the journal of another
+// process sharing the same cache dir.
+func (chrT *cacheHandlerTest) setUpSharedFileInfoJournal() (other *index.Journal) {
+	AssertEq(nil, os.MkdirAll(chrT.cacheDir, util.DefaultDirPerm))
+	journalPath := path.Join(chrT.cacheDir, util.FileCacheIndex)
+	journal, err := index.OpenSharedJournal(journalPath, util.DefaultFilePerm)
+	AssertEq(nil, err)
+	other, err = index.OpenSharedJournal(journalPath, util.DefaultFilePerm)
+	AssertEq(nil, err)
+	chrT.jobManager = downloader.NewJobManager(chrT.cache, util.DefaultFilePerm,
+		util.DefaultDirPerm, chrT.cacheDir, DefaultSequentialReadSizeMb, chrT.fileCacheConfig, journal)
+	chrT.cacheHandler = NewCacheHandler(chrT.cache, chrT.jobManager, chrT.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm, journal, chrT.fileCacheConfig)
+	return
+}
+
+// downloadAsOtherProcess writes the content of given object into cache and
+// records its completion in the journal of other process.
+func (chrT *cacheHandlerTest) downloadAsOtherProcess(other *index.Journal, minObject *gcs.MinObject, content []byte) {
+	downloadPath := util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject.Name))
+	AssertEq(nil, os.MkdirAll(path.Dir(downloadPath), util.DefaultDirPerm))
+	AssertEq(nil, os.WriteFile(downloadPath, content, util.DefaultFilePerm))
+	AssertEq(nil, other.Put(data.FileInfo{
+		Key:              data.FileInfoKey{BucketName: chrT.bucket.Name(), ObjectName: minObject.Name},
+		ObjectGeneration: minObject.Generation,
+		FileSize:         minObject.Size,
+		Offset:           minObject.Size,
+	}))
+}
+
+func (chrT *cacheHandlerTest) Test_GetCacheHandle_Shared_ReusesFileDownloadedByOtherProcess() {
+	other := chrT.setUpSharedFileInfoJournal()
+	defer other.Close()
+	content := []byte("content of object_1")
+	minObject := chrT.getMinObject("object_1", content)
+	chrT.downloadAsOtherProcess(other, minObject, content)
+
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, true, 0)
+
+	AssertEq(nil, err)
+	defer cacheHandle.Close()
+	// No download job is needed for the file downloaded by other process.
+	ExpectEq(nil, cacheHandle.fileDownloadJob)
+	buf := make([]byte, 7)
+	n, cacheHit, err := cacheHandle.Read(context.Background(), chrT.bucket, minObject, 0, buf)
+	AssertEq(nil, err)
+	ExpectTrue(cacheHit)
+	ExpectEq("content", string(buf[:n]))
+}
+
+func (chrT *cacheHandlerTest) Test_GetCacheHandle_Shared_EvictsForFileDownloadedByOtherProcess() {
+	other := chrT.setUpSharedFileInfoJournal()
+	defer other.Close()
+	// The entry of test object created in SetUp takes all but
+	// ObjectSizeToCauseEviction bytes of cache.
+	content := make([]byte, ObjectSizeToCauseEviction+1)
+	minObject1 := chrT.getMinObject("object_1", content)
+	chrT.downloadAsOtherProcess(other, minObject1, content)
+	minObject2 := chrT.getMinObject("object_2", []byte("content of object_2"))
+
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject2, chrT.bucket, true, 0)
+
+	AssertEq(nil, err)
+	AssertEq(nil, cacheHandle.Close())
+	ExpectFalse(chrT.isEntryInFileInfoCache(chrT.object.Name, chrT.bucket.Name()))
+	ExpectFalse(doesFileExist(chrT.downloadPath))
+	ExpectTrue(chrT.isEntryInFileInfoCache(minObject1.Name, chrT.bucket.Name()))
+	ExpectTrue(chrT.isEntryInFileInfoCache(minObject2.Name, chrT.bucket.Name()))
+}
+
+func (chrT *cacheHandlerTest) Test_InvalidateCache_Shared_ForgetsEntryErasedByOtherProcess() {
+	other := chrT.setUpSharedFileInfoJournal()
+	defer other.Close()
+	minObject := chrT.getMinObject("object_1", []byte("content of object_1"))
+	chrT.downloadCompletely(minObject)
+	downloadPath := util.GetDownloadPath(chrT.cacheDir, util.GetObjectPath(chrT.bucket.Name(), minObject.Name))
+	// Another process evicting the entry and downloading the object again.
+	AssertEq(nil, other.Erase(data.FileInfoKey{BucketName: chrT.bucket.Name(), ObjectName: minObject.Name}))
+	chrT.downloadAsOtherProcess(other, minObject, []byte("content of object_1"))
+
+	err := chrT.cacheHandler.InvalidateCache(chrT.object.Name, chrT.bucket.Name())
+
+	AssertEq(nil, err)
+	// The entry is refreshed from journal, and the file of other process is
+	// left in place.
+	ExpectTrue(chrT.isEntryInFileInfoCache(minObject.Name, chrT.bucket.Name()))
+	ExpectTrue(doesFileExist(downloadPath))
+	ExpectEq(minObject.Size, chrT.getFileInfo(minObject).Offset)
+}
+
+// An Entry recording whether it has been evicted.
+type testEntry struct {
+	size    uint64
+	evicted *bool
+}
+
+func (e testEntry) Size() uint64 { return e.size }
+
+func (e testEntry) Evict() { *e.evicted = true }
+
+func (chrT *cacheHandlerTest) Test_InsertEntry_EvictsFile() {
+	existingJob := chrT.getDownloadJobForTestObject()
+	var evicted bool
+
+	err := chrT.cacheHandler.InsertEntry("block", testEntry{size: ObjectSizeToCauseEviction + 1, evicted: &evicted})
+
+	AssertEq(nil, err)
+	ExpectTrue(chrT.cacheHandler.LookUpEntry("block"))
+	ExpectFalse(evicted)
+	ExpectFalse(chrT.isEntryInFileInfoCache(chrT.object.Name, chrT.bucket.Name()))
+	ExpectEq(downloader.Invalid, existingJob.GetStatus().Name)
+	ExpectFalse(doesFileExist(chrT.downloadPath))
+}
+
+func (chrT *cacheHandlerTest) Test_InsertEntry_EvictedByFile() {
+	var evicted bool
+	err := chrT.cacheHandler.InsertEntry("block", testEntry{size: ObjectSizeToCauseEviction, evicted: &evicted})
+	AssertEq(nil, err)
+	// Make the entry the least recently used.
+	AssertTrue(chrT.isEntryInFileInfoCache(chrT.object.Name, chrT.bucket.Name()))
+	minObject := chrT.getMinObject("object_1", make([]byte, ObjectSizeToCauseEviction))
+
+	cacheHandle, err := chrT.cacheHandler.GetCacheHandle(minObject, chrT.bucket, false, 0)
+
+	AssertEq(nil, err)
+	ExpectNe(nil, cacheHandle)
+	ExpectTrue(evicted)
+	ExpectFalse(chrT.cacheHandler.LookUpEntry("block"))
+	ExpectTrue(chrT.isEntryInFileInfoCache(chrT.object.Name, chrT.bucket.Name()))
+}
+
+func (chrT *cacheHandlerTest) Test_EraseEntry() {
+	var evicted bool
+	err := chrT.cacheHandler.InsertEntry("block", testEntry{size: 1, evicted: &evicted})
+	AssertEq(nil, err)
+
+	chrT.cacheHandler.EraseEntry("block")
+	chrT.cacheHandler.EraseEntry("other")
+
+	ExpectTrue(evicted)
+	ExpectFalse(chrT.cacheHandler.LookUpEntry("block"))
+	ExpectTrue(chrT.isEntryInFileInfoCache(chrT.object.Name, chrT.bucket.Name()))
+}
@@ -15,14 +15,17 @@
 package downloader
 
 import (
+	"math"
 	"os"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/index"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/sync/semaphore"
 )
 
 // JobManager is responsible for maintaining, getting and removing file download
@@ -47,6 +50,16 @@ type JobManager struct {
 	fileInfoCache        *lru.Cache
 	fileCacheConfig      *config.FileCacheConfig
 
+	// maxParallelismSem is shared by all the jobs created by JobManager to
+	// respect fileCacheConfig.MaxDownloadParallelism across all the files being
+	// downloaded in parallel.
+	maxParallelismSem *semaphore.Weighted
+
+	// fileInfoJournal is passed to Job created by JobManager to record the
+	// completely downloaded objects in the durable index of file cache. It is
+	// nil if the file info cache is not persisted.
+	fileInfoJournal *index.Journal
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
@@ -60,7 +73,7 @@ type JobManager struct {
 }
 
 func NewJobManager(fileInfoCache *lru.Cache, filePerm os.FileMode, dirPerm os.FileMode,
-	cacheDir string, sequentialReadSizeMb int32, c *config.FileCacheConfig) (jm *JobManager) {
+	cacheDir string, sequentialReadSizeMb int32, c *config.FileCacheConfig, fileInfoJournal *index.Journal) (jm *JobManager) {
 	jm = &JobManager{
 		fileInfoCache:        fileInfoCache,
 		filePerm:             filePerm,
@@ -68,7 +81,15 @@ func NewJobManager(fileInfoCache *lru.Cache, filePerm os.FileMode, dirPerm os.Fi
 		cacheDir:             cacheDir,
 		sequentialReadSizeMb: sequentialReadSizeMb,
 		fileCacheConfig:      c,
+		fileInfoJournal:      fileInfoJournal,
+	}
+	// A value of -1 for max-download-parallelism means there is no limit on the
+	// number of concurrent downloads across files.
+	maxParallelism := int64(math.MaxInt64)
+	if c.MaxDownloadParallelism != -1 {
+		maxParallelism = int64(c.MaxDownloadParallelism)
 	}
+	jm.maxParallelismSem = semaphore.NewWeighted(maxParallelism)
 	jm.mu = locker.New("JobManager", func() {})
 	jm.jobs = make(map[string]*Job)
 	return
@@ -105,7 +126,7 @@ func (jm *JobManager) CreateJobIfNotExists(object *gcs.MinObject, bucket gcs.Buc
 	removeJobCallback := func() {
 		jm.removeJob(object.Name, bucket.Name())
 	}
-	job = NewJob(object, bucket, jm.fileInfoCache, jm.sequentialReadSizeMb, fileSpec, removeJobCallback, jm.fileCacheConfig)
+	job = NewJob(object, bucket, jm.fileInfoCache, jm.sequentialReadSizeMb, fileSpec, removeJobCallback, jm.fileCacheConfig, jm.maxParallelismSem, jm.fileInfoJournal)
 	jm.jobs[objectPath] = job
 	return job
 }
@@ -63,7 +63,7 @@ func (dt *downloaderTest) SetUp(*TestInfo) {
 	dt.initJobTest(DefaultObjectName, []byte("taco"), DefaultSequentialReadSizeMb, CacheMaxSize, func() {})
 	dt.jm = NewJobManager(dt.cache, util.DefaultFilePerm, util.DefaultDirPerm, cacheDir, DefaultSequentialReadSizeMb, &config.FileCacheConfig{
 		EnableCrcCheck: true,
-	})
+	}, nil)
 }
 
 func (dt *downloaderTest) TearDown() {
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/index"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
@@ -33,6 +34,7 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/semaphore"
 )
 
 type jobStatusName string
@@ -60,7 +62,16 @@ type Job struct {
 	sequentialReadSizeMb int32
 	fileSpec             data.FileSpec
 	fileCacheConfig      *config.FileCacheConfig
-	// downloaded to cache.
+
+	// maxParallelismSem is the semaphore shared across all the jobs created by
+	// the JobManager. It limits the number of ranges downloaded concurrently
+	// across all the jobs when parallel downloads are enabled.
+	maxParallelismSem *semaphore.Weighted
+
+	// fileInfoJournal is the durable index of file cache, in which the job
+	// records the file info of the object once it is completely downloaded.
+	// It is nil if the file info cache is not persisted.
+	fileInfoJournal *index.Journal
 
 	/////////////////////////
 	// Mutable state
@@ -83,6 +94,10 @@ type Job struct {
 	// doneCh for waiting for cancellation of async download in progress.
 	doneCh chan struct{}
 
+	// chunkDownloads contains the chunks of sparse file being downloaded, mapped
+	// to the channel closed once the download of chunk terminates.
+	chunkDownloads map[uint64]chan struct{}
+
 	// removeJobCallback is a callback function to remove job from JobManager. It
 	// is responsibility of JobManager to pass this function.
 	removeJobCallback func()
@@ -112,6 +127,8 @@ func NewJob(
 	fileSpec data.FileSpec,
 	removeJobCallback func(),
 	fileCacheConfig *config.FileCacheConfig,
+	maxParallelismSem *semaphore.Weighted,
+	fileInfoJournal *index.Journal,
 ) (job *Job) {
 	job = &Job{
 		object:               object,
@@ -121,6 +138,8 @@ func NewJob(
 		fileSpec:             fileSpec,
 		removeJobCallback:    removeJobCallback,
 		fileCacheConfig:      fileCacheConfig,
+		maxParallelismSem:    maxParallelismSem,
+		fileInfoJournal:      fileInfoJournal,
 	}
 	job.mu = locker.New("Job-"+fileSpec.Path, job.checkInvariants)
 	job.init()
@@ -145,6 +164,7 @@ func (job *Job) init() {
 	job.status = JobStatus{NotStarted, nil, 0}
 	job.subscribers = list.List{}
 	job.doneCh = make(chan struct{})
+	job.chunkDownloads = make(map[uint64]chan struct{})
 }
 
 // cancel is helper function to cancel the in-progress job.downloadAsync goroutine.
@@ -270,7 +290,9 @@ func (job *Job) updateFileInfoCache() (err error) {
 }
 
 // downloadObjectAsync downloads the backing GCS object into a file as part of
-// file cache using NewReader method of gcs.Bucket.
+// file cache using NewReader method of gcs.Bucket. The object is downloaded
+// with multiple ranges in parallel if parallel downloads are enabled in
+// fileCacheConfig, otherwise sequentially.
 //
 // Note: There can only be one async download running for a job at a time.
 // Acquires and releases LOCK(job.mu)
@@ -290,12 +312,36 @@ func (job *Job) downloadObjectAsync() {
 		job.mu.Unlock()
 	}()
 
-	// Create, open and truncate cache file for writing object into it.
-	cacheFile, err := cacheutil.CreateFile(job.fileSpec, os.O_TRUNC|os.O_WRONLY)
-	if err != nil {
-		err = fmt.Errorf("downloadObjectAsync: error in creating cache file: %w", err)
-		job.failWhileDownloading(err)
-		return
+	var cacheFile *os.File
+	var err error
+	if job.isShared() {
+		var downloaded bool
+		cacheFile, downloaded, err = job.openSharedCacheFile()
+		if err != nil {
+			job.handleError(fmt.Errorf("downloadObjectAsync: %w", err))
+			return
+		}
+		if downloaded {
+			logger.Tracef("Job:%p (%s:/%s) reuses download by another process.", job, job.bucket.Name(), job.object.Name)
+			err = job.updateStatusOffset(int64(job.object.Size))
+			if err != nil {
+				job.handleError(err)
+				return
+			}
+			job.mu.Lock()
+			job.status.Name = Completed
+			job.notifySubscribers()
+			job.mu.Unlock()
+			return
+		}
+	} else {
+		// Create, open and truncate cache file for writing object into it.
+		cacheFile, err = cacheutil.CreateFile(job.fileSpec, os.O_TRUNC|os.O_WRONLY)
+		if err != nil {
+			err = fmt.Errorf("downloadObjectAsync: error in creating cache file: %w", err)
+			job.failWhileDownloading(err)
+			return
+		}
 	}
 	defer func() {
 		err = cacheFile.Close()
@@ -305,119 +351,194 @@ func (job *Job) downloadObjectAsync() {
 		}
 	}()
 
-	notifyInvalid := func() {
-		job.mu.Lock()
-		job.status.Name = Invalid
-		job.notifySubscribers()
-		job.mu.Unlock()
+	if job.fileCacheConfig.EnableParallelDownloads {
+		err = job.parallelDownloadObjectToFile(cacheFile)
+	} else {
+		err = job.downloadObjectToFile(cacheFile)
+	}
+	if err != nil {
+		job.handleError(err)
+		return
+	}
+
+	err = job.validateCRC()
+	if err != nil {
+		job.failWhileDownloading(err)
+		return
+	}
+
+	if job.isShared() {
+		// The file in cache stays locked until the completion is recorded, so
+		// that other processes waiting for the download reuse it.
+		err = job.recordSharedCompletion(cacheFile)
+		if err != nil {
+			job.handleError(err)
+			return
+		}
+	} else {
+		job.recordCompletion(cacheFile)
+	}
+
+	job.mu.Lock()
+	job.status.Name = Completed
+	job.notifySubscribers()
+	job.mu.Unlock()
+}
+
+// recordCompletion records the completely downloaded object in
+// job.fileInfoJournal so that the file in cache can be reused after restart.
+// The cache file is synced before that, so that the journal never refers to
+// data which is not on disk. Failure to record is only logged, as the entry
+// is then treated as incomplete and evicted after restart.
+func (job *Job) recordCompletion(cacheFile *os.File) {
+	if job.fileInfoJournal == nil {
+		return
+	}
+
+	err := cacheFile.Sync()
+	if err == nil {
+		err = job.fileInfoJournal.Put(job.completedFileInfo())
+	}
+	if err != nil {
+		logger.Warnf("Job:%p (%s:/%s) failed to record completion in file cache index: %v", job, job.bucket.Name(), job.object.Name, err)
+	}
+}
+
+// completedFileInfo returns the data.FileInfo of the object completely
+// downloaded by the job.
+func (job *Job) completedFileInfo() data.FileInfo {
+	return data.FileInfo{
+		Key: data.FileInfoKey{
+			BucketName: job.bucket.Name(),
+			ObjectName: job.object.Name,
+		},
+		ObjectGeneration: job.object.Generation,
+		FileSize:         job.object.Size,
+		Offset:           job.object.Size,
 	}
+}
 
+// downloadObjectToFile downloads the backing GCS object into the given file
+// sequentially, using one NewReader of gcs.Bucket per sequentialReadSizeMb
+// chunk of the object.
+func (job *Job) downloadObjectToFile(cacheFile *os.File) (err error) {
 	var newReader io.ReadCloser
 	var start, end, sequentialReadSize, newReaderLimit int64
 	end = int64(job.object.Size)
 	sequentialReadSize = int64(job.sequentialReadSizeMb) * cacheutil.MiB
 
-	for {
+	defer func() {
+		if newReader != nil {
+			closeErr := newReader.Close()
+			if closeErr != nil {
+				logger.Errorf("Job:%p (%s:/%s) error while closing reader: %v", job, job.bucket.Name(), job.object.Name, closeErr)
+			}
+		}
+	}()
+
+	for start < end {
 		select {
 		case <-job.cancelCtx.Done():
-			return
+			return job.cancelCtx.Err()
 		default:
-			if start < end {
-				if newReader == nil {
-					newReaderLimit = min(start+sequentialReadSize, end)
-					newReader, err = job.bucket.NewReader(
-						job.cancelCtx,
-						&gcs.ReadObjectRequest{
-							Name:       job.object.Name,
-							Generation: job.object.Generation,
-							Range: &gcs.ByteRange{
-								Start: uint64(start),
-								Limit: uint64(newReaderLimit),
-							},
-							ReadCompressed: job.object.HasContentEncodingGzip(),
-						})
-					if err != nil {
-						// Context is canceled when job.cancel is called at the time of
-						// invalidation and hence caller should be notified as invalid.
-						if errors.Is(err, context.Canceled) {
-							notifyInvalid()
-							return
-						}
-						err = fmt.Errorf("downloadObjectAsync: error in creating NewReader with start %d and limit %d: %w", start, newReaderLimit, err)
-						job.failWhileDownloading(err)
-						return
-					}
-					monitor.CaptureGCSReadMetrics(job.cancelCtx, util.Sequential, newReaderLimit-start)
-				}
-
-				maxRead := min(ReadChunkSize, newReaderLimit-start)
-				_, err = cacheFile.Seek(start, 0)
-				if err != nil {
-					err = fmt.Errorf("downloadObjectAsync: error while seeking file handle, seek %d: %w", start, err)
-					job.failWhileDownloading(err)
-					return
-				}
-
-				// Copy the contents from NewReader to cache file.
-				_, readErr := io.CopyN(cacheFile, newReader, maxRead)
-				if readErr != nil {
-					// Context is canceled when job.cancel is called at the time of
-					// invalidation and hence caller should be notified as invalid.
-					if errors.Is(readErr, context.Canceled) {
-						notifyInvalid()
-						return
-					}
-					err = fmt.Errorf("downloadObjectAsync: error at the time of copying content to cache file %w", readErr)
-					job.failWhileDownloading(err)
-					return
-				}
-				start += maxRead
-				if start == newReaderLimit {
-					err = newReader.Close()
-					if err != nil {
-						logger.Errorf("Job:%p (%s:/%s) error while closing reader: %v", job, job.bucket.Name(), job.object.Name, err)
-					}
-					newReader = nil
-				}
-
-				job.mu.Lock()
-				job.status.Offset = start
-				err = job.updateFileInfoCache()
-				// Notify subscribers if file cache is updated.
-				if err == nil {
-					job.notifySubscribers()
-				} else if strings.Contains(err.Error(), lru.EntryNotExistErrMsg) {
-					// Download job expects entry in file info cache for the file it is
-					// downloading. If the entry is deleted in between which is expected
-					// to happen at the time of eviction, then the job should be
-					// marked Invalid instead of Failed.
-					job.status.Name = Invalid
-					job.notifySubscribers()
-					logger.Tracef("Job:%p (%s:/%s) is no longer valid due to absense of entry in file info cache.", job, job.bucket.Name(), job.object.Name)
-					job.mu.Unlock()
-					return
-				}
-				job.mu.Unlock()
-				// Change status of job in case of error while updating file cache.
-				if err != nil {
-					job.failWhileDownloading(err)
-					return
-				}
-			} else {
-				err = job.validateCRC()
-				if err != nil {
-					job.failWhileDownloading(err)
-					return
-				}
-
-				job.mu.Lock()
-				job.status.Name = Completed
-				job.notifySubscribers()
-				job.mu.Unlock()
-				return
+		}
+
+		if newReader == nil {
+			newReaderLimit = min(start+sequentialReadSize, end)
+			newReader, err = job.bucket.NewReader(
+				job.cancelCtx,
+				&gcs.ReadObjectRequest{
+					Name:       job.object.Name,
+					Generation: job.object.Generation,
+					Range: &gcs.ByteRange{
+						Start: uint64(start),
+						Limit: uint64(newReaderLimit),
+					},
+					ReadCompressed: job.object.HasContentEncodingGzip(),
+				})
+			if err != nil {
+				return fmt.Errorf("downloadObjectToFile: error in creating NewReader with start %d and limit %d: %w", start, newReaderLimit, err)
 			}
+			monitor.CaptureGCSReadMetrics(job.cancelCtx, util.Sequential, newReaderLimit-start)
+		}
+
+		maxRead := min(ReadChunkSize, newReaderLimit-start)
+		_, err = cacheFile.Seek(start, 0)
+		if err != nil {
+			return fmt.Errorf("downloadObjectToFile: error while seeking file handle, seek %d: %w", start, err)
+		}
+
+		// Copy the contents from NewReader to cache file.
+		_, err = io.CopyN(cacheFile, newReader, maxRead)
+		if err != nil {
+			return fmt.Errorf("downloadObjectToFile: error at the time of copying content to cache file %w", err)
+		}
+		start += maxRead
+		if start == newReaderLimit {
+			err = newReader.Close()
+			if err != nil {
+				logger.Errorf("Job:%p (%s:/%s) error while closing reader: %v", job, job.bucket.Name(), job.object.Name, err)
+			}
+			newReader = nil
+		}
+
+		err = job.updateStatusOffset(start)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// updateStatusOffset updates the offset of job status and file info cache to
+// the given downloadedOffset, and notifies the subscribers waiting for the
+// download till that offset. Returns error in case of failure while updating
+// file info cache.
+//
+// Acquires and releases LOCK(job.mu)
+func (job *Job) updateStatusOffset(downloadedOffset int64) (err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.status.Offset = downloadedOffset
+	err = job.updateFileInfoCache()
+	// Notify subscribers if file cache is updated.
+	if err == nil {
+		job.notifySubscribers()
+	}
+	return
+}
+
+// handleError changes the status of job depending upon the error encountered
+// while downloading and notifies the subscribers about it.
+//
+// Acquires and releases LOCK(job.mu)
+func (job *Job) handleError(err error) {
+	// Context is canceled when job.cancel is called at the time of
+	// invalidation and hence caller should be notified as invalid.
+	if errors.Is(err, context.Canceled) {
+		job.mu.Lock()
+		job.status.Name = Invalid
+		job.notifySubscribers()
+		job.mu.Unlock()
+		return
+	}
+
+	// Download job expects entry in file info cache for the file it is
+	// downloading. If the entry is deleted in between which is expected
+	// to happen at the time of eviction, then the job should be
+	// marked Invalid instead of Failed. The same holds for the entry in file
+	// cache index shared with other processes.
+	if strings.Contains(err.Error(), lru.EntryNotExistErrMsg) || errors.Is(err, errEntryChanged) {
+		job.mu.Lock()
+		job.status.Name = Invalid
+		job.notifySubscribers()
+		logger.Tracef("Job:%p (%s:/%s) is no longer valid due to absense of entry in file info cache.", job, job.bucket.Name(), job.object.Name)
+		job.mu.Unlock()
+		return
+	}
+
+	job.failWhileDownloading(err)
 }
 
 // Download downloads object till the given offset and returns the status of
@@ -479,7 +600,8 @@ func (job *Job) GetStatus() JobStatus {
 // Compares CRC32 of the downloaded file with the CRC32 from GCS object metadata.
 // In case of mismatch deletes the file and corresponding entry from file cache.
 func (job *Job) validateCRC() (err error) {
-	if !job.fileCacheConfig.EnableCrcCheck {
+	// Objects from stores other than GCS may not have a CRC32C to check.
+	if !job.fileCacheConfig.EnableCrcCheck || job.object.CRC32C == nil {
 		return
 	}
 
@@ -0,0 +1,160 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/monitor"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+	"golang.org/x/net/context"
+)
+
+// rangeDownloadResult is the result of downloading the range [start, end) of
+// the object by downloadRange.
+type rangeDownloadResult struct {
+	start int64
+	end   int64
+	err   error
+}
+
+// downloadRange reads the data in range [start, end) of the backing GCS object
+// and writes it to the given writer. The read is captured in GCS read metrics
+// with the given read type.
+func (job *Job) downloadRange(ctx context.Context, dstWriter io.Writer, start, end int64, readType string) (err error) {
+	newReader, err := job.bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       job.object.Name,
+			Generation: job.object.Generation,
+			Range: &gcs.ByteRange{
+				Start: uint64(start),
+				Limit: uint64(end),
+			},
+			ReadCompressed: job.object.HasContentEncodingGzip(),
+		})
+	if err != nil {
+		err = fmt.Errorf("downloadRange: error in creating NewReader with start %d and end %d: %w", start, end, err)
+		return
+	}
+	defer func() {
+		closeErr := newReader.Close()
+		if closeErr != nil {
+			logger.Errorf("Job:%p (%s:/%s) error while closing reader: %v", job, job.bucket.Name(), job.object.Name, closeErr)
+		}
+	}()
+
+	monitor.CaptureGCSReadMetrics(ctx, readType, end-start)
+
+	_, err = io.CopyN(dstWriter, newReader, end-start)
+	if err != nil {
+		err = fmt.Errorf("downloadRange: error at the time of copying content to cache file: %w", err)
+		return
+	}
+	return
+}
+
+// parallelDownloadObjectToFile downloads the backing GCS object into the given
+// file by splitting it into ranges of fileCacheConfig.ReadRequestSizeMB and
+// downloading up to fileCacheConfig.DownloadParallelismPerFile ranges
+// concurrently. Every range downloaded concurrently needs a token from
+// job.maxParallelismSem, so that the total number of such ranges across jobs
+// is bounded by fileCacheConfig.MaxDownloadParallelism. When no token is
+// available and the job has no range in flight, the job downloads the next
+// range serially instead of waiting, so that it always makes progress.
+//
+// Ranges may complete in any order, but job.status.Offset is only advanced
+// till the end of the contiguous downloaded data starting from 0, so that
+// the subscribers can always read [0, job.status.Offset) from cache file.
+func (job *Job) parallelDownloadObjectToFile(cacheFile *os.File) (err error) {
+	end := int64(job.object.Size)
+	readRequestSize := int64(job.fileCacheConfig.ReadRequestSizeMB) * cacheutil.MiB
+	parallelism := job.fileCacheConfig.DownloadParallelismPerFile
+
+	// All the in-flight ranges are cancelled as soon as one of them fails.
+	ctx, cancel := context.WithCancel(job.cancelCtx)
+	defer cancel()
+
+	results := make(chan rangeDownloadResult, parallelism)
+	// completedRanges contains the ranges downloaded beyond the contiguous
+	// offset, mapped from start to end of the range.
+	completedRanges := make(map[int64]int64)
+	var offset, nextStart int64
+	inFlight := 0
+
+	// waitForInFlight waits for the in-flight ranges to terminate, as they
+	// write into cacheFile which is closed by the caller after returning.
+	waitForInFlight := func() {
+		cancel()
+		for ; inFlight > 0; inFlight-- {
+			<-results
+		}
+	}
+
+	for offset < end {
+		for nextStart < end && inFlight < parallelism && job.maxParallelismSem.TryAcquire(1) {
+			rangeStart := nextStart
+			rangeEnd := min(rangeStart+readRequestSize, end)
+			go func() {
+				// Copy the contents from NewReader to cache file at appropriate offset.
+				offsetWriter := io.NewOffsetWriter(cacheFile, rangeStart)
+				rangeErr := job.downloadRange(ctx, offsetWriter, rangeStart, rangeEnd, util.Parallel)
+				job.maxParallelismSem.Release(1)
+				results <- rangeDownloadResult{start: rangeStart, end: rangeEnd, err: rangeErr}
+			}()
+			inFlight++
+			nextStart = rangeEnd
+		}
+
+		var result rangeDownloadResult
+		if inFlight == 0 {
+			// Other jobs hold all the tokens, so download the next range serially.
+			result.start = nextStart
+			result.end = min(nextStart+readRequestSize, end)
+			offsetWriter := io.NewOffsetWriter(cacheFile, result.start)
+			result.err = job.downloadRange(ctx, offsetWriter, result.start, result.end, util.Sequential)
+			nextStart = result.end
+		} else {
+			result = <-results
+			inFlight--
+		}
+
+		if result.err != nil {
+			waitForInFlight()
+			return result.err
+		}
+
+		completedRanges[result.start] = result.end
+		prevOffset := offset
+		for rangeEnd, ok := completedRanges[offset]; ok; rangeEnd, ok = completedRanges[offset] {
+			delete(completedRanges, offset)
+			offset = rangeEnd
+		}
+
+		if offset > prevOffset {
+			err = job.updateStatusOffset(offset)
+			if err != nil {
+				waitForInFlight()
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	testutil "github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+	. "github.com/jacobsa/ogletest"
+	"golang.org/x/sync/semaphore"
+)
+
+func (dt *downloaderTest) enableParallelDownloads(readRequestSizeMB int, parallelismPerFile int, maxParallelism int64) {
+	dt.job.fileCacheConfig = &config.FileCacheConfig{
+		EnableCrcCheck:             true,
+		EnableParallelDownloads:    true,
+		ReadRequestSizeMB:          readRequestSizeMB,
+		DownloadParallelismPerFile: parallelismPerFile,
+		MaxDownloadParallelism:     int(maxParallelism),
+	}
+	dt.job.maxParallelismSem = semaphore.NewWeighted(maxParallelism)
+}
+
+// concurrencyCountingBucket records the peak number of readers open at once.
+// Readers are kept open for a while once read, so that downloads overlap.
+type concurrencyCountingBucket struct {
+	gcs.Bucket
+
+	mu     sync.Mutex
+	active int
+	peak   int
+}
+
+type countedReader struct {
+	io.ReadCloser
+	bucket *concurrencyCountingBucket
+}
+
+func (b *concurrencyCountingBucket) NewReader(ctx context.Context, req *gcs.ReadObjectRequest) (io.ReadCloser, error) {
+	rc, err := b.Bucket.NewReader(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.active++
+	b.peak = max(b.peak, b.active)
+	b.mu.Unlock()
+	return &countedReader{ReadCloser: rc, bucket: b}, nil
+}
+
+func (r *countedReader) Close() error {
+	time.Sleep(10 * time.Millisecond)
+	r.bucket.mu.Lock()
+	r.bucket.active--
+	r.bucket.mu.Unlock()
+	return r.ReadCloser.Close()
+}
+
+func (dt *downloaderTest) Test_downloadRange() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 10 * util.MiB
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	var buf bytes.Buffer
+	start := int64(util.MiB)
+	end := int64(3*util.MiB + 5)
+
+	err := dt.job.downloadRange(context.Background(), &buf, start, end, testutil.Parallel)
+
+	AssertEq(nil, err)
+	AssertTrue(reflect.DeepEqual(objectContent[start:end], buf.Bytes()))
+}
+
+func (dt *downloaderTest) Test_downloadRange_CtxCancelled() {
+	objectName := "path/in/gcs/foo.txt"
+	objectContent := testutil.GenerateRandomBytes(util.MiB)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*util.MiB), func() {})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := dt.job.downloadRange(ctx, &bytes.Buffer{}, 0, util.MiB, testutil.Parallel)
+
+	AssertNe(nil, err)
+}
+
+func (dt *downloaderTest) Test_parallelDownloadObjectToFile() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 21*util.MiB + 7
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	dt.enableParallelDownloads(2, 4, 16)
+	dt.job.cancelCtx, dt.job.cancelFunc = context.WithCancel(context.Background())
+	cacheFile, err := util.CreateFile(dt.fileSpec, os.O_TRUNC|os.O_WRONLY)
+	AssertEq(nil, err)
+
+	err = dt.job.parallelDownloadObjectToFile(cacheFile)
+
+	AssertEq(nil, err)
+	AssertEq(nil, cacheFile.Close())
+	AssertEq(objectSize, dt.job.status.Offset)
+	dt.verifyFile(objectContent)
+	dt.verifyFileInfoEntry(uint64(objectSize))
+}
+
+func (dt *downloaderTest) Test_parallelDownloadObjectToFile_MaxParallelismAcrossJobs() {
+	const jobCount = 3
+	const maxParallelism = 2
+	bucket := &concurrencyCountingBucket{Bucket: dt.bucket}
+	maxParallelismSem := semaphore.NewWeighted(maxParallelism)
+	var jobs []*Job
+	var contents [][]byte
+	for i := 0; i < jobCount; i++ {
+		objectContent := testutil.GenerateRandomBytes(8 * util.MiB)
+		dt.initJobTest(fmt.Sprintf("path/in/gcs/foo%d.txt", i), objectContent, DefaultSequentialReadSizeMb, uint64(2*len(objectContent)), func() {})
+		dt.enableParallelDownloads(1, 4, maxParallelism)
+		// Each job needs its own object, as dt.object is reused by initJobTest.
+		object := dt.object
+		dt.job.object = &object
+		dt.job.bucket = bucket
+		dt.job.maxParallelismSem = maxParallelismSem
+		dt.job.cancelCtx, dt.job.cancelFunc = context.WithCancel(context.Background())
+		jobs = append(jobs, dt.job)
+		contents = append(contents, objectContent)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, jobCount)
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cacheFile, err := util.CreateFile(job.fileSpec, os.O_TRUNC|os.O_WRONLY)
+			if err == nil {
+				err = job.parallelDownloadObjectToFile(cacheFile)
+				cacheFile.Close()
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for i, job := range jobs {
+		AssertEq(nil, errs[i])
+		dt.fileSpec = job.fileSpec
+		dt.verifyFile(contents[i])
+	}
+	// Every range downloaded concurrently holds a token, and a job downloads a
+	// range without one only while it holds none, so at most jobCount-1 ranges
+	// are downloaded without a token while all the tokens are in use.
+	AssertLe(bucket.peak, maxParallelism+jobCount-1)
+	// All the tokens have been released.
+	AssertTrue(maxParallelismSem.TryAcquire(maxParallelism))
+}
+
+func (dt *downloaderTest) Test_downloadObjectAsync_ParallelDownloads() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 30 * util.MiB
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	dt.enableParallelDownloads(3, 5, 100)
+	dt.job.cancelCtx, dt.job.cancelFunc = context.WithCancel(context.Background())
+	subscribedOffset := int64(10 * util.MiB)
+	notificationC := dt.job.subscribe(subscribedOffset)
+
+	dt.job.downloadObjectAsync()
+
+	jobStatus := <-notificationC
+	AssertGe(jobStatus.Offset, subscribedOffset)
+	dt.job.mu.Lock()
+	defer dt.job.mu.Unlock()
+	AssertTrue(reflect.DeepEqual(JobStatus{Completed, nil, int64(objectSize)}, dt.job.status))
+	dt.verifyFile(objectContent)
+	dt.verifyFileInfoEntry(uint64(objectSize))
+}
+
+func (dt *downloaderTest) Test_downloadObjectAsync_ParallelDownloadsWithExhaustedMaxParallelism() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 10 * util.MiB
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	// No token is available in the shared semaphore, so the job should still
+	// progress by downloading one range at a time.
+	dt.enableParallelDownloads(1, 10, 0)
+	dt.job.cancelCtx, dt.job.cancelFunc = context.WithCancel(context.Background())
+
+	dt.job.downloadObjectAsync()
+
+	dt.job.mu.Lock()
+	defer dt.job.mu.Unlock()
+	AssertTrue(reflect.DeepEqual(JobStatus{Completed, nil, int64(objectSize)}, dt.job.status))
+	dt.verifyFile(objectContent)
+	dt.verifyFileInfoEntry(uint64(objectSize))
+}
+
+func (dt *downloaderTest) Test_Download_ParallelDownloadsContiguousOffset() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 40 * util.MiB
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	dt.enableParallelDownloads(2, 8, 100)
+	offset := int64(9 * util.MiB)
+
+	jobStatus, err := dt.job.Download(context.Background(), offset, true)
+
+	AssertEq(nil, err)
+	AssertEq(nil, jobStatus.Err)
+	AssertGe(jobStatus.Offset, offset)
+	// Data till the notified offset must be present in cache file.
+	dt.verifyFile(objectContent[:jobStatus.Offset])
+	dt.verifyFileInfoEntry(uint64(jobStatus.Offset))
+}
+
+func (dt *downloaderTest) Test_Invalidate_WhenParallelDownloading() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 50 * util.MiB
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	dt.enableParallelDownloads(1, 4, 100)
+	jobStatus, err := dt.job.Download(context.Background(), 0, false)
+	AssertEq(nil, err)
+	AssertEq(Downloading, jobStatus.Name)
+
+	dt.job.Invalidate()
+
+	jobStatus = dt.job.GetStatus()
+	AssertEq(Invalid, jobStatus.Name)
+	// All the ranges in flight should have terminated, releasing their tokens.
+	AssertTrue(dt.job.maxParallelismSem.TryAcquire(100))
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+)
+
+// sharedLockRetryInterval is the interval at which a job retries to lock the
+// file in cache which another process is downloading the object into.
+const sharedLockRetryInterval = 100 * time.Millisecond
+
+// errEntryChanged means that another process sharing the file cache directory
+// has erased the entry of the object being downloaded from file cache index,
+// or replaced it with another generation.
+var errEntryChanged = errors.New("entry of object in file cache index is changed by another process")
+
+// isShared returns true if the file cache directory is shared with other
+// processes through job.fileInfoJournal.
+func (job *Job) isShared() bool {
+	return job.fileInfoJournal != nil && job.fileInfoJournal.IsShared()
+}
+
+// openSharedCacheFile opens the file in cache for writing the object into it
+// when the file cache directory is shared with other processes. The file is
+// locked, so that one process at a time downloads the object into it, while
+// the others wait for the download and reuse it. If the object is already
+// completely downloaded by then, the file is closed and downloaded is true.
+// Otherwise, the file is truncated for the job to download the object.
+func (job *Job) openSharedCacheFile() (cacheFile *os.File, downloaded bool, err error) {
+	for {
+		cacheFile, err = cacheutil.CreateFile(job.fileSpec, os.O_WRONLY)
+		if err != nil {
+			return nil, false, fmt.Errorf("openSharedCacheFile: error in creating cache file: %w", err)
+		}
+		var same bool
+		err = job.lockCacheFile(cacheFile)
+		if err == nil {
+			same, err = isFileAt(cacheFile, job.fileSpec.Path)
+		}
+		if err == nil && same {
+			break
+		}
+		// The file is closed and opened again if another process has removed it
+		// while waiting for the lock.
+		_ = cacheFile.Close()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	fileInfo, present, err := job.fileInfoJournal.Get(job.completedFileInfo().Key)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("openSharedCacheFile: while reading file cache index: %w", err)
+	case !present || fileInfo.ObjectGeneration != job.object.Generation:
+		err = errEntryChanged
+	case fileInfo.Offset == job.object.Size:
+		var stat os.FileInfo
+		stat, err = cacheFile.Stat()
+		downloaded = err == nil && uint64(stat.Size()) == job.object.Size
+	}
+	if err == nil && !downloaded {
+		err = cacheFile.Truncate(0)
+	}
+
+	if err != nil || downloaded {
+		_ = cacheFile.Close()
+		cacheFile = nil
+	}
+	return
+}
+
+// lockCacheFile locks the given file in cache, waiting for another process to
+// release the lock until the job is cancelled.
+func (job *Job) lockCacheFile(cacheFile *os.File) error {
+	for waiting := false; ; waiting = true {
+		locked, err := cacheutil.TryLockFile(cacheFile)
+		if err != nil {
+			return fmt.Errorf("lockCacheFile: %w", err)
+		}
+		if locked {
+			return nil
+		}
+		if !waiting {
+			logger.Tracef("Job:%p (%s:/%s) waiting for download by another process.", job, job.bucket.Name(), job.object.Name)
+		}
+
+		select {
+		case <-job.cancelCtx.Done():
+			return job.cancelCtx.Err()
+		case <-time.After(sharedLockRetryInterval):
+		}
+	}
+}
+
+// recordSharedCompletion records the completely downloaded object in the
+// shared job.fileInfoJournal, if its entry and the file in cache at its path
+// are still the ones the job downloaded the object for. Otherwise, another
+// process has evicted or replaced them in the meantime, and it returns
+// errEntryChanged.
+func (job *Job) recordSharedCompletion(cacheFile *os.File) error {
+	err := cacheFile.Sync()
+	if err != nil {
+		return fmt.Errorf("recordSharedCompletion: while syncing cache file: %w", err)
+	}
+
+	recorded, err := job.fileInfoJournal.PutIf(job.completedFileInfo(), func(current data.FileInfo, present bool) bool {
+		if !present || current.ObjectGeneration != job.object.Generation {
+			return false
+		}
+		same, err := isFileAt(cacheFile, job.fileSpec.Path)
+		return err == nil && same
+	})
+	if err != nil {
+		return fmt.Errorf("recordSharedCompletion: while recording in file cache index: %w", err)
+	}
+	if !recorded {
+		return errEntryChanged
+	}
+	return nil
+}
+
+// isFileAt returns true if the given open file is the one at the given path.
+func isFileAt(file *os.File, path string) (bool, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	pathStat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(stat, pathStat), nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"context"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/index"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	testutil "github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+	. "github.com/jacobsa/ogletest"
+	"golang.org/x/sync/semaphore"
+)
+
+// share replaces the test job with one recording in a shared journal, in
+// which the entry of test object is put as by CacheHandler. It returns another
+// shared journal at the same path standing for another process, which the
+// caller must close.
+func (dt *downloaderTest) share() (other *index.Journal) {
+	AssertEq(nil, os.MkdirAll(cacheDir, util.DefaultDirPerm))
+	journalPath := path.Join(cacheDir, util.FileCacheIndex)
+	journal, err := index.OpenSharedJournal(journalPath, util.DefaultFilePerm)
+	AssertEq(nil, err)
+	other, err = index.OpenSharedJournal(journalPath, util.DefaultFilePerm)
+	AssertEq(nil, err)
+
+	dt.job = NewJob(&dt.object, dt.bucket, dt.cache, DefaultSequentialReadSizeMb, dt.fileSpec, func() {}, &config.FileCacheConfig{EnableCrcCheck: true}, semaphore.NewWeighted(math.MaxInt64), journal)
+	fileInfo := dt.job.completedFileInfo()
+	fileInfo.Offset = 0
+	AssertEq(nil, journal.Put(fileInfo))
+	return
+}
+
+// lockAsOtherProcess opens and locks the file in cache of test object as
+// another process downloading it.
+func (dt *downloaderTest) lockAsOtherProcess() *os.File {
+	file, err := util.CreateFile(dt.fileSpec, os.O_WRONLY)
+	AssertEq(nil, err)
+	AssertEq(nil, util.LockFile(file))
+	return file
+}
+
+func (dt *downloaderTest) deleteObject() {
+	err := dt.bucket.DeleteObject(context.Background(), &gcs.DeleteObjectRequest{Name: dt.object.Name})
+	AssertEq(nil, err)
+}
+
+func (dt *downloaderTest) Test_Shared_RecordsCompletion() {
+	objectContent := testutil.GenerateRandomBytes(2 * util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+
+	jobStatus, err := dt.job.Download(context.Background(), int64(len(objectContent)), true)
+
+	AssertEq(nil, err)
+	AssertEq(nil, jobStatus.Err)
+	dt.waitForCrcCheckToBeCompleted()
+	AssertEq(Completed, dt.job.GetStatus().Name)
+	dt.verifyFile(objectContent)
+	fileInfo, present, err := other.Get(dt.job.completedFileInfo().Key)
+	AssertEq(nil, err)
+	AssertTrue(present)
+	ExpectEq(dt.object.Size, fileInfo.Offset)
+}
+
+func (dt *downloaderTest) Test_Shared_ReusesDownloadOfOtherProcess() {
+	objectContent := testutil.GenerateRandomBytes(2 * util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+	file, err := util.CreateFile(dt.fileSpec, os.O_WRONLY)
+	AssertEq(nil, err)
+	_, err = file.Write(objectContent)
+	AssertEq(nil, err)
+	AssertEq(nil, file.Close())
+	AssertEq(nil, other.Put(dt.job.completedFileInfo()))
+	// The object can't be downloaded again.
+	dt.deleteObject()
+
+	jobStatus, err := dt.job.Download(context.Background(), int64(len(objectContent)), true)
+
+	AssertEq(nil, err)
+	AssertEq(nil, jobStatus.Err)
+	ExpectEq(len(objectContent), jobStatus.Offset)
+	AssertEq(Completed, dt.job.GetStatus().Name)
+	dt.verifyFile(objectContent)
+	dt.verifyFileInfoEntry(dt.object.Size)
+}
+
+func (dt *downloaderTest) Test_Shared_WaitsForDownloadByOtherProcess() {
+	objectContent := testutil.GenerateRandomBytes(2 * util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+	file := dt.lockAsOtherProcess()
+
+	jobStatus, err := dt.job.Download(context.Background(), int64(len(objectContent)), false)
+
+	AssertEq(nil, err)
+	AssertEq(Downloading, jobStatus.Name)
+	time.Sleep(2 * sharedLockRetryInterval)
+	jobStatus = dt.job.GetStatus()
+	AssertEq(Downloading, jobStatus.Name)
+	AssertEq(0, jobStatus.Offset)
+	// The other process completes the download.
+	_, err = file.Write(objectContent)
+	AssertEq(nil, err)
+	AssertEq(nil, other.Put(dt.job.completedFileInfo()))
+	dt.deleteObject()
+	AssertEq(nil, file.Close())
+	jobStatus, err = dt.job.Download(context.Background(), int64(len(objectContent)), true)
+	AssertEq(nil, err)
+	AssertEq(nil, jobStatus.Err)
+	ExpectEq(len(objectContent), jobStatus.Offset)
+	dt.verifyFile(objectContent)
+}
+
+func (dt *downloaderTest) Test_Shared_DownloadsWhenOtherProcessStopsDownloading() {
+	objectContent := testutil.GenerateRandomBytes(2 * util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+	file := dt.lockAsOtherProcess()
+	_, err := file.Write([]byte("partial"))
+	AssertEq(nil, err)
+	_, err = dt.job.Download(context.Background(), int64(len(objectContent)), false)
+	AssertEq(nil, err)
+
+	AssertEq(nil, file.Close())
+
+	jobStatus, err := dt.job.Download(context.Background(), int64(len(objectContent)), true)
+	AssertEq(nil, err)
+	AssertEq(nil, jobStatus.Err)
+	ExpectEq(len(objectContent), jobStatus.Offset)
+	dt.verifyFile(objectContent)
+}
+
+func (dt *downloaderTest) Test_Shared_InvalidWhenEntryErasedByOtherProcess() {
+	objectContent := testutil.GenerateRandomBytes(2 * util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+	file := dt.lockAsOtherProcess()
+	_, err := dt.job.Download(context.Background(), int64(len(objectContent)), false)
+	AssertEq(nil, err)
+
+	AssertEq(nil, other.Erase(dt.job.completedFileInfo().Key))
+	AssertEq(nil, file.Close())
+
+	jobStatus, err := dt.job.Download(context.Background(), int64(len(objectContent)), true)
+	AssertEq(nil, err)
+	ExpectEq(Invalid, jobStatus.Name)
+}
+
+func (dt *downloaderTest) Test_Shared_InvalidateWhileWaitingForOtherProcess() {
+	objectContent := testutil.GenerateRandomBytes(2 * util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+	file := dt.lockAsOtherProcess()
+	defer file.Close()
+	_, err := dt.job.Download(context.Background(), int64(len(objectContent)), false)
+	AssertEq(nil, err)
+
+	dt.job.Invalidate()
+
+	ExpectEq(Invalid, dt.job.GetStatus().Name)
+}
+
+func (dt *downloaderTest) Test_recordSharedCompletion_FileRemovedByOtherProcess() {
+	objectContent := testutil.GenerateRandomBytes(util.MiB)
+	dt.initJobTest("foo.txt", objectContent, DefaultSequentialReadSizeMb, uint64(len(objectContent)), func() {})
+	other := dt.share()
+	defer other.Close()
+	cacheFile, err := util.CreateFile(dt.fileSpec, os.O_WRONLY)
+	AssertEq(nil, err)
+	defer cacheFile.Close()
+	AssertEq(nil, os.Remove(dt.fileSpec.Path))
+
+	err = dt.job.recordSharedCompletion(cacheFile)
+
+	ExpectEq(errEntryChanged, err)
+	fileInfo, present, err := other.Get(dt.job.completedFileInfo().Key)
+	AssertEq(nil, err)
+	AssertTrue(present)
+	ExpectEq(0, fileInfo.Offset)
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+	"golang.org/x/net/context"
+)
+
+// getSparseFileInfo returns the data.FileInfo of the object from file info
+// cache, returning error if the entry is absent, is of different generation or
+// is not of a sparse file.
+func (job *Job) getSparseFileInfo() (fileInfo data.FileInfo, err error) {
+	fileInfoKey := data.FileInfoKey{
+		BucketName: job.bucket.Name(),
+		ObjectName: job.object.Name,
+	}
+	fileInfoKeyName, err := fileInfoKey.Key()
+	if err != nil {
+		err = fmt.Errorf("getSparseFileInfo: error while creating fileInfoKeyName for bucket %s and object %s %w",
+			fileInfoKey.BucketName, fileInfoKey.ObjectName, err)
+		return
+	}
+
+	val := job.fileInfoCache.LookUpWithoutChangingOrder(fileInfoKeyName)
+	if val == nil {
+		err = fmt.Errorf("%s: no entry found in file info cache for key %v", cacheutil.InvalidFileInfoCacheErrMsg, fileInfoKeyName)
+		return
+	}
+	fileInfo = val.(data.FileInfo)
+	if fileInfo.ObjectGeneration != job.object.Generation || !fileInfo.IsSparse() {
+		err = fmt.Errorf("%s: entry for key %v is not of sparse file with generation %d", cacheutil.InvalidFileInfoCacheErrMsg, fileInfoKeyName, job.object.Generation)
+		return
+	}
+	return
+}
+
+// DownloadChunks downloads the chunks of sparse file in cache overlapping the
+// range [start, end) of the object which are not already present, and
+// returns data.ChunkInfo of the chunks downloaded by this call. If some of the
+// chunks are being downloaded by another caller, it waits for them instead of
+// downloading again. Chunks are marked present in the data.ChunkBitmap of
+// data.FileInfo as soon as they are written to the file in cache, and the
+// caller is responsible for inserting the returned chunks in file info cache.
+// The returned error contains util.FallbackToGCSErrMsg if the chunks couldn't
+// be downloaded, in which case the caller should read from GCS.
+//
+// Unlike Download, this method never changes the status of job from
+// NotStarted, as the job doesn't download the object asynchronously. The job
+// can only be invalidated.
+//
+// Acquires and releases LOCK(job.mu)
+func (job *Job) DownloadChunks(ctx context.Context, start, end int64) (downloaded []data.ChunkInfo, err error) {
+	if start < 0 || end > int64(job.object.Size) || start > end {
+		err = fmt.Errorf("DownloadChunks: the requested range [%d, %d) is out of object of size %d", start, end, job.object.Size)
+		return
+	}
+
+	fileInfo, err := job.getSparseFileInfo()
+	if err != nil {
+		err = fmt.Errorf("DownloadChunks: %w", err)
+		return
+	}
+	startChunk, endChunk := fileInfo.ChunkRange(uint64(start), uint64(end))
+
+	job.mu.Lock()
+	if job.status.Name == Invalid || job.status.Name == Failed {
+		defer job.mu.Unlock()
+		err = fmt.Errorf("DownloadChunks: %s: jobStatus: %s", cacheutil.InvalidFileDownloadJobErrMsg, job.status.Name)
+		return
+	}
+	var toDownload []uint64
+	var inFlight []chan struct{}
+	for chunk := startChunk; chunk < endChunk; chunk++ {
+		if fileInfo.Chunks.IsSet(chunk) {
+			continue
+		}
+		if doneC, ok := job.chunkDownloads[chunk]; ok {
+			inFlight = append(inFlight, doneC)
+			continue
+		}
+		job.chunkDownloads[chunk] = make(chan struct{})
+		toDownload = append(toDownload, chunk)
+	}
+	job.mu.Unlock()
+
+	if len(toDownload) > 0 {
+		downloaded, err = job.downloadChunksToFile(ctx, fileInfo, toDownload)
+		if err != nil {
+			err = fmt.Errorf("DownloadChunks: %s: %w", cacheutil.FallbackToGCSErrMsg, err)
+			return
+		}
+	}
+
+	// Wait for the chunks being downloaded by other callers.
+	for _, doneC := range inFlight {
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("DownloadChunks: %w", ctx.Err())
+			return
+		case <-doneC:
+		}
+	}
+
+	if !fileInfo.Chunks.AreSet(startChunk, endChunk) {
+		err = fmt.Errorf("DownloadChunks: %s: chunks in range [%d, %d) are not present in cache", cacheutil.FallbackToGCSErrMsg, startChunk, endChunk)
+	}
+	return
+}
+
+// downloadChunksToFile downloads the given chunks of sparse file one by one
+// into the file in cache, and marks them present in the bitmap of fileInfo.
+// The given chunks must have been registered in job.chunkDownloads by the
+// caller, and are removed from it as the download of each chunk terminates.
+//
+// Acquires and releases LOCK(job.mu)
+func (job *Job) downloadChunksToFile(ctx context.Context, fileInfo data.FileInfo, chunks []uint64) (downloaded []data.ChunkInfo, err error) {
+	// Notify the waiters of chunks which are not downloaded in case of failure.
+	defer func() {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		for _, chunk := range chunks[len(downloaded):] {
+			if doneC, ok := job.chunkDownloads[chunk]; ok {
+				close(doneC)
+				delete(job.chunkDownloads, chunk)
+			}
+		}
+	}()
+
+	// The file in cache is created (and truncated) while adding the entry in
+	// file info cache, hence must not be truncated here.
+	cacheFile, err := cacheutil.CreateFile(job.fileSpec, os.O_WRONLY)
+	if err != nil {
+		err = fmt.Errorf("downloadChunksToFile: error in opening cache file: %w", err)
+		return
+	}
+	defer func() {
+		closeErr := cacheFile.Close()
+		if closeErr != nil {
+			logger.Errorf("Job:%p (%s:/%s) error while closing cache file: %v", job, job.bucket.Name(), job.object.Name, closeErr)
+		}
+	}()
+
+	for _, chunk := range chunks {
+		chunkStart := chunk * fileInfo.ChunkSize
+		chunkEnd := min(chunkStart+fileInfo.ChunkSize, job.object.Size)
+		offsetWriter := io.NewOffsetWriter(cacheFile, int64(chunkStart))
+		err = job.downloadRange(ctx, offsetWriter, int64(chunkStart), int64(chunkEnd), util.Random)
+		if err != nil {
+			return
+		}
+
+		fileInfo.Chunks.Set(chunk)
+		downloaded = append(downloaded, data.ChunkInfo{
+			Key:              fileInfo.Key,
+			ObjectGeneration: fileInfo.ObjectGeneration,
+			Index:            chunk,
+			Offset:           chunkStart,
+			Length:           chunkEnd - chunkStart,
+			Chunks:           fileInfo.Chunks,
+		})
+		logger.Tracef("Job:%p (%s:/%s) downloaded chunk %d.", job, job.bucket.Name(), job.object.Name, chunk)
+
+		job.mu.Lock()
+		close(job.chunkDownloads[chunk])
+		delete(job.chunkDownloads, chunk)
+		job.mu.Unlock()
+	}
+	return
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	testutil "github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+	. "github.com/jacobsa/ogletest"
+)
+
+const testChunkSize = util.MiB
+
+// makeSparse replaces the entry of test object in file info cache with an
+// entry of sparse file with chunks of testChunkSize, and creates an empty file
+// in cache.
+func (dt *downloaderTest) makeSparse() *data.ChunkBitmap {
+	fileInfoKey := data.FileInfoKey{BucketName: dt.bucket.Name(), ObjectName: dt.object.Name}
+	fileInfoKeyName, err := fileInfoKey.Key()
+	AssertEq(nil, err)
+	chunks := data.NewChunkBitmap((dt.object.Size + testChunkSize - 1) / testChunkSize)
+	_, err = dt.cache.Insert(fileInfoKeyName, data.FileInfo{
+		Key:              fileInfoKey,
+		ObjectGeneration: dt.object.Generation,
+		FileSize:         dt.object.Size,
+		ChunkSize:        testChunkSize,
+		Chunks:           chunks,
+	})
+	AssertEq(nil, err)
+	file, err := util.CreateFile(dt.fileSpec, os.O_TRUNC|os.O_WRONLY)
+	AssertEq(nil, err)
+	AssertEq(nil, file.Close())
+	return chunks
+}
+
+// verifyFileRange verifies that the file in cache contains the given content
+// at the given offset.
+func (dt *downloaderTest) verifyFileRange(offset int64, content []byte) {
+	fileContent, err := os.ReadFile(dt.fileSpec.Path)
+	AssertEq(nil, err)
+	AssertLe(offset+int64(len(content)), len(fileContent))
+	AssertTrue(reflect.DeepEqual(content, fileContent[offset:offset+int64(len(content))]))
+}
+
+func (dt *downloaderTest) Test_DownloadChunks() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 10*testChunkSize + 5
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	chunks := dt.makeSparse()
+	start := int64(3*testChunkSize + 10)
+	end := int64(5*testChunkSize + 1)
+
+	downloaded, err := dt.job.DownloadChunks(context.Background(), start, end)
+
+	AssertEq(nil, err)
+	AssertEq(3, len(downloaded))
+	for i, chunkInfo := range downloaded {
+		ExpectEq(3+i, chunkInfo.Index)
+		ExpectEq((3+i)*testChunkSize, chunkInfo.Offset)
+		ExpectEq(testChunkSize, chunkInfo.Size())
+		ExpectEq(dt.object.Generation, chunkInfo.ObjectGeneration)
+		ExpectEq(chunks, chunkInfo.Chunks)
+	}
+	ExpectEq(3, chunks.Count())
+	ExpectTrue(chunks.AreSet(3, 6))
+	dt.verifyFileRange(3*testChunkSize, objectContent[3*testChunkSize:6*testChunkSize])
+	// Job is not started for sparse file.
+	ExpectEq(NotStarted, dt.job.GetStatus().Name)
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_OnlyMissingChunks() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 10*testChunkSize + 5
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	chunks := dt.makeSparse()
+	_, err := dt.job.DownloadChunks(context.Background(), 2*testChunkSize, 3*testChunkSize)
+	AssertEq(nil, err)
+
+	downloaded, err := dt.job.DownloadChunks(context.Background(), testChunkSize, 4*testChunkSize)
+
+	AssertEq(nil, err)
+	AssertEq(2, len(downloaded))
+	ExpectEq(1, downloaded[0].Index)
+	ExpectEq(3, downloaded[1].Index)
+	ExpectTrue(chunks.AreSet(1, 4))
+	dt.verifyFileRange(testChunkSize, objectContent[testChunkSize:4*testChunkSize])
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_LastChunk() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 2*testChunkSize + 5
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	chunks := dt.makeSparse()
+
+	downloaded, err := dt.job.DownloadChunks(context.Background(), int64(objectSize-1), int64(objectSize))
+
+	AssertEq(nil, err)
+	AssertEq(1, len(downloaded))
+	ExpectEq(2, downloaded[0].Index)
+	ExpectEq(5, downloaded[0].Size())
+	ExpectEq(1, chunks.Count())
+	dt.verifyFileRange(2*testChunkSize, objectContent[2*testChunkSize:])
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_AllChunksPresent() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 2 * testChunkSize
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	_ = dt.makeSparse()
+	_, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize))
+	AssertEq(nil, err)
+
+	downloaded, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize))
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(downloaded))
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_Concurrent() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 4 * testChunkSize
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	chunks := dt.makeSparse()
+	wg := sync.WaitGroup{}
+	var mu sync.Mutex
+	downloadedCount := 0
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			downloaded, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize))
+			AssertEq(nil, err)
+			mu.Lock()
+			downloadedCount += len(downloaded)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Every chunk is downloaded exactly once.
+	ExpectEq(4, downloadedCount)
+	ExpectEq(4, chunks.Count())
+	dt.verifyFileRange(0, objectContent)
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_InvalidRange() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 2 * testChunkSize
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	_ = dt.makeSparse()
+
+	_, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize+1))
+
+	AssertNe(nil, err)
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_NotSparseFile() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 2 * testChunkSize
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+
+	_, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize))
+
+	AssertNe(nil, err)
+	ExpectTrue(strings.Contains(err.Error(), util.InvalidFileInfoCacheErrMsg))
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_InvalidJob() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 2 * testChunkSize
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	chunks := dt.makeSparse()
+	dt.job.Invalidate()
+
+	_, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize))
+
+	AssertNe(nil, err)
+	ExpectTrue(strings.Contains(err.Error(), util.InvalidFileDownloadJobErrMsg))
+	ExpectEq(0, chunks.Count())
+}
+
+func (dt *downloaderTest) Test_DownloadChunks_CtxCancelled() {
+	objectName := "path/in/gcs/foo.txt"
+	objectSize := 2 * testChunkSize
+	objectContent := testutil.GenerateRandomBytes(objectSize)
+	dt.initJobTest(objectName, objectContent, DefaultSequentialReadSizeMb, uint64(2*objectSize), func() {})
+	chunks := dt.makeSparse()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := dt.job.DownloadChunks(ctx, 0, int64(objectSize))
+
+	AssertNe(nil, err)
+	ExpectTrue(strings.Contains(err.Error(), util.FallbackToGCSErrMsg))
+	ExpectEq(0, chunks.Count())
+	// Chunks can be downloaded again after failure.
+	downloaded, err := dt.job.DownloadChunks(context.Background(), 0, int64(objectSize))
+	AssertEq(nil, err)
+	ExpectEq(2, len(downloaded))
+}
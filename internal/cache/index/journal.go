@@ -0,0 +1,648 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package index provides the durable index of the file cache, which allows the
+// file info cache to be rebuilt from the files in cache after gcsfuse restarts,
+// and to be shared by the gcsfuse processes using the same cache directory.
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+)
+
+const (
+	putOp   = "put"
+	eraseOp = "erase"
+
+	// minRecordsForCompaction is the minimum number of records in journal file
+	// before it is considered for compaction.
+	minRecordsForCompaction = 1024
+
+	// maxRecordSize is the maximum size of a single record in the journal file.
+	// Object names are at most 1024 bytes, so a record is much smaller than this.
+	maxRecordSize = 64 * 1024
+)
+
+// record is a single line of the journal file.
+type record struct {
+	Op   string        `json:"op"`
+	Info data.FileInfo `json:"info"`
+}
+
+// journalEntry is the latest data.FileInfo recorded for a key along with the
+// sequence number of the record, which is used to preserve the order of
+// entries while compacting.
+type journalEntry struct {
+	seq  uint64
+	info data.FileInfo
+}
+
+// Journal is an append-only log of changes to the entries of file info cache.
+// Every change is appended to the journal file as a record, and replaying the
+// records gives the latest data.FileInfo of every object in the file cache.
+// The journal file is compacted to contain only one record per entry when
+// opened and when it grows too large compared to the number of entries.
+//
+// Records for new entries are synced to disk before returning, as they must
+// reach the disk before the corresponding file in cache is overwritten.
+//
+// A shared journal can be used by several processes at once. Each of them
+// holds a lock on a file next to the journal file while changing or reading
+// it, and catches up with the records appended by the others before that.
+//
+// All methods are safe for concurrent access.
+type Journal struct {
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	path     string
+	filePerm os.FileMode
+
+	// lockFile is the file next to the journal file locked by the processes
+	// sharing the journal. It is nil if the journal isn't shared.
+	lockFile *os.File
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	// file is the journal file opened for appending records, and fileStat is
+	// its file info, which tells if it has been replaced by a compaction of
+	// another process.
+	file     *os.File
+	fileStat os.FileInfo
+
+	// readOffset is the size of the journal file read or written by this
+	// process, i.e. the offset from which the records appended by other
+	// processes are read.
+	readOffset int64
+
+	// changed contains the keys of the entries changed by other processes and
+	// not yet returned by Refresh, indexed by file info key name.
+	changed map[string]data.FileInfoKey
+
+	// entries contains the latest data.FileInfo of every entry present in
+	// journal, indexed by file info key name.
+	entries map[string]journalEntry
+
+	// nextSeq is the sequence number given to the next record.
+	nextSeq uint64
+
+	// numRecords is the number of records in journal file.
+	//
+	// INVARIANT: numRecords >= len(entries)
+	numRecords int
+
+	mu locker.Locker
+}
+
+// OpenJournal opens the journal file at the given path, creating it with given
+// permissions if not present, and replays the records present in it. A
+// truncated or corrupted record, e.g. due to a crash while writing, ends the
+// replay, and the records after it are ignored. The journal file is compacted
+// after replay.
+func OpenJournal(path string, filePerm os.FileMode) (j *Journal, err error) {
+	return openJournal(path, filePerm, nil)
+}
+
+// OpenSharedJournal opens the journal file at the given path like OpenJournal,
+// for use by several processes at once. The lock file shared by them is
+// created next to the journal file.
+func OpenSharedJournal(path string, filePerm os.FileMode) (j *Journal, err error) {
+	lockFile, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDONLY, filePerm)
+	if err != nil {
+		return nil, fmt.Errorf("OpenSharedJournal: while opening lock file: %w", err)
+	}
+	j, err = openJournal(path, filePerm, lockFile)
+	if err != nil {
+		_ = lockFile.Close()
+	}
+	return
+}
+
+func openJournal(path string, filePerm os.FileMode, lockFile *os.File) (j *Journal, err error) {
+	j = &Journal{
+		path:     path,
+		filePerm: filePerm,
+		lockFile: lockFile,
+		entries:  make(map[string]journalEntry),
+		changed:  make(map[string]data.FileInfoKey),
+	}
+	j.mu = locker.New("Journal-"+path, j.checkInvariants)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if lockFile != nil {
+		if err = cacheutil.LockFile(lockFile); err != nil {
+			return nil, fmt.Errorf("OpenJournal: while locking %s: %w", path, err)
+		}
+		defer j.unlockShared()
+	}
+
+	err = j.replay()
+	if err != nil {
+		return nil, fmt.Errorf("OpenJournal: while replaying %s: %w", path, err)
+	}
+
+	err = j.compact()
+	if err != nil {
+		return nil, fmt.Errorf("OpenJournal: while compacting %s: %w", path, err)
+	}
+	return
+}
+
+// IsShared returns true if the journal is opened for use by several processes.
+func (j *Journal) IsShared() bool {
+	return j.lockFile != nil
+}
+
+// checkInvariants panic if any internal invariants have been violated.
+func (j *Journal) checkInvariants() {
+	// INVARIANT: numRecords >= len(entries)
+	if j.numRecords < len(j.entries) {
+		panic(fmt.Sprintf("Number of records %v is less than number of entries %v", j.numRecords, len(j.entries)))
+	}
+}
+
+// apply updates j.entries with the given record. The change is tracked in
+// j.changed if trackChange is true.
+func (j *Journal) apply(r record, trackChange bool) error {
+	key, err := r.Info.Key.Key()
+	if err != nil {
+		return err
+	}
+	if trackChange {
+		j.changed[key] = r.Info.Key
+	}
+
+	switch r.Op {
+	case putOp:
+		j.entries[key] = journalEntry{seq: j.nextSeq, info: r.Info}
+		j.nextSeq++
+	case eraseOp:
+		delete(j.entries, key)
+	default:
+		return fmt.Errorf("unknown op: %q", r.Op)
+	}
+	j.numRecords++
+	return nil
+}
+
+// replay reads all the valid records of the journal file into j.entries.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) replay() error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	j.readRecords(f, false)
+	return nil
+}
+
+// readRecords applies the valid records read from the given journal file,
+// which is positioned at j.readOffset, and advances j.readOffset past them.
+// It returns false if the records end with a truncated or corrupted one.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) readRecords(f *os.File, trackChanges bool) bool {
+	reader := bufio.NewReaderSize(f, maxRecordSize)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == io.EOF && len(line) == 0 {
+			return true
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			var r record
+			err = json.Unmarshal(line, &r)
+			if err == nil {
+				err = j.apply(r, trackChanges)
+			}
+		}
+		if err != nil {
+			logger.Warnf("Journal %s: ignoring records after record %d: %v", j.path, j.numRecords, err)
+			return false
+		}
+		j.readOffset += int64(len(line))
+	}
+}
+
+// catchUp applies the records appended to the journal file by other processes
+// since it was last read, or all the records of the journal file if another
+// process has compacted it in the meantime. A truncated or corrupted record,
+// e.g. due to a crash of another process while writing, is dropped along
+// with the records after it by compacting the journal file.
+//
+// Requires LOCK(j.mu) and the lock of j.lockFile
+func (j *Journal) catchUp() (err error) {
+	stat, err := os.Stat(j.path)
+	if err != nil {
+		return
+	}
+	replaced := !os.SameFile(stat, j.fileStat)
+	if !replaced && stat.Size() == j.readOffset {
+		return nil
+	}
+
+	f, err := os.Open(j.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var ok bool
+	if replaced {
+		ok = j.replaceEntries(f)
+	} else {
+		if _, err = f.Seek(j.readOffset, io.SeekStart); err != nil {
+			return
+		}
+		ok = j.readRecords(f, true)
+	}
+
+	if !ok {
+		return j.compact()
+	}
+	if replaced {
+		return j.reopen()
+	}
+	return nil
+}
+
+// replaceEntries replaces j.entries with the records of the given journal file,
+// which has replaced the one read so far, tracking the entries which differ.
+// It returns false if the records end with a truncated or corrupted one.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) replaceEntries(f *os.File) (ok bool) {
+	previous := j.entries
+	j.entries = make(map[string]journalEntry)
+	j.numRecords = 0
+	j.readOffset = 0
+	ok = j.readRecords(f, false)
+
+	for key, e := range j.entries {
+		if p, present := previous[key]; !present || p.info != e.info {
+			j.changed[key] = e.info.Key
+		}
+	}
+	for key, p := range previous {
+		if _, present := j.entries[key]; !present {
+			j.changed[key] = p.info.Key
+		}
+	}
+	return
+}
+
+// lockShared acquires the lock of j.lockFile and catches up with the records
+// appended by other processes. It does nothing if the journal isn't shared.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) lockShared() error {
+	if j.lockFile == nil {
+		return nil
+	}
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+
+	if err := cacheutil.LockFile(j.lockFile); err != nil {
+		return fmt.Errorf("while locking journal %s: %w", j.path, err)
+	}
+	if err := j.catchUp(); err != nil {
+		j.unlockShared()
+		return fmt.Errorf("while reading journal %s: %w", j.path, err)
+	}
+	return nil
+}
+
+// unlockShared releases the lock acquired by lockShared.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) unlockShared() {
+	if j.lockFile == nil {
+		return
+	}
+	if err := cacheutil.UnlockFile(j.lockFile); err != nil {
+		logger.Warnf("Journal %s: while unlocking: %v", j.path, err)
+	}
+}
+
+// sortedEntries returns the entries ordered by the sequence number of their
+// latest put record.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) sortedEntries() []journalEntry {
+	entries := make([]journalEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].seq < entries[b].seq })
+	return entries
+}
+
+// compact rewrites the journal file with one put record per entry. The new
+// journal file is written next to the existing one and renamed over it, so
+// that a crash while compacting leaves one of them intact.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) compact() (err error) {
+	tmpPath := j.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, j.filePerm)
+	if err != nil {
+		return
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	for _, e := range j.sortedEntries() {
+		if err = encoder.Encode(record{Op: putOp, Info: e.info}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	err = errors.Join(err, tmpFile.Close())
+	if err == nil {
+		err = os.Rename(tmpPath, j.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return
+	}
+
+	err = j.reopen()
+	if err != nil {
+		return
+	}
+	j.numRecords = len(j.entries)
+	j.readOffset = j.fileStat.Size()
+	return
+}
+
+// reopen opens the journal file at j.path for appending records, in place of
+// the one opened so far.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) reopen() (err error) {
+	if j.file != nil {
+		if closeErr := j.file.Close(); closeErr != nil {
+			logger.Warnf("Journal %s: while closing replaced journal file: %v", j.path, closeErr)
+		}
+	}
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, j.filePerm)
+	if err == nil {
+		j.fileStat, err = j.file.Stat()
+	}
+	if err != nil {
+		if j.file != nil {
+			_ = j.file.Close()
+		}
+		j.file = nil
+	}
+	return
+}
+
+// append writes the given record to the journal file and applies it to
+// j.entries. The journal file is compacted if it has grown to more than twice
+// the number of entries.
+//
+// Requires LOCK(j.mu)
+func (j *Journal) append(r record, sync bool) (err error) {
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	n, err := j.file.Write(append(buf, '\n'))
+	j.readOffset += int64(n)
+	if err != nil {
+		return
+	}
+	if sync {
+		err = j.file.Sync()
+		if err != nil {
+			return
+		}
+	}
+	err = j.apply(r, false)
+	if err != nil {
+		return
+	}
+
+	if j.numRecords >= minRecordsForCompaction && j.numRecords > 2*len(j.entries) {
+		if compactErr := j.compact(); compactErr != nil {
+			logger.Warnf("Journal %s: while compacting: %v", j.path, compactErr)
+		}
+	}
+	return
+}
+
+// Put records the given data.FileInfo as the latest state of its entry in
+// file cache. The record is synced to disk before returning.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) Put(fileInfo data.FileInfo) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.lockShared(); err != nil {
+		return err
+	}
+	defer j.unlockShared()
+	return j.append(record{Op: putOp, Info: fileInfo}, true)
+}
+
+// PutIf records the given data.FileInfo like Put, if cond returns true for the
+// latest data.FileInfo recorded for its entry, and present tells whether
+// there is one. For a shared journal, cond is called while holding the lock
+// shared by the processes, so that no other process changes the entry in the
+// meantime. It returns whether the given data.FileInfo is recorded.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) PutIf(fileInfo data.FileInfo, cond func(current data.FileInfo, present bool) bool) (bool, error) {
+	keyName, err := fileInfo.Key.Key()
+	if err != nil {
+		return false, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err = j.lockShared(); err != nil {
+		return false, err
+	}
+	defer j.unlockShared()
+
+	current, present := j.entries[keyName]
+	if !cond(current.info, present) {
+		return false, nil
+	}
+	return true, j.append(record{Op: putOp, Info: fileInfo}, true)
+}
+
+// Get returns the latest data.FileInfo recorded for the entry with the given
+// key, and whether there is one.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) Get(key data.FileInfoKey) (fileInfo data.FileInfo, present bool, err error) {
+	keyName, err := key.Key()
+	if err != nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err = j.lockShared(); err != nil {
+		return
+	}
+	defer j.unlockShared()
+
+	e, present := j.entries[keyName]
+	return e.info, present, nil
+}
+
+// Erase records that the entry with the given key is removed from file cache.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) Erase(key data.FileInfoKey) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	keyName, err := key.Key()
+	if err != nil {
+		return err
+	}
+	if err = j.lockShared(); err != nil {
+		return err
+	}
+	defer j.unlockShared()
+
+	if _, ok := j.entries[keyName]; !ok {
+		return nil
+	}
+	return j.append(record{Op: eraseOp, Info: data.FileInfo{Key: key}}, false)
+}
+
+// Refresh catches up with the records appended to a shared journal by other
+// processes, and returns the entries changed by them since the last call: the
+// latest data.FileInfo of those present, ordered from the least to the most
+// recently put, and the keys of those erased. It returns nothing if the
+// journal isn't shared.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) Refresh() (puts []data.FileInfo, erased []data.FileInfoKey, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err = j.lockShared(); err != nil {
+		return
+	}
+	defer j.unlockShared()
+
+	var changed []journalEntry
+	for keyName, key := range j.changed {
+		if e, ok := j.entries[keyName]; ok {
+			changed = append(changed, e)
+		} else {
+			erased = append(erased, key)
+		}
+	}
+	sort.Slice(changed, func(a, b int) bool { return changed[a].seq < changed[b].seq })
+	for _, e := range changed {
+		puts = append(puts, e.info)
+	}
+	clear(j.changed)
+	return
+}
+
+// Entries returns the latest data.FileInfo of all the entries present in
+// journal, ordered from the least to the most recently put.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) Entries() []data.FileInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// The entries read so far are returned if the journal can't be read.
+	if err := j.lockShared(); err != nil {
+		logger.Warnf("Journal %s: %v", j.path, err)
+	} else {
+		defer j.unlockShared()
+	}
+
+	sorted := j.sortedEntries()
+	fileInfos := make([]data.FileInfo, 0, len(sorted))
+	for _, e := range sorted {
+		fileInfos = append(fileInfos, e.info)
+	}
+	return fileInfos
+}
+
+// Close compacts and closes the journal file. No records can be written after
+// closing the journal.
+//
+// Acquires and releases LOCK(j.mu)
+func (j *Journal) Close() (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	compactErr := j.lockShared()
+	if compactErr == nil {
+		compactErr = j.compact()
+		j.unlockShared()
+	}
+	if compactErr != nil {
+		logger.Warnf("Journal %s: while compacting: %v", j.path, compactErr)
+	}
+	if j.file != nil {
+		err = j.file.Close()
+		j.file = nil
+	}
+	if j.lockFile != nil {
+		err = errors.Join(err, j.lockFile.Close())
+	}
+	return
+}
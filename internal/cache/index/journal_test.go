@@ -0,0 +1,355 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/data"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
+	. "github.com/jacobsa/ogletest"
+)
+
+const TestBucketName = "test_bucket"
+
+func TestJournal(t *testing.T) { RunTests(t) }
+
+type journalTest struct {
+	dir     string
+	path    string
+	journal *Journal
+}
+
+func init() { RegisterTestSuite(&journalTest{}) }
+
+func (jt *journalTest) SetUp(*TestInfo) {
+	locker.EnableInvariantsCheck()
+	var err error
+	jt.dir, err = os.MkdirTemp("", "journal_test")
+	AssertEq(nil, err)
+	jt.path = path.Join(jt.dir, util.FileCacheIndex)
+	jt.journal, err = OpenJournal(jt.path, util.DefaultFilePerm)
+	AssertEq(nil, err)
+}
+
+func (jt *journalTest) TearDown() {
+	_ = jt.journal.Close()
+	_ = os.RemoveAll(jt.dir)
+}
+
+func getFileInfo(objectName string, offset uint64) data.FileInfo {
+	return data.FileInfo{
+		Key: data.FileInfoKey{
+			BucketName: TestBucketName,
+			ObjectName: objectName,
+		},
+		ObjectGeneration: 1234,
+		Offset:           offset,
+		FileSize:         10,
+	}
+}
+
+func (jt *journalTest) reopen() {
+	AssertEq(nil, jt.journal.Close())
+	var err error
+	jt.journal, err = OpenJournal(jt.path, util.DefaultFilePerm)
+	AssertEq(nil, err)
+}
+
+func (jt *journalTest) numLines() int {
+	f, err := os.Open(jt.path)
+	AssertEq(nil, err)
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	AssertEq(nil, scanner.Err())
+	return lines
+}
+
+func (jt *journalTest) Test_OpenJournal_CreatesFile() {
+	stat, err := os.Stat(jt.path)
+
+	AssertEq(nil, err)
+	ExpectEq(0, stat.Size())
+	ExpectEq(0, len(jt.journal.Entries()))
+}
+
+func (jt *journalTest) Test_Put() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 0)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("b", 0)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 10)))
+
+	entries := jt.journal.Entries()
+
+	AssertEq(2, len(entries))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("b", 0), entries[0]))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), entries[1]))
+	ExpectEq(3, jt.numLines())
+}
+
+func (jt *journalTest) Test_Erase() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 0)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("b", 0)))
+
+	err := jt.journal.Erase(getFileInfo("a", 0).Key)
+
+	AssertEq(nil, err)
+	entries := jt.journal.Entries()
+	AssertEq(1, len(entries))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("b", 0), entries[0]))
+	ExpectEq(3, jt.numLines())
+}
+
+func (jt *journalTest) Test_Erase_WhenEntryNotPresent() {
+	err := jt.journal.Erase(getFileInfo("a", 0).Key)
+
+	AssertEq(nil, err)
+	ExpectEq(0, jt.numLines())
+}
+
+func (jt *journalTest) Test_Erase_InvalidKey() {
+	err := jt.journal.Erase(data.FileInfoKey{BucketName: TestBucketName})
+
+	ExpectNe(nil, err)
+}
+
+func (jt *journalTest) Test_Reopen_RestoresEntriesInOrder() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 10)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("b", 0)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("c", 10)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 10)))
+	AssertEq(nil, jt.journal.Erase(getFileInfo("b", 0).Key))
+
+	jt.reopen()
+
+	entries := jt.journal.Entries()
+	AssertEq(2, len(entries))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("c", 10), entries[0]))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), entries[1]))
+	// Journal file is compacted to one record per entry.
+	ExpectEq(2, jt.numLines())
+}
+
+func (jt *journalTest) Test_Reopen_WithoutClose() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 10)))
+	AssertEq(nil, jt.journal.Put(getFileInfo("b", 10)))
+
+	// Journal is not closed, as in case of crash.
+	journal, err := OpenJournal(jt.path, util.DefaultFilePerm)
+
+	AssertEq(nil, err)
+	defer journal.Close()
+	entries := journal.Entries()
+	AssertEq(2, len(entries))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), entries[0]))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("b", 10), entries[1]))
+}
+
+func (jt *journalTest) Test_Reopen_IgnoresRecordsAfterCorruptedRecord() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 10)))
+	AssertEq(nil, jt.journal.Close())
+	f, err := os.OpenFile(jt.path, os.O_APPEND|os.O_WRONLY, 0)
+	AssertEq(nil, err)
+	_, err = f.WriteString("{\"op\":\"put\",\"info\":{\"Key\"\n")
+	AssertEq(nil, err)
+	_, err = f.WriteString("{\"op\":\"put\",\"info\":{\"Key\":{\"BucketName\":\"test_bucket\",\"ObjectName\":\"b\"}}}\n")
+	AssertEq(nil, err)
+	AssertEq(nil, f.Close())
+
+	jt.journal, err = OpenJournal(jt.path, util.DefaultFilePerm)
+
+	AssertEq(nil, err)
+	entries := jt.journal.Entries()
+	AssertEq(1, len(entries))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), entries[0]))
+	ExpectEq(1, jt.numLines())
+}
+
+func (jt *journalTest) Test_Put_CompactsJournal() {
+	for i := 0; i < minRecordsForCompaction; i++ {
+		AssertEq(nil, jt.journal.Put(getFileInfo("a", uint64(i%10))))
+	}
+
+	ExpectEq(1, len(jt.journal.Entries()))
+	ExpectEq(1, jt.numLines())
+}
+
+func (jt *journalTest) Test_Put_DoesNotCompactWhenMostRecordsAreLive() {
+	for i := 0; i < minRecordsForCompaction; i++ {
+		AssertEq(nil, jt.journal.Put(getFileInfo(fmt.Sprintf("object_%d", i), 0)))
+	}
+
+	ExpectEq(minRecordsForCompaction, len(jt.journal.Entries()))
+	ExpectEq(minRecordsForCompaction, jt.numLines())
+}
+
+func (jt *journalTest) Test_Put_AfterClose() {
+	AssertEq(nil, jt.journal.Close())
+
+	err := jt.journal.Put(getFileInfo("a", 0))
+
+	ExpectNe(nil, err)
+}
+
+func (jt *journalTest) Test_Get() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 10)))
+
+	fileInfo, present, err := jt.journal.Get(getFileInfo("a", 0).Key)
+
+	AssertEq(nil, err)
+	ExpectTrue(present)
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), fileInfo))
+	_, present, err = jt.journal.Get(getFileInfo("b", 0).Key)
+	AssertEq(nil, err)
+	ExpectFalse(present)
+}
+
+func (jt *journalTest) Test_PutIf() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 0)))
+
+	recorded, err := jt.journal.PutIf(getFileInfo("a", 10), func(current data.FileInfo, present bool) bool {
+		return present && current.Offset == 5
+	})
+
+	AssertEq(nil, err)
+	ExpectFalse(recorded)
+	recorded, err = jt.journal.PutIf(getFileInfo("a", 10), func(current data.FileInfo, present bool) bool {
+		return present && current.Offset == 0
+	})
+	AssertEq(nil, err)
+	ExpectTrue(recorded)
+	entries := jt.journal.Entries()
+	AssertEq(1, len(entries))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), entries[0]))
+}
+
+func (jt *journalTest) Test_Refresh_NotShared() {
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 0)))
+
+	puts, erased, err := jt.journal.Refresh()
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(puts))
+	ExpectEq(0, len(erased))
+	ExpectFalse(jt.journal.IsShared())
+}
+
+// share reopens jt.journal as a shared journal, and returns another shared
+// journal at the same path standing for another process.
+func (jt *journalTest) share() *Journal {
+	AssertEq(nil, jt.journal.Close())
+	var err error
+	jt.journal, err = OpenSharedJournal(jt.path, util.DefaultFilePerm)
+	AssertEq(nil, err)
+	other, err := OpenSharedJournal(jt.path, util.DefaultFilePerm)
+	AssertEq(nil, err)
+	return other
+}
+
+func (jt *journalTest) Test_Shared_RefreshReturnsChangesOfOtherProcesses() {
+	other := jt.share()
+	defer other.Close()
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 0)))
+	AssertEq(nil, other.Put(getFileInfo("b", 0)))
+	AssertEq(nil, other.Put(getFileInfo("c", 0)))
+	AssertEq(nil, other.Put(getFileInfo("b", 10)))
+	AssertEq(nil, other.Erase(getFileInfo("a", 0).Key))
+
+	puts, erased, err := jt.journal.Refresh()
+
+	AssertEq(nil, err)
+	ExpectTrue(jt.journal.IsShared())
+	AssertEq(2, len(puts))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("c", 0), puts[0]))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("b", 10), puts[1]))
+	AssertEq(1, len(erased))
+	ExpectEq("a", erased[0].ObjectName)
+	// The changes are returned once, and own changes not at all.
+	puts, erased, err = jt.journal.Refresh()
+	AssertEq(nil, err)
+	ExpectEq(0, len(puts)+len(erased))
+	// The entry put by jt.journal is erased since.
+	puts, erased, err = other.Refresh()
+	AssertEq(nil, err)
+	ExpectEq(0, len(puts))
+	AssertEq(1, len(erased))
+	ExpectEq("a", erased[0].ObjectName)
+}
+
+func (jt *journalTest) Test_Shared_GetReturnsPutByOtherProcess() {
+	other := jt.share()
+	defer other.Close()
+	AssertEq(nil, other.Put(getFileInfo("a", 10)))
+
+	fileInfo, present, err := jt.journal.Get(getFileInfo("a", 0).Key)
+
+	AssertEq(nil, err)
+	ExpectTrue(present)
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 10), fileInfo))
+}
+
+func (jt *journalTest) Test_Shared_CompactedByOtherProcess() {
+	other := jt.share()
+	AssertEq(nil, jt.journal.Put(getFileInfo("a", 0)))
+	AssertEq(nil, other.Put(getFileInfo("b", 0)))
+	AssertEq(nil, other.Erase(getFileInfo("a", 0).Key))
+	AssertEq(nil, other.Put(getFileInfo("c", 0)))
+
+	// Closing compacts the journal file into a new one.
+	AssertEq(nil, other.Close())
+	AssertEq(2, jt.numLines())
+	puts, erased, err := jt.journal.Refresh()
+
+	AssertEq(nil, err)
+	AssertEq(2, len(puts))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("b", 0), puts[0]))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("c", 0), puts[1]))
+	AssertEq(1, len(erased))
+	ExpectEq("a", erased[0].ObjectName)
+	// Records are appended to the new journal file.
+	AssertEq(nil, jt.journal.Put(getFileInfo("d", 0)))
+	ExpectEq(3, jt.numLines())
+}
+
+func (jt *journalTest) Test_Shared_DropsCorruptedRecordOfOtherProcess() {
+	other := jt.share()
+	defer other.Close()
+	AssertEq(nil, other.Put(getFileInfo("a", 0)))
+	f, err := os.OpenFile(jt.path, os.O_APPEND|os.O_WRONLY, 0)
+	AssertEq(nil, err)
+	_, err = f.WriteString("{\"op\":\"put\",\"info\":{\"Key\"")
+	AssertEq(nil, err)
+	AssertEq(nil, f.Close())
+
+	AssertEq(nil, jt.journal.Put(getFileInfo("b", 0)))
+	AssertEq(nil, other.Put(getFileInfo("c", 0)))
+
+	puts, _, err := jt.journal.Refresh()
+	AssertEq(nil, err)
+	AssertEq(2, len(puts))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("a", 0), puts[0]))
+	ExpectTrue(reflect.DeepEqual(getFileInfo("c", 0), puts[1]))
+	ExpectEq(3, len(other.Entries()))
+	ExpectEq(3, jt.numLines())
+}
@@ -15,10 +15,9 @@
 package lru
 
 import (
-	"container/list"
 	"errors"
 	"fmt"
-	"reflect"
+	"strings"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
 )
@@ -31,8 +30,9 @@ const (
 	EntryNotExistErrMsg            = "entry with given key does not exist"
 )
 
-// Cache is a LRU cache for any lru.ValueType indexed by string keys.
-// That means entry's value should be a lru.ValueType.
+// Cache is a cache for any lru.ValueType indexed by string keys, evicting
+// entries according to an EvictionPolicy, by default least recently used
+// first. That means entry's value should be a lru.ValueType.
 type Cache struct {
 	/////////////////////////
 	// Constant data
@@ -45,20 +45,21 @@ type Cache struct {
 	// Mutable state
 	/////////////////////////
 
-	// Sum of entry.Value.Size() of all the entries in the cache.
+	// Sum of Size() of all the values in the cache.
+	//
+	// INVARIANT: currentSize <= maxSize
 	currentSize uint64
 
-	// List of cache entries, with least recently used at the tail.
+	// The policy deciding which entries to evict.
 	//
-	// INVARIANT: currentSize <= maxSize
-	// INVARIANT: Each element is of type entry
-	entries list.List
+	// INVARIANT: policy.CheckInvariants() does not panic
+	// INVARIANT: Contains all and only the keys of index
+	policy EvictionPolicy
 
-	// Index of elements by name.
+	// Values of the cache entries by key.
 	//
-	// INVARIANT: For each k, v: v.Value.(entry).Key == k
-	// INVARIANT: Contains all and only the elements of entries
-	index map[string]*list.Element
+	// INVARIANT: Each value is non-nil
+	index map[string]ValueType
 
 	// All public methods of this Cache uses this RW mutex based locker while
 	// accessing/updating Cache's data.
@@ -69,17 +70,20 @@ type ValueType interface {
 	Size() uint64
 }
 
-type entry struct {
-	Key   string
-	Value ValueType
-}
-
 // NewCache returns the reference of cache object by initialising the cache with
-// the supplied maxSize, which must be greater than zero.
+// the supplied maxSize, which must be greater than zero. The cache evicts the
+// least recently used entries first.
 func NewCache(maxSize uint64) *Cache {
+	return NewCacheWithPolicy(maxSize, NewLRUPolicy())
+}
+
+// NewCacheWithPolicy is like NewCache, but evicts entries according to the
+// supplied policy, which must not be used by any other cache.
+func NewCacheWithPolicy(maxSize uint64, policy EvictionPolicy) *Cache {
 	c := &Cache{
 		maxSize: maxSize,
-		index:   make(map[string]*list.Element),
+		policy:  policy,
+		index:   make(map[string]ValueType),
 	}
 
 	// Set up invariant checking.
@@ -99,39 +103,42 @@ func (c *Cache) checkInvariants() {
 		panic(fmt.Sprintf("CurrentSize %v over maxSize %v", c.currentSize, c.maxSize))
 	}
 
-	// INVARIANT: Each element is of type entry
-	for e := c.entries.Front(); e != nil; e = e.Next() {
-		switch e.Value.(type) {
-		case entry:
-		default:
-			panic(fmt.Sprintf("Unexpected element type: %v", reflect.TypeOf(e.Value)))
-		}
-	}
+	// INVARIANT: policy.CheckInvariants() does not panic
+	c.policy.CheckInvariants()
 
-	// INVARIANT: For each k, v: v.Value.(entry).Key == k
-	// INVARIANT: Contains all and only the elements of entries
-	if c.entries.Len() != len(c.index) {
+	// INVARIANT: Contains all and only the keys of index
+	if c.policy.Len() != len(c.index) {
 		panic(fmt.Sprintf(
 			"Length mismatch: %v vs. %v",
-			c.entries.Len(),
+			c.policy.Len(),
 			len(c.index)))
 	}
 
-	for e := c.entries.Front(); e != nil; e = e.Next() {
-		if c.index[e.Value.(entry).Key] != e {
-			panic(fmt.Sprintf("Mismatch for key %v", e.Value.(entry).Key))
+	var size uint64
+	for k, v := range c.index {
+		if !c.policy.Contains(k) {
+			panic(fmt.Sprintf("Mismatch for key %v", k))
+		}
+
+		// INVARIANT: Each value is non-nil
+		if v == nil {
+			panic(fmt.Sprintf("Nil value for key %v", k))
 		}
+		size += v.Size()
+	}
+
+	if size != c.currentSize {
+		panic(fmt.Sprintf("CurrentSize %v, but entries take %v", c.currentSize, size))
 	}
 }
 
-func (c *Cache) evictOne() ValueType {
-	e := c.entries.Back()
-	key := e.Value.(entry).Key
+// Evict an entry other than the one with the given key, which has just been
+// inserted.
+func (c *Cache) evictOne(inserted string) ValueType {
+	key := c.policy.Evict(inserted)
 
-	evictedEntry := e.Value.(entry).Value
+	evictedEntry := c.index[key]
 	c.currentSize -= evictedEntry.Size()
-
-	c.entries.Remove(e)
 	delete(c.index, key)
 
 	return evictedEntry
@@ -159,24 +166,18 @@ func (c *Cache) Insert(
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	e, ok := c.index[key]
-	if ok {
+	if old, ok := c.index[key]; ok {
 		// Update an entry if already exist.
-		c.currentSize -= e.Value.(entry).Value.Size()
-		c.currentSize += valueSize
-		e.Value = entry{key, value}
-		c.entries.MoveToFront(e)
-	} else {
-		// Add the entry if already doesn't exist.
-		e := c.entries.PushFront(entry{key, value})
-		c.index[key] = e
-		c.currentSize += valueSize
+		c.currentSize -= old.Size()
 	}
+	c.index[key] = value
+	c.currentSize += valueSize
+	c.policy.Insert(key)
 
 	var evictedValues []ValueType
 	// Evict until we're at or below maxSize.
 	for c.currentSize > c.maxSize {
-		evictedValues = append(evictedValues, c.evictOne())
+		evictedValues = append(evictedValues, c.evictOne(key))
 	}
 
 	return evictedValues, nil
@@ -187,20 +188,36 @@ func (c *Cache) Erase(key string) (value ValueType) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	e, ok := c.index[key]
+	deletedEntry, ok := c.index[key]
 	if !ok {
 		return
 	}
 
-	deletedEntry := e.Value.(entry).Value
 	c.currentSize -= deletedEntry.Size()
 
 	delete(c.index, key)
-	c.entries.Remove(e)
+	c.policy.Remove(key)
 
 	return deletedEntry
 }
 
+// EraseEntriesWithGivenPrefix erases the entries whose keys start with the
+// given prefix. It takes time linear in the number of entries in the cache.
+func (c *Cache) EraseEntriesWithGivenPrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, value := range c.index {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		c.currentSize -= value.Size()
+		delete(c.index, key)
+		c.policy.Remove(key)
+	}
+}
+
 // LookUp a previously-inserted value for the given key. Return nil if no
 // value is present.
 func (c *Cache) LookUp(key string) (value ValueType) {
@@ -208,15 +225,15 @@ func (c *Cache) LookUp(key string) (value ValueType) {
 	defer c.mu.Unlock()
 
 	// Consult the index.
-	e, ok := c.index[key]
+	value, ok := c.index[key]
 	if !ok {
 		return
 	}
 	// This is now the most recently used entry.
-	c.entries.MoveToFront(e)
+	c.policy.Access(key)
 
 	// Return the value.
-	return e.Value.(entry).Value
+	return value
 }
 
 // LookUpWithoutChangingOrder looks up previously-inserted value for a given key
@@ -230,13 +247,7 @@ func (c *Cache) LookUpWithoutChangingOrder(key string) (value ValueType) {
 	defer c.mu.RUnlock()
 
 	// Consult the index.
-	e, ok := c.index[key]
-	if !ok {
-		return
-	}
-
-	// Return the value.
-	return e.Value.(entry).Value
+	return c.index[key]
 }
 
 // UpdateWithoutChangingOrder updates entry with the given key in cache with
@@ -253,17 +264,16 @@ func (c *Cache) UpdateWithoutChangingOrder(
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	e, ok := c.index[key]
+	old, ok := c.index[key]
 	if !ok {
 		return errors.New(EntryNotExistErrMsg)
 	}
 
-	if value.Size() != e.Value.(entry).Value.Size() {
+	if value.Size() != old.Size() {
 		return errors.New(InvalidUpdateEntrySizeErrorMsg)
 	}
 
-	e.Value = entry{key, value}
-	c.index[key] = e
+	c.index[key] = value
 
 	return nil
 }
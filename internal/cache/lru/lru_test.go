@@ -178,6 +178,21 @@ func (t *CacheTest) TestEraseWhenKeyNotPresent() {
 	ExpectEq(23, t.cache.LookUp("burrito").(testData).Value)
 }
 
+func (t *CacheTest) TestEraseEntriesWithGivenPrefix() {
+	t.insertAndAssert("a/burrito", testData{Value: 23, DataSize: 4}, []int64{}, nil)
+	t.insertAndAssert("a/b/taco", testData{Value: 26, DataSize: 4}, []int64{}, nil)
+	t.insertAndAssert("ab", testData{Value: 28, DataSize: 4}, []int64{}, nil)
+
+	t.cache.EraseEntriesWithGivenPrefix("a/")
+
+	ExpectEq(nil, t.cache.LookUp("a/burrito"))
+	ExpectEq(nil, t.cache.LookUp("a/b/taco"))
+	ExpectEq(28, t.cache.LookUp("ab").(testData).Value)
+	// The space of the erased entries is available again.
+	t.insertAndAssert("enchilada", testData{Value: 30, DataSize: 8}, []int64{}, nil)
+	ExpectEq(28, t.cache.LookUp("ab").(testData).Value)
+}
+
 func (t *CacheTest) TestUpdateWhenKeyPresent() {
 	key := "burrito"
 	data := testData{Value: 23, DataSize: 4}
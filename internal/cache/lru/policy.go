@@ -0,0 +1,557 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"time"
+
+	"github.com/jacobsa/timeutil"
+)
+
+// Names of the eviction policies, as given in config.
+const (
+	LRUPolicy      = "lru"
+	LFUPolicy      = "lfu"
+	TwoQueuePolicy = "2q"
+	TTLPolicy      = "ttl"
+)
+
+// EvictionPolicy decides which entry of a Cache to evict when it is over its
+// maximum size. It tracks the keys of the entries of the cache, which calls it
+// under its lock, so implementations needn't be safe for concurrent access.
+type EvictionPolicy interface {
+	// Insert records the insertion of an entry with the given key, either a new
+	// one or one replacing the value of an existing entry.
+	Insert(key string)
+
+	// Access records a look-up of the existing entry with the given key.
+	Access(key string)
+
+	// Remove forgets the entry with the given key, erased from the cache. It
+	// does nothing if there's no such entry.
+	Remove(key string)
+
+	// Evict forgets an entry other than the one with the key exclude, which has
+	// just been inserted, and returns its key. Called only when there is such an
+	// entry.
+	Evict(exclude string) string
+
+	// Contains returns whether there is an entry with the given key.
+	Contains(key string) bool
+
+	// Len returns the number of entries.
+	Len() int
+
+	// CheckInvariants panics if any internal invariants have been violated.
+	CheckInvariants()
+}
+
+// NewEvictionPolicy returns a new eviction policy with the given name, the LRU
+// policy if it's empty. The TTL policy treats entries inserted more than ttl
+// ago, by clock, as expired; the other policies ignore ttl and clock.
+func NewEvictionPolicy(name string, ttl time.Duration, clock timeutil.Clock) (EvictionPolicy, error) {
+	switch name {
+	case "", LRUPolicy:
+		return NewLRUPolicy(), nil
+	case LFUPolicy:
+		return NewLFUPolicy(), nil
+	case TwoQueuePolicy:
+		return NewTwoQueuePolicy(), nil
+	case TTLPolicy:
+		return NewTTLPolicy(ttl, clock), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy: %q", name)
+}
+
+// The element of l nearest to its back whose value isn't the key exclude.
+func backExcluding(l *list.List, exclude string) *list.Element {
+	e := l.Back()
+	if e != nil && e.Value.(string) == exclude {
+		e = e.Prev()
+	}
+	return e
+}
+
+// Check that index has all and only the elements of the lists, whose values
+// are their keys.
+func checkListIndex(index map[string]*list.Element, lists ...*list.List) {
+	n := 0
+	for _, l := range lists {
+		for e := l.Front(); e != nil; e = e.Next() {
+			key := e.Value.(string)
+			if index[key] != e {
+				panic(fmt.Sprintf("Mismatch for key %v", key))
+			}
+			n++
+		}
+	}
+	if n != len(index) {
+		panic(fmt.Sprintf("Length mismatch: %v vs. %v", n, len(index)))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// LRU
+////////////////////////////////////////////////////////////////////////
+
+type lruPolicy struct {
+	// Keys of the entries, with the least recently used at the back.
+	order list.List
+
+	// INVARIANT: For each k, v: v.Value.(string) == k
+	// INVARIANT: Contains all and only the elements of order
+	index map[string]*list.Element
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used entry.
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{index: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Insert(key string) {
+	if e, ok := p.index[key]; ok {
+		p.order.MoveToFront(e)
+		return
+	}
+	p.index[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) Access(key string) {
+	if e, ok := p.index[key]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) Remove(key string) {
+	if e, ok := p.index[key]; ok {
+		p.order.Remove(e)
+		delete(p.index, key)
+	}
+}
+
+func (p *lruPolicy) Evict(exclude string) string {
+	key := backExcluding(&p.order, exclude).Value.(string)
+	p.Remove(key)
+	return key
+}
+
+func (p *lruPolicy) Contains(key string) bool {
+	_, ok := p.index[key]
+	return ok
+}
+
+func (p *lruPolicy) Len() int {
+	return len(p.index)
+}
+
+func (p *lruPolicy) CheckInvariants() {
+	checkListIndex(p.index, &p.order)
+}
+
+////////////////////////////////////////////////////////////////////////
+// LFU
+////////////////////////////////////////////////////////////////////////
+
+type lfuEntry struct {
+	key string
+
+	// The number of insertions and look-ups of the entry.
+	uses uint64
+
+	// The value of the policy's clock at the last use.
+	lastUse uint64
+
+	// The position of the entry in the heap.
+	index int
+}
+
+// A min-heap of entries ordered by uses, then by last use.
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].uses != h[j].uses {
+		return h[i].uses < h[j].uses
+	}
+	return h[i].lastUse < h[j].lastUse
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+type lfuPolicy struct {
+	// Counts uses, to break ties between entries used as often.
+	clock uint64
+
+	entries lfuHeap
+
+	// INVARIANT: For each k, v: v.key == k && entries[v.index] == v
+	// INVARIANT: Contains all and only the elements of entries
+	index map[string]*lfuEntry
+}
+
+// NewLFUPolicy returns a policy evicting the least frequently used entry,
+// counting insertions and look-ups, and the least recently used of those used
+// as often.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{index: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) Insert(key string) {
+	if _, ok := p.index[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.clock++
+	e := &lfuEntry{key: key, uses: 1, lastUse: p.clock}
+	heap.Push(&p.entries, e)
+	p.index[key] = e
+}
+
+func (p *lfuPolicy) Access(key string) {
+	e, ok := p.index[key]
+	if !ok {
+		return
+	}
+	p.clock++
+	e.uses++
+	e.lastUse = p.clock
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	if e, ok := p.index[key]; ok {
+		heap.Remove(&p.entries, e.index)
+		delete(p.index, key)
+	}
+}
+
+func (p *lfuPolicy) Evict(exclude string) string {
+	// If the root is excluded, the next least frequently used entry is one of
+	// its children.
+	i := 0
+	if p.entries[0].key == exclude {
+		i = 1
+		if len(p.entries) > 2 && p.entries.Less(2, 1) {
+			i = 2
+		}
+	}
+
+	key := p.entries[i].key
+	p.Remove(key)
+	return key
+}
+
+func (p *lfuPolicy) Contains(key string) bool {
+	_, ok := p.index[key]
+	return ok
+}
+
+func (p *lfuPolicy) Len() int {
+	return len(p.index)
+}
+
+func (p *lfuPolicy) CheckInvariants() {
+	if len(p.entries) != len(p.index) {
+		panic(fmt.Sprintf("Length mismatch: %v vs. %v", len(p.entries), len(p.index)))
+	}
+	for i, e := range p.entries {
+		if e.index != i || p.index[e.key] != e {
+			panic(fmt.Sprintf("Mismatch for key %v", e.key))
+		}
+		if i > 0 && p.entries.Less(i, (i-1)/2) {
+			panic(fmt.Sprintf("Heap order violated for key %v", e.key))
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// 2Q
+////////////////////////////////////////////////////////////////////////
+
+// The share of the entries below which the probationary queue is not evicted
+// from, unless the protected one is empty, as a fraction 1/n.
+const probationShare = 4
+
+type twoQueueEntry struct {
+	elem      *list.Element
+	protected bool
+}
+
+type twoQueuePolicy struct {
+	// Keys of the entries used once since they were inserted, with the oldest
+	// at the back.
+	probation list.List
+
+	// Keys of the entries used again, with the least recently used at the back.
+	protected list.List
+
+	// Keys of the entries recently evicted from probation, with the oldest at
+	// the back. An entry inserted again while remembered here is protected
+	// straight away.
+	//
+	// INVARIANT: ghosts.Len() <= max(1, len(index))
+	ghosts list.List
+
+	// INVARIANT: For each k, v: v.elem.Value.(string) == k
+	// INVARIANT: For each k, v: v.elem is in protected iff v.protected
+	// INVARIANT: Contains all and only the elements of probation and protected
+	index map[string]*twoQueueEntry
+
+	// INVARIANT: Contains all and only the elements of ghosts
+	ghostIndex map[string]*list.Element
+}
+
+// NewTwoQueuePolicy returns a scan-resistant policy after 2Q: new entries are
+// held in a probationary queue, and only those used again move to a protected
+// queue. A scan of entries used once therefore evicts other such entries, not
+// the working set, as long as the probationary queue holds at least a quarter
+// of the entries.
+func NewTwoQueuePolicy() EvictionPolicy {
+	return &twoQueuePolicy{
+		index:      make(map[string]*twoQueueEntry),
+		ghostIndex: make(map[string]*list.Element),
+	}
+}
+
+func (p *twoQueuePolicy) Insert(key string) {
+	if _, ok := p.index[key]; ok {
+		p.Access(key)
+		return
+	}
+
+	if g, ok := p.ghostIndex[key]; ok {
+		p.ghosts.Remove(g)
+		delete(p.ghostIndex, key)
+		p.index[key] = &twoQueueEntry{elem: p.protected.PushFront(key), protected: true}
+		return
+	}
+	p.index[key] = &twoQueueEntry{elem: p.probation.PushFront(key)}
+}
+
+func (p *twoQueuePolicy) Access(key string) {
+	e, ok := p.index[key]
+	if !ok {
+		return
+	}
+	if !e.protected {
+		p.probation.Remove(e.elem)
+		e.elem = p.protected.PushFront(key)
+		e.protected = true
+		return
+	}
+	p.protected.MoveToFront(e.elem)
+}
+
+func (p *twoQueuePolicy) Remove(key string) {
+	e, ok := p.index[key]
+	if !ok {
+		return
+	}
+	if e.protected {
+		p.protected.Remove(e.elem)
+	} else {
+		p.probation.Remove(e.elem)
+	}
+	delete(p.index, key)
+	p.trimGhosts()
+}
+
+func (p *twoQueuePolicy) Evict(exclude string) string {
+	e := backExcluding(&p.probation, exclude)
+	fromProbation := e != nil
+	if e == nil || p.probation.Len()*probationShare <= p.Len() {
+		if f := backExcluding(&p.protected, exclude); f != nil {
+			e = f
+			fromProbation = false
+		}
+	}
+
+	key := e.Value.(string)
+	p.Remove(key)
+	if fromProbation {
+		p.ghostIndex[key] = p.ghosts.PushFront(key)
+		p.trimGhosts()
+	}
+	return key
+}
+
+func (p *twoQueuePolicy) trimGhosts() {
+	for p.ghosts.Len() > max(1, p.Len()) {
+		g := p.ghosts.Back()
+		p.ghosts.Remove(g)
+		delete(p.ghostIndex, g.Value.(string))
+	}
+}
+
+func (p *twoQueuePolicy) Contains(key string) bool {
+	_, ok := p.index[key]
+	return ok
+}
+
+func (p *twoQueuePolicy) Len() int {
+	return len(p.index)
+}
+
+func (p *twoQueuePolicy) CheckInvariants() {
+	if p.probation.Len()+p.protected.Len() != len(p.index) {
+		panic(fmt.Sprintf(
+			"Length mismatch: %v + %v vs. %v",
+			p.probation.Len(),
+			p.protected.Len(),
+			len(p.index)))
+	}
+	for protected, l := range []*list.List{&p.probation, &p.protected} {
+		for e := l.Front(); e != nil; e = e.Next() {
+			key := e.Value.(string)
+			if p.index[key] == nil || p.index[key].elem != e || p.index[key].protected != (protected == 1) {
+				panic(fmt.Sprintf("Mismatch for key %v", key))
+			}
+		}
+	}
+
+	checkListIndex(p.ghostIndex, &p.ghosts)
+	if p.ghosts.Len() > max(1, p.Len()) {
+		panic(fmt.Sprintf("Too many ghosts: %v for %v entries", p.ghosts.Len(), p.Len()))
+	}
+	for key := range p.ghostIndex {
+		if _, ok := p.index[key]; ok {
+			panic(fmt.Sprintf("Key %v is both an entry and a ghost", key))
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// TTL
+////////////////////////////////////////////////////////////////////////
+
+type ttlEntry struct {
+	inserted time.Time
+
+	// The elements of the entry in the policy's lists.
+	byUse       *list.Element
+	byInsertion *list.Element
+}
+
+type ttlPolicy struct {
+	ttl   time.Duration
+	clock timeutil.Clock
+
+	// Keys of the entries, with the least recently used at the back.
+	byUse list.List
+
+	// Keys of the entries, with the least recently inserted at the back.
+	byInsertion list.List
+
+	// INVARIANT: For each k, v: v.byUse.Value.(string) == k
+	// INVARIANT: For each k, v: v.byInsertion.Value.(string) == k
+	// INVARIANT: Contains all and only the elements of byUse and of byInsertion
+	index map[string]*ttlEntry
+}
+
+// NewTTLPolicy returns a policy evicting entries inserted more than ttl ago
+// first, the least recently inserted first, and otherwise the least recently
+// used entry. This suits caches whose values expire ttl after insertion, which
+// are dead weight until looked up again.
+func NewTTLPolicy(ttl time.Duration, clock timeutil.Clock) EvictionPolicy {
+	return &ttlPolicy{
+		ttl:   ttl,
+		clock: clock,
+		index: make(map[string]*ttlEntry),
+	}
+}
+
+func (p *ttlPolicy) Insert(key string) {
+	now := p.clock.Now()
+	if e, ok := p.index[key]; ok {
+		e.inserted = now
+		p.byUse.MoveToFront(e.byUse)
+		p.byInsertion.MoveToFront(e.byInsertion)
+		return
+	}
+	p.index[key] = &ttlEntry{
+		inserted:    now,
+		byUse:       p.byUse.PushFront(key),
+		byInsertion: p.byInsertion.PushFront(key),
+	}
+}
+
+func (p *ttlPolicy) Access(key string) {
+	if e, ok := p.index[key]; ok {
+		p.byUse.MoveToFront(e.byUse)
+	}
+}
+
+func (p *ttlPolicy) Remove(key string) {
+	if e, ok := p.index[key]; ok {
+		p.byUse.Remove(e.byUse)
+		p.byInsertion.Remove(e.byInsertion)
+		delete(p.index, key)
+	}
+}
+
+func (p *ttlPolicy) Evict(exclude string) string {
+	e := backExcluding(&p.byInsertion, exclude)
+	key := e.Value.(string)
+	if p.clock.Now().Sub(p.index[key].inserted) < p.ttl {
+		key = backExcluding(&p.byUse, exclude).Value.(string)
+	}
+	p.Remove(key)
+	return key
+}
+
+func (p *ttlPolicy) Contains(key string) bool {
+	_, ok := p.index[key]
+	return ok
+}
+
+func (p *ttlPolicy) Len() int {
+	return len(p.index)
+}
+
+func (p *ttlPolicy) CheckInvariants() {
+	if p.byUse.Len() != len(p.index) || p.byInsertion.Len() != len(p.index) {
+		panic(fmt.Sprintf(
+			"Length mismatch: %v, %v vs. %v",
+			p.byUse.Len(),
+			p.byInsertion.Len(),
+			len(p.index)))
+	}
+	for key, e := range p.index {
+		if e.byUse.Value.(string) != key || e.byInsertion.Value.(string) != key {
+			panic(fmt.Sprintf("Mismatch for key %v", key))
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestPolicy(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type PolicyTest struct {
+	clock timeutil.SimulatedClock
+}
+
+func init() { RegisterTestSuite(&PolicyTest{}) }
+
+func (t *PolicyTest) SetUp(*TestInfo) {
+	locker.EnableInvariantsCheck()
+	t.clock.SetTime(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func insertAll(p lru.EvictionPolicy, keys ...string) {
+	for _, key := range keys {
+		p.Insert(key)
+		p.CheckInvariants()
+	}
+}
+
+// evict evicts n entries, returning their keys.
+func evict(p lru.EvictionPolicy, n int) (keys []string) {
+	for i := 0; i < n; i++ {
+		keys = append(keys, p.Evict(""))
+		p.CheckInvariants()
+	}
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Test functions
+////////////////////////////////////////////////////////////////////////
+
+func (t *PolicyTest) NewEvictionPolicy() {
+	for _, name := range []string{lru.LRUPolicy, lru.LFUPolicy, lru.TwoQueuePolicy, lru.TTLPolicy} {
+		p, err := lru.NewEvictionPolicy(name, time.Minute, &t.clock)
+
+		AssertEq(nil, err)
+		ExpectNe(nil, p)
+	}
+
+	_, err := lru.NewEvictionPolicy("mru", time.Minute, &t.clock)
+	ExpectThat(err, Error(HasSubstr("unknown eviction policy")))
+}
+
+func (t *PolicyTest) LRU() {
+	p := lru.NewLRUPolicy()
+	insertAll(p, "a", "b", "c")
+	p.Access("a")
+
+	ExpectEq(fmt.Sprint([]string{"b", "c", "a"}), fmt.Sprint(evict(p, 3)))
+}
+
+func (t *PolicyTest) LRU_EvictExcludesInserted() {
+	p := lru.NewLRUPolicy()
+	insertAll(p, "a", "b")
+	p.Access("a")
+
+	ExpectEq("a", p.Evict("b"))
+}
+
+func (t *PolicyTest) LFU() {
+	p := lru.NewLFUPolicy()
+	insertAll(p, "a", "b", "c", "d")
+	p.Access("a")
+	p.Access("a")
+	p.Access("c")
+	p.Access("b")
+
+	// d and then c and b, used as often, the least recently used first.
+	ExpectEq(fmt.Sprint([]string{"d", "c", "b", "a"}), fmt.Sprint(evict(p, 4)))
+}
+
+func (t *PolicyTest) LFU_EvictExcludesInserted() {
+	p := lru.NewLFUPolicy()
+	insertAll(p, "a", "b", "c")
+	p.Access("a")
+	p.Access("b")
+	p.Access("b")
+
+	ExpectEq("a", p.Evict("c"))
+	p.CheckInvariants()
+	ExpectFalse(p.Contains("a"))
+	ExpectTrue(p.Contains("c"))
+}
+
+func (t *PolicyTest) LFU_Remove() {
+	p := lru.NewLFUPolicy()
+	insertAll(p, "a", "b", "c")
+
+	p.Remove("a")
+	p.Remove("z")
+
+	p.CheckInvariants()
+	ExpectEq(2, p.Len())
+	ExpectEq("b", p.Evict(""))
+}
+
+func (t *PolicyTest) TwoQueue_ScanDoesNotEvictWorkingSet() {
+	p := lru.NewTwoQueuePolicy()
+	insertAll(p, "a", "b", "c", "d")
+	for _, key := range []string{"a", "b", "c"} {
+		p.Access(key)
+	}
+
+	// A scan, keeping the number of entries at 4.
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("scan%d", i)
+		insertAll(p, key)
+		AssertNe("", p.Evict(key))
+		p.CheckInvariants()
+	}
+
+	ExpectTrue(p.Contains("a"))
+	ExpectTrue(p.Contains("b"))
+	ExpectTrue(p.Contains("c"))
+	ExpectTrue(p.Contains("scan9"))
+}
+
+func (t *PolicyTest) TwoQueue_EvictsProtectedWhenProbationIsSmall() {
+	p := lru.NewTwoQueuePolicy()
+	insertAll(p, "a", "b", "c", "d", "e")
+	for _, key := range []string{"a", "b", "c", "d"} {
+		p.Access(key)
+	}
+
+	// Only e is on probation, a fifth of the entries.
+	ExpectEq("a", p.Evict(""))
+}
+
+func (t *PolicyTest) TwoQueue_EvictedFromProbationIsProtectedWhenInsertedAgain() {
+	p := lru.NewTwoQueuePolicy()
+	insertAll(p, "a", "b")
+	AssertEq("a", p.Evict(""))
+
+	insertAll(p, "a", "c")
+
+	// a is protected, so the probationary b goes first.
+	ExpectEq(fmt.Sprint([]string{"b", "c", "a"}), fmt.Sprint(evict(p, 3)))
+}
+
+func (t *PolicyTest) TTL_EvictsExpiredFirst() {
+	p := lru.NewTTLPolicy(time.Minute, &t.clock)
+	insertAll(p, "a", "b")
+	t.clock.AdvanceTime(2 * time.Minute)
+	insertAll(p, "c")
+	p.Access("c")
+	p.Access("a")
+	p.Access("b")
+
+	// a and b have expired though recently used; then c as the least recently
+	// used of the rest.
+	ExpectEq(fmt.Sprint([]string{"a", "b", "c"}), fmt.Sprint(evict(p, 3)))
+}
+
+func (t *PolicyTest) TTL_EvictsLeastRecentlyUsedWhenNoneExpired() {
+	p := lru.NewTTLPolicy(time.Minute, &t.clock)
+	insertAll(p, "a", "b", "c")
+	p.Access("a")
+
+	ExpectEq("b", p.Evict(""))
+}
+
+func (t *PolicyTest) TTL_InsertAgainRenews() {
+	p := lru.NewTTLPolicy(time.Minute, &t.clock)
+	insertAll(p, "a", "b")
+	t.clock.AdvanceTime(2 * time.Minute)
+	insertAll(p, "a")
+	p.Access("b")
+
+	ExpectEq("b", p.Evict(""))
+}
+
+func (t *PolicyTest) CacheWithPolicy() {
+	cache := lru.NewCacheWithPolicy(30, lru.NewTwoQueuePolicy())
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := cache.Insert(key, testData{Value: 1, DataSize: 5})
+		AssertEq(nil, err)
+		AssertTrue(cache.LookUp(key) != nil)
+	}
+
+	// A scan, evicting only entries of the scan.
+	for i := 0; i < 10; i++ {
+		_, err := cache.Insert(fmt.Sprintf("scan%d", i), testData{Value: 2, DataSize: 5})
+		AssertEq(nil, err)
+	}
+
+	ExpectTrue(cache.LookUpWithoutChangingOrder("a") != nil)
+	ExpectTrue(cache.LookUpWithoutChangingOrder("b") != nil)
+	ExpectTrue(cache.LookUpWithoutChangingOrder("c") != nil)
+	ExpectTrue(cache.LookUpWithoutChangingOrder("scan9") != nil)
+	ExpectTrue(cache.LookUpWithoutChangingOrder("scan0") == nil)
+}
+
+func (t *PolicyTest) CacheWithPolicy_NeverEvictsInsertedEntry() {
+	cache := lru.NewCacheWithPolicy(10, lru.NewLFUPolicy())
+	_, err := cache.Insert("a", testData{Value: 1, DataSize: 5})
+	AssertEq(nil, err)
+	cache.LookUp("a")
+
+	evicted, err := cache.Insert("b", testData{Value: 2, DataSize: 10})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(evicted))
+	ExpectEq(1, evicted[0].(testData).Value)
+	ExpectTrue(cache.LookUp("b") != nil)
+}
@@ -45,6 +45,10 @@ type StatCache interface {
 	// Erase the entry for the given object name, if any.
 	Erase(name string)
 
+	// Erase the entries for the object names starting with the given prefix,
+	// e.g. those within a renamed folder.
+	EraseEntriesWithGivenPrefix(prefix string)
+
 	// Return the current entry for the given name, or nil if there is a negative
 	// entry. Return hit == false when there is neither a positive nor a negative
 	// entry, or the entry has expired according to the supplied current time.
@@ -181,6 +185,10 @@ func (sc *statCacheBucketView) Erase(objectName string) {
 	sc.sharedCache.Erase(name)
 }
 
+func (sc *statCacheBucketView) EraseEntriesWithGivenPrefix(prefix string) {
+	sc.sharedCache.EraseEntriesWithGivenPrefix(sc.key(prefix))
+}
+
 func (sc *statCacheBucketView) LookUp(
 	objectName string,
 	now time.Time) (hit bool, m *gcs.MinObject) {
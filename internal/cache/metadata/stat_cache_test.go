@@ -51,6 +51,10 @@ func (c *testHelperCache) Erase(name string) {
 	c.wrapped.Erase(name)
 }
 
+func (c *testHelperCache) EraseEntriesWithGivenPrefix(prefix string) {
+	c.wrapped.EraseEntriesWithGivenPrefix(prefix)
+}
+
 func (c *testHelperCache) LookUp(
 	name string,
 	now time.Time) (hit bool, m *gcs.MinObject) {
@@ -317,6 +321,18 @@ func (t *StatCacheTest) Overwrite_NegativeWithNegative() {
 	ExpectTrue(t.cache.NegativeEntry(name, someTime))
 }
 
+func (t *StatCacheTest) EraseEntriesWithGivenPrefix() {
+	t.cache.Insert(&gcs.MinObject{Name: "a/taco", Generation: 1}, expiration)
+	t.cache.AddNegativeEntry("a/b/burrito", expiration)
+	t.cache.Insert(&gcs.MinObject{Name: "enchilada", Generation: 1}, expiration)
+
+	t.cache.EraseEntriesWithGivenPrefix("a/")
+
+	ExpectFalse(t.cache.Hit("a/taco", someTime))
+	ExpectFalse(t.cache.Hit("a/b/burrito", someTime))
+	ExpectTrue(t.cache.Hit("enchilada", someTime))
+}
+
 // ///////////////////////////////////////////////////////////////
 // ////// Tests for multi-bucket cache scenarios /////////////////
 // ///////////////////////////////////////////////////////////////
@@ -135,6 +135,12 @@ type typeCache struct {
 // older entries are evicted according to the LRU-policy.
 // If either of TTL or maxSizeMB is zero, nothing is ever cached.
 func NewTypeCache(maxSizeMB int, ttl time.Duration) TypeCache {
+	return NewTypeCacheWithPolicy(maxSizeMB, ttl, lru.NewLRUPolicy())
+}
+
+// NewTypeCacheWithPolicy is like NewTypeCache, but evicts entries according to
+// the supplied policy instead of the LRU-policy.
+func NewTypeCacheWithPolicy(maxSizeMB int, ttl time.Duration, policy lru.EvictionPolicy) TypeCache {
 	if ttl > 0 && maxSizeMB != 0 {
 		var lruSizeInBytesToUse uint64 = math.MaxUint64 // default for when maxSizeMB = -1
 		if maxSizeMB > 0 {
@@ -142,7 +148,7 @@ func NewTypeCache(maxSizeMB int, ttl time.Duration) TypeCache {
 		}
 		return &typeCache{
 			ttl:     ttl,
-			entries: lru.NewCache(lruSizeInBytesToUse),
+			entries: lru.NewCacheWithPolicy(lruSizeInBytesToUse, policy),
 		}
 	}
 	return &typeCache{}
@@ -0,0 +1,56 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockFile places an exclusive lock on the given file, blocking until it is
+// acquired. The lock conflicts with the locks placed through every other open
+// file description of the file, by this or other processes, and is released
+// on UnlockFile or when the file is closed.
+func LockFile(file *os.File) error {
+	return flock(file, unix.LOCK_EX)
+}
+
+// TryLockFile is like LockFile, but returns false instead of blocking if the
+// lock is held through another open file description.
+func TryLockFile(file *os.File) (bool, error) {
+	err := flock(file, unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UnlockFile releases the lock placed by LockFile or TryLockFile.
+func UnlockFile(file *os.File) error {
+	return flock(file, unix.LOCK_UN)
+}
+
+func flock(file *os.File, how int) error {
+	for {
+		// The signals used by the Go runtime to preempt goroutines interrupt
+		// the blocking call.
+		err := unix.Flock(int(file.Fd()), how)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// PunchHole deallocates the space of range [offset, offset+length) of the
+// given file without changing its size, so that the range reads as zeros. It
+// is only supported on linux.
+func PunchHole(file *os.File, offset, length int64) error {
+	return syscall.ENOTSUP
+}
@@ -47,6 +47,7 @@ const (
 	DefaultFilePerm = os.FileMode(0600)
 	DefaultDirPerm  = os.FileMode(0700)
 	FileCache       = "gcsfuse-file-cache"
+	FileCacheIndex  = "gcsfuse-file-cache-index"
 )
 
 // CreateFile creates file with given file spec i.e. permissions and returns
@@ -267,6 +267,42 @@ func (ut *utilTest) Test_CalculateFileCRC32_ShouldReturnErrorForFileNotExist() {
 	ExpectEq(0, crc)
 }
 
+func (ut *utilTest) Test_TryLockFile_ConflictsWithLockThroughOtherOpenFile() {
+	file, err := CreateFile(ut.fileSpec, os.O_RDONLY)
+	AssertEq(nil, err)
+	defer file.Close()
+	other, err := os.Open(ut.fileSpec.Path)
+	AssertEq(nil, err)
+	defer other.Close()
+	AssertEq(nil, LockFile(file))
+
+	locked, err := TryLockFile(other)
+
+	AssertEq(nil, err)
+	ExpectFalse(locked)
+	AssertEq(nil, UnlockFile(file))
+	locked, err = TryLockFile(other)
+	AssertEq(nil, err)
+	ExpectTrue(locked)
+}
+
+func (ut *utilTest) Test_TryLockFile_LockReleasedOnClose() {
+	file, err := CreateFile(ut.fileSpec, os.O_RDONLY)
+	AssertEq(nil, err)
+	other, err := os.Open(ut.fileSpec.Path)
+	AssertEq(nil, err)
+	defer other.Close()
+	locked, err := TryLockFile(file)
+	AssertEq(nil, err)
+	AssertTrue(locked)
+
+	AssertEq(nil, file.Close())
+
+	locked, err = TryLockFile(other)
+	AssertEq(nil, err)
+	ExpectTrue(locked)
+}
+
 func Test_CreateCacheDirectoryIfNotPresentAt_ShouldNotReturnAnyErrorWhenDirectoryExists(t *testing.T) {
 	base := path.Join("./", string(testutil.GenerateRandomBytes(4)))
 	dirPath := path.Join(base, "/", "path/cachedir")
@@ -16,6 +16,7 @@ package config
 
 import (
 	"math"
+	"time"
 )
 
 const (
@@ -63,10 +64,133 @@ const (
 	DefaultReadRequestSizeMB          = 25
 	DefaultDownloadParallelismPerFile = 10
 	DefaultMaxDownloadParallelism     = -1
+	DefaultEnableSparseFile           = false
+	DefaultSparseFileChunkSizeMB      = 8
+	DefaultSharedCacheDir             = false
+
+	DefaultRenameDirParallelism = 16
+
+	DefaultEnableParallelUploads     = false
+	DefaultParallelUploadThresholdMB = 256
+	DefaultUploadPartSizeMB          = 64
+	DefaultUploadParallelismPerFile  = 8
+
+	DefaultEnableStreamingWrites = false
+
+	// UploadDirtyDataRecovery is the dirty-data-recovery mode where the dirty
+	// contents left behind by a crash are written out to their objects, unless
+	// the objects have been modified since.
+	UploadDirtyDataRecovery string = "upload"
+	// MoveDirtyDataRecovery is the dirty-data-recovery mode where the dirty
+	// contents left behind by a crash are moved into the recovery directory
+	// within the journal directory.
+	MoveDirtyDataRecovery string = "move"
+	// DefaultDirtyDataRecovery is the default value of dirty-data-recovery.
+	DefaultDirtyDataRecovery = UploadDirtyDataRecovery
+
+	DefaultAutoSyncInterval time.Duration = 0
+	DefaultAutoSyncDirtyMB                = 0
+
+	DefaultEnablePartialUpdates     = false
+	DefaultPartialUpdateThresholdMB = 1024
+	DefaultPartialUpdateBlockSizeMB = 64
+
+	DefaultEnableReadAhead      = false
+	DefaultReadAheadChunkSizeMB = 8
+	DefaultReadAheadMaxInFlight = 8
+	DefaultReadAheadMemoryMB    = 512
+
+	DefaultBlockCacheMaxSizeMB   = 0
+	DefaultBlockCacheBlockSizeMB = 1
+
+	DefaultBlockCachePromotionThreshold = 2
+
+	// DiscardConflictPolicy is the conflict-policy where the local changes to a
+	// file whose object has been clobbered remotely are discarded.
+	DiscardConflictPolicy string = "discard"
+	// ErrorConflictPolicy is the conflict-policy where syncing a file whose
+	// object has been clobbered remotely fails with ESTALE, and the local
+	// changes are kept until the file is closed.
+	ErrorConflictPolicy string = "error"
+	// ConflictObjectConflictPolicy is the conflict-policy where the local changes
+	// to a file whose object has been clobbered remotely are uploaded as a
+	// sibling conflict object.
+	ConflictObjectConflictPolicy string = "conflict-object"
+	// DefaultConflictPolicy is the default value of conflict-policy.
+	DefaultConflictPolicy = DiscardConflictPolicy
+
+	// LRUEvictionPolicy is the eviction-policy of a cache evicting the least
+	// recently used entries first.
+	LRUEvictionPolicy string = "lru"
+	// LFUEvictionPolicy is the eviction-policy of a cache evicting the least
+	// frequently used entries first.
+	LFUEvictionPolicy string = "lfu"
+	// TwoQueueEvictionPolicy is the scan-resistant eviction-policy of a cache
+	// evicting entries used only once before those used again.
+	TwoQueueEvictionPolicy string = "2q"
+	// TTLEvictionPolicy is the eviction-policy of a cache evicting entries
+	// inserted more than the metadata-cache ttl ago first, and then the least
+	// recently used ones.
+	TTLEvictionPolicy string = "ttl"
+	// DefaultEvictionPolicy is the default value of the eviction-policy of each
+	// cache.
+	DefaultEvictionPolicy = LRUEvictionPolicy
+
+	// LatencyFault delays a request by the latency of the fault rule.
+	LatencyFault string = "latency"
+	// TooManyRequestsFault fails a request with HTTP 429.
+	TooManyRequestsFault string = "http-429"
+	// ServiceUnavailableFault fails a request with HTTP 503.
+	ServiceUnavailableFault string = "http-503"
+	// PreconditionFault fails a request with a precondition error.
+	PreconditionFault string = "precondition"
+	// TruncateFault ends the stream returned by NewReader with an unexpected
+	// EOF after after-bytes bytes.
+	TruncateFault string = "truncate"
+	// StallFault blocks the stream returned by NewReader for the latency of the
+	// fault rule after after-bytes bytes.
+	StallFault string = "stall"
 )
 
 type WriteConfig struct {
-	CreateEmptyFile bool `yaml:"create-empty-file"`
+	CreateEmptyFile bool   `yaml:"create-empty-file"`
+	ConflictPolicy  string `yaml:"conflict-policy"`
+
+	// EnableParallelUploads makes files of at least ParallelUploadThresholdMB
+	// be written out as parallel composite uploads: parts of
+	// UploadPartSizeMB are uploaded as temporary objects,
+	// UploadParallelismPerFile at a time, then composed into the object.
+	EnableParallelUploads     bool `yaml:"enable-parallel-uploads"`
+	ParallelUploadThresholdMB int  `yaml:"parallel-upload-threshold-mb"`
+	UploadPartSizeMB          int  `yaml:"upload-part-size-mb"`
+	UploadParallelismPerFile  int  `yaml:"upload-parallelism-per-file"`
+
+	// EnableStreamingWrites makes the sequential writes to new files be
+	// streamed to their objects rather than staged in temp files.
+	EnableStreamingWrites bool `yaml:"enable-streaming-writes"`
+
+	// DirtyDataJournalDir, if set, is the directory in which the contents of
+	// files are kept along with manifests of those not yet synced, so that
+	// they survive a crash. They are then recovered as per DirtyDataRecovery
+	// the next time the bucket is mounted.
+	DirtyDataJournalDir string `yaml:"dirty-data-journal-dir"`
+	DirtyDataRecovery   string `yaml:"dirty-data-recovery"`
+
+	// AutoSyncInterval and AutoSyncDirtyMB make the dirty contents of files be
+	// written out in the background, without waiting for the files to be
+	// flushed, once they have been dirty for AutoSyncInterval or once
+	// AutoSyncDirtyMB of them are dirty. Zero disables either limit.
+	AutoSyncInterval time.Duration `yaml:"auto-sync-interval"`
+	AutoSyncDirtyMB  int           `yaml:"auto-sync-dirty-mb"`
+
+	// EnablePartialUpdates makes files of at least PartialUpdateThresholdMB be
+	// written out as blocks of PartialUpdateBlockSizeMB, kept as objects of
+	// their own and composed into the object, so that only the blocks
+	// containing modified ranges are uploaded again when the file is next
+	// written out. The blocks double the storage taken up by such files.
+	EnablePartialUpdates     bool `yaml:"enable-partial-updates"`
+	PartialUpdateThresholdMB int  `yaml:"partial-update-threshold-mb"`
+	PartialUpdateBlockSizeMB int  `yaml:"partial-update-block-size-mb"`
 }
 
 type LogConfig struct {
@@ -105,8 +229,11 @@ type EnableHNS bool
 type CacheDir string
 
 type FileSystemConfig struct {
-	IgnoreInterrupts      bool `yaml:"ignore-interrupts"`
-	DisableParallelDirops bool `yaml:"disable-parallel-dirops"`
+	IgnoreInterrupts        bool `yaml:"ignore-interrupts"`
+	DisableParallelDirops   bool `yaml:"disable-parallel-dirops"`
+	PreservePosixAttributes bool `yaml:"preserve-posix-attributes"`
+	EnableVersionsDir       bool `yaml:"enable-versions-dir"`
+	RenameDirParallelism    int  `yaml:"rename-dir-parallelism"`
 }
 
 type FileCacheConfig struct {
@@ -117,6 +244,73 @@ type FileCacheConfig struct {
 	MaxDownloadParallelism     int   `yaml:"max-download-parallelism,omitempty"`
 	ReadRequestSizeMB          int   `yaml:"read-request-size-mb,omitempty"`
 	EnableCrcCheck             bool  `yaml:"enable-crc-check"`
+	EnableSparseFile           bool  `yaml:"enable-sparse-file,omitempty"`
+	SparseFileChunkSizeMB      int   `yaml:"sparse-file-chunk-size-mb,omitempty"`
+	// EvictionPolicy is the eviction-policy of the file cache, any but ttl.
+	EvictionPolicy string `yaml:"eviction-policy"`
+	// SharedCacheDir makes the file cache safe to share with the other gcsfuse
+	// processes using the same cache-dir, which then keep it within
+	// MaxSizeMB together.
+	SharedCacheDir bool `yaml:"shared-cache-dir"`
+}
+
+// ReadConfig configures reads served from GCS rather than the file cache.
+type ReadConfig struct {
+	// EnableReadAhead makes sequential and strided reads of a file handle be
+	// served from chunks of ReadAheadChunkSizeMB fetched ahead of them, with up
+	// to ReadAheadMaxInFlight of them in flight per handle. The chunks of all
+	// handles together take at most ReadAheadMemoryMB of memory.
+	EnableReadAhead      bool `yaml:"enable-read-ahead"`
+	ReadAheadChunkSizeMB int  `yaml:"read-ahead-chunk-size-mb"`
+	ReadAheadMaxInFlight int  `yaml:"read-ahead-max-in-flight"`
+	ReadAheadMemoryMB    int  `yaml:"read-ahead-memory-mb"`
+
+	// BlockCacheMaxSizeMB is the memory budget of the cache of blocks of
+	// BlockCacheBlockSizeMB, which serves small reads which don't continue the
+	// previous read of a file handle. 0 disables the cache.
+	BlockCacheMaxSizeMB   int `yaml:"block-cache-max-size-mb"`
+	BlockCacheBlockSizeMB int `yaml:"block-cache-block-size-mb"`
+
+	// BlockCachePromotionThreshold is the number of reads after which a block
+	// demoted to the file cache, if enabled, is promoted back to memory. The
+	// blocks in the file cache count towards FileCacheConfig.MaxSizeMB.
+	BlockCachePromotionThreshold int `yaml:"block-cache-promotion-threshold"`
+}
+
+// FaultInjectionConfig makes the bucket misbehave on purpose, for testing how
+// workloads and gcsfuse cope with GCS errors and slowness. Faults are injected
+// above the GCS client, hence injected errors aren't retried by it.
+type FaultInjectionConfig struct {
+	// Seed seeds the choice of requests to inject faults into, for reproducible
+	// runs. Zero means a random seed.
+	Seed  int64       `yaml:"seed"`
+	Rules []FaultRule `yaml:"rules"`
+}
+
+// FaultRule injects a fault into matching requests with some probability.
+type FaultRule struct {
+	// Methods are the names of the gcs.Bucket methods the rule applies to, e.g.
+	// NewReader. Empty means all of them.
+	Methods []string `yaml:"methods"`
+
+	// ObjectPattern is a regular expression which the object name of a request
+	// must match for the rule to apply: the destination for CopyObject and
+	// ComposeObjects, and the prefix for ListObjects. Empty matches all names.
+	ObjectPattern string `yaml:"object-pattern"`
+
+	// Probability is the chance that the fault is injected into a matching
+	// request, in [0, 1].
+	Probability float64 `yaml:"probability"`
+
+	// Fault is one of latency, http-429, http-503, precondition, truncate and
+	// stall.
+	Fault string `yaml:"fault"`
+
+	// Latency is the delay for latency and stall faults.
+	Latency time.Duration `yaml:"latency"`
+
+	// AfterBytes is the number of bytes read before truncate and stall faults.
+	AfterBytes int64 `yaml:"after-bytes"`
 }
 
 type MetadataCacheConfig struct {
@@ -137,19 +331,26 @@ type MetadataCacheConfig struct {
 	// It can also be set to -1 for no-size-limit, 0 for
 	// no cache. Values below -1 are not supported.
 	StatCacheMaxSizeMB int64 `yaml:"stat-cache-max-size-mb,omitempty"`
+
+	// StatCacheEvictionPolicy and TypeCacheEvictionPolicy are the
+	// eviction-policies of stat-cache and of the type-cache of each directory.
+	StatCacheEvictionPolicy string `yaml:"stat-cache-eviction-policy"`
+	TypeCacheEvictionPolicy string `yaml:"type-cache-eviction-policy"`
 }
 
 type MountConfig struct {
-	WriteConfig         `yaml:"write"`
-	LogConfig           `yaml:"logging"`
-	FileCacheConfig     `yaml:"file-cache"`
-	CacheDir            `yaml:"cache-dir"`
-	MetadataCacheConfig `yaml:"metadata-cache"`
-	ListConfig          `yaml:"list"`
-	GCSConnection       `yaml:"gcs-connection"`
-	GCSAuth             `yaml:"gcs-auth"`
-	EnableHNS           `yaml:"enable-hns"`
-	FileSystemConfig    `yaml:"file-system"`
+	WriteConfig          `yaml:"write"`
+	LogConfig            `yaml:"logging"`
+	FileCacheConfig      `yaml:"file-cache"`
+	CacheDir             `yaml:"cache-dir"`
+	MetadataCacheConfig  `yaml:"metadata-cache"`
+	ListConfig           `yaml:"list"`
+	GCSConnection        `yaml:"gcs-connection"`
+	GCSAuth              `yaml:"gcs-auth"`
+	EnableHNS            `yaml:"enable-hns"`
+	FileSystemConfig     `yaml:"file-system"`
+	FaultInjectionConfig `yaml:"fault-injection"`
+	ReadConfig           `yaml:"read"`
 }
 
 // LogRotateConfig defines the parameters for log rotation. It consists of three
@@ -177,6 +378,20 @@ func DefaultLogRotateConfig() LogRotateConfig {
 
 func NewMountConfig() *MountConfig {
 	mountConfig := &MountConfig{}
+	mountConfig.WriteConfig = WriteConfig{
+		ConflictPolicy:            DefaultConflictPolicy,
+		EnableParallelUploads:     DefaultEnableParallelUploads,
+		ParallelUploadThresholdMB: DefaultParallelUploadThresholdMB,
+		UploadPartSizeMB:          DefaultUploadPartSizeMB,
+		UploadParallelismPerFile:  DefaultUploadParallelismPerFile,
+		EnableStreamingWrites:     DefaultEnableStreamingWrites,
+		DirtyDataRecovery:         DefaultDirtyDataRecovery,
+		AutoSyncInterval:          DefaultAutoSyncInterval,
+		AutoSyncDirtyMB:           DefaultAutoSyncDirtyMB,
+		EnablePartialUpdates:      DefaultEnablePartialUpdates,
+		PartialUpdateThresholdMB:  DefaultPartialUpdateThresholdMB,
+		PartialUpdateBlockSizeMB:  DefaultPartialUpdateBlockSizeMB,
+	}
 	mountConfig.LogConfig = LogConfig{
 		// Making the default severity as INFO.
 		Severity: INFO,
@@ -190,11 +405,18 @@ func NewMountConfig() *MountConfig {
 		MaxDownloadParallelism:     DefaultMaxDownloadParallelism,
 		ReadRequestSizeMB:          DefaultReadRequestSizeMB,
 		EnableCrcCheck:             DefaultEnableCrcCheck,
+		EnableSparseFile:           DefaultEnableSparseFile,
+		SparseFileChunkSizeMB:      DefaultSparseFileChunkSizeMB,
+		EvictionPolicy:             DefaultEvictionPolicy,
+		SharedCacheDir:             DefaultSharedCacheDir,
 	}
 	mountConfig.MetadataCacheConfig = MetadataCacheConfig{
 		TtlInSeconds:       TtlInSecsUnsetSentinel,
 		TypeCacheMaxSizeMB: DefaultTypeCacheMaxSizeMB,
 		StatCacheMaxSizeMB: StatCacheMaxSizeMBUnsetSentinel,
+
+		StatCacheEvictionPolicy: DefaultEvictionPolicy,
+		TypeCacheEvictionPolicy: DefaultEvictionPolicy,
 	}
 	mountConfig.ListConfig = ListConfig{
 		EnableEmptyManagedFolders: DefaultEnableEmptyManagedFoldersListing,
@@ -206,6 +428,20 @@ func NewMountConfig() *MountConfig {
 		AnonymousAccess: DefaultAnonymousAccess,
 	}
 	mountConfig.EnableHNS = DefaultEnableHNS
+	mountConfig.FileSystemConfig = FileSystemConfig{
+		RenameDirParallelism: DefaultRenameDirParallelism,
+	}
+	mountConfig.ReadConfig = ReadConfig{
+		EnableReadAhead:      DefaultEnableReadAhead,
+		ReadAheadChunkSizeMB: DefaultReadAheadChunkSizeMB,
+		ReadAheadMaxInFlight: DefaultReadAheadMaxInFlight,
+		ReadAheadMemoryMB:    DefaultReadAheadMemoryMB,
+
+		BlockCacheMaxSizeMB:   DefaultBlockCacheMaxSizeMB,
+		BlockCacheBlockSizeMB: DefaultBlockCacheBlockSizeMB,
+
+		BlockCachePromotionThreshold: DefaultBlockCachePromotionThreshold,
+	}
 
 	mountConfig.ListConfig = ListConfig{
 		KernelListCacheTtlSeconds: DefaultKernelListCacheTtlSeconds,
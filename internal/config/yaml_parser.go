@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
@@ -37,17 +39,41 @@ const (
 
 	parseConfigFileErrMsgFormat = "error parsing config file: %v"
 
-	MetadataCacheTtlSecsInvalidValueError       = "the value of ttl-secs for metadata-cache can't be less than -1"
-	MetadataCacheTtlSecsTooHighError            = "the value of ttl-secs in metadata-cache is too high to be supported. Max is 9223372036"
-	TypeCacheMaxSizeMBInvalidValueError         = "the value of type-cache-max-size-mb for metadata-cache can't be less than -1"
-	StatCacheMaxSizeMBInvalidValueError         = "the value of stat-cache-max-size-mb for metadata-cache can't be less than -1"
-	StatCacheMaxSizeMBTooHighError              = "the value of stat-cache-max-size-mb for metadata-cache is too high! Max supported: 17592186044415"
-	MaxSupportedStatCacheMaxSizeMB              = util.MaxMiBsInUint64
-	UnsupportedMetadataPrefixModeError          = "unsupported metadata-prefix-mode: \"%s\"; supported values: disabled, sync, async"
-	FileCacheMaxSizeMBInvalidValueError         = "the value of max-size-mb for file-cache can't be less than -1"
-	MaxDownloadParallelismInvalidValueError     = "the value of max-download-parallelism for file-cache can't be less than -1"
-	DownloadParallelismPerFileInvalidValueError = "the value of download-parallelism-per-file for file-cache can't be less than 1"
-	ReadRequestSizeMBInvalidValueError          = "the value of read-request-size-mb for file-cache can't be less than 1"
+	MetadataCacheTtlSecsInvalidValueError         = "the value of ttl-secs for metadata-cache can't be less than -1"
+	MetadataCacheTtlSecsTooHighError              = "the value of ttl-secs in metadata-cache is too high to be supported. Max is 9223372036"
+	TypeCacheMaxSizeMBInvalidValueError           = "the value of type-cache-max-size-mb for metadata-cache can't be less than -1"
+	StatCacheMaxSizeMBInvalidValueError           = "the value of stat-cache-max-size-mb for metadata-cache can't be less than -1"
+	StatCacheMaxSizeMBTooHighError                = "the value of stat-cache-max-size-mb for metadata-cache is too high! Max supported: 17592186044415"
+	MaxSupportedStatCacheMaxSizeMB                = util.MaxMiBsInUint64
+	UnsupportedMetadataPrefixModeError            = "unsupported metadata-prefix-mode: \"%s\"; supported values: disabled, sync, async"
+	FileCacheMaxSizeMBInvalidValueError           = "the value of max-size-mb for file-cache can't be less than -1"
+	MaxDownloadParallelismInvalidValueError       = "the value of max-download-parallelism for file-cache can't be less than -1"
+	DownloadParallelismPerFileInvalidValueError   = "the value of download-parallelism-per-file for file-cache can't be less than 1"
+	ReadRequestSizeMBInvalidValueError            = "the value of read-request-size-mb for file-cache can't be less than 1"
+	SparseFileChunkSizeMBInvalidValueError        = "the value of sparse-file-chunk-size-mb for file-cache can't be less than 1"
+	UnsupportedConflictPolicyError                = "unsupported conflict-policy: \"%s\"; supported values: discard, error, conflict-object"
+	UnsupportedEvictionPolicyError                = "unsupported %s: \"%s\"; supported values: lru, lfu, 2q, ttl"
+	UnsupportedFileCacheEvictionPolicyError       = "unsupported eviction-policy for file-cache: \"%s\"; supported values: lru, lfu, 2q"
+	ParallelUploadThresholdMBInvalidValueError    = "the value of parallel-upload-threshold-mb for write can't be less than 1"
+	UploadPartSizeMBInvalidValueError             = "the value of upload-part-size-mb for write can't be less than 1"
+	UploadParallelismPerFileInvalidValueError     = "the value of upload-parallelism-per-file for write can't be less than 1"
+	UnsupportedDirtyDataRecoveryError             = "unsupported dirty-data-recovery: \"%s\"; supported values: upload, move"
+	AutoSyncIntervalInvalidValueError             = "the value of auto-sync-interval for write can't be negative"
+	AutoSyncDirtyMBInvalidValueError              = "the value of auto-sync-dirty-mb for write can't be negative"
+	PartialUpdateThresholdMBInvalidValueError     = "the value of partial-update-threshold-mb for write can't be less than 1"
+	PartialUpdateBlockSizeMBInvalidValueError     = "the value of partial-update-block-size-mb for write can't be less than 1"
+	RenameDirParallelismInvalidValueError         = "the value of rename-dir-parallelism for file-system can't be less than 1"
+	ReadAheadChunkSizeMBInvalidValueError         = "the value of read-ahead-chunk-size-mb for read can't be less than 1"
+	ReadAheadMaxInFlightInvalidValueError         = "the value of read-ahead-max-in-flight for read can't be less than 1"
+	ReadAheadMemoryMBInvalidValueError            = "the value of read-ahead-memory-mb for read can't be less than read-ahead-chunk-size-mb"
+	BlockCacheMaxSizeMBInvalidValueError          = "the value of block-cache-max-size-mb for read can't be negative, or less than block-cache-block-size-mb unless 0"
+	BlockCacheBlockSizeMBInvalidValueError        = "the value of block-cache-block-size-mb for read can't be less than 1"
+	BlockCachePromotionThresholdInvalidValueError = "the value of block-cache-promotion-threshold for read can't be less than 1"
+	UnsupportedFaultError                         = "unsupported fault: \"%s\"; supported values: latency, http-429, http-503, precondition, truncate, stall"
+	UnsupportedFaultMethodError                   = "unsupported method: \"%s\"; supported values: NewReader, CreateObject, CopyObject, ComposeObjects, StatObject, ListObjects, UpdateObject, DeleteObject, DeleteFolder, GetFolder, CreateFolder, RenameFolder"
+	FaultProbabilityInvalidValueError             = "the value of probability for a fault rule must be in [0, 1]"
+	FaultLatencyInvalidValueError                 = "the value of latency for a latency or stall fault rule must be positive"
+	FaultAfterBytesInvalidValueError              = "the value of after-bytes for a fault rule can't be negative"
 )
 
 func IsValidLogSeverity(severity LogSeverity) bool {
@@ -87,9 +113,24 @@ func (fileCacheConfig *FileCacheConfig) validate() error {
 	if fileCacheConfig.ReadRequestSizeMB < 1 {
 		return fmt.Errorf(ReadRequestSizeMBInvalidValueError)
 	}
+	if fileCacheConfig.SparseFileChunkSizeMB < 1 {
+		return fmt.Errorf(SparseFileChunkSizeMBInvalidValueError)
+	}
+	// The file cache has no ttl to expire entries by.
+	if !isValidEvictionPolicy(fileCacheConfig.EvictionPolicy) || fileCacheConfig.EvictionPolicy == TTLEvictionPolicy {
+		return fmt.Errorf(UnsupportedFileCacheEvictionPolicyError, fileCacheConfig.EvictionPolicy)
+	}
 	return nil
 }
 
+func isValidEvictionPolicy(policy string) bool {
+	switch policy {
+	case LRUEvictionPolicy, LFUEvictionPolicy, TwoQueueEvictionPolicy, TTLEvictionPolicy:
+		return true
+	}
+	return false
+}
+
 func (metadataCacheConfig *MetadataCacheConfig) validate() error {
 	if metadataCacheConfig.TtlInSeconds != TtlInSecsUnsetSentinel {
 		if metadataCacheConfig.TtlInSeconds < -1 {
@@ -111,6 +152,79 @@ func (metadataCacheConfig *MetadataCacheConfig) validate() error {
 			return fmt.Errorf(StatCacheMaxSizeMBTooHighError)
 		}
 	}
+	if !isValidEvictionPolicy(metadataCacheConfig.StatCacheEvictionPolicy) {
+		return fmt.Errorf(UnsupportedEvictionPolicyError, "stat-cache-eviction-policy", metadataCacheConfig.StatCacheEvictionPolicy)
+	}
+	if !isValidEvictionPolicy(metadataCacheConfig.TypeCacheEvictionPolicy) {
+		return fmt.Errorf(UnsupportedEvictionPolicyError, "type-cache-eviction-policy", metadataCacheConfig.TypeCacheEvictionPolicy)
+	}
+	return nil
+}
+
+func (writeConfig *WriteConfig) validate() error {
+	switch writeConfig.ConflictPolicy {
+	case DiscardConflictPolicy, ErrorConflictPolicy, ConflictObjectConflictPolicy:
+	default:
+		return fmt.Errorf(UnsupportedConflictPolicyError, writeConfig.ConflictPolicy)
+	}
+
+	if writeConfig.ParallelUploadThresholdMB < 1 {
+		return fmt.Errorf(ParallelUploadThresholdMBInvalidValueError)
+	}
+	if writeConfig.UploadPartSizeMB < 1 {
+		return fmt.Errorf(UploadPartSizeMBInvalidValueError)
+	}
+	if writeConfig.UploadParallelismPerFile < 1 {
+		return fmt.Errorf(UploadParallelismPerFileInvalidValueError)
+	}
+
+	switch writeConfig.DirtyDataRecovery {
+	case UploadDirtyDataRecovery, MoveDirtyDataRecovery:
+	default:
+		return fmt.Errorf(UnsupportedDirtyDataRecoveryError, writeConfig.DirtyDataRecovery)
+	}
+
+	if writeConfig.AutoSyncInterval < 0 {
+		return fmt.Errorf(AutoSyncIntervalInvalidValueError)
+	}
+	if writeConfig.AutoSyncDirtyMB < 0 {
+		return fmt.Errorf(AutoSyncDirtyMBInvalidValueError)
+	}
+	if writeConfig.PartialUpdateThresholdMB < 1 {
+		return fmt.Errorf(PartialUpdateThresholdMBInvalidValueError)
+	}
+	if writeConfig.PartialUpdateBlockSizeMB < 1 {
+		return fmt.Errorf(PartialUpdateBlockSizeMBInvalidValueError)
+	}
+	return nil
+}
+
+func (fileSystemConfig *FileSystemConfig) validate() error {
+	if fileSystemConfig.RenameDirParallelism < 1 {
+		return fmt.Errorf(RenameDirParallelismInvalidValueError)
+	}
+	return nil
+}
+
+func (readConfig *ReadConfig) validate() error {
+	if readConfig.ReadAheadChunkSizeMB < 1 {
+		return fmt.Errorf(ReadAheadChunkSizeMBInvalidValueError)
+	}
+	if readConfig.ReadAheadMaxInFlight < 1 {
+		return fmt.Errorf(ReadAheadMaxInFlightInvalidValueError)
+	}
+	if readConfig.ReadAheadMemoryMB < readConfig.ReadAheadChunkSizeMB {
+		return fmt.Errorf(ReadAheadMemoryMBInvalidValueError)
+	}
+	if readConfig.BlockCacheBlockSizeMB < 1 {
+		return fmt.Errorf(BlockCacheBlockSizeMBInvalidValueError)
+	}
+	if readConfig.BlockCacheMaxSizeMB != 0 && readConfig.BlockCacheMaxSizeMB < readConfig.BlockCacheBlockSizeMB {
+		return fmt.Errorf(BlockCacheMaxSizeMBInvalidValueError)
+	}
+	if readConfig.BlockCachePromotionThreshold < 1 {
+		return fmt.Errorf(BlockCachePromotionThresholdInvalidValueError)
+	}
 	return nil
 }
 
@@ -129,6 +243,61 @@ func (listConfig *ListConfig) validate() error {
 	return nil
 }
 
+// FaultMethods are the names of the bucket methods that faults can be injected
+// into.
+var FaultMethods = []string{
+	"NewReader",
+	"CreateObject",
+	"CopyObject",
+	"ComposeObjects",
+	"StatObject",
+	"ListObjects",
+	"UpdateObject",
+	"DeleteObject",
+	"DeleteFolder",
+	"GetFolder",
+	"CreateFolder",
+	"RenameFolder",
+}
+
+func (rule *FaultRule) validate() error {
+	switch rule.Fault {
+	case TooManyRequestsFault, ServiceUnavailableFault, PreconditionFault, TruncateFault:
+	case LatencyFault, StallFault:
+		if rule.Latency <= 0 {
+			return fmt.Errorf(FaultLatencyInvalidValueError)
+		}
+	default:
+		return fmt.Errorf(UnsupportedFaultError, rule.Fault)
+	}
+
+	for _, method := range rule.Methods {
+		if !slices.Contains(FaultMethods, method) {
+			return fmt.Errorf(UnsupportedFaultMethodError, method)
+		}
+	}
+
+	if _, err := regexp.Compile(rule.ObjectPattern); err != nil {
+		return fmt.Errorf("invalid object-pattern: %w", err)
+	}
+	if rule.Probability < 0 || rule.Probability > 1 {
+		return fmt.Errorf(FaultProbabilityInvalidValueError)
+	}
+	if rule.AfterBytes < 0 {
+		return fmt.Errorf(FaultAfterBytesInvalidValueError)
+	}
+	return nil
+}
+
+func (faultInjectionConfig *FaultInjectionConfig) validate() error {
+	for i := range faultInjectionConfig.Rules {
+		if err := faultInjectionConfig.Rules[i].validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
 func ParseConfigFile(fileName string) (mountConfig *MountConfig, err error) {
 	mountConfig = NewMountConfig()
 
@@ -166,6 +335,14 @@ func ParseConfigFile(fileName string) (mountConfig *MountConfig, err error) {
 		return
 	}
 
+	if err = mountConfig.WriteConfig.validate(); err != nil {
+		return mountConfig, fmt.Errorf("error parsing write configs: %w", err)
+	}
+
+	if err = mountConfig.FileSystemConfig.validate(); err != nil {
+		return mountConfig, fmt.Errorf("error parsing file-system configs: %w", err)
+	}
+
 	if err = mountConfig.FileCacheConfig.validate(); err != nil {
 		return mountConfig, fmt.Errorf("error parsing file-cache configs: %w", err)
 	}
@@ -182,5 +359,13 @@ func ParseConfigFile(fileName string) (mountConfig *MountConfig, err error) {
 		return mountConfig, fmt.Errorf("error parsing list config: %w", err)
 	}
 
+	if err = mountConfig.FaultInjectionConfig.validate(); err != nil {
+		return mountConfig, fmt.Errorf("error parsing fault-injection config: %w", err)
+	}
+
+	if err = mountConfig.ReadConfig.validate(); err != nil {
+		return mountConfig, fmt.Errorf("error parsing read config: %w", err)
+	}
+
 	return
 }
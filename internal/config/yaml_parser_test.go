@@ -17,6 +17,7 @@ package config
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -32,6 +33,19 @@ func TestYamlParserSuite(t *testing.T) {
 func validateDefaultConfig(t *testing.T, mountConfig *MountConfig) {
 	assert.NotNil(t, mountConfig)
 	assert.False(t, mountConfig.CreateEmptyFile)
+	assert.Equal(t, DiscardConflictPolicy, mountConfig.WriteConfig.ConflictPolicy)
+	assert.False(t, mountConfig.WriteConfig.EnableParallelUploads)
+	assert.Equal(t, DefaultParallelUploadThresholdMB, mountConfig.WriteConfig.ParallelUploadThresholdMB)
+	assert.Equal(t, DefaultUploadPartSizeMB, mountConfig.WriteConfig.UploadPartSizeMB)
+	assert.Equal(t, DefaultUploadParallelismPerFile, mountConfig.WriteConfig.UploadParallelismPerFile)
+	assert.False(t, mountConfig.WriteConfig.EnableStreamingWrites)
+	assert.Equal(t, "", mountConfig.WriteConfig.DirtyDataJournalDir)
+	assert.Equal(t, UploadDirtyDataRecovery, mountConfig.WriteConfig.DirtyDataRecovery)
+	assert.Equal(t, time.Duration(0), mountConfig.WriteConfig.AutoSyncInterval)
+	assert.Equal(t, 0, mountConfig.WriteConfig.AutoSyncDirtyMB)
+	assert.False(t, mountConfig.WriteConfig.EnablePartialUpdates)
+	assert.Equal(t, DefaultPartialUpdateThresholdMB, mountConfig.WriteConfig.PartialUpdateThresholdMB)
+	assert.Equal(t, DefaultPartialUpdateBlockSizeMB, mountConfig.WriteConfig.PartialUpdateBlockSizeMB)
 	assert.False(t, mountConfig.ListConfig.EnableEmptyManagedFolders)
 	assert.Equal(t, "INFO", string(mountConfig.LogConfig.Severity))
 	assert.Equal(t, "", mountConfig.LogConfig.Format)
@@ -47,12 +61,28 @@ func validateDefaultConfig(t *testing.T, mountConfig *MountConfig) {
 	assert.Equal(t, -1, mountConfig.FileCacheConfig.MaxDownloadParallelism)
 	assert.Equal(t, 25, mountConfig.FileCacheConfig.ReadRequestSizeMB)
 	assert.True(t, mountConfig.FileCacheConfig.EnableCrcCheck)
+	assert.False(t, mountConfig.FileCacheConfig.EnableSparseFile)
+	assert.Equal(t, 8, mountConfig.FileCacheConfig.SparseFileChunkSizeMB)
+	assert.Equal(t, "lru", mountConfig.FileCacheConfig.EvictionPolicy)
+	assert.False(t, mountConfig.FileCacheConfig.SharedCacheDir)
+	assert.Equal(t, "lru", mountConfig.MetadataCacheConfig.StatCacheEvictionPolicy)
+	assert.Equal(t, "lru", mountConfig.MetadataCacheConfig.TypeCacheEvictionPolicy)
 	assert.Equal(t, 1, mountConfig.GCSConnection.GRPCConnPoolSize)
 	assert.False(t, mountConfig.GCSAuth.AnonymousAccess)
 	assert.False(t, bool(mountConfig.EnableHNS))
 	assert.False(t, mountConfig.FileSystemConfig.IgnoreInterrupts)
 	assert.False(t, mountConfig.FileSystemConfig.DisableParallelDirops)
+	assert.False(t, mountConfig.FileSystemConfig.PreservePosixAttributes)
+	assert.False(t, mountConfig.FileSystemConfig.EnableVersionsDir)
+	assert.Equal(t, DefaultRenameDirParallelism, mountConfig.FileSystemConfig.RenameDirParallelism)
 	assert.Equal(t, DefaultKernelListCacheTtlSeconds, mountConfig.KernelListCacheTtlSeconds)
+	assert.False(t, mountConfig.ReadConfig.EnableReadAhead)
+	assert.Equal(t, DefaultReadAheadChunkSizeMB, mountConfig.ReadConfig.ReadAheadChunkSizeMB)
+	assert.Equal(t, DefaultReadAheadMaxInFlight, mountConfig.ReadConfig.ReadAheadMaxInFlight)
+	assert.Equal(t, DefaultReadAheadMemoryMB, mountConfig.ReadConfig.ReadAheadMemoryMB)
+	assert.Equal(t, DefaultBlockCacheMaxSizeMB, mountConfig.ReadConfig.BlockCacheMaxSizeMB)
+	assert.Equal(t, DefaultBlockCacheBlockSizeMB, mountConfig.ReadConfig.BlockCacheBlockSizeMB)
+	assert.Equal(t, DefaultBlockCachePromotionThreshold, mountConfig.ReadConfig.BlockCachePromotionThreshold)
 }
 
 func (t *YamlParserTest) TestReadConfigFile_EmptyFileName() {
@@ -107,6 +137,19 @@ func (t *YamlParserTest) TestReadConfigFile_ValidConfig() {
 	assert.NoError(t.T(), err)
 	assert.NotNil(t.T(), mountConfig)
 	assert.True(t.T(), mountConfig.WriteConfig.CreateEmptyFile)
+	assert.Equal(t.T(), ConflictObjectConflictPolicy, mountConfig.WriteConfig.ConflictPolicy)
+	assert.True(t.T(), mountConfig.WriteConfig.EnableParallelUploads)
+	assert.Equal(t.T(), 512, mountConfig.WriteConfig.ParallelUploadThresholdMB)
+	assert.Equal(t.T(), 32, mountConfig.WriteConfig.UploadPartSizeMB)
+	assert.Equal(t.T(), 4, mountConfig.WriteConfig.UploadParallelismPerFile)
+	assert.True(t.T(), mountConfig.WriteConfig.EnableStreamingWrites)
+	assert.Equal(t.T(), "/tmp/dirty-data", mountConfig.WriteConfig.DirtyDataJournalDir)
+	assert.Equal(t.T(), MoveDirtyDataRecovery, mountConfig.WriteConfig.DirtyDataRecovery)
+	assert.Equal(t.T(), 5*time.Minute, mountConfig.WriteConfig.AutoSyncInterval)
+	assert.Equal(t.T(), 64, mountConfig.WriteConfig.AutoSyncDirtyMB)
+	assert.True(t.T(), mountConfig.WriteConfig.EnablePartialUpdates)
+	assert.Equal(t.T(), 2048, mountConfig.WriteConfig.PartialUpdateThresholdMB)
+	assert.Equal(t.T(), 16, mountConfig.WriteConfig.PartialUpdateBlockSizeMB)
 	assert.Equal(t.T(), ERROR, mountConfig.LogConfig.Severity)
 	assert.Equal(t.T(), "/tmp/logfile.json", mountConfig.LogConfig.FilePath)
 	assert.Equal(t.T(), "text", mountConfig.LogConfig.Format)
@@ -120,6 +163,8 @@ func (t *YamlParserTest) TestReadConfigFile_ValidConfig() {
 	assert.Equal(t.T(), int64(5), mountConfig.MetadataCacheConfig.TtlInSeconds)
 	assert.Equal(t.T(), 1, mountConfig.MetadataCacheConfig.TypeCacheMaxSizeMB)
 	assert.Equal(t.T(), int64(3), mountConfig.MetadataCacheConfig.StatCacheMaxSizeMB)
+	assert.Equal(t.T(), TwoQueueEvictionPolicy, mountConfig.MetadataCacheConfig.StatCacheEvictionPolicy)
+	assert.Equal(t.T(), TTLEvictionPolicy, mountConfig.MetadataCacheConfig.TypeCacheEvictionPolicy)
 
 	// list config
 	assert.True(t.T(), mountConfig.ListConfig.EnableEmptyManagedFolders)
@@ -133,6 +178,18 @@ func (t *YamlParserTest) TestReadConfigFile_ValidConfig() {
 	// file-system config
 	assert.True(t.T(), mountConfig.FileSystemConfig.IgnoreInterrupts)
 	assert.True(t.T(), mountConfig.FileSystemConfig.DisableParallelDirops)
+	assert.True(t.T(), mountConfig.FileSystemConfig.PreservePosixAttributes)
+	assert.True(t.T(), mountConfig.FileSystemConfig.EnableVersionsDir)
+	assert.Equal(t.T(), 4, mountConfig.FileSystemConfig.RenameDirParallelism)
+
+	// read config
+	assert.True(t.T(), mountConfig.ReadConfig.EnableReadAhead)
+	assert.Equal(t.T(), 4, mountConfig.ReadConfig.ReadAheadChunkSizeMB)
+	assert.Equal(t.T(), 16, mountConfig.ReadConfig.ReadAheadMaxInFlight)
+	assert.Equal(t.T(), 256, mountConfig.ReadConfig.ReadAheadMemoryMB)
+	assert.Equal(t.T(), 128, mountConfig.ReadConfig.BlockCacheMaxSizeMB)
+	assert.Equal(t.T(), 2, mountConfig.ReadConfig.BlockCacheBlockSizeMB)
+	assert.Equal(t.T(), 3, mountConfig.ReadConfig.BlockCachePromotionThreshold)
 
 	// file-cache config
 	assert.Equal(t.T(), int64(100), mountConfig.FileCacheConfig.MaxSizeMB)
@@ -142,6 +199,10 @@ func (t *YamlParserTest) TestReadConfigFile_ValidConfig() {
 	assert.Equal(t.T(), -1, mountConfig.MaxDownloadParallelism)
 	assert.Equal(t.T(), 100, mountConfig.ReadRequestSizeMB)
 	assert.False(t.T(), mountConfig.FileCacheConfig.EnableCrcCheck)
+	assert.True(t.T(), mountConfig.FileCacheConfig.EnableSparseFile)
+	assert.Equal(t.T(), 4, mountConfig.FileCacheConfig.SparseFileChunkSizeMB)
+	assert.Equal(t.T(), LFUEvictionPolicy, mountConfig.FileCacheConfig.EvictionPolicy)
+	assert.True(t.T(), mountConfig.FileCacheConfig.SharedCacheDir)
 }
 
 func (t *YamlParserTest) TestReadConfigFile_InvalidLogConfig() {
@@ -186,6 +247,72 @@ func (t *YamlParserTest) TestReadConfigFile_InvalidReadRequestSizeMBConfig() {
 	assert.ErrorContains(t.T(), err, ReadRequestSizeMBInvalidValueError)
 }
 
+func (t *YamlParserTest) TestReadConfigFile_InvalidSparseFileChunkSizeMBConfig() {
+	_, err := ParseConfigFile("testdata/file_cache_config/invalid_sparse_file_chunk_size_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, SparseFileChunkSizeMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_UnsupportedFileCacheEvictionPolicy() {
+	_, err := ParseConfigFile("testdata/file_cache_config/unsupported_eviction_policy.yaml")
+
+	assert.ErrorContains(t.T(), err, fmt.Sprintf(UnsupportedFileCacheEvictionPolicyError, "ttl"))
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidConflictPolicy() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_conflict_policy.yaml")
+
+	assert.ErrorContains(t.T(), err, fmt.Sprintf(UnsupportedConflictPolicyError, "overwrite"))
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidParallelUploadThresholdMB() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_parallel_upload_threshold_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, ParallelUploadThresholdMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidUploadPartSizeMB() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_upload_part_size_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, UploadPartSizeMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidUploadParallelismPerFile() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_upload_parallelism_per_file.yaml")
+
+	assert.ErrorContains(t.T(), err, UploadParallelismPerFileInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidDirtyDataRecovery() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_dirty_data_recovery.yaml")
+
+	assert.ErrorContains(t.T(), err, fmt.Sprintf(UnsupportedDirtyDataRecoveryError, "discard"))
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidAutoSyncInterval() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_auto_sync_interval.yaml")
+
+	assert.ErrorContains(t.T(), err, AutoSyncIntervalInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidAutoSyncDirtyMB() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_auto_sync_dirty_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, AutoSyncDirtyMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidPartialUpdateThresholdMB() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_partial_update_threshold_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, PartialUpdateThresholdMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_WriteConfig_InvalidPartialUpdateBlockSizeMB() {
+	_, err := ParseConfigFile("testdata/write_config/invalid_partial_update_block_size_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, PartialUpdateBlockSizeMBInvalidValueError)
+}
+
 func (t *YamlParserTest) TestReadConfigFile_MetatadaCacheConfig_InvalidTTL() {
 	_, err := ParseConfigFile("testdata/metadata_cache_config_invalid_ttl.yaml")
 
@@ -240,6 +367,18 @@ func (t *YamlParserTest) TestReadConfigFile_MetatadaCacheConfig_StatCacheSizeToo
 	assert.ErrorContains(t.T(), err, StatCacheMaxSizeMBTooHighError)
 }
 
+func (t *YamlParserTest) TestReadConfigFile_MetatadaCacheConfig_UnsupportedStatCacheEvictionPolicy() {
+	_, err := ParseConfigFile("testdata/metadata_cache_config_unsupported_stat-cache-eviction-policy.yaml")
+
+	assert.ErrorContains(t.T(), err, `unsupported stat-cache-eviction-policy: "mru"`)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_MetatadaCacheConfig_UnsupportedTypeCacheEvictionPolicy() {
+	_, err := ParseConfigFile("testdata/metadata_cache_config_unsupported_type-cache-eviction-policy.yaml")
+
+	assert.ErrorContains(t.T(), err, `unsupported type-cache-eviction-policy: "fifo"`)
+}
+
 func (t *YamlParserTest) TestReadConfigFile_GrpcClientConfig_invalidConnPoolSize() {
 	_, err := ParseConfigFile("testdata/gcs_connection/invalid_conn_pool_size.yaml")
 
@@ -296,6 +435,55 @@ func (t *YamlParserTest) TestReadConfigFile_FileSystemConfig_UnsetDisableParalle
 	assert.False(t.T(), mountConfig.FileSystemConfig.DisableParallelDirops)
 }
 
+func (t *YamlParserTest) TestReadConfigFile_FileSystemConfig_InvalidRenameDirParallelism() {
+	_, err := ParseConfigFile("testdata/file_system_config/invalid_rename_dir_parallelism.yaml")
+
+	assert.ErrorContains(t.T(), err, RenameDirParallelismInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_FileSystemConfig_UnsetRenameDirParallelism() {
+	mountConfig, err := ParseConfigFile("testdata/file_system_config/unset_rename_dir_parallelism.yaml")
+
+	assert.NoError(t.T(), err)
+	assert.Equal(t.T(), DefaultRenameDirParallelism, mountConfig.FileSystemConfig.RenameDirParallelism)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_ReadConfig_InvalidReadAheadChunkSizeMB() {
+	_, err := ParseConfigFile("testdata/read_config/invalid_read_ahead_chunk_size_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, ReadAheadChunkSizeMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_ReadConfig_InvalidReadAheadMaxInFlight() {
+	_, err := ParseConfigFile("testdata/read_config/invalid_read_ahead_max_in_flight.yaml")
+
+	assert.ErrorContains(t.T(), err, ReadAheadMaxInFlightInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_ReadConfig_InvalidReadAheadMemoryMB() {
+	_, err := ParseConfigFile("testdata/read_config/invalid_read_ahead_memory_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, ReadAheadMemoryMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_ReadConfig_InvalidBlockCacheMaxSizeMB() {
+	_, err := ParseConfigFile("testdata/read_config/invalid_block_cache_max_size_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, BlockCacheMaxSizeMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_ReadConfig_InvalidBlockCacheBlockSizeMB() {
+	_, err := ParseConfigFile("testdata/read_config/invalid_block_cache_block_size_mb.yaml")
+
+	assert.ErrorContains(t.T(), err, BlockCacheBlockSizeMBInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_ReadConfig_InvalidBlockCachePromotionThreshold() {
+	_, err := ParseConfigFile("testdata/read_config/invalid_block_cache_promotion_threshold.yaml")
+
+	assert.ErrorContains(t.T(), err, BlockCachePromotionThresholdInvalidValueError)
+}
+
 func (t *YamlParserTest) TestReadConfigFile_ListConfig_InvalidKernelListCacheTtl() {
 	_, err := ParseConfigFile("testdata/list_config/invalid_kernel_list_cache_ttl.yaml")
 
@@ -323,3 +511,48 @@ func (t *YamlParserTest) TestReadConfigFile_ListConfig_ValidKernelListCacheTtl()
 	assert.NotNil(t.T(), mountConfig)
 	assert.Equal(t.T(), int64(10), mountConfig.ListConfig.KernelListCacheTtlSeconds)
 }
+
+func (t *YamlParserTest) TestReadConfigFile_FaultInjectionConfig_Valid() {
+	mountConfig, err := ParseConfigFile("testdata/fault_injection_config/valid.yaml")
+
+	assert.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(42), mountConfig.FaultInjectionConfig.Seed)
+	assert.Equal(t.T(), []FaultRule{
+		{
+			Methods:       []string{"NewReader"},
+			ObjectPattern: "^logs/",
+			Probability:   0.5,
+			Fault:         StallFault,
+			Latency:       2 * time.Second,
+			AfterBytes:    1 << 20,
+		},
+		{
+			Probability: 0.01,
+			Fault:       ServiceUnavailableFault,
+		},
+	}, mountConfig.FaultInjectionConfig.Rules)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_FaultInjectionConfig_InvalidFault() {
+	_, err := ParseConfigFile("testdata/fault_injection_config/invalid_fault.yaml")
+
+	assert.ErrorContains(t.T(), err, fmt.Sprintf(UnsupportedFaultError, "explode"))
+}
+
+func (t *YamlParserTest) TestReadConfigFile_FaultInjectionConfig_InvalidMethod() {
+	_, err := ParseConfigFile("testdata/fault_injection_config/invalid_method.yaml")
+
+	assert.ErrorContains(t.T(), err, fmt.Sprintf(UnsupportedFaultMethodError, "ReadObject"))
+}
+
+func (t *YamlParserTest) TestReadConfigFile_FaultInjectionConfig_InvalidProbability() {
+	_, err := ParseConfigFile("testdata/fault_injection_config/invalid_probability.yaml")
+
+	assert.ErrorContains(t.T(), err, FaultProbabilityInvalidValueError)
+}
+
+func (t *YamlParserTest) TestReadConfigFile_FaultInjectionConfig_MissingLatency() {
+	_, err := ParseConfigFile("testdata/fault_injection_config/missing_latency.yaml")
+
+	assert.ErrorContains(t.T(), err, FaultLatencyInvalidValueError)
+}
@@ -28,8 +28,10 @@ import (
 	"syscall"
 	"time"
 
+	blockcache "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/block"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file/downloader"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/index"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
@@ -55,6 +57,11 @@ type ServerConfig struct {
 	// The bucket manager is responsible for setting up buckets.
 	BucketManager gcsx.BucketManager
 
+	// The journal in which to keep the contents of files, or nil if they aren't
+	// journaled. It is shared with the bucket manager, which recovers the dirty
+	// contents left behind by a crash when a bucket is set up.
+	DirtyJournal *gcsx.DirtyJournal
+
 	// The name of the specific GCS bucket to be mounted. If it's empty or "_",
 	// all accessible GCS buckets are mounted as subdirectories of the FS root.
 	BucketName string
@@ -154,6 +161,13 @@ func NewFileSystem(
 		}
 	}
 
+	// Keep the contents of files in the dirty-data journal, if enabled. Any left
+	// behind by a crash are recovered when the bucket is set up.
+	var dirtyJournal *gcsx.DirtyJournal
+	if !cfg.LocalFileCache {
+		dirtyJournal = cfg.DirtyJournal
+	}
+
 	// Create file cache handler if cache is enabled by user. Cache is considered
 	// enabled only if cache-dir is not empty and file-cache:max-size-mb is non 0.
 	var fileCacheHandler *file.CacheHandler
@@ -165,6 +179,23 @@ func NewFileSystem(
 		}
 	}
 
+	// Read-ahead shares one pool of buffers across all file handles, bounding
+	// the memory they take as a whole.
+	var readAhead gcsx.ReadAheadConfig
+	if readConfig := cfg.MountConfig.ReadConfig; readConfig.EnableReadAhead {
+		chunkSize := int64(util.MiBsToBytes(uint64(readConfig.ReadAheadChunkSizeMB)))
+		readAhead = gcsx.ReadAheadConfig{
+			ChunkSize:   chunkSize,
+			MaxInFlight: readConfig.ReadAheadMaxInFlight,
+			Buffers:     gcsx.NewBufferPool(chunkSize, readConfig.ReadAheadMemoryMB/readConfig.ReadAheadChunkSizeMB),
+		}
+	}
+
+	var blockCache *blockcache.Cache
+	if readConfig := cfg.MountConfig.ReadConfig; readConfig.BlockCacheMaxSizeMB > 0 {
+		blockCache = createBlockCache(cfg, fileCacheHandler)
+	}
+
 	// Set up the basic struct.
 	fs := &fileSystem{
 		mtimeClock:                 mtimeClock,
@@ -172,6 +203,7 @@ func NewFileSystem(
 		bucketManager:              cfg.BucketManager,
 		localFileCache:             cfg.LocalFileCache,
 		contentCache:               contentCache,
+		dirtyJournal:               dirtyJournal,
 		implicitDirs:               cfg.ImplicitDirectories,
 		enableNonexistentTypeCache: cfg.EnableNonexistentTypeCache,
 		inodeAttributeCacheTTL:     cfg.InodeAttributeCacheTTL,
@@ -188,10 +220,13 @@ func NewFileSystem(
 		generationBackedInodes:     make(map[inode.Name]inode.GenerationBackedInode),
 		implicitDirInodes:          make(map[inode.Name]inode.DirInode),
 		localFileInodes:            make(map[inode.Name]inode.Inode),
+		versionInodes:              make(map[inode.Name]inode.Inode),
 		handles:                    make(map[fuseops.HandleID]interface{}),
 		mountConfig:                cfg.MountConfig,
 		fileCacheHandler:           fileCacheHandler,
 		cacheFileForRangeRead:      cfg.MountConfig.FileCacheConfig.CacheFileForRangeRead,
+		readAhead:                  readAhead,
+		blockCache:                 blockCache,
 	}
 
 	// Set up root bucket
@@ -215,9 +250,52 @@ func NewFileSystem(
 
 	// Set up invariant checking.
 	fs.mu = locker.New("FS", fs.checkInvariants)
+
+	// Write out the dirty contents of long-lived files in the background, if
+	// enabled.
+	writeConfig := cfg.MountConfig.WriteConfig
+	if writeConfig.AutoSyncInterval > 0 || writeConfig.AutoSyncDirtyMB > 0 {
+		fs.dirtyFiles = inode.NewDirtyFiles()
+		var autoSyncCtx context.Context
+		autoSyncCtx, fs.stopAutoSync = context.WithCancel(context.Background())
+		go fs.autoSync(
+			autoSyncCtx,
+			writeConfig.AutoSyncInterval,
+			int64(util.MiBsToBytes(uint64(writeConfig.AutoSyncDirtyMB))))
+	}
+
 	return fs, nil
 }
 
+// Create the block cache, with the file cache as its disk tier if that is
+// enabled and can be set up.
+func createBlockCache(cfg *ServerConfig, fileCacheHandler *file.CacheHandler) *blockcache.Cache {
+	readConfig := cfg.MountConfig.ReadConfig
+	memoryMaxSize := util.MiBsToBytes(uint64(readConfig.BlockCacheMaxSizeMB))
+	blockSize := int64(util.MiBsToBytes(uint64(readConfig.BlockCacheBlockSizeMB)))
+	if fileCacheHandler == nil {
+		return blockcache.NewCache(memoryMaxSize, blockSize)
+	}
+	// The blocks aren't recorded in the index, so other processes can't account
+	// for them.
+	if cfg.MountConfig.FileCacheConfig.SharedCacheDir {
+		logger.Warnf("Blocks aren't demoted to a shared file cache directory, keeping the block cache in memory only.")
+		return blockcache.NewCache(memoryMaxSize, blockSize)
+	}
+
+	blockCache, err := blockcache.NewTieredCache(
+		memoryMaxSize,
+		fileCacheHandler,
+		cacheutil.DefaultDirPerm,
+		readConfig.BlockCachePromotionThreshold,
+		blockSize)
+	if err != nil {
+		logger.Warnf("Keeping the block cache in memory only: %v", err)
+		return blockcache.NewCache(memoryMaxSize, blockSize)
+	}
+	return blockCache
+}
+
 func createFileCacheHandler(cfg *ServerConfig) (fileCacheHandler *file.CacheHandler, err error) {
 	var sizeInBytes uint64
 	// -1 means unlimited size for cache, the underlying LRU cache doesn't handle
@@ -227,7 +305,11 @@ func createFileCacheHandler(cfg *ServerConfig) (fileCacheHandler *file.CacheHand
 	} else {
 		sizeInBytes = uint64(cfg.MountConfig.FileCacheConfig.MaxSizeMB) * cacheutil.MiB
 	}
-	fileInfoCache := lru.NewCache(sizeInBytes)
+	policy, err := lru.NewEvictionPolicy(cfg.MountConfig.FileCacheConfig.EvictionPolicy, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("createFileCacheHandler: %w", err)
+	}
+	fileInfoCache := lru.NewCacheWithPolicy(sizeInBytes, policy)
 
 	cacheDir := string(cfg.MountConfig.CacheDir)
 	// Adding a new directory inside cacheDir to keep file-cache separate from
@@ -243,10 +325,36 @@ func createFileCacheHandler(cfg *ServerConfig) (fileCacheHandler *file.CacheHand
 		return nil, fmt.Errorf("createFileCacheHandler: while creating file cache directory: %w", cacheDirErr)
 	}
 
+	// The index is kept outside the file-cache directory, so that it can't
+	// collide with the path of a cached object.
+	fileCacheConfig := cfg.MountConfig.FileCacheConfig
+	indexPath := path.Join(string(cfg.MountConfig.CacheDir), cacheutil.FileCacheIndex)
+	var fileInfoJournal *index.Journal
+	if fileCacheConfig.SharedCacheDir {
+		// The chunks of sparse files aren't recorded in the index, so other
+		// processes can't account for them.
+		if fileCacheConfig.EnableSparseFile {
+			logger.Warnf("Sparse files aren't supported with a shared file cache directory, caching whole files instead.")
+			fileCacheConfig.EnableSparseFile = false
+		}
+		fileInfoJournal, err = index.OpenSharedJournal(indexPath, filePerm)
+	} else {
+		fileInfoJournal, err = index.OpenJournal(indexPath, filePerm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("createFileCacheHandler: while opening file cache index: %w", err)
+	}
+
 	jobManager := downloader.NewJobManager(fileInfoCache, filePerm, dirPerm, cacheDir,
-		cfg.SequentialReadSizeMb, &cfg.MountConfig.FileCacheConfig)
+		cfg.SequentialReadSizeMb, &fileCacheConfig, fileInfoJournal)
 	fileCacheHandler = file.NewCacheHandler(fileInfoCache, jobManager,
-		cacheDir, filePerm, dirPerm)
+		cacheDir, filePerm, dirPerm, fileInfoJournal, &fileCacheConfig)
+
+	err = fileCacheHandler.RebuildFileInfoCache()
+	if err != nil {
+		_ = fileInfoJournal.Close()
+		return nil, fmt.Errorf("createFileCacheHandler: while rebuilding file info cache: %w", err)
+	}
 	return
 }
 
@@ -275,6 +383,7 @@ func makeRootForBucket(
 		fs.mtimeClock,
 		fs.cacheClock,
 		fs.mountConfig.MetadataCacheConfig.TypeCacheMaxSizeMB,
+		fs.mountConfig.MetadataCacheConfig.TypeCacheEvictionPolicy,
 	)
 }
 
@@ -360,6 +469,17 @@ type fileSystem struct {
 	fileMode os.FileMode
 	dirMode  os.FileMode
 
+	// The journal in which file inodes keep their temp files, so that dirty
+	// content survives a crash, or nil if not enabled.
+	dirtyJournal *gcsx.DirtyJournal
+
+	// The file inodes with dirty content, which the background auto-sync visits,
+	// or nil if it is not enabled.
+	dirtyFiles *inode.DirtyFiles
+
+	// Stops the background auto-sync of dirty files, or nil if not enabled.
+	stopAutoSync context.CancelFunc
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
@@ -445,9 +565,21 @@ type fileSystem struct {
 	// GUARDED_BY(mu)
 	localFileInodes map[inode.Name]inode.Inode
 
+	// A map from name to the inode that represents that name within the
+	// read-only directory of object versions. There can be at most one such
+	// inode for a given name accessible to us at any given time.
+	//
+	// INVARIANT: For each k/v, v.Name() == k
+	// INVARIANT: For each value v, inodes[v.ID()] == v
+	// INVARIANT: For each value v, v is *inode.VersionInode or a DirInode
+	//
+	// GUARDED_BY(mu)
+	versionInodes map[inode.Name]inode.Inode
+
 	// The collection of live handles, keyed by handle ID.
 	//
-	// INVARIANT: All values are of type *dirHandle or *handle.FileHandle
+	// INVARIANT: All values are of type *dirHandle, *handle.FileHandle or
+	//            *handle.VersionHandle
 	//
 	// GUARDED_BY(mu)
 	handles map[fuseops.HandleID]interface{}
@@ -469,6 +601,14 @@ type fileSystem struct {
 	// cacheFileForRangeRead when true downloads file into cache even for
 	// random file access.
 	cacheFileForRangeRead bool
+
+	// readAhead configures the prefetching of object contents ahead of the
+	// reads of file handles. Its Buffers are nil if read-ahead is disabled.
+	readAhead gcsx.ReadAheadConfig
+
+	// blockCache serves small random reads from memory, shared by all file
+	// handles. It is nil if the block cache is disabled.
+	blockCache *blockcache.Cache
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -558,11 +698,14 @@ func (fs *fileSystem) checkInvariantsForImplicitDirs() {
 
 	// INVARIANT: For each in in inodes such that in is DirInode but not
 	//            ExplicitDirInode, implicitDirInodes[d.Name()] == d
+	//
+	// Directories within the directory of object versions are exempt, since
+	// they are in versionInodes instead.
 	for _, in := range fs.inodes {
 		_, dir := in.(inode.DirInode)
 		_, edir := in.(inode.ExplicitDirInode)
 
-		if dir && !edir {
+		if dir && !edir && fs.versionInodes[in.Name()] != in {
 			if !(fs.implicitDirInodes[in.Name()] == in) {
 				panic(fmt.Sprintf(
 					"implicitDirInodes mismatch: %q %v %v",
@@ -597,6 +740,39 @@ func (fs *fileSystem) checkInvariantsForGenerationBackedInodes() {
 	}
 }
 
+func (fs *fileSystem) checkInvariantsForVersionInodes() {
+	// INVARIANT: For each k/v, v.Name() == k
+	for k, v := range fs.versionInodes {
+		if !(v.Name() == k) {
+			panic(fmt.Sprintf(
+				"Unexpected name: \"%s\" vs. \"%s\"",
+				v.Name(),
+				k))
+		}
+	}
+
+	// INVARIANT: For each value v, inodes[v.ID()] == v
+	for _, v := range fs.versionInodes {
+		if fs.inodes[v.ID()] != v {
+			panic(fmt.Sprintf(
+				"Mismatch for ID %v: %v %v",
+				v.ID(),
+				fs.inodes[v.ID()],
+				v))
+		}
+	}
+
+	// INVARIANT: For each value v, v is *inode.VersionInode or a DirInode
+	for _, v := range fs.versionInodes {
+		switch v.(type) {
+		case *inode.VersionInode:
+		case inode.DirInode:
+		default:
+			panic(fmt.Sprintf("Unexpected version inode %d, type %T", v.ID(), v))
+		}
+	}
+}
+
 func (fs *fileSystem) checkInvariantsForInodes() {
 	// INVARIANT: For all keys k, fuseops.RootInodeID <= k < nextInodeID
 	for id := range fs.inodes {
@@ -643,16 +819,19 @@ func (fs *fileSystem) checkInvariants() {
 	fs.checkInvariantsForGenerationBackedInodes()
 	fs.checkInvariantsForImplicitDirs()
 	fs.checkInvariantsForLocalFileInodes()
+	fs.checkInvariantsForVersionInodes()
 
 	//////////////////////////////////
 	// handles
 	//////////////////////////////////
 
-	// INVARIANT: All values are of type *dirHandle or *handle.FileHandle
+	// INVARIANT: All values are of type *dirHandle, *handle.FileHandle or
+	//            *handle.VersionHandle
 	for _, h := range fs.handles {
 		switch h.(type) {
 		case *handle.DirHandle:
 		case *handle.FileHandle:
+		case *handle.VersionHandle:
 		default:
 			panic(fmt.Sprintf("Unexpected handle type: %T", h))
 		}
@@ -681,13 +860,43 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 
 	// Create the inode.
 	switch {
-	// Explicit directories
+	// Directories within the directory of object versions
+	case ic.Version && ic.FullName.IsDir():
+		in = inode.NewVersionsDirInode(
+			id,
+			ic.FullName,
+			fuseops.InodeAttributes{
+				Uid:  fs.uid,
+				Gid:  fs.gid,
+				Mode: fs.dirMode,
+
+				// We guarantee only that directory times be "reasonable".
+				Atime: fs.mtimeClock.Now(),
+				Ctime: fs.mtimeClock.Now(),
+				Mtime: fs.mtimeClock.Now(),
+			},
+			ic.Bucket)
+
+		// Generations of objects
+	case ic.Version:
+		in = inode.NewVersionInode(
+			id,
+			ic.FullName,
+			ic.MinObject,
+			fuseops.InodeAttributes{
+				Uid:  fs.uid,
+				Gid:  fs.gid,
+				Mode: fs.fileMode,
+			},
+			ic.Bucket)
+
+		// Explicit directories
 	case ic.MinObject != nil && ic.FullName.IsDir():
 		in = inode.NewExplicitDirInode(
 			id,
 			ic.FullName,
 			ic.MinObject,
-			fuseops.InodeAttributes{
+			fs.withPosixAttributes(fuseops.InodeAttributes{
 				Uid:  fs.uid,
 				Gid:  fs.gid,
 				Mode: fs.dirMode,
@@ -696,7 +905,7 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 				Atime: fs.mtimeClock.Now(),
 				Ctime: fs.mtimeClock.Now(),
 				Mtime: fs.mtimeClock.Now(),
-			},
+			}, ic.MinObject),
 			fs.implicitDirs,
 			fs.mountConfig.ListConfig.EnableEmptyManagedFolders,
 			fs.enableNonexistentTypeCache,
@@ -704,7 +913,8 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 			ic.Bucket,
 			fs.mtimeClock,
 			fs.cacheClock,
-			fs.mountConfig.MetadataCacheConfig.TypeCacheMaxSizeMB)
+			fs.mountConfig.MetadataCacheConfig.TypeCacheMaxSizeMB,
+			fs.mountConfig.MetadataCacheConfig.TypeCacheEvictionPolicy)
 
 		// Implicit directories
 	case ic.FullName.IsDir():
@@ -728,7 +938,8 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 			ic.Bucket,
 			fs.mtimeClock,
 			fs.cacheClock,
-			fs.mountConfig.MetadataCacheConfig.TypeCacheMaxSizeMB)
+			fs.mountConfig.MetadataCacheConfig.TypeCacheMaxSizeMB,
+			fs.mountConfig.MetadataCacheConfig.TypeCacheEvictionPolicy)
 
 	case inode.IsSymlink(ic.MinObject):
 		in = inode.NewSymlinkInode(
@@ -746,16 +957,20 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 			id,
 			ic.FullName,
 			ic.MinObject,
-			fuseops.InodeAttributes{
+			fs.withPosixAttributes(fuseops.InodeAttributes{
 				Uid:  fs.uid,
 				Gid:  fs.gid,
 				Mode: fs.fileMode,
-			},
+			}, ic.MinObject),
 			ic.Bucket,
 			fs.localFileCache,
 			fs.contentCache,
 			fs.mtimeClock,
-			ic.Local)
+			ic.Local,
+			fs.mountConfig.WriteConfig.ConflictPolicy,
+			fs.mountConfig.WriteConfig.EnableStreamingWrites,
+			fs.dirtyJournal,
+			fs.dirtyFiles)
 	}
 
 	// Place it in our map of IDs to inodes.
@@ -764,6 +979,17 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 	return
 }
 
+// withPosixAttributes returns attrs with the POSIX attributes stored in the
+// custom metadata of the given object, if they are to be preserved.
+func (fs *fileSystem) withPosixAttributes(
+	attrs fuseops.InodeAttributes,
+	m *gcs.MinObject) fuseops.InodeAttributes {
+	if !fs.mountConfig.FileSystemConfig.PreservePosixAttributes || m == nil {
+		return attrs
+	}
+	return inode.ApplyPosixAttributes(attrs, m.Metadata)
+}
+
 // Attempt to find an inode for a backing object or an implicit directory.
 // Create an inode if (1) it has never yet existed, or (2) the object is newer
 // than the existing one.
@@ -794,6 +1020,13 @@ func (fs *fileSystem) lookUpOrCreateInodeIfNotStale(ic inode.Core) (in inode.Ino
 
 	fs.mu.Lock()
 
+	// Handle inodes within the directory of object versions, which never change
+	// and hence are identified by name alone.
+	if ic.Version {
+		in = fs.lookUpOrCreateVersionInode(ic)
+		return
+	}
+
 	// Handle implicit directories.
 	if ic.MinObject == nil {
 		if !ic.FullName.IsDir() {
@@ -906,6 +1139,43 @@ func (fs *fileSystem) lookUpOrCreateInodeIfNotStale(ic inode.Core) (in inode.Ino
 	}
 }
 
+// Implementation detail of lookUpOrCreateInodeIfNotStale; do not use outside
+// of that function. Return an existing inode within the directory of object
+// versions for the given name or mint a new one, locked, or nil if the inode
+// couldn't be locked.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *fileSystem) lookUpOrCreateVersionInode(ic inode.Core) (in inode.Inode) {
+	const maxTriesToCreateInode = 3
+	for n := 0; n < maxTriesToCreateInode; n++ {
+		var ok bool
+		in, ok = fs.versionInodes[ic.FullName]
+		// If we don't have an entry, create one. Nothing else can hold the lock
+		// of the new inode, hence it's safe to take it without releasing fs.mu.
+		if !ok {
+			in = fs.mintInode(ic)
+			fs.versionInodes[in.Name()] = in
+			in.Lock()
+			return
+		}
+
+		// Follow the lock ordering rules: first the inode lock, then fs lock.
+		fs.mu.Unlock()
+		in.Lock()
+		fs.mu.Lock()
+
+		// If the inode is in the process of being destroyed, try again.
+		if fs.versionInodes[ic.FullName] != in {
+			in.Unlock()
+			continue
+		}
+
+		return
+	}
+
+	return nil
+}
+
 // Look up the child with the given name within the parent, then return an
 // existing inode for that child or create a new one if necessary. Return
 // ENOENT if the child doesn't exist.
@@ -919,6 +1189,24 @@ func (fs *fileSystem) lookUpOrCreateChildInode(
 	ctx context.Context,
 	parent inode.DirInode,
 	childName string) (child inode.Inode, err error) {
+	// The directory of object versions isn't listed in the root of the bucket,
+	// hence look it up by name.
+	if fs.mountConfig.FileSystemConfig.EnableVersionsDir &&
+		childName == inode.VersionsDirName &&
+		parent.Name().IsBucketRoot() {
+		if bucketOwned, ok := parent.(inode.BucketOwnedInode); ok {
+			child = fs.lookUpOrCreateInodeIfNotStale(inode.Core{
+				Bucket:   bucketOwned.Bucket(),
+				FullName: inode.NewDirName(parent.Name(), childName),
+				Version:  true,
+			})
+			if child == nil {
+				err = fmt.Errorf("cannot find %q in %q", childName, parent.Name())
+			}
+			return
+		}
+	}
+
 	// First check if the requested child is a localFileInode.
 	child = fs.lookUpLocalFileInode(parent, childName)
 	if child != nil {
@@ -1084,14 +1372,7 @@ func (fs *fileSystem) syncFile(
 	}
 
 	// Once the inode is synced to GCS, it is no longer an localFileInode.
-	// Delete the entry from localFileInodes map and add it to generationBackedInodes.
-	fs.mu.Lock()
-	delete(fs.localFileInodes, f.Name())
-	_, ok := fs.generationBackedInodes[f.Name()]
-	if !ok {
-		fs.generationBackedInodes[f.Name()] = f
-	}
-	fs.mu.Unlock()
+	fs.promoteLocalFileInode(f)
 
 	// We need not update fileIndex:
 	//
@@ -1106,6 +1387,89 @@ func (fs *fileSystem) syncFile(
 	return
 }
 
+// Delete the entry for the supplied file inode, which has been synced to GCS,
+// from localFileInodes and add it to generationBackedInodes.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_REQUIRED(f)
+func (fs *fileSystem) promoteLocalFileInode(f *inode.FileInode) {
+	fs.mu.Lock()
+	delete(fs.localFileInodes, f.Name())
+	_, ok := fs.generationBackedInodes[f.Name()]
+	if !ok {
+		fs.generationBackedInodes[f.Name()] = f
+	}
+	fs.mu.Unlock()
+}
+
+// Promote the supplied file inode if it was local before an operation that
+// may have synced it to GCS, such as a read or out-of-order write finishing
+// the upload to which its writes were being streamed.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_REQUIRED(f)
+func (fs *fileSystem) promoteIfNoLongerLocal(f *inode.FileInode, wasLocal bool) {
+	if wasLocal && !f.IsLocal() {
+		fs.promoteLocalFileInode(f)
+	}
+}
+
+// How often the background auto-sync checks for files to sync, unless the
+// auto-sync interval is shorter.
+const autoSyncCheckPeriod = time.Second
+
+// Periodically sync the files whose contents have been dirty for at least
+// interval, or of which at least dirtyBytes bytes are dirty, until the context
+// is cancelled, so that their progress is visible in the bucket and at most
+// one interval's worth of it is lost if the process dies. See
+// FileInode.AutoSync.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) autoSync(
+	ctx context.Context,
+	interval time.Duration,
+	dirtyBytes int64) {
+	period := autoSyncCheckPeriod
+	if interval > 0 && interval < period {
+		period = interval
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			fs.autoSyncOnce(ctx, interval, dirtyBytes)
+		}
+	}
+}
+
+// Sync the files that are due to be auto-synced, one at a time.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) autoSyncOnce(
+	ctx context.Context,
+	interval time.Duration,
+	dirtyBytes int64) {
+	// Only files with dirty content can be due. An inode may be synced or
+	// destroyed before it is locked below, which FileInode.AutoSync tolerates.
+	for _, f := range fs.dirtyFiles.List() {
+		f.Lock()
+		wasLocal := f.IsLocal()
+		synced, err := f.AutoSync(ctx, interval, dirtyBytes)
+		if err != nil {
+			logger.Warnf("Auto-sync of %q failed: %v", f.Name().GcsObjectName(), err)
+		} else if synced {
+			fs.promoteIfNoLongerLocal(f, wasLocal)
+		}
+		f.Unlock()
+	}
+}
+
 // Decrement the supplied inode's lookup count, destroying it if the inode says
 // that it has hit zero.
 //
@@ -1138,6 +1502,9 @@ func (fs *fileSystem) unlockAndDecrementLookupCount(in inode.Inode, N uint64) {
 		if fs.localFileInodes[name] == in {
 			delete(fs.localFileInodes, name)
 		}
+		if fs.versionInodes[name] == in {
+			delete(fs.versionInodes, name)
+		}
 		fs.mu.Unlock()
 	}
 
@@ -1296,7 +1663,14 @@ func (fs *fileSystem) invalidateChildFileCacheIfExist(parentInode inode.DirInode
 ////////////////////////////////////////////////////////////////////////
 
 func (fs *fileSystem) Destroy() {
+	if fs.stopAutoSync != nil {
+		fs.stopAutoSync()
+	}
 	fs.bucketManager.ShutDown()
+	// The block cache keeps blocks in the file cache.
+	if fs.blockCache != nil {
+		fs.blockCache.Destroy()
+	}
 	if fs.fileCacheHandler != nil {
 		_ = fs.fileCacheHandler.Destroy()
 	}
@@ -1407,6 +1781,32 @@ func (fs *fileSystem) SetInodeAttributes(
 	in.Lock()
 	defer in.Unlock()
 	file, isFile := in.(*inode.FileInode)
+	if isFile {
+		defer fs.promoteIfNoLongerLocal(file, file.IsLocal())
+	}
+
+	// Generations of objects are read-only.
+	if _, isVersion := in.(*inode.VersionInode); isVersion && (op.Mtime != nil || op.Size != nil) {
+		return syscall.EROFS
+	}
+
+	// Check that the caller may change the mode, uid and gid before anything
+	// is changed.
+	posix := fs.mountConfig.FileSystemConfig.PreservePosixAttributes &&
+		(op.Mode != nil || op.Uid != nil || op.Gid != nil)
+	if posix {
+		var attrs fuseops.InodeAttributes
+		attrs, err = in.Attributes(ctx)
+		if err != nil {
+			err = fmt.Errorf("Attributes: %w", err)
+			return err
+		}
+
+		err = inode.CheckPosixAttributesCaller(op.OpContext.Uid, fs.uid, attrs, op.Mode, op.Uid, op.Gid)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Set file mtimes.
 	if isFile && op.Mtime != nil {
@@ -1426,7 +1826,22 @@ func (fs *fileSystem) SetInodeAttributes(
 		}
 	}
 
-	// We silently ignore updates to mode and atime.
+	// Set mode, uid and gid of files and explicit directories, if they are
+	// preserved in object metadata.
+	if posix {
+		switch typed := in.(type) {
+		case *inode.FileInode:
+			err = typed.SetPosixAttributes(ctx, op.Mode, op.Uid, op.Gid)
+		case inode.ExplicitDirInode:
+			err = typed.SetPosixAttributes(ctx, op.Mode, op.Uid, op.Gid)
+		}
+		if err != nil {
+			err = fmt.Errorf("SetPosixAttributes: %w", err)
+			return err
+		}
+	}
+
+	// We silently ignore updates to atime, and to mode, uid and gid otherwise.
 
 	// Fill in the response.
 	op.Attributes, op.AttributesExpiration, err = fs.getAttributes(ctx, in)
@@ -1674,7 +2089,7 @@ func (fs *fileSystem) CreateFile(
 	handleID := fs.nextHandleID
 	fs.nextHandleID++
 
-	fs.handles[handleID] = handle.NewFileHandle(child.(*inode.FileInode), fs.fileCacheHandler, fs.cacheFileForRangeRead)
+	fs.handles[handleID] = handle.NewFileHandle(child.(*inode.FileInode), fs.fileCacheHandler, fs.cacheFileForRangeRead, fs.readAhead, fs.blockCache)
 	op.Handle = handleID
 
 	fs.mu.Unlock()
@@ -1750,6 +2165,45 @@ func (fs *fileSystem) CreateSymlink(
 	return
 }
 
+// Return ENOTEMPTY if the supplied directory has any local files or any
+// entries in GCS.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_REQUIRED(dir)
+func (fs *fileSystem) checkDirEmpty(
+	ctx context.Context,
+	dir inode.DirInode) error {
+	// Check for local file entries.
+	fs.mu.Lock()
+	localFileEntries := dir.LocalFileEntries(fs.localFileInodes)
+	fs.mu.Unlock()
+	// Are there any local entries?
+	if len(localFileEntries) != 0 {
+		return fuse.ENOTEMPTY
+	}
+
+	// Check for entries on GCS.
+	var tok string
+	for {
+		var entries []fuseutil.Dirent
+		var err error
+		entries, tok, err = dir.ReadEntries(ctx, tok)
+		if err != nil {
+			return fmt.Errorf("ReadEntries: %w", err)
+		}
+
+		// Are there any entries?
+		if len(entries) != 0 {
+			return fuse.ENOTEMPTY
+		}
+
+		// Are we done listing?
+		if tok == "" {
+			return nil
+		}
+	}
+}
+
 // LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) RmDir(
 	// When rm -r or os.RemoveAll call is made, the following calls are made in order
@@ -1812,39 +2266,10 @@ func (fs *fileSystem) RmDir(
 	//     https://github.com/GoogleCloudPlatform/gcsfuse/issues/9
 	//
 	//
-
-	// Check for local file entries.
-	fs.mu.Lock()
-	localFileEntries := childDir.LocalFileEntries(fs.localFileInodes)
-	fs.mu.Unlock()
-	// Are there any local entries?
-	if len(localFileEntries) != 0 {
-		err = fuse.ENOTEMPTY
+	if err = fs.checkDirEmpty(ctx, childDir); err != nil {
 		return
 	}
 
-	// Check for entries on GCS.
-	var tok string
-	for {
-		var entries []fuseutil.Dirent
-		entries, tok, err = childDir.ReadEntries(ctx, tok)
-		if err != nil {
-			err = fmt.Errorf("ReadEntries: %w", err)
-			return err
-		}
-
-		// Are there any entries?
-		if len(entries) != 0 {
-			err = fuse.ENOTEMPTY
-			return
-		}
-
-		// Are we done listing?
-		if tok == "" {
-			break
-		}
-	}
-
 	// We are done with the child.
 	cleanUpAndUnlockChild()
 
@@ -1916,12 +2341,44 @@ func (fs *fileSystem) Rename(
 		return err
 	}
 
+	// Renaming a generation out of the directory of object versions restores
+	// it, leaving the generation in place.
+	if child.Version {
+		if child.FullName.IsDir() {
+			return fmt.Errorf("rename a versions directory: %w", syscall.EROFS)
+		}
+		return fs.restoreVersion(ctx, child.MinObject, newParent, op.NewName)
+	}
+
 	if child.FullName.IsDir() {
-		return fs.renameDir(ctx, oldParent, op.OldName, newParent, op.NewName)
+		if child.Bucket.BucketType() == gcs.Hierarchical {
+			return fs.renameHierarchicalDir(ctx, child.Bucket, oldParent, op.OldName, newParent, op.NewName)
+		}
+		return fs.renameDir(ctx, child.Bucket, oldParent, op.OldName, newParent, op.NewName)
 	}
 	return fs.renameFile(ctx, oldParent, op.OldName, child.MinObject, newParent, op.NewName)
 }
 
+// Restore the given generation of an object by copying it over the object
+// with the given name in newParent.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(newParent)
+func (fs *fileSystem) restoreVersion(
+	ctx context.Context,
+	version *gcs.MinObject,
+	newParent inode.DirInode,
+	newName string) error {
+	newParent.Lock()
+	_, err := newParent.CloneToChildFile(ctx, newName, version)
+	newParent.Unlock()
+	if err != nil {
+		return fmt.Errorf("CloneToChildFile: %w", err)
+	}
+
+	return nil
+}
+
 // LOCKS_EXCLUDED(fs.mu)
 // LOCKS_EXCLUDED(oldParent)
 // LOCKS_EXCLUDED(newParent)
@@ -1968,11 +2425,17 @@ func (fs *fileSystem) renameFile(
 // Rename an old directory to a new directory. If the new directory already
 // exists and is non-empty, return ENOTEMPTY.
 //
+// The objects in the old directory are moved in parallel by a DirRenamer,
+// which journals the moves in the bucket so that a rename interrupted by a
+// crash is finished when the bucket is next set up, or when the old directory
+// is next renamed.
+//
 // LOCKS_EXCLUDED(fs.mu)
 // LOCKS_EXCLUDED(oldParent)
 // LOCKS_EXCLUDED(newParent)
 func (fs *fileSystem) renameDir(
 	ctx context.Context,
+	bucket *gcsx.SyncerBucket,
 	oldParent inode.DirInode,
 	oldName string,
 	newParent inode.DirInode,
@@ -2005,6 +2468,20 @@ func (fs *fileSystem) renameDir(
 		return fmt.Errorf("can't rename directory %s with open files: %w", oldName, syscall.ENOTSUP)
 	}
 
+	// Finish an interrupted rename of the old directory, if any, after which
+	// the old directory no longer exists.
+	renamer := gcsx.NewDirRenamer(
+		bucket,
+		gcsx.RenameJournalPrefix,
+		fs.mountConfig.FileSystemConfig.RenameDirParallelism)
+	resumed, err := renamer.RollForward(ctx, oldDir.Name().GcsObjectName())
+	if err != nil {
+		return fmt.Errorf("RollForward: %w", err)
+	}
+	if resumed {
+		return fuse.ENOENT
+	}
+
 	// Fetch all the descendants of the old directory recursively
 	descendants, err := oldDir.ReadDescendants(ctx, int(fs.renameDirLimit+1))
 	if err != nil {
@@ -2044,37 +2521,40 @@ func (fs *fileSystem) renameDir(
 		return fuse.ENOTEMPTY
 	}
 
-	// Move all the files from the old directory to the new directory, keeping
-	// both directories locked.
+	// Move all the files from the old directory to the new directory, along
+	// with the backing object of the old directory, keeping both directories
+	// locked.
+	objects := make([]*gcs.MinObject, 0, len(descendants))
 	for _, descendant := range descendants {
-		nameDiff := strings.TrimPrefix(
-			descendant.FullName.GcsObjectName(), oldDir.Name().GcsObjectName())
-		if nameDiff == descendant.FullName.GcsObjectName() {
-			return fmt.Errorf("unwanted descendant %q not from dir %q", descendant.FullName, oldDir.Name())
-		}
-
-		o := descendant.MinObject
-		if _, err := newDir.CloneToChildFile(ctx, nameDiff, o); err != nil {
-			return fmt.Errorf("copy file %q: %w", o.Name, err)
-		}
-		if err := oldDir.DeleteChildFile(ctx, nameDiff, o.Generation, &o.MetaGeneration); err != nil {
-			return fmt.Errorf("delete file %q: %w", o.Name, err)
+		objects = append(objects, descendant.MinObject)
+	}
+	err = renamer.Rename(
+		ctx,
+		oldDir.Name().GcsObjectName(),
+		newDir.Name().GcsObjectName(),
+		objects)
+	if err != nil {
+		var preconditionErr *gcs.PreconditionError
+		if errors.As(err, &preconditionErr) {
+			return fmt.Errorf("directory %s is being renamed already: %w", oldName, syscall.EBUSY)
 		}
+		return fmt.Errorf("Rename: %w", err)
+	}
 
+	for _, o := range objects {
 		if err = fs.invalidateChildFileCacheIfExist(oldDir, o.Name); err != nil {
-			return fmt.Errorf("Unlink: while invalidating cache for delete file: %w", err)
+			return fmt.Errorf("renameDir: while invalidating cache for moved file: %w", err)
 		}
 	}
 
 	// We are done with both directories.
 	releaseInodes()
 
-	// Delete the backing object of the old directory.
-	fs.mu.Lock()
-	_, isImplicitDir := fs.implicitDirInodes[oldDir.Name()]
-	fs.mu.Unlock()
+	// The renamer has deleted the backing object of the old directory already,
+	// hence treat it like an implicit directory, which the parent need only
+	// forget.
 	oldParent.Lock()
-	err = oldParent.DeleteChildDir(ctx, oldName, isImplicitDir)
+	err = oldParent.DeleteChildDir(ctx, oldName, true)
 	oldParent.Unlock()
 	if err != nil {
 		return fmt.Errorf("DeleteChildDir: %w", err)
@@ -2083,6 +2563,160 @@ func (fs *fileSystem) renameDir(
 	return nil
 }
 
+// Rename an old directory to a new directory in a bucket with a hierarchical
+// namespace, by renaming the folder backing it along with everything in it in
+// a single call. Unlike renameDir, this is atomic and isn't limited by the
+// number of objects in the directory. If the new directory already exists and
+// is non-empty, return ENOTEMPTY.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(oldParent)
+// LOCKS_EXCLUDED(newParent)
+func (fs *fileSystem) renameHierarchicalDir(
+	ctx context.Context,
+	bucket *gcsx.SyncerBucket,
+	oldParent inode.DirInode,
+	oldName string,
+	newParent inode.DirInode,
+	newName string) error {
+	// Get the inode of the old directory
+	oldDir, err := fs.lookUpOrCreateChildDirInode(ctx, oldParent, oldName)
+	if err != nil {
+		return fmt.Errorf("lookup old directory: %w", err)
+	}
+
+	// If old directory contains local (un-synced) files, rename operation is not supported.
+	fs.mu.Lock()
+	entries := oldDir.LocalFileEntries(fs.localFileInodes)
+	fs.mu.Unlock()
+	oldDirName := oldDir.Name()
+	fs.unlockAndDecrementLookupCount(oldDir, 1)
+	if len(entries) != 0 {
+		return fmt.Errorf("can't rename directory %s with open files: %w", oldName, syscall.ENOTSUP)
+	}
+
+	// The folder can only be renamed to a name that doesn't exist, hence delete
+	// the new directory if it exists. Deleting it removes its backing object
+	// before its folder, so check that it's empty first rather than relying on
+	// the folder's deletion failing.
+	newParent.Lock()
+	existing, err := newParent.LookUpChild(ctx, newName)
+	newParent.Unlock()
+	if err != nil {
+		return fmt.Errorf("LookUpChild: %w", err)
+	}
+	if existing != nil {
+		if !existing.FullName.IsDir() {
+			return fmt.Errorf("rename directory over file %q: %w", newName, syscall.ENOTDIR)
+		}
+
+		newDir, err := fs.lookUpOrCreateChildDirInode(ctx, newParent, newName)
+		if err != nil {
+			return fmt.Errorf("lookup new directory: %w", err)
+		}
+		err = fs.checkDirEmpty(ctx, newDir)
+		fs.unlockAndDecrementLookupCount(newDir, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	newDir, err := fs.renameToChildFolder(ctx, newParent, newName, oldDirName, existing != nil)
+	if err != nil {
+		return err
+	}
+
+	// The folder has been renamed along with everything in it, hence the old
+	// parent need only forget the old directory, like an implicit one, and
+	// the files moved out of it are no longer cached under their old names.
+	oldParent.Lock()
+	defer oldParent.Unlock()
+	if err = oldParent.DeleteChildDir(ctx, oldName, true); err != nil {
+		return fmt.Errorf("DeleteChildDir: %w", err)
+	}
+	if err = fs.invalidateRenamedFolderFileCache(ctx, bucket, oldParent, oldDirName, newDir); err != nil {
+		return fmt.Errorf("renameHierarchicalDir: while invalidating cache for moved files: %w", err)
+	}
+
+	return nil
+}
+
+// renameToChildFolder renames the folder named src to the child directory of
+// newParent with the given name, and returns the name of the new directory.
+// If the new directory exists, it must be empty and is deleted first, and is
+// created again if the rename fails, so that it isn't lost.
+//
+// LOCKS_EXCLUDED(newParent)
+func (fs *fileSystem) renameToChildFolder(
+	ctx context.Context,
+	newParent inode.DirInode,
+	newName string,
+	src inode.Name,
+	exists bool) (inode.Name, error) {
+	newParent.Lock()
+	defer newParent.Unlock()
+	if exists {
+		// The folder's deletion still fails if something was added to it since.
+		err := newParent.DeleteChildDir(ctx, newName, false)
+		var preconditionErr *gcs.PreconditionError
+		if errors.As(err, &preconditionErr) {
+			return inode.Name{}, fuse.ENOTEMPTY
+		}
+		if err != nil {
+			return inode.Name{}, fmt.Errorf("DeleteChildDir: %w", err)
+		}
+	}
+
+	core, err := newParent.RenameToChildDir(ctx, newName, src)
+	if err != nil {
+		if exists {
+			if _, createErr := newParent.CreateChildDir(ctx, newName); createErr != nil {
+				logger.Warnf("Failed to recreate directory %q after failing to rename %q to it: %v", newName, src.LocalName(), createErr)
+			}
+		}
+		return inode.Name{}, fmt.Errorf("RenameToChildDir: %w", err)
+	}
+
+	return core.FullName, nil
+}
+
+// invalidateRenamedFolderFileCache invalidates the file cache entries of the
+// files moved from the directory named oldDir along with its folder, which
+// are keyed by their old names, by listing the directory newDir they were
+// moved to.
+//
+// LOCKS_REQUIRED(oldParent)
+func (fs *fileSystem) invalidateRenamedFolderFileCache(
+	ctx context.Context,
+	bucket *gcsx.SyncerBucket,
+	oldParent inode.DirInode,
+	oldDir inode.Name,
+	newDir inode.Name) error {
+	if fs.fileCacheHandler == nil {
+		return nil
+	}
+
+	req := &gcs.ListObjectsRequest{Prefix: newDir.GcsObjectName()}
+	for {
+		listing, err := bucket.ListObjects(ctx, req)
+		if err != nil {
+			return fmt.Errorf("ListObjects: %w", err)
+		}
+
+		for _, o := range listing.Objects {
+			oldObjectName := oldDir.GcsObjectName() + strings.TrimPrefix(o.Name, newDir.GcsObjectName())
+			if err = fs.invalidateChildFileCacheIfExist(oldParent, oldObjectName); err != nil {
+				return err
+			}
+		}
+
+		if listing.ContinuationToken == "" {
+			return nil
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+}
+
 // LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) Unlink(
 	ctx context.Context,
@@ -2224,14 +2858,23 @@ func (fs *fileSystem) OpenFile(
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	// Find the inode.
-	in := fs.fileInodeOrDie(op.Inode)
+	// Generations of objects can only be opened for reading.
+	version, isVersion := fs.inodes[op.Inode].(*inode.VersionInode)
+	if isVersion && !op.OpenFlags.IsReadOnly() {
+		return syscall.EROFS
+	}
 
 	// Allocate a handle.
 	handleID := fs.nextHandleID
 	fs.nextHandleID++
 
-	fs.handles[handleID] = handle.NewFileHandle(in, fs.fileCacheHandler, fs.cacheFileForRangeRead)
+	if isVersion {
+		fs.handles[handleID] = handle.NewVersionHandle(version)
+	} else {
+		// Find the inode.
+		in := fs.fileInodeOrDie(op.Inode)
+		fs.handles[handleID] = handle.NewFileHandle(in, fs.fileCacheHandler, fs.cacheFileForRangeRead, fs.readAhead, fs.blockCache)
+	}
 	op.Handle = handleID
 
 	// When we observe object generations that we didn't create, we assign them
@@ -2259,14 +2902,35 @@ func (fs *fileSystem) ReadFile(
 
 	// Find the handle and lock it.
 	fs.mu.Lock()
-	fh := fs.handles[op.Handle].(*handle.FileHandle)
+	h := fs.handles[op.Handle]
 	fs.mu.Unlock()
 
-	fh.Lock()
-	defer fh.Unlock()
-
 	// Serve the read.
-	op.BytesRead, err = fh.Read(ctx, op.Dst, op.Offset, fs.sequentialReadSizeMb)
+	if vh, ok := h.(*handle.VersionHandle); ok {
+		vh.Lock()
+		defer vh.Unlock()
+
+		op.BytesRead, err = vh.Read(ctx, op.Dst, op.Offset, fs.sequentialReadSizeMb)
+	} else {
+		fh := h.(*handle.FileHandle)
+		fh.Lock()
+		defer fh.Unlock()
+
+		// Note whether the file is local beforehand: reading back the writes
+		// streamed to a new file creates its object.
+		in := fh.Inode()
+		in.Lock()
+		wasLocal := in.IsLocal()
+		in.Unlock()
+
+		op.BytesRead, err = fh.Read(ctx, op.Dst, op.Offset, fs.sequentialReadSizeMb)
+
+		if wasLocal {
+			in.Lock()
+			fs.promoteIfNoLongerLocal(in, wasLocal)
+			in.Unlock()
+		}
+	}
 
 	// As required by fuse, we don't treat EOF as an error.
 	if err == io.EOF {
@@ -2312,6 +2976,7 @@ func (fs *fileSystem) WriteFile(
 
 	in.Lock()
 	defer in.Unlock()
+	defer fs.promoteIfNoLongerLocal(in, in.IsLocal())
 
 	// Serve the request.
 	if err := in.Write(ctx, op.Data, op.Offset); err != nil {
@@ -2367,6 +3032,11 @@ func (fs *fileSystem) FlushFile(
 	}
 	// Find the inode.
 	fs.mu.Lock()
+	if _, ok := fs.inodes[op.Inode].(*inode.VersionInode); ok {
+		// No-op for generations of objects, which are read-only.
+		fs.mu.Unlock()
+		return
+	}
 	in := fs.fileInodeOrDie(op.Inode)
 	fs.mu.Unlock()
 
@@ -2389,7 +3059,12 @@ func (fs *fileSystem) ReleaseFileHandle(
 	defer fs.mu.Unlock()
 
 	// Destroy the handle.
-	fs.handles[op.Handle].(*handle.FileHandle).Destroy()
+	switch h := fs.handles[op.Handle].(type) {
+	case *handle.VersionHandle:
+		h.Destroy()
+	default:
+		h.(*handle.FileHandle).Destroy()
+	}
 
 	// Update the map.
 	delete(fs.handles, op.Handle)
@@ -2397,14 +3072,138 @@ func (fs *fileSystem) ReleaseFileHandle(
 	return
 }
 
+// copyXattrValue copies the given value of extended attribute(s) to dst as
+// required by the xattr ops, returning the number of bytes which are or would
+// be copied. A zero length dst is a query for the size of the value.
+func copyXattrValue(dst []byte, value []byte) (n int, err error) {
+	n = len(value)
+	if len(dst) == 0 {
+		return
+	}
+	if len(dst) < n {
+		err = syscall.ERANGE
+		return
+	}
+	copy(dst, value)
+	return
+}
+
+// Extended attributes are supported only for files, as they are backed by the
+// custom metadata of objects. See inode.FileInode.GetXattr for details.
+//
+// LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) GetXattr(
 	ctx context.Context,
 	op *fuseops.GetXattrOp) (err error) {
-	return syscall.ENOSYS
+	// Answer lookups of attributes in other namespaces without waiting for any
+	// lock, e.g. one held by a write that is looking up security.capability.
+	if !inode.IsGCSXattr(op.Name) {
+		return syscall.ENODATA
+	}
+
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	file, ok := in.(*inode.FileInode)
+	if !ok {
+		return fuse.ENOATTR
+	}
+
+	file.Lock()
+	defer file.Unlock()
+
+	value, err := file.GetXattr(ctx, op.Name)
+	if err != nil {
+		return
+	}
+
+	op.BytesRead, err = copyXattrValue(op.Dst, value)
+	return
 }
 
+// LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) ListXattr(
 	ctx context.Context,
-	op *fuseops.ListXattrOp) error {
-	return syscall.ENOSYS
+	op *fuseops.ListXattrOp) (err error) {
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	file, ok := in.(*inode.FileInode)
+	if !ok {
+		return
+	}
+
+	file.Lock()
+	names := file.ListXattr()
+	file.Unlock()
+
+	// The names are NUL-terminated strings.
+	var value []byte
+	for _, name := range names {
+		value = append(value, name...)
+		value = append(value, 0)
+	}
+
+	op.BytesRead, err = copyXattrValue(op.Dst, value)
+	return
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) SetXattr(
+	ctx context.Context,
+	op *fuseops.SetXattrOp) (err error) {
+	if fs.mountConfig.FileSystemConfig.IgnoreInterrupts {
+		// When ignore interrupts config is set, we are creating a new context not
+		// cancellable by parent context.
+		var cancel context.CancelFunc
+		ctx, cancel = util.IsolateContextFromParentContext(ctx)
+		defer cancel()
+	}
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	file, ok := in.(*inode.FileInode)
+	if !ok {
+		return syscall.ENOTSUP
+	}
+
+	file.Lock()
+	defer file.Unlock()
+
+	err = file.SetXattr(ctx, op.Name, op.Value, op.Flags)
+	return
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) RemoveXattr(
+	ctx context.Context,
+	op *fuseops.RemoveXattrOp) (err error) {
+	if fs.mountConfig.FileSystemConfig.IgnoreInterrupts {
+		// When ignore interrupts config is set, we are creating a new context not
+		// cancellable by parent context.
+		var cancel context.CancelFunc
+		ctx, cancel = util.IsolateContextFromParentContext(ctx)
+		defer cancel()
+	}
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	file, ok := in.(*inode.FileInode)
+	if !ok {
+		return syscall.ENOTSUP
+	}
+
+	file.Lock()
+	defer file.Unlock()
+
+	err = file.RemoveXattr(ctx, op.Name)
+	return
 }
@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/contentcache"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/fs/inode"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
@@ -79,7 +80,8 @@ func (t *DirHandleTest) resetDirHandle() {
 		&t.bucket,
 		&t.clock,
 		&t.clock,
-		0)
+		0,
+		config.DefaultEvictionPolicy)
 
 	t.dh = NewDirHandle(
 		dirInode,
@@ -101,7 +103,11 @@ func (t *DirHandleTest) createLocalFileInode(name string, id fuseops.InodeID) (i
 		false, // localFileCache
 		contentcache.New("", &t.clock),
 		&t.clock,
-		true) // localFile
+		true, // localFile
+		config.DefaultConflictPolicy,
+		false, // streamingWrites
+		nil,   // dirtyJournal
+		nil)   // dirtyFiles
 	return
 }
 
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io"
 
+	blockcache "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/block"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/fs/inode"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
@@ -45,13 +46,23 @@ type FileHandle struct {
 	// cacheFileForRangeRead is also valid for cache workflow, if true, object content
 	// will be downloaded for random reads as well too.
 	cacheFileForRangeRead bool
+
+	// readAhead configures the prefetching of object contents ahead of reads
+	// served from GCS.
+	readAhead gcsx.ReadAheadConfig
+
+	// blockCache serves small random reads from memory. This will be nil if the
+	// block cache is disabled.
+	blockCache *blockcache.Cache
 }
 
-func NewFileHandle(inode *inode.FileInode, fileCacheHandler *file.CacheHandler, cacheFileForRangeRead bool) (fh *FileHandle) {
+func NewFileHandle(inode *inode.FileInode, fileCacheHandler *file.CacheHandler, cacheFileForRangeRead bool, readAhead gcsx.ReadAheadConfig, blockCache *blockcache.Cache) (fh *FileHandle) {
 	fh = &FileHandle{
 		inode:                 inode,
 		fileCacheHandler:      fileCacheHandler,
 		cacheFileForRangeRead: cacheFileForRangeRead,
+		readAhead:             readAhead,
+		blockCache:            blockCache,
 	}
 
 	fh.mu = syncutil.NewInvariantMutex(fh.checkInvariants)
@@ -170,7 +181,10 @@ func (fh *FileHandle) tryEnsureReader(ctx context.Context, sequentialReadSizeMb
 	}
 
 	// Attempt to create an appropriate reader.
-	rr := gcsx.NewRandomReader(fh.inode.Source(), fh.inode.Bucket(), sequentialReadSizeMb, fh.fileCacheHandler, fh.cacheFileForRangeRead)
+	rr := gcsx.NewRandomReader(fh.inode.Source(), fh.inode.Bucket(), sequentialReadSizeMb, fh.fileCacheHandler, fh.cacheFileForRangeRead, gcsx.RandomReaderOptions{
+		ReadAhead:  fh.readAhead,
+		BlockCache: fh.blockCache,
+	})
 
 	fh.reader = rr
 	return
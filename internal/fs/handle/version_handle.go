@@ -0,0 +1,104 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handle
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// VersionHandle is a handle to a read-only file for a generation of an object,
+// reading the generation with a random reader.
+type VersionHandle struct {
+	inode *inode.VersionInode
+
+	mu syncutil.InvariantMutex
+
+	// A random reader for the generation of the object, created on first read.
+	//
+	// INVARIANT: If reader != nil, reader.CheckInvariants() doesn't panic.
+	//
+	// GUARDED_BY(mu)
+	reader gcsx.RandomReader
+}
+
+func NewVersionHandle(inode *inode.VersionInode) (vh *VersionHandle) {
+	vh = &VersionHandle{
+		inode: inode,
+	}
+
+	vh.mu = syncutil.NewInvariantMutex(vh.checkInvariants)
+
+	return
+}
+
+// Destroy any resources associated with the handle, which must not be used
+// again.
+func (vh *VersionHandle) Destroy() {
+	if vh.reader != nil {
+		vh.reader.Destroy()
+	}
+}
+
+// Inode returns the inode backing this handle.
+func (vh *VersionHandle) Inode() *inode.VersionInode {
+	return vh.inode
+}
+
+func (vh *VersionHandle) Lock() {
+	vh.mu.Lock()
+}
+
+func (vh *VersionHandle) Unlock() {
+	vh.mu.Unlock()
+}
+
+// Read from the generation of the object with semantics matching
+// io.ReaderAt. The file cache isn't used, since it only holds the live
+// generations of objects.
+//
+// LOCKS_REQUIRED(vh)
+func (vh *VersionHandle) Read(ctx context.Context, dst []byte, offset int64, sequentialReadSizeMb int32) (n int, err error) {
+	// The generation never changes, hence the reader can be used for the
+	// lifetime of the handle.
+	if vh.reader == nil {
+		vh.reader = gcsx.NewRandomReader(vh.inode.Source(), vh.inode.Bucket(), sequentialReadSizeMb, nil, false, gcsx.RandomReaderOptions{})
+	}
+
+	n, _, err = vh.reader.ReadAt(ctx, dst, offset)
+	switch {
+	case err == io.EOF:
+		return
+
+	case err != nil:
+		err = fmt.Errorf("vh.reader.ReadAt: %w", err)
+		return
+	}
+
+	return
+}
+
+// LOCKS_REQUIRED(vh.mu)
+func (vh *VersionHandle) checkInvariants() {
+	// INVARIANT: If reader != nil, reader.CheckInvariants() doesn't panic.
+	if vh.reader != nil {
+		vh.reader.CheckInvariants()
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Tests of directories in a bucket with a hierarchical namespace.
+
+package fs_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type HNSDirTest struct {
+	fsTest
+}
+
+func init() {
+	RegisterTestSuite(&HNSDirTest{})
+}
+
+func (t *HNSDirTest) SetUpTestSuite() {
+	bucket = fake.NewHierarchicalFakeBucket(timeutil.RealClock(), "some_bucket")
+	t.fsTest.SetUpTestSuite()
+}
+
+// Create folders, and objects in them, in the bucket.
+func (t *HNSDirTest) createFolders(folders []string, objects map[string]string) {
+	for _, f := range folders {
+		_, err := bucket.CreateFolder(ctx, f)
+		AssertEq(nil, err)
+	}
+	for name, contents := range objects {
+		_, err := storageutil.CreateObject(ctx, bucket, name, []byte(contents))
+		AssertEq(nil, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *HNSDirTest) RenameDir() {
+	t.createFolders([]string{"foo/"}, map[string]string{"foo/baz": "taco"})
+
+	err := os.Rename(path.Join(mntDir, "foo"), path.Join(mntDir, "bar"))
+
+	AssertEq(nil, err)
+	_, err = os.Stat(path.Join(mntDir, "foo"))
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+	contents, err := os.ReadFile(path.Join(mntDir, "bar/baz"))
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *HNSDirTest) RenameDir_OldParentForgetsOldDir() {
+	t.createFolders([]string{"foo/"}, map[string]string{"foo/baz": "taco"})
+	_, err := os.Stat(path.Join(mntDir, "foo"))
+	AssertEq(nil, err)
+
+	err = os.Rename(path.Join(mntDir, "foo"), path.Join(mntDir, "bar"))
+	AssertEq(nil, err)
+
+	// A file created with the old name by someone else shows up at once,
+	// rather than being hidden by the old parent's record of the old directory.
+	_, err = storageutil.CreateObject(ctx, bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+	fi, err := os.Stat(path.Join(mntDir, "foo"))
+	AssertEq(nil, err)
+	ExpectFalse(fi.IsDir())
+	entries, err := os.ReadDir(mntDir)
+	AssertEq(nil, err)
+	AssertEq(2, len(entries))
+	ExpectEq("bar", entries[0].Name())
+	ExpectTrue(entries[0].IsDir())
+	ExpectEq("foo", entries[1].Name())
+	ExpectFalse(entries[1].IsDir())
+}
+
+func (t *HNSDirTest) RenameDir_OverEmptyDir() {
+	t.createFolders([]string{"foo/", "bar/"}, map[string]string{"foo/baz": "taco"})
+
+	err := os.Rename(path.Join(mntDir, "foo"), path.Join(mntDir, "bar"))
+
+	AssertEq(nil, err)
+	contents, err := storageutil.ReadObject(ctx, bucket, "bar/baz")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *HNSDirTest) RenameDir_OverNonEmptyDir() {
+	t.createFolders(
+		[]string{"foo/", "bar/"},
+		map[string]string{"foo/baz": "taco", "bar/": "", "bar/qux": "burrito"})
+
+	err := os.Rename(path.Join(mntDir, "foo"), path.Join(mntDir, "bar"))
+
+	ExpectTrue(errors.Is(err, syscall.ENOTEMPTY), "err: %v", err)
+	// Neither directory has been touched, not even the new directory's backing
+	// object.
+	_, err = bucket.GetFolder(ctx, "foo/")
+	ExpectEq(nil, err)
+	_, err = storageutil.ReadObject(ctx, bucket, "foo/baz")
+	ExpectEq(nil, err)
+	_, err = storageutil.ReadObject(ctx, bucket, "bar/")
+	ExpectEq(nil, err)
+	_, err = storageutil.ReadObject(ctx, bucket, "bar/qux")
+	ExpectEq(nil, err)
+}
+
+func (t *HNSDirTest) RenameDir_OverDirWithEmptySubfolder() {
+	t.createFolders([]string{"foo/", "bar/", "bar/qux/"}, map[string]string{"bar/": ""})
+
+	err := os.Rename(path.Join(mntDir, "foo"), path.Join(mntDir, "bar"))
+
+	ExpectTrue(errors.Is(err, syscall.ENOTEMPTY), "err: %v", err)
+	_, err = storageutil.ReadObject(ctx, bucket, "bar/")
+	ExpectEq(nil, err)
+	_, err = bucket.GetFolder(ctx, "bar/qux/")
+	ExpectEq(nil, err)
+}
@@ -215,6 +215,10 @@ func (d *baseDirInode) CreateChildDir(ctx context.Context, name string) (*Core,
 	return nil, fuse.ENOSYS
 }
 
+func (d *baseDirInode) RenameToChildDir(ctx context.Context, name string, src Name) (*Core, error) {
+	return nil, fuse.ENOSYS
+}
+
 func (d *baseDirInode) DeleteChildFile(
 	ctx context.Context,
 	name string,
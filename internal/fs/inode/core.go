@@ -37,6 +37,11 @@ type Core struct {
 
 	// Specifies a local object which is not yet synced to GCS.
 	Local bool
+
+	// Specifies an inode within the read-only directory of object versions (see
+	// VersionsDirName). MinObject, if present, is the generation of the object
+	// that the inode exposes, rather than an object named FullName.
+	Version bool
 }
 
 // Exists returns true iff the back object exists implicitly or explicitly.
@@ -62,7 +67,7 @@ func (c *Core) Type() metadata.Type {
 // SanityCheck returns an error if the object is conflicting with itself, which
 // means the metadata of the file system is broken.
 func (c Core) SanityCheck() error {
-	if c.MinObject != nil && c.FullName.objectName != c.MinObject.Name {
+	if c.MinObject != nil && !c.Version && c.FullName.objectName != c.MinObject.Name {
 		return fmt.Errorf("inode name %q mismatches object name %q", c.FullName, c.MinObject.Name)
 	}
 
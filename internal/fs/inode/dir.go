@@ -19,8 +19,10 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/metadata"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
@@ -106,10 +108,18 @@ type DirInode interface {
 
 	// Create a backing object for a child directory with the supplied (relative)
 	// name, failing with *gcs.PreconditionError if a backing object already
-	// exists in GCS.
+	// exists in GCS. In a bucket with a hierarchical namespace, the child is
+	// backed by a folder instead.
 	// Return the full name of the child and the GCS object it backs up.
 	CreateChildDir(ctx context.Context, name string) (*Core, error)
 
+	// Like CreateChildDir, except rename the folder backing the supplied source
+	// directory, along with everything in it, instead of creating an empty
+	// folder. This takes a single call, and is only supported in buckets with a
+	// hierarchical namespace.
+	// Return the full name of the child and the folder it backs up.
+	RenameToChildDir(ctx context.Context, name string, src Name) (*Core, error)
+
 	// Delete the backing object for the child file or symlink with the given
 	// (relative) name and generation number, where zero means the latest
 	// generation. If the object/generation doesn't exist, no error is returned.
@@ -179,6 +189,8 @@ type dirInode struct {
 	// INVARIANT: name.IsDir()
 	name Name
 
+	// Mode, uid and gid of explicit directories may be changed by
+	// SetPosixAttributes while holding mu.
 	attrs fuseops.InodeAttributes
 
 	/////////////////////////
@@ -220,7 +232,8 @@ var _ DirInode = &dirInode{}
 // maintained. This may speed up calls to LookUpChild, especially when combined
 // with a stat-caching GCS bucket, but comes at the cost of consistency: if the
 // child is removed and recreated with a different type before the expiration,
-// we may fail to find it.
+// we may fail to find it. Its entries are evicted according to the named
+// typeCacheEvictionPolicy, LRU if empty.
 //
 // The initial lookup count is zero.
 //
@@ -236,12 +249,18 @@ func NewDirInode(
 	bucket *gcsx.SyncerBucket,
 	mtimeClock timeutil.Clock,
 	cacheClock timeutil.Clock,
-	typeCacheMaxSizeMB int) (d DirInode) {
+	typeCacheMaxSizeMB int,
+	typeCacheEvictionPolicy string) (d DirInode) {
 
 	if !name.IsDir() {
 		panic(fmt.Sprintf("Unexpected name: %s", name))
 	}
 
+	policy, err := lru.NewEvictionPolicy(typeCacheEvictionPolicy, typeCacheTTL, cacheClock)
+	if err != nil {
+		panic(fmt.Sprintf("Type cache of %s: %v", name, err))
+	}
+
 	typed := &dirInode{
 		bucket:                      bucket,
 		mtimeClock:                  mtimeClock,
@@ -252,7 +271,7 @@ func NewDirInode(
 		enableNonexistentTypeCache:  enableNonexistentTypeCache,
 		name:                        name,
 		attrs:                       attrs,
-		cache:                       metadata.NewTypeCache(typeCacheMaxSizeMB, typeCacheTTL),
+		cache:                       metadata.NewTypeCacheWithPolicy(typeCacheMaxSizeMB, typeCacheTTL, policy),
 	}
 
 	typed.lc.Init(id)
@@ -281,6 +300,9 @@ func (d *dirInode) lookUpChildFile(ctx context.Context, name string) (*Core, err
 
 func (d *dirInode) lookUpChildDir(ctx context.Context, name string) (*Core, error) {
 	childName := NewDirName(d.Name(), name)
+	if d.isBucketHierarchical() {
+		return findExplicitFolder(ctx, d.Bucket(), childName)
+	}
 	if d.implicitDirs {
 		return findDirInode(ctx, d.Bucket(), childName)
 	}
@@ -344,6 +366,29 @@ func findExplicitInode(ctx context.Context, bucket *gcsx.SyncerBucket, name Name
 	}, nil
 }
 
+// findExplicitFolder finds the dir inode core backed by a folder in GCS with the
+// given name. Return nil if such folder does not exist.
+func findExplicitFolder(ctx context.Context, bucket *gcsx.SyncerBucket, name Name) (*Core, error) {
+	folder, err := bucket.GetFolder(ctx, name.GcsObjectName())
+
+	// Suppress "not found" errors.
+	var gcsErr *gcs.NotFoundError
+	if errors.As(err, &gcsErr) {
+		return nil, nil
+	}
+
+	// Annotate others.
+	if err != nil {
+		return nil, fmt.Errorf("GetFolder: %w", err)
+	}
+
+	return &Core{
+		Bucket:    bucket,
+		FullName:  name,
+		MinObject: storageutil.ConvertFolderToMinObject(folder),
+	}, nil
+}
+
 // findDirInode finds the dir inode core where the directory is either explicit
 // or implicit. Returns nil if no such directory exists.
 func findDirInode(ctx context.Context, bucket *gcsx.SyncerBucket, name Name) (*Core, error) {
@@ -374,6 +419,18 @@ func findDirInode(ctx context.Context, bucket *gcsx.SyncerBucket, name Name) (*C
 	return result, nil
 }
 
+// In a bucket with a hierarchical namespace, directories are backed by folders
+// rather than objects, and none of them are implicit.
+func (d *dirInode) isBucketHierarchical() bool {
+	return d.bucket.BucketType() == gcs.Hierarchical
+}
+
+// The journals of directory renames live in a directory in the root of the
+// bucket, which is hidden from users.
+func (d *dirInode) isHiddenChild(name string) bool {
+	return d.Name().IsBucketRoot() && name+"/" == gcsx.RenameJournalPrefix
+}
+
 // Fail if the name already exists. Pass on errors directly.
 func (d *dirInode) createNewObject(
 	ctx context.Context,
@@ -484,6 +541,10 @@ func (d *dirInode) LookUpChild(ctx context.Context, name string) (*Core, error)
 		return d.lookUpConflicting(ctx, name)
 	}
 
+	if d.isHiddenChild(name) {
+		return nil, nil
+	}
+
 	var fileResult *Core
 	var dirResult *Core
 	lookUpFile := func(ctx context.Context) (err error) {
@@ -498,6 +559,13 @@ func (d *dirInode) LookUpChild(ctx context.Context, name string) (*Core, error)
 		dirResult, err = findDirInode(ctx, d.Bucket(), NewDirName(d.Name(), name))
 		return
 	}
+	if d.isBucketHierarchical() {
+		lookUpExplicitDir = func(ctx context.Context) (err error) {
+			dirResult, err = findExplicitFolder(ctx, d.Bucket(), NewDirName(d.Name(), name))
+			return
+		}
+		lookUpImplicitOrExplicitDir = lookUpExplicitDir
+	}
 
 	b := syncutil.NewBundle(ctx)
 
@@ -597,7 +665,7 @@ func (d *dirInode) readObjects(
 		// Setting Projection param to noAcl since fetching owner and acls are not
 		// required.
 		ProjectionVal:            gcs.NoAcl,
-		IncludeFoldersAsPrefixes: d.enableManagedFoldersListing,
+		IncludeFoldersAsPrefixes: d.enableManagedFoldersListing || d.isBucketHierarchical(),
 	}
 
 	listing, err := d.bucket.ListObjects(ctx, req)
@@ -621,6 +689,9 @@ func (d *dirInode) readObjects(
 		}
 
 		nameBase := path.Base(o.Name) // ie. "bar" from "foo/bar/" or "foo/bar"
+		if d.isHiddenChild(nameBase) {
+			continue
+		}
 
 		// Given the alphabetical order of the objects, if a file "foo" and
 		// directory "foo/" coexist, the directory would eventually occupy
@@ -647,6 +718,22 @@ func (d *dirInode) readObjects(
 	// Return an appropriate continuation token, if any.
 	newTok = listing.ContinuationToken
 
+	// Each collapsed run in a bucket with a hierarchical namespace is a folder.
+	if d.isBucketHierarchical() {
+		for _, p := range listing.CollapsedRuns {
+			if d.isHiddenChild(path.Base(p)) {
+				continue
+			}
+			dirName := NewDirName(d.Name(), path.Base(p))
+			cores[dirName] = &Core{
+				Bucket:    d.Bucket(),
+				FullName:  dirName,
+				MinObject: storageutil.ConvertFolderToMinObject(&gcs.Folder{Name: p}),
+			}
+		}
+		return
+	}
+
 	if !d.implicitDirs {
 		return
 	}
@@ -654,6 +741,9 @@ func (d *dirInode) readObjects(
 	// Add implicit directories into the result.
 	for _, p := range listing.CollapsedRuns {
 		pathBase := path.Base(p)
+		if d.isHiddenChild(pathBase) {
+			continue
+		}
 		dirName := NewDirName(d.Name(), pathBase)
 		if c, ok := cores[dirName]; ok && c.Type() == metadata.ExplicitDirType {
 			continue
@@ -786,18 +876,52 @@ func (d *dirInode) CreateChildSymlink(ctx context.Context, name string, target s
 // LOCKS_REQUIRED(d)
 func (d *dirInode) CreateChildDir(ctx context.Context, name string) (*Core, error) {
 	fullName := NewDirName(d.Name(), name)
-	o, err := d.createNewObject(ctx, fullName, nil)
+
+	var m *gcs.MinObject
+	if d.isBucketHierarchical() {
+		folder, err := d.bucket.CreateFolder(ctx, fullName.GcsObjectName())
+		if err != nil {
+			return nil, err
+		}
+		m = storageutil.ConvertFolderToMinObject(folder)
+	} else {
+		o, err := d.createNewObject(ctx, fullName, nil)
+		if err != nil {
+			return nil, err
+		}
+		m = storageutil.ConvertObjToMinObject(o)
+	}
+
+	d.cache.Insert(d.cacheClock.Now(), name, metadata.ExplicitDirType)
+
+	return &Core{
+		Bucket:    d.Bucket(),
+		FullName:  fullName,
+		MinObject: m,
+	}, nil
+}
+
+// LOCKS_REQUIRED(d)
+func (d *dirInode) RenameToChildDir(ctx context.Context, name string, src Name) (*Core, error) {
+	if !d.isBucketHierarchical() {
+		return nil, fmt.Errorf("rename folder %q: %w", src, syscall.ENOTSUP)
+	}
+
+	// Erase any existing type information for this name.
+	d.cache.Erase(name)
+	fullName := NewDirName(d.Name(), name)
+
+	folder, err := d.bucket.RenameFolder(ctx, src.GcsObjectName(), fullName.GcsObjectName())
 	if err != nil {
 		return nil, err
 	}
-	m := storageutil.ConvertObjToMinObject(o)
 
 	d.cache.Insert(d.cacheClock.Now(), name, metadata.ExplicitDirType)
 
 	return &Core{
 		Bucket:    d.Bucket(),
 		FullName:  fullName,
-		MinObject: m,
+		MinObject: storageutil.ConvertFolderToMinObject(folder),
 	}, nil
 }
 
@@ -855,7 +979,7 @@ func (d *dirInode) DeleteChildDir(
 		return
 	}
 
-	if d.bucket.BucketType() == gcs.Hierarchical {
+	if d.isBucketHierarchical() {
 		// Delete Folder deletes folder (in case of Hierarchical Bucket).
 		err = d.bucket.DeleteFolder(ctx, childName.GcsObjectName())
 	}
@@ -118,7 +118,8 @@ func (t *DirTest) resetInodeWithTypeCacheConfigs(implicitDirs, enableNonexistent
 		&t.bucket,
 		&t.clock,
 		&t.clock,
-		typeCacheMaxSizeMB)
+		typeCacheMaxSizeMB,
+		config.DefaultEvictionPolicy)
 
 	d := t.in.(*dirInode)
 	AssertNe(nil, d)
@@ -181,7 +182,11 @@ func (t *DirTest) createLocalFileInode(parent Name, name string, id fuseops.Inod
 		false, // localFileCache
 		contentcache.New("", &t.clock),
 		&t.clock,
-		true) //localFile
+		true, //localFile
+		config.DefaultConflictPolicy,
+		false, // streamingWrites
+		nil,   // dirtyJournal
+		nil)   // dirtyFiles
 	return
 }
 
@@ -759,6 +764,46 @@ func (t *DirTest) ReadEntries_Empty() {
 	AssertNe(nil, d.prevDirListingTimeStamp)
 }
 
+func (t *DirTest) RenameJournalsHiddenInBucketRoot() {
+	root := NewDirInode(
+		fuseops.RootInodeID,
+		NewRootName(""),
+		fuseops.InodeAttributes{
+			Uid:  uid,
+			Gid:  gid,
+			Mode: dirMode,
+		},
+		true, // implicitDirs
+		false,
+		false,
+		typeCacheTTL,
+		&t.bucket,
+		&t.clock,
+		&t.clock,
+		config.DefaultTypeCacheMaxSizeMB,
+		config.DefaultEvictionPolicy)
+	root.Lock()
+	defer root.Unlock()
+	err := storageutil.CreateEmptyObjects(t.ctx, t.bucket, []string{
+		gcsx.RenameJournalPrefix,
+		gcsx.RenameJournalPrefix + "journal",
+		"file",
+	})
+	AssertEq(nil, err)
+
+	core, err := root.LookUpChild(t.ctx, "file")
+	AssertEq(nil, err)
+	ExpectNe(nil, core)
+	core, err = root.LookUpChild(t.ctx, ".gcsfuse_rename")
+	AssertEq(nil, err)
+	ExpectEq(nil, core)
+
+	entries, _, err := root.ReadEntries(t.ctx, "")
+	AssertEq(nil, err)
+	AssertEq(1, len(entries))
+	ExpectEq("file", entries[0].Name)
+}
+
 func (t *DirTest) ReadEntries_NonEmpty_ImplicitDirsDisabled() {
 	var err error
 	var entry fuseutil.Dirent
@@ -0,0 +1,63 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import "sync"
+
+// DirtyFiles is the set of file inodes whose content has been modified since
+// it was last synced, as kept up to date by the inodes given it, so that the
+// auto-sync needn't look at every inode. See FileInode.AutoSync.
+//
+// Safe for concurrent use, including while holding inode locks.
+type DirtyFiles struct {
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	files map[*FileInode]struct{}
+}
+
+// NewDirtyFiles creates an empty DirtyFiles.
+func NewDirtyFiles() *DirtyFiles {
+	return &DirtyFiles{
+		files: make(map[*FileInode]struct{}),
+	}
+}
+
+// List returns the inodes in the set. They must be locked before being
+// examined, by which time they may have been synced or destroyed.
+func (d *DirtyFiles) List() (files []*FileInode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	files = make([]*FileInode, 0, len(d.files))
+	for f := range d.files {
+		files = append(files, f)
+	}
+	return
+}
+
+func (d *DirtyFiles) add(f *FileInode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.files[f] = struct{}{}
+}
+
+func (d *DirtyFiles) remove(f *FileInode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.files, f)
+}
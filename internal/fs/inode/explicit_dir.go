@@ -15,12 +15,17 @@
 package inode
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
 )
 
 // An inode representing a directory backed by an object in GCS with a specific
@@ -28,6 +33,15 @@ import (
 type ExplicitDirInode interface {
 	DirInode
 	SourceGeneration() Generation
+
+	// SetPosixAttributes sets the given mode, uid and gid of the directory and
+	// stores them in the custom metadata of the backing object, see
+	// ApplyPosixAttributes. Nil attributes are left unchanged.
+	SetPosixAttributes(
+		ctx context.Context,
+		mode *os.FileMode,
+		uid *uint32,
+		gid *uint32) error
 }
 
 // Create an explicit dir inode backed by the supplied object. See notes on
@@ -44,7 +58,8 @@ func NewExplicitDirInode(
 	bucket *gcsx.SyncerBucket,
 	mtimeClock timeutil.Clock,
 	cacheClock timeutil.Clock,
-	typeCacheMaxSizeMB int) (d ExplicitDirInode) {
+	typeCacheMaxSizeMB int,
+	typeCacheEvictionPolicy string) (d ExplicitDirInode) {
 	wrapped := NewDirInode(
 		id,
 		name,
@@ -56,7 +71,8 @@ func NewExplicitDirInode(
 		bucket,
 		mtimeClock,
 		cacheClock,
-		typeCacheMaxSizeMB)
+		typeCacheMaxSizeMB,
+		typeCacheEvictionPolicy)
 
 	d = &explicitDirInode{
 		dirInode: wrapped.(*dirInode),
@@ -78,3 +94,39 @@ func (d *explicitDirInode) SourceGeneration() (gen Generation) {
 	gen = d.generation
 	return
 }
+
+// LOCKS_REQUIRED(d)
+func (d *explicitDirInode) SetPosixAttributes(
+	ctx context.Context,
+	mode *os.FileMode,
+	uid *uint32,
+	gid *uint32) (err error) {
+	metadata := posixAttributesMetadata(mode, uid, gid)
+	if len(metadata) == 0 {
+		return
+	}
+
+	req := &gcs.UpdateObjectRequest{
+		Name:                       d.Name().GcsObjectName(),
+		Generation:                 d.generation.Object,
+		MetaGenerationPrecondition: &d.generation.Metadata,
+		Metadata:                   metadata,
+	}
+	o, err := d.Bucket().UpdateObject(ctx, req)
+
+	// The directory object has been clobbered or modified by someone else.
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &notFoundErr) || errors.As(err, &preconditionErr) {
+		err = syscall.ESTALE
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("UpdateObject: %w", err)
+		return
+	}
+
+	d.generation.Metadata = o.MetaGeneration
+	setPosixAttributes(&d.attrs, mode, uid, gid)
+	return
+}
@@ -18,12 +18,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/contentcache"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/monitor"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
 	"github.com/jacobsa/fuse/fuseops"
@@ -36,6 +41,13 @@ import (
 // the format defined by time.RFC3339Nano.
 const FileMtimeMetadataKey = gcsx.MtimeMetadataKey
 
+// How long after a failed auto-sync of a file it is first retried, doubling
+// with each consecutive failure up to the maximum. See FileInode.AutoSync.
+const (
+	autoSyncMinRetryDelay = time.Second
+	autoSyncMaxRetryDelay = time.Minute
+)
+
 type FileInode struct {
 	/////////////////////////
 	// Dependencies
@@ -50,12 +62,28 @@ type FileInode struct {
 
 	id           fuseops.InodeID
 	name         Name
-	attrs        fuseops.InodeAttributes
 	contentCache *contentcache.ContentCache
 	// TODO (#640) remove bool flag and refactor contentCache to support two implementations:
 	// one implementation with original functionality and one with new persistent disk content cache
 	localFileCache bool
 
+	// What to do with the dirty content when the object has been clobbered,
+	// one of the conflict policies in config.
+	conflictPolicy string
+
+	// Whether the writes to a new file may be streamed to its object rather
+	// than staged in a temp file. See Write.
+	streamingWrites bool
+
+	// The journal in which temp files are created and their manifests recorded
+	// before they are modified, so that dirty content survives a crash, or nil
+	// if temp files are anonymous.
+	dirtyJournal *gcsx.DirtyJournal
+
+	// The set to which the inode belongs while its content is dirty, or nil if
+	// the auto-sync is disabled.
+	dirtyFiles *DirtyFiles
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
@@ -67,6 +95,18 @@ type FileInode struct {
 	// GUARDED_BY(mu)
 	lc lookupCount
 
+	// The attributes of this inode other than the ones derived from the source
+	// object. Only mode, uid and gid may change, by SetPosixAttributes.
+	//
+	// GUARDED_BY(mu)
+	attrs fuseops.InodeAttributes
+
+	// Custom metadata updates made to a local file, which are applied to the
+	// object once it is created by Sync.
+	//
+	// GUARDED_BY(mu)
+	pendingMetadata map[string]*string
+
 	// The source object from which this inode derives.
 	//
 	// INVARIANT: for non local files,  src.Name == name.GcsObjectName()
@@ -78,6 +118,23 @@ type FileInode struct {
 	// authoritative.
 	content gcsx.TempFile
 
+	// The upload to which the writes to a new file are being streamed, or nil.
+	// While non-nil, content is nil and the contents of the file are those
+	// written to the upload so far, which were last modified at streamingMtime.
+	//
+	// GUARDED_BY(mu)
+	streamer       *gcsx.StreamingUpload
+	streamingMtime time.Time
+
+	// The error with which the upload of streamed contents failed, if it did,
+	// and their size. The contents are then lost, so until the file is unlinked
+	// or released, it has no content, and anything that needs it fails with
+	// syscall.EIO rather than presenting the file as empty.
+	//
+	// GUARDED_BY(mu)
+	streamingErr      error
+	streamingLostSize int64
+
 	// Has Destroy been called?
 	//
 	// GUARDED_BY(mu)
@@ -88,6 +145,26 @@ type FileInode struct {
 
 	// Represents if local file has been unlinked.
 	unlinked bool
+
+	// Has the conflict between the current content and the clobbered object
+	// been handled? Reset when the content is modified, so that the same
+	// content is not handled again by every sync.
+	//
+	// GUARDED_BY(mu)
+	conflictHandled bool
+
+	// When the content was first modified since it was last synced, or zero if
+	// it hasn't been. See AutoSync.
+	//
+	// GUARDED_BY(mu)
+	dirtiedAt time.Time
+
+	// The number of consecutive auto-syncs that have failed since the content
+	// was last synced, and the time before which it isn't retried.
+	//
+	// GUARDED_BY(mu)
+	autoSyncFailures int
+	autoSyncRetryAt  time.Time
 }
 
 var _ Inode = &FileInode{}
@@ -109,23 +186,31 @@ func NewFileInode(
 	localFileCache bool,
 	contentCache *contentcache.ContentCache,
 	mtimeClock timeutil.Clock,
-	localFile bool) (f *FileInode) {
+	localFile bool,
+	conflictPolicy string,
+	streamingWrites bool,
+	dirtyJournal *gcsx.DirtyJournal,
+	dirtyFiles *DirtyFiles) (f *FileInode) {
 	// Set up the basic struct.
 	var minObj gcs.MinObject
 	if m != nil {
 		minObj = *m
 	}
 	f = &FileInode{
-		bucket:         bucket,
-		mtimeClock:     mtimeClock,
-		id:             id,
-		name:           name,
-		attrs:          attrs,
-		localFileCache: localFileCache,
-		contentCache:   contentCache,
-		src:            minObj,
-		local:          localFile,
-		unlinked:       false,
+		bucket:          bucket,
+		mtimeClock:      mtimeClock,
+		id:              id,
+		name:            name,
+		attrs:           attrs,
+		localFileCache:  localFileCache,
+		contentCache:    contentCache,
+		src:             minObj,
+		local:           localFile,
+		unlinked:        false,
+		conflictPolicy:  conflictPolicy,
+		streamingWrites: streamingWrites,
+		dirtyJournal:    dirtyJournal,
+		dirtyFiles:      dirtyFiles,
 	}
 
 	f.lc.Init(id)
@@ -165,6 +250,11 @@ func (f *FileInode) checkInvariants() {
 	if f.content != nil {
 		f.content.CheckInvariants()
 	}
+
+	// INVARIANT: If streamer != nil, content == nil and the file is local
+	if f.streamer != nil && (f.content != nil || !f.IsLocal()) {
+		panic("Streaming to an object with a temp file or a source object")
+	}
 }
 
 // LOCKS_REQUIRED(f.mu)
@@ -265,7 +355,7 @@ func (f *FileInode) ensureContent(ctx context.Context) (err error) {
 			return err
 		}
 
-		tf, err := f.contentCache.NewTempFile(rc)
+		tf, err := f.newTempFile(rc)
 		if err != nil {
 			err = fmt.Errorf("NewTempFile: %w", err)
 			return err
@@ -277,6 +367,46 @@ func (f *FileInode) ensureContent(ctx context.Context) (err error) {
 	return
 }
 
+// Create a temp file with the supplied initial contents, in the dirty journal
+// if there is one.
+func (f *FileInode) newTempFile(rc io.ReadCloser) (gcsx.TempFile, error) {
+	if f.dirtyJournal != nil {
+		return f.dirtyJournal.NewTempFile(rc)
+	}
+	return f.contentCache.NewTempFile(rc)
+}
+
+// Record the manifest of the content in the dirty journal, if any, ahead of a
+// modification after which the content may differ from the source object from
+// dirtyThreshold onwards. The content of an unlinked file is not recorded.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) journalContent(dirtyThreshold int64) (err error) {
+	if f.dirtyJournal == nil || f.IsUnlinked() {
+		return
+	}
+
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+
+	err = f.dirtyJournal.Record(f.content, gcsx.DirtyManifest{
+		BucketName:       f.bucket.Name(),
+		ObjectName:       f.name.GcsObjectName(),
+		ObjectPrefix:     f.bucket.ObjectPrefix,
+		SourceGeneration: f.src.Generation,
+		DirtyThreshold:   min(sr.DirtyThreshold, dirtyThreshold),
+	})
+	if err != nil {
+		err = fmt.Errorf("Record: %w", err)
+		return
+	}
+
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Public interface
 ////////////////////////////////////////////////////////////////////////
@@ -307,6 +437,29 @@ func (f *FileInode) IsUnlinked() bool {
 
 func (f *FileInode) Unlink() {
 	f.unlinked = true
+
+	// The content of an unlinked file must not be recovered after a crash.
+	if f.dirtyJournal != nil && f.content != nil {
+		f.dirtyJournal.Forget(f.content)
+	}
+
+	// The streamed contents of an unlinked file must never make it to the
+	// bucket, so throw them away now and continue with an empty temp file.
+	if f.streamer != nil {
+		f.streamer.Abort()
+		f.streamer = nil
+		if err := f.CreateEmptyTempFile(); err != nil {
+			logger.Errorf("CreateEmptyTempFile for unlinked file %q: %v", f.name.GcsObjectName(), err)
+		}
+	}
+
+	// Likewise if they have already been lost.
+	if f.streamingErr != nil {
+		f.streamingErr = nil
+		if err := f.CreateEmptyTempFile(); err != nil {
+			logger.Errorf("CreateEmptyTempFile for unlinked file %q: %v", f.name.GcsObjectName(), err)
+		}
+	}
 }
 
 // Source returns a record for the GCS object from which this inode is branched. The
@@ -326,7 +479,7 @@ func (f *FileInode) Source() *gcs.MinObject {
 //
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) SourceGenerationIsAuthoritative() bool {
-	return f.content == nil
+	return f.content == nil && f.streamer == nil && f.streamingErr == nil
 }
 
 // Equivalent to the generation returned by f.Source().
@@ -352,6 +505,13 @@ func (f *FileInode) DecrementLookupCount(n uint64) (destroy bool) {
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) Destroy() (err error) {
 	f.destroyed = true
+	if f.dirtyFiles != nil {
+		f.dirtyFiles.remove(f)
+	}
+	if f.streamer != nil {
+		f.streamer.Abort()
+		f.streamer = nil
+	}
 	if f.localFileCache {
 		cacheObjectKey := &contentcache.CacheObjectKey{BucketName: f.bucket.Name(), ObjectName: f.name.objectName}
 		f.contentCache.Remove(cacheObjectKey)
@@ -403,6 +563,15 @@ func (f *FileInode) Attributes(
 		}
 	}
 
+	// Likewise for contents being streamed to the object.
+	if f.streamer != nil {
+		attrs.Size = uint64(f.streamer.Size())
+		attrs.Mtime = f.streamingMtime
+	} else if f.streamingErr != nil {
+		attrs.Size = uint64(f.streamingLostSize)
+		attrs.Mtime = f.streamingMtime
+	}
+
 	// We require only that atime and ctime be "reasonable".
 	attrs.Atime = attrs.Mtime
 	attrs.Ctime = attrs.Mtime
@@ -439,6 +608,20 @@ func (f *FileInode) Read(
 	ctx context.Context,
 	dst []byte,
 	offset int64) (n int, err error) {
+	err = f.checkStreamingErr()
+	if err != nil {
+		return
+	}
+
+	// Reading back streamed contents means fetching them from the object.
+	if f.streamer != nil {
+		err = f.finishStreaming(ctx)
+		if err != nil {
+			err = fmt.Errorf("finishStreaming: %w", err)
+			return
+		}
+	}
+
 	// Make sure f.content != nil.
 	err = f.ensureContent(ctx)
 	if err != nil {
@@ -462,11 +645,42 @@ func (f *FileInode) Read(
 
 // Serve a write for this file with semantics matching fuseops.WriteFileOp.
 //
+// If streaming writes are enabled, the writes to a new file are streamed to
+// its object for as long as they are sequential, starting at offset zero.
+// Anything else that needs the contents, such as an out-of-order write or a
+// read, finishes the upload first, after which the file's contents are fetched
+// from the object into a temp file as for any other file.
+//
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) Write(
 	ctx context.Context,
 	data []byte,
 	offset int64) (err error) {
+	err = f.checkStreamingErr()
+	if err != nil {
+		return
+	}
+
+	err = f.maybeStartStreaming(offset)
+	if err != nil {
+		err = fmt.Errorf("maybeStartStreaming: %w", err)
+		return
+	}
+
+	if f.streamer != nil {
+		if offset == f.streamer.Size() {
+			_, err = f.streamer.Write(data)
+			f.streamingMtime = f.mtimeClock.Now()
+			return
+		}
+
+		err = f.finishStreaming(ctx)
+		if err != nil {
+			err = fmt.Errorf("finishStreaming: %w", err)
+			return
+		}
+	}
+
 	// Make sure f.content != nil.
 	err = f.ensureContent(ctx)
 	if err != nil {
@@ -474,9 +688,17 @@ func (f *FileInode) Write(
 		return
 	}
 
+	err = f.journalContent(offset)
+	if err != nil {
+		err = fmt.Errorf("journalContent: %w", err)
+		return
+	}
+
 	// Write to the mutable content. Note that io.WriterAt guarantees it returns
 	// an error for short writes.
 	_, err = f.content.WriteAt(data, offset)
+	f.conflictHandled = false
+	f.markDirtied()
 
 	return
 }
@@ -487,6 +709,23 @@ func (f *FileInode) Write(
 func (f *FileInode) SetMtime(
 	ctx context.Context,
 	mtime time.Time) (err error) {
+	err = f.checkStreamingErr()
+	if err != nil {
+		return
+	}
+
+	// If contents are being streamed to the object, store the mtime in its
+	// metadata once it is created.
+	if f.streamer != nil {
+		formatted := mtime.UTC().Format(time.RFC3339Nano)
+		if f.pendingMetadata == nil {
+			f.pendingMetadata = make(map[string]*string)
+		}
+		f.pendingMetadata[FileMtimeMetadataKey] = &formatted
+		f.streamingMtime = mtime
+		return
+	}
+
 	// If we have a local temp file, stat it.
 	var sr gcsx.StatResult
 	if f.content != nil {
@@ -555,9 +794,43 @@ func (f *FileInode) SetMtime(
 	return
 }
 
+// SetPosixAttributes sets the given mode, uid and gid of the file and stores
+// them in the custom metadata of the backing object, see
+// ApplyPosixAttributes. Nil attributes are left unchanged. For a local file,
+// the metadata is stored when the object is created by Sync.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SetPosixAttributes(
+	ctx context.Context,
+	mode *os.FileMode,
+	uid *uint32,
+	gid *uint32) (err error) {
+	metadata := posixAttributesMetadata(mode, uid, gid)
+	if len(metadata) == 0 {
+		return
+	}
+
+	if f.IsLocal() {
+		if f.pendingMetadata == nil {
+			f.pendingMetadata = make(map[string]*string)
+		}
+		for key, value := range metadata {
+			f.pendingMetadata[key] = value
+		}
+	} else {
+		err = f.updateMetadata(ctx, metadata)
+		if err != nil {
+			return
+		}
+	}
+
+	setPosixAttributes(&f.attrs, mode, uid, gid)
+	return
+}
+
 // Sync writes out contents to GCS. If this fails due to the generation having been
-// clobbered, treat it as a non-error (simulating the inode having been
-// unlinked).
+// clobbered, the inode is treated as having been unlinked, and the dirty
+// content is handled as per the conflict policy (see handleConflict).
 //
 // After this method succeeds, SourceGeneration will return the new generation
 // by which this inode should be known (which may be the same as before). If it
@@ -565,6 +838,36 @@ func (f *FileInode) SetMtime(
 //
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) Sync(ctx context.Context) (err error) {
+	return f.sync(ctx, false)
+}
+
+// Sync the file, as Sync does, keeping the local content if keepContent is
+// set, rebased onto the new generation, rather than throwing it away.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) sync(ctx context.Context, keepContent bool) (err error) {
+	defer func() {
+		if err == nil {
+			f.dirtiedAt = time.Time{}
+			f.autoSyncFailures = 0
+			f.autoSyncRetryAt = time.Time{}
+			if f.dirtyFiles != nil {
+				f.dirtyFiles.remove(f)
+			}
+		}
+	}()
+
+	err = f.checkStreamingErr()
+	if err != nil {
+		return
+	}
+
+	// Streamed contents are written out by finishing the upload.
+	if f.streamer != nil {
+		err = f.finishStreaming(ctx)
+		return
+	}
+
 	// If we have not been dirtied, there is nothing to do.
 	if f.content == nil {
 		return
@@ -580,9 +883,14 @@ func (f *FileInode) Sync(ctx context.Context) (err error) {
 	// properties.
 	latestGcsObj, isClobbered, err := f.clobbered(ctx, true, true)
 
-	// Clobbered is treated as being unlinked. There's no reason to return an
-	// error in that case. We simply return without syncing the object.
-	if err != nil || isClobbered {
+	if err != nil {
+		return
+	}
+
+	// Clobbered is treated as being unlinked, and the dirty content is handled
+	// as per the conflict policy.
+	if isClobbered {
+		err = f.handleConflict(ctx)
 		return
 	}
 
@@ -592,10 +900,10 @@ func (f *FileInode) Sync(ctx context.Context) (err error) {
 	newObj, err := f.bucket.SyncObject(ctx, f.Name().GcsObjectName(), latestGcsObj, f.content)
 
 	// Special case: a precondition error means we were clobbered, which we treat
-	// as being unlinked. There's no reason to return an error in that case.
+	// as being unlinked.
 	var preconditionErr *gcs.PreconditionError
 	if errors.As(err, &preconditionErr) {
-		err = nil
+		err = f.handleConflict(ctx)
 		return
 	}
 
@@ -617,19 +925,387 @@ func (f *FileInode) Sync(ctx context.Context) (err error) {
 		if f.IsLocal() {
 			f.local = false
 		}
-		f.content.Destroy()
-		f.content = nil
+		if keepContent {
+			err = f.rebaseContent()
+			if err != nil {
+				return
+			}
+		} else {
+			f.content.Destroy()
+			f.content = nil
+		}
+	}
+
+	err = f.storePendingMetadata(ctx)
+	return
+}
+
+// AutoSync syncs the file, as Sync does, if its content was first modified
+// at least interval ago, or if at least dirtyBytes bytes of it, counted from
+// the first byte modified, are dirty, reporting whether it did. A zero
+// interval or dirtyBytes disables the corresponding limit.
+//
+// Unlike Sync, this keeps the content, which is then that of the new
+// generation. Content being streamed to the object is left alone, as is that
+// of an unlinked local file. After a failure, neither limit triggers a retry
+// until autoSyncMinRetryDelay has passed, doubling with each consecutive
+// failure up to autoSyncMaxRetryDelay, unless the file is synced by other
+// means first.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) AutoSync(
+	ctx context.Context,
+	interval time.Duration,
+	dirtyBytes int64) (synced bool, err error) {
+	if f.destroyed ||
+		f.content == nil ||
+		f.streamer != nil ||
+		f.dirtiedAt.IsZero() ||
+		(f.IsLocal() && f.IsUnlinked()) {
+		return
+	}
+
+	now := f.mtimeClock.Now()
+	if now.Before(f.autoSyncRetryAt) {
+		return
+	}
+
+	due := interval > 0 && now.Sub(f.dirtiedAt) >= interval
+	if !due && dirtyBytes > 0 {
+		var sr gcsx.StatResult
+		sr, err = f.content.Stat()
+		if err != nil {
+			err = fmt.Errorf("Stat: %w", err)
+			return
+		}
+		due = sr.Size-sr.DirtyThreshold >= dirtyBytes
+	}
+	if !due {
+		return
+	}
+
+	// The file is likely to be written to again, e.g. appended to, so keep its
+	// content rather than fetching it again then.
+	err = f.sync(ctx, true)
+	if err != nil {
+		delay := autoSyncMinRetryDelay << min(f.autoSyncFailures, 16)
+		f.autoSyncFailures++
+		f.autoSyncRetryAt = now.Add(min(delay, autoSyncMaxRetryDelay))
+		err = fmt.Errorf("Sync: %w", err)
+		return
+	}
+
+	synced = true
+	return
+}
+
+// Treat the local content as that of the source object, which it has just been
+// written out to, so that nothing is dirty or left for the dirty journal to
+// recover.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) rebaseContent() (err error) {
+	err = f.content.Rebase()
+	if err != nil {
+		err = fmt.Errorf("Rebase: %w", err)
+		return
+	}
+
+	if f.dirtyJournal != nil {
+		f.dirtyJournal.Forget(f.content)
+	}
+
+	return
+}
+
+// Record that the content has been modified, if it hasn't been since it was
+// last synced, adding the inode to the dirty files.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) markDirtied() {
+	if f.dirtiedAt.IsZero() {
+		f.dirtiedAt = f.mtimeClock.Now()
+		if f.dirtyFiles != nil {
+			f.dirtyFiles.add(f)
+		}
+	}
+}
+
+// Store the metadata updates made before the object was created.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) storePendingMetadata(ctx context.Context) (err error) {
+	if len(f.pendingMetadata) > 0 && !f.IsLocal() {
+		err = f.updateMetadata(ctx, f.pendingMetadata)
+		if err != nil {
+			err = fmt.Errorf("updateMetadata: %w", err)
+			return
+		}
+		f.pendingMetadata = nil
+	}
+
+	return
+}
+
+// Start streaming the writes to the object of a new file in place of its
+// empty temp file, if enabled and the supplied write is the file's first.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) maybeStartStreaming(offset int64) (err error) {
+	if !f.streamingWrites ||
+		f.localFileCache ||
+		!f.IsLocal() ||
+		f.IsUnlinked() ||
+		f.streamer != nil ||
+		f.content == nil ||
+		offset != 0 {
+		return
+	}
+
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
 	}
+	if sr.Size != 0 {
+		return
+	}
+
+	// Metadata updates made so far are stored with the object. Later ones are
+	// stored once it has been created.
+	metadata := make(map[string]string)
+	for key, value := range f.pendingMetadata {
+		if value != nil {
+			metadata[key] = *value
+		}
+	}
+	f.pendingMetadata = nil
+
+	// Unless the streamed contents may be discarded, they are staged in a
+	// temporary object, so that they can be handled as per the conflict policy
+	// if the object is created remotely in the meantime.
+	var tmpObjectPrefix string
+	if f.conflictPolicy != config.DiscardConflictPolicy {
+		tmpObjectPrefix = f.bucket.TmpObjectPrefix
+	}
+
+	var precond int64
+	f.streamer = gcsx.NewStreamingUpload(
+		f.bucket,
+		&gcs.CreateObjectRequest{
+			Name:                   f.name.GcsObjectName(),
+			GenerationPrecondition: &precond,
+			Metadata:               metadata,
+		},
+		tmpObjectPrefix)
+	f.streamingMtime = f.mtimeClock.Now()
+	f.content.Destroy()
+	f.content = nil
 
 	return
 }
 
+// Finish the upload to which the writes to a new file are being streamed. The
+// object created becomes the source object of the inode, which is then no
+// longer local.
+//
+// If the upload fails, the streamed contents are lost, and everything that
+// needs them fails from then on, until the file is unlinked or released. But
+// if the object has been created remotely since the upload started, the
+// streamed contents are handled as per the conflict policy (see
+// handleStreamingConflict).
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) finishStreaming(ctx context.Context) (err error) {
+	streamer := f.streamer
+	size := streamer.Size()
+	o, err := streamer.Finish(ctx)
+	f.streamer = nil
+
+	if err != nil {
+		var preconditionErr *gcs.PreconditionError
+		if !errors.As(err, &preconditionErr) {
+			f.streamingErr = err
+			f.streamingLostSize = size
+			err = fmt.Errorf("StreamingUpload.Finish: %w", err)
+			return
+		}
+
+		err = f.handleStreamingConflict(ctx, streamer)
+		return
+	}
+
+	f.src = *storageutil.ConvertObjToMinObject(o)
+	f.local = false
+
+	err = f.storePendingMetadata(ctx)
+	return
+}
+
+// handleStreamingConflict handles the streamed contents of the file after its
+// object has been created remotely, as handleConflict does the dirty content of
+// other files. Under the discard policy the contents were not staged, and the
+// inode carries on with an empty temp file, as if newly created. Otherwise they
+// are fetched from the temporary object in which they were staged into the
+// temp file, which is then handled as per the policy. If fetching them fails,
+// they are kept staged, and handled again by the next sync.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) handleStreamingConflict(
+	ctx context.Context,
+	streamer *gcsx.StreamingUpload) (err error) {
+	staged := streamer.Staged()
+	if staged == nil {
+		streamer.Abort()
+		err = f.CreateEmptyTempFile()
+		if err != nil {
+			err = fmt.Errorf("CreateEmptyTempFile: %w", err)
+			return
+		}
+
+		monitor.CaptureSyncConflictMetrics(ctx, f.conflictPolicy)
+		logger.Warnf("Object %q has been created remotely, streamed changes are discarded.", f.name.GcsObjectName())
+		f.conflictHandled = true
+		if f.conflictPolicy != config.DiscardConflictPolicy {
+			err = syscall.ESTALE
+		}
+		return
+	}
+
+	rc, err := f.bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       staged.Name,
+			Generation: staged.Generation,
+		})
+	if err != nil {
+		f.streamer = streamer
+		err = fmt.Errorf("NewReader for staged contents: %w", err)
+		return
+	}
+
+	content, err := f.newTempFile(rc)
+	if err != nil {
+		f.streamer = streamer
+		err = fmt.Errorf("NewTempFile for staged contents: %w", err)
+		return
+	}
+
+	streamer.Abort()
+	f.content = content
+	f.content.SetMtime(f.streamingMtime)
+	f.conflictHandled = false
+	f.markDirtied()
+
+	err = f.journalContent(0)
+	if err != nil {
+		err = fmt.Errorf("journalContent: %w", err)
+		return
+	}
+
+	err = f.handleConflict(ctx)
+	return
+}
+
+// checkStreamingErr returns syscall.EIO if the streamed contents of the file
+// have been lost. See finishStreaming.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) checkStreamingErr() (err error) {
+	if f.streamingErr != nil {
+		err = fmt.Errorf("streamed contents lost to %v: %w", f.streamingErr, syscall.EIO)
+	}
+	return
+}
+
+// handleConflict handles the dirty content of the file after its object has
+// been clobbered, as per the conflict policy:
+//
+//   - discard: the content is dropped, as if the file had been unlinked.
+//   - error: syscall.ESTALE is returned, and the content is retained so that
+//     the writer can retry or recover it.
+//   - conflict-object: the content is uploaded as a sibling object named
+//     "<name>.conflict-<host>-<timestamp>".
+//
+// Content that has already been handled is not handled again until it is
+// modified.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) handleConflict(ctx context.Context) (err error) {
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+	// Content that has not been modified has nothing to lose.
+	if sr.Mtime == nil || f.conflictHandled {
+		return
+	}
+
+	monitor.CaptureSyncConflictMetrics(ctx, f.conflictPolicy)
+
+	switch f.conflictPolicy {
+	case config.ErrorConflictPolicy:
+		logger.Warnf("Object %q has been modified or deleted remotely, local changes are not synced.", f.name.GcsObjectName())
+		err = syscall.ESTALE
+		return
+
+	case config.ConflictObjectConflictPolicy:
+		conflictName := f.conflictObjectName()
+		_, err = f.bucket.SyncObject(ctx, conflictName, nil, f.content)
+		if err != nil {
+			err = fmt.Errorf("SyncObject for conflict object %q: %w", conflictName, err)
+			return
+		}
+		logger.Warnf("Object %q has been modified or deleted remotely, local changes are saved to %q.", f.name.GcsObjectName(), conflictName)
+
+	default:
+		logger.Warnf("Object %q has been modified or deleted remotely, local changes are discarded.", f.name.GcsObjectName())
+	}
+
+	f.conflictHandled = true
+	return
+}
+
+// conflictObjectName returns the name of the object in which the dirty content
+// of the file is saved by the conflict-object policy.
+func (f *FileInode) conflictObjectName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf(
+		"%s.conflict-%s-%s",
+		f.name.GcsObjectName(),
+		hostname,
+		f.mtimeClock.Now().UTC().Format("20060102T150405.000000000Z"))
+}
+
 // Truncate the file to the specified size.
 //
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) Truncate(
 	ctx context.Context,
 	size int64) (err error) {
+	err = f.checkStreamingErr()
+	if err != nil {
+		return
+	}
+
+	// Truncating streamed contents to their current size, e.g. for O_TRUNC, is a
+	// no-op. Otherwise they have to be fetched from the object.
+	if f.streamer != nil {
+		if size == f.streamer.Size() {
+			return
+		}
+
+		err = f.finishStreaming(ctx)
+		if err != nil {
+			err = fmt.Errorf("finishStreaming: %w", err)
+			return
+		}
+	}
+
 	// Make sure f.content != nil.
 	err = f.ensureContent(ctx)
 	if err != nil {
@@ -637,8 +1313,16 @@ func (f *FileInode) Truncate(
 		return
 	}
 
+	err = f.journalContent(size)
+	if err != nil {
+		err = fmt.Errorf("journalContent: %w", err)
+		return
+	}
+
 	// Call through.
 	err = f.content.Truncate(size)
+	f.conflictHandled = false
+	f.markDirtied()
 
 	return
 }
@@ -655,8 +1339,16 @@ func (f *FileInode) CacheEnsureContent(ctx context.Context) (err error) {
 func (f *FileInode) CreateEmptyTempFile() (err error) {
 	// Creating a file with no contents. The contents will be updated with
 	// writeFile operations.
-	f.content, err = f.contentCache.NewTempFile(io.NopCloser(strings.NewReader("")))
+	f.content, err = f.newTempFile(io.NopCloser(strings.NewReader("")))
+	if err != nil {
+		return
+	}
 	// Setting the initial mtime to creation time.
 	f.content.SetMtime(f.mtimeClock.Now())
+	f.markDirtied()
+
+	// The file doesn't exist anywhere else, so record it in the journal even
+	// before it is written to.
+	err = f.journalContent(0)
 	return
 }
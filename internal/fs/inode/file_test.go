@@ -15,13 +15,18 @@
 package inode
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
@@ -31,6 +36,7 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/contentcache"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
 	"github.com/jacobsa/fuse/fuseops"
+	. "github.com/jacobsa/oglematchers"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
 )
@@ -49,6 +55,21 @@ const fileName = "foo/bar"
 const fileMode os.FileMode = 0641
 const Delta = 30 * time.Minute
 
+// A bucket whose object creations fail once they have consumed their
+// contents.
+type failingCreateBucket struct {
+	gcs.Bucket
+}
+
+func (b *failingCreateBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (*gcs.Object, error) {
+	if _, err := io.Copy(io.Discard, req.Contents); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("taco")
+}
+
 type FileTest struct {
 	ctx    context.Context
 	bucket gcs.Bucket
@@ -56,6 +77,11 @@ type FileTest struct {
 
 	initialContents string
 	backingObj      *gcs.MinObject
+	conflictPolicy  string
+	streamingWrites bool
+	dirtyJournalDir string
+	dirtyJournal    *gcsx.DirtyJournal
+	dirtyFiles      *DirtyFiles
 
 	in *FileInode
 }
@@ -71,6 +97,8 @@ func (t *FileTest) SetUp(ti *TestInfo) {
 	t.ctx = ti.Ctx
 	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
 	t.bucket = fake.NewFakeBucket(&t.clock, "some_bucket")
+	t.conflictPolicy = config.DefaultConflictPolicy
+	t.dirtyFiles = NewDirtyFiles()
 
 	// Set up the backing object.
 	var err error
@@ -91,6 +119,43 @@ func (t *FileTest) SetUp(ti *TestInfo) {
 
 func (t *FileTest) TearDown() {
 	t.in.Unlock()
+	if t.dirtyJournalDir != "" {
+		os.RemoveAll(t.dirtyJournalDir)
+	}
+}
+
+// Create a local file inode whose writes are streamed to its object, as
+// created by the file system.
+func (t *FileTest) createStreamingLocalInode() {
+	t.streamingWrites = true
+	t.createInodeWithLocalParam("test", true)
+	err := t.in.CreateEmptyTempFile()
+	AssertEq(nil, err)
+}
+
+// Create the inode again, keeping its temp files in a dirty journal.
+func (t *FileTest) useDirtyJournal(fileName string, local bool) {
+	var err error
+	t.dirtyJournalDir, err = os.MkdirTemp("", "file_test")
+	AssertEq(nil, err)
+	t.dirtyJournal, err = gcsx.NewDirtyJournal(t.dirtyJournalDir, &t.clock)
+	AssertEq(nil, err)
+
+	t.createInodeWithLocalParam(fileName, local)
+}
+
+// Return the manifests recorded in the dirty journal.
+func (t *FileTest) dirtyManifests() (manifests []gcsx.DirtyManifest) {
+	names, err := filepath.Glob(filepath.Join(t.dirtyJournalDir, "*.json"))
+	AssertEq(nil, err)
+	for _, name := range names {
+		contents, err := os.ReadFile(name)
+		AssertEq(nil, err)
+		var m gcsx.DirtyManifest
+		AssertEq(nil, json.Unmarshal(contents, &m))
+		manifests = append(manifests, m)
+	}
+	return
 }
 
 func (t *FileTest) createInode() {
@@ -126,7 +191,11 @@ func (t *FileTest) createInodeWithLocalParam(fileName string, local bool) {
 		false, // localFileCache
 		contentcache.New("", &t.clock),
 		&t.clock,
-		local)
+		local,
+		t.conflictPolicy,
+		t.streamingWrites,
+		t.dirtyJournal,
+		t.dirtyFiles)
 
 	t.in.Lock()
 }
@@ -693,6 +762,127 @@ func (t *FileTest) Sync_Clobbered() {
 	ExpectEq(newObj.Size, m.Size)
 }
 
+// listTmpObjects returns the temporary objects left in the bucket.
+func (t *FileTest) listTmpObjects() []*gcs.Object {
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{
+		Prefix: ".gcsfuse_tmp/",
+	})
+	AssertEq(nil, err)
+	return listing.Objects
+}
+
+// listConflictObjects returns the conflict objects of the backing object.
+func (t *FileTest) listConflictObjects() []*gcs.Object {
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{
+		Prefix: t.in.Name().GcsObjectName() + ".conflict-",
+	})
+	AssertEq(nil, err)
+	return listing.Objects
+}
+
+func (t *FileTest) Sync_Clobbered_ErrorConflictPolicy() {
+	t.conflictPolicy = config.ErrorConflictPolicy
+	t.createInode()
+	err := t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+	newObj, err := storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	ExpectEq(syscall.ESTALE, err)
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	ExpectEq(newObj.Generation, t.statBackingObject().Generation)
+	// The content is retained, and the conflict is reported again.
+	buf := make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq("paco", string(buf[:n]))
+	err = t.in.Sync(t.ctx)
+	ExpectEq(syscall.ESTALE, err)
+	ExpectEq(0, len(t.listConflictObjects()))
+}
+
+func (t *FileTest) Sync_Clobbered_ErrorConflictPolicy_ContentNotModified() {
+	t.conflictPolicy = config.ErrorConflictPolicy
+	t.createInode()
+	// Fault in the content without modifying it.
+	_, err := t.in.Read(t.ctx, make([]byte, 4), 0)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	ExpectEq(nil, err)
+}
+
+func (t *FileTest) Sync_Clobbered_ConflictObjectPolicy() {
+	t.conflictPolicy = config.ConflictObjectConflictPolicy
+	t.createInode()
+	err := t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
+	newObj, err := storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(newObj.Generation, t.statBackingObject().Generation)
+	conflictObjects := t.listConflictObjects()
+	AssertEq(1, len(conflictObjects))
+	hostname, err := os.Hostname()
+	AssertEq(nil, err)
+	ExpectEq(
+		fmt.Sprintf("%s.conflict-%s-%s", fileName, hostname, t.clock.Now().UTC().Format("20060102T150405.000000000Z")),
+		conflictObjects[0].Name)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, conflictObjects[0].Name)
+	AssertEq(nil, err)
+	ExpectEq("ta", string(contents))
+	// Syncing again doesn't upload the same content again.
+	t.clock.AdvanceTime(time.Second)
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(1, len(t.listConflictObjects()))
+}
+
+func (t *FileTest) Sync_Clobbered_ConflictObjectPolicy_ModifiedAgain() {
+	t.conflictPolicy = config.ConflictObjectConflictPolicy
+	t.createInode()
+	err := t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+	err = t.in.Write(t.ctx, []byte("s"), 0)
+	AssertEq(nil, err)
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(2, len(t.listConflictObjects()))
+}
+
+func (t *FileTest) Sync_Deleted_ConflictObjectPolicy() {
+	t.conflictPolicy = config.ConflictObjectConflictPolicy
+	t.createInode()
+	err := t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: fileName})
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	conflictObjects := t.listConflictObjects()
+	AssertEq(1, len(conflictObjects))
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, conflictObjects[0].Name)
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+}
+
 func (t *FileTest) SetMtime_ContentNotFaultedIn() {
 	var err error
 	var attrs fuseops.InodeAttributes
@@ -942,3 +1132,504 @@ func (t *FileTest) UnlinkLocalFile() {
 	AssertNe(nil, err)
 	AssertEq("gcs.NotFoundError: Object test not found", err.Error())
 }
+
+func (t *FileTest) StreamingWrites_SequentialWritesThenSync() {
+	t.createStreamingLocalInode()
+
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Second)
+	writeTime := t.clock.Now()
+	err = t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+
+	// The contents are being streamed rather than staged.
+	ExpectEq(nil, t.in.content)
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len("tacos"), attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime))
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectFalse(t.in.IsLocal())
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "test")
+	AssertEq(nil, err)
+	ExpectEq("tacos", string(contents))
+	ExpectEq(len("tacos"), t.in.Source().Size)
+}
+
+func (t *FileTest) StreamingWrites_NotForExistingFiles() {
+	t.streamingWrites = true
+	t.createInode()
+
+	err := t.in.Write(t.ctx, []byte("burrito"), 0)
+
+	AssertEq(nil, err)
+	ExpectEq(nil, t.in.streamer)
+	ExpectNe(nil, t.in.content)
+}
+
+func (t *FileTest) StreamingWrites_OutOfOrderWriteFallsBackToTempFile() {
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("X"), 1)
+
+	AssertEq(nil, err)
+	ExpectFalse(t.in.IsLocal())
+	ExpectEq(nil, t.in.streamer)
+	AssertNe(nil, t.in.content)
+	// The contents written before the out-of-order write are in the object.
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "test")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	contents, err = storageutil.ReadObject(t.ctx, t.bucket, "test")
+	AssertEq(nil, err)
+	ExpectEq("tXco", string(contents))
+}
+
+func (t *FileTest) StreamingWrites_ReadBack() {
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	buf := make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+	ExpectFalse(t.in.IsLocal())
+}
+
+func (t *FileTest) StreamingWrites_TruncateToCurrentSize() {
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Truncate(t.ctx, 4)
+
+	AssertEq(nil, err)
+	ExpectNe(nil, t.in.streamer)
+	ExpectTrue(t.in.IsLocal())
+}
+
+func (t *FileTest) StreamingWrites_SetMtime() {
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	mtime := time.Date(2015, 4, 5, 2, 15, 0, 0, time.UTC)
+
+	err = t.in.SetMtime(t.ctx, mtime)
+	AssertEq(nil, err)
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(mtime))
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "test"})
+	AssertEq(nil, err)
+	ExpectEq(mtime.Format(time.RFC3339Nano), m.Metadata["gcsfuse_mtime"])
+}
+
+func (t *FileTest) StreamingWrites_Unlink() {
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	t.in.Unlink()
+
+	ExpectEq(nil, t.in.streamer)
+	_, _, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "test"})
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
+
+func (t *FileTest) StreamingWrites_ObjectCreatedRemotely() {
+	t.conflictPolicy = config.ErrorConflictPolicy
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, "test", []byte("burrito"))
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+	ExpectEq(nil, t.in.streamer)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "test")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+	// The streamed contents are retained, and the conflict is reported again.
+	buf := make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+	err = t.in.Sync(t.ctx)
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+	ExpectEq(0, len(t.listConflictObjects()))
+	ExpectEq(0, len(t.listTmpObjects()))
+}
+
+func (t *FileTest) StreamingWrites_ObjectCreatedRemotely_ConflictObjectPolicy() {
+	t.conflictPolicy = config.ConflictObjectConflictPolicy
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, "test", []byte("burrito"))
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "test")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+	conflictObjects := t.listConflictObjects()
+	AssertEq(1, len(conflictObjects))
+	contents, err = storageutil.ReadObject(t.ctx, t.bucket, conflictObjects[0].Name)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	ExpectEq(0, len(t.listTmpObjects()))
+}
+
+func (t *FileTest) StreamingWrites_ObjectCreatedRemotely_DiscardConflictPolicy() {
+	t.conflictPolicy = config.DiscardConflictPolicy
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, "test", []byte("burrito"))
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(t.listConflictObjects()))
+	ExpectEq(0, len(t.listTmpObjects()))
+}
+
+func (t *FileTest) StreamingWrites_UploadFailed() {
+	t.bucket = &failingCreateBucket{Bucket: t.bucket}
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	ExpectThat(err, Error(HasSubstr("taco")))
+
+	// The contents are lost, which everything that needs them reports, rather
+	// than finding the file empty.
+	ExpectEq(nil, t.in.content)
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len("taco"), attrs.Size)
+
+	_, err = t.in.Read(t.ctx, make([]byte, 4), 0)
+	ExpectTrue(errors.Is(err, syscall.EIO))
+	err = t.in.Write(t.ctx, []byte("s"), 4)
+	ExpectTrue(errors.Is(err, syscall.EIO))
+	err = t.in.Truncate(t.ctx, 0)
+	ExpectTrue(errors.Is(err, syscall.EIO))
+	err = t.in.Sync(t.ctx)
+	ExpectTrue(errors.Is(err, syscall.EIO))
+
+	// Unlinking the file starts it afresh.
+	t.in.Unlink()
+	err = t.in.Write(t.ctx, []byte("s"), 0)
+	ExpectEq(nil, err)
+}
+
+func (t *FileTest) DirtyJournal_LocalFileRecordedOnCreation() {
+	t.useDirtyJournal("test", true)
+
+	err := t.in.CreateEmptyTempFile()
+
+	AssertEq(nil, err)
+	manifests := t.dirtyManifests()
+	AssertEq(1, len(manifests))
+	ExpectEq("some_bucket", manifests[0].BucketName)
+	ExpectEq("test", manifests[0].ObjectName)
+	ExpectEq(0, manifests[0].SourceGeneration)
+	ExpectEq(0, manifests[0].DirtyThreshold)
+	ExpectEq(t.in.content.Name(), manifests[0].TempFilePath)
+}
+
+func (t *FileTest) DirtyJournal_WriteRecordsDirtyThreshold() {
+	t.useDirtyJournal(fileName, false)
+
+	err := t.in.Write(t.ctx, []byte("o"), 2)
+	AssertEq(nil, err)
+	err = t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+
+	manifests := t.dirtyManifests()
+	AssertEq(1, len(manifests))
+	ExpectEq(fileName, manifests[0].ObjectName)
+	ExpectEq(t.backingObj.Generation, manifests[0].SourceGeneration)
+	ExpectEq(2, manifests[0].DirtyThreshold)
+	contents, err := os.ReadFile(manifests[0].TempFilePath)
+	AssertEq(nil, err)
+	ExpectEq("taoos", string(contents))
+}
+
+func (t *FileTest) DirtyJournal_TruncateRecordsDirtyThreshold() {
+	t.useDirtyJournal(fileName, false)
+
+	err := t.in.Truncate(t.ctx, 1)
+
+	AssertEq(nil, err)
+	manifests := t.dirtyManifests()
+	AssertEq(1, len(manifests))
+	ExpectEq(1, manifests[0].DirtyThreshold)
+}
+
+func (t *FileTest) DirtyJournal_SyncRemovesContent() {
+	t.useDirtyJournal(fileName, false)
+	err := t.in.Write(t.ctx, []byte("o"), 2)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	entries, err := os.ReadDir(t.dirtyJournalDir)
+	AssertEq(nil, err)
+	ExpectEq(0, len(entries))
+}
+
+func (t *FileTest) DirtyJournal_UnlinkForgetsLocalFile() {
+	t.useDirtyJournal("test", true)
+	err := t.in.CreateEmptyTempFile()
+	AssertEq(nil, err)
+
+	t.in.Unlink()
+	err = t.in.Write(t.ctx, []byte("taco"), 0)
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(t.dirtyManifests()))
+}
+
+func (t *FileTest) AutoSync_NotDirty() {
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 1)
+
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+}
+
+func (t *FileTest) AutoSync_IntervalNotElapsed() {
+	err := t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Minute - time.Second)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 0)
+
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, fileName)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) AutoSync_IntervalElapsed() {
+	err := t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(30 * time.Second)
+	err = t.in.Write(t.ctx, []byte("!"), 5)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(30 * time.Second)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 0)
+
+	AssertEq(nil, err)
+	ExpectTrue(synced)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, fileName)
+	AssertEq(nil, err)
+	ExpectEq("tacos!", string(contents))
+
+	// The interval starts again with the next write.
+	err = t.in.Write(t.ctx, []byte("?"), 6)
+	AssertEq(nil, err)
+	synced, err = t.in.AutoSync(t.ctx, time.Minute, 0)
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+}
+
+func (t *FileTest) AutoSync_KeepsContent() {
+	t.useDirtyJournal(fileName, false)
+	err := t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Minute)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 0)
+
+	AssertEq(nil, err)
+	ExpectTrue(synced)
+	// The content is kept, as that of the new generation, so isn't dirty or
+	// journaled.
+	AssertNe(nil, t.in.content)
+	sr, err := t.in.content.Stat()
+	AssertEq(nil, err)
+	ExpectEq(5, sr.Size)
+	ExpectEq(5, sr.DirtyThreshold)
+	ExpectEq(nil, sr.Mtime)
+	ExpectEq(0, len(t.dirtyManifests()))
+	o, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: fileName})
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+
+	// Appending to it needs only the new bytes to be written out.
+	err = t.in.Write(t.ctx, []byte("!"), 5)
+	AssertEq(nil, err)
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, fileName)
+	AssertEq(nil, err)
+	ExpectEq("tacos!", string(contents))
+}
+
+func (t *FileTest) DirtyFiles() {
+	ExpectEq(0, len(t.dirtyFiles.List()))
+
+	// Writing adds the inode to the dirty files.
+	err := t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+	AssertEq(1, len(t.dirtyFiles.List()))
+	ExpectEq(t.in, t.dirtyFiles.List()[0])
+
+	// Syncing removes it.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0, len(t.dirtyFiles.List()))
+
+	// As does destroying it.
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
+	AssertEq(1, len(t.dirtyFiles.List()))
+	ExpectEq(t.in, t.dirtyFiles.List()[0])
+	err = t.in.Destroy()
+	AssertEq(nil, err)
+	ExpectEq(0, len(t.dirtyFiles.List()))
+}
+
+func (t *FileTest) AutoSync_DirtyBytes() {
+	err := t.in.Write(t.ctx, []byte("s!!"), 4)
+	AssertEq(nil, err)
+
+	synced, err := t.in.AutoSync(t.ctx, 0, 4)
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+
+	synced, err = t.in.AutoSync(t.ctx, 0, 3)
+	AssertEq(nil, err)
+	ExpectTrue(synced)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, fileName)
+	AssertEq(nil, err)
+	ExpectEq("tacos!!", string(contents))
+}
+
+func (t *FileTest) AutoSync_LocalFile() {
+	t.createInodeWithLocalParam("test", true)
+	err := t.in.CreateEmptyTempFile()
+	AssertEq(nil, err)
+	err = t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Minute)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 0)
+
+	AssertEq(nil, err)
+	ExpectTrue(synced)
+	ExpectFalse(t.in.IsLocal())
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "test")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) AutoSync_UnlinkedLocalFile() {
+	t.createInodeWithLocalParam("test", true)
+	err := t.in.CreateEmptyTempFile()
+	AssertEq(nil, err)
+	t.in.Unlink()
+	t.clock.AdvanceTime(time.Minute)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 0)
+
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+}
+
+func (t *FileTest) AutoSync_FailureRetriedAfterBackoff() {
+	t.conflictPolicy = config.ErrorConflictPolicy
+	t.createInode()
+	err := t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Minute)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 1)
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+	ExpectFalse(synced)
+
+	// Neither limit applies again until the retry delay has passed.
+	synced, err = t.in.AutoSync(t.ctx, time.Minute, 1)
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+
+	t.clock.AdvanceTime(autoSyncMinRetryDelay)
+	_, err = t.in.AutoSync(t.ctx, time.Minute, 1)
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+
+	// The delay doubles with each consecutive failure.
+	t.clock.AdvanceTime(autoSyncMinRetryDelay)
+	synced, err = t.in.AutoSync(t.ctx, time.Minute, 1)
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+
+	t.clock.AdvanceTime(autoSyncMinRetryDelay)
+	_, err = t.in.AutoSync(t.ctx, time.Minute, 1)
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+}
+
+func (t *FileTest) AutoSync_FailureRetriedWithoutInterval() {
+	t.conflictPolicy = config.ErrorConflictPolicy
+	t.createInode()
+	err := t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+
+	_, err = t.in.AutoSync(t.ctx, 0, 1)
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+
+	// The dirty-bytes limit alone triggers a retry once the delay has passed.
+	synced, err := t.in.AutoSync(t.ctx, 0, 1)
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+
+	t.clock.AdvanceTime(autoSyncMinRetryDelay)
+	_, err = t.in.AutoSync(t.ctx, 0, 1)
+	ExpectTrue(errors.Is(err, syscall.ESTALE))
+}
+
+func (t *FileTest) AutoSync_StreamingLeftAlone() {
+	t.createStreamingLocalInode()
+	err := t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Minute)
+
+	synced, err := t.in.AutoSync(t.ctx, time.Minute, 1)
+
+	AssertEq(nil, err)
+	ExpectFalse(synced)
+	ExpectNe(nil, t.in.streamer)
+}
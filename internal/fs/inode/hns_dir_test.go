@@ -0,0 +1,234 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/metadata"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestHNSDir(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type HNSDirTest struct {
+	ctx    context.Context
+	bucket gcsx.SyncerBucket
+	clock  timeutil.SimulatedClock
+
+	in DirInode
+}
+
+var _ SetUpInterface = &HNSDirTest{}
+var _ TearDownInterface = &HNSDirTest{}
+
+func init() { RegisterTestSuite(&HNSDirTest{}) }
+
+func (t *HNSDirTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
+	t.bucket = gcsx.NewSyncerBucket(
+		1, // Append threshold
+		".gcsfuse_tmp/",
+		fake.NewHierarchicalFakeBucket(&t.clock, "some_bucket"))
+
+	// The directory's own folder.
+	_, err := t.bucket.CreateFolder(t.ctx, dirInodeName)
+	AssertEq(nil, err)
+
+	t.in = NewDirInode(
+		dirInodeID,
+		NewDirName(NewRootName(""), dirInodeName),
+		fuseops.InodeAttributes{
+			Uid:  uid,
+			Gid:  gid,
+			Mode: dirMode,
+		},
+		false, // implicitDirs
+		false, // enableManagedFoldersListing
+		true,  // enableNonexistentTypeCache
+		typeCacheTTL,
+		&t.bucket,
+		&t.clock,
+		&t.clock,
+		config.DefaultTypeCacheMaxSizeMB,
+		config.DefaultEvictionPolicy)
+	t.in.Lock()
+}
+
+func (t *HNSDirTest) TearDown() {
+	t.in.Unlock()
+}
+
+func (t *HNSDirTest) getTypeFromCache(name string) metadata.Type {
+	return t.in.(*dirInode).cache.Get(t.clock.Now(), name)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *HNSDirTest) LookUpChild_EmptyFolder() {
+	const name = "qux"
+	_, err := t.bucket.CreateFolder(t.ctx, dirInodeName+name+"/")
+	AssertEq(nil, err)
+
+	result, err := t.in.LookUpChild(t.ctx, name)
+
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(dirInodeName+name+"/", result.FullName.GcsObjectName())
+	AssertNe(nil, result.MinObject)
+	ExpectEq(dirInodeName+name+"/", result.MinObject.Name)
+	ExpectEq(metadata.ExplicitDirType, result.Type())
+}
+
+func (t *HNSDirTest) LookUpChild_FolderOfObject() {
+	const name = "qux"
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, dirInodeName+name+"/baz", []byte("taco"))
+	AssertEq(nil, err)
+
+	result, err := t.in.LookUpChild(t.ctx, name)
+
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(metadata.ExplicitDirType, result.Type())
+}
+
+func (t *HNSDirTest) LookUpChild_NonExistent() {
+	result, err := t.in.LookUpChild(t.ctx, "qux")
+
+	AssertEq(nil, err)
+	ExpectEq(nil, result)
+	ExpectEq(metadata.NonexistentType, t.getTypeFromCache("qux"))
+}
+
+func (t *HNSDirTest) CreateChildDir_CreatesFolder() {
+	const name = "qux"
+
+	result, err := t.in.CreateChildDir(t.ctx, name)
+
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(metadata.ExplicitDirType, result.Type())
+	folder, err := t.bucket.GetFolder(t.ctx, dirInodeName+name+"/")
+	AssertEq(nil, err)
+	ExpectEq(dirInodeName+name+"/", folder.Name)
+	// No object backs the directory.
+	_, _, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: dirInodeName + name + "/"})
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+	ExpectEq(metadata.ExplicitDirType, t.getTypeFromCache(name))
+}
+
+func (t *HNSDirTest) CreateChildDir_AlreadyExists() {
+	const name = "qux"
+	_, err := t.bucket.CreateFolder(t.ctx, dirInodeName+name+"/")
+	AssertEq(nil, err)
+
+	_, err = t.in.CreateChildDir(t.ctx, name)
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+}
+
+func (t *HNSDirTest) ReadEntries_ListsEmptyFolders() {
+	_, err := t.bucket.CreateFolder(t.ctx, dirInodeName+"empty/")
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, dirInodeName+"full/baz", []byte("taco"))
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, dirInodeName+"file", []byte("burrito"))
+	AssertEq(nil, err)
+
+	entries, tok, err := t.in.ReadEntries(t.ctx, "")
+
+	AssertEq(nil, err)
+	ExpectEq("", tok)
+	types := make(map[string]fuseutil.DirentType)
+	for _, e := range entries {
+		types[e.Name] = e.Type
+	}
+	ExpectEq(3, len(types))
+	ExpectEq(fuseutil.DT_Directory, types["empty"])
+	ExpectEq(fuseutil.DT_Directory, types["full"])
+	ExpectEq(fuseutil.DT_File, types["file"])
+	ExpectEq(metadata.ExplicitDirType, t.getTypeFromCache("empty"))
+}
+
+func (t *HNSDirTest) RenameToChildDir_MovesContents() {
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "src/a", []byte("taco"))
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, "src/sub/b", []byte("burrito"))
+	AssertEq(nil, err)
+	// The new name was looked up and cached as nonexistent.
+	result, err := t.in.LookUpChild(t.ctx, "qux")
+	AssertEq(nil, err)
+	AssertEq(nil, result)
+
+	result, err = t.in.RenameToChildDir(t.ctx, "qux", NewDirName(NewRootName(""), "src"))
+
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(dirInodeName+"qux/", result.FullName.GcsObjectName())
+	ExpectEq(metadata.ExplicitDirType, t.getTypeFromCache("qux"))
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, dirInodeName+"qux/sub/b")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+	_, err = t.bucket.GetFolder(t.ctx, "src/")
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+	_, _, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "src/a"})
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
+
+func (t *HNSDirTest) RenameToChildDir_DestinationExists() {
+	_, err := t.bucket.CreateFolder(t.ctx, "src/")
+	AssertEq(nil, err)
+	_, err = t.bucket.CreateFolder(t.ctx, dirInodeName+"qux/")
+	AssertEq(nil, err)
+
+	_, err = t.in.RenameToChildDir(t.ctx, "qux", NewDirName(NewRootName(""), "src"))
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+}
+
+func (t *HNSDirTest) DeleteChildDir_DeletesFolder() {
+	const name = "qux"
+	_, err := t.bucket.CreateFolder(t.ctx, dirInodeName+name+"/")
+	AssertEq(nil, err)
+
+	err = t.in.DeleteChildDir(t.ctx, name, false)
+
+	AssertEq(nil, err)
+	_, err = t.bucket.GetFolder(t.ctx, dirInodeName+name+"/")
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
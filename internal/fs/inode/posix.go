@@ -0,0 +1,122 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Custom metadata keys in which the POSIX attributes of files and directories
+// are stored, compatible with gsutil's -P option.
+const (
+	PosixModeMetadataKey = "goog-reserved-posix-mode"
+	PosixUidMetadataKey  = "goog-reserved-posix-uid"
+	PosixGidMetadataKey  = "goog-reserved-posix-gid"
+)
+
+// ApplyPosixAttributes returns attrs with the permission bits, uid and gid
+// replaced by the ones stored in the given custom metadata of an object.
+// Missing or malformed values are ignored.
+func ApplyPosixAttributes(
+	attrs fuseops.InodeAttributes,
+	metadata map[string]string) fuseops.InodeAttributes {
+	if v, ok := metadata[PosixModeMetadataKey]; ok {
+		// The mode is stored as an octal number, e.g. "644".
+		if mode, err := strconv.ParseUint(v, 8, 32); err == nil {
+			attrs.Mode = (attrs.Mode &^ os.ModePerm) | (os.FileMode(mode) & os.ModePerm)
+		}
+	}
+	if v, ok := metadata[PosixUidMetadataKey]; ok {
+		if uid, err := strconv.ParseUint(v, 10, 32); err == nil {
+			attrs.Uid = uint32(uid)
+		}
+	}
+	if v, ok := metadata[PosixGidMetadataKey]; ok {
+		if gid, err := strconv.ParseUint(v, 10, 32); err == nil {
+			attrs.Gid = uint32(gid)
+		}
+	}
+	return attrs
+}
+
+// posixAttributesMetadata returns the custom metadata updates which store the
+// given POSIX attributes. Nil attributes are left out.
+func posixAttributesMetadata(
+	mode *os.FileMode,
+	uid *uint32,
+	gid *uint32) map[string]*string {
+	metadata := make(map[string]*string)
+	if mode != nil {
+		v := fmt.Sprintf("%03o", uint32(mode.Perm()))
+		metadata[PosixModeMetadataKey] = &v
+	}
+	if uid != nil {
+		v := strconv.FormatUint(uint64(*uid), 10)
+		metadata[PosixUidMetadataKey] = &v
+	}
+	if gid != nil {
+		v := strconv.FormatUint(uint64(*gid), 10)
+		metadata[PosixGidMetadataKey] = &v
+	}
+	return metadata
+}
+
+// setPosixAttributes updates attrs with the given POSIX attributes. Nil
+// attributes are left unchanged.
+func setPosixAttributes(
+	attrs *fuseops.InodeAttributes,
+	mode *os.FileMode,
+	uid *uint32,
+	gid *uint32) {
+	if mode != nil {
+		attrs.Mode = (attrs.Mode &^ os.ModePerm) | mode.Perm()
+	}
+	if uid != nil {
+		attrs.Uid = *uid
+	}
+	if gid != nil {
+		attrs.Gid = *gid
+	}
+}
+
+// CheckPosixAttributesCaller returns syscall.EPERM if the process with uid
+// caller may not make the given changes to an inode with the given attributes
+// on a mount owned by mountUid, as with default_permissions: only root and the
+// mount owner may change the uid and gid, and only they and the owner of the
+// inode may change the mode. Nil attributes and ones set to their current
+// values aren't changes.
+func CheckPosixAttributesCaller(
+	caller uint32,
+	mountUid uint32,
+	attrs fuseops.InodeAttributes,
+	mode *os.FileMode,
+	uid *uint32,
+	gid *uint32) error {
+	if caller == 0 || caller == mountUid {
+		return nil
+	}
+	if (uid != nil && *uid != attrs.Uid) || (gid != nil && *gid != attrs.Gid) {
+		return syscall.EPERM
+	}
+	if mode != nil && mode.Perm() != attrs.Mode.Perm() && caller != attrs.Uid {
+		return syscall.EPERM
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse/fuseops"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestPosix(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type PosixTest struct {
+	ctx    context.Context
+	bucket gcsx.SyncerBucket
+	clock  timeutil.SimulatedClock
+
+	in ExplicitDirInode
+}
+
+var _ SetUpInterface = &PosixTest{}
+var _ TearDownInterface = &PosixTest{}
+
+func init() { RegisterTestSuite(&PosixTest{}) }
+
+func (t *PosixTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
+	t.bucket = gcsx.NewSyncerBucket(
+		1, // Append threshold
+		".gcsfuse_tmp/",
+		fake.NewFakeBucket(&t.clock, "some_bucket"))
+
+	o, err := storageutil.CreateObject(t.ctx, t.bucket, dirInodeName, []byte{})
+	AssertEq(nil, err)
+
+	t.in = NewExplicitDirInode(
+		dirInodeID,
+		NewDirName(NewRootName(""), dirInodeName),
+		storageutil.ConvertObjToMinObject(o),
+		fuseops.InodeAttributes{
+			Uid:  uid,
+			Gid:  gid,
+			Mode: dirMode,
+		},
+		false, // implicitDirs
+		false, // enableManagedFoldersListing
+		true,  // enableNonexistentTypeCache
+		typeCacheTTL,
+		&t.bucket,
+		&t.clock,
+		&t.clock,
+		config.DefaultTypeCacheMaxSizeMB,
+		config.DefaultEvictionPolicy)
+	t.in.Lock()
+}
+
+func (t *PosixTest) TearDown() {
+	t.in.Unlock()
+}
+
+func (t *PosixTest) statDirObject() *gcs.MinObject {
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: dirInodeName})
+	AssertEq(nil, err)
+	return m
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *PosixTest) ApplyPosixAttributes() {
+	attrs := fuseops.InodeAttributes{Uid: uid, Gid: gid, Mode: 0644 | os.ModeDir}
+
+	attrs = ApplyPosixAttributes(attrs, map[string]string{
+		PosixModeMetadataKey: "750",
+		PosixUidMetadataKey:  "1000",
+		PosixGidMetadataKey:  "1001",
+	})
+
+	ExpectEq(0750|os.ModeDir, attrs.Mode)
+	ExpectEq(1000, attrs.Uid)
+	ExpectEq(1001, attrs.Gid)
+}
+
+func (t *PosixTest) ApplyPosixAttributes_MalformedValues() {
+	attrs := fuseops.InodeAttributes{Uid: uid, Gid: gid, Mode: 0644}
+
+	attrs = ApplyPosixAttributes(attrs, map[string]string{
+		PosixModeMetadataKey: "rwxr-xr-x",
+		PosixUidMetadataKey:  "-1",
+		PosixGidMetadataKey:  "",
+	})
+
+	ExpectEq(0644, attrs.Mode)
+	ExpectEq(uid, attrs.Uid)
+	ExpectEq(gid, attrs.Gid)
+}
+
+func (t *PosixTest) ApplyPosixAttributes_OnlyPermissionBits() {
+	attrs := fuseops.InodeAttributes{Mode: 0644}
+
+	attrs = ApplyPosixAttributes(attrs, map[string]string{
+		PosixModeMetadataKey: "104755",
+	})
+
+	ExpectEq(0755, attrs.Mode)
+}
+
+func (t *PosixTest) CheckPosixAttributesCaller() {
+	const mountUid = 1000
+	attrs := fuseops.InodeAttributes{Mode: 0644, Uid: 1001, Gid: 1002}
+	mode := os.FileMode(0600)
+	sameMode := os.FileMode(0644)
+	newId := uint32(1003)
+	sameUid := attrs.Uid
+	sameGid := attrs.Gid
+
+	testCases := []struct {
+		caller uint32
+		mode   *os.FileMode
+		uid    *uint32
+		gid    *uint32
+		err    error
+	}{
+		{0, &mode, &newId, &newId, nil},
+		{mountUid, &mode, &newId, &newId, nil},
+		{attrs.Uid, &mode, nil, nil, nil},
+		{attrs.Uid, nil, &newId, nil, syscall.EPERM},
+		{attrs.Uid, nil, nil, &newId, syscall.EPERM},
+		{attrs.Uid, nil, &sameUid, &sameGid, nil},
+		{1004, &mode, nil, nil, syscall.EPERM},
+		{1004, &sameMode, &sameUid, &sameGid, nil},
+		{1004, nil, nil, nil, nil},
+	}
+
+	for i, tc := range testCases {
+		err := CheckPosixAttributesCaller(tc.caller, mountUid, attrs, tc.mode, tc.uid, tc.gid)
+		ExpectEq(tc.err, err, "Test case %d", i)
+	}
+}
+
+func (t *PosixTest) ExplicitDir_SetPosixAttributes() {
+	mode := os.FileMode(0700) | os.ModeDir
+	newUid := uint32(1000)
+	oldMetaGeneration := t.in.SourceGeneration().Metadata
+
+	err := t.in.SetPosixAttributes(t.ctx, &mode, &newUid, nil)
+
+	AssertEq(nil, err)
+	m := t.statDirObject()
+	ExpectEq("700", m.Metadata[PosixModeMetadataKey])
+	ExpectEq("1000", m.Metadata[PosixUidMetadataKey])
+	_, ok := m.Metadata[PosixGidMetadataKey]
+	ExpectFalse(ok)
+	ExpectEq(m.MetaGeneration, t.in.SourceGeneration().Metadata)
+	ExpectEq(oldMetaGeneration+1, t.in.SourceGeneration().Metadata)
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0700|os.ModeDir, attrs.Mode)
+	ExpectEq(1000, attrs.Uid)
+	ExpectEq(gid, attrs.Gid)
+}
+
+func (t *PosixTest) ExplicitDir_SetPosixAttributes_Clobbered() {
+	_, err := t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{Name: dirInodeName})
+	AssertEq(nil, err)
+	newUid := uint32(1000)
+
+	err = t.in.SetPosixAttributes(t.ctx, nil, &newUid, nil)
+
+	ExpectEq(syscall.ESTALE, err)
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(uid, attrs.Uid)
+}
+
+func (t *FileTest) SetPosixAttributes() {
+	mode := os.FileMode(0600)
+	newGid := uint32(1001)
+
+	err := t.in.SetPosixAttributes(t.ctx, &mode, nil, &newGid)
+
+	AssertEq(nil, err)
+	m := t.statBackingObject()
+	ExpectEq("600", m.Metadata[PosixModeMetadataKey])
+	ExpectEq("1001", m.Metadata[PosixGidMetadataKey])
+	ExpectEq(m.MetaGeneration, t.in.SourceGeneration().Metadata)
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0600, attrs.Mode)
+	ExpectEq(uid, attrs.Uid)
+	ExpectEq(1001, attrs.Gid)
+}
+
+func (t *FileTest) SetPosixAttributes_Nothing() {
+	err := t.in.SetPosixAttributes(t.ctx, nil, nil, nil)
+
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.MetaGeneration, t.statBackingObject().MetaGeneration)
+}
+
+func (t *FileTest) SetPosixAttributes_LocalFileThenSync() {
+	t.createInodeWithLocalParam("test", true)
+	err := t.in.CreateEmptyTempFile()
+	AssertEq(nil, err)
+	newUid := uint32(1000)
+
+	err = t.in.SetPosixAttributes(t.ctx, nil, &newUid, nil)
+	AssertEq(nil, err)
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(1000, attrs.Uid)
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "test"})
+	AssertEq(nil, err)
+	ExpectEq("1000", m.Metadata[PosixUidMetadataKey])
+	ExpectEq(m.MetaGeneration, t.in.SourceGeneration().Metadata)
+}
+
+func (t *FileTest) SetPosixAttributes_ContentDirtyThenSync() {
+	err := t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+	mode := os.FileMode(0755)
+
+	err = t.in.SetPosixAttributes(t.ctx, &mode, nil, nil)
+	AssertEq(nil, err)
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	m := t.statBackingObject()
+	ExpectEq(7, m.Size)
+	ExpectEq("755", m.Metadata[PosixModeMetadataKey])
+}
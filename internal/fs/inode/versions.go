@@ -0,0 +1,553 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/locker"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"golang.org/x/net/context"
+)
+
+// VersionsDirName is the name of the virtual directory in the root of a bucket
+// which exposes the generations of objects, if enabled:
+//
+//   - ".gcsfuse-versions/<path>/" is a directory for each path at which an
+//     object exists or has ever existed, and for each directory containing
+//     such paths.
+//   - ".gcsfuse-versions/<path>/<generation>-<timestamp>" is a read-only file
+//     with the contents of a generation of the object named <path>, where
+//     <timestamp> is the time at which the generation was last updated in UTC.
+//     The names of soft-deleted generations, which can't be read until they
+//     are restored, have the suffix ".soft-deleted".
+//
+// The directory isn't listed in the root directory.
+const VersionsDirName = ".gcsfuse-versions"
+
+const (
+	versionTimestampFormat   = "20060102T150405Z"
+	softDeletedVersionSuffix = ".soft-deleted"
+)
+
+// An objectVersion is a generation of an object as listed with the noncurrent
+// or soft-deleted generations.
+type objectVersion struct {
+	o           *gcs.Object
+	softDeleted bool
+}
+
+// name returns the name of the file for the generation in the versions
+// directory of the object.
+func (v objectVersion) name() string {
+	name := fmt.Sprintf("%d-%s", v.o.Generation, v.o.Updated.UTC().Format(versionTimestampFormat))
+	if v.softDeleted {
+		name += softDeletedVersionSuffix
+	}
+	return name
+}
+
+// An inode that
+//
+//	(1) represents a directory in the directory of object versions, which
+//	    contains the generations of the object named by its path and the
+//	    directories for the paths below it;
+//	(2) implements DirInode, allowing read only ops.
+type versionsDirInode struct {
+	/////////////////////////
+	// Dependencies
+	/////////////////////////
+
+	bucket *gcsx.SyncerBucket
+
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	id fuseops.InodeID
+
+	// INVARIANT: name.IsDir()
+	name Name
+
+	// The name of the object whose generations the directory contains, empty
+	// for the directory of object versions itself.
+	objectName string
+
+	attrs fuseops.InodeAttributes
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	// A mutex that must be held when calling certain methods. See documentation
+	// for each method.
+	mu locker.RWLocker
+
+	// GUARDED_BY(mu)
+	lc lookupCount
+}
+
+var _ DirInode = &versionsDirInode{}
+
+// NewVersionsDirInode returns a read-only directory inode for the given name
+// within the directory of object versions in the given bucket, or for the
+// directory itself. Write permissions in attrs are ignored.
+//
+// REQUIRES: name.IsDir()
+func NewVersionsDirInode(
+	id fuseops.InodeID,
+	name Name,
+	attrs fuseops.InodeAttributes,
+	bucket *gcsx.SyncerBucket) (d DirInode) {
+	if !name.IsDir() {
+		panic(fmt.Sprintf("NewVersionsDirInode: %q is not a directory", name))
+	}
+
+	objectName := strings.TrimPrefix(name.GcsObjectName(), VersionsDirName+"/")
+	attrs.Mode &^= 0222
+
+	typed := &versionsDirInode{
+		bucket:     bucket,
+		id:         id,
+		name:       name,
+		objectName: strings.TrimSuffix(objectName, "/"),
+		attrs:      attrs,
+	}
+	typed.lc.Init(id)
+	typed.mu = locker.NewRW("VersionsDirInode"+name.GcsObjectName(), func() {})
+
+	d = typed
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// childPath returns the path below the directory with the given name.
+func (d *versionsDirInode) childPath(name string) string {
+	if d.objectName == "" {
+		return name
+	}
+	return d.objectName + "/" + name
+}
+
+// listGenerations lists all the live, noncurrent and soft-deleted generations
+// of objects whose names begin with the given prefix, collapsing the names
+// below the next "/".
+func (d *versionsDirInode) listGenerations(
+	ctx context.Context,
+	prefix string) (versions []objectVersion, collapsedRuns []string, err error) {
+	for _, softDeleted := range []bool{false, true} {
+		req := &gcs.ListObjectsRequest{
+			Prefix:      prefix,
+			Delimiter:   "/",
+			Versions:    !softDeleted,
+			SoftDeleted: softDeleted,
+			MaxResults:  MaxResultsForListObjectsCall,
+		}
+
+		for {
+			var listing *gcs.Listing
+			listing, err = d.bucket.ListObjects(ctx, req)
+			if err != nil {
+				err = fmt.Errorf("ListObjects: %w", err)
+				return
+			}
+
+			for _, o := range listing.Objects {
+				versions = append(versions, objectVersion{o: o, softDeleted: softDeleted})
+			}
+			collapsedRuns = append(collapsedRuns, listing.CollapsedRuns...)
+
+			if listing.ContinuationToken == "" {
+				break
+			}
+			req.ContinuationToken = listing.ContinuationToken
+		}
+	}
+
+	return
+}
+
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) lookUpVersion(
+	ctx context.Context,
+	name string) (*Core, error) {
+	versions, _, err := d.listGenerations(ctx, d.objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		if v.o.Name == d.objectName && v.name() == name {
+			return &Core{
+				Bucket:    d.bucket,
+				FullName:  NewFileName(d.name, name),
+				MinObject: storageutil.ConvertObjToMinObject(v.o),
+				Version:   true,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// Public interface
+////////////////////////////////////////////////////////////////////////
+
+func (d *versionsDirInode) Lock() {
+	d.mu.Lock()
+}
+
+func (d *versionsDirInode) Unlock() {
+	d.mu.Unlock()
+}
+
+func (d *versionsDirInode) RLock() {
+	d.mu.RLock()
+}
+
+func (d *versionsDirInode) RUnlock() {
+	d.mu.RUnlock()
+}
+
+// LockForChildLookup takes read-only lock on inode when the inode's child is
+// looked up, since the lookup doesn't modify the inode.
+func (d *versionsDirInode) LockForChildLookup() {
+	d.mu.RLock()
+}
+
+func (d *versionsDirInode) UnlockForChildLookup() {
+	d.mu.RUnlock()
+}
+
+func (d *versionsDirInode) ID() fuseops.InodeID {
+	return d.id
+}
+
+func (d *versionsDirInode) Name() Name {
+	return d.name
+}
+
+func (d *versionsDirInode) Bucket() *gcsx.SyncerBucket {
+	return d.bucket
+}
+
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) IncrementLookupCount() {
+	d.lc.Inc()
+}
+
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) DecrementLookupCount(n uint64) (destroy bool) {
+	destroy = d.lc.Dec(n)
+	return
+}
+
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) Destroy() (err error) {
+	// Nothing interesting to do.
+	return
+}
+
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) Attributes(
+	ctx context.Context) (attrs fuseops.InodeAttributes, err error) {
+	attrs = d.attrs
+	attrs.Nlink = 1
+
+	return
+}
+
+// LookUpChild returns the generation of the object with the given file name,
+// or else the directory for the path below with the given name, if an object
+// exists or has ever existed at or below that path.
+//
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) LookUpChild(ctx context.Context, name string) (*Core, error) {
+	// Only names starting with a generation number can be generations.
+	generation, _, _ := strings.Cut(name, "-")
+	if _, err := strconv.ParseInt(generation, 10, 64); err == nil && d.objectName != "" {
+		core, err := d.lookUpVersion(ctx, name)
+		if err != nil || core != nil {
+			return core, err
+		}
+	}
+
+	childPath := d.childPath(name)
+	versions, collapsedRuns, err := d.listGenerations(ctx, childPath)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	for _, v := range versions {
+		exists = exists || v.o.Name == childPath
+	}
+	for _, run := range collapsedRuns {
+		exists = exists || run == childPath+"/"
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	return &Core{
+		Bucket:   d.bucket,
+		FullName: NewDirName(d.name, name),
+		Version:  true,
+	}, nil
+}
+
+// Not implemented
+func (d *versionsDirInode) ReadDescendants(ctx context.Context, limit int) (map[Name]*Core, error) {
+	return nil, fuse.ENOSYS
+}
+
+// ReadEntries returns all the entries in a single batch: the generations of
+// the object, followed by the directories for the paths below.
+//
+// LOCKS_REQUIRED(d)
+func (d *versionsDirInode) ReadEntries(
+	ctx context.Context,
+	tok string) (entries []fuseutil.Dirent, newTok string, err error) {
+	if d.objectName != "" {
+		var versions []objectVersion
+		versions, _, err = d.listGenerations(ctx, d.objectName)
+		if err != nil {
+			return
+		}
+
+		for _, v := range versions {
+			if v.o.Name == d.objectName {
+				entries = append(entries, fuseutil.Dirent{
+					Name: v.name(),
+					Type: fuseutil.DT_File,
+				})
+			}
+		}
+	}
+
+	prefix := d.childPath("")
+	versions, collapsedRuns, err := d.listGenerations(ctx, prefix)
+	if err != nil {
+		return
+	}
+
+	// Each path is listed once, however many generations it has.
+	children := make(map[string]struct{})
+	for _, v := range versions {
+		children[strings.TrimPrefix(v.o.Name, prefix)] = struct{}{}
+	}
+	for _, run := range collapsedRuns {
+		children[strings.TrimSuffix(strings.TrimPrefix(run, prefix), "/")] = struct{}{}
+	}
+	// The object named by the prefix itself, i.e. the directory object.
+	delete(children, "")
+
+	for name := range children {
+		entries = append(entries, fuseutil.Dirent{
+			Name: name,
+			Type: fuseutil.DT_Directory,
+		})
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Forbidden Public interface
+////////////////////////////////////////////////////////////////////////
+
+// The directory of object versions is read-only. When the user tries to
+// mutate it, they will receive an EROFS error.
+
+func (d *versionsDirInode) CreateChildFile(ctx context.Context, name string) (*Core, error) {
+	return nil, syscall.EROFS
+}
+
+func (d *versionsDirInode) CreateLocalChildFile(name string) (*Core, error) {
+	return nil, syscall.EROFS
+}
+
+func (d *versionsDirInode) CloneToChildFile(ctx context.Context, name string, src *gcs.MinObject) (*Core, error) {
+	return nil, syscall.EROFS
+}
+
+func (d *versionsDirInode) CreateChildSymlink(ctx context.Context, name string, target string) (*Core, error) {
+	return nil, syscall.EROFS
+}
+
+func (d *versionsDirInode) CreateChildDir(ctx context.Context, name string) (*Core, error) {
+	return nil, syscall.EROFS
+}
+
+func (d *versionsDirInode) RenameToChildDir(ctx context.Context, name string, src Name) (*Core, error) {
+	return nil, syscall.EROFS
+}
+
+func (d *versionsDirInode) DeleteChildFile(
+	ctx context.Context,
+	name string,
+	generation int64,
+	metaGeneration *int64) (err error) {
+	err = syscall.EROFS
+	return
+}
+
+func (d *versionsDirInode) DeleteChildDir(
+	ctx context.Context,
+	name string,
+	isImplicitDir bool) (err error) {
+	err = syscall.EROFS
+	return
+}
+
+func (d *versionsDirInode) LocalFileEntries(localFileInodes map[Name]Inode) (localEntries []fuseutil.Dirent) {
+	// The directory of object versions can not contain local files.
+	return nil
+}
+
+func (d *versionsDirInode) ShouldInvalidateKernelListCache(ttl time.Duration) bool {
+	// Generations may be added at any time.
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////
+// VersionInode
+////////////////////////////////////////////////////////////////////////
+
+// VersionInode is a read-only file inode for a generation of an object, within
+// the directory of object versions.
+type VersionInode struct {
+	/////////////////////////
+	// Dependencies
+	/////////////////////////
+
+	bucket *gcsx.SyncerBucket
+
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	id    fuseops.InodeID
+	name  Name
+	src   gcs.MinObject
+	attrs fuseops.InodeAttributes
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	lc lookupCount
+}
+
+var _ BucketOwnedInode = &VersionInode{}
+
+// NewVersionInode creates a read-only file inode for the given generation of
+// an object. Write permissions in attrs are ignored.
+func NewVersionInode(
+	id fuseops.InodeID,
+	name Name,
+	m *gcs.MinObject,
+	attrs fuseops.InodeAttributes,
+	bucket *gcsx.SyncerBucket) (v *VersionInode) {
+	v = &VersionInode{
+		bucket: bucket,
+		id:     id,
+		name:   name,
+		src:    *m,
+		attrs: fuseops.InodeAttributes{
+			Nlink: 1,
+			Size:  m.Size,
+			Uid:   attrs.Uid,
+			Gid:   attrs.Gid,
+			Mode:  attrs.Mode &^ 0222,
+			Atime: m.Updated,
+			Ctime: m.Updated,
+			Mtime: m.Updated,
+		},
+	}
+
+	// Set up lookup counting.
+	v.lc.Init(id)
+
+	return
+}
+
+func (v *VersionInode) Lock() {
+	v.mu.Lock()
+}
+
+func (v *VersionInode) Unlock() {
+	v.mu.Unlock()
+}
+
+func (v *VersionInode) ID() fuseops.InodeID {
+	return v.id
+}
+
+func (v *VersionInode) Name() Name {
+	return v.name
+}
+
+func (v *VersionInode) Bucket() *gcsx.SyncerBucket {
+	return v.bucket
+}
+
+// Source returns a record for the generation of the object exposed by the
+// inode. Doesn't require the lock to be held.
+func (v *VersionInode) Source() *gcs.MinObject {
+	// Make a copy, since callers may modify it.
+	o := v.src
+	return &o
+}
+
+// LOCKS_REQUIRED(v.mu)
+func (v *VersionInode) IncrementLookupCount() {
+	v.lc.Inc()
+}
+
+// LOCKS_REQUIRED(v.mu)
+func (v *VersionInode) DecrementLookupCount(n uint64) (destroy bool) {
+	destroy = v.lc.Dec(n)
+	return
+}
+
+// LOCKS_REQUIRED(v.mu)
+func (v *VersionInode) Destroy() (err error) {
+	// Nothing to do.
+	return
+}
+
+func (v *VersionInode) Attributes(
+	ctx context.Context) (attrs fuseops.InodeAttributes, err error) {
+	attrs = v.attrs
+	return
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestVersions(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type VersionsTest struct {
+	ctx    context.Context
+	bucket gcsx.SyncerBucket
+	clock  timeutil.SimulatedClock
+}
+
+var _ SetUpInterface = &VersionsTest{}
+
+func init() { RegisterTestSuite(&VersionsTest{}) }
+
+func (t *VersionsTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
+	t.bucket = gcsx.NewSyncerBucket(
+		1, // Append threshold
+		".gcsfuse_tmp/",
+		fake.NewVersionedFakeBucket(&t.clock, "some_bucket"))
+}
+
+func (t *VersionsTest) createVersionsDir(path string) DirInode {
+	return NewVersionsDirInode(
+		dirInodeID,
+		NewDirName(NewRootName(""), VersionsDirName+"/"+path),
+		fuseops.InodeAttributes{
+			Uid:  uid,
+			Gid:  gid,
+			Mode: dirMode,
+		},
+		&t.bucket)
+}
+
+func (t *VersionsTest) createObject(name string, contents string) *gcs.Object {
+	o, err := storageutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	AssertEq(nil, err)
+	return o
+}
+
+func (t *VersionsTest) readAllEntries(d DirInode) []fuseutil.Dirent {
+	d.Lock()
+	defer d.Unlock()
+	entries, tok, err := d.ReadEntries(t.ctx, "")
+	AssertEq(nil, err)
+	AssertEq("", tok)
+	sort.Sort(DirentSlice(entries))
+	return entries
+}
+
+func versionName(o *gcs.Object) string {
+	return fmt.Sprintf("%d-%s", o.Generation, o.Updated.UTC().Format("20060102T150405Z"))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *VersionsTest) Attributes() {
+	d := t.createVersionsDir("")
+
+	attrs, err := d.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(0510|uint32(dirMode&^0777), uint32(attrs.Mode))
+	ExpectEq(uid, attrs.Uid)
+	ExpectEq(gid, attrs.Gid)
+}
+
+func (t *VersionsTest) ReadEntries_Root() {
+	t.createObject("foo", "taco")
+	t.createObject("foo", "burrito")
+	t.createObject("dir/bar", "enchilada")
+	t.createObject("baz", "queso")
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "baz"}))
+	d := t.createVersionsDir("")
+
+	entries := t.readAllEntries(d)
+
+	AssertEq(3, len(entries))
+	ExpectEq("baz", entries[0].Name)
+	ExpectEq(fuseutil.DT_Directory, entries[0].Type)
+	ExpectEq("dir", entries[1].Name)
+	ExpectEq(fuseutil.DT_Directory, entries[1].Type)
+	ExpectEq("foo", entries[2].Name)
+	ExpectEq(fuseutil.DT_Directory, entries[2].Type)
+}
+
+func (t *VersionsTest) ReadEntries_Object() {
+	o1 := t.createObject("foo", "taco")
+	t.clock.AdvanceTime(time.Minute)
+	o2 := t.createObject("foo", "burrito")
+	t.createObject("foo/bar", "enchilada")
+	d := t.createVersionsDir("foo/")
+
+	entries := t.readAllEntries(d)
+
+	AssertEq(3, len(entries))
+	ExpectEq(versionName(o1), entries[0].Name)
+	ExpectEq(fuseutil.DT_File, entries[0].Type)
+	ExpectEq(versionName(o2), entries[1].Name)
+	ExpectEq(fuseutil.DT_File, entries[1].Type)
+	ExpectEq("bar", entries[2].Name)
+	ExpectEq(fuseutil.DT_Directory, entries[2].Type)
+}
+
+func (t *VersionsTest) ReadEntries_DeletedObject() {
+	o := t.createObject("foo", "taco")
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"}))
+	d := t.createVersionsDir("foo/")
+
+	entries := t.readAllEntries(d)
+
+	AssertEq(1, len(entries))
+	ExpectEq(versionName(o), entries[0].Name)
+}
+
+func (t *VersionsTest) LookUpChild_Version() {
+	o1 := t.createObject("foo", "taco")
+	t.createObject("foo", "burrito")
+	d := t.createVersionsDir("foo/")
+	d.Lock()
+	defer d.Unlock()
+
+	core, err := d.LookUpChild(t.ctx, versionName(o1))
+
+	AssertEq(nil, err)
+	AssertNe(nil, core)
+	ExpectTrue(core.Version)
+	ExpectEq(VersionsDirName+"/foo/"+versionName(o1), core.FullName.GcsObjectName())
+	ExpectEq(o1.Generation, core.MinObject.Generation)
+	ExpectEq(nil, core.SanityCheck())
+}
+
+func (t *VersionsTest) LookUpChild_Dir() {
+	t.createObject("foo/bar", "taco")
+	d := t.createVersionsDir("")
+	d.Lock()
+	defer d.Unlock()
+
+	core, err := d.LookUpChild(t.ctx, "foo")
+
+	AssertEq(nil, err)
+	AssertNe(nil, core)
+	ExpectTrue(core.Version)
+	ExpectTrue(core.FullName.IsDir())
+	ExpectEq(VersionsDirName+"/foo/", core.FullName.GcsObjectName())
+	ExpectEq(nil, core.MinObject)
+}
+
+func (t *VersionsTest) LookUpChild_NonExistent() {
+	o := t.createObject("foo", "taco")
+	d := t.createVersionsDir("foo/")
+	d.Lock()
+	defer d.Unlock()
+
+	core, err := d.LookUpChild(t.ctx, "bar")
+	AssertEq(nil, err)
+	ExpectEq(nil, core)
+
+	core, err = d.LookUpChild(t.ctx, fmt.Sprintf("%d-19700101T000000Z", o.Generation+1))
+	AssertEq(nil, err)
+	ExpectEq(nil, core)
+}
+
+func (t *VersionsTest) Mutations() {
+	o := t.createObject("foo", "taco")
+	d := t.createVersionsDir("foo/")
+	d.Lock()
+	defer d.Unlock()
+
+	_, err := d.CreateChildFile(t.ctx, "bar")
+	ExpectEq(syscall.EROFS, err)
+	_, err = d.CreateChildDir(t.ctx, "bar")
+	ExpectEq(syscall.EROFS, err)
+	_, err = d.CloneToChildFile(t.ctx, "bar", storageutil.ConvertObjToMinObject(o))
+	ExpectEq(syscall.EROFS, err)
+	err = d.DeleteChildFile(t.ctx, versionName(o), 0, nil)
+	ExpectEq(syscall.EROFS, err)
+}
+
+func (t *VersionsTest) VersionInode_Attributes() {
+	o := t.createObject("foo", "taco")
+	t.createObject("foo", "burrito")
+	v := NewVersionInode(
+		fileInodeID,
+		NewFileName(NewDirName(NewRootName(""), VersionsDirName+"/foo/"), versionName(o)),
+		storageutil.ConvertObjToMinObject(o),
+		fuseops.InodeAttributes{
+			Uid:  uid,
+			Gid:  gid,
+			Mode: fileMode,
+		},
+		&t.bucket)
+
+	attrs, err := v.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(len("taco"), attrs.Size)
+	ExpectEq(0441, attrs.Mode)
+	ExpectEq(1, attrs.Nlink)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(o.Updated))
+	ExpectEq(o.Generation, v.Source().Generation)
+}
@@ -0,0 +1,314 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse"
+	"golang.org/x/net/context"
+)
+
+// Extended attributes of files are backed by the backing object in GCS:
+//
+//   - "user.<key>" is read-write and maps to the custom metadata <key> of the
+//     object, except for the keys reserved for use by gcsfuse and GCS.
+//   - "system.gcs.<attr>" are read-only and expose the properties of the
+//     object.
+const (
+	UserXattrPrefix      = "user."
+	SystemGCSXattrPrefix = "system.gcs."
+
+	GenerationXattr     = SystemGCSXattrPrefix + "generation"
+	MetaGenerationXattr = SystemGCSXattrPrefix + "metageneration"
+	CRC32CXattr         = SystemGCSXattrPrefix + "crc32c"
+	MD5Xattr            = SystemGCSXattrPrefix + "md5"
+	StorageClassXattr   = SystemGCSXattrPrefix + "storage_class"
+	ContentTypeXattr    = SystemGCSXattrPrefix + "content_type"
+
+	// Flags of setxattr(2).
+	xattrCreate  = 0x1
+	xattrReplace = 0x2
+
+	// reservedMetadataKeyPrefix is the prefix of custom metadata keys reserved
+	// by GCS tools, e.g. goog-reserved-file-mtime set by gsutil.
+	reservedMetadataKeyPrefix = "goog-reserved-"
+)
+
+// isReservedMetadataKey returns true if the custom metadata key is used by
+// gcsfuse or GCS tools, and hence can't be accessed as an extended attribute.
+func isReservedMetadataKey(key string) bool {
+	return key == FileMtimeMetadataKey ||
+		key == SymlinkMetadataKey ||
+		strings.HasPrefix(key, reservedMetadataKeyPrefix)
+}
+
+// metadataKeyForXattr returns the custom metadata key to which the given
+// extended attribute in user namespace maps. ok is false if the attribute
+// doesn't map to an accessible key.
+func metadataKeyForXattr(name string) (key string, ok bool) {
+	key, ok = strings.CutPrefix(name, UserXattrPrefix)
+	if !ok || key == "" || isReservedMetadataKey(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// IsGCSXattr returns true if name is in one of the namespaces of extended
+// attributes backed by objects, i.e. user and system.gcs. Others, such as
+// security.capability which the kernel looks up before every write, are never
+// present.
+func IsGCSXattr(name string) bool {
+	return strings.HasPrefix(name, UserXattrPrefix) || strings.HasPrefix(name, SystemGCSXattrPrefix)
+}
+
+// isSystemGCSXattr returns true if name is one of the read-only attributes in
+// system.gcs namespace.
+func isSystemGCSXattr(name string) bool {
+	switch name {
+	case GenerationXattr, MetaGenerationXattr, CRC32CXattr, MD5Xattr, StorageClassXattr, ContentTypeXattr:
+		return true
+	}
+	return false
+}
+
+// ListXattr returns the names of the extended attributes of the file in
+// sorted order. It doesn't involve a round trip to GCS. The md5 attribute is
+// listed even though GCS doesn't export MD5 hash for composite objects.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ListXattr() (names []string) {
+	for key := range f.src.Metadata {
+		if !isReservedMetadataKey(key) {
+			names = append(names, UserXattrPrefix+key)
+		}
+	}
+
+	// Local files have no backing object yet.
+	if !f.IsLocal() {
+		names = append(names, GenerationXattr, MetaGenerationXattr, MD5Xattr, StorageClassXattr, ContentTypeXattr)
+		if f.src.CRC32C != nil {
+			names = append(names, CRC32CXattr)
+		}
+	}
+
+	sort.Strings(names)
+	return
+}
+
+// GetXattr returns the value of the extended attribute with the given name,
+// or fuse.ENOATTR if the file doesn't have it. Attributes which aren't part of
+// the source record, i.e. md5, storage_class and content_type, involve a round
+// trip to GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) GetXattr(
+	ctx context.Context,
+	name string) (value []byte, err error) {
+	if strings.HasPrefix(name, UserXattrPrefix) {
+		key, ok := metadataKeyForXattr(name)
+		if !ok {
+			err = fuse.ENOATTR
+			return
+		}
+		v, ok := f.src.Metadata[key]
+		if !ok {
+			err = fuse.ENOATTR
+			return
+		}
+		value = []byte(v)
+		return
+	}
+
+	if !isSystemGCSXattr(name) || f.IsLocal() {
+		err = fuse.ENOATTR
+		return
+	}
+
+	switch name {
+	case GenerationXattr:
+		value = []byte(strconv.FormatInt(f.src.Generation, 10))
+		return
+	case MetaGenerationXattr:
+		value = []byte(strconv.FormatInt(f.src.MetaGeneration, 10))
+		return
+	case CRC32CXattr:
+		if f.src.CRC32C == nil {
+			err = fuse.ENOATTR
+			return
+		}
+		// Encoded in the same way as GCS does, i.e. base64 of the big-endian
+		// checksum.
+		buf := binary.BigEndian.AppendUint32(nil, *f.src.CRC32C)
+		value = []byte(base64.StdEncoding.EncodeToString(buf))
+		return
+	}
+
+	// The remaining attributes are only returned by a stat of the object in GCS.
+	m, e, err := f.bucket.StatObject(ctx, &gcs.StatObjectRequest{
+		Name:                           f.src.Name,
+		ForceFetchFromGcs:              true,
+		ReturnExtendedObjectAttributes: true,
+	})
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		err = syscall.ESTALE
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("StatObject: %w", err)
+		return
+	}
+	// A different generation has different content, hence the attributes of
+	// the latest generation are not of this file.
+	if m.Generation != f.src.Generation {
+		err = syscall.ESTALE
+		return
+	}
+
+	switch name {
+	case MD5Xattr:
+		if e.MD5 == nil {
+			err = fuse.ENOATTR
+			return
+		}
+		value = []byte(base64.StdEncoding.EncodeToString(e.MD5[:]))
+	case StorageClassXattr:
+		value = []byte(e.StorageClass)
+	case ContentTypeXattr:
+		value = []byte(e.ContentType)
+	}
+	return
+}
+
+// SetXattr sets the extended attribute in user namespace with the given name
+// by updating the custom metadata of the backing object in GCS. flags have
+// the semantics of setxattr(2). Attributes in other namespaces can't be set.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SetXattr(
+	ctx context.Context,
+	name string,
+	value []byte,
+	flags uint32) (err error) {
+	key, ok := metadataKeyForXattr(name)
+	if !ok {
+		err = syscall.EPERM
+		return
+	}
+	// Custom metadata values in GCS are strings.
+	if !utf8.Valid(value) {
+		err = syscall.EINVAL
+		return
+	}
+
+	_, exists := f.src.Metadata[key]
+	if flags&xattrCreate != 0 && exists {
+		err = syscall.EEXIST
+		return
+	}
+	if flags&xattrReplace != 0 && !exists {
+		err = fuse.ENOATTR
+		return
+	}
+
+	v := string(value)
+	err = f.updateMetadata(ctx, map[string]*string{key: &v})
+	return
+}
+
+// RemoveXattr removes the extended attribute in user namespace with the given
+// name by deleting the custom metadata key of the backing object in GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) RemoveXattr(
+	ctx context.Context,
+	name string) (err error) {
+	key, ok := metadataKeyForXattr(name)
+	if !ok {
+		if isSystemGCSXattr(name) {
+			err = syscall.EPERM
+		} else {
+			err = fuse.ENOATTR
+		}
+		return
+	}
+	if _, exists := f.src.Metadata[key]; !exists {
+		err = fuse.ENOATTR
+		return
+	}
+
+	err = f.updateMetadata(ctx, map[string]*string{key: nil})
+	return
+}
+
+// updateMetadata applies the given custom metadata updates to the source
+// generation of the backing object, and updates the source record. Returns
+// syscall.ESTALE if the object has been clobbered or its metadata has been
+// modified by someone else since it was last looked up.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) updateMetadata(
+	ctx context.Context,
+	metadata map[string]*string) (err error) {
+	// A local file has no backing object to store the metadata in until it is
+	// synced.
+	if f.IsLocal() {
+		err = syscall.ENOTSUP
+		return
+	}
+
+	srcGen := f.SourceGeneration()
+	req := &gcs.UpdateObjectRequest{
+		Name:                       f.src.Name,
+		Generation:                 srcGen.Object,
+		MetaGenerationPrecondition: &srcGen.Metadata,
+		Metadata:                   metadata,
+	}
+
+	o, err := f.bucket.UpdateObject(ctx, req)
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &notFoundErr) || errors.As(err, &preconditionErr) {
+		err = syscall.ESTALE
+		return
+	}
+	// Individual keys can't be deleted over gRPC.
+	if errors.Is(err, syscall.ENOTSUP) {
+		err = syscall.ENOTSUP
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("UpdateObject: %w", err)
+		return
+	}
+
+	// The content, if dirty, is synced with the metadata of the latest object,
+	// hence the update isn't lost.
+	if minObj := storageutil.ConvertObjToMinObject(o); minObj != nil {
+		f.src = *minObj
+	}
+	return
+}
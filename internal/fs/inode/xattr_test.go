@@ -0,0 +1,249 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"strconv"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+// setBackingObjectMetadata sets the given custom metadata on the backing
+// object and recreates the inode for the updated object.
+func (t *FileTest) setBackingObjectMetadata(metadata map[string]*string) {
+	o, err := t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{
+		Name:     fileName,
+		Metadata: metadata,
+	})
+	AssertEq(nil, err)
+	t.backingObj = storageutil.ConvertObjToMinObject(o)
+	t.createInode()
+}
+
+func (t *FileTest) statBackingObject() *gcs.MinObject {
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: fileName})
+	AssertEq(nil, err)
+	return m
+}
+
+func (t *FileTest) ListXattr() {
+	foo := "bar"
+	mtime := "2012-08-15T22:56:00Z"
+	t.setBackingObjectMetadata(map[string]*string{
+		"foo":                      &foo,
+		FileMtimeMetadataKey:       &mtime,
+		"goog-reserved-file-mtime": &mtime,
+	})
+
+	names := t.in.ListXattr()
+
+	ExpectThat(names, ElementsAre(
+		ContentTypeXattr,
+		CRC32CXattr,
+		GenerationXattr,
+		MD5Xattr,
+		MetaGenerationXattr,
+		StorageClassXattr,
+		"user.foo",
+	))
+}
+
+func (t *FileTest) ListXattr_LocalFile() {
+	t.createInodeWithLocalParam("test", true)
+
+	names := t.in.ListXattr()
+
+	ExpectEq(0, len(names))
+}
+
+func (t *FileTest) GetXattr_User() {
+	foo := "bar"
+	t.setBackingObjectMetadata(map[string]*string{"foo": &foo})
+
+	value, err := t.in.GetXattr(t.ctx, "user.foo")
+
+	AssertEq(nil, err)
+	ExpectEq("bar", string(value))
+}
+
+func (t *FileTest) GetXattr_UserNotPresent() {
+	_, err := t.in.GetXattr(t.ctx, "user.foo")
+
+	ExpectEq(fuse.ENOATTR, err)
+}
+
+func (t *FileTest) GetXattr_ReservedKey() {
+	mtime := "2012-08-15T22:56:00Z"
+	t.setBackingObjectMetadata(map[string]*string{FileMtimeMetadataKey: &mtime})
+
+	_, err := t.in.GetXattr(t.ctx, UserXattrPrefix+FileMtimeMetadataKey)
+
+	ExpectEq(fuse.ENOATTR, err)
+}
+
+func (t *FileTest) GetXattr_System() {
+	sum := md5.Sum([]byte(t.initialContents))
+
+	generation, err := t.in.GetXattr(t.ctx, GenerationXattr)
+	AssertEq(nil, err)
+	metaGeneration, err := t.in.GetXattr(t.ctx, MetaGenerationXattr)
+	AssertEq(nil, err)
+	crc32c, err := t.in.GetXattr(t.ctx, CRC32CXattr)
+	AssertEq(nil, err)
+	md5Sum, err := t.in.GetXattr(t.ctx, MD5Xattr)
+	AssertEq(nil, err)
+	storageClass, err := t.in.GetXattr(t.ctx, StorageClassXattr)
+	AssertEq(nil, err)
+
+	ExpectEq(strconv.FormatInt(t.backingObj.Generation, 10), string(generation))
+	ExpectEq(strconv.FormatInt(t.backingObj.MetaGeneration, 10), string(metaGeneration))
+	ExpectEq(8, len(crc32c))
+	ExpectEq(base64.StdEncoding.EncodeToString(sum[:]), string(md5Sum))
+	ExpectEq("STANDARD", string(storageClass))
+}
+
+func (t *FileTest) GetXattr_SystemUnknown() {
+	_, err := t.in.GetXattr(t.ctx, SystemGCSXattrPrefix+"foo")
+
+	ExpectEq(fuse.ENOATTR, err)
+}
+
+func (t *FileTest) GetXattr_SystemClobbered() {
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, fileName, []byte("burrito"))
+	AssertEq(nil, err)
+
+	_, err = t.in.GetXattr(t.ctx, StorageClassXattr)
+
+	ExpectEq(syscall.ESTALE, err)
+}
+
+func (t *FileTest) IsGCSXattr() {
+	ExpectTrue(IsGCSXattr("user.foo"))
+	ExpectTrue(IsGCSXattr(GenerationXattr))
+	ExpectTrue(IsGCSXattr("system.gcs.unknown"))
+	ExpectFalse(IsGCSXattr("security.capability"))
+	ExpectFalse(IsGCSXattr("system.posix_acl_access"))
+	ExpectFalse(IsGCSXattr("trusted.foo"))
+}
+
+func (t *FileTest) SetXattr() {
+	err := t.in.SetXattr(t.ctx, "user.foo", []byte("bar"), 0)
+
+	AssertEq(nil, err)
+	ExpectEq("bar", t.statBackingObject().Metadata["foo"])
+	ExpectEq("bar", t.in.Source().Metadata["foo"])
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	ExpectEq(t.backingObj.MetaGeneration+1, t.in.SourceGeneration().Metadata)
+}
+
+func (t *FileTest) SetXattr_CreateWhenPresent() {
+	foo := "bar"
+	t.setBackingObjectMetadata(map[string]*string{"foo": &foo})
+
+	err := t.in.SetXattr(t.ctx, "user.foo", []byte("baz"), xattrCreate)
+
+	ExpectEq(syscall.EEXIST, err)
+	ExpectEq("bar", t.statBackingObject().Metadata["foo"])
+}
+
+func (t *FileTest) SetXattr_ReplaceWhenAbsent() {
+	err := t.in.SetXattr(t.ctx, "user.foo", []byte("bar"), xattrReplace)
+
+	ExpectEq(fuse.ENOATTR, err)
+	ExpectEq(0, len(t.statBackingObject().Metadata))
+}
+
+func (t *FileTest) SetXattr_System() {
+	err := t.in.SetXattr(t.ctx, StorageClassXattr, []byte("NEARLINE"), 0)
+
+	ExpectEq(syscall.EPERM, err)
+}
+
+func (t *FileTest) SetXattr_InvalidValue() {
+	err := t.in.SetXattr(t.ctx, "user.foo", []byte{0xff, 0xfe}, 0)
+
+	ExpectEq(syscall.EINVAL, err)
+}
+
+func (t *FileTest) SetXattr_LocalFile() {
+	t.createInodeWithLocalParam("test", true)
+
+	err := t.in.SetXattr(t.ctx, "user.foo", []byte("bar"), 0)
+
+	ExpectEq(syscall.ENOTSUP, err)
+}
+
+func (t *FileTest) SetXattr_MetaGenerationChanged() {
+	baz := "baz"
+	_, err := t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{
+		Name:     fileName,
+		Metadata: map[string]*string{"baz": &baz},
+	})
+	AssertEq(nil, err)
+
+	err = t.in.SetXattr(t.ctx, "user.foo", []byte("bar"), 0)
+
+	ExpectEq(syscall.ESTALE, err)
+	ExpectEq("", t.statBackingObject().Metadata["foo"])
+}
+
+func (t *FileTest) SetXattr_ContentDirtyThenSync() {
+	err := t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.SetXattr(t.ctx, "user.foo", []byte("bar"), 0)
+	AssertEq(nil, err)
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	m := t.statBackingObject()
+	ExpectEq(7, m.Size)
+	ExpectEq("bar", m.Metadata["foo"])
+}
+
+func (t *FileTest) RemoveXattr() {
+	foo := "bar"
+	baz := "qux"
+	t.setBackingObjectMetadata(map[string]*string{"foo": &foo, "baz": &baz})
+
+	err := t.in.RemoveXattr(t.ctx, "user.foo")
+
+	AssertEq(nil, err)
+	m := t.statBackingObject()
+	_, ok := m.Metadata["foo"]
+	ExpectFalse(ok)
+	ExpectEq("qux", m.Metadata["baz"])
+	_, err = t.in.GetXattr(t.ctx, "user.foo")
+	ExpectEq(fuse.ENOATTR, err)
+}
+
+func (t *FileTest) RemoveXattr_NotPresent() {
+	err := t.in.RemoveXattr(t.ctx, "user.foo")
+
+	ExpectEq(fuse.ENOATTR, err)
+}
+
+func (t *FileTest) RemoveXattr_System() {
+	err := t.in.RemoveXattr(t.ctx, GenerationXattr)
+
+	ExpectEq(syscall.EPERM, err)
+}
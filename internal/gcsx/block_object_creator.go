@@ -0,0 +1,656 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// BlockSetPrefix is the prefix of the names of the block objects from which
+// objects written out by partial updates are composed, and of the manifests
+// recording them. Unlike temporary objects, they are garbage collected only
+// once their object has been deleted or overwritten by other means, however
+// old they are. See collectBlockSets.
+const BlockSetPrefix = ".gcsfuse_blocks/"
+
+// PartialUpdateConfig controls the partial updates made by a syncer.
+type PartialUpdateConfig struct {
+	// Files at least this long are written out by partial updates. Zero
+	// disables partial updates.
+	Threshold int64
+
+	// The size of each block. Larger blocks are used if needed to keep within
+	// gcs.MaxComponentCount blocks.
+	BlockSize int64
+
+	// The maximum number of blocks of a file uploaded at a time.
+	Parallelism int
+}
+
+// The name of the manifest of a block set, relative to its directory.
+const blockSetManifestName = "manifest"
+
+// A blockSet records the block objects from which a generation of an object
+// was composed, so that the blocks of a later generation that are unchanged
+// from it can be composed from the same block objects rather than uploaded
+// again. Compose works only on whole objects, so the block objects are kept
+// alongside the object for as long as it is written out this way.
+type blockSet struct {
+	// The object, and the generation of it composed from the blocks.
+	ObjectName       string
+	ObjectGeneration int64
+
+	// The length of each block but the last, which may be shorter.
+	BlockSize int64
+
+	// The block objects, in order.
+	Blocks []gcs.ComposeSource
+}
+
+// Whether the block set records how the supplied source object was composed.
+func (s *blockSet) matches(srcObject *gcs.Object) bool {
+	if srcObject == nil ||
+		s.ObjectName != srcObject.Name ||
+		s.ObjectGeneration != srcObject.Generation ||
+		s.BlockSize < 1 {
+		return false
+	}
+
+	size := int64(srcObject.Size)
+	return int64(len(s.Blocks)) == max(1, (size+s.BlockSize-1)/s.BlockSize)
+}
+
+// The directory of the block set of the supplied object, ending with a slash.
+func blockSetDir(prefix string, objectName string) string {
+	// Hash the name of the object, as it may be as long as the longest allowed
+	// object name.
+	sum := sha256.Sum256([]byte(objectName))
+	return prefix + hex.EncodeToString(sum[:]) + "/"
+}
+
+// Create an objectCreator that accepts a source object, if any, and the full
+// contents with which it should be overwritten as a TempFile, which tracks the
+// ranges modified since it was created from the source object.
+//
+// The contents are written out as blocks of blockSize bytes, each a block
+// object with a name beginning with blockPrefix, which are composed into the
+// destination object. The block objects are kept afterwards, along with a
+// manifest recording them, so that the next time the object is written out
+// only the blocks containing modified ranges need be uploaded: the others are
+// composed from the block objects of the source generation. The blocks are
+// uploaded up to parallelism at a time, and when there are too many to compose
+// in one go they are first composed into temporary objects with names
+// beginning with tmpPrefix.
+//
+// Block objects that are no longer needed are deleted afterwards, but they
+// may be left behind if we are interrupted for some reason. They, and those of
+// deleted objects, are deleted by collectBlockSets.
+//
+// Create guarantees to return *gcs.PreconditionError when the source object
+// has been clobbered, or when there is no source object and the destination
+// object has been created since.
+func newBlockObjectCreator(
+	blockPrefix string,
+	tmpPrefix string,
+	blockSize int64,
+	parallelism int,
+	bucket gcs.Bucket) (oc objectCreator) {
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	oc = &blockObjectCreator{
+		blockPrefix: blockPrefix,
+		composer: compositeObjectCreator{
+			namer:       appendObjectCreator{prefix: tmpPrefix},
+			parallelism: parallelism,
+			bucket:      bucket,
+		},
+		blockSize:   blockSize,
+		parallelism: parallelism,
+		bucket:      bucket,
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Implementation
+////////////////////////////////////////////////////////////////////////
+
+type blockObjectCreator struct {
+	blockPrefix string
+
+	// Used for composing the blocks, via temporary objects if need be.
+	composer compositeObjectCreator
+
+	blockSize   int64
+	parallelism int
+	bucket      gcs.Bucket
+}
+
+// A block of the contents, and the block object it is composed from.
+type block struct {
+	offset int64
+	length int64
+	source gcs.ComposeSource
+
+	// Whether the block is composed from a block object of the source
+	// generation.
+	reused bool
+}
+
+func (oc *blockObjectCreator) Create(
+	ctx context.Context,
+	objectName string,
+	srcObject *gcs.Object,
+	mtime *time.Time,
+	r io.Reader) (o *gcs.Object, err error) {
+	content, ok := r.(TempFile)
+	if !ok {
+		err = fmt.Errorf("unexpected reader type %T", r)
+		return
+	}
+
+	sr, err := content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+
+	dir := blockSetDir(oc.blockPrefix, objectName)
+	old, oldGeneration, err := readBlockSet(ctx, oc.bucket, dir)
+	if err != nil {
+		err = fmt.Errorf("readBlockSet: %w", err)
+		return
+	}
+
+	blocks, blockSize := oc.planBlocks(srcObject, old, sr.Size, content.DirtyRanges())
+	o, uploaded, err := oc.writeBlocks(ctx, objectName, srcObject, mtime, dir, content, blocks)
+
+	// Not finding a source may mean that a block object of the source
+	// generation has been deleted behind our back, in which case we can still
+	// upload all of the blocks.
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) && anyReused(blocks) {
+		logger.Warnf("Blocks of %q are missing, uploading all of them: %v", objectName, err)
+		blocks, blockSize = oc.planBlocks(srcObject, nil, sr.Size, nil)
+		o, uploaded, err = oc.writeBlocks(ctx, objectName, srcObject, mtime, dir, content, blocks)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// The object has been written out, so from here on failing to keep track
+	// of its blocks only costs us uploading all of them again next time.
+	set := &blockSet{
+		ObjectName:       o.Name,
+		ObjectGeneration: o.Generation,
+		BlockSize:        blockSize,
+		Blocks:           make([]gcs.ComposeSource, len(blocks)),
+	}
+	for i, b := range blocks {
+		set.Blocks[i] = b.source
+	}
+
+	var unused []string
+	if writeErr := oc.writeSet(ctx, dir, set, oldGeneration); writeErr != nil {
+		logger.Warnf("Failed to record the blocks of %q: %v", objectName, writeErr)
+		unused = uploaded
+	} else if old != nil {
+		inUse := make(map[string]bool)
+		for _, s := range set.Blocks {
+			inUse[s.Name] = true
+		}
+		for _, s := range old.Blocks {
+			if !inUse[s.Name] {
+				unused = append(unused, s.Name)
+			}
+		}
+	}
+
+	if deleteErr := oc.composer.deleteAll(ctx, unused); deleteErr != nil {
+		logger.Warnf("Failed to delete unused blocks of %q: %v", objectName, deleteErr)
+	}
+
+	return
+}
+
+func anyReused(blocks []*block) bool {
+	for _, b := range blocks {
+		if b.reused {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Upload the blocks that aren't reused to the supplied directory and compose
+// all of the blocks into the destination object, returning the names of the
+// block objects uploaded. Temporary objects are deleted whether or not we
+// succeed, and the block objects uploaded are deleted if we fail.
+func (oc *blockObjectCreator) writeBlocks(
+	ctx context.Context,
+	objectName string,
+	srcObject *gcs.Object,
+	mtime *time.Time,
+	dir string,
+	content io.ReaderAt,
+	blocks []*block) (o *gcs.Object, uploaded []string, err error) {
+	var tmpObjects []string
+	var tmpObjectsMu sync.Mutex
+	created := func(name string) {
+		tmpObjectsMu.Lock()
+		tmpObjects = append(tmpObjects, name)
+		tmpObjectsMu.Unlock()
+	}
+	defer func() {
+		toDelete := tmpObjects
+		if err != nil {
+			toDelete = append(toDelete, uploaded...)
+		}
+
+		deleteErr := oc.composer.deleteAll(ctx, toDelete)
+		if err == nil && deleteErr != nil {
+			err = fmt.Errorf("deleteAll: %w", deleteErr)
+		}
+	}()
+
+	uploaded, err = oc.uploadBlocks(ctx, dir, content, blocks)
+	if err != nil {
+		err = fmt.Errorf("uploadBlocks: %w", err)
+		return
+	}
+
+	// Compose the blocks into intermediate objects until there are few enough
+	// left to compose into the destination object in one go.
+	sources := make([]gcs.ComposeSource, len(blocks))
+	for i, b := range blocks {
+		sources[i] = b.source
+	}
+
+	for len(sources) > gcs.MaxSourcesPerComposeRequest {
+		sources, err = oc.composer.composeIntermediate(ctx, sources, created)
+		if err != nil {
+			err = fmt.Errorf("composeIntermediate: %w", err)
+			return
+		}
+	}
+
+	o, err = oc.composer.composeFinal(ctx, objectName, srcObject, mtime, sources)
+	if err != nil {
+		err = fmt.Errorf("ComposeObjects: %w", err)
+		return
+	}
+
+	return
+}
+
+// Read the block set in the supplied directory, returning a nil set if there
+// is none.
+func readBlockSet(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	dir string) (s *blockSet, generation int64, err error) {
+	name := dir + blockSetManifestName
+	m, _, err := bucket.StatObject(ctx, &gcs.StatObjectRequest{
+		Name:              name,
+		ForceFetchFromGcs: true,
+	})
+
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("StatObject(%q): %w", name, err)
+		return
+	}
+	generation = m.Generation
+
+	rc, err := bucket.NewReader(ctx, &gcs.ReadObjectRequest{
+		Name:       name,
+		Generation: generation,
+	})
+	if err != nil {
+		err = fmt.Errorf("NewReader(%q): %w", name, err)
+		return
+	}
+	contents, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		err = fmt.Errorf("ReadAll(%q): %w", name, err)
+		return
+	}
+
+	s = &blockSet{}
+	if err = json.Unmarshal(contents, s); err != nil {
+		err = fmt.Errorf("json.Unmarshal(%q): %w", name, err)
+		return
+	}
+
+	return
+}
+
+// Write the block set to the supplied directory, failing if the manifest
+// there is no longer at the supplied generation, zero meaning that there was
+// none.
+func (oc *blockObjectCreator) writeSet(
+	ctx context.Context,
+	dir string,
+	s *blockSet,
+	generation int64) (err error) {
+	contents, err := json.Marshal(s)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %w", err)
+		return
+	}
+
+	name := dir + blockSetManifestName
+	_, err = oc.bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:                   name,
+		Contents:               bytes.NewReader(contents),
+		GenerationPrecondition: &generation,
+	})
+	if err != nil {
+		err = fmt.Errorf("CreateObject(%q): %w", name, err)
+		return
+	}
+
+	return
+}
+
+// Divide content of the supplied size into blocks, reusing the block objects
+// of the source generation recorded by old, if any, for the blocks that are
+// the same length as before and don't overlap the dirty ranges.
+func (oc *blockObjectCreator) planBlocks(
+	srcObject *gcs.Object,
+	old *blockSet,
+	size int64,
+	dirtyRanges []ByteRange) (blocks []*block, blockSize int64) {
+	if old != nil && !old.matches(srcObject) {
+		old = nil
+	}
+
+	// Keep to the block size of the source generation if we can, so that its
+	// blocks line up with ours. Otherwise use larger blocks if need be to stay
+	// within the component count limit of the composed object.
+	blockSize = max(oc.blockSize, (size+gcs.MaxComponentCount-1)/gcs.MaxComponentCount)
+	if old != nil {
+		if (size+old.BlockSize-1)/old.BlockSize <= gcs.MaxComponentCount {
+			blockSize = old.BlockSize
+		} else {
+			old = nil
+		}
+	}
+
+	// An empty file is written out as a single empty block.
+	for offset := int64(0); offset < size || len(blocks) == 0; offset += blockSize {
+		blocks = append(blocks, &block{
+			offset: offset,
+			length: min(blockSize, size-offset),
+		})
+	}
+
+	if old == nil {
+		return
+	}
+
+	srcSize := int64(srcObject.Size)
+	for i, b := range blocks {
+		if i >= len(old.Blocks) || b.length != min(blockSize, srcSize-b.offset) {
+			break
+		}
+
+		// Skip the dirty ranges that end before the block.
+		for len(dirtyRanges) > 0 && dirtyRanges[0].Limit <= b.offset {
+			dirtyRanges = dirtyRanges[1:]
+		}
+		if len(dirtyRanges) > 0 && dirtyRanges[0].Start < b.offset+b.length {
+			continue
+		}
+
+		b.source = old.Blocks[i]
+		b.reused = true
+	}
+
+	return
+}
+
+// Upload the blocks that aren't reused as block objects in the supplied
+// directory, returning the names of those created.
+func (oc *blockObjectCreator) uploadBlocks(
+	ctx context.Context,
+	dir string,
+	content io.ReaderAt,
+	blocks []*block) (uploaded []string, err error) {
+	toUpload := make(chan *block, len(blocks))
+	for _, b := range blocks {
+		if !b.reused {
+			toUpload <- b
+		}
+	}
+	close(toUpload)
+
+	var mu sync.Mutex
+	namer := appendObjectCreator{prefix: dir}
+
+	bundle := syncutil.NewBundle(ctx)
+	for i := 0; i < oc.parallelism && i < len(blocks); i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for b := range toUpload {
+				var name string
+				name, err = namer.chooseName()
+				if err != nil {
+					err = fmt.Errorf("chooseName: %w", err)
+					return
+				}
+
+				var zero int64
+				var o *gcs.Object
+				o, err = oc.bucket.CreateObject(
+					ctx,
+					&gcs.CreateObjectRequest{
+						Name:                   name,
+						GenerationPrecondition: &zero,
+						Contents:               io.NewSectionReader(content, b.offset, b.length),
+					})
+				if err != nil {
+					err = fmt.Errorf("CreateObject: %w", err)
+					return
+				}
+
+				mu.Lock()
+				uploaded = append(uploaded, o.Name)
+				mu.Unlock()
+
+				b.source = gcs.ComposeSource{
+					Name:       o.Name,
+					Generation: o.Generation,
+				}
+			}
+			return
+		})
+	}
+
+	err = bundle.Join()
+	return
+}
+
+// Delete the block sets with names beginning with prefix that no longer
+// record how the current generation of their object was composed, because it
+// has been deleted, renamed or overwritten by other means for at least
+// stalenessThreshold as of now, along with the block objects left behind by
+// interrupted partial updates: those not recorded by the manifest in their
+// directory that haven't been updated for at least stalenessThreshold.
+func collectBlockSets(
+	ctx context.Context,
+	prefix string,
+	now time.Time,
+	stalenessThreshold time.Duration,
+	bucket gcs.Bucket) (objectsDeleted uint64, err error) {
+	// List the objects in each block set directory.
+	var dirs []string
+	objects := make(map[string][]*gcs.Object)
+	listed := make(chan *gcs.Object, 100)
+	b := syncutil.NewBundle(ctx)
+	b.Add(func(ctx context.Context) error {
+		defer close(listed)
+		return storageutil.ListPrefix(ctx, bucket, prefix, listed)
+	})
+	b.Add(func(ctx context.Context) error {
+		for o := range listed {
+			dir, _, ok := strings.Cut(strings.TrimPrefix(o.Name, prefix), "/")
+			if !ok {
+				continue
+			}
+			dir = prefix + dir + "/"
+			if _, ok := objects[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			objects[dir] = append(objects[dir], o)
+		}
+		return nil
+	})
+	if err = b.Join(); err != nil {
+		err = fmt.Errorf("ListPrefix: %w", err)
+		return
+	}
+
+	// A block set we can't make sense of, e.g. because its manifest is
+	// corrupt, mustn't stop the others being collected.
+	for _, dir := range dirs {
+		stale, staleErr := staleBlockSetObjects(ctx, dir, objects[dir], now, stalenessThreshold, bucket)
+		if staleErr != nil {
+			logger.Warnf("Not collecting the block set %q: %v", dir, staleErr)
+			continue
+		}
+
+		for _, o := range stale {
+			// Delete the generation we looked at, so as not to delete a manifest
+			// or block written since.
+			err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{
+				Name:       o.Name,
+				Generation: o.Generation,
+			})
+
+			var notFoundErr *gcs.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				err = nil
+				continue
+			}
+			if err != nil {
+				err = fmt.Errorf("DeleteObject(%q): %w", o.Name, err)
+				return
+			}
+
+			objectsDeleted++
+		}
+	}
+
+	return
+}
+
+// Choose the objects to be deleted from those listed in the supplied block set
+// directory. See collectBlockSets.
+func staleBlockSetObjects(
+	ctx context.Context,
+	dir string,
+	objects []*gcs.Object,
+	now time.Time,
+	stalenessThreshold time.Duration,
+	bucket gcs.Bucket) (stale []*gcs.Object, err error) {
+	s, generation, err := readBlockSet(ctx, bucket, dir)
+	if err != nil {
+		err = fmt.Errorf("readBlockSet: %w", err)
+		return
+	}
+
+	// Is the block set that of the current generation of its object? An
+	// object that has just been written out may not have had its manifest
+	// updated yet, so give it time.
+	inUse := make(map[string]bool)
+	if s != nil {
+		var o *gcs.MinObject
+		o, _, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{
+			Name:              s.ObjectName,
+			ForceFetchFromGcs: true,
+		})
+
+		var notFoundErr *gcs.NotFoundError
+		switch {
+		case errors.As(err, &notFoundErr):
+			err = nil
+
+		case err != nil:
+			err = fmt.Errorf("StatObject(%q): %w", s.ObjectName, err)
+			return
+
+		case o.Generation == s.ObjectGeneration || now.Sub(o.Updated) < stalenessThreshold:
+			inUse[dir+blockSetManifestName] = true
+			for _, b := range s.Blocks {
+				inUse[b.Name] = true
+			}
+		}
+	}
+
+	for _, o := range objects {
+		switch {
+		case inUse[o.Name]:
+		case o.Name == dir+blockSetManifestName:
+			if o.Generation == generation {
+				stale = append(stale, o)
+			}
+		case s != nil && !inUse[dir+blockSetManifestName] && containsBlock(s, o):
+			stale = append(stale, o)
+		case now.Sub(o.Updated) >= stalenessThreshold:
+			stale = append(stale, o)
+		}
+	}
+
+	return
+}
+
+// Whether the block set records the supplied generation of a block object.
+func containsBlock(s *blockSet, o *gcs.Object) bool {
+	for _, b := range s.Blocks {
+		if b.Name == o.Name && b.Generation == o.Generation {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,386 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestBlockObjectCreator(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that counts the objects created in it.
+type countingCreateBucket struct {
+	gcs.Bucket
+
+	mu      sync.Mutex
+	created int
+}
+
+func (b *countingCreateBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (*gcs.Object, error) {
+	b.mu.Lock()
+	b.created++
+	b.mu.Unlock()
+
+	return b.Bucket.CreateObject(ctx, req)
+}
+
+type BlockObjectCreatorTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket *countingCreateBucket
+	mtime  time.Time
+}
+
+var _ SetUpInterface = &BlockObjectCreatorTest{}
+
+func init() { RegisterTestSuite(&BlockObjectCreatorTest{}) }
+
+func (t *BlockObjectCreatorTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.bucket = &countingCreateBucket{Bucket: fake.NewFakeBucket(&t.clock, "some_bucket")}
+	t.mtime = time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Write out the contents of the supplied source object, if any, as modified
+// by the supplied function, in blocks of blockSize bytes, returning the number
+// of objects created in doing so.
+func (t *BlockObjectCreatorTest) call(
+	blockSize int64,
+	objectName string,
+	srcObject *gcs.Object,
+	modify func(TempFile)) (o *gcs.Object, created int, err error) {
+	var initialContents string
+	if srcObject != nil {
+		initialContents = t.read(srcObject.Name)
+	}
+
+	tf, err := NewTempFile(io.NopCloser(strings.NewReader(initialContents)), "", &t.clock)
+	AssertEq(nil, err)
+	defer tf.Destroy()
+	modify(tf)
+
+	t.bucket.created = 0
+	creator := newBlockObjectCreator(BlockSetPrefix, prefix, blockSize, 4, t.bucket)
+	o, err = creator.Create(t.ctx, objectName, srcObject, &t.mtime, tf)
+	created = t.bucket.created
+	return
+}
+
+// Write out a new object with the supplied contents in blocks of blockSize
+// bytes.
+func (t *BlockObjectCreatorTest) create(
+	blockSize int64,
+	objectName string,
+	contents string) *gcs.Object {
+	o, _, err := t.call(blockSize, objectName, nil, func(tf TempFile) {
+		_, err := tf.WriteAt([]byte(contents), 0)
+		AssertEq(nil, err)
+	})
+	AssertEq(nil, err)
+	return o
+}
+
+func writeAt(data string, offset int64) func(TempFile) {
+	return func(tf TempFile) {
+		_, err := tf.WriteAt([]byte(data), offset)
+		AssertEq(nil, err)
+	}
+}
+
+func (t *BlockObjectCreatorTest) list(prefix string) (names []string) {
+	objects, _, err := storageutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+	AssertEq(nil, err)
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	return
+}
+
+// The names of the block objects of the supplied object, excluding the
+// manifest.
+func (t *BlockObjectCreatorTest) blocks(objectName string) (names []string) {
+	dir := blockSetDir(BlockSetPrefix, objectName)
+	for _, name := range t.list(dir) {
+		if name != dir+blockSetManifestName {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+func (t *BlockObjectCreatorTest) readSet(objectName string) *blockSet {
+	s, _, err := readBlockSet(t.ctx, t.bucket, blockSetDir(BlockSetPrefix, objectName))
+	AssertEq(nil, err)
+	return s
+}
+
+func (t *BlockObjectCreatorTest) read(name string) string {
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BlockObjectCreatorTest) NewObject() {
+	o, created, err := t.call(3, "foo", nil, writeAt("burrito", 0))
+
+	AssertEq(nil, err)
+	ExpectEq(len("burrito"), o.Size)
+	ExpectEq(3, o.ComponentCount)
+	ExpectEq(t.mtime.Format(time.RFC3339Nano), o.Metadata[MtimeMetadataKey])
+	ExpectEq("burrito", t.read("foo"))
+	ExpectEq(4, created)
+	ExpectEq(3, len(t.blocks("foo")))
+	ExpectThat(t.list(prefix), ElementsAre())
+
+	s := t.readSet("foo")
+	AssertNe(nil, s)
+	ExpectEq("foo", s.ObjectName)
+	ExpectEq(o.Generation, s.ObjectGeneration)
+	ExpectEq(3, s.BlockSize)
+	ExpectEq(3, len(s.Blocks))
+}
+
+func (t *BlockObjectCreatorTest) UploadsOnlyDirtyBlocks() {
+	src := t.create(3, "foo", "burritotaco")
+	oldBlocks := t.blocks("foo")
+
+	o, created, err := t.call(3, "foo", src, writeAt("T", 7))
+
+	AssertEq(nil, err)
+	ExpectLt(src.Generation, o.Generation)
+	ExpectEq("burritoTaco", t.read("foo"))
+	ExpectEq(2, created) // One block and the manifest
+	ExpectEq(o.Generation, t.readSet("foo").ObjectGeneration)
+
+	// Only the block that was replaced has been deleted.
+	newBlocks := t.blocks("foo")
+	ExpectEq(4, len(newBlocks))
+	var kept int
+	for _, name := range oldBlocks {
+		for _, newName := range newBlocks {
+			if name == newName {
+				kept++
+			}
+		}
+	}
+	ExpectEq(3, kept)
+}
+
+func (t *BlockObjectCreatorTest) RepeatedPartialUpdates() {
+	src := t.create(2, "foo", "tacoburrito")
+
+	src, _, err := t.call(2, "foo", src, writeAt("T", 0))
+	AssertEq(nil, err)
+	src, _, err = t.call(2, "foo", src, writeAt("B", 4))
+	AssertEq(nil, err)
+	o, created, err := t.call(2, "foo", src, writeAt("O", 10))
+
+	AssertEq(nil, err)
+	ExpectEq(2, created)
+	ExpectEq("TacoBurritO", t.read("foo"))
+	ExpectEq(6, len(t.blocks("foo")))
+	ExpectEq(o.Generation, t.readSet("foo").ObjectGeneration)
+}
+
+func (t *BlockObjectCreatorTest) Append() {
+	src := t.create(3, "foo", "burrito")
+
+	_, created, err := t.call(3, "foo", src, writeAt("taco", 7))
+
+	AssertEq(nil, err)
+	ExpectEq("burritotaco", t.read("foo"))
+	ExpectEq(3, created) // Two blocks and the manifest
+	ExpectEq(4, len(t.blocks("foo")))
+}
+
+func (t *BlockObjectCreatorTest) Truncate() {
+	src := t.create(3, "foo", "burritotaco")
+
+	_, created, err := t.call(3, "foo", src, func(tf TempFile) {
+		AssertEq(nil, tf.Truncate(5))
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("burri", t.read("foo"))
+	ExpectEq(2, created) // The shortened last block and the manifest
+	ExpectEq(2, len(t.blocks("foo")))
+}
+
+func (t *BlockObjectCreatorTest) TruncateAndExtend() {
+	src := t.create(3, "foo", "burritotaco")
+
+	_, _, err := t.call(3, "foo", src, func(tf TempFile) {
+		AssertEq(nil, tf.Truncate(1))
+		AssertEq(nil, tf.Truncate(11))
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("b"+strings.Repeat("\x00", 10), t.read("foo"))
+}
+
+func (t *BlockObjectCreatorTest) ObjectOverwrittenSince() {
+	t.create(3, "foo", "burritotaco")
+	src, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("enchilada"))
+	AssertEq(nil, err)
+
+	o, created, err := t.call(3, "foo", src, writeAt("E", 0))
+
+	AssertEq(nil, err)
+	ExpectEq("Enchilada", t.read("foo"))
+	ExpectEq(4, created)
+	ExpectEq(3, len(t.blocks("foo")))
+	ExpectEq(o.Generation, t.readSet("foo").ObjectGeneration)
+}
+
+func (t *BlockObjectCreatorTest) BlocksDeletedSince() {
+	src := t.create(3, "foo", "burritotaco")
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: t.readSet("foo").Blocks[0].Name})
+	AssertEq(nil, err)
+
+	_, created, err := t.call(3, "foo", src, writeAt("T", 7))
+
+	AssertEq(nil, err)
+	ExpectEq("burritoTaco", t.read("foo"))
+	ExpectEq(6, created) // One block, then all four, and the manifest
+	ExpectEq(4, len(t.blocks("foo")))
+	ExpectThat(t.list(prefix), ElementsAre())
+}
+
+func (t *BlockObjectCreatorTest) SourceObjectClobbered() {
+	src := t.create(3, "foo", "burritotaco")
+	oldBlocks := t.blocks("foo")
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("queso"))
+	AssertEq(nil, err)
+
+	_, _, err = t.call(3, "foo", src, writeAt("T", 7))
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+	ExpectEq("queso", t.read("foo"))
+	ExpectThat(t.blocks("foo"), ElementsAre(oldBlocks[0], oldBlocks[1], oldBlocks[2], oldBlocks[3]))
+	ExpectThat(t.list(prefix), ElementsAre())
+}
+
+func (t *BlockObjectCreatorTest) TreeComposesManyBlocks() {
+	contents := strings.Repeat("0123456789", 10)
+	src := t.create(1, "foo", contents)
+	ExpectEq(100, src.ComponentCount)
+
+	o, created, err := t.call(1, "foo", src, writeAt("x", 50))
+
+	AssertEq(nil, err)
+	ExpectEq(100, o.ComponentCount)
+	ExpectEq(contents[:50]+"x"+contents[51:], t.read("foo"))
+	ExpectEq(2, created)
+	ExpectThat(t.list(prefix), ElementsAre())
+}
+
+func (t *BlockObjectCreatorTest) EmptyContents() {
+	o, _, err := t.call(3, "foo", nil, func(TempFile) {})
+
+	AssertEq(nil, err)
+	ExpectEq(0, o.Size)
+	ExpectEq("", t.read("foo"))
+	ExpectEq(1, len(t.blocks("foo")))
+}
+
+func (t *BlockObjectCreatorTest) collect() (objectsDeleted uint64) {
+	objectsDeleted, err := collectBlockSets(t.ctx, BlockSetPrefix, t.clock.Now(), time.Hour, t.bucket)
+	AssertEq(nil, err)
+	return
+}
+
+func (t *BlockObjectCreatorTest) CollectBlockSets_CurrentObjects() {
+	t.create(3, "foo", "burritotaco")
+	t.create(3, "bar", "enchilada")
+	t.clock.AdvanceTime(2 * time.Hour)
+
+	ExpectEq(0, t.collect())
+	ExpectEq(4, len(t.blocks("foo")))
+	ExpectEq(3, len(t.blocks("bar")))
+}
+
+func (t *BlockObjectCreatorTest) CollectBlockSets_DeletedObject() {
+	t.create(3, "foo", "burritotaco")
+	t.create(3, "bar", "enchilada")
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	// The blocks and the manifest.
+	ExpectEq(5, t.collect())
+	ExpectThat(t.list(blockSetDir(BlockSetPrefix, "foo")), ElementsAre())
+	ExpectEq(3, len(t.blocks("bar")))
+}
+
+func (t *BlockObjectCreatorTest) CollectBlockSets_OverwrittenObject() {
+	t.create(3, "foo", "burritotaco")
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("enchilada"))
+	AssertEq(nil, err)
+
+	// The object may have just been written out by a partial update whose
+	// manifest hasn't been written yet.
+	ExpectEq(0, t.collect())
+	ExpectEq(4, len(t.blocks("foo")))
+
+	t.clock.AdvanceTime(time.Hour)
+	ExpectEq(5, t.collect())
+	ExpectThat(t.list(blockSetDir(BlockSetPrefix, "foo")), ElementsAre())
+}
+
+func (t *BlockObjectCreatorTest) CollectBlockSets_LeftoverBlocks() {
+	t.create(3, "foo", "burritotaco")
+	dir := blockSetDir(BlockSetPrefix, "foo")
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, dir+"leftover", []byte("tac"))
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, BlockSetPrefix+"abc/leftover", []byte("tac"))
+	AssertEq(nil, err)
+
+	// They may belong to a partial update in progress.
+	ExpectEq(0, t.collect())
+	ExpectEq(5, len(t.blocks("foo")))
+
+	t.clock.AdvanceTime(time.Hour)
+	ExpectEq(2, t.collect())
+	ExpectEq(4, len(t.blocks("foo")))
+	ExpectThat(t.list(BlockSetPrefix+"abc/"), ElementsAre())
+	ExpectEq(4, len(t.readSet("foo").Blocks))
+}
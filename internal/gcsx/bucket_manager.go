@@ -15,20 +15,30 @@
 package gcsx
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/metadata"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/canned"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/monitor"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/ratelimit"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/caching"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/localdir"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/replay"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
 	"github.com/jacobsa/timeutil"
 )
@@ -40,9 +50,32 @@ type BucketConfig struct {
 	OpRateLimitHz                      float64
 	StatCacheMaxSizeMB                 uint64
 	StatCacheTTL                       time.Duration
+	StatCacheEvictionPolicy            string
 	EnableMonitoring                   bool
 	DebugGCS                           bool
 
+	// If non-zero, expose a read-only view of buckets as they were at this
+	// time. See NewSnapshotBucket.
+	SnapshotTime time.Time
+
+	// If set, buckets are mounted read-only, so nothing is written to them when
+	// they're set up, e.g. to finish interrupted directory renames or to upload
+	// the recovered dirty contents of files.
+	ReadOnly bool
+
+	// If set, expose buckets as the writable upper layer over this read-only
+	// lower bucket, optionally followed by a slash and a prefix within it. See
+	// NewOverlayBucket.
+	OverlayLower string
+
+	// Faults to inject into requests to buckets, for testing. See
+	// storage.NewFaultInjectionBucket.
+	FaultInjection config.FaultInjectionConfig
+
+	// If set, record the requests to buckets and their responses to a trace
+	// file at this path. See replay.NewRecordingBucket.
+	GCSTracePath string
+
 	// Files backed by on object of length at least AppendThreshold that have
 	// only been appended to (i.e. none of the object's contents have been
 	// dirtied) will be written out by "appending" to the object in GCS with this
@@ -61,6 +94,27 @@ type BucketConfig struct {
 	// periodically garbage collected.
 	AppendThreshold int64
 	TmpObjectPrefix string
+
+	// Parallel composite uploads of the full contents of large files, also
+	// using temporary objects under TmpObjectPrefix. A zero threshold disables
+	// them.
+	ParallelUploads ParallelUploadConfig
+
+	// Partial updates of large files, whose blocks are kept in objects under
+	// BlockSetPrefix. A zero threshold disables them.
+	PartialUpdates PartialUpdateConfig
+
+	// The maximum number of objects moved in parallel by directory renames,
+	// including when rolling forward renames interrupted by a crash when a
+	// bucket is set up. See DirRenamer.
+	RenameDirParallelism int
+
+	// The DirtyJournal in which the file system keeps the contents of files,
+	// or nil if they aren't journaled. Dirty contents left behind by a crash are
+	// recovered when a bucket is set up, by writing them out if UploadDirtyData
+	// is set, or else by moving them aside.
+	DirtyJournal    *DirtyJournal
+	UploadDirtyData bool
 }
 
 // BucketManager manages the lifecycle of buckets.
@@ -81,12 +135,21 @@ type bucketManager struct {
 	// Garbage collector
 	gcCtx                 context.Context
 	stopGarbageCollecting func()
+
+	// The buckets to be closed on shutting down, e.g. to release the
+	// directories of local directory buckets.
+	mu      sync.Mutex
+	closers []io.Closer // GUARDED_BY(mu)
 }
 
 func NewBucketManager(config BucketConfig, storageHandle storage.StorageHandle) BucketManager {
 	var c *lru.Cache
 	if config.StatCacheMaxSizeMB > 0 {
-		c = lru.NewCache(util.MiBsToBytes(config.StatCacheMaxSizeMB))
+		policy, err := lru.NewEvictionPolicy(config.StatCacheEvictionPolicy, config.StatCacheTTL, timeutil.RealClock())
+		if err != nil {
+			panic(fmt.Sprintf("Stat cache: %v", err))
+		}
+		c = lru.NewCacheWithPolicy(util.MiBsToBytes(config.StatCacheMaxSizeMB), policy)
 	}
 
 	bm := &bucketManager{
@@ -152,6 +215,52 @@ func setUpRateLimiting(
 	return
 }
 
+// Return a bucket serving the trace at the given path, recorded by a mount
+// using the given prefix for temporary objects.
+func newReplayingBucket(path string, tmpObjectPrefix string) (b gcs.Bucket, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	b, err = replay.NewReplayingBucket(bufio.NewReader(f), tmpObjectPrefix)
+	return
+}
+
+// Wrap the given bucket in one recording its traffic to a trace at the given
+// path. The file remains open for the lifetime of the process.
+func newRecordingBucket(path string, wrapped gcs.Bucket) (b gcs.Bucket, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+
+	b, err = replay.NewRecordingBucket(f, wrapped)
+	if err != nil {
+		f.Close()
+	}
+	return
+}
+
+// Return the lower layer of overlay mounts, given as a bucket name optionally
+// followed by a slash and a prefix within the bucket.
+func (bm *bucketManager) setUpOverlayLower() (b gcs.Bucket, err error) {
+	name, prefix, _ := strings.Cut(bm.config.OverlayLower, "/")
+	b = bm.storageHandle.BucketHandle(name, bm.config.BillingProject)
+
+	if bm.config.EnableMonitoring {
+		b = monitor.NewMonitoringBucket(b)
+	}
+
+	b = storage.NewDebugBucket(b)
+
+	if prefix != "" {
+		b, err = NewPrefixBucket(path.Clean(prefix)+"/", b)
+	}
+	return
+}
+
 func (bm *bucketManager) SetUpBucket(
 	ctx context.Context,
 	name string,
@@ -161,10 +270,58 @@ func (bm *bucketManager) SetUpBucket(
 	// Set up the appropriate backing bucket.
 	if name == canned.FakeBucketName {
 		b = canned.MakeFakeBucket(ctx)
+	} else if dir, ok := localdir.ParseBucketURL(name); ok {
+		b, err = localdir.NewBucket(timeutil.RealClock(), filepath.Base(dir), dir)
+		if err != nil {
+			err = fmt.Errorf("localdir.NewBucket: %w", err)
+			return
+		}
+
+		closer := b.(io.Closer)
+		defer func() {
+			if err != nil {
+				closer.Close()
+				return
+			}
+
+			bm.mu.Lock()
+			bm.closers = append(bm.closers, closer)
+			bm.mu.Unlock()
+		}()
+	} else if trace, ok := replay.ParseBucketURL(name); ok {
+		b, err = newReplayingBucket(trace, bm.config.TmpObjectPrefix)
+		if err != nil {
+			err = fmt.Errorf("newReplayingBucket: %w", err)
+			return
+		}
 	} else {
 		b = bm.storageHandle.BucketHandle(name, bm.config.BillingProject)
 	}
 
+	// Inject faults, if requested, as though they came from GCS.
+	if len(bm.config.FaultInjection.Rules) > 0 {
+		b, err = storage.NewFaultInjectionBucket(bm.config.FaultInjection, b)
+		if err != nil {
+			err = fmt.Errorf("NewFaultInjectionBucket: %w", err)
+			return
+		}
+	}
+
+	// Record the traffic to the bucket, if requested, including any faults
+	// injected above. Each bucket of a dynamic mount gets its own trace.
+	if bm.config.GCSTracePath != "" {
+		tracePath := bm.config.GCSTracePath
+		if isMultibucketMount {
+			tracePath += "." + name
+		}
+
+		b, err = newRecordingBucket(tracePath, b)
+		if err != nil {
+			err = fmt.Errorf("newRecordingBucket: %w", err)
+			return
+		}
+	}
+
 	// Enable monitoring.
 	if bm.config.EnableMonitoring {
 		b = monitor.NewMonitoringBucket(b)
@@ -174,14 +331,37 @@ func (bm *bucketManager) SetUpBucket(
 	b = storage.NewDebugBucket(b)
 
 	// Limit to a requested prefix of the bucket, if any.
+	var objectPrefix string
 	if bm.config.OnlyDir != "" {
-		b, err = NewPrefixBucket(path.Clean(bm.config.OnlyDir)+"/", b)
+		objectPrefix = path.Clean(bm.config.OnlyDir) + "/"
+		b, err = NewPrefixBucket(objectPrefix, b)
 		if err != nil {
 			err = fmt.Errorf("NewPrefixBucket: %w", err)
 			return
 		}
 	}
 
+	// Overlay the bucket on a lower one, if requested.
+	if bm.config.OverlayLower != "" {
+		if isMultibucketMount {
+			err = errors.New("overlay-lower isn't supported for dynamic mounts")
+			return
+		}
+
+		var lower gcs.Bucket
+		lower, err = bm.setUpOverlayLower()
+		if err != nil {
+			err = fmt.Errorf("setUpOverlayLower: %w", err)
+			return
+		}
+		b = NewOverlayBucket(b, lower)
+	}
+
+	// Freeze the view of the bucket at a point in time, if requested.
+	if !bm.config.SnapshotTime.IsZero() {
+		b = NewSnapshotBucket(bm.config.SnapshotTime, timeutil.RealClock(), b)
+	}
+
 	// Enable rate limiting, if requested.
 	b, err = setUpRateLimiting(
 		b,
@@ -217,10 +397,19 @@ func (bm *bucketManager) SetUpBucket(
 		err = errors.New("You must set TmpObjectPrefix.")
 		return
 	}
-	sb = NewSyncerBucket(
-		bm.config.AppendThreshold,
-		bm.config.TmpObjectPrefix,
-		b)
+	sb = SyncerBucket{
+		Bucket: b,
+		Syncer: NewSyncer(
+			bm.config.AppendThreshold,
+			bm.config.TmpObjectPrefix,
+			SyncerOptions{
+				ParallelUploads: bm.config.ParallelUploads,
+				PartialUpdates:  bm.config.PartialUpdates,
+			},
+			b),
+		ObjectPrefix:    objectPrefix,
+		TmpObjectPrefix: bm.config.TmpObjectPrefix,
+	}
 
 	// Fetch bucket type from storage layout api and set bucket type.
 	b.BucketType()
@@ -234,12 +423,60 @@ func (bm *bucketManager) SetUpBucket(
 		}
 	}
 
-	// Periodically garbage collect temporary objects
-	go garbageCollect(bm.gcCtx, bm.config.TmpObjectPrefix, sb)
+	// Finish any directory renames that were interrupted, e.g. by a crash,
+	// before the directories involved are accessed. Failing to, e.g. for lack
+	// of permission to write to the bucket, isn't fatal, as an interrupted
+	// rename is also finished when its directory is next renamed.
+	if bm.config.SnapshotTime.IsZero() && !bm.config.ReadOnly && b.BucketType() != gcs.Hierarchical {
+		renamer := NewDirRenamer(sb, RenameJournalPrefix, bm.config.RenameDirParallelism)
+		n, rollErr := renamer.RollForwardAll(ctx)
+		if rollErr != nil {
+			logger.Warnf("Couldn't roll forward interrupted directory renames in bucket %q: %v", name, rollErr)
+		}
+		if n > 0 {
+			logger.Infof("Rolled forward %d interrupted directory renames in bucket %q.", n, name)
+		}
+	}
+
+	// Recover the dirty contents of files that were never synced because of a
+	// crash, before the objects involved are accessed. A read-only mount
+	// mustn't write to the bucket, so moves them aside rather than uploading
+	// them.
+	if bm.config.SnapshotTime.IsZero() && bm.config.DirtyJournal != nil {
+		var n int
+		n, err = bm.config.DirtyJournal.Recover(ctx, sb, bm.config.UploadDirtyData && !bm.config.ReadOnly)
+		if err != nil {
+			err = fmt.Errorf("Recover: %w", err)
+			return
+		}
+		if n > 0 {
+			logger.Infof("Recovered the dirty contents of %d files in bucket %q.", n, name)
+		}
+	}
+
+	// Periodically garbage collect temporary objects, unless the bucket is a
+	// read-only snapshot, and the block sets of objects no longer written out by
+	// partial updates if this mount writes them.
+	if bm.config.SnapshotTime.IsZero() {
+		var blockSetPrefix string
+		if bm.config.PartialUpdates.Threshold > 0 && !bm.config.ReadOnly {
+			blockSetPrefix = BlockSetPrefix
+		}
+		go garbageCollect(bm.gcCtx, bm.config.TmpObjectPrefix, blockSetPrefix, sb)
+	}
 
 	return
 }
 
 func (bm *bucketManager) ShutDown() {
 	bm.stopGarbageCollecting()
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for _, c := range bm.closers {
+		if err := c.Close(); err != nil {
+			logger.Warnf("Failed to close a bucket: %v", err)
+		}
+	}
+	bm.closers = nil
 }
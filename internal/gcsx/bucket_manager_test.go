@@ -2,12 +2,22 @@ package gcsx
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/localdir"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/replay"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
 	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
 )
 
 func TestBucketManager(t *testing.T) { RunTests(t) }
@@ -111,6 +121,248 @@ func (t *BucketManagerTest) TestSetUpBucketMethod_IsMultiBucketMountTrue() {
 	ExpectEq(nil, err)
 }
 
+func (t *BucketManagerTest) TestSetUpBucketMethod_LocalDir() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix"}
+	bm.gcCtx = ctx
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+
+	bucket, err := bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+
+	AssertEq(nil, err)
+	ExpectEq(filepath.Base(dir), bucket.Name())
+	_, err = storageutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	entries, err := os.ReadDir(dir)
+	AssertEq(nil, err)
+	// The object's contents and metadata, and the lock file.
+	ExpectEq(3, len(entries))
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_LocalDirInUse() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix"}
+	bm.gcCtx, bm.stopGarbageCollecting = context.WithCancel(ctx)
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+	_, err = bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+	AssertEq(nil, err)
+
+	// The directory can't be used by two buckets at once.
+	_, err = bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+	ExpectNe(nil, err)
+
+	// But can be once the first is shut down.
+	bm.ShutDown()
+	_, err = bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+	ExpectEq(nil, err)
+	bm.ShutDown()
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_RollsForwardRenamesUnlessReadOnly() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix", ReadOnly: true}
+	bm.gcCtx, bm.stopGarbageCollecting = context.WithCancel(ctx)
+	defer bm.ShutDown()
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+	bucket, err := bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+	AssertEq(nil, err)
+	// Journal a rename of src/ to dst/ which was interrupted before moving
+	// anything.
+	o, err := storageutil.CreateObject(ctx, bucket, "src/a", []byte("taco"))
+	AssertEq(nil, err)
+	contents, err := json.Marshal(&renameJournal{
+		SrcDir:  "src/",
+		DstDir:  "dst/",
+		Objects: []journaledObject{{Name: o.Name, Generation: o.Generation}},
+	})
+	AssertEq(nil, err)
+	journalName := NewDirRenamer(bucket, RenameJournalPrefix, 1).journalName("src/")
+	_, err = storageutil.CreateObject(ctx, bucket, journalName, contents)
+	AssertEq(nil, err)
+
+	// Setting the bucket up read-only leaves the rename alone.
+	bm.ShutDown()
+	bucket, err = bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+	AssertEq(nil, err)
+	_, err = storageutil.ReadObject(ctx, bucket, journalName)
+	ExpectEq(nil, err)
+	_, err = storageutil.ReadObject(ctx, bucket, "src/a")
+	ExpectEq(nil, err)
+
+	// Otherwise it's finished.
+	bm.config.ReadOnly = false
+	bm.ShutDown()
+	bucket, err = bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+	AssertEq(nil, err)
+	moved, err := storageutil.ReadObject(ctx, bucket, "dst/a")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(moved))
+	_, err = storageutil.ReadObject(ctx, bucket, journalName)
+	ExpectNe(nil, err)
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_MovesDirtyDataWhenReadOnly() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.gcCtx, bm.stopGarbageCollecting = context.WithCancel(ctx)
+	defer bm.ShutDown()
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+	bucketDir := filepath.Join(dir, "bucket")
+	journalDir := filepath.Join(dir, "journal")
+	// Leave the dirty contents of a new file behind in the journal.
+	j, err := NewDirtyJournal(journalDir, timeutil.RealClock())
+	AssertEq(nil, err)
+	tf, err := j.NewTempFile(io.NopCloser(strings.NewReader("")))
+	AssertEq(nil, err)
+	err = j.Record(tf, DirtyManifest{BucketName: "bucket", ObjectName: "foo"})
+	AssertEq(nil, err)
+	_, err = tf.WriteAt([]byte("taco"), 0)
+	AssertEq(nil, err)
+	tf.(*journaledTempFile).TempFile.(*tempFile).f.Close()
+	bm.config = BucketConfig{
+		TmpObjectPrefix: "TmpObjectPrefix",
+		ReadOnly:        true,
+		DirtyJournal:    j,
+		UploadDirtyData: true,
+	}
+
+	bucket, err := bm.SetUpBucket(ctx, localdir.URLScheme+bucketDir, false)
+
+	AssertEq(nil, err)
+	_, _, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr), "err: %v", err)
+	entries, err := os.ReadDir(filepath.Join(journalDir, DirtyRecoveryDir))
+	AssertEq(nil, err)
+	ExpectEq(2, len(entries))
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_RecordAndReplay() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.gcCtx = ctx
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+	trace := filepath.Join(dir, "trace")
+	bucketDir := filepath.Join(dir, "bucket")
+	AssertEq(nil, os.Mkdir(bucketDir, 0755))
+	// Record a request to a bucket.
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix", GCSTracePath: trace}
+	bucket, err := bm.SetUpBucket(ctx, localdir.URLScheme+bucketDir, false)
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix"}
+
+	bucket, err = bm.SetUpBucket(ctx, replay.URLScheme+trace, false)
+
+	AssertEq(nil, err)
+	ExpectEq("bucket", bucket.Name())
+	_, err = storageutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	ExpectEq(nil, err)
+}
+
+// Append to object foo through a temp file with the given mtime, as done when
+// a file is written and synced, returning the object written.
+func writeAndSyncFoo(ctx context.Context, bucket SyncerBucket, mtime time.Time) (o *gcs.Object, err error) {
+	src, err := storageutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		return
+	}
+	var clock timeutil.SimulatedClock
+	clock.SetTime(mtime)
+	content, err := NewTempFile(io.NopCloser(strings.NewReader("taco")), "", &clock)
+	if err != nil {
+		return
+	}
+	defer content.Destroy()
+	if _, err = content.WriteAt([]byte("burrito"), 4); err != nil {
+		return
+	}
+
+	o, err = bucket.SyncObject(ctx, "foo", src, content)
+	return
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_ReplaysFileWriteAndSync() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.gcCtx = ctx
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+	trace := filepath.Join(dir, "trace")
+	bucketDir := filepath.Join(dir, "bucket")
+	AssertEq(nil, os.Mkdir(bucketDir, 0755))
+	// Record appending to a file, which writes a temporary object with a random
+	// name and sets the mtime of the file in the object's metadata.
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix", AppendThreshold: 1, GCSTracePath: trace}
+	bucket, err := bm.SetUpBucket(ctx, localdir.URLScheme+bucketDir, false)
+	AssertEq(nil, err)
+	recorded, err := writeAndSyncFoo(ctx, bucket, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	AssertEq(nil, err)
+	AssertEq(2, recorded.ComponentCount)
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix", AppendThreshold: 1}
+	bucket, err = bm.SetUpBucket(ctx, replay.URLScheme+trace, false)
+	AssertEq(nil, err)
+
+	replayed, err := writeAndSyncFoo(ctx, bucket, time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC))
+
+	AssertEq(nil, err)
+	ExpectEq(recorded.Generation, replayed.Generation)
+	ExpectEq(recorded.Size, replayed.Size)
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_Overlay() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.storageHandle = t.storageHandle
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix", OverlayLower: TestBucketName + "/base"}
+	bm.gcCtx = ctx
+	dir, err := os.MkdirTemp("", "bucket_manager_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+	_, err = storageutil.CreateObject(ctx, t.bucket, "base/foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	bucket, err := bm.SetUpBucket(ctx, localdir.URLScheme+dir, false)
+
+	AssertEq(nil, err)
+	ExpectEq(filepath.Base(dir), bucket.Name())
+	contents, err := storageutil.ReadObject(ctx, bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	_, err = storageutil.CreateObject(ctx, bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+	contents, err = storageutil.ReadObject(ctx, t.bucket, "base/foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *BucketManagerTest) TestSetUpBucketMethod_OverlayWithMultiBucketMount() {
+	var bm bucketManager
+	ctx := context.Background()
+	bm.storageHandle = t.storageHandle
+	bm.config = BucketConfig{TmpObjectPrefix: "TmpObjectPrefix", OverlayLower: TestBucketName}
+	bm.gcCtx = ctx
+
+	_, err := bm.SetUpBucket(ctx, TestBucketName, true)
+
+	ExpectNe(nil, err)
+}
+
 func (t *BucketManagerTest) TestSetUpBucketMethodWhenBucketDoesNotExist() {
 	var bm bucketManager
 	bucketConfig := BucketConfig{
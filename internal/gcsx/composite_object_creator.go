@@ -0,0 +1,378 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// ParallelUploadConfig controls the parallel composite uploads made by a
+// syncer when writing out the full contents of a file.
+type ParallelUploadConfig struct {
+	// Files at least this long are uploaded as parallel composite uploads. Zero
+	// disables parallel composite uploads.
+	Threshold int64
+
+	// The size of each part uploaded. Larger parts are used if needed to keep
+	// within gcs.MaxComponentCount parts.
+	PartSize int64
+
+	// The maximum number of parts of a file uploaded at a time.
+	Parallelism int
+}
+
+// Create an objectCreator that accepts a source object, if any, and the full
+// contents with which it should be overwritten, uploading them as parts of
+// partSize bytes, up to parallelism at a time, then composing the parts into
+// the destination object. The parts are temporary objects with names
+// beginning with the supplied prefix.
+//
+// The reader passed to Create must be an *io.SectionReader, so that the parts
+// can be read concurrently.
+//
+// Note that the Create method will attempt to remove any temporary junk left
+// behind, but it may fail to do so. Users should arrange for garbage collection.
+//
+// Create guarantees to return *gcs.PreconditionError when the source object
+// has been clobbered, or when there is no source object and the destination
+// object has been created since.
+func newCompositeObjectCreator(
+	prefix string,
+	partSize int64,
+	parallelism int,
+	bucket gcs.Bucket) (oc objectCreator) {
+	if partSize < 1 {
+		partSize = 1
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	oc = &compositeObjectCreator{
+		namer:       appendObjectCreator{prefix: prefix},
+		partSize:    partSize,
+		parallelism: parallelism,
+		bucket:      bucket,
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Implementation
+////////////////////////////////////////////////////////////////////////
+
+type compositeObjectCreator struct {
+	// Used only for choosing names for temporary objects.
+	namer appendObjectCreator
+
+	partSize    int64
+	parallelism int
+	bucket      gcs.Bucket
+}
+
+// The byte range of a part, and the temporary object it was uploaded to.
+type uploadPart struct {
+	offset int64
+	length int64
+	source gcs.ComposeSource
+}
+
+func (oc *compositeObjectCreator) Create(
+	ctx context.Context,
+	objectName string,
+	srcObject *gcs.Object,
+	mtime *time.Time,
+	r io.Reader) (o *gcs.Object, err error) {
+	sr, ok := r.(*io.SectionReader)
+	if !ok {
+		err = fmt.Errorf("unexpected reader type %T", r)
+		return
+	}
+
+	// Attempt to delete the temporary objects when we're done, whether or not
+	// we succeed.
+	var tmpObjects []string
+	var tmpObjectsMu sync.Mutex
+	created := func(name string) {
+		tmpObjectsMu.Lock()
+		tmpObjects = append(tmpObjects, name)
+		tmpObjectsMu.Unlock()
+	}
+	defer func() {
+		deleteErr := oc.deleteAll(ctx, tmpObjects)
+		if err == nil && deleteErr != nil {
+			err = fmt.Errorf("deleteAll: %w", deleteErr)
+		}
+	}()
+
+	sources, err := oc.uploadParts(ctx, sr, created)
+	if err != nil {
+		err = fmt.Errorf("uploadParts: %w", err)
+		return
+	}
+
+	// Compose the parts into intermediate objects until there are few enough
+	// left to compose into the destination object in one go.
+	for len(sources) > gcs.MaxSourcesPerComposeRequest {
+		sources, err = oc.composeIntermediate(ctx, sources, created)
+		if err != nil {
+			err = fmt.Errorf("composeIntermediate: %w", err)
+			return
+		}
+	}
+
+	o, err = oc.composeFinal(ctx, objectName, srcObject, mtime, sources)
+	if err != nil {
+		err = fmt.Errorf("ComposeObjects: %w", err)
+		return
+	}
+
+	return
+}
+
+// Upload the contents of the supplied reader as temporary objects, returning
+// them in order.
+func (oc *compositeObjectCreator) uploadParts(
+	ctx context.Context,
+	sr *io.SectionReader,
+	created func(string)) (sources []gcs.ComposeSource, err error) {
+	// Use larger parts if need be to stay within the component count limit of
+	// the composed object. An empty file is uploaded as a single empty part.
+	size := sr.Size()
+	partSize := oc.partSize
+	if minPartSize := (size + gcs.MaxComponentCount - 1) / gcs.MaxComponentCount; partSize < minPartSize {
+		partSize = minPartSize
+	}
+
+	var parts []*uploadPart
+	for offset := int64(0); offset < size || len(parts) == 0; offset += partSize {
+		parts = append(parts, &uploadPart{
+			offset: offset,
+			length: min(partSize, size-offset),
+		})
+	}
+
+	// Upload the parts with a fixed pool of workers.
+	toUpload := make(chan *uploadPart, len(parts))
+	for _, p := range parts {
+		toUpload <- p
+	}
+	close(toUpload)
+
+	b := syncutil.NewBundle(ctx)
+	for i := 0; i < oc.parallelism && i < len(parts); i++ {
+		b.Add(func(ctx context.Context) (err error) {
+			for p := range toUpload {
+				p.source, err = oc.createTmp(ctx, io.NewSectionReader(sr, p.offset, p.length), created)
+				if err != nil {
+					return
+				}
+			}
+			return
+		})
+	}
+
+	err = b.Join()
+	if err != nil {
+		return
+	}
+
+	sources = make([]gcs.ComposeSource, len(parts))
+	for i, p := range parts {
+		sources[i] = p.source
+	}
+
+	return
+}
+
+// Create a temporary object with the supplied contents.
+func (oc *compositeObjectCreator) createTmp(
+	ctx context.Context,
+	contents io.Reader,
+	created func(string)) (source gcs.ComposeSource, err error) {
+	name, err := oc.namer.chooseName()
+	if err != nil {
+		err = fmt.Errorf("chooseName: %w", err)
+		return
+	}
+
+	var zero int64
+	tmp, err := oc.bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   name,
+			GenerationPrecondition: &zero,
+			Contents:               contents,
+		})
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %w", err)
+		return
+	}
+
+	created(tmp.Name)
+	source = gcs.ComposeSource{
+		Name:       tmp.Name,
+		Generation: tmp.Generation,
+	}
+
+	return
+}
+
+// Compose each run of gcs.MaxSourcesPerComposeRequest sources into a
+// temporary object, returning them in order.
+func (oc *compositeObjectCreator) composeIntermediate(
+	ctx context.Context,
+	sources []gcs.ComposeSource,
+	created func(string)) (composed []gcs.ComposeSource, err error) {
+	n := (len(sources) + gcs.MaxSourcesPerComposeRequest - 1) / gcs.MaxSourcesPerComposeRequest
+	composed = make([]gcs.ComposeSource, n)
+
+	b := syncutil.NewBundle(ctx)
+	for i := 0; i < n; i++ {
+		i := i
+		batch := sources[i*gcs.MaxSourcesPerComposeRequest : min((i+1)*gcs.MaxSourcesPerComposeRequest, len(sources))]
+		b.Add(func(ctx context.Context) (err error) {
+			name, err := oc.namer.chooseName()
+			if err != nil {
+				err = fmt.Errorf("chooseName: %w", err)
+				return
+			}
+
+			var zero int64
+			tmp, err := oc.bucket.ComposeObjects(
+				ctx,
+				&gcs.ComposeObjectsRequest{
+					DstName:                   name,
+					DstGenerationPrecondition: &zero,
+					Sources:                   batch,
+				})
+			if err != nil {
+				err = fmt.Errorf("ComposeObjects: %w", err)
+				return
+			}
+
+			created(tmp.Name)
+			composed[i] = gcs.ComposeSource{
+				Name:       tmp.Name,
+				Generation: tmp.Generation,
+			}
+
+			return
+		})
+	}
+
+	err = b.Join()
+	return
+}
+
+// Compose the supplied sources into the destination object, with the same
+// preconditions and attributes as fullObjectCreator would write it with.
+func (oc *compositeObjectCreator) composeFinal(
+	ctx context.Context,
+	objectName string,
+	srcObject *gcs.Object,
+	mtime *time.Time,
+	sources []gcs.ComposeSource) (o *gcs.Object, err error) {
+	metadataMap := make(map[string]string)
+
+	var req *gcs.ComposeObjectsRequest
+	if srcObject == nil {
+		var precond int64
+		req = &gcs.ComposeObjectsRequest{
+			DstName:                   objectName,
+			DstGenerationPrecondition: &precond,
+			Sources:                   sources,
+			Metadata:                  metadataMap,
+		}
+	} else {
+		for key, value := range srcObject.Metadata {
+			metadataMap[key] = value
+		}
+
+		req = &gcs.ComposeObjectsRequest{
+			DstName:                       srcObject.Name,
+			DstGenerationPrecondition:     &srcObject.Generation,
+			DstMetaGenerationPrecondition: &srcObject.MetaGeneration,
+			Sources:                       sources,
+			Metadata:                      metadataMap,
+			CacheControl:                  srcObject.CacheControl,
+			ContentDisposition:            srcObject.ContentDisposition,
+			ContentEncoding:               srcObject.ContentEncoding,
+			ContentType:                   srcObject.ContentType,
+			CustomTime:                    srcObject.CustomTime,
+			EventBasedHold:                srcObject.EventBasedHold,
+			StorageClass:                  srcObject.StorageClass,
+		}
+	}
+
+	// Any existing mtime value will be overwritten with new value.
+	if mtime != nil {
+		metadataMap[MtimeMetadataKey] = mtime.UTC().Format(time.RFC3339Nano)
+	}
+
+	// Unlike in the append flow, the source object isn't among the sources, so
+	// a not found error means that a temporary object was garbage collected
+	// during a very slow upload rather than that the source was clobbered.
+	o, err = oc.bucket.ComposeObjects(ctx, req)
+	return
+}
+
+// Delete the supplied temporary objects, up to oc.parallelism at a time.
+func (oc *compositeObjectCreator) deleteAll(
+	ctx context.Context,
+	names []string) (err error) {
+	toDelete := make(chan string, len(names))
+	for _, name := range names {
+		toDelete <- name
+	}
+	close(toDelete)
+
+	b := syncutil.NewBundle(ctx)
+	for i := 0; i < oc.parallelism && i < len(names); i++ {
+		b.Add(func(ctx context.Context) (err error) {
+			for name := range toDelete {
+				err = oc.bucket.DeleteObject(
+					ctx,
+					&gcs.DeleteObjectRequest{
+						Name:       name,
+						Generation: 0, // Delete the latest generation of temporary object.
+					})
+
+				var notFoundErr *gcs.NotFoundError
+				if errors.As(err, &notFoundErr) {
+					err = nil
+				}
+
+				if err != nil {
+					err = fmt.Errorf("DeleteObject(%q): %w", name, err)
+					return
+				}
+			}
+			return
+		})
+	}
+
+	err = b.Join()
+	return
+}
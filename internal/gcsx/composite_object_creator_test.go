@@ -0,0 +1,215 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestCompositeObjectCreator(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose object creations fail once a given number have succeeded.
+type failingCreateBucket struct {
+	gcs.Bucket
+
+	mu        sync.Mutex
+	remaining int
+}
+
+func (b *failingCreateBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (*gcs.Object, error) {
+	b.mu.Lock()
+	fail := b.remaining == 0
+	if !fail {
+		b.remaining--
+	}
+	b.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("taco")
+	}
+	return b.Bucket.CreateObject(ctx, req)
+}
+
+type CompositeObjectCreatorTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+	mtime  time.Time
+}
+
+var _ SetUpInterface = &CompositeObjectCreatorTest{}
+
+func init() { RegisterTestSuite(&CompositeObjectCreatorTest{}) }
+
+func (t *CompositeObjectCreatorTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.bucket = fake.NewFakeBucket(&t.clock, "some_bucket")
+	t.mtime = time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (t *CompositeObjectCreatorTest) call(
+	partSize int64,
+	objectName string,
+	srcObject *gcs.Object,
+	contents string) (*gcs.Object, error) {
+	creator := newCompositeObjectCreator(prefix, partSize, 4, t.bucket)
+	return creator.Create(
+		t.ctx,
+		objectName,
+		srcObject,
+		&t.mtime,
+		io.NewSectionReader(strings.NewReader(contents), 0, int64(len(contents))))
+}
+
+func (t *CompositeObjectCreatorTest) tmpObjects() (names []string) {
+	objects, _, err := storageutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+	AssertEq(nil, err)
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	return
+}
+
+func (t *CompositeObjectCreatorTest) read(name string) string {
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CompositeObjectCreatorTest) NewObject() {
+	o, err := t.call(3, "foo", nil, "burrito enchilada")
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq(len("burrito enchilada"), o.Size)
+	ExpectEq(6, o.ComponentCount)
+	ExpectEq(t.mtime.Format(time.RFC3339Nano), o.Metadata[MtimeMetadataKey])
+	ExpectEq("burrito enchilada", t.read("foo"))
+	ExpectThat(t.tmpObjects(), ElementsAre())
+}
+
+func (t *CompositeObjectCreatorTest) NewObject_AlreadyExists() {
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = t.call(3, "foo", nil, "burrito")
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+	ExpectEq("taco", t.read("foo"))
+	ExpectThat(t.tmpObjects(), ElementsAre())
+}
+
+func (t *CompositeObjectCreatorTest) EmptyContents() {
+	o, err := t.call(3, "foo", nil, "")
+
+	AssertEq(nil, err)
+	ExpectEq(0, o.Size)
+	ExpectEq("", t.read("foo"))
+	ExpectThat(t.tmpObjects(), ElementsAre())
+}
+
+func (t *CompositeObjectCreatorTest) TreeComposesManyParts() {
+	contents := strings.Repeat("0123456789", 100)
+
+	o, err := t.call(1, "foo", nil, contents)
+
+	AssertEq(nil, err)
+	ExpectEq(1000, o.ComponentCount)
+	ExpectEq(contents, t.read("foo"))
+	ExpectThat(t.tmpObjects(), ElementsAre())
+}
+
+func (t *CompositeObjectCreatorTest) GrowsPartsPastComponentCountLimit() {
+	contents := strings.Repeat("x", 2*gcs.MaxComponentCount+1)
+
+	o, err := t.call(1, "foo", nil, contents)
+
+	AssertEq(nil, err)
+	ExpectLe(o.ComponentCount, gcs.MaxComponentCount)
+	ExpectEq(contents, t.read("foo"))
+}
+
+func (t *CompositeObjectCreatorTest) OverwritesSourceObject() {
+	src, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:        "foo",
+		Contents:    strings.NewReader("taco"),
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"a": "b"},
+	})
+	AssertEq(nil, err)
+
+	o, err := t.call(3, "foo", src, "burrito")
+
+	AssertEq(nil, err)
+	ExpectLt(src.Generation, o.Generation)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectEq("b", o.Metadata["a"])
+	ExpectEq(t.mtime.Format(time.RFC3339Nano), o.Metadata[MtimeMetadataKey])
+	ExpectEq("burrito", t.read("foo"))
+}
+
+func (t *CompositeObjectCreatorTest) SourceObjectClobbered() {
+	src, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("queso"))
+	AssertEq(nil, err)
+
+	_, err = t.call(3, "foo", src, "burrito")
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+	ExpectEq("queso", t.read("foo"))
+	ExpectThat(t.tmpObjects(), ElementsAre())
+}
+
+func (t *CompositeObjectCreatorTest) UploadFails() {
+	t.bucket = &failingCreateBucket{Bucket: t.bucket, remaining: 2}
+
+	_, err := t.call(1, "foo", nil, "burrito")
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+	ExpectThat(t.tmpObjects(), ElementsAre())
+	_, _, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
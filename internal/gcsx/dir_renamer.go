@@ -0,0 +1,452 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/syncutil"
+)
+
+// RenameJournalPrefix is the prefix of the names of the journal objects of
+// directory renames in progress. Unlike temporary objects, journals must not
+// be garbage collected, however old they are.
+const RenameJournalPrefix = ".gcsfuse_rename/"
+
+// A renameJournal records the moves planned by a directory rename. It is
+// written to the bucket before the first object is moved and deleted after
+// the last, so that a rename interrupted by a crash can be rolled forward.
+type renameJournal struct {
+	// The names of the backing objects of the old and new directories, ending
+	// with slashes.
+	SrcDir string
+	DstDir string
+
+	// The generations of the objects to be moved from under SrcDir to the same
+	// relative names under DstDir.
+	Objects []journaledObject
+}
+
+type journaledObject struct {
+	Name       string
+	Generation int64
+}
+
+// The suffix which, appended to the name the journal of a rename of a
+// directory would have, names the marker of a rename to that directory. The
+// marker holds the name of the journal, so that the rename can be found from
+// either directory.
+const renameMarkerSuffix = ".dst"
+
+// DirRenamer renames directories in buckets without a hierarchical namespace,
+// where doing so means moving every object in the directory one by one.
+//
+// To make the rename resumable, the moves are first recorded in a journal
+// object. Each object is then copied to its new name, unless an object
+// already exists there, and the copied generation deleted from the old name,
+// so that repeating a move is harmless. Should the process die partway, the
+// next RollForward of either directory, or RollForwardAll, finishes the rename
+// from the journal.
+//
+// An object already at the new name is only taken for an earlier copy when
+// resuming, and only if it has the same contents as the journaled
+// generation. Otherwise the rename fails with syscall.EEXIST, leaving both
+// objects and the journal in place, so that it can be rolled forward once the
+// conflicting object has been dealt with.
+type DirRenamer struct {
+	bucket        gcs.Bucket
+	journalPrefix string
+	parallelism   int
+}
+
+// NewDirRenamer creates a DirRenamer for the supplied bucket, which keeps its
+// journals in objects with names beginning with journalPrefix and moves up to
+// parallelism objects at a time, or one at a time if parallelism isn't
+// positive.
+func NewDirRenamer(
+	bucket gcs.Bucket,
+	journalPrefix string,
+	parallelism int) *DirRenamer {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	return &DirRenamer{
+		bucket:        bucket,
+		journalPrefix: journalPrefix,
+		parallelism:   parallelism,
+	}
+}
+
+// The name of the journal of a rename of the given directory. There is at
+// most one rename of a directory in progress at a time.
+func (r *DirRenamer) journalName(srcDir string) string {
+	// Hash the name of the directory, as it may be as long as the longest
+	// allowed object name.
+	sum := sha256.Sum256([]byte(srcDir))
+	return r.journalPrefix + hex.EncodeToString(sum[:])
+}
+
+// Rename moves the supplied objects, which must all be under srcDir, to the
+// same relative names under dstDir, then deletes the backing object of
+// srcDir, if any. dstDir must have been created, and be empty.
+//
+// If a rename of srcDir is already in progress, fails with
+// *gcs.PreconditionError without moving anything.
+func (r *DirRenamer) Rename(
+	ctx context.Context,
+	srcDir string,
+	dstDir string,
+	objects []*gcs.MinObject) error {
+	j := &renameJournal{
+		SrcDir:  srcDir,
+		DstDir:  dstDir,
+		Objects: make([]journaledObject, 0, len(objects)),
+	}
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Name, srcDir) {
+			return fmt.Errorf("object %q is not in directory %q", o.Name, srcDir)
+		}
+		j.Objects = append(j.Objects, journaledObject{Name: o.Name, Generation: o.Generation})
+	}
+
+	contents, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	// Write the journal, failing if there is one already.
+	name := r.journalName(srcDir)
+	var zero int64
+	journal, err := r.bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:                   name,
+		Contents:               bytes.NewReader(contents),
+		GenerationPrecondition: &zero,
+	})
+	if err != nil {
+		return fmt.Errorf("CreateObject(%q): %w", name, err)
+	}
+
+	// Write the marker, giving up on the rename if that fails, as nothing has
+	// been moved yet.
+	markerName := r.journalName(dstDir) + renameMarkerSuffix
+	_, err = r.bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:     markerName,
+		Contents: strings.NewReader(name),
+	})
+	if err != nil {
+		r.bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{
+			Name:       name,
+			Generation: journal.Generation,
+		})
+		return fmt.Errorf("CreateObject(%q): %w", markerName, err)
+	}
+
+	return r.rollForward(ctx, j, journal.Name, journal.Generation, false)
+}
+
+// RollForward finishes the interrupted renames of dir to another directory,
+// and of another directory to dir, if any, reporting whether there was one of
+// the former, after which dir no longer exists.
+func (r *DirRenamer) RollForward(ctx context.Context, dir string) (bool, error) {
+	// The journal of a rename of dir and the marker of a rename to it are
+	// listed together.
+	name := r.journalName(dir)
+	listing, err := r.bucket.ListObjects(ctx, &gcs.ListObjectsRequest{Prefix: name})
+	if err != nil {
+		return false, fmt.Errorf("ListObjects(%q): %w", name, err)
+	}
+
+	var journal *gcs.Object
+	for _, o := range listing.Objects {
+		switch o.Name {
+		case name:
+			journal = o
+
+		case name + renameMarkerSuffix:
+			if err := r.rollForwardMarker(ctx, o); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if journal == nil {
+		return false, nil
+	}
+	return true, r.rollForwardJournal(ctx, journal.Name, journal.Generation)
+}
+
+// RollForwardAll finishes all the interrupted renames in the bucket,
+// returning the number of them finished, and the errors of the rest.
+func (r *DirRenamer) RollForwardAll(ctx context.Context) (int, error) {
+	var journals []*gcs.Object
+	objects := make(chan *gcs.Object, 100)
+	b := syncutil.NewBundle(ctx)
+	b.Add(func(ctx context.Context) error {
+		defer close(objects)
+		return storageutil.ListPrefix(ctx, r.bucket, r.journalPrefix, objects)
+	})
+	b.Add(func(ctx context.Context) error {
+		for o := range objects {
+			journals = append(journals, o)
+		}
+		return nil
+	})
+	if err := b.Join(); err != nil {
+		return 0, fmt.Errorf("ListPrefix: %w", err)
+	}
+
+	// Markers are deleted along with their journals. A rename which can't be
+	// finished doesn't hold up the others.
+	n := 0
+	var errs []error
+	for _, o := range journals {
+		if strings.HasSuffix(o.Name, renameMarkerSuffix) {
+			continue
+		}
+		if err := r.rollForwardJournal(ctx, o.Name, o.Generation); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+
+	return n, errors.Join(errs...)
+}
+
+// Finish the rename whose journal is named by the given marker, if it hasn't
+// been finished since the marker was listed.
+func (r *DirRenamer) rollForwardMarker(ctx context.Context, marker *gcs.Object) error {
+	var notFoundErr *gcs.NotFoundError
+	rc, err := r.bucket.NewReader(ctx, &gcs.ReadObjectRequest{
+		Name:       marker.Name,
+		Generation: marker.Generation,
+	})
+	if errors.As(err, &notFoundErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("NewReader(%q): %w", marker.Name, err)
+	}
+	name, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("ReadAll(%q): %w", marker.Name, err)
+	}
+
+	m, _, err := r.bucket.StatObject(ctx, &gcs.StatObjectRequest{
+		Name:              string(name),
+		ForceFetchFromGcs: true,
+	})
+	if errors.As(err, &notFoundErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("StatObject(%q): %w", name, err)
+	}
+
+	return r.rollForwardJournal(ctx, m.Name, m.Generation)
+}
+
+// Read the given journal and finish the rename it records.
+func (r *DirRenamer) rollForwardJournal(
+	ctx context.Context,
+	name string,
+	generation int64) error {
+	rc, err := r.bucket.NewReader(ctx, &gcs.ReadObjectRequest{
+		Name:       name,
+		Generation: generation,
+	})
+	if err != nil {
+		return fmt.Errorf("NewReader(%q): %w", name, err)
+	}
+	contents, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("ReadAll(%q): %w", name, err)
+	}
+
+	j := &renameJournal{}
+	if err := json.Unmarshal(contents, j); err != nil {
+		return fmt.Errorf("json.Unmarshal(%q): %w", name, err)
+	}
+
+	logger.Infof("Rolling forward the rename of %q to %q.", j.SrcDir, j.DstDir)
+	return r.rollForward(ctx, j, name, generation, true)
+}
+
+// Move the objects recorded in the journal, then delete the old directory and
+// the journal. If resuming, some of the objects may have been moved already.
+func (r *DirRenamer) rollForward(
+	ctx context.Context,
+	j *renameJournal,
+	journalName string,
+	journalGeneration int64,
+	resuming bool) error {
+	objects := make(chan journaledObject, len(j.Objects))
+	for _, o := range j.Objects {
+		objects <- o
+	}
+	close(objects)
+
+	b := syncutil.NewBundle(ctx)
+	for i := 0; i < r.parallelism; i++ {
+		b.Add(func(ctx context.Context) error {
+			for o := range objects {
+				if err := r.move(ctx, j, o, resuming); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := b.Join(); err != nil {
+		return err
+	}
+
+	// Non-existence of any of the objects isn't an error, e.g. when the
+	// directory is implicit. The marker goes before the journal, so that it
+	// never outlives it.
+	deletes := []*gcs.DeleteObjectRequest{
+		{Name: j.SrcDir},
+		{Name: r.journalName(j.DstDir) + renameMarkerSuffix},
+		{Name: journalName, Generation: journalGeneration},
+	}
+	for _, req := range deletes {
+		err := r.bucket.DeleteObject(ctx, req)
+		var notFoundErr *gcs.NotFoundError
+		if err != nil && !errors.As(err, &notFoundErr) {
+			return fmt.Errorf("DeleteObject(%q): %w", req.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Move a single object, in such a way that moving it again is a no-op.
+func (r *DirRenamer) move(
+	ctx context.Context,
+	j *renameJournal,
+	o journaledObject,
+	resuming bool) error {
+	dstName := j.DstDir + strings.TrimPrefix(o.Name, j.SrcDir)
+
+	// When resuming, the journaled generation may have been moved already. In
+	// a bucket with object versioning, copying it would then resurrect it, so
+	// first check that it is still live.
+	var notFoundErr *gcs.NotFoundError
+	if resuming {
+		m, _, err := r.bucket.StatObject(ctx, &gcs.StatObjectRequest{
+			Name:              o.Name,
+			ForceFetchFromGcs: true,
+		})
+		if errors.As(err, &notFoundErr) || (err == nil && m.Generation != o.Generation) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("StatObject(%q): %w", o.Name, err)
+		}
+	}
+
+	// Copy the journaled generation, unless something is at the new name
+	// already.
+	var zero int64
+	_, err := r.bucket.CopyObject(ctx, &gcs.CopyObjectRequest{
+		SrcName:                   o.Name,
+		SrcGeneration:             o.Generation,
+		DstName:                   dstName,
+		DstGenerationPrecondition: &zero,
+	})
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &notFoundErr) {
+		return nil
+	}
+	if errors.As(err, &preconditionErr) {
+		// When resuming, that may be an earlier copy, but it may also be an
+		// object written there since, in which case the journaled generation
+		// mustn't be lost.
+		copied := false
+		if resuming {
+			copied, err = r.copied(ctx, o, dstName)
+			if err != nil {
+				return err
+			}
+		}
+		if !copied {
+			return fmt.Errorf("move %q: %q exists with other contents: %w", o.Name, dstName, syscall.EEXIST)
+		}
+	} else if err != nil {
+		return fmt.Errorf("CopyObject(%q, %q): %w", o.Name, dstName, err)
+	}
+
+	err = r.bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{
+		Name:       o.Name,
+		Generation: o.Generation,
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteObject(%q): %w", o.Name, err)
+	}
+
+	return nil
+}
+
+// Report whether the object at dstName is a copy of the journaled generation,
+// judging by their sizes and CRC32Cs. Objects without CRC32Cs, as in CMEK
+// buckets, are never considered copies. If the journaled generation is gone,
+// there's nothing left to delete, which is reported as it having been copied.
+func (r *DirRenamer) copied(
+	ctx context.Context,
+	o journaledObject,
+	dstName string) (bool, error) {
+	var notFoundErr *gcs.NotFoundError
+	src, _, err := r.bucket.StatObject(ctx, &gcs.StatObjectRequest{
+		Name:              o.Name,
+		ForceFetchFromGcs: true,
+	})
+	if errors.As(err, &notFoundErr) || (err == nil && src.Generation != o.Generation) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("StatObject(%q): %w", o.Name, err)
+	}
+
+	dst, _, err := r.bucket.StatObject(ctx, &gcs.StatObjectRequest{
+		Name:              dstName,
+		ForceFetchFromGcs: true,
+	})
+	if errors.As(err, &notFoundErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("StatObject(%q): %w", dstName, err)
+	}
+
+	return src.Size == dst.Size &&
+		src.CRC32C != nil &&
+		dst.CRC32C != nil &&
+		*src.CRC32C == *dst.CRC32C, nil
+}
@@ -0,0 +1,316 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestDirRenamer(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose copies of a particular object fail, as though the process
+// died while renaming a directory containing it.
+type failingCopyBucket struct {
+	gcs.Bucket
+	failName string
+}
+
+func (b *failingCopyBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (*gcs.Object, error) {
+	if req.SrcName == b.failName {
+		return nil, errors.New("taco")
+	}
+	return b.Bucket.CopyObject(ctx, req)
+}
+
+type DirRenamerTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &DirRenamerTest{}
+
+func init() { RegisterTestSuite(&DirRenamerTest{}) }
+
+func (t *DirRenamerTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.bucket = fake.NewVersionedFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *DirRenamerTest) createObjects(names ...string) (objects []*gcs.MinObject) {
+	for _, name := range names {
+		o, err := storageutil.CreateObject(t.ctx, t.bucket, name, []byte("contents of "+name))
+		AssertEq(nil, err)
+		objects = append(objects, storageutil.ConvertObjToMinObject(o))
+	}
+	return
+}
+
+func (t *DirRenamerTest) read(name string) string {
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+func (t *DirRenamerTest) exists(name string) bool {
+	_, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return false
+	}
+	AssertEq(nil, err)
+	return true
+}
+
+func (t *DirRenamerTest) journals() (names []string) {
+	objects, _, err := storageutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: gcsx.RenameJournalPrefix})
+	AssertEq(nil, err)
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	return
+}
+
+// Start renaming src/ to dst/, failing at src/c.
+func (t *DirRenamerTest) interruptedRename() {
+	t.createObjects("src/", "dst/")
+	objects := t.createObjects("src/a", "src/b", "src/c", "src/d")
+
+	failing := &failingCopyBucket{Bucket: t.bucket, failName: "src/c"}
+	err := gcsx.NewDirRenamer(failing, gcsx.RenameJournalPrefix, 1).
+		Rename(t.ctx, "src/", "dst/", objects)
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+
+	// The journal, and the marker of the rename to dst/.
+	AssertEq(2, len(t.journals()))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *DirRenamerTest) Rename() {
+	t.createObjects("src/", "dst/")
+	objects := t.createObjects("src/a", "src/b/c", "src/b/")
+
+	err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 2).
+		Rename(t.ctx, "src/", "dst/", objects)
+
+	AssertEq(nil, err)
+	ExpectEq("contents of src/a", t.read("dst/a"))
+	ExpectEq("contents of src/b/c", t.read("dst/b/c"))
+	ExpectTrue(t.exists("dst/b/"))
+	ExpectTrue(t.exists("dst/"))
+	ExpectFalse(t.exists("src/a"))
+	ExpectFalse(t.exists("src/b/c"))
+	ExpectFalse(t.exists("src/b/"))
+	ExpectFalse(t.exists("src/"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) RenameObjectOutsideDir() {
+	objects := t.createObjects("other/a")
+
+	err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		Rename(t.ctx, "src/", "dst/", objects)
+
+	ExpectThat(err, Error(HasSubstr("not in directory")))
+	ExpectTrue(t.exists("other/a"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) RenameWhileRenameInProgress() {
+	t.interruptedRename()
+
+	err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		Rename(t.ctx, "src/", "elsewhere/", nil)
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+	ExpectTrue(t.exists("src/c"))
+}
+
+func (t *DirRenamerTest) RollForward_NoneInProgress() {
+	t.createObjects("src/a")
+
+	resumed, err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		RollForward(t.ctx, "src/")
+
+	AssertEq(nil, err)
+	ExpectFalse(resumed)
+	ExpectTrue(t.exists("src/a"))
+}
+
+func (t *DirRenamerTest) RollForward_FinishesInterruptedRename() {
+	t.interruptedRename()
+	ExpectTrue(t.exists("dst/b"))
+	ExpectFalse(t.exists("dst/c"))
+
+	resumed, err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		RollForward(t.ctx, "src/")
+
+	AssertEq(nil, err)
+	ExpectTrue(resumed)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		ExpectEq("contents of src/"+name, t.read("dst/"+name))
+		ExpectFalse(t.exists("src/" + name))
+	}
+	ExpectFalse(t.exists("src/"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) RollForward_FromNewDirectory() {
+	t.interruptedRename()
+
+	resumed, err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		RollForward(t.ctx, "dst/")
+
+	AssertEq(nil, err)
+	ExpectFalse(resumed)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		ExpectEq("contents of src/"+name, t.read("dst/"+name))
+		ExpectFalse(t.exists("src/" + name))
+	}
+	ExpectFalse(t.exists("src/"))
+	ExpectTrue(t.exists("dst/"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) RollForwardAll_KeepsChangesMadeSinceInterruption() {
+	t.interruptedRename()
+	// Overwrite one moved object and delete another. Neither must be restored
+	// from the old generations, which the bucket keeps.
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "dst/a", []byte("burrito"))
+	AssertEq(nil, err)
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "dst/b"})
+	AssertEq(nil, err)
+
+	n, err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 4).
+		RollForwardAll(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("burrito", t.read("dst/a"))
+	ExpectFalse(t.exists("dst/b"))
+	ExpectEq("contents of src/c", t.read("dst/c"))
+	ExpectEq("contents of src/d", t.read("dst/d"))
+	ExpectFalse(t.exists("src/c"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) RollForward_DeletesEarlierCopy() {
+	t.interruptedRename()
+	// As though the process died between copying src/c and deleting it.
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "dst/c", []byte("contents of src/c"))
+	AssertEq(nil, err)
+
+	_, err = gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		RollForward(t.ctx, "src/")
+
+	AssertEq(nil, err)
+	ExpectEq("contents of src/c", t.read("dst/c"))
+	ExpectFalse(t.exists("src/c"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) RollForward_FailsWhenDestinationDiffers() {
+	t.interruptedRename()
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "dst/c", []byte("burrito"))
+	AssertEq(nil, err)
+	renamer := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1)
+
+	_, err = renamer.RollForward(t.ctx, "src/")
+
+	ExpectTrue(errors.Is(err, syscall.EEXIST), "err: %v", err)
+	ExpectEq("burrito", t.read("dst/c"))
+	ExpectEq("contents of src/c", t.read("src/c"))
+	ExpectEq(2, len(t.journals()))
+
+	// The rename is finished once the conflicting object is out of the way.
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "dst/c"})
+	AssertEq(nil, err)
+	_, err = renamer.RollForward(t.ctx, "src/")
+	AssertEq(nil, err)
+	ExpectEq("contents of src/c", t.read("dst/c"))
+	ExpectFalse(t.exists("src/c"))
+	ExpectThat(t.journals(), ElementsAre())
+}
+
+func (t *DirRenamerTest) Rename_FailsWhenDestinationExists() {
+	t.createObjects("src/", "dst/")
+	objects := t.createObjects("src/a", "src/b")
+	// Written by someone else, with the same contents even.
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "dst/a", []byte("contents of src/a"))
+	AssertEq(nil, err)
+
+	err = gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		Rename(t.ctx, "src/", "dst/", objects)
+
+	ExpectTrue(errors.Is(err, syscall.EEXIST), "err: %v", err)
+	ExpectTrue(t.exists("src/a"))
+	ExpectTrue(t.exists("src/"))
+	ExpectEq(2, len(t.journals()))
+}
+
+func (t *DirRenamerTest) RollForwardAll_FinishesOtherRenames() {
+	t.interruptedRename()
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "dst/c", []byte("burrito"))
+	AssertEq(nil, err)
+	objects := t.createObjects("other/a")
+	failing := &failingCopyBucket{Bucket: t.bucket, failName: "other/a"}
+	err = gcsx.NewDirRenamer(failing, gcsx.RenameJournalPrefix, 1).
+		Rename(t.ctx, "other/", "elsewhere/", objects)
+	AssertNe(nil, err)
+
+	n, err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 1).
+		RollForwardAll(t.ctx)
+
+	ExpectTrue(errors.Is(err, syscall.EEXIST), "err: %v", err)
+	ExpectEq(1, n)
+	ExpectEq("contents of other/a", t.read("elsewhere/a"))
+	ExpectEq("contents of src/c", t.read("src/c"))
+	ExpectEq(2, len(t.journals()))
+}
+
+func (t *DirRenamerTest) RollForwardAll_NoneInProgress() {
+	n, err := gcsx.NewDirRenamer(t.bucket, gcsx.RenameJournalPrefix, 4).
+		RollForwardAll(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+}
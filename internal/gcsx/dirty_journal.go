@@ -0,0 +1,456 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+)
+
+const (
+	// The prefix of the names of the files holding the contents of journaled
+	// temp files. The manifest of each is named after it, plus manifestSuffix.
+	dirtyFilePrefix = "gcsfuse-dirty-"
+	manifestSuffix  = ".json"
+
+	// DirtyRecoveryDir is the directory within the journal directory into which
+	// the dirty contents that can't be uploaded are moved.
+	DirtyRecoveryDir = "recovered"
+
+	// Data files without manifests are normally clean contents left behind by a
+	// crash, but may also be newly created by a running process, so are only
+	// removed once they are this old.
+	orphanStalenessThreshold = time.Minute
+)
+
+// DirtyManifest describes the contents of a file that have yet to be written
+// out to its object, as recorded by a DirtyJournal.
+type DirtyManifest struct {
+	BucketName string
+	ObjectName string
+
+	// The prefix within the bucket, such as that given by --only-dir, which
+	// ObjectName leaves out. See SyncerBucket.ObjectPrefix.
+	ObjectPrefix string
+
+	// The generation of the object from which the contents derive, or zero for
+	// a file that has never been synced.
+	SourceGeneration int64
+
+	// The path of the file holding the contents.
+	TempFilePath string
+
+	// Bytes [0, DirtyThreshold) of the contents are unmodified from the source
+	// object. May be lower than the actual threshold, but never higher.
+	DirtyThreshold int64
+}
+
+// DirtyJournal keeps the contents of files in named temp files in a
+// directory, alongside a manifest for each that has been dirtied, so that
+// dirty contents that were never synced survive a crash and can be recovered
+// by Recover when the bucket is next set up.
+//
+// Each temp file is exclusively locked for as long as it is open, so that a
+// file in use by a live process, this one or another sharing the directory,
+// is never mistaken for one left behind by a crash.
+type DirtyJournal struct {
+	dir   string
+	clock timeutil.Clock
+}
+
+// NewDirtyJournal creates a DirtyJournal keeping its files in dir, which is
+// created if need be.
+func NewDirtyJournal(dir string, clock timeutil.Clock) (j *DirtyJournal, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		err = fmt.Errorf("MkdirAll: %w", err)
+		return
+	}
+
+	j = &DirtyJournal{
+		dir:   dir,
+		clock: clock,
+	}
+
+	return
+}
+
+// NewTempFile creates a temp file whose initial contents are given by the
+// supplied reader, like the package-level NewTempFile, but which is kept in
+// the journal directory. Destroying it removes it and its manifest, if any.
+func (j *DirtyJournal) NewTempFile(source io.ReadCloser) (tf TempFile, err error) {
+	f, err := os.CreateTemp(j.dir, dirtyFilePrefix+"*")
+	if err != nil {
+		err = fmt.Errorf("CreateTemp: %w", err)
+		return
+	}
+
+	locked, err := cacheutil.TryLockFile(f)
+	if err == nil && !locked {
+		err = errors.New("locked by another process")
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		err = fmt.Errorf("TryLockFile: %w", err)
+		return
+	}
+
+	tf = &journaledTempFile{
+		TempFile:               NewCacheFile(source, f, j.dir, j.clock),
+		recordedDirtyThreshold: -1,
+	}
+
+	return
+}
+
+// Record the manifest of the supplied temp file, unless one has been recorded
+// already with the same source generation and a dirty threshold no higher than
+// the supplied one. Temp files not created by a DirtyJournal are ignored.
+//
+// To keep the manifest from overstating the unmodified contents, this must be
+// called before the temp file is modified, with the dirty threshold it will
+// have afterwards.
+func (j *DirtyJournal) Record(tf TempFile, m DirtyManifest) (err error) {
+	jtf, ok := tf.(*journaledTempFile)
+	if !ok {
+		return
+	}
+
+	if jtf.recordedDirtyThreshold >= 0 &&
+		jtf.recordedDirtyThreshold <= m.DirtyThreshold &&
+		jtf.recordedSourceGeneration == m.SourceGeneration {
+		return
+	}
+
+	m.TempFilePath = jtf.Name()
+	err = writeManifest(&m)
+	if err != nil {
+		return
+	}
+
+	jtf.recordedDirtyThreshold = m.DirtyThreshold
+	jtf.recordedSourceGeneration = m.SourceGeneration
+	return
+}
+
+// Forget the manifest of the supplied temp file, so that its contents will
+// not be recovered after a crash, e.g. because its file has been unlinked.
+func (j *DirtyJournal) Forget(tf TempFile) {
+	jtf, ok := tf.(*journaledTempFile)
+	if !ok || jtf.recordedDirtyThreshold < 0 {
+		return
+	}
+
+	if err := os.Remove(jtf.Name() + manifestSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warnf("Removing the dirty manifest of %q: %v", jtf.Name(), err)
+	}
+	jtf.recordedDirtyThreshold = -1
+}
+
+// Recover the dirty contents of objects in the supplied bucket, with its
+// object prefix, left behind in the journal directory by a process that has
+// died, returning the number of files recovered.
+//
+// If upload is set, the contents are written out to their objects, provided
+// that the objects have not been modified since the contents derived from
+// them. Contents that can't be written out for that reason, or all contents if
+// upload is not set, are moved into DirtyRecoveryDir along with their
+// manifests, for the user to deal with. Contents that fail to be recovered for
+// any other reason are left in place for the next attempt.
+func (j *DirtyJournal) Recover(
+	ctx context.Context,
+	bucket SyncerBucket,
+	upload bool) (n int, err error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		err = fmt.Errorf("ReadDir: %w", err)
+		return
+	}
+
+	manifests := make(map[string]bool)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), manifestSuffix) {
+			manifests[strings.TrimSuffix(e.Name(), manifestSuffix)] = true
+		}
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, dirtyFilePrefix) || strings.HasSuffix(name, manifestSuffix) {
+			continue
+		}
+
+		if !manifests[name] {
+			j.removeOrphan(filepath.Join(j.dir, name))
+			continue
+		}
+
+		var recovered bool
+		recovered, err = j.recoverFile(ctx, bucket, filepath.Join(j.dir, name), upload)
+		if err != nil {
+			logger.Errorf("Recovering dirty contents %q: %v", name, err)
+			err = nil
+			continue
+		}
+		if recovered {
+			n++
+		}
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A temp file created by a DirtyJournal, which remembers the manifest last
+// recorded for it.
+type journaledTempFile struct {
+	TempFile
+
+	// The dirty threshold and source generation of the recorded manifest. The
+	// threshold is negative if no manifest has been recorded.
+	recordedDirtyThreshold   int64
+	recordedSourceGeneration int64
+}
+
+func (tf *journaledTempFile) Destroy() {
+	// Remove the manifest before the contents, and both before releasing the
+	// lock by closing the file.
+	name := tf.Name()
+	os.Remove(name + manifestSuffix)
+	os.Remove(name)
+	tf.TempFile.Destroy()
+}
+
+// Write the manifest next to the file it describes, atomically replacing any
+// earlier one.
+func writeManifest(m *DirtyManifest) (err error) {
+	contents, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return
+	}
+
+	name := m.TempFilePath + manifestSuffix
+	err = os.WriteFile(name+".tmp", contents, 0600)
+	if err != nil {
+		err = fmt.Errorf("WriteFile: %w", err)
+		return
+	}
+
+	err = os.Rename(name+".tmp", name)
+	if err != nil {
+		err = fmt.Errorf("Rename: %w", err)
+		return
+	}
+
+	return
+}
+
+// Open and lock the supplied file, returning nil if it is locked by a live
+// process.
+func lockIfAbandoned(path string) (f *os.File, err error) {
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		err = fmt.Errorf("OpenFile: %w", err)
+		return
+	}
+
+	locked, err := cacheutil.TryLockFile(f)
+	if err != nil {
+		f.Close()
+		f = nil
+		err = fmt.Errorf("TryLockFile: %w", err)
+		return
+	}
+	if !locked {
+		f.Close()
+		f = nil
+		return
+	}
+
+	return
+}
+
+// Remove a stale data file without a manifest, unless it is in use.
+func (j *DirtyJournal) removeOrphan(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || j.clock.Now().Sub(fi.ModTime()) < orphanStalenessThreshold {
+		return
+	}
+
+	f, err := lockIfAbandoned(path)
+	if err != nil || f == nil {
+		return
+	}
+	defer f.Close()
+
+	os.Remove(path)
+}
+
+// Recover the dirty contents in the supplied data file, if they belong to the
+// bucket and have been abandoned.
+func (j *DirtyJournal) recoverFile(
+	ctx context.Context,
+	bucket SyncerBucket,
+	path string,
+	upload bool) (recovered bool, err error) {
+	contents, err := os.ReadFile(path + manifestSuffix)
+	if err != nil {
+		err = fmt.Errorf("ReadFile: %w", err)
+		return
+	}
+
+	var m DirtyManifest
+	err = json.Unmarshal(contents, &m)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return
+	}
+
+	if m.BucketName != bucket.Name() || m.ObjectPrefix != bucket.ObjectPrefix {
+		return
+	}
+
+	f, err := lockIfAbandoned(path)
+	if err != nil || f == nil {
+		return
+	}
+	defer f.Close()
+
+	if upload {
+		var conflict bool
+		conflict, err = j.upload(ctx, bucket, f, &m)
+		if err != nil {
+			err = fmt.Errorf("upload: %w", err)
+			return
+		}
+		if !conflict {
+			logger.Infof("Recovered the dirty contents of %q by writing them out.", m.ObjectName)
+			os.Remove(path + manifestSuffix)
+			os.Remove(path)
+			recovered = true
+			return
+		}
+	}
+
+	err = j.moveToRecoveryDir(path, &m)
+	if err != nil {
+		err = fmt.Errorf("moveToRecoveryDir: %w", err)
+		return
+	}
+
+	recovered = true
+	return
+}
+
+// Write out the dirty contents in the supplied file to their object, with
+// preconditions ensuring that the object has not been modified since the
+// contents derived from it. Reports a conflict if it has.
+func (j *DirtyJournal) upload(
+	ctx context.Context,
+	bucket SyncerBucket,
+	f *os.File,
+	m *DirtyManifest) (conflict bool, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+
+	var srcObject *gcs.Object
+	if m.SourceGeneration != 0 {
+		minObj, extAttrs, statErr := bucket.StatObject(ctx, &gcs.StatObjectRequest{
+			Name:                           m.ObjectName,
+			ForceFetchFromGcs:              true,
+			ReturnExtendedObjectAttributes: true,
+		})
+		var notFoundErr *gcs.NotFoundError
+		if errors.As(statErr, &notFoundErr) {
+			conflict = true
+			return
+		}
+		if statErr != nil {
+			err = fmt.Errorf("StatObject: %w", statErr)
+			return
+		}
+		if minObj.Generation != m.SourceGeneration {
+			conflict = true
+			return
+		}
+		srcObject = storageutil.ConvertMinObjectAndExtendedObjectAttributesToObject(minObj, extAttrs)
+	}
+
+	tf := RecoverDirtyFile(f, min(m.DirtyThreshold, fi.Size()), fi.Size(), fi.ModTime(), j.clock)
+	_, err = bucket.SyncObject(ctx, m.ObjectName, srcObject, tf)
+
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &preconditionErr) {
+		conflict = true
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("SyncObject: %w", err)
+		return
+	}
+
+	return
+}
+
+// Move the supplied data file and its manifest into the recovery directory.
+func (j *DirtyJournal) moveToRecoveryDir(path string, m *DirtyManifest) (err error) {
+	dir := filepath.Join(j.dir, DirtyRecoveryDir)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		err = fmt.Errorf("MkdirAll: %w", err)
+		return
+	}
+
+	m.TempFilePath = filepath.Join(dir, filepath.Base(path))
+	err = os.Rename(path, m.TempFilePath)
+	if err != nil {
+		err = fmt.Errorf("Rename: %w", err)
+		return
+	}
+
+	err = writeManifest(m)
+	if err != nil {
+		return
+	}
+	os.Remove(path + manifestSuffix)
+
+	logger.Warnf(
+		"The dirty contents of %q could not be written out, and have been moved to %q.",
+		m.ObjectName,
+		m.TempFilePath)
+	return
+}
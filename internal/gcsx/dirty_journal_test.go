@@ -0,0 +1,310 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestDirtyJournal(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type DirtyJournalTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket SyncerBucket
+	dir    string
+	j      *DirtyJournal
+}
+
+var _ SetUpInterface = &DirtyJournalTest{}
+var _ TearDownInterface = &DirtyJournalTest{}
+
+func init() { RegisterTestSuite(&DirtyJournalTest{}) }
+
+func (t *DirtyJournalTest) SetUp(ti *TestInfo) {
+	var err error
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Now())
+	t.bucket = NewSyncerBucket(
+		1, // Append threshold
+		".gcsfuse_tmp/",
+		fake.NewFakeBucket(&t.clock, "some_bucket"))
+
+	t.dir, err = os.MkdirTemp("", "dirty_journal_test")
+	AssertEq(nil, err)
+	t.j, err = NewDirtyJournal(t.dir, &t.clock)
+	AssertEq(nil, err)
+}
+
+func (t *DirtyJournalTest) TearDown() {
+	os.RemoveAll(t.dir)
+}
+
+// Create a journaled temp file with the supplied initial contents for the
+// supplied object, then overwrite it from offset on with data.
+func (t *DirtyJournalTest) dirty(
+	objectName string,
+	srcGeneration int64,
+	initialContents string,
+	data string,
+	offset int64) TempFile {
+	tf, err := t.j.NewTempFile(io.NopCloser(strings.NewReader(initialContents)))
+	AssertEq(nil, err)
+
+	err = t.j.Record(tf, DirtyManifest{
+		BucketName:       t.bucket.Name(),
+		ObjectName:       objectName,
+		SourceGeneration: srcGeneration,
+		DirtyThreshold:   offset,
+	})
+	AssertEq(nil, err)
+
+	_, err = tf.WriteAt([]byte(data), offset)
+	AssertEq(nil, err)
+	return tf
+}
+
+// Simulate the death of the process using the temp file, by closing it
+// without removing anything.
+func (t *DirtyJournalTest) abandon(tf TempFile) {
+	tf.(*journaledTempFile).TempFile.(*tempFile).f.Close()
+}
+
+func (t *DirtyJournalTest) files(dir string) (names []string) {
+	entries, err := os.ReadDir(dir)
+	AssertEq(nil, err)
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return
+}
+
+func (t *DirtyJournalTest) read(name string) string {
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *DirtyJournalTest) RecordOnlyWhenDirtyThresholdDrops() {
+	tf := t.dirty("foo", 0, "taco", "s", 4)
+	m := DirtyManifest{BucketName: "some_bucket", ObjectName: "foo", DirtyThreshold: 5}
+	AssertEq(nil, t.j.Record(tf, m))
+	contents, err := os.ReadFile(tf.Name() + manifestSuffix)
+	AssertEq(nil, err)
+	var recorded DirtyManifest
+	AssertEq(nil, json.Unmarshal(contents, &recorded))
+	ExpectEq(4, recorded.DirtyThreshold)
+
+	m.DirtyThreshold = 1
+	AssertEq(nil, t.j.Record(tf, m))
+	contents, err = os.ReadFile(tf.Name() + manifestSuffix)
+	AssertEq(nil, err)
+	AssertEq(nil, json.Unmarshal(contents, &recorded))
+	ExpectEq(1, recorded.DirtyThreshold)
+	ExpectEq(tf.Name(), recorded.TempFilePath)
+}
+
+func (t *DirtyJournalTest) DestroyRemovesFiles() {
+	tf := t.dirty("foo", 0, "", "taco", 0)
+
+	tf.Destroy()
+
+	ExpectThat(t.files(t.dir), ElementsAre())
+}
+
+func (t *DirtyJournalTest) Forget() {
+	tf := t.dirty("foo", 0, "", "taco", 0)
+
+	t.j.Forget(tf)
+
+	ExpectThat(t.files(t.dir), ElementsAre(filepath.Base(tf.Name())))
+}
+
+func (t *DirtyJournalTest) Recover_UploadsNewFile() {
+	t.abandon(t.dirty("foo", 0, "", "taco", 0))
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("taco", t.read("foo"))
+	ExpectThat(t.files(t.dir), ElementsAre())
+}
+
+func (t *DirtyJournalTest) Recover_UploadsModifiedObject() {
+	src, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+		Metadata: map[string]string{"a": "b"},
+	})
+	AssertEq(nil, err)
+	t.abandon(t.dirty("foo", src.Generation, "taco", "pas", 2))
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("tapas", t.read("foo"))
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectLt(src.Generation, m.Generation)
+	ExpectEq("b", m.Metadata["a"])
+	ExpectThat(t.files(t.dir), ElementsAre())
+}
+
+func (t *DirtyJournalTest) Recover_ObjectModifiedSince() {
+	src, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	tf := t.dirty("foo", src.Generation, "taco", "pas", 2)
+	name := filepath.Base(tf.Name())
+	t.abandon(tf)
+	_, err = storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("burrito", t.read("foo"))
+	ExpectThat(t.files(t.dir), ElementsAre())
+
+	recoveryDir := filepath.Join(t.dir, DirtyRecoveryDir)
+	ExpectThat(t.files(recoveryDir), ElementsAre(name, name+manifestSuffix))
+	contents, err := os.ReadFile(filepath.Join(recoveryDir, name))
+	AssertEq(nil, err)
+	ExpectEq("tapas", string(contents))
+	contents, err = os.ReadFile(filepath.Join(recoveryDir, name+manifestSuffix))
+	AssertEq(nil, err)
+	var m DirtyManifest
+	AssertEq(nil, json.Unmarshal(contents, &m))
+	ExpectEq("foo", m.ObjectName)
+	ExpectEq(filepath.Join(recoveryDir, name), m.TempFilePath)
+}
+
+func (t *DirtyJournalTest) Recover_NewFileCreatedSince() {
+	t.abandon(t.dirty("foo", 0, "", "taco", 0))
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("burrito", t.read("foo"))
+	ExpectEq(2, len(t.files(filepath.Join(t.dir, DirtyRecoveryDir))))
+}
+
+func (t *DirtyJournalTest) Recover_MoveWithoutUploading() {
+	t.abandon(t.dirty("foo", 0, "", "taco", 0))
+
+	n, err := t.j.Recover(t.ctx, t.bucket, false)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	_, _, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+	ExpectEq(2, len(t.files(filepath.Join(t.dir, DirtyRecoveryDir))))
+}
+
+func (t *DirtyJournalTest) Recover_SkipsFilesInUse() {
+	tf := t.dirty("foo", 0, "", "taco", 0)
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectThat(t.files(t.dir), ElementsAre(filepath.Base(tf.Name()), filepath.Base(tf.Name())+manifestSuffix))
+}
+
+func (t *DirtyJournalTest) Recover_SkipsOtherBuckets() {
+	tf, err := t.j.NewTempFile(io.NopCloser(strings.NewReader("")))
+	AssertEq(nil, err)
+	err = t.j.Record(tf, DirtyManifest{BucketName: "other_bucket", ObjectName: "foo"})
+	AssertEq(nil, err)
+	t.abandon(tf)
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectEq(2, len(t.files(t.dir)))
+}
+
+func (t *DirtyJournalTest) Recover_SkipsOtherPrefixes() {
+	tf := t.dirty("foo", 0, "", "taco", 0)
+	t.abandon(tf)
+	// The same bucket, mounted with --only-dir.
+	prefixed := t.bucket
+	prefixed.ObjectPrefix = "some_dir/"
+
+	n, err := t.j.Recover(t.ctx, prefixed, true)
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectEq(2, len(t.files(t.dir)))
+
+	// Whereas the bucket the file was recorded for recovers it.
+	n, err = t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("taco", t.read("foo"))
+}
+
+func (t *DirtyJournalTest) Recover_RemovesStaleOrphans() {
+	stale, err := t.j.NewTempFile(io.NopCloser(strings.NewReader("taco")))
+	AssertEq(nil, err)
+	t.abandon(stale)
+	t.clock.AdvanceTime(2 * orphanStalenessThreshold)
+	fresh, err := t.j.NewTempFile(io.NopCloser(strings.NewReader("taco")))
+	AssertEq(nil, err)
+	t.abandon(fresh)
+	fi, err := os.Stat(fresh.Name())
+	AssertEq(nil, err)
+	err = os.Chtimes(fresh.Name(), fi.ModTime(), t.clock.Now())
+	AssertEq(nil, err)
+
+	n, err := t.j.Recover(t.ctx, t.bucket, true)
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectThat(t.files(t.dir), ElementsAre(filepath.Base(fresh.Name())))
+}
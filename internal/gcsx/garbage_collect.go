@@ -27,9 +27,12 @@ import (
 	"github.com/jacobsa/syncutil"
 )
 
+// Delete stale temporary objects, and stale block sets if blockSetPrefix is
+// non-empty. See collectBlockSets.
 func garbageCollectOnce(
 	ctx context.Context,
 	tmpObjectPrefix string,
+	blockSetPrefix string,
 	bucket gcs.Bucket) (objectsDeleted uint64, err error) {
 	const stalenessThreshold = 30 * time.Minute
 	b := syncutil.NewBundle(ctx)
@@ -91,14 +94,27 @@ func garbageCollectOnce(
 	})
 
 	err = b.Join()
+	if err != nil || blockSetPrefix == "" {
+		return
+	}
+
+	blocksDeleted, err := collectBlockSets(ctx, blockSetPrefix, now, stalenessThreshold, bucket)
+	objectsDeleted += blocksDeleted
+	if err != nil {
+		err = fmt.Errorf("collectBlockSets: %w", err)
+		return
+	}
+
 	return
 }
 
-// Periodically delete stale temporary objects from the supplied bucket until
-// the context is cancelled.
+// Periodically delete stale temporary objects, and stale block sets if
+// blockSetPrefix is non-empty, from the supplied bucket until the context is
+// cancelled.
 func garbageCollect(
 	ctx context.Context,
 	tmpObjectPrefix string,
+	blockSetPrefix string,
 	bucket gcs.Bucket) {
 	const period = 10 * time.Minute
 	ticker := time.NewTicker(period)
@@ -115,7 +131,7 @@ func garbageCollect(
 		logger.Info("Starting a garbage collection run.")
 
 		startTime := time.Now()
-		objectsDeleted, err := garbageCollectOnce(ctx, tmpObjectPrefix, bucket)
+		objectsDeleted, err := garbageCollectOnce(ctx, tmpObjectPrefix, blockSetPrefix, bucket)
 
 		if err != nil {
 			logger.Infof(
@@ -91,6 +91,7 @@ func (t *IntegrationTest) SetUp(ti *TestInfo) {
 	t.syncer = gcsx.NewSyncer(
 		appendThreshold,
 		tmpObjectPrefix,
+		gcsx.SyncerOptions{},
 		t.bucket)
 }
 
@@ -0,0 +1,766 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/net/context"
+)
+
+// WhiteoutMetadataKey is the metadata key marking an object in the upper layer
+// of an overlay bucket as a whiteout, which hides the object of the same name
+// in the lower layer.
+const WhiteoutMetadataKey = "gcsfuse_whiteout"
+
+// NewOverlayBucket creates a view merging a writable upper bucket over a
+// read-only lower one, as a union file system does.
+//
+// An object in the upper layer hides the object of the same name in the lower
+// one. All modifications are made to the upper layer: objects of the lower
+// layer are copied up when modified, and hidden by whiteouts in the upper layer
+// when deleted. Whiteouts are empty objects with WhiteoutMetadataKey set in
+// their metadata, and aren't themselves visible.
+//
+// Listings of noncurrent or soft-deleted generations only list those of the
+// upper layer.
+func NewOverlayBucket(
+	upper gcs.Bucket,
+	lower gcs.Bucket) (b gcs.Bucket) {
+	b = &overlayBucket{
+		upper: upper,
+		lower: lower,
+	}
+
+	return
+}
+
+type overlayBucket struct {
+	upper gcs.Bucket
+	lower gcs.Bucket
+}
+
+// An object name, as resolved in the layers of the bucket.
+type overlayEntry struct {
+	// The visible object, or nil if there is none.
+	m   *gcs.MinObject
+	ext *gcs.ExtendedObjectAttributes
+
+	// Whether the visible object is in the upper layer.
+	inUpper bool
+
+	// The whiteout in the upper layer, if any.
+	whiteout *gcs.MinObject
+}
+
+// The position of a merged listing in the listings of each layer.
+type overlayListingToken struct {
+	// The tokens of the pages of each layer's listing holding the next names.
+	Upper string `json:"u,omitempty"`
+	Lower string `json:"l,omitempty"`
+
+	// Whether each layer's listing has been consumed.
+	UpperDone bool `json:"ud,omitempty"`
+	LowerDone bool `json:"ld,omitempty"`
+
+	// The last name returned. Pages may be listed more than once, and names up
+	// to this one are skipped.
+	After string `json:"a,omitempty"`
+}
+
+func isWhiteout(metadata map[string]string) bool {
+	_, ok := metadata[WhiteoutMetadataKey]
+	return ok
+}
+
+func notFound(name string) error {
+	return &gcs.NotFoundError{Err: fmt.Errorf("object %q not found", name)}
+}
+
+// resolve finds the object visible at the given name. It isn't an error for
+// there to be none.
+func (b *overlayBucket) resolve(
+	ctx context.Context,
+	name string) (e overlayEntry, err error) {
+	req := &gcs.StatObjectRequest{
+		Name:                           name,
+		ForceFetchFromGcs:              true,
+		ReturnExtendedObjectAttributes: true,
+	}
+	var notFoundErr *gcs.NotFoundError
+
+	m, ext, err := b.upper.StatObject(ctx, req)
+	switch {
+	case err == nil && isWhiteout(m.Metadata):
+		e.whiteout = m
+		return
+	case err == nil:
+		e = overlayEntry{m: m, ext: ext, inUpper: true}
+		return
+	case !errors.As(err, &notFoundErr):
+		err = fmt.Errorf("StatObject in upper layer: %w", err)
+		return
+	}
+
+	m, ext, err = b.lower.StatObject(ctx, req)
+	switch {
+	case errors.As(err, &notFoundErr):
+		err = nil
+	case err != nil:
+		err = fmt.Errorf("StatObject in lower layer: %w", err)
+	default:
+		e = overlayEntry{m: m, ext: ext}
+	}
+
+	return
+}
+
+// resolveGeneration finds the object visible at the given name, failing as
+// GCS does if there is none, or if the generation isn't the given one, unless
+// that's zero, or the meta-generation isn't the given one, unless that's nil.
+func (b *overlayBucket) resolveGeneration(
+	ctx context.Context,
+	name string,
+	generation int64,
+	metaGeneration *int64) (e overlayEntry, err error) {
+	e, err = b.resolve(ctx, name)
+	if err != nil {
+		return
+	}
+
+	if e.m == nil || (generation != 0 && e.m.Generation != generation) {
+		err = notFound(name)
+		return
+	}
+
+	if metaGeneration != nil && e.m.MetaGeneration != *metaGeneration {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("object %q has meta-generation %d", name, e.m.MetaGeneration),
+		}
+	}
+
+	return
+}
+
+// upperPreconditions translates preconditions on the object visible at the
+// given name into preconditions for writing it in the upper layer, checking
+// them if the object isn't in the upper layer.
+func (b *overlayBucket) upperPreconditions(
+	ctx context.Context,
+	name string,
+	generation *int64,
+	metaGeneration *int64) (upperGeneration *int64, upperMetaGeneration *int64, err error) {
+	if generation == nil && metaGeneration == nil {
+		return
+	}
+
+	e, err := b.resolve(ctx, name)
+	if err != nil {
+		return
+	}
+
+	// The upper layer enforces preconditions on its own objects atomically.
+	if e.inUpper {
+		upperGeneration, upperMetaGeneration = generation, metaGeneration
+		return
+	}
+
+	var visibleGeneration int64
+	if e.m != nil {
+		visibleGeneration = e.m.Generation
+	}
+
+	if (generation != nil && *generation != visibleGeneration) ||
+		(metaGeneration != nil && (e.m == nil || e.m.MetaGeneration != *metaGeneration)) {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("object %q has generation %d", name, visibleGeneration),
+		}
+		return
+	}
+
+	// Otherwise the write must create the object in the upper layer, or replace
+	// the whiteout there.
+	var replaced int64
+	if e.whiteout != nil {
+		replaced = e.whiteout.Generation
+	}
+	upperGeneration = &replaced
+
+	return
+}
+
+// copyUpRequest returns a request to create an object with the attributes of
+// the given one.
+func copyUpRequest(
+	name string,
+	m *gcs.MinObject,
+	ext *gcs.ExtendedObjectAttributes) (req *gcs.CreateObjectRequest) {
+	req = &gcs.CreateObjectRequest{
+		Name:            name,
+		ContentEncoding: m.ContentEncoding,
+		Metadata:        make(map[string]string),
+		CRC32C:          m.CRC32C,
+	}
+	for k, v := range m.Metadata {
+		req.Metadata[k] = v
+	}
+
+	if ext != nil {
+		req.ContentType = ext.ContentType
+		req.ContentLanguage = ext.ContentLanguage
+		req.CacheControl = ext.CacheControl
+		req.ContentDisposition = ext.ContentDisposition
+		req.CustomTime = ext.CustomTime
+		req.EventBasedHold = ext.EventBasedHold
+	}
+
+	return
+}
+
+// whiteout hides the object of the lower layer at the given name, replacing
+// the given generation of the object in the upper layer, or 0 if there is
+// none.
+func (b *overlayBucket) whiteout(
+	ctx context.Context,
+	name string,
+	upperGeneration int64,
+	upperMetaGeneration *int64) (err error) {
+	_, err = b.upper.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:                       name,
+		Contents:                   strings.NewReader(""),
+		Metadata:                   map[string]string{WhiteoutMetadataKey: "true"},
+		GenerationPrecondition:     &upperGeneration,
+		MetaGenerationPrecondition: upperMetaGeneration,
+	})
+	if err != nil {
+		err = fmt.Errorf("CreateObject whiteout: %w", err)
+	}
+
+	return
+}
+
+// copyUp copies the given generation of an object in the lower layer to the
+// upper one, creating it with the given request.
+func (b *overlayBucket) copyUp(
+	ctx context.Context,
+	srcName string,
+	srcGeneration int64,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	rc, err := b.lower.NewReader(ctx, &gcs.ReadObjectRequest{
+		Name:           srcName,
+		Generation:     srcGeneration,
+		ReadCompressed: true,
+	})
+	if err != nil {
+		err = fmt.Errorf("NewReader in lower layer: %w", err)
+		return
+	}
+	defer rc.Close()
+
+	req.Contents = rc
+	o, err = b.upper.CreateObject(ctx, req)
+	return
+}
+
+// visibleUnder returns whether any object with the given prefix is visible.
+func (b *overlayBucket) visibleUnder(
+	ctx context.Context,
+	prefix string) (visible bool, err error) {
+	req := &gcs.ListObjectsRequest{Prefix: prefix}
+	for {
+		var listing *gcs.Listing
+		listing, err = b.ListObjects(ctx, req)
+		if err != nil {
+			return
+		}
+
+		if len(listing.Objects) > 0 {
+			visible = true
+			return
+		}
+
+		if listing.ContinuationToken == "" {
+			return
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+}
+
+// listLayer lists the page of the layer's listing with the given token, or an
+// empty one if its listing has been consumed.
+func listLayer(
+	ctx context.Context,
+	layer gcs.Bucket,
+	req *gcs.ListObjectsRequest,
+	token string,
+	done bool) (listing *gcs.Listing, err error) {
+	if done {
+		listing = &gcs.Listing{}
+		return
+	}
+
+	mReq := new(gcs.ListObjectsRequest)
+	*mReq = *req
+	mReq.ContinuationToken = token
+
+	listing, err = layer.ListObjects(ctx, mReq)
+	return
+}
+
+// lastName returns the greatest name in the listing, or "" if it's empty.
+func lastName(listing *gcs.Listing) (name string) {
+	if n := len(listing.Objects); n > 0 {
+		name = listing.Objects[n-1].Name
+	}
+	if n := len(listing.CollapsedRuns); n > 0 && listing.CollapsedRuns[n-1] > name {
+		name = listing.CollapsedRuns[n-1]
+	}
+
+	return
+}
+
+// nextPage returns the position in a layer's listing following a merged page
+// ending at the given name.
+func nextPage(
+	listing *gcs.Listing,
+	token string,
+	done bool,
+	end string) (nextToken string, nextDone bool) {
+	switch {
+	case done:
+		nextDone = true
+	case lastName(listing) > end:
+		// Some of the page remains to be merged.
+		nextToken = token
+	case listing.ContinuationToken == "":
+		nextDone = true
+	default:
+		nextToken = listing.ContinuationToken
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *overlayBucket) Name() string {
+	return b.upper.Name()
+}
+
+// The layers are merged object by object, hence folders aren't used.
+func (b *overlayBucket) BucketType() gcs.BucketType {
+	return gcs.NonHierarchical
+}
+
+func (b *overlayBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	// A given generation is looked for in the upper layer first. Generations
+	// are assumed to be distinct across the layers, as they are for GCS, where
+	// they're timestamps.
+	if req.Generation != 0 {
+		var notFoundErr *gcs.NotFoundError
+		rc, err = b.upper.NewReader(ctx, req)
+		if errors.As(err, &notFoundErr) {
+			rc, err = b.lower.NewReader(ctx, req)
+		}
+		return
+	}
+
+	e, err := b.resolve(ctx, req.Name)
+	if err != nil {
+		return
+	}
+	if e.m == nil {
+		err = notFound(req.Name)
+		return
+	}
+
+	mReq := new(gcs.ReadObjectRequest)
+	*mReq = *req
+	mReq.Generation = e.m.Generation
+
+	if e.inUpper {
+		rc, err = b.upper.NewReader(ctx, mReq)
+	} else {
+		rc, err = b.lower.NewReader(ctx, mReq)
+	}
+	return
+}
+
+func (b *overlayBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	mReq := new(gcs.CreateObjectRequest)
+	*mReq = *req
+	mReq.GenerationPrecondition, mReq.MetaGenerationPrecondition, err = b.upperPreconditions(
+		ctx,
+		req.Name,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	o, err = b.upper.CreateObject(ctx, mReq)
+	return
+}
+
+func (b *overlayBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	src, err := b.resolveGeneration(ctx, req.SrcName, req.SrcGeneration, req.SrcMetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	dstGeneration, _, err := b.upperPreconditions(ctx, req.DstName, req.DstGenerationPrecondition, nil)
+	if err != nil {
+		return
+	}
+
+	if src.inUpper {
+		mReq := new(gcs.CopyObjectRequest)
+		*mReq = *req
+		mReq.SrcGeneration = src.m.Generation
+		mReq.DstGenerationPrecondition = dstGeneration
+
+		o, err = b.upper.CopyObject(ctx, mReq)
+		return
+	}
+
+	// Copy the object up from the lower layer.
+	cReq := copyUpRequest(req.DstName, src.m, src.ext)
+	cReq.GenerationPrecondition = dstGeneration
+
+	o, err = b.copyUp(ctx, req.SrcName, src.m.Generation, cReq)
+	return
+}
+
+func (b *overlayBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	dstGeneration, dstMetaGeneration, err := b.upperPreconditions(
+		ctx,
+		req.DstName,
+		req.DstGenerationPrecondition,
+		req.DstMetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	// Objects can only be composed within a bucket, so copy the sources in the
+	// lower layer up to the same names in the upper one first. This doesn't
+	// change their contents, but does change their generations.
+	mReq := new(gcs.ComposeObjectsRequest)
+	*mReq = *req
+	mReq.Sources = make([]gcs.ComposeSource, len(req.Sources))
+	copies := make(map[gcs.ComposeSource]int64)
+	for i, s := range req.Sources {
+		// A source may be listed more than once.
+		if g, ok := copies[s]; ok {
+			mReq.Sources[i] = gcs.ComposeSource{Name: s.Name, Generation: g}
+			continue
+		}
+
+		var e overlayEntry
+		e, err = b.resolveGeneration(ctx, s.Name, s.Generation, nil)
+		if err != nil {
+			return
+		}
+
+		if e.inUpper {
+			mReq.Sources[i] = gcs.ComposeSource{Name: s.Name, Generation: e.m.Generation}
+			continue
+		}
+
+		var zero int64
+		cReq := copyUpRequest(s.Name, e.m, e.ext)
+		cReq.GenerationPrecondition = &zero
+
+		var copy *gcs.Object
+		copy, err = b.copyUp(ctx, s.Name, e.m.Generation, cReq)
+		if err != nil {
+			return
+		}
+
+		mReq.Sources[i] = gcs.ComposeSource{Name: s.Name, Generation: copy.Generation}
+		copies[s] = copy.Generation
+
+		// The destination's precondition now applies to the copy.
+		if s.Name == req.DstName && dstGeneration != nil {
+			dstGeneration = &copy.Generation
+		}
+	}
+
+	mReq.DstGenerationPrecondition = dstGeneration
+	mReq.DstMetaGenerationPrecondition = dstMetaGeneration
+
+	o, err = b.upper.ComposeObjects(ctx, mReq)
+	return
+}
+
+func (b *overlayBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, ext *gcs.ExtendedObjectAttributes, err error) {
+	e, err := b.resolve(ctx, req.Name)
+	if err != nil {
+		return
+	}
+	if e.m == nil {
+		err = notFound(req.Name)
+		return
+	}
+
+	m = e.m
+	if req.ReturnExtendedObjectAttributes {
+		ext = e.ext
+	}
+	return
+}
+
+func (b *overlayBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	if req.Versions || req.SoftDeleted {
+		listing, err = b.upper.ListObjects(ctx, req)
+		return
+	}
+
+	var tok overlayListingToken
+	if req.ContinuationToken != "" {
+		var j []byte
+		j, err = base64.RawURLEncoding.DecodeString(req.ContinuationToken)
+		if err == nil {
+			err = json.Unmarshal(j, &tok)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid continuation token %q: %w", req.ContinuationToken, err)
+			return
+		}
+	}
+
+	upper, err := listLayer(ctx, b.upper, req, tok.Upper, tok.UpperDone)
+	if err != nil {
+		err = fmt.Errorf("ListObjects in upper layer: %w", err)
+		return
+	}
+
+	lower, err := listLayer(ctx, b.lower, req, tok.Lower, tok.LowerDone)
+	if err != nil {
+		err = fmt.Errorf("ListObjects in lower layer: %w", err)
+		return
+	}
+
+	// Names can only be merged up to the end of the page of a layer with more
+	// to come, since the other layer's page may hold names beyond it.
+	end, bounded := "", false
+	for _, l := range []*gcs.Listing{upper, lower} {
+		if l.ContinuationToken != "" && (!bounded || lastName(l) < end) {
+			end, bounded = lastName(l), true
+		}
+	}
+
+	inPage := func(name string) bool {
+		return name > tok.After && (!bounded || name <= end)
+	}
+
+	// Objects in the upper layer hide those in the lower one. Collapsed runs
+	// in the upper layer may consist only of whiteouts.
+	objects := make(map[string]*gcs.Object)
+	runs := make(map[string]bool)
+	for _, l := range []*gcs.Listing{lower, upper} {
+		for _, o := range l.Objects {
+			if inPage(o.Name) {
+				objects[o.Name] = o
+			}
+		}
+		for _, r := range l.CollapsedRuns {
+			if inPage(r) {
+				runs[r] = l == upper
+			}
+		}
+	}
+
+	var names []string
+	for name := range objects {
+		names = append(names, name)
+	}
+	for r := range runs {
+		if _, ok := objects[r]; !ok {
+			names = append(names, r)
+		}
+	}
+	sort.Strings(names)
+
+	listing = &gcs.Listing{IncompleteObjects: upper.IncompleteObjects || lower.IncompleteObjects}
+	for i, name := range names {
+		if req.MaxResults > 0 && len(listing.Objects)+len(listing.CollapsedRuns) >= req.MaxResults {
+			end, bounded = names[i-1], true
+			break
+		}
+
+		if o, ok := objects[name]; ok && !isWhiteout(o.Metadata) {
+			listing.Objects = append(listing.Objects, o)
+		}
+
+		if inUpper, ok := runs[name]; ok {
+			visible := true
+			if inUpper {
+				visible, err = b.visibleUnder(ctx, name)
+				if err != nil {
+					return
+				}
+			}
+
+			if visible {
+				listing.CollapsedRuns = append(listing.CollapsedRuns, name)
+			}
+		}
+	}
+
+	if !bounded {
+		return
+	}
+
+	next := overlayListingToken{After: tok.After}
+	if end > next.After {
+		next.After = end
+	}
+	next.Upper, next.UpperDone = nextPage(upper, tok.Upper, tok.UpperDone, next.After)
+	next.Lower, next.LowerDone = nextPage(lower, tok.Lower, tok.LowerDone, next.After)
+
+	if !next.UpperDone || !next.LowerDone {
+		j, _ := json.Marshal(next)
+		listing.ContinuationToken = base64.RawURLEncoding.EncodeToString(j)
+	}
+
+	return
+}
+
+func (b *overlayBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	e, err := b.resolveGeneration(ctx, req.Name, req.Generation, req.MetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	if e.inUpper {
+		mReq := new(gcs.UpdateObjectRequest)
+		*mReq = *req
+		mReq.Generation = e.m.Generation
+
+		o, err = b.upper.UpdateObject(ctx, mReq)
+		return
+	}
+
+	// Copy the object up from the lower layer with the new attributes.
+	cReq := copyUpRequest(req.Name, e.m, e.ext)
+	if req.ContentType != nil {
+		cReq.ContentType = *req.ContentType
+	}
+	if req.ContentEncoding != nil {
+		cReq.ContentEncoding = *req.ContentEncoding
+	}
+	if req.ContentLanguage != nil {
+		cReq.ContentLanguage = *req.ContentLanguage
+	}
+	if req.CacheControl != nil {
+		cReq.CacheControl = *req.CacheControl
+	}
+	for k, v := range req.Metadata {
+		if v == nil {
+			delete(cReq.Metadata, k)
+		} else {
+			cReq.Metadata[k] = *v
+		}
+	}
+
+	var zero int64
+	cReq.GenerationPrecondition = &zero
+
+	o, err = b.copyUp(ctx, req.Name, e.m.Generation, cReq)
+	return
+}
+
+func (b *overlayBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	e, err := b.resolveGeneration(ctx, req.Name, req.Generation, nil)
+	if err != nil {
+		return
+	}
+
+	if !e.inUpper {
+		if req.MetaGenerationPrecondition != nil && e.m.MetaGeneration != *req.MetaGenerationPrecondition {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf("object %q has meta-generation %d", req.Name, e.m.MetaGeneration),
+			}
+			return
+		}
+
+		err = b.whiteout(ctx, req.Name, 0, nil)
+		return
+	}
+
+	// An object in the upper layer is replaced by a whiteout if there's one to
+	// hide in the lower layer, and deleted otherwise.
+	_, _, err = b.lower.StatObject(ctx, &gcs.StatObjectRequest{Name: req.Name, ForceFetchFromGcs: true})
+	var notFoundErr *gcs.NotFoundError
+	switch {
+	case err == nil:
+		err = b.whiteout(ctx, req.Name, e.m.Generation, req.MetaGenerationPrecondition)
+	case errors.As(err, &notFoundErr):
+		mReq := new(gcs.DeleteObjectRequest)
+		*mReq = *req
+		mReq.Generation = e.m.Generation
+
+		err = b.upper.DeleteObject(ctx, mReq)
+	default:
+		err = fmt.Errorf("StatObject in lower layer: %w", err)
+	}
+
+	return
+}
+
+func (b *overlayBucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
+	err = b.upper.DeleteFolder(ctx, folderName)
+	return
+}
+
+func (b *overlayBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	err = errors.New("overlay buckets don't have folders")
+	return
+}
+
+func (b *overlayBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	err = errors.New("overlay buckets don't have folders")
+	return
+}
+
+func (b *overlayBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	err = errors.New("overlay buckets don't have folders")
+	return
+}
@@ -0,0 +1,345 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestOverlayBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type OverlayBucketTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	upper  gcs.Bucket
+	lower  gcs.Bucket
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &OverlayBucketTest{}
+
+func init() { RegisterTestSuite(&OverlayBucketTest{}) }
+
+func (t *OverlayBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.upper = fake.NewFakeBucket(&t.clock, "upper")
+	t.lower = fake.NewFakeBucket(&t.clock, "lower")
+	t.bucket = gcsx.NewOverlayBucket(t.upper, t.lower)
+}
+
+func (t *OverlayBucketTest) createObject(b gcs.Bucket, name string, contents string) *gcs.Object {
+	o, err := storageutil.CreateObject(t.ctx, b, name, []byte(contents))
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Second)
+	return o
+}
+
+func (t *OverlayBucketTest) read(b gcs.Bucket, name string) string {
+	contents, err := storageutil.ReadObject(t.ctx, b, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+func (t *OverlayBucketTest) stat(b gcs.Bucket, name string) (*gcs.MinObject, error) {
+	m, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	return m, err
+}
+
+func (t *OverlayBucketTest) listAll(req *gcs.ListObjectsRequest) (objects []string, runs []string) {
+	for {
+		listing, err := t.bucket.ListObjects(t.ctx, req)
+		AssertEq(nil, err)
+		for _, o := range listing.Objects {
+			objects = append(objects, o.Name)
+		}
+		runs = append(runs, listing.CollapsedRuns...)
+		if listing.ContinuationToken == "" {
+			return
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+}
+
+func isNotFound(err error) bool {
+	var notFoundErr *gcs.NotFoundError
+	return errors.As(err, &notFoundErr)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *OverlayBucketTest) StatAndRead() {
+	lowerFoo := t.createObject(t.lower, "foo", "taco")
+	t.createObject(t.lower, "bar", "enchilada")
+	upperBar := t.createObject(t.upper, "bar", "burrito")
+
+	m, err := t.stat(t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq(lowerFoo.Generation, m.Generation)
+	ExpectEq("taco", t.read(t.bucket, "foo"))
+
+	m, err = t.stat(t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectEq(upperBar.Generation, m.Generation)
+	ExpectEq("burrito", t.read(t.bucket, "bar"))
+
+	_, err = t.stat(t.bucket, "baz")
+	ExpectTrue(isNotFound(err))
+}
+
+func (t *OverlayBucketTest) ReadGeneration() {
+	lowerFoo := t.createObject(t.lower, "foo", "taco")
+	t.createObject(t.upper, "foo", "burrito")
+	t.createObject(t.upper, "foo", "enchilada")
+
+	rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: lowerFoo.Generation})
+
+	AssertEq(nil, err)
+	defer rc.Close()
+	contents, err := io.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *OverlayBucketTest) CreateObject_WritesUpperLayer() {
+	lowerFoo := t.createObject(t.lower, "foo", "taco")
+
+	_, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		Contents:               strings.NewReader("burrito"),
+		GenerationPrecondition: &lowerFoo.Generation,
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("burrito", t.read(t.bucket, "foo"))
+	ExpectEq("burrito", t.read(t.upper, "foo"))
+	ExpectEq("taco", t.read(t.lower, "foo"))
+}
+
+func (t *OverlayBucketTest) CreateObject_PreconditionOnLowerObject() {
+	lowerFoo := t.createObject(t.lower, "foo", "taco")
+	zero := int64(0)
+	wrong := lowerFoo.Generation + 1
+	var preconditionErr *gcs.PreconditionError
+
+	_, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		Contents:               strings.NewReader("burrito"),
+		GenerationPrecondition: &zero,
+	})
+	ExpectTrue(errors.As(err, &preconditionErr))
+
+	_, err = t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		Contents:               strings.NewReader("burrito"),
+		GenerationPrecondition: &wrong,
+	})
+	ExpectTrue(errors.As(err, &preconditionErr))
+
+	_, err = t.stat(t.upper, "foo")
+	ExpectTrue(isNotFound(err))
+}
+
+func (t *OverlayBucketTest) DeleteObject_LowerObject() {
+	t.createObject(t.lower, "foo", "taco")
+
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	_, err = t.stat(t.bucket, "foo")
+	ExpectTrue(isNotFound(err))
+	ExpectEq("taco", t.read(t.lower, "foo"))
+	m, err := t.stat(t.upper, "foo")
+	AssertEq(nil, err)
+	ExpectEq("true", m.Metadata[gcsx.WhiteoutMetadataKey])
+
+	// The name can be used again.
+	zero := int64(0)
+	_, err = t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		Contents:               strings.NewReader("burrito"),
+		GenerationPrecondition: &zero,
+	})
+	AssertEq(nil, err)
+	ExpectEq("burrito", t.read(t.bucket, "foo"))
+}
+
+func (t *OverlayBucketTest) DeleteObject_UpperObjectOverLowerObject() {
+	t.createObject(t.lower, "foo", "taco")
+	t.createObject(t.upper, "foo", "burrito")
+
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	_, err = t.stat(t.bucket, "foo")
+	ExpectTrue(isNotFound(err))
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	ExpectTrue(isNotFound(err))
+}
+
+func (t *OverlayBucketTest) DeleteObject_UpperObject() {
+	t.createObject(t.upper, "foo", "burrito")
+
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	_, err = t.stat(t.upper, "foo")
+	ExpectTrue(isNotFound(err))
+}
+
+func (t *OverlayBucketTest) UpdateObject_CopiesUp() {
+	_, err := t.lower.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:        "foo",
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"a": "1", "b": "2"},
+		Contents:    strings.NewReader("taco"),
+	})
+	AssertEq(nil, err)
+	newValue := "3"
+
+	o, err := t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{
+		Name:     "foo",
+		Metadata: map[string]*string{"a": &newValue, "b": nil},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectThat(o.Metadata, DeepEquals(map[string]string{"a": "3"}))
+	ExpectEq("taco", t.read(t.upper, "foo"))
+	m, err := t.stat(t.lower, "foo")
+	AssertEq(nil, err)
+	ExpectEq("1", m.Metadata["a"])
+}
+
+func (t *OverlayBucketTest) CopyObject_FromLowerLayer() {
+	t.createObject(t.lower, "foo", "taco")
+
+	o, err := t.bucket.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+	ExpectEq("taco", t.read(t.upper, "bar"))
+	_, err = t.stat(t.lower, "bar")
+	ExpectTrue(isNotFound(err))
+}
+
+func (t *OverlayBucketTest) CopyObject_WhitedOutSource() {
+	t.createObject(t.lower, "foo", "taco")
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	_, err = t.bucket.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	ExpectTrue(isNotFound(err))
+}
+
+func (t *OverlayBucketTest) ComposeObjects_AcrossLayers() {
+	lowerFoo := t.createObject(t.lower, "foo", "taco")
+	t.createObject(t.upper, "bar", "burrito")
+
+	_, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName:                   "foo",
+		DstGenerationPrecondition: &lowerFoo.Generation,
+		Sources:                   []gcs.ComposeSource{{Name: "foo"}, {Name: "bar"}},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", t.read(t.bucket, "foo"))
+	ExpectEq("taco", t.read(t.lower, "foo"))
+}
+
+func (t *OverlayBucketTest) ComposeObjects_UpperLayer() {
+	foo := t.createObject(t.upper, "foo", "taco")
+	t.createObject(t.upper, "bar", "burrito")
+
+	o, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName:                   "foo",
+		DstGenerationPrecondition: &foo.Generation,
+		Sources:                   []gcs.ComposeSource{{Name: "foo"}, {Name: "bar"}},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq(2, o.ComponentCount)
+	ExpectEq("tacoburrito", t.read(t.bucket, "foo"))
+}
+
+func (t *OverlayBucketTest) ListObjects() {
+	t.createObject(t.lower, "a", "")
+	t.createObject(t.lower, "c", "")
+	t.createObject(t.lower, "dir/e", "")
+	t.createObject(t.lower, "gone/f", "")
+	upperC := t.createObject(t.upper, "c", "")
+	t.createObject(t.upper, "d", "")
+	t.createObject(t.upper, "new/g", "")
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "a"}))
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "gone/f"}))
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{Delimiter: "/"})
+
+	AssertEq(nil, err)
+	AssertEq(2, len(listing.Objects))
+	ExpectEq("c", listing.Objects[0].Name)
+	ExpectEq(upperC.Generation, listing.Objects[0].Generation)
+	ExpectEq("d", listing.Objects[1].Name)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("dir/", "new/"))
+	ExpectEq("", listing.ContinuationToken)
+}
+
+func (t *OverlayBucketTest) ListObjects_Paginated() {
+	for _, name := range []string{"a", "c", "e", "g", "h", "i"} {
+		t.createObject(t.lower, name, "")
+	}
+	for _, name := range []string{"b", "d", "f"} {
+		t.createObject(t.upper, name, "")
+	}
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "e"}))
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "h"}))
+
+	objects, runs := t.listAll(&gcs.ListObjectsRequest{MaxResults: 2})
+
+	ExpectThat(objects, ElementsAre("a", "b", "c", "d", "f", "g", "i"))
+	ExpectEq(0, len(runs))
+}
+
+func (t *OverlayBucketTest) ListObjects_DeletedDirectory() {
+	t.createObject(t.lower, "dir/", "")
+	t.createObject(t.lower, "dir/a", "")
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "dir/a"}))
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "dir/"}))
+
+	objects, runs := t.listAll(&gcs.ListObjectsRequest{Delimiter: "/", IncludeTrailingDelimiter: true})
+
+	ExpectEq(0, len(objects))
+	ExpectEq(0, len(runs))
+}
@@ -0,0 +1,405 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/monitor"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/util"
+	"golang.org/x/net/context"
+)
+
+// The number of consecutive reads following the same pattern after which
+// read-ahead starts.
+const minReadsForReadAhead = 2
+
+// ReadAheadConfig configures the prefetching of object contents ahead of
+// sequential and strided reads.
+type ReadAheadConfig struct {
+	// The size of each range request issued ahead of sequential reads.
+	ChunkSize int64
+
+	// The maximum number of range requests in flight for a single reader.
+	MaxInFlight int
+
+	// The memory into which prefetched chunks are read, shared by all readers.
+	// Nil disables read-ahead.
+	Buffers *BufferPool
+}
+
+// BufferPool is a bounded set of equally sized buffers, shared by the
+// prefetchers of all readers so that their memory use is bounded as a whole.
+//
+// Safe for concurrent access.
+type BufferPool struct {
+	bufferSize int64
+
+	mu sync.Mutex
+
+	// Buffers which have been released and may be handed out again.
+	//
+	// GUARDED_BY(mu)
+	free [][]byte
+
+	// The number of buffers which may still be allocated.
+	//
+	// GUARDED_BY(mu)
+	unallocated int
+}
+
+// NewBufferPool creates a pool of at most maxBuffers buffers of bufferSize
+// bytes each. The buffers are allocated when first needed.
+func NewBufferPool(bufferSize int64, maxBuffers int) *BufferPool {
+	return &BufferPool{
+		bufferSize:  bufferSize,
+		unallocated: maxBuffers,
+	}
+}
+
+// Acquire a buffer, or return nil if all of them are in use.
+func (bp *BufferPool) acquire() []byte {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if n := len(bp.free); n > 0 {
+		b := bp.free[n-1]
+		bp.free = bp.free[:n-1]
+		return b
+	}
+
+	if bp.unallocated == 0 {
+		return nil
+	}
+	bp.unallocated--
+	return make([]byte, bp.bufferSize)
+}
+
+// Return a buffer obtained from acquire to the pool.
+func (bp *BufferPool) release(b []byte) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	bp.free = append(bp.free, b[:cap(b)])
+}
+
+type readPatternKind int
+
+const (
+	noReadPattern readPatternKind = iota
+	sequentialReadPattern
+	stridedReadPattern
+)
+
+// readPattern tracks the offsets of the reads made through a reader to
+// detect sequential reads, which start where the previous read ended, and
+// strided reads, which have the same size and skip the same distance ahead of
+// the previous read.
+type readPattern struct {
+	kind   readPatternKind
+	streak int
+
+	// The distance between the offsets of the last two reads, when strided.
+	stride int64
+
+	// The last read, or a zero size if there hasn't been one.
+	offset int64
+	size   int64
+}
+
+func (rp *readPattern) observe(offset int64, size int64) {
+	distance := offset - rp.offset
+	switch {
+	case rp.size > 0 && distance == rp.size:
+		if rp.kind != sequentialReadPattern {
+			rp.kind = sequentialReadPattern
+			rp.streak = 0
+		}
+		rp.streak++
+
+	case rp.size > 0 && distance > rp.size && size == rp.size:
+		if rp.kind != stridedReadPattern || rp.stride != distance {
+			rp.kind = stridedReadPattern
+			rp.stride = distance
+			rp.streak = 0
+		}
+		rp.streak++
+
+	default:
+		rp.kind = noReadPattern
+		rp.streak = 0
+	}
+
+	rp.offset = offset
+	rp.size = size
+}
+
+func (rp *readPattern) active() bool {
+	return rp.kind != noReadPattern && rp.streak >= minReadsForReadAhead
+}
+
+// A range of the object being read into a buffer from the pool.
+type prefetchChunk struct {
+	offset int64
+	buf    []byte
+	cancel func()
+
+	// Closed when the fetch is finished, after which n and err are set.
+	done chan struct{}
+	n    int
+	err  error
+
+	// Whether any read has been served from the chunk.
+	consumed bool
+}
+
+func (c *prefetchChunk) end() int64 {
+	return c.offset + int64(len(c.buf))
+}
+
+func (c *prefetchChunk) contains(offset int64) bool {
+	return c.offset <= offset && offset < c.end()
+}
+
+// prefetcher keeps range requests in flight ahead of the reads made through
+// a reader, once these follow a sequential or strided pattern. The number of
+// requests in flight grows while reads have to wait for them, and shrinks
+// when prefetched chunks go unread.
+//
+// Not safe for concurrent access.
+type prefetcher struct {
+	object *gcs.MinObject
+	bucket gcs.Bucket
+	config ReadAheadConfig
+
+	pattern readPattern
+
+	// The chunks in flight or fetched, in the order in which they will be read.
+	//
+	// INVARIANT: len(chunks) <= config.MaxInFlight
+	chunks []*prefetchChunk
+
+	// The number of chunks to keep in flight.
+	//
+	// INVARIANT: 1 <= window <= config.MaxInFlight
+	window int
+}
+
+func newPrefetcher(o *gcs.MinObject, bucket gcs.Bucket, config ReadAheadConfig) *prefetcher {
+	return &prefetcher{
+		object: o,
+		bucket: bucket,
+		config: config,
+		window: 1,
+	}
+}
+
+func (pf *prefetcher) checkInvariants() {
+	// INVARIANT: len(chunks) <= config.MaxInFlight
+	if len(pf.chunks) > pf.config.MaxInFlight {
+		panic(fmt.Sprintf("Too many chunks: %d > %d", len(pf.chunks), pf.config.MaxInFlight))
+	}
+
+	// INVARIANT: 1 <= window <= config.MaxInFlight
+	if pf.window < 1 || pf.window > pf.config.MaxInFlight {
+		panic(fmt.Sprintf("Illegal window: %d", pf.window))
+	}
+}
+
+// Serve as much of a read of len(p) bytes at offset as possible from
+// prefetched chunks, and keep chunks in flight ahead of it if reads follow a
+// pattern. The rest of the read, if any, must be served some other way.
+func (pf *prefetcher) read(ctx context.Context, p []byte, offset int64) (n int, err error) {
+	readEnd := offset + int64(len(p))
+	pf.pattern.observe(offset, int64(len(p)))
+
+	// Chunks which end before the read will not be read. This happens when
+	// the kernel serves some reads from its page cache, or when a strided
+	// pattern changes.
+	for len(pf.chunks) > 0 && pf.chunks[0].end() <= offset {
+		pf.dropFirst()
+	}
+
+	for len(p) > 0 && len(pf.chunks) > 0 && pf.chunks[0].contains(offset) {
+		c := pf.chunks[0]
+
+		select {
+		case <-c.done:
+		default:
+			// Reads are outpacing the requests in flight; keep more of them.
+			pf.window = min(2*pf.window, pf.config.MaxInFlight)
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+
+		if c.err != nil {
+			// Leave the read to the caller and start over.
+			logger.Tracef("Prefetch of [%d, %d) of %q failed: %v", c.offset, c.end(), pf.object.Name, c.err)
+			pf.dropAll()
+			break
+		}
+
+		m := copy(p, c.buf[offset-c.offset:])
+		c.consumed = true
+		n += m
+		p = p[m:]
+		offset += int64(m)
+
+		if offset == c.end() {
+			pf.chunks = pf.chunks[1:]
+			pf.config.Buffers.release(c.buf)
+		}
+	}
+
+	// A read which starts outside of the chunks is a seek; the chunks are
+	// unlikely to be read.
+	if n == 0 && len(pf.chunks) > 0 {
+		pf.dropAll()
+	}
+
+	if pf.pattern.active() {
+		pf.fill(readEnd)
+	}
+
+	return
+}
+
+// Issue requests for chunks following the read ending at readEnd until the
+// window is full or the pool runs out of buffers.
+func (pf *prefetcher) fill(readEnd int64) {
+	size := int64(pf.object.Size)
+	for len(pf.chunks) < pf.window {
+		var offset, length int64
+		switch pf.pattern.kind {
+		case sequentialReadPattern:
+			offset = readEnd
+			if n := len(pf.chunks); n > 0 {
+				offset = pf.chunks[n-1].end()
+			}
+			length = pf.config.ChunkSize
+
+		case stridedReadPattern:
+			offset = pf.pattern.offset + pf.pattern.stride
+			if n := len(pf.chunks); n > 0 {
+				offset = pf.chunks[n-1].offset + pf.pattern.stride
+			}
+			length = min(pf.pattern.size, pf.config.ChunkSize)
+		}
+
+		if offset >= size {
+			return
+		}
+		length = min(length, size-offset)
+
+		buf := pf.config.Buffers.acquire()
+		if buf == nil {
+			return
+		}
+
+		pf.chunks = append(pf.chunks, pf.fetch(offset, buf[:length]))
+	}
+}
+
+// Start reading the object at offset into buf.
+func (pf *prefetcher) fetch(offset int64, buf []byte) *prefetchChunk {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &prefetchChunk{
+		offset: offset,
+		buf:    buf,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	monitor.CaptureGCSReadMetrics(ctx, util.Parallel, int64(len(buf)))
+
+	go func() {
+		defer close(c.done)
+
+		rc, err := pf.bucket.NewReader(
+			ctx,
+			&gcs.ReadObjectRequest{
+				Name:       pf.object.Name,
+				Generation: pf.object.Generation,
+				Range: &gcs.ByteRange{
+					Start: uint64(c.offset),
+					Limit: uint64(c.end()),
+				},
+				ReadCompressed: pf.object.HasContentEncodingGzip(),
+			})
+		if err != nil {
+			c.err = fmt.Errorf("NewReader: %w", err)
+			return
+		}
+		defer rc.Close()
+
+		c.n, err = io.ReadFull(rc, c.buf)
+		if err != nil {
+			c.err = fmt.Errorf("ReadFull: %w", err)
+		}
+	}()
+
+	return c
+}
+
+// Cancel the fetch of a chunk and release its buffer once the fetch is
+// done.
+func (pf *prefetcher) drop(c *prefetchChunk) {
+	c.cancel()
+	select {
+	case <-c.done:
+		pf.config.Buffers.release(c.buf)
+	default:
+		go func() {
+			<-c.done
+			pf.config.Buffers.release(c.buf)
+		}()
+	}
+}
+
+// Drop the first chunk, shrinking the window if it went unread.
+func (pf *prefetcher) dropFirst() {
+	c := pf.chunks[0]
+	pf.chunks = pf.chunks[1:]
+	pf.drop(c)
+	if !c.consumed {
+		pf.window = max(pf.window/2, 1)
+	}
+}
+
+// Drop all chunks, shrinking the window once if any of them went unread.
+func (pf *prefetcher) dropAll() {
+	unread := false
+	for _, c := range pf.chunks {
+		pf.drop(c)
+		unread = unread || !c.consumed
+	}
+	pf.chunks = nil
+	if unread {
+		pf.window = max(pf.window/2, 1)
+	}
+}
+
+// Cancel all fetches. The prefetcher must not be used again.
+func (pf *prefetcher) destroy() {
+	pf.dropAll()
+}
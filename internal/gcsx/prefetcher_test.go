@@ -0,0 +1,358 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/fuse/fuseops"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestPrefetcher(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Gated bucket
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose NewReader blocks until the gate is opened or the request is
+// cancelled, and which records the ranges read.
+type gatedBucket struct {
+	gcs.Bucket
+
+	gate      chan struct{}
+	cancelled chan struct{}
+
+	mu     sync.Mutex
+	ranges []gcs.ByteRange
+}
+
+func (b *gatedBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	b.mu.Lock()
+	if req.Range != nil {
+		b.ranges = append(b.ranges, *req.Range)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.gate:
+	case <-ctx.Done():
+		b.cancelled <- struct{}{}
+		err = ctx.Err()
+		return
+	}
+
+	return b.Bucket.NewReader(ctx, req)
+}
+
+func (b *gatedBucket) readCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.ranges)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+const prefetcherObjectSize = 1000
+
+type PrefetcherTest struct {
+	ctx      context.Context
+	clock    timeutil.SimulatedClock
+	bucket   *gatedBucket
+	object   *gcs.MinObject
+	contents []byte
+	buffers  *BufferPool
+	pf       *prefetcher
+}
+
+var _ SetUpInterface = &PrefetcherTest{}
+var _ TearDownInterface = &PrefetcherTest{}
+
+func init() { RegisterTestSuite(&PrefetcherTest{}) }
+
+func (t *PrefetcherTest) SetUp(ti *TestInfo) {
+	readOp := fuseops.ReadFileOp{Handle: 1}
+	t.ctx = context.WithValue(ti.Ctx, ReadOp, &readOp)
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = &gatedBucket{
+		Bucket:    fake.NewFakeBucket(&t.clock, "some_bucket"),
+		gate:      make(chan struct{}),
+		cancelled: make(chan struct{}, 100),
+	}
+
+	t.contents = make([]byte, prefetcherObjectSize)
+	for i := range t.contents {
+		t.contents[i] = byte(i)
+	}
+	o, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", t.contents)
+	AssertEq(nil, err)
+	t.object = storageutil.ConvertObjToMinObject(o)
+
+	t.buffers = NewBufferPool(100, 8)
+	t.pf = newPrefetcher(t.object, t.bucket, ReadAheadConfig{
+		ChunkSize:   100,
+		MaxInFlight: 4,
+		Buffers:     t.buffers,
+	})
+}
+
+func (t *PrefetcherTest) TearDown() {
+	t.pf.destroy()
+}
+
+func (t *PrefetcherTest) openGate() {
+	select {
+	case <-t.bucket.gate:
+	default:
+		close(t.bucket.gate)
+	}
+}
+
+// Read through the prefetcher, returning the number of bytes served from
+// prefetched chunks.
+func (t *PrefetcherTest) read(offset int64, size int) int {
+	p := make([]byte, size)
+	n, err := t.pf.read(t.ctx, p, offset)
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(t.contents[offset:offset+int64(n)], p[:n]))
+	t.pf.checkInvariants()
+	return n
+}
+
+func (t *PrefetcherTest) chunkOffsets() (offsets []int64) {
+	for _, c := range t.pf.chunks {
+		offsets = append(offsets, c.offset)
+	}
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *PrefetcherTest) ReadPattern_Sequential() {
+	var rp readPattern
+	rp.observe(0, 10)
+	rp.observe(10, 10)
+	ExpectFalse(rp.active())
+
+	rp.observe(20, 5)
+
+	ExpectTrue(rp.active())
+	ExpectEq(sequentialReadPattern, rp.kind)
+}
+
+func (t *PrefetcherTest) ReadPattern_Strided() {
+	var rp readPattern
+	rp.observe(0, 10)
+	rp.observe(100, 10)
+	ExpectFalse(rp.active())
+
+	rp.observe(200, 10)
+
+	ExpectTrue(rp.active())
+	ExpectEq(stridedReadPattern, rp.kind)
+	ExpectEq(100, rp.stride)
+}
+
+func (t *PrefetcherTest) ReadPattern_Random() {
+	var rp readPattern
+	rp.observe(0, 10)
+	rp.observe(10, 10)
+	rp.observe(20, 10)
+	AssertTrue(rp.active())
+
+	rp.observe(500, 10)
+	ExpectFalse(rp.active())
+	rp.observe(200, 10)
+	ExpectFalse(rp.active())
+}
+
+func (t *PrefetcherTest) NoReadAheadBeforePattern() {
+	t.openGate()
+
+	ExpectEq(0, t.read(0, 10))
+	ExpectEq(0, t.read(10, 10))
+
+	ExpectEq(0, t.bucket.readCount())
+	ExpectEq(0, len(t.pf.chunks))
+}
+
+func (t *PrefetcherTest) SequentialReadsServedFromChunks() {
+	t.openGate()
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+	AssertEq(fmt.Sprint([]int64{30}), fmt.Sprint(t.chunkOffsets()))
+
+	for offset := int64(30); offset < prefetcherObjectSize; offset += 10 {
+		AssertEq(10, t.read(offset, 10))
+	}
+
+	// Each chunk was fetched once.
+	ExpectEq(10, t.bucket.readCount())
+	ExpectEq(0, len(t.pf.chunks))
+}
+
+func (t *PrefetcherTest) StridedReadsServedFromChunks() {
+	t.openGate()
+	t.read(0, 10)
+	t.read(100, 10)
+	t.read(200, 10)
+	AssertEq(fmt.Sprint([]int64{300}), fmt.Sprint(t.chunkOffsets()))
+	AssertEq(10, len(t.pf.chunks[0].buf))
+
+	ExpectEq(10, t.read(300, 10))
+	ExpectEq(10, t.read(400, 10))
+}
+
+func (t *PrefetcherTest) WindowGrowsWhileReadsWait() {
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+	AssertEq(1, t.pf.window)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		t.openGate()
+	}()
+	ExpectEq(10, t.read(30, 10))
+
+	ExpectEq(2, t.pf.window)
+	ExpectEq(fmt.Sprint([]int64{30, 130}), fmt.Sprint(t.chunkOffsets()))
+}
+
+func (t *PrefetcherTest) WindowIsBoundedByMaxInFlight() {
+	t.pf.window = 4
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		t.openGate()
+	}()
+	ExpectEq(10, t.read(30, 10))
+
+	ExpectEq(4, t.pf.window)
+	ExpectEq(4, len(t.pf.chunks))
+}
+
+func (t *PrefetcherTest) ChunksAreBoundedByBuffers() {
+	t.pf.config.Buffers = NewBufferPool(100, 2)
+	t.pf.window = 4
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+
+	ExpectEq(fmt.Sprint([]int64{30, 130}), fmt.Sprint(t.chunkOffsets()))
+}
+
+func (t *PrefetcherTest) SeekCancelsChunks() {
+	t.pf.window = 2
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+	AssertEq(2, len(t.pf.chunks))
+
+	ExpectEq(0, t.read(700, 10))
+
+	ExpectEq(0, len(t.pf.chunks))
+	ExpectEq(1, t.pf.window)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-t.bucket.cancelled:
+		case <-time.After(5 * time.Second):
+			AddFailure("Chunk %d not cancelled", i)
+		}
+	}
+}
+
+func (t *PrefetcherTest) SkippedChunksShrinkWindow() {
+	t.openGate()
+	t.pf.window = 4
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+	AssertEq(fmt.Sprint([]int64{30, 130, 230, 330}), fmt.Sprint(t.chunkOffsets()))
+	for _, c := range t.pf.chunks {
+		<-c.done
+	}
+
+	// As when the kernel serves the reads in between from its page cache.
+	ExpectEq(10, t.read(250, 10))
+
+	ExpectEq(1, t.pf.window)
+	ExpectEq(fmt.Sprint([]int64{230, 330}), fmt.Sprint(t.chunkOffsets()))
+}
+
+func (t *PrefetcherTest) FailedChunkFallsBack() {
+	t.read(0, 10)
+	t.read(10, 10)
+	t.read(20, 10)
+	AssertEq(1, len(t.pf.chunks))
+
+	// Fail the fetch by cancelling it.
+	t.pf.chunks[0].cancel()
+	<-t.bucket.cancelled
+
+	ExpectEq(0, t.read(30, 10))
+	ExpectEq(fmt.Sprint([]int64{40}), fmt.Sprint(t.chunkOffsets()))
+}
+
+func (t *PrefetcherTest) RandomReaderServesAllReads() {
+	t.openGate()
+	rr := NewRandomReader(t.object, t.bucket, 1, nil, false, RandomReaderOptions{
+		ReadAhead: ReadAheadConfig{
+			ChunkSize:   64,
+			MaxInFlight: 4,
+			Buffers:     t.buffers,
+		},
+	})
+	defer rr.Destroy()
+
+	var offsets []int64
+	for offset := int64(0); offset < prefetcherObjectSize; offset += 30 {
+		offsets = append(offsets, offset)
+	}
+	offsets = append(offsets, 500, 10, 40, 70, 100)
+
+	for _, offset := range offsets {
+		p := make([]byte, 30)
+		n, _, err := rr.ReadAt(t.ctx, p, offset)
+		if offset+30 > prefetcherObjectSize {
+			AssertEq(prefetcherObjectSize-offset, n)
+		} else {
+			AssertEq(nil, err)
+			AssertEq(30, n)
+		}
+		rr.CheckInvariants()
+		ExpectTrue(bytes.Equal(t.contents[offset:offset+int64(n)], p[:n]), "offset %d", offset)
+	}
+}
@@ -216,3 +216,36 @@ func (b *prefixBucket) DeleteFolder(ctx context.Context, folderName string) (err
 	mFolderName := b.wrappedName(folderName)
 	return b.wrapped.DeleteFolder(ctx, mFolderName)
 }
+
+func (b *prefixBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	folder, err = b.wrapped.GetFolder(ctx, b.wrappedName(folderName))
+
+	// Modify the returned folder.
+	if folder != nil {
+		folder.Name = b.localName(folder.Name)
+	}
+
+	return
+}
+
+func (b *prefixBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	folder, err = b.wrapped.CreateFolder(ctx, b.wrappedName(folderName))
+
+	// Modify the returned folder.
+	if folder != nil {
+		folder.Name = b.localName(folder.Name)
+	}
+
+	return
+}
+
+func (b *prefixBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	folder, err = b.wrapped.RenameFolder(ctx, b.wrappedName(folderName), b.wrappedName(destinationFolderId))
+
+	// Modify the returned folder.
+	if folder != nil {
+		folder.Name = b.localName(folder.Name)
+	}
+
+	return
+}
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	blockcache "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/block"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
 	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
@@ -77,10 +78,22 @@ type RandomReader interface {
 	Destroy()
 }
 
+// RandomReaderOptions controls the optional ways in which a random reader
+// serves reads from GCS. The zero value disables them all.
+type RandomReaderOptions struct {
+	// Keep range requests in flight ahead of sequential and strided reads, as
+	// configured.
+	ReadAhead ReadAheadConfig
+
+	// If non-nil, serve small reads which don't continue the previous read from
+	// this cache.
+	BlockCache *blockcache.Cache
+}
+
 // NewRandomReader create a random reader for the supplied object record that
 // reads using the given bucket.
-func NewRandomReader(o *gcs.MinObject, bucket gcs.Bucket, sequentialReadSizeMb int32, fileCacheHandler *file.CacheHandler, cacheFileForRangeRead bool) RandomReader {
-	return &randomReader{
+func NewRandomReader(o *gcs.MinObject, bucket gcs.Bucket, sequentialReadSizeMb int32, fileCacheHandler *file.CacheHandler, cacheFileForRangeRead bool, opts RandomReaderOptions) RandomReader {
+	rr := &randomReader{
 		object:                o,
 		bucket:                bucket,
 		start:                 -1,
@@ -90,7 +103,12 @@ func NewRandomReader(o *gcs.MinObject, bucket gcs.Bucket, sequentialReadSizeMb i
 		sequentialReadSizeMb:  sequentialReadSizeMb,
 		fileCacheHandler:      fileCacheHandler,
 		cacheFileForRangeRead: cacheFileForRangeRead,
+		blockCache:            opts.BlockCache,
+	}
+	if opts.ReadAhead.Buffers != nil {
+		rr.prefetcher = newPrefetcher(o, bucket, opts.ReadAhead)
 	}
+	return rr
 }
 
 type randomReader struct {
@@ -129,6 +147,18 @@ type randomReader struct {
 	// fileCacheHandle is used to read from the cached location. It is created on the fly
 	// using fileCacheHandler for the given object and bucket.
 	fileCacheHandle *file.CacheHandle
+
+	// blockCache serves small reads from GCS which don't continue the previous
+	// read, such as index look-ups, from memory. This will be nil if the block
+	// cache is disabled.
+	blockCache *blockcache.Cache
+
+	// The offset at which the previous read ended, whichever way it was served.
+	lastReadEnd int64
+
+	// prefetcher serves reads from GCS which follow a pattern from chunks
+	// fetched ahead of them. This will be nil if read-ahead is disabled.
+	prefetcher *prefetcher
 }
 
 func (rr *randomReader) CheckInvariants() {
@@ -146,6 +176,10 @@ func (rr *randomReader) CheckInvariants() {
 	if rr.limit < 0 && rr.reader != nil {
 		panic(fmt.Sprintf("Unexpected non-nil reader with limit == %d", rr.limit))
 	}
+
+	if rr.prefetcher != nil {
+		rr.prefetcher.checkInvariants()
+	}
 }
 
 // tryReadingFromFileCache creates the cache handle first if it doesn't exist already
@@ -258,6 +292,9 @@ func (rr *randomReader) ReadAt(
 		return
 	}
 
+	continuesLastRead := offset == rr.lastReadEnd
+	rr.lastReadEnd = offset + int64(len(p))
+
 	// Note: If we are reading the file for the first time and read type is sequential
 	// then the file cache behavior is write-through i.e. data is first read from
 	// GCS, cached in file and then served from that file. But the cacheHit is
@@ -272,6 +309,45 @@ func (rr *randomReader) ReadAt(
 		return
 	}
 
+	if rr.prefetcher != nil {
+		var m int
+		m, err = rr.prefetcher.read(ctx, p, offset)
+		if err != nil {
+			err = fmt.Errorf("ReadAt: while reading ahead: %w", err)
+			return
+		}
+		n += m
+		p = p[m:]
+		offset += int64(m)
+		rr.totalReadBytes += uint64(m)
+
+		// The reader, if any, has been overtaken by the prefetched chunks. Throw
+		// it away without counting a seek, lest the reads look random.
+		if m > 0 && rr.reader != nil {
+			rr.reader.Close()
+			rr.reader = nil
+			rr.cancel = nil
+		}
+	}
+
+	// Reads which don't continue the previous one are likely to be small random
+	// reads, which the block cache can serve without throwing the reader away.
+	// Sequential reads carry on streaming.
+	if rr.blockCache != nil && !continuesLastRead && len(p) > 0 && int64(len(p)) <= rr.blockCache.BlockSize() {
+		var m int
+		m, err = rr.blockCache.Read(ctx, rr.bucket, rr.object, p, offset)
+		if err != nil {
+			err = fmt.Errorf("ReadAt: while reading from block cache: %w", err)
+			return
+		}
+		n += m
+		rr.totalReadBytes += uint64(m)
+		if m < len(p) {
+			err = io.EOF
+		}
+		return
+	}
+
 	for len(p) > 0 {
 		// Have we blown past the end of the object?
 		if offset >= int64(rr.object.Size) {
@@ -371,6 +447,10 @@ func (rr *randomReader) Object() (o *gcs.MinObject) {
 }
 
 func (rr *randomReader) Destroy() {
+	if rr.prefetcher != nil {
+		rr.prefetcher.destroy()
+	}
+
 	// Close out the reader, if we have one.
 	if rr.reader != nil {
 		err := rr.reader.Close()
@@ -27,6 +27,7 @@ import (
 	"testing/iotest"
 	"time"
 
+	blockcache "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/block"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/file/downloader"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
@@ -175,13 +176,14 @@ func (t *RandomReaderTest) SetUp(ti *TestInfo) {
 
 	t.cacheDir = path.Join(os.Getenv("HOME"), "cache/dir")
 	lruCache := lru.NewCache(CacheMaxSize)
-	t.jobManager = downloader.NewJobManager(lruCache, util.DefaultFilePerm, util.DefaultDirPerm, t.cacheDir, sequentialReadSizeInMb, &config.FileCacheConfig{
+	fileCacheConfig := &config.FileCacheConfig{
 		EnableCrcCheck: false,
-	})
-	t.cacheHandler = file.NewCacheHandler(lruCache, t.jobManager, t.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm)
+	}
+	t.jobManager = downloader.NewJobManager(lruCache, util.DefaultFilePerm, util.DefaultDirPerm, t.cacheDir, sequentialReadSizeInMb, fileCacheConfig, nil)
+	t.cacheHandler = file.NewCacheHandler(lruCache, t.jobManager, t.cacheDir, util.DefaultFilePerm, util.DefaultDirPerm, nil, fileCacheConfig)
 
 	// Set up the reader.
-	rr := NewRandomReader(t.object, t.bucket, sequentialReadSizeInMb, nil, false)
+	rr := NewRandomReader(t.object, t.bucket, sequentialReadSizeInMb, nil, false, RandomReaderOptions{})
 	t.rr.wrapped = rr.(*randomReader)
 }
 
@@ -590,7 +592,7 @@ func (t *RandomReaderTest) UpgradesSequentialReads_NoExistingReader() {
 	t.object.Size = 1 << 40
 	const readSize = 1 * MB
 	// Set up the custom randomReader.
-	rr := NewRandomReader(t.object, t.bucket, readSize/MB, nil, false)
+	rr := NewRandomReader(t.object, t.bucket, readSize/MB, nil, false, RandomReaderOptions{})
 	t.rr.wrapped = rr.(*randomReader)
 
 	// Simulate a previous exhausted reader that ended at the offset from which
@@ -623,7 +625,7 @@ func (t *RandomReaderTest) SequentialReads_NoExistingReader_requestedSizeGreater
 	const chunkSize = 1 * MB
 	const readSize = 3 * MB
 	// Set up the custom randomReader.
-	rr := NewRandomReader(t.object, t.bucket, chunkSize/MB, nil, false)
+	rr := NewRandomReader(t.object, t.bucket, chunkSize/MB, nil, false, RandomReaderOptions{})
 	t.rr.wrapped = rr.(*randomReader)
 	// Create readers for each chunk.
 	chunk1Reader := strings.NewReader(strings.Repeat("x", chunkSize))
@@ -670,7 +672,7 @@ func (t *RandomReaderTest) SequentialReads_existingReader_requestedSizeGreaterTh
 	const chunkSize = 1 * MB
 	const readSize = 3 * MB
 	// Set up the custom randomReader.
-	rr := NewRandomReader(t.object, t.bucket, chunkSize/MB, nil, false)
+	rr := NewRandomReader(t.object, t.bucket, chunkSize/MB, nil, false, RandomReaderOptions{})
 	t.rr.wrapped = rr.(*randomReader)
 	// Simulate an existing reader at the correct offset, which will be exhausted
 	// by the read below.
@@ -1198,6 +1200,46 @@ func (t *RandomReaderTest) Test_Destroy_NonNilCacheHandle() {
 	ExpectEq(nil, t.rr.wrapped.fileCacheHandle)
 }
 
+func (t *RandomReaderTest) Test_ReadAt_BlockCacheServesReadsNotContinuingLastRead() {
+	t.rr.wrapped.blockCache = blockcache.NewCache(100, 8)
+	testContent := testutil.GenerateRandomBytes(int(t.object.Size))
+	ExpectCall(t.bucket, "NewReader")(Any(), AllOf(rangeStartIs(8), rangeLimitIs(16))).
+		Times(1).
+		WillOnce(Return(getReadCloser(testContent[8:16]), nil))
+	ExpectCall(t.bucket, "Name")().WillRepeatedly(Return("test"))
+	buf := make([]byte, 4)
+
+	_, cacheHit, err := t.rr.ReadAt(buf, 10)
+
+	ExpectFalse(cacheHit)
+	AssertEq(nil, err)
+	ExpectTrue(reflect.DeepEqual(testContent[10:14], buf))
+
+	// Another read within the block is served from memory.
+	_, _, err = t.rr.ReadAt(buf, 9)
+
+	AssertEq(nil, err)
+	ExpectTrue(reflect.DeepEqual(testContent[9:13], buf))
+	ExpectEq(nil, t.rr.wrapped.reader)
+}
+
+func (t *RandomReaderTest) Test_ReadAt_BlockCacheLeavesSequentialReadsToStream() {
+	t.rr.wrapped.blockCache = blockcache.NewCache(100, 8)
+	testContent := testutil.GenerateRandomBytes(int(t.object.Size))
+	ExpectCall(t.bucket, "NewReader")(Any(), AllOf(rangeStartIs(0), rangeLimitIs(t.object.Size))).
+		Times(1).
+		WillOnce(Return(getReadCloser(testContent), nil))
+	buf := make([]byte, 4)
+
+	_, _, err := t.rr.ReadAt(buf, 0)
+	AssertEq(nil, err)
+	ExpectTrue(reflect.DeepEqual(testContent[0:4], buf))
+	_, _, err = t.rr.ReadAt(buf, 4)
+
+	AssertEq(nil, err)
+	ExpectTrue(reflect.DeepEqual(testContent[4:8], buf))
+}
+
 // TODO (raj-prince) - to add unit tests for failed scenario while reading via cache.
 // This requires mocking CacheHandle object, whose read method will return some unexpected
 // error.
@@ -0,0 +1,281 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// NewSnapshotBucket creates a read-only view on the wrapped bucket as it was at
+// the supplied time. Each object name resolves to the generation which was
+// live at that time, if any, and reads of an object read that generation.
+//
+// Generations which were overwritten or deleted since then can only be seen if
+// the wrapped bucket retains noncurrent generations, i.e. has object
+// versioning enabled. Collapsed runs are listed as they are now, so
+// directories may be listed which contain nothing at the time of the snapshot.
+//
+// Each name is resolved once the snapshot time has passed according to the
+// clock, as what was live then doesn't change afterwards.
+func NewSnapshotBucket(
+	snapshotTime time.Time,
+	clock timeutil.Clock,
+	wrapped gcs.Bucket) (b gcs.Bucket) {
+	b = &snapshotBucket{
+		snapshotTime: snapshotTime,
+		clock:        clock,
+		wrapped:      wrapped,
+		resolved:     make(map[string]*gcs.Object),
+	}
+
+	return
+}
+
+type snapshotBucket struct {
+	snapshotTime time.Time
+	clock        timeutil.Clock
+	wrapped      gcs.Bucket
+
+	mu sync.Mutex
+
+	// The generations live at the time of the snapshot of the names resolved
+	// after it, with nil for the names of no object at the time.
+	//
+	// GUARDED_BY(mu)
+	resolved map[string]*gcs.Object
+}
+
+// live returns true if the given generation of an object was live at the time
+// of the snapshot. At most one generation of an object is live at any time.
+func (b *snapshotBucket) live(o *gcs.Object) bool {
+	return !o.Created.After(b.snapshotTime) &&
+		(o.Deleted.IsZero() || o.Deleted.After(b.snapshotTime))
+}
+
+// readOnlyError returns the error for an attempt to modify the bucket.
+func (b *snapshotBucket) readOnlyError(op string) error {
+	return fmt.Errorf("%s: snapshot of bucket %q at %v is read-only: %w",
+		op, b.Name(), b.snapshotTime.Format(time.RFC3339), syscall.EROFS)
+}
+
+// findLiveGeneration returns the generation of the object with the given name
+// which was live at the time of the snapshot, or a *gcs.NotFoundError.
+func (b *snapshotBucket) findLiveGeneration(
+	ctx context.Context,
+	name string) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	o, ok := b.resolved[name]
+	b.mu.Unlock()
+
+	// Names are only resolved for good once the snapshot time has passed, as
+	// objects may still be created or replaced until then.
+	if !ok {
+		final := b.clock.Now().After(b.snapshotTime)
+		o, err = b.listLiveGeneration(ctx, name)
+		if err != nil {
+			return
+		}
+
+		if final {
+			b.mu.Lock()
+			b.resolved[name] = o
+			b.mu.Unlock()
+		}
+	}
+
+	if o == nil {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("object %q did not exist at %v", name, b.snapshotTime.Format(time.RFC3339)),
+		}
+	}
+	return
+}
+
+// listLiveGeneration lists the generations of the object with the given name
+// to find the one which was live at the time of the snapshot, if any.
+func (b *snapshotBucket) listLiveGeneration(
+	ctx context.Context,
+	name string) (o *gcs.Object, err error) {
+	// The generations of the object named by the prefix itself are listed
+	// first, hence stop as soon as a page ends with another name.
+	req := &gcs.ListObjectsRequest{
+		Prefix:    name,
+		Delimiter: "/",
+		Versions:  true,
+	}
+
+	for {
+		var listing *gcs.Listing
+		listing, err = b.wrapped.ListObjects(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %w", err)
+			return
+		}
+
+		for _, candidate := range listing.Objects {
+			if candidate.Name == name && b.live(candidate) {
+				o = candidate
+				return
+			}
+		}
+
+		n := len(listing.Objects)
+		if listing.ContinuationToken == "" || (n > 0 && listing.Objects[n-1].Name != name) {
+			break
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *snapshotBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+// Folders can't be looked up as of the snapshot time, hence directories are
+// found by listing objects, as in buckets without folders.
+func (b *snapshotBucket) BucketType() gcs.BucketType {
+	return gcs.NonHierarchical
+}
+
+func (b *snapshotBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	// Pin the generation, if the caller hasn't already.
+	mReq := new(gcs.ReadObjectRequest)
+	*mReq = *req
+	if mReq.Generation == 0 {
+		var o *gcs.Object
+		o, err = b.findLiveGeneration(ctx, req.Name)
+		if err != nil {
+			return
+		}
+		mReq.Generation = o.Generation
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, mReq)
+	return
+}
+
+func (b *snapshotBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	err = b.readOnlyError("CreateObject")
+	return
+}
+
+func (b *snapshotBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	err = b.readOnlyError("CopyObject")
+	return
+}
+
+func (b *snapshotBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	err = b.readOnlyError("ComposeObjects")
+	return
+}
+
+func (b *snapshotBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, e *gcs.ExtendedObjectAttributes, err error) {
+	o, err := b.findLiveGeneration(ctx, req.Name)
+	if err != nil {
+		return
+	}
+
+	m = storageutil.ConvertObjToMinObject(o)
+	if req.ReturnExtendedObjectAttributes {
+		e = storageutil.ConvertObjToExtendedObjectAttributes(o)
+	}
+
+	return
+}
+
+func (b *snapshotBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	// List all generations, so that the ones live at the time of the snapshot
+	// can be picked out. Listings of generations only lose the ones created
+	// after the snapshot.
+	mReq := new(gcs.ListObjectsRequest)
+	*mReq = *req
+	mReq.Versions = !req.SoftDeleted
+
+	l, err = b.wrapped.ListObjects(ctx, mReq)
+	if err != nil {
+		return
+	}
+
+	objects := l.Objects[:0]
+	for _, o := range l.Objects {
+		if b.live(o) || ((req.Versions || req.SoftDeleted) && !o.Created.After(b.snapshotTime)) {
+			objects = append(objects, o)
+		}
+	}
+	l.Objects = objects
+
+	return
+}
+
+func (b *snapshotBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	err = b.readOnlyError("UpdateObject")
+	return
+}
+
+func (b *snapshotBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.readOnlyError("DeleteObject")
+	return
+}
+
+func (b *snapshotBucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
+	err = b.readOnlyError("DeleteFolder")
+	return
+}
+
+func (b *snapshotBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	err = fmt.Errorf("GetFolder: snapshot of bucket %q has no folders", b.Name())
+	return
+}
+
+func (b *snapshotBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	err = b.readOnlyError("CreateFolder")
+	return
+}
+
+func (b *snapshotBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	err = b.readOnlyError("RenameFolder")
+	return
+}
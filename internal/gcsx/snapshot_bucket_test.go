@@ -0,0 +1,221 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestSnapshotBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type SnapshotBucketTest struct {
+	ctx     context.Context
+	clock   timeutil.SimulatedClock
+	wrapped gcs.Bucket
+}
+
+var _ SetUpInterface = &SnapshotBucketTest{}
+
+func init() { RegisterTestSuite(&SnapshotBucketTest{}) }
+
+func (t *SnapshotBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.wrapped = fake.NewVersionedFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *SnapshotBucketTest) createObject(name string, contents string) *gcs.Object {
+	o, err := storageutil.CreateObject(t.ctx, t.wrapped, name, []byte(contents))
+	AssertEq(nil, err)
+	t.clock.AdvanceTime(time.Minute)
+	return o
+}
+
+// snapshot returns a snapshot of the bucket as it is now.
+func (t *SnapshotBucketTest) snapshot() gcs.Bucket {
+	b := gcsx.NewSnapshotBucket(t.clock.Now(), &t.clock, t.wrapped)
+	t.clock.AdvanceTime(time.Minute)
+	return b
+}
+
+func (t *SnapshotBucketTest) read(b gcs.Bucket, name string) string {
+	contents, err := storageutil.ReadObject(t.ctx, b, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *SnapshotBucketTest) StatAndRead_Overwritten() {
+	o := t.createObject("foo", "taco")
+	b := t.snapshot()
+	t.createObject("foo", "burrito")
+
+	m, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, m.Generation)
+	ExpectEq(len("taco"), m.Size)
+	ExpectEq("taco", t.read(b, "foo"))
+}
+
+func (t *SnapshotBucketTest) StatAndRead_Deleted() {
+	t.createObject("foo", "taco")
+	b := t.snapshot()
+	err := t.wrapped.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	m, e, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{
+		Name:                           "foo",
+		ReturnExtendedObjectAttributes: true,
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", m.Name)
+	ExpectNe(nil, e)
+	ExpectEq("taco", t.read(b, "foo"))
+}
+
+func (t *SnapshotBucketTest) Stat_CreatedAfterSnapshot() {
+	b := t.snapshot()
+	t.createObject("foo", "taco")
+
+	_, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
+
+// listCountingBucket counts the calls to ListObjects.
+type listCountingBucket struct {
+	gcs.Bucket
+	listings int
+}
+
+func (b *listCountingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (*gcs.Listing, error) {
+	b.listings++
+	return b.Bucket.ListObjects(ctx, req)
+}
+
+func (t *SnapshotBucketTest) StatAndRead_ResolvesNamesOnce() {
+	t.createObject("foo", "taco")
+	wrapped := &listCountingBucket{Bucket: t.wrapped}
+	b := gcsx.NewSnapshotBucket(t.clock.Now(), &t.clock, wrapped)
+	t.clock.AdvanceTime(time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+		AssertEq(nil, err)
+		ExpectEq("taco", t.read(b, "foo"))
+
+		_, _, err = b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+		var notFoundErr *gcs.NotFoundError
+		ExpectTrue(errors.As(err, &notFoundErr))
+	}
+
+	ExpectEq(2, wrapped.listings)
+}
+
+func (t *SnapshotBucketTest) Stat_SnapshotTimeInFuture() {
+	b := gcsx.NewSnapshotBucket(t.clock.Now().Add(time.Hour), &t.clock, t.wrapped)
+	_, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	var notFoundErr *gcs.NotFoundError
+	AssertTrue(errors.As(err, &notFoundErr))
+
+	// The object is created before the snapshot time, hence is in the snapshot.
+	o := t.createObject("foo", "taco")
+	m, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, m.Generation)
+}
+
+func (t *SnapshotBucketTest) ListObjects() {
+	foo := t.createObject("foo", "taco")
+	t.createObject("bar", "enchilada")
+	t.createObject("dir/baz", "queso")
+	b := t.snapshot()
+	t.createObject("foo", "burrito")
+	t.createObject("qux", "salsa")
+	err := t.wrapped.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+
+	listing, err := b.ListObjects(t.ctx, &gcs.ListObjectsRequest{Delimiter: "/"})
+
+	AssertEq(nil, err)
+	AssertEq(2, len(listing.Objects))
+	ExpectEq("bar", listing.Objects[0].Name)
+	ExpectEq("foo", listing.Objects[1].Name)
+	ExpectEq(foo.Generation, listing.Objects[1].Generation)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("dir/"))
+}
+
+func (t *SnapshotBucketTest) ListObjects_Paginated() {
+	for _, name := range []string{"a", "a", "b", "b", "c"} {
+		t.createObject(name, name)
+	}
+	b := t.snapshot()
+	t.createObject("a", "a")
+
+	var names []string
+	req := &gcs.ListObjectsRequest{MaxResults: 2}
+	for {
+		listing, err := b.ListObjects(t.ctx, req)
+		AssertEq(nil, err)
+		for _, o := range listing.Objects {
+			names = append(names, o.Name)
+		}
+		if listing.ContinuationToken == "" {
+			break
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	ExpectThat(names, ElementsAre("a", "b", "c"))
+}
+
+func (t *SnapshotBucketTest) Mutations() {
+	t.createObject("foo", "taco")
+	b := t.snapshot()
+
+	_, err := storageutil.CreateObject(t.ctx, b, "bar", []byte("burrito"))
+	ExpectTrue(errors.Is(err, syscall.EROFS))
+	_, err = b.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+	ExpectTrue(errors.Is(err, syscall.EROFS))
+	err = b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	ExpectTrue(errors.Is(err, syscall.EROFS))
+
+	ExpectEq("taco", t.read(t.wrapped, "foo"))
+}
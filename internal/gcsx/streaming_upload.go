@@ -0,0 +1,235 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/net/context"
+)
+
+// errUploadAborted is the error with which the contents of an aborted
+// streaming upload end.
+var errUploadAborted = errors.New("streaming upload aborted")
+
+// StreamingUpload creates an object from contents supplied by successive calls
+// to Write, uploading them as they are written rather than staging them
+// locally. Write blocks while the upload catches up, so at most a chunk of the
+// upload is buffered in memory.
+//
+// Not safe for concurrent access.
+type StreamingUpload struct {
+	bucket gcs.Bucket
+	req    gcs.CreateObjectRequest
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	size   int64
+
+	// Closed when the upload has completed, after which o and err are set.
+	done chan struct{}
+	o    *gcs.Object
+	err  error
+
+	// The temporary object to which the contents are uploaded, if staged, once
+	// the upload has completed, until it is deleted.
+	tmp *gcs.Object
+}
+
+// NewStreamingUpload starts creating an object in the supplied bucket per the
+// supplied request, whose Contents are ignored. The upload continues in the
+// background until Finish or Abort is called.
+//
+// If tmpObjectPrefix is non-empty, the contents are staged in a temporary
+// object with a name beginning with it, which Finish composes into the object,
+// so that they aren't lost if the request's preconditions no longer hold by
+// then. See Staged.
+func NewStreamingUpload(
+	bucket gcs.Bucket,
+	req *gcs.CreateObjectRequest,
+	tmpObjectPrefix string) (u *StreamingUpload) {
+	// The upload outlives the file system op that started it, so it mustn't use
+	// the op's context.
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+
+	u = &StreamingUpload{
+		bucket: bucket,
+		req:    *req,
+		pw:     pw,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(u.done)
+		u.o, u.err = u.create(ctx, pr, tmpObjectPrefix)
+
+		// Unblock any pending or subsequent Write if the upload has failed before
+		// consuming all of the contents.
+		if u.err != nil {
+			pr.CloseWithError(u.err)
+		} else {
+			pr.Close()
+		}
+	}()
+
+	return
+}
+
+// Create the object, or the temporary object if tmpObjectPrefix is non-empty,
+// with the supplied contents.
+func (u *StreamingUpload) create(
+	ctx context.Context,
+	contents io.Reader,
+	tmpObjectPrefix string) (o *gcs.Object, err error) {
+	if tmpObjectPrefix == "" {
+		req := u.req
+		req.Contents = contents
+		o, err = u.bucket.CreateObject(ctx, &req)
+		return
+	}
+
+	namer := appendObjectCreator{prefix: tmpObjectPrefix}
+	tmpName, err := namer.chooseName()
+	if err != nil {
+		err = fmt.Errorf("chooseName: %w", err)
+		return
+	}
+
+	var zero int64
+	o, err = u.bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   tmpName,
+			GenerationPrecondition: &zero,
+			Contents:               contents,
+		})
+	if err != nil {
+		return
+	}
+
+	u.tmp = o
+	return
+}
+
+// Size returns the number of bytes written so far.
+func (u *StreamingUpload) Size() int64 {
+	return u.size
+}
+
+// Write appends the supplied data to the contents of the object. If the upload
+// has failed, returns its error.
+func (u *StreamingUpload) Write(p []byte) (n int, err error) {
+	n, err = u.pw.Write(p)
+	u.size += int64(n)
+	if err != nil {
+		err = fmt.Errorf("streaming upload: %w", err)
+	}
+
+	return
+}
+
+// Finish ends the contents of the object with the data written so far, and
+// waits for the upload to complete, returning the object created.
+//
+// If the contents are staged and the object can't be created for a
+// *gcs.PreconditionError, they are kept in the temporary object until Abort is
+// called. Otherwise the temporary object is deleted.
+func (u *StreamingUpload) Finish(ctx context.Context) (o *gcs.Object, err error) {
+	u.pw.Close()
+	<-u.done
+	u.cancel()
+
+	o, err = u.o, u.err
+	if err != nil || u.tmp == nil {
+		return
+	}
+
+	o, err = u.bucket.ComposeObjects(
+		ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName:                       u.req.Name,
+			DstGenerationPrecondition:     u.req.GenerationPrecondition,
+			DstMetaGenerationPrecondition: u.req.MetaGenerationPrecondition,
+			Sources: []gcs.ComposeSource{
+				gcs.ComposeSource{
+					Name:       u.tmp.Name,
+					Generation: u.tmp.Generation,
+				},
+			},
+			ContentType:        u.req.ContentType,
+			Metadata:           u.req.Metadata,
+			ContentLanguage:    u.req.ContentLanguage,
+			ContentEncoding:    u.req.ContentEncoding,
+			CacheControl:       u.req.CacheControl,
+			ContentDisposition: u.req.ContentDisposition,
+			CustomTime:         u.req.CustomTime,
+			EventBasedHold:     u.req.EventBasedHold,
+			StorageClass:       u.req.StorageClass,
+			Acl:                u.req.Acl,
+		})
+
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &preconditionErr) {
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("ComposeObjects: %w", err)
+	}
+
+	// The object has been created from the temporary object, which is no longer
+	// needed. Failing to delete it leaves it for the garbage collection.
+	u.deleteTmp(ctx)
+	return
+}
+
+// Staged returns the temporary object holding the contents after Finish has
+// failed for a *gcs.PreconditionError, or nil if there is none.
+func (u *StreamingUpload) Staged() *gcs.Object {
+	return u.tmp
+}
+
+// Abort cancels the upload, so that no object is created, and waits for it to
+// stop. Any staged contents are deleted.
+func (u *StreamingUpload) Abort() {
+	u.cancel()
+	u.pw.CloseWithError(errUploadAborted)
+	<-u.done
+
+	// The upload's context has been cancelled, so the temporary object is
+	// deleted with a new one.
+	u.deleteTmp(context.Background())
+}
+
+func (u *StreamingUpload) deleteTmp(ctx context.Context) {
+	if u.tmp == nil {
+		return
+	}
+
+	err := u.bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{
+			Name:       u.tmp.Name,
+			Generation: u.tmp.Generation,
+		})
+	if err != nil {
+		logger.Warnf("DeleteObject for staged contents %q: %v", u.tmp.Name, err)
+	}
+	u.tmp = nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestStreamingUpload(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type StreamingUploadTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &StreamingUploadTest{}
+
+func init() { RegisterTestSuite(&StreamingUploadTest{}) }
+
+func (t *StreamingUploadTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.bucket = fake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *StreamingUploadTest) start() *StreamingUpload {
+	return t.startStaged("")
+}
+
+func (t *StreamingUploadTest) startStaged(tmpObjectPrefix string) *StreamingUpload {
+	var zero int64
+	return NewStreamingUpload(t.bucket, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		GenerationPrecondition: &zero,
+		Metadata:               map[string]string{"a": "b"},
+	}, tmpObjectPrefix)
+}
+
+func (t *StreamingUploadTest) tmpObjects() (names []string) {
+	objects, _, err := storageutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: ".gcsfuse_tmp/"})
+	AssertEq(nil, err)
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	return
+}
+
+func (t *StreamingUploadTest) exists(name string) bool {
+	_, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return false
+	}
+	AssertEq(nil, err)
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *StreamingUploadTest) WriteThenFinish() {
+	u := t.start()
+
+	_, err := u.Write([]byte("taco"))
+	AssertEq(nil, err)
+	_, err = u.Write([]byte("s"))
+	AssertEq(nil, err)
+	ExpectEq(len("tacos"), u.Size())
+	ExpectFalse(t.exists("foo"))
+
+	o, err := u.Finish(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq(len("tacos"), o.Size)
+	ExpectEq("b", o.Metadata["a"])
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("tacos", string(contents))
+}
+
+func (t *StreamingUploadTest) FinishWithoutWriting() {
+	o, err := t.start().Finish(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(0, o.Size)
+}
+
+func (t *StreamingUploadTest) Abort() {
+	u := t.start()
+	_, err := u.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	u.Abort()
+
+	ExpectFalse(t.exists("foo"))
+}
+
+func (t *StreamingUploadTest) PreconditionFailure() {
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+	u := t.start()
+	_, err = u.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = u.Finish(t.ctx)
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+}
+
+func (t *StreamingUploadTest) WriteAfterUploadFailed() {
+	t.bucket = &failingCreateBucket{Bucket: t.bucket}
+	u := t.start()
+
+	// The upload fails without consuming anything, so the first write fails.
+	_, err := u.Write([]byte("taco"))
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+	_, err = u.Finish(t.ctx)
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *StreamingUploadTest) StagedWriteThenFinish() {
+	u := t.startStaged(".gcsfuse_tmp/")
+	_, err := u.Write([]byte("tacos"))
+	AssertEq(nil, err)
+
+	o, err := u.Finish(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("b", o.Metadata["a"])
+	ExpectEq(nil, u.Staged())
+	ExpectThat(t.tmpObjects(), ElementsAre())
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("tacos", string(contents))
+}
+
+func (t *StreamingUploadTest) StagedPreconditionFailure() {
+	_, err := storageutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+	u := t.startStaged(".gcsfuse_tmp/")
+	_, err = u.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = u.Finish(t.ctx)
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+	staged := u.Staged()
+	AssertNe(nil, staged)
+	ExpectThat(t.tmpObjects(), ElementsAre(staged.Name))
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, staged.Name)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	contents, err = storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+
+	u.Abort()
+
+	ExpectEq(nil, u.Staged())
+	ExpectThat(t.tmpObjects(), ElementsAre())
+}
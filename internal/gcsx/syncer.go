@@ -44,6 +44,23 @@ type Syncer interface {
 		content TempFile) (o *gcs.Object, err error)
 }
 
+// SyncerOptions controls the optional ways in which a syncer writes out files.
+// The zero value disables them all.
+type SyncerOptions struct {
+	// Write out the full contents of files at least ParallelUploads.Threshold
+	// bytes long as parallel composite uploads: parts of the file are uploaded
+	// concurrently as temporary blobs, then composed into the object.
+	ParallelUploads ParallelUploadConfig
+
+	// Write out files at least PartialUpdates.Threshold bytes long by partial
+	// updates: the file is composed from blocks kept as objects with names
+	// beginning with BlockSetPrefix, of which only those containing modified
+	// ranges are uploaded again when the file is next written out. The blocks
+	// of objects that are deleted or overwritten by other means are left for
+	// the garbage collection to delete.
+	PartialUpdates PartialUpdateConfig
+}
+
 // NewSyncer creates a syncer that syncs into the supplied bucket.
 //
 // When the source object has been changed only by appending, and the source
@@ -56,6 +73,7 @@ type Syncer interface {
 func NewSyncer(
 	appendThreshold int64,
 	tmpObjectPrefix string,
+	opts SyncerOptions,
 	bucket gcs.Bucket) (os Syncer) {
 	// Create the object creators.
 	fullCreator := &fullObjectCreator{
@@ -66,8 +84,34 @@ func NewSyncer(
 		tmpObjectPrefix,
 		bucket)
 
+	var compositeCreator objectCreator
+	if opts.ParallelUploads.Threshold > 0 {
+		compositeCreator = newCompositeObjectCreator(
+			tmpObjectPrefix,
+			opts.ParallelUploads.PartSize,
+			opts.ParallelUploads.Parallelism,
+			bucket)
+	}
+
+	var blockCreator objectCreator
+	if opts.PartialUpdates.Threshold > 0 {
+		blockCreator = newBlockObjectCreator(
+			BlockSetPrefix,
+			tmpObjectPrefix,
+			opts.PartialUpdates.BlockSize,
+			opts.PartialUpdates.Parallelism,
+			bucket)
+	}
+
 	// And the syncer.
-	os = newSyncer(appendThreshold, fullCreator, appendCreator)
+	os = newSyncer(
+		appendThreshold,
+		opts.ParallelUploads.Threshold,
+		opts.PartialUpdates.Threshold,
+		fullCreator,
+		appendCreator,
+		compositeCreator,
+		blockCreator)
 
 	return
 }
@@ -147,7 +191,7 @@ type objectCreator interface {
 }
 
 // Create a syncer that stats the mutable content to see if it's dirty before
-// calling through to one of two object creators if the content is dirty:
+// calling through to one of three object creators if the content is dirty:
 //
 //   - fullCreator accepts the source object and the full contents with which it
 //     should be overwritten.
@@ -155,27 +199,85 @@ type objectCreator interface {
 //   - appendCreator accepts the source object and the contents that should be
 //     "appended" to it.
 //
+//   - compositeCreator, which may be nil, is used in place of fullCreator when
+//     the content is at least compositeThreshold bytes long. It accepts the
+//     full contents as an *io.SectionReader.
+//
+//   - blockCreator, which may be nil, is used in place of all of the above when
+//     the content is at least blockThreshold bytes long. It accepts the full
+//     contents as the TempFile itself, so that it can tell which ranges have
+//     been modified.
+//
 // appendThreshold controls the source object length at which we consider it
 // worthwhile to make the append optimization. It should be set to a value on
 // the order of the bandwidth to GCS times three times the round trip latency
 // to GCS (for a small create, a compose, and a delete).
 func newSyncer(
 	appendThreshold int64,
+	compositeThreshold int64,
+	blockThreshold int64,
 	fullCreator objectCreator,
-	appendCreator objectCreator) (os Syncer) {
+	appendCreator objectCreator,
+	compositeCreator objectCreator,
+	blockCreator objectCreator) (os Syncer) {
 	os = &syncer{
-		appendThreshold: appendThreshold,
-		fullCreator:     fullCreator,
-		appendCreator:   appendCreator,
+		appendThreshold:    appendThreshold,
+		compositeThreshold: compositeThreshold,
+		blockThreshold:     blockThreshold,
+		fullCreator:        fullCreator,
+		appendCreator:      appendCreator,
+		compositeCreator:   compositeCreator,
+		blockCreator:       blockCreator,
 	}
 
 	return
 }
 
 type syncer struct {
-	appendThreshold int64
-	fullCreator     objectCreator
-	appendCreator   objectCreator
+	appendThreshold    int64
+	compositeThreshold int64
+	blockThreshold     int64
+	fullCreator        objectCreator
+	appendCreator      objectCreator
+	compositeCreator   objectCreator
+	blockCreator       objectCreator
+}
+
+// Whether content of the supplied size is written out by blockCreator.
+func (os *syncer) updatesBlocks(size int64) bool {
+	return os.blockCreator != nil && size >= os.blockThreshold
+}
+
+// Write out the full contents of the temp file, which is size bytes long.
+func (os *syncer) createFull(
+	ctx context.Context,
+	objectName string,
+	srcObject *gcs.Object,
+	mtime *time.Time,
+	size int64,
+	content TempFile) (o *gcs.Object, err error) {
+	// Content.Stat() seeks the current position to end of file. Seek it back
+	// to beginning of the file.
+	_, err = content.Seek(0, 0)
+	if err != nil {
+		err = fmt.Errorf("Seek: %w", err)
+		return
+	}
+
+	if os.updatesBlocks(size) {
+		return os.blockCreator.Create(ctx, objectName, srcObject, mtime, content)
+	}
+
+	if os.compositeCreator != nil && size >= os.compositeThreshold {
+		return os.compositeCreator.Create(
+			ctx,
+			objectName,
+			srcObject,
+			mtime,
+			io.NewSectionReader(content, 0, size))
+	}
+
+	return os.fullCreator.Create(ctx, objectName, srcObject, mtime, content)
 }
 
 func (os *syncer) SyncObject(
@@ -193,14 +295,7 @@ func (os *syncer) SyncObject(
 	// Local files are not present on GCS, hence only fullCreator is
 	// invoked and append flow is never triggered.
 	if srcObject == nil {
-		// Content.Stat() seeks the current position to end of file. Seek it back
-		// to beginning of the file.
-		_, err = content.Seek(0, 0)
-		if err != nil {
-			err = fmt.Errorf("error in seeking: %w", err)
-			return
-		}
-		return os.fullCreator.Create(ctx, objectName, srcObject, sr.Mtime, content)
+		return os.createFull(ctx, objectName, srcObject, sr.Mtime, sr.Size, content)
 	}
 
 	// Make sure the dirty threshold makes sense.
@@ -230,8 +325,11 @@ func (os *syncer) SyncObject(
 
 	// Otherwise, we need to create a new generation. If the source object is
 	// long enough, hasn't been dirtied, and has a low enough component count,
-	// then we can make the optimization of not rewriting its contents.
-	if srcSize >= os.appendThreshold &&
+	// then we can make the optimization of not rewriting its contents. Partial
+	// updates rewrite at most one block of it in that case anyway, and unlike
+	// appending keep track of its blocks for next time, so are preferred.
+	if !os.updatesBlocks(sr.Size) &&
+		srcSize >= os.appendThreshold &&
 		sr.DirtyThreshold == srcSize &&
 		srcObject.ComponentCount < gcs.MaxComponentCount {
 		_, err = content.Seek(srcSize, 0)
@@ -242,13 +340,7 @@ func (os *syncer) SyncObject(
 
 		o, err = os.appendCreator.Create(ctx, objectName, srcObject, sr.Mtime, content)
 	} else {
-		_, err = content.Seek(0, 0)
-		if err != nil {
-			err = fmt.Errorf("Seek: %w", err)
-			return
-		}
-
-		o, err = os.fullCreator.Create(ctx, objectName, srcObject, sr.Mtime, content)
+		o, err = os.createFull(ctx, objectName, srcObject, sr.Mtime, sr.Size, content)
 	}
 
 	// Deal with errors.
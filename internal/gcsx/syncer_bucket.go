@@ -21,6 +21,15 @@ import (
 type SyncerBucket struct {
 	gcs.Bucket
 	Syncer
+
+	// The prefix, such as that given by --only-dir, which the names of objects
+	// in Bucket leave out, or empty if they are the names in the underlying
+	// bucket.
+	ObjectPrefix string
+
+	// The prefix of the names of temporary objects, such as those written by
+	// Syncer, which are left for the garbage collection if they can't be deleted.
+	TmpObjectPrefix string
 }
 
 // NewSyncerBucket creates a SyncerBucket, which can be used either as
@@ -30,6 +39,6 @@ func NewSyncerBucket(
 	tmpObjectPrefix string,
 	bucket gcs.Bucket,
 ) SyncerBucket {
-	syncer := NewSyncer(appendThreshold, tmpObjectPrefix, bucket)
-	return SyncerBucket{bucket, syncer}
+	syncer := NewSyncer(appendThreshold, tmpObjectPrefix, SyncerOptions{}, bucket)
+	return SyncerBucket{Bucket: bucket, Syncer: syncer, TmpObjectPrefix: tmpObjectPrefix}
 }
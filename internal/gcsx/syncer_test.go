@@ -272,8 +272,10 @@ const appendThreshold = int64(len(srcObjectContents))
 type SyncerTest struct {
 	ctx context.Context
 
-	fullCreator   fakeObjectCreator
-	appendCreator fakeObjectCreator
+	fullCreator      fakeObjectCreator
+	appendCreator    fakeObjectCreator
+	compositeCreator fakeObjectCreator
+	blockCreator     fakeObjectCreator
 
 	bucket gcs.Bucket
 	syncer Syncer
@@ -295,8 +297,12 @@ func (t *SyncerTest) SetUp(ti *TestInfo) {
 	t.bucket = fake.NewFakeBucket(&t.clock, "some_bucket")
 	t.syncer = newSyncer(
 		appendThreshold,
+		0,
+		0,
 		&t.fullCreator,
-		&t.appendCreator)
+		&t.appendCreator,
+		nil,
+		nil)
 
 	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
 
@@ -321,6 +327,34 @@ func (t *SyncerTest) SetUp(ti *TestInfo) {
 	// Return errors from the fakes by default.
 	t.fullCreator.err = errors.New("Fake error")
 	t.appendCreator.err = errors.New("Fake error")
+	t.compositeCreator.err = errors.New("Fake error")
+	t.blockCreator.err = errors.New("Fake error")
+}
+
+// Recreate the syncer with a composite creator for content at least
+// compositeThreshold bytes long.
+func (t *SyncerTest) useCompositeCreator(compositeThreshold int64) {
+	t.syncer = newSyncer(
+		appendThreshold,
+		compositeThreshold,
+		0,
+		&t.fullCreator,
+		&t.appendCreator,
+		&t.compositeCreator,
+		nil)
+}
+
+// Recreate the syncer with composite and block creators for content at least
+// compositeThreshold and blockThreshold bytes long respectively.
+func (t *SyncerTest) useBlockCreator(compositeThreshold int64, blockThreshold int64) {
+	t.syncer = newSyncer(
+		appendThreshold,
+		compositeThreshold,
+		blockThreshold,
+		&t.fullCreator,
+		&t.appendCreator,
+		&t.compositeCreator,
+		&t.blockCreator)
 }
 
 func (t *SyncerTest) call() (o *gcs.Object, err error) {
@@ -414,8 +448,12 @@ func (t *SyncerTest) SourceTooShortForAppend() {
 	// Recreate the syncer with a higher append threshold.
 	t.syncer = newSyncer(
 		int64(len(srcObjectContents)+1),
+		0,
+		0,
 		&t.fullCreator,
-		&t.appendCreator)
+		&t.appendCreator,
+		nil,
+		nil)
 
 	// Extend the length of the content.
 	err = t.content.Truncate(int64(len(srcObjectContents) + 1))
@@ -479,6 +517,132 @@ func (t *SyncerTest) CallsFullCreator() {
 	ExpectEq(srcObjectContents[:2], string(t.fullCreator.contents))
 }
 
+func (t *SyncerTest) CallsCompositeCreatorAtThreshold() {
+	var err error
+	t.useCompositeCreator(2)
+
+	// Ready the content.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	mtime := time.Now().Add(123 * time.Second)
+	t.content.SetMtime(mtime)
+
+	// Call
+	t.call()
+
+	ExpectFalse(t.fullCreator.called)
+	ExpectFalse(t.appendCreator.called)
+	AssertTrue(t.compositeCreator.called)
+	ExpectEq(t.srcObject, t.compositeCreator.srcObject)
+	ExpectThat(t.compositeCreator.mtime, timeutil.TimeEq(mtime))
+	ExpectEq(srcObjectContents[:2], string(t.compositeCreator.contents))
+}
+
+func (t *SyncerTest) CallsFullCreatorBelowCompositeThreshold() {
+	var err error
+	t.useCompositeCreator(3)
+
+	// Ready the content.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	t.call()
+
+	ExpectTrue(t.fullCreator.called)
+	ExpectFalse(t.compositeCreator.called)
+}
+
+func (t *SyncerTest) CallsCompositeCreatorWhenSrcObjectIsNil() {
+	t.useCompositeCreator(1)
+
+	_, _ = t.syncer.SyncObject(t.ctx, t.srcObject.Name, nil, t.content)
+
+	ExpectFalse(t.fullCreator.called)
+	AssertTrue(t.compositeCreator.called)
+	ExpectEq(srcObjectContents, string(t.compositeCreator.contents))
+}
+
+func (t *SyncerTest) PrefersAppendToComposite() {
+	var err error
+	t.useCompositeCreator(1)
+
+	// Extend the length of the content.
+	err = t.content.Truncate(int64(len(srcObjectContents) + 1))
+	AssertEq(nil, err)
+
+	// The append creator should be called.
+	t.call()
+
+	ExpectTrue(t.appendCreator.called)
+	ExpectFalse(t.compositeCreator.called)
+}
+
+func (t *SyncerTest) CallsBlockCreatorAtThreshold() {
+	var err error
+	t.useBlockCreator(1, 2)
+
+	// Ready the content.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	mtime := time.Now().Add(123 * time.Second)
+	t.content.SetMtime(mtime)
+
+	// Call
+	t.call()
+
+	ExpectFalse(t.fullCreator.called)
+	ExpectFalse(t.appendCreator.called)
+	ExpectFalse(t.compositeCreator.called)
+	AssertTrue(t.blockCreator.called)
+	ExpectEq(t.srcObject, t.blockCreator.srcObject)
+	ExpectThat(t.blockCreator.mtime, timeutil.TimeEq(mtime))
+	ExpectEq(srcObjectContents[:2], string(t.blockCreator.contents))
+}
+
+func (t *SyncerTest) CallsCompositeCreatorBelowBlockThreshold() {
+	var err error
+	t.useBlockCreator(1, 3)
+
+	// Ready the content.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	t.call()
+
+	ExpectTrue(t.compositeCreator.called)
+	ExpectFalse(t.blockCreator.called)
+}
+
+func (t *SyncerTest) CallsBlockCreatorWhenSrcObjectIsNil() {
+	t.useBlockCreator(1, 1)
+
+	_, _ = t.syncer.SyncObject(t.ctx, t.srcObject.Name, nil, t.content)
+
+	ExpectFalse(t.compositeCreator.called)
+	AssertTrue(t.blockCreator.called)
+	ExpectEq(srcObjectContents, string(t.blockCreator.contents))
+}
+
+func (t *SyncerTest) PrefersBlocksToAppend() {
+	var err error
+	t.useBlockCreator(1, 1)
+
+	// Extend the length of the content.
+	err = t.content.Truncate(int64(len(srcObjectContents) + 1))
+	AssertEq(nil, err)
+
+	// The block creator should be called.
+	t.call()
+
+	ExpectFalse(t.appendCreator.called)
+	AssertTrue(t.blockCreator.called)
+	ExpectEq(srcObjectContents+"\x00", string(t.blockCreator.contents))
+}
+
 func (t *SyncerTest) FullCreatorFails() {
 	var err error
 	t.fullCreator.err = errors.New("taco")
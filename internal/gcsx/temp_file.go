@@ -26,7 +26,7 @@ import (
 )
 
 // TempFile is a temporary file that keeps track of the lowest offset at which
-// it has been modified.
+// it has been modified, and of the ranges of bytes that have been modified.
 //
 // Not safe for concurrent access.
 type TempFile interface {
@@ -46,10 +46,20 @@ type TempFile interface {
 	// the seek position.
 	Stat() (sr StatResult, err error)
 
+	// Return the ranges of bytes that may have been modified from the original
+	// content, in order and not overlapping or adjacent. Bytes outside them,
+	// and below both the original and current sizes, are unmodified.
+	DirtyRanges() []ByteRange
+
 	// Explicitly set the mtime that will return in stat results. This will stick
 	// until another method that modifies the file is called.
 	SetMtime(mtime time.Time)
 
+	// Treat the current content as the original content, e.g. once it has been
+	// written out as a new generation of the object, so that none of it is
+	// dirty and the mtime is nil.
+	Rebase() (err error)
+
 	// Throw away the resources used by the temporary file. The object must not
 	// be used again.
 	Destroy()
@@ -74,6 +84,12 @@ type StatResult struct {
 	Mtime *time.Time
 }
 
+// ByteRange is a [Start, Limit) range of bytes of a temp file.
+type ByteRange struct {
+	Start int64
+	Limit int64
+}
+
 // NewTempFile creates a temp file whose initial contents are given by the
 // supplied reader. dir is a directory on whose file system the inode will live,
 // or the system default temporary location if empty.
@@ -142,6 +158,35 @@ func RecoverCacheFile(
 	return
 }
 
+// RecoverDirtyFile returns a temp file wrapping the supplied file, which is
+// size bytes long and holds contents last modified at mtime, of which the first
+// dirtyThreshold bytes are unmodified from the original content.
+//
+// REQUIRES: dirtyThreshold <= size
+func RecoverDirtyFile(
+	f *os.File,
+	dirtyThreshold int64,
+	size int64,
+	mtime time.Time,
+	clock timeutil.Clock) (tf TempFile) {
+	recovered := &tempFile{
+		state:          fileDirty,
+		clock:          clock,
+		f:              f,
+		dirtyThreshold: dirtyThreshold,
+		mtime:          &mtime,
+	}
+
+	// Only the dirty threshold is known, so consider everything after it to be
+	// modified.
+	if dirtyThreshold < size {
+		recovered.dirtyRanges = []ByteRange{{dirtyThreshold, size}}
+	}
+
+	tf = recovered
+	return
+}
+
 type fileState string
 
 const (
@@ -173,6 +218,14 @@ type tempFile struct {
 	// INVARIANT: Stat().DirtyThreshold <= Stat().Size
 	dirtyThreshold int64
 
+	// The ranges of bytes that have been modified from the initial contents,
+	// in order. Shrinking the file cuts them short rather than adding to them.
+	//
+	// INVARIANT: Ranges are non-empty, and neither overlap nor touch
+	// INVARIANT: For each range r, dirtyThreshold <= r.Start
+	// INVARIANT: For each range r, r.Limit <= Stat().Size
+	dirtyRanges []ByteRange
+
 	// The time at which a method that modifies our contents was last called, or
 	// nil if never.
 	//
@@ -216,6 +269,19 @@ func (tf *tempFile) CheckInvariants() {
 	if tf.mtime == nil && sr.DirtyThreshold != sr.Size {
 		panic(fmt.Errorf("Mismatch: %d vs. %d", sr.DirtyThreshold, sr.Size))
 	}
+
+	for i, r := range tf.dirtyRanges {
+		// INVARIANT: Ranges are non-empty, and neither overlap nor touch
+		if r.Start >= r.Limit || (i > 0 && tf.dirtyRanges[i-1].Limit >= r.Start) {
+			panic(fmt.Errorf("Bad dirty ranges: %v", tf.dirtyRanges))
+		}
+
+		// INVARIANT: For each range r, dirtyThreshold <= r.Start
+		// INVARIANT: For each range r, r.Limit <= Stat().Size
+		if r.Start < tf.dirtyThreshold || r.Limit > sr.Size {
+			panic(fmt.Errorf("Dirty range %v outside [%d, %d)", r, tf.dirtyThreshold, sr.Size))
+		}
+	}
 }
 
 func (tf *tempFile) Destroy() {
@@ -277,6 +343,9 @@ func (tf *tempFile) WriteAt(p []byte, offset int64) (int, error) {
 
 	// Update our state regarding being dirty.
 	tf.dirtyThreshold = minInt64(tf.dirtyThreshold, offset)
+	if len(p) > 0 {
+		tf.addDirtyRange(ByteRange{offset, offset + int64(len(p))})
+	}
 
 	tf.state = fileDirty
 
@@ -293,8 +362,19 @@ func (tf *tempFile) Truncate(n int64) error {
 		return fmt.Errorf("Cannot Truncate incomplete file: %w", err)
 	}
 
-	// Update our state regarding being dirty.
+	// Update our state regarding being dirty. Bytes added by extending the
+	// file are modified, even though they read as zeroes.
+	size, err := tf.f.Seek(0, 2)
+	if err != nil {
+		return fmt.Errorf("Seek: %w", err)
+	}
+
 	tf.dirtyThreshold = minInt64(tf.dirtyThreshold, n)
+	if n > size {
+		tf.addDirtyRange(ByteRange{size, n})
+	} else {
+		tf.cutDirtyRanges(n)
+	}
 
 	tf.state = fileDirty
 
@@ -305,10 +385,33 @@ func (tf *tempFile) Truncate(n int64) error {
 	return tf.f.Truncate(n)
 }
 
+func (tf *tempFile) DirtyRanges() []ByteRange {
+	return append([]ByteRange(nil), tf.dirtyRanges...)
+}
+
 func (tf *tempFile) SetMtime(mtime time.Time) {
 	tf.mtime = &mtime
 }
 
+func (tf *tempFile) Rebase() error {
+	err := tf.ensureComplete()
+	if err != nil {
+		return fmt.Errorf("Cannot Rebase incomplete file: %w", err)
+	}
+
+	size, err := tf.f.Seek(0, 2)
+	if err != nil {
+		return fmt.Errorf("Seek: %w", err)
+	}
+
+	tf.state = fileComplete
+	tf.dirtyThreshold = size
+	tf.dirtyRanges = nil
+	tf.mtime = nil
+
+	return nil
+}
+
 func (tf *tempFile) Name() string {
 	return tf.f.Name()
 }
@@ -325,6 +428,39 @@ func minInt64(a int64, b int64) int64 {
 	return b
 }
 
+// Add the supplied non-empty range to the dirty ranges, merging it with any
+// that it overlaps or touches.
+func (tf *tempFile) addDirtyRange(r ByteRange) {
+	var merged []ByteRange
+
+	i := 0
+	for ; i < len(tf.dirtyRanges) && tf.dirtyRanges[i].Limit < r.Start; i++ {
+		merged = append(merged, tf.dirtyRanges[i])
+	}
+
+	for ; i < len(tf.dirtyRanges) && tf.dirtyRanges[i].Start <= r.Limit; i++ {
+		r.Start = minInt64(r.Start, tf.dirtyRanges[i].Start)
+		r.Limit = max(r.Limit, tf.dirtyRanges[i].Limit)
+	}
+
+	merged = append(merged, r)
+	tf.dirtyRanges = append(merged, tf.dirtyRanges[i:]...)
+}
+
+// Cut the dirty ranges short at the supplied new size of the file.
+func (tf *tempFile) cutDirtyRanges(n int64) {
+	cut := tf.dirtyRanges[:0]
+	for _, d := range tf.dirtyRanges {
+		if d.Start >= n {
+			break
+		}
+		d.Limit = minInt64(d.Limit, n)
+		cut = append(cut, d)
+	}
+
+	tf.dirtyRanges = cut
+}
+
 const (
 	minCopyLength = 64 * 1024 * 1024 // 64 MB
 )
@@ -75,6 +75,12 @@ func (tf *checkingTempFile) Stat() (gcsx.StatResult, error) {
 	return tf.wrapped.Stat()
 }
 
+func (tf *checkingTempFile) DirtyRanges() []gcsx.ByteRange {
+	tf.wrapped.CheckInvariants()
+	defer tf.wrapped.CheckInvariants()
+	return tf.wrapped.DirtyRanges()
+}
+
 func (tf *checkingTempFile) Read(b []byte) (int, error) {
 	tf.wrapped.CheckInvariants()
 	defer tf.wrapped.CheckInvariants()
@@ -111,6 +117,12 @@ func (tf *checkingTempFile) SetMtime(mtime time.Time) {
 	tf.wrapped.SetMtime(mtime)
 }
 
+func (tf *checkingTempFile) Rebase() error {
+	tf.wrapped.CheckInvariants()
+	defer tf.wrapped.CheckInvariants()
+	return tf.wrapped.Rebase()
+}
+
 func (tf *checkingTempFile) Destroy() {
 	tf.wrapped.CheckInvariants()
 	tf.wrapped.Destroy()
@@ -162,6 +174,7 @@ func (t *TempFileTest) Stat_InitialState() {
 	ExpectEq(initialContentSize, sr.Size)
 	ExpectEq(initialContentSize, sr.DirtyThreshold)
 	ExpectEq(nil, sr.Mtime)
+	ExpectThat(t.tf.DirtyRanges(), ElementsAre())
 }
 
 func (t *TempFileTest) ReadAt() {
@@ -250,3 +263,82 @@ func (t *TempFileTest) SetMtime() {
 	AssertEq(nil, err)
 	ExpectThat(sr.Mtime, Pointee(timeutil.TimeEq(mtime)))
 }
+
+func (t *TempFileTest) Rebase() {
+	_, err := t.tf.WriteAt([]byte("xyz"), 9)
+	AssertEq(nil, err)
+
+	err = t.tf.Rebase()
+
+	AssertEq(nil, err)
+	sr, err := t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize+1, sr.Size)
+	ExpectEq(initialContentSize+1, sr.DirtyThreshold)
+	ExpectEq(nil, sr.Mtime)
+	ExpectThat(t.tf.DirtyRanges(), ElementsAre())
+
+	// Later modifications are dirty relative to the rebased content.
+	_, err = t.tf.WriteAt([]byte("!"), 2)
+	AssertEq(nil, err)
+	sr, err = t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(2, sr.DirtyThreshold)
+	ExpectThat(t.tf.DirtyRanges(), DeepEquals([]gcsx.ByteRange{{Start: 2, Limit: 3}}))
+}
+
+func (t *TempFileTest) DirtyRanges_Writes() {
+	writes := []struct {
+		offset int64
+		data   string
+	}{
+		{8, "x"},
+		{1, "fo"},
+		{3, "o"},
+		{6, "x"},
+		{10, "xyz"},
+	}
+	for _, w := range writes {
+		_, err := t.tf.WriteAt([]byte(w.data), w.offset)
+		AssertEq(nil, err)
+	}
+
+	ExpectThat(
+		t.tf.DirtyRanges(),
+		DeepEquals([]gcsx.ByteRange{
+			{Start: 1, Limit: 4},
+			{Start: 6, Limit: 7},
+			{Start: 8, Limit: 9},
+			{Start: 10, Limit: 13},
+		}))
+
+	// A write spanning several ranges merges them.
+	_, err := t.tf.WriteAt([]byte("xyzzy"), 5)
+	AssertEq(nil, err)
+
+	ExpectThat(
+		t.tf.DirtyRanges(),
+		DeepEquals([]gcsx.ByteRange{
+			{Start: 1, Limit: 4},
+			{Start: 5, Limit: 13},
+		}))
+}
+
+func (t *TempFileTest) DirtyRanges_Truncate() {
+	_, err := t.tf.WriteAt([]byte("xyz"), 4)
+	AssertEq(nil, err)
+
+	// Shrinking cuts the ranges short.
+	err = t.tf.Truncate(5)
+	AssertEq(nil, err)
+	ExpectThat(t.tf.DirtyRanges(), DeepEquals([]gcsx.ByteRange{{Start: 4, Limit: 5}}))
+
+	// Extending adds to them.
+	err = t.tf.Truncate(8)
+	AssertEq(nil, err)
+	ExpectThat(t.tf.DirtyRanges(), DeepEquals([]gcsx.ByteRange{{Start: 4, Limit: 8}}))
+
+	err = t.tf.Truncate(1)
+	AssertEq(nil, err)
+	ExpectThat(t.tf.DirtyRanges(), ElementsAre())
+}
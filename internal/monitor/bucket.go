@@ -202,6 +202,27 @@ func (mb *monitoringBucket) DeleteFolder(ctx context.Context, folderName string)
 	return err
 }
 
+func (mb *monitoringBucket) GetFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	startTime := time.Now()
+	folder, err := mb.wrapped.GetFolder(ctx, folderName)
+	recordRequest(ctx, "GetFolder", startTime)
+	return folder, err
+}
+
+func (mb *monitoringBucket) CreateFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	startTime := time.Now()
+	folder, err := mb.wrapped.CreateFolder(ctx, folderName)
+	recordRequest(ctx, "CreateFolder", startTime)
+	return folder, err
+}
+
+func (mb *monitoringBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (*gcs.Folder, error) {
+	startTime := time.Now()
+	folder, err := mb.wrapped.RenameFolder(ctx, folderName, destinationFolderId)
+	recordRequest(ctx, "RenameFolder", startTime)
+	return folder, err
+}
+
 // recordReader increments the reader count when it's opened or closed.
 func recordReader(ctx context.Context, ioMethod string) {
 	if err := stats.RecordWithTags(
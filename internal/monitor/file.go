@@ -0,0 +1,62 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"log"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/monitor/tags"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"golang.org/x/net/context"
+)
+
+var (
+	// A sync conflict occurs when the local changes to a file are synced after
+	// its object has been modified or deleted remotely.
+	syncConflictCount = stats.Int64("fs/sync_conflict_count",
+		"The number of sync conflicts along with the conflict policy applied - discard/error/conflict-object",
+		stats.UnitDimensionless)
+)
+
+// Initialize the metrics.
+func init() {
+	if err := view.Register(
+		&view.View{
+			Name:        "fs/sync_conflict_count",
+			Measure:     syncConflictCount,
+			Description: "The number of sync conflicts along with the conflict policy applied - discard/error/conflict-object",
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{tags.ConflictPolicy},
+		},
+	); err != nil {
+		log.Fatalf("Failed to register the file view: %v", err)
+	}
+}
+
+func CaptureSyncConflictMetrics(ctx context.Context, conflictPolicy string) {
+	if err := stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{
+			tag.Upsert(tags.ConflictPolicy, conflictPolicy),
+		},
+		syncConflictCount.M(1),
+	); err != nil {
+		// Error in recording syncConflictCount.
+		logger.Errorf("Cannot record syncConflictCount %v", err)
+	}
+}
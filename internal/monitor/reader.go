@@ -47,6 +47,12 @@ var (
 	fileCacheReadLatency = stats.Float64("file_cache/read_latency",
 		"Latency of read from file cache along with cache hit - true/false",
 		stats.UnitMilliseconds)
+	blockCacheReadCount = stats.Int64("block_cache/read_count",
+		"Specifies the number of read requests made via the in-memory block cache along with cache hit - true/false",
+		stats.UnitDimensionless)
+	blockCacheReadBytesCount = stats.Int64("block_cache/read_bytes_count",
+		"The cumulative number of bytes read via the in-memory block cache along with cache hit - true/false",
+		stats.UnitBytes)
 )
 
 const NanosecondsInOneMillisecond = 1000000
@@ -91,6 +97,21 @@ func init() {
 			Aggregation: ochttp.DefaultLatencyDistribution,
 			TagKeys:     []tag.Key{tags.CacheHit},
 		},
+		// Block cache related metrics
+		&view.View{
+			Name:        "block_cache/read_count",
+			Measure:     blockCacheReadCount,
+			Description: "Specifies the number of read requests made via the in-memory block cache along with cache hit - true/false",
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{tags.CacheHit},
+		},
+		&view.View{
+			Name:        "block_cache/read_bytes_count",
+			Measure:     blockCacheReadBytesCount,
+			Description: "The cumulative number of bytes read via the in-memory block cache along with cache hit - true/false",
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{tags.CacheHit},
+		},
 	); err != nil {
 		log.Fatalf("Failed to register the reader view: %v", err)
 	}
@@ -156,3 +177,19 @@ func CaptureFileCacheMetrics(ctx context.Context, readType string, readDataSize
 		logger.Errorf("Cannot record fileCacheReadLatency %v", err)
 	}
 }
+
+// CaptureBlockCacheMetrics records a read of readDataSize bytes via the
+// in-memory block cache, which is a hit if all of them were cached.
+func CaptureBlockCacheMetrics(ctx context.Context, readDataSize int, cacheHit bool) {
+	if err := stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{
+			tag.Upsert(tags.CacheHit, strconv.FormatBool(cacheHit)),
+		},
+		blockCacheReadCount.M(1),
+		blockCacheReadBytesCount.M(int64(readDataSize)),
+	); err != nil {
+		// Error in recording blockCacheReadCount or blockCacheReadBytesCount.
+		logger.Errorf("Cannot record block cache metrics %v", err)
+	}
+}
@@ -35,6 +35,11 @@ var (
 	// ReadType annotates the read operation with the type - Sequential/Random
 	ReadType = tag.MustNewKey("read_type")
 
-	// CacheHit annotates the read operation from file cache with true or false.
+	// CacheHit annotates the read operation from file or block cache with true or
+	// false.
 	CacheHit = tag.MustNewKey("cache_hit")
+
+	// ConflictPolicy annotates the sync conflicts with the conflict policy
+	// applied - discard/error/conflict-object.
+	ConflictPolicy = tag.MustNewKey("conflict_policy")
 )
@@ -30,6 +30,9 @@ const (
 	HTTP1 ClientProtocol = "http1"
 	HTTP2 ClientProtocol = "http2"
 	GRPC  ClientProtocol = "grpc"
+	// S3 selects an S3-compatible object store, at the custom endpoint if set,
+	// in place of GCS.
+	S3 ClientProtocol = "s3"
 	// DefaultStatOrTypeCacheTTL is the default value used for
 	// stat-cache-ttl or type-cache-ttl if they have not been set
 	// by the user.
@@ -55,7 +58,7 @@ const (
 
 func (cp ClientProtocol) IsValid() bool {
 	switch cp {
-	case HTTP1, HTTP2, GRPC:
+	case HTTP1, HTTP2, GRPC, S3:
 		return true
 	}
 	return false
@@ -25,9 +25,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"syscall"
 
 	"cloud.google.com/go/storage"
-	control "cloud.google.com/go/storage/control/apiv2"
 	"cloud.google.com/go/storage/control/apiv2/controlpb"
 	"github.com/googleapis/gax-go/v2"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
@@ -35,14 +36,22 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
+	storagev1 "google.golang.org/api/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type bucketHandle struct {
 	gcs.Bucket
-	bucket        *storage.BucketHandle
-	bucketName    string
-	bucketType    gcs.BucketType
-	controlClient StorageControlClient
+	bucket         *storage.BucketHandle
+	bucketName     string
+	billingProject string
+	bucketType     gcs.BucketType
+	controlClient  StorageControlClient
+
+	// The JSON API, for the requests the storage client can't make. Nil over
+	// gRPC.
+	rawService *storagev1.Service
 }
 
 func (bh *bucketHandle) Name() string {
@@ -50,13 +59,12 @@ func (bh *bucketHandle) Name() string {
 }
 
 func (bh *bucketHandle) BucketType() gcs.BucketType {
-	var nilControlClient *control.StorageControlClient = nil
 	// Note: The first invocation of this method will be slower due to a required Google Cloud Storage (GCS) fetch.
 	// Subsequent calls will be significantly faster as the results are cached in memory.
 	// While this operation is thread-safe, parallel calls during the initial fetch can result in redundant GCS requests.
 	// To avoid this, it's advisable to call this initially while mounting.
 	if bh.bucketType == gcs.Nil {
-		if bh.controlClient == nilControlClient {
+		if bh.controlClient == nil {
 			bh.bucketType = gcs.NonHierarchical
 			return bh.bucketType
 		}
@@ -248,6 +256,15 @@ func (b *bucketHandle) CopyObject(ctx context.Context, req *gcs.CopyObjectReques
 		srcObj = srcObj.If(storage.Conditions{MetagenerationMatch: *req.SrcMetaGenerationPrecondition})
 	}
 
+	// Putting a condition on the generation of the destination, if any.
+	if req.DstGenerationPrecondition != nil {
+		if *req.DstGenerationPrecondition == 0 {
+			dstObj = dstObj.If(storage.Conditions{DoesNotExist: true})
+		} else {
+			dstObj = dstObj.If(storage.Conditions{GenerationMatch: *req.DstGenerationPrecondition})
+		}
+	}
+
 	objAttrs, err := dstObj.CopierFrom(srcObj).Run(ctx)
 
 	if err != nil {
@@ -294,6 +311,8 @@ func (b *bucketHandle) ListObjects(ctx context.Context, req *gcs.ListObjectsRequ
 		Projection:               getProjectionValue(req.ProjectionVal),
 		IncludeTrailingDelimiter: req.IncludeTrailingDelimiter,
 		IncludeFoldersAsPrefixes: req.IncludeFoldersAsPrefixes,
+		Versions:                 req.Versions,
+		SoftDeleted:              req.SoftDeleted,
 		//MaxResults: , (Field not present in storage.Query of Go Storage Library but present in ListObjectsQuery in Jacobsa code.)
 	}
 	itr := b.bucket.Objects(ctx, query) // Returning iterator to the list of objects.
@@ -344,6 +363,93 @@ func (b *bucketHandle) ListObjects(ctx context.Context, req *gcs.ListObjectsRequ
 	return
 }
 
+// patchObject applies the update over the JSON API, which unlike the storage
+// client can delete individual custom metadata keys, by sending them as null.
+// Hence the keys are deleted by a single request, guarded by the
+// preconditions of the update.
+func (b *bucketHandle) patchObject(ctx context.Context, req *gcs.UpdateObjectRequest) (*gcs.Object, error) {
+	object := &storagev1.Object{Metadata: make(map[string]string)}
+	if req.ContentType != nil {
+		object.ContentType = *req.ContentType
+	}
+	if req.ContentEncoding != nil {
+		object.ContentEncoding = *req.ContentEncoding
+	}
+	if req.ContentLanguage != nil {
+		object.ContentLanguage = *req.ContentLanguage
+	}
+	if req.CacheControl != nil {
+		object.CacheControl = *req.CacheControl
+	}
+	for key, value := range req.Metadata {
+		if value != nil {
+			object.Metadata[key] = *value
+		} else {
+			object.NullFields = append(object.NullFields, "Metadata."+key)
+		}
+	}
+
+	call := b.rawService.Objects.Patch(b.bucketName, req.Name, object).Context(ctx)
+	if req.Generation != 0 {
+		call.Generation(req.Generation)
+	}
+	if req.MetaGenerationPrecondition != nil {
+		call.IfMetagenerationMatch(*req.MetaGenerationPrecondition)
+	}
+	if b.billingProject != "" {
+		call.UserProject(b.billingProject)
+	}
+
+	raw, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	return storageutil.RawObjectToBucketObject(raw)
+}
+
+// clearMetadata prepares the update of obj which deletes the given custom
+// metadata keys, without the JSON API. Over gRPC the storage client can only
+// delete the whole custom metadata, by updating it to an empty map, hence
+// the update is only possible if it leaves no keys. Returns the handle to use
+// for the update, pinned to the generation and meta-generation which were
+// checked, and syscall.ENOTSUP otherwise.
+func (b *bucketHandle) clearMetadata(
+	ctx context.Context,
+	obj *storage.ObjectHandle,
+	deletedKeys []string,
+	setKeys map[string]string) (*storage.ObjectHandle, error) {
+	if len(setKeys) > 0 {
+		return nil, fmt.Errorf("delete custom metadata keys over gRPC: %w", syscall.ENOTSUP)
+	}
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make(map[string]bool)
+	for _, key := range deletedKeys {
+		deleted[key] = true
+	}
+	for key := range attrs.Metadata {
+		if !deleted[key] {
+			return nil, fmt.Errorf("delete custom metadata keys over gRPC: %w", syscall.ENOTSUP)
+		}
+	}
+
+	obj = b.bucket.Object(attrs.Name).Generation(attrs.Generation)
+	return ifMetagenerationMatch(obj, attrs.Metageneration), nil
+}
+
+// ifMetagenerationMatch returns obj with the meta-generation precondition, if
+// the meta-generation is known, i.e. non-zero.
+func ifMetagenerationMatch(obj *storage.ObjectHandle, metageneration int64) *storage.ObjectHandle {
+	if metageneration == 0 {
+		return obj
+	}
+	return obj.If(storage.Conditions{MetagenerationMatch: metageneration})
+}
+
 func (b *bucketHandle) UpdateObject(ctx context.Context, req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
 	obj := b.bucket.Object(req.Name)
 
@@ -373,20 +479,39 @@ func (b *bucketHandle) UpdateObject(ctx context.Context, req *gcs.UpdateObjectRe
 		updateQuery.CacheControl = *req.CacheControl
 	}
 
+	var deletedKeys []string
 	if req.Metadata != nil {
 		updateQuery.Metadata = make(map[string]string)
 		for key, element := range req.Metadata {
 			if element != nil {
 				updateQuery.Metadata[key] = *element
+			} else {
+				deletedKeys = append(deletedKeys, key)
 			}
 		}
 	}
 
-	attrs, err := obj.Update(ctx, updateQuery)
+	// Preconditions are checked by the request which deletes the keys, so
+	// that no other update can come in between.
+	var attrs *storage.ObjectAttrs
+	switch {
+	case len(deletedKeys) > 0 && b.rawService != nil:
+		o, err = b.patchObject(ctx, req)
+	case len(deletedKeys) > 0:
+		obj, err = b.clearMetadata(ctx, obj, deletedKeys, updateQuery.Metadata)
+		if err == nil {
+			updateQuery.Metadata = map[string]string{}
+			attrs, err = obj.Update(ctx, updateQuery)
+		}
+	default:
+		attrs, err = obj.Update(ctx, updateQuery)
+	}
 
 	if err == nil {
 		// Converting objAttrs to type *Object
-		o = storageutil.ObjectAttrsToBucketObject(attrs)
+		if attrs != nil {
+			o = storageutil.ObjectAttrsToBucketObject(attrs)
+		}
 		return
 	}
 
@@ -396,6 +521,9 @@ func (b *bucketHandle) UpdateObject(ctx context.Context, req *gcs.UpdateObjectRe
 	case *googleapi.Error:
 		if ee.Code == http.StatusPreconditionFailed {
 			err = &gcs.PreconditionError{Err: ee}
+		} else if ee.Code == http.StatusNotFound {
+			// Returned by the JSON API, which patchObject calls directly.
+			err = &gcs.NotFoundError{Err: ee}
 		}
 	default:
 		if err == storage.ErrObjectNotExist {
@@ -486,16 +614,85 @@ func (b *bucketHandle) DeleteFolder(ctx context.Context, folderName string) (err
 	}
 
 	err = b.controlClient.DeleteFolder(ctx, &controlpb.DeleteFolderRequest{
-		Name: "projects/_/buckets/" + b.bucketName + "/folders/" + folderName,
+		Name: b.folderResourceName(folderName),
 	}, callOptions...)
 
 	if err != nil {
-		err = fmt.Errorf("DeleteFolder: %w", err)
+		err = fmt.Errorf("DeleteFolder: %w", folderError(err))
 	}
 
 	return err
 }
 
+func (b *bucketHandle) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	f, err := b.controlClient.GetFolder(ctx, &controlpb.GetFolderRequest{
+		Name: b.folderResourceName(folderName),
+	})
+	if err != nil {
+		err = fmt.Errorf("GetFolder: %w", folderError(err))
+		return
+	}
+
+	folder = b.convertFolder(f)
+	return
+}
+
+func (b *bucketHandle) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	f, err := b.controlClient.CreateFolder(ctx, &controlpb.CreateFolderRequest{
+		Parent:   "projects/_/buckets/" + b.bucketName,
+		FolderId: folderName,
+	})
+	if err != nil {
+		err = fmt.Errorf("CreateFolder: %w", folderError(err))
+		return
+	}
+
+	folder = b.convertFolder(f)
+	return
+}
+
+func (b *bucketHandle) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	f, err := b.controlClient.RenameFolder(ctx, &controlpb.RenameFolderRequest{
+		Name:                b.folderResourceName(folderName),
+		DestinationFolderId: destinationFolderId,
+	})
+	if err != nil {
+		err = fmt.Errorf("RenameFolder: %w", folderError(err))
+		return
+	}
+
+	folder = b.convertFolder(f)
+	return
+}
+
+// Return the resource name the storage control API uses for the folder.
+func (b *bucketHandle) folderResourceName(folderName string) string {
+	return "projects/_/buckets/" + b.bucketName + "/folders/" + folderName
+}
+
+// Convert a folder returned by the storage control API, which is named by its
+// resource name.
+func (b *bucketHandle) convertFolder(f *controlpb.Folder) *gcs.Folder {
+	return &gcs.Folder{
+		Name:           strings.TrimPrefix(f.GetName(), b.folderResourceName("")),
+		MetaGeneration: f.GetMetageneration(),
+		UpdateTime:     f.GetUpdateTime().AsTime(),
+	}
+}
+
+// Translate the gRPC status of a failed folder call to the errors of the gcs
+// package where there's an equivalent.
+func folderError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return &gcs.NotFoundError{Err: err}
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return &gcs.PreconditionError{Err: err}
+	default:
+		return err
+	}
+}
+
 // TODO: Consider adding this method to the bucket interface if additional
 // layout options are needed in the future.
 func (b *bucketHandle) getStorageLayout() (*controlpb.StorageLayout, error) {
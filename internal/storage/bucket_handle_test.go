@@ -16,22 +16,40 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/storage"
-	control "cloud.google.com/go/storage/control/apiv2"
 	"cloud.google.com/go/storage/control/apiv2/controlpb"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const missingObjectName string = "test/foo"
 const dstObjectName string = "gcsfuse/dst.txt"
+const TestFolderName string = "gcsfuse/folder/"
+const TestRenamedFolderName string = "gcsfuse/renamed/"
 
 var ContentType string = "ContentType"
 var ContentEncoding string = "ContentEncoding"
@@ -59,7 +77,7 @@ func TestBucketHandleTestSuite(testSuite *testing.T) {
 func (testSuite *BucketHandleTest) SetupTest() {
 	testSuite.fakeStorage = NewFakeStorage()
 	testSuite.storageHandle = testSuite.fakeStorage.CreateStorageHandle()
-	testSuite.bucketHandle = testSuite.storageHandle.BucketHandle(TestBucketName, "")
+	testSuite.bucketHandle = testSuite.storageHandle.BucketHandle(TestBucketName, "").(*bucketHandle)
 
 	assert.NotNil(testSuite.T(), testSuite.bucketHandle)
 }
@@ -681,6 +699,244 @@ func (testSuite *BucketHandleTest) TestUpdateObjectMethodWithMissingObject() {
 	assert.True(testSuite.T(), errors.As(err, &notfound))
 }
 
+// The custom metadata of the object served by the servers of the tests of
+// deleting metadata keys.
+func originalMetadata() map[string]any {
+	return map[string]any{"deleted": "value", "kept": "value", "other": "value"}
+}
+
+// metadataDeletingRequest returns an update of the object served by the
+// servers of the tests of deleting metadata keys, which deletes a key and
+// sets another.
+func metadataDeletingRequest() *gcs.UpdateObjectRequest {
+	keptValue := "kept"
+	metagenerationPrecondition := int64(1)
+	return &gcs.UpdateObjectRequest{
+		Name:                       TestObjectName,
+		MetaGenerationPrecondition: &metagenerationPrecondition,
+		Metadata: map[string]*string{
+			"deleted": nil,
+			"kept":    &keptValue,
+		},
+	}
+}
+
+// httpMetadataBucketHandle returns a bucket handle talking over HTTP to a
+// server which serves an object with custom metadata, applies object PATCH
+// requests to it as GCS does and records them. The PATCH numbered
+// failingPatch, counting from one, fails without changing the object.
+func (testSuite *BucketHandleTest) httpMetadataBucketHandle(failingPatch int, patches *[]map[string]any) (*bucketHandle, map[string]any) {
+	object := map[string]any{
+		"bucket":         TestBucketName,
+		"name":           TestObjectName,
+		"generation":     "1",
+		"metageneration": "1",
+		"metadata":       originalMetadata(),
+	}
+	metageneration := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			var body map[string]any
+			assert.Nil(testSuite.T(), json.NewDecoder(r.Body).Decode(&body))
+			body["ifMetagenerationMatch"] = r.URL.Query().Get("ifMetagenerationMatch")
+			*patches = append(*patches, body)
+			if len(*patches) == failingPatch {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if precondition := r.URL.Query().Get("ifMetagenerationMatch"); precondition != "" && precondition != fmt.Sprint(metageneration) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+
+			// A null clears the custom metadata or deletes a key, and keys are
+			// otherwise merged.
+			if metadata, ok := body["metadata"]; ok {
+				if metadata == nil {
+					object["metadata"] = map[string]any{}
+				}
+				set, _ := metadata.(map[string]any)
+				for key, value := range set {
+					if value == nil {
+						delete(object["metadata"].(map[string]any), key)
+					} else {
+						object["metadata"].(map[string]any)[key] = value
+					}
+				}
+			}
+			metageneration++
+			object["metageneration"] = fmt.Sprint(metageneration)
+		}
+		_ = json.NewEncoder(w).Encode(object)
+	}))
+	testSuite.T().Cleanup(server.Close)
+
+	opts := []option.ClientOption{option.WithEndpoint(server.URL + "/storage/v1/"), option.WithoutAuthentication()}
+	client, err := storage.NewClient(context.Background(), opts...)
+	assert.Nil(testSuite.T(), err)
+	rawService, err := storagev1.NewService(context.Background(), opts...)
+	assert.Nil(testSuite.T(), err)
+	return &bucketHandle{bucket: client.Bucket(TestBucketName), bucketName: TestBucketName, rawService: rawService}, object
+}
+
+func (testSuite *BucketHandleTest) TestUpdateObjectMethodDeletesMetadataKey() {
+	var patches []map[string]any
+	bucketHandle, object := testSuite.httpMetadataBucketHandle(0, &patches)
+
+	o, err := bucketHandle.UpdateObject(context.Background(), metadataDeletingRequest())
+
+	assert.Nil(testSuite.T(), err)
+	assert.Equal(testSuite.T(), map[string]string{"kept": "kept", "other": "value"}, o.Metadata)
+	assert.Equal(testSuite.T(), map[string]any{"kept": "kept", "other": "value"}, object["metadata"])
+	assert.Equal(testSuite.T(), int64(2), o.MetaGeneration)
+	// The key is deleted by a single patch sending it as null, guarded by the
+	// meta-generation.
+	assert.Equal(testSuite.T(), 1, len(patches))
+	assert.Equal(testSuite.T(), map[string]any{"deleted": nil, "kept": "kept"}, patches[0]["metadata"])
+	assert.Equal(testSuite.T(), "1", patches[0]["ifMetagenerationMatch"])
+}
+
+func (testSuite *BucketHandleTest) TestUpdateObjectMethodKeepsMetadataIfDeletingKeyFails() {
+	var patches []map[string]any
+	bucketHandle, object := testSuite.httpMetadataBucketHandle(1, &patches)
+
+	_, err := bucketHandle.UpdateObject(context.Background(), metadataDeletingRequest())
+
+	assert.NotNil(testSuite.T(), err)
+	assert.Equal(testSuite.T(), 1, len(patches))
+	assert.Equal(testSuite.T(), originalMetadata(), object["metadata"])
+}
+
+func (testSuite *BucketHandleTest) TestUpdateObjectMethodDeletingMetadataKeyWithStaleMetaGeneration() {
+	var patches []map[string]any
+	bucketHandle, object := testSuite.httpMetadataBucketHandle(0, &patches)
+	req := metadataDeletingRequest()
+	*req.MetaGenerationPrecondition = 2
+
+	_, err := bucketHandle.UpdateObject(context.Background(), req)
+
+	var preconditionErr *gcs.PreconditionError
+	assert.True(testSuite.T(), errors.As(err, &preconditionErr))
+	assert.Equal(testSuite.T(), originalMetadata(), object["metadata"])
+}
+
+// newStoragepbMessage returns an empty message of the type of the gRPC API of
+// GCS with the given name, which the storage client registers.
+func (testSuite *BucketHandleTest) newStoragepbMessage(name string) proto.Message {
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName("google.storage.v2." + name))
+	assert.Nil(testSuite.T(), err)
+	return messageType.New().Interface()
+}
+
+// grpcMetadataBucketHandle returns a bucket handle talking over gRPC to a
+// server which serves an object with custom metadata, and applies the field
+// masks of object updates to it as GCS does: a masked key which the update
+// doesn't set is deleted, and one set to the empty string is stored as such.
+func (testSuite *BucketHandleTest) grpcMetadataBucketHandle() (*bucketHandle, map[string]any) {
+	object := map[string]any{
+		"bucket":         "projects/_/buckets/" + TestBucketName,
+		"name":           TestObjectName,
+		"generation":     "1",
+		"metageneration": "1",
+		"metadata":       originalMetadata(),
+	}
+	metageneration := 1
+	handler := func(_ any, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		method = method[strings.LastIndex(method, "/")+1:]
+		if method != "GetObject" && method != "UpdateObject" {
+			return status.Errorf(codes.Unimplemented, "%s", method)
+		}
+
+		req := testSuite.newStoragepbMessage(method + "Request")
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		// The read mask of all fields, "*", has no JSON form.
+		if readMask := req.ProtoReflect().Descriptor().Fields().ByName("read_mask"); readMask != nil {
+			req.ProtoReflect().Clear(readMask)
+		}
+		data, err := protojson.Marshal(req)
+		assert.Nil(testSuite.T(), err)
+		var fields map[string]any
+		assert.Nil(testSuite.T(), json.Unmarshal(data, &fields))
+
+		if method == "UpdateObject" {
+			if precondition, ok := fields["ifMetagenerationMatch"]; ok && precondition != fmt.Sprint(metageneration) {
+				return status.Error(codes.FailedPrecondition, "metageneration mismatch")
+			}
+
+			updated, _ := fields["object"].(map[string]any)["metadata"].(map[string]any)
+			metadata := object["metadata"].(map[string]any)
+			for _, path := range strings.Split(fields["updateMask"].(string), ",") {
+				if path == "metadata" {
+					metadata = map[string]any{}
+					for key, value := range updated {
+						metadata[key] = value
+					}
+				} else if key, ok := strings.CutPrefix(path, "metadata."); ok {
+					if value, ok := updated[key]; ok {
+						metadata[key] = value
+					} else {
+						delete(metadata, key)
+					}
+				}
+			}
+			object["metadata"] = metadata
+			metageneration++
+			object["metageneration"] = fmt.Sprint(metageneration)
+		}
+
+		data, err = json.Marshal(object)
+		assert.Nil(testSuite.T(), err)
+		resp := testSuite.newStoragepbMessage("Object")
+		assert.Nil(testSuite.T(), protojson.Unmarshal(data, resp))
+		return stream.SendMsg(resp)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.Nil(testSuite.T(), err)
+	server := grpc.NewServer(grpc.UnknownServiceHandler(handler))
+	go func() { _ = server.Serve(listener) }()
+	testSuite.T().Cleanup(server.Stop)
+
+	client, err := storage.NewGRPCClient(context.Background(),
+		option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	assert.Nil(testSuite.T(), err)
+	return &bucketHandle{bucket: client.Bucket(TestBucketName), bucketName: TestBucketName}, object
+}
+
+func (testSuite *BucketHandleTest) TestUpdateObjectMethodDeletingMetadataKeyOverGRPCIsNotSupported() {
+	bucketHandle, object := testSuite.grpcMetadataBucketHandle()
+	req := &gcs.UpdateObjectRequest{
+		Name:     TestObjectName,
+		Metadata: map[string]*string{"deleted": nil},
+	}
+
+	_, err := bucketHandle.UpdateObject(context.Background(), req)
+
+	assert.True(testSuite.T(), errors.Is(err, syscall.ENOTSUP))
+	assert.Equal(testSuite.T(), originalMetadata(), object["metadata"])
+	assert.Equal(testSuite.T(), "1", object["metageneration"])
+}
+
+func (testSuite *BucketHandleTest) TestUpdateObjectMethodDeletesAllMetadataKeysOverGRPC() {
+	bucketHandle, object := testSuite.grpcMetadataBucketHandle()
+	req := &gcs.UpdateObjectRequest{
+		Name:     TestObjectName,
+		Metadata: map[string]*string{"deleted": nil, "kept": nil, "other": nil},
+	}
+
+	o, err := bucketHandle.UpdateObject(context.Background(), req)
+
+	assert.Nil(testSuite.T(), err)
+	assert.Empty(testSuite.T(), o.Metadata)
+	assert.Empty(testSuite.T(), object["metadata"])
+	assert.Equal(testSuite.T(), "2", object["metageneration"])
+}
+
 // Read content of an object and return
 func (testSuite *BucketHandleTest) readObjectContent(ctx context.Context, req *gcs.ReadObjectRequest) (buffer string) {
 	rc, err := testSuite.bucketHandle.NewReader(ctx, &gcs.ReadObjectRequest{
@@ -1242,8 +1498,7 @@ func (testSuite *BucketHandleTest) TestBucketTypeWithHierarchicalNamespaceIsNil(
 }
 
 func (testSuite *BucketHandleTest) TestDefaultBucketTypeWithControlClientNil() {
-	var nilControlClient *control.StorageControlClient = nil
-	testSuite.bucketHandle.controlClient = nilControlClient
+	testSuite.bucketHandle.controlClient = nil
 
 	testSuite.bucketHandle.BucketType()
 
@@ -1291,3 +1546,126 @@ func (testSuite *BucketHandleTest) TestDeleteFolderWhenFolderNotExistForHierarch
 	mockClient.AssertExpectations(testSuite.T())
 	assert.Equal(testSuite.T(), "DeleteFolder: mock error", err.Error())
 }
+
+func (testSuite *BucketHandleTest) TestDeleteFolderWhenFolderNotEmptyForHierarchicalBucket() {
+	ctx := context.Background()
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("DeleteFolder", mock.Anything, &controlpb.DeleteFolderRequest{Name: "projects/_/buckets/" + TestBucketName + "/folders/" + TestFolderName}, mock.Anything).
+		Return(status.Error(codes.FailedPrecondition, "folder not empty"))
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	err := testSuite.bucketHandle.DeleteFolder(ctx, TestFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	var preconditionErr *gcs.PreconditionError
+	assert.ErrorAs(testSuite.T(), err, &preconditionErr)
+}
+
+func (testSuite *BucketHandleTest) TestGetFolderWhenFolderExistsForHierarchicalBucket() {
+	ctx := context.Background()
+	updateTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("GetFolder", ctx, &controlpb.GetFolderRequest{Name: "projects/_/buckets/" + TestBucketName + "/folders/" + TestFolderName}, mock.Anything).
+		Return(&controlpb.Folder{
+			Name:           "projects/_/buckets/" + TestBucketName + "/folders/" + TestFolderName,
+			Metageneration: 3,
+			UpdateTime:     timestamppb.New(updateTime),
+		}, nil)
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	folder, err := testSuite.bucketHandle.GetFolder(ctx, TestFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	assert.NoError(testSuite.T(), err)
+	assert.Equal(testSuite.T(), &gcs.Folder{Name: TestFolderName, MetaGeneration: 3, UpdateTime: updateTime}, folder)
+}
+
+func (testSuite *BucketHandleTest) TestGetFolderWhenFolderNotExistForHierarchicalBucket() {
+	ctx := context.Background()
+	var nilFolder *controlpb.Folder
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("GetFolder", ctx, &controlpb.GetFolderRequest{Name: "projects/_/buckets/" + TestBucketName + "/folders/" + TestFolderName}, mock.Anything).
+		Return(nilFolder, status.Error(codes.NotFound, "folder not found"))
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	folder, err := testSuite.bucketHandle.GetFolder(ctx, TestFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	assert.Nil(testSuite.T(), folder)
+	var notFoundErr *gcs.NotFoundError
+	assert.ErrorAs(testSuite.T(), err, &notFoundErr)
+}
+
+func (testSuite *BucketHandleTest) TestCreateFolderForHierarchicalBucket() {
+	ctx := context.Background()
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("CreateFolder", ctx, &controlpb.CreateFolderRequest{Parent: "projects/_/buckets/" + TestBucketName, FolderId: TestFolderName}, mock.Anything).
+		Return(&controlpb.Folder{
+			Name:           "projects/_/buckets/" + TestBucketName + "/folders/" + TestFolderName,
+			Metageneration: 1,
+		}, nil)
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	folder, err := testSuite.bucketHandle.CreateFolder(ctx, TestFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	assert.NoError(testSuite.T(), err)
+	assert.Equal(testSuite.T(), TestFolderName, folder.Name)
+	assert.Equal(testSuite.T(), int64(1), folder.MetaGeneration)
+}
+
+func (testSuite *BucketHandleTest) TestCreateFolderWhenFolderExistsForHierarchicalBucket() {
+	ctx := context.Background()
+	var nilFolder *controlpb.Folder
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("CreateFolder", ctx, mock.Anything, mock.Anything).
+		Return(nilFolder, status.Error(codes.AlreadyExists, "folder exists"))
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	_, err := testSuite.bucketHandle.CreateFolder(ctx, TestFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	var preconditionErr *gcs.PreconditionError
+	assert.ErrorAs(testSuite.T(), err, &preconditionErr)
+}
+
+func (testSuite *BucketHandleTest) TestRenameFolderForHierarchicalBucket() {
+	ctx := context.Background()
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("RenameFolder", ctx, &controlpb.RenameFolderRequest{Name: "projects/_/buckets/" + TestBucketName + "/folders/" + TestFolderName, DestinationFolderId: TestRenamedFolderName}, mock.Anything).
+		Return(&controlpb.Folder{
+			Name:           "projects/_/buckets/" + TestBucketName + "/folders/" + TestRenamedFolderName,
+			Metageneration: 2,
+		}, nil)
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	folder, err := testSuite.bucketHandle.RenameFolder(ctx, TestFolderName, TestRenamedFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	assert.NoError(testSuite.T(), err)
+	assert.Equal(testSuite.T(), TestRenamedFolderName, folder.Name)
+	assert.Equal(testSuite.T(), int64(2), folder.MetaGeneration)
+}
+
+func (testSuite *BucketHandleTest) TestRenameFolderWhenFolderNotExistForHierarchicalBucket() {
+	ctx := context.Background()
+	var nilFolder *controlpb.Folder
+	mockClient := new(MockStorageControlClient)
+	mockClient.On("RenameFolder", ctx, mock.Anything, mock.Anything).
+		Return(nilFolder, status.Error(codes.NotFound, "folder not found"))
+	testSuite.bucketHandle.controlClient = mockClient
+	testSuite.bucketHandle.bucketType = gcs.Hierarchical
+
+	folder, err := testSuite.bucketHandle.RenameFolder(ctx, TestFolderName, TestRenamedFolderName)
+
+	mockClient.AssertExpectations(testSuite.T())
+	assert.Nil(testSuite.T(), folder)
+	var notFoundErr *gcs.NotFoundError
+	assert.ErrorAs(testSuite.T(), err, &notFoundErr)
+}
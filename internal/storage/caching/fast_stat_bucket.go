@@ -105,6 +105,14 @@ func (b *fastStatBucket) invalidate(name string) {
 	b.cache.Erase(name)
 }
 
+// LOCKS_EXCLUDED(b.mu)
+func (b *fastStatBucket) invalidatePrefix(prefix string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cache.EraseEntriesWithGivenPrefix(prefix)
+}
+
 // LOCKS_EXCLUDED(b.mu)
 func (b *fastStatBucket) lookUp(name string) (hit bool, m *gcs.MinObject) {
 	b.mu.Lock()
@@ -236,8 +244,11 @@ func (b *fastStatBucket) ListObjects(
 		return
 	}
 
-	// Note anything we found.
-	b.insertMultiple(listing.Objects)
+	// Note anything we found, unless the listing may contain generations other
+	// than the live ones, or records lacking some of their attributes.
+	if !req.Versions && !req.SoftDeleted && !listing.IncompleteObjects {
+		b.insertMultiple(listing.Objects)
+	}
 
 	return
 }
@@ -276,6 +287,22 @@ func (b *fastStatBucket) DeleteFolder(ctx context.Context, folderName string) er
 	return err
 }
 
+func (b *fastStatBucket) GetFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	return b.wrapped.GetFolder(ctx, folderName)
+}
+
+func (b *fastStatBucket) CreateFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	b.invalidate(folderName)
+	return b.wrapped.CreateFolder(ctx, folderName)
+}
+
+func (b *fastStatBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (*gcs.Folder, error) {
+	// Everything within both folders changes.
+	b.invalidatePrefix(folderName)
+	b.invalidatePrefix(destinationFolderId)
+	return b.wrapped.RenameFolder(ctx, folderName, destinationFolderId)
+}
+
 func (b *fastStatBucket) StatObjectFromGcs(ctx context.Context,
 	req *gcs.StatObjectRequest) (m *gcs.MinObject, e *gcs.ExtendedObjectAttributes, err error) {
 	m, e, err = b.wrapped.StatObject(ctx, req)
@@ -586,6 +586,44 @@ func (t *ListObjectsTest) NonEmptyListing() {
 	ExpectEq(expected, listing)
 }
 
+func (t *ListObjectsTest) VersionsListing() {
+	// Wrapped
+	o0 := &gcs.Object{Name: "taco", Generation: 1}
+	o1 := &gcs.Object{Name: "taco", Generation: 2}
+
+	expected := &gcs.Listing{
+		Objects: []*gcs.Object{o0, o1},
+	}
+
+	ExpectCall(t.wrapped, "ListObjects")(Any(), Any()).
+		WillOnce(Return(expected, nil))
+
+	// Call. Noncurrent generations must not be inserted into the cache.
+	listing, err := t.bucket.ListObjects(context.TODO(), &gcs.ListObjectsRequest{Versions: true})
+
+	AssertEq(nil, err)
+	ExpectEq(expected, listing)
+}
+
+func (t *ListObjectsTest) IncompleteListing() {
+	// Wrapped
+	o0 := &gcs.Object{Name: "taco"}
+
+	expected := &gcs.Listing{
+		Objects:           []*gcs.Object{o0},
+		IncompleteObjects: true,
+	}
+
+	ExpectCall(t.wrapped, "ListObjects")(Any(), Any()).
+		WillOnce(Return(expected, nil))
+
+	// Call. Records lacking attributes must not be inserted into the cache.
+	listing, err := t.bucket.ListObjects(context.TODO(), &gcs.ListObjectsRequest{})
+
+	AssertEq(nil, err)
+	ExpectEq(expected, listing)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // UpdateObject
 ////////////////////////////////////////////////////////////////////////
@@ -725,3 +763,73 @@ func (t *DeleteObjectTest) WrappedSucceeds() {
 	err = t.deleteObject(name)
 	AssertEq(nil, err)
 }
+
+////////////////////////////////////////////////////////////////////////
+// CreateFolder
+////////////////////////////////////////////////////////////////////////
+
+type CreateFolderTest struct {
+	fastStatBucketTest
+}
+
+func init() { RegisterTestSuite(&CreateFolderTest{}) }
+
+func (t *CreateFolderTest) CallsEraseAndWrapped() {
+	const name = "taco/"
+
+	// Erase
+	ExpectCall(t.cache, "Erase")(name)
+
+	// Wrapped
+	ExpectCall(t.wrapped, "CreateFolder")(Any(), name).
+		WillOnce(Return(&gcs.Folder{Name: name}, nil))
+
+	// Call
+	folder, err := t.bucket.CreateFolder(context.TODO(), name)
+
+	AssertEq(nil, err)
+	ExpectEq(name, folder.Name)
+}
+
+////////////////////////////////////////////////////////////////////////
+// RenameFolder
+////////////////////////////////////////////////////////////////////////
+
+type RenameFolderTest struct {
+	fastStatBucketTest
+}
+
+func init() { RegisterTestSuite(&RenameFolderTest{}) }
+
+func (t *RenameFolderTest) ErasesBothPrefixesAndCallsWrapped() {
+	const src = "taco/"
+	const dst = "burrito/"
+
+	// Erase
+	ExpectCall(t.cache, "EraseEntriesWithGivenPrefix")(src)
+	ExpectCall(t.cache, "EraseEntriesWithGivenPrefix")(dst)
+
+	// Wrapped
+	ExpectCall(t.wrapped, "RenameFolder")(Any(), src, dst).
+		WillOnce(Return(&gcs.Folder{Name: dst}, nil))
+
+	// Call
+	folder, err := t.bucket.RenameFolder(context.TODO(), src, dst)
+
+	AssertEq(nil, err)
+	ExpectEq(dst, folder.Name)
+}
+
+func (t *RenameFolderTest) WrappedFails() {
+	// Erase
+	ExpectCall(t.cache, "EraseEntriesWithGivenPrefix")(Any()).Times(2)
+
+	// Wrapped
+	ExpectCall(t.wrapped, "RenameFolder")(Any(), Any(), Any()).
+		WillOnce(Return(nil, errors.New("taco")))
+
+	// Call
+	_, err := t.bucket.RenameFolder(context.TODO(), "a/", "b/")
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
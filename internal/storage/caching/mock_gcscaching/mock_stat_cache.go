@@ -78,6 +78,23 @@ func (m *mockStatCache) Erase(p0 string) {
 	}
 }
 
+func (m *mockStatCache) EraseEntriesWithGivenPrefix(p0 string) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"EraseEntriesWithGivenPrefix",
+		file,
+		line,
+		[]interface{}{p0})
+
+	if len(retVals) != 0 {
+		panic(fmt.Sprintf("mockStatCache.EraseEntriesWithGivenPrefix: invalid return values: %v", retVals))
+	}
+}
+
 func (m *mockStatCache) Insert(p0 *gcs.MinObject, p1 time.Time) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
@@ -17,6 +17,7 @@ package storage
 import (
 	"context"
 
+	control "cloud.google.com/go/storage/control/apiv2"
 	"cloud.google.com/go/storage/control/apiv2/controlpb"
 	"github.com/googleapis/gax-go/v2"
 )
@@ -29,4 +30,35 @@ type StorageControlClient interface {
 	DeleteFolder(ctx context.Context,
 		req *controlpb.DeleteFolderRequest,
 		opts ...gax.CallOption) error
+
+	GetFolder(ctx context.Context,
+		req *controlpb.GetFolderRequest,
+		opts ...gax.CallOption) (*controlpb.Folder, error)
+
+	CreateFolder(ctx context.Context,
+		req *controlpb.CreateFolderRequest,
+		opts ...gax.CallOption) (*controlpb.Folder, error)
+
+	// Unlike the method of control.StorageControlClient, returns only once the
+	// long-running rename operation is done.
+	RenameFolder(ctx context.Context,
+		req *controlpb.RenameFolderRequest,
+		opts ...gax.CallOption) (*controlpb.Folder, error)
+}
+
+// controlClientWrapper adapts control.StorageControlClient to the
+// StorageControlClient interface.
+type controlClientWrapper struct {
+	*control.StorageControlClient
+}
+
+func (c *controlClientWrapper) RenameFolder(ctx context.Context,
+	req *controlpb.RenameFolderRequest,
+	opts ...gax.CallOption) (*controlpb.Folder, error) {
+	op, err := c.StorageControlClient.RenameFolder(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return op.Wait(ctx)
 }
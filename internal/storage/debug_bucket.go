@@ -237,3 +237,27 @@ func (b *debugBucket) DeleteFolder(ctx context.Context, folderName string) (err
 	err = b.wrapped.DeleteFolder(ctx, folderName)
 	return err
 }
+
+func (b *debugBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	id, desc, start := b.startRequest("GetFolder(%q)", folderName)
+	defer b.finishRequest(id, desc, start, &err)
+
+	folder, err = b.wrapped.GetFolder(ctx, folderName)
+	return
+}
+
+func (b *debugBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	id, desc, start := b.startRequest("CreateFolder(%q)", folderName)
+	defer b.finishRequest(id, desc, start, &err)
+
+	folder, err = b.wrapped.CreateFolder(ctx, folderName)
+	return
+}
+
+func (b *debugBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	id, desc, start := b.startRequest("RenameFolder(%q, %q)", folderName, destinationFolderId)
+	defer b.finishRequest(id, desc, start, &err)
+
+	folder, err = b.wrapped.RenameFolder(ctx, folderName, destinationFolderId)
+	return
+}
@@ -25,6 +25,7 @@ import (
 	"io"
 	"sort"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
@@ -42,6 +43,31 @@ func NewFakeBucket(clock timeutil.Clock, name string) gcs.Bucket {
 	return b
 }
 
+// NewHierarchicalFakeBucket returns a fake bucket with a hierarchical
+// namespace, i.e. in which objects are contained in folders. As in GCS, the
+// folders containing an object are created along with it, and folders can be
+// created, looked up and renamed along with their contents.
+func NewHierarchicalFakeBucket(clock timeutil.Clock, name string) gcs.Bucket {
+	b := &bucket{
+		clock:      clock,
+		name:       name,
+		bucketType: gcs.Hierarchical,
+		folders:    make(map[string]gcs.Folder),
+	}
+	b.mu = syncutil.NewInvariantMutex(b.checkInvariants)
+	return b
+}
+
+// NewVersionedFakeBucket returns a fake bucket with object versioning
+// enabled, i.e. which retains the generations of objects that are replaced or
+// deleted as noncurrent generations. These can be listed and read by
+// generation.
+func NewVersionedFakeBucket(clock timeutil.Clock, name string) gcs.Bucket {
+	b := &bucket{clock: clock, name: name, versioning: true}
+	b.mu = syncutil.NewInvariantMutex(b.checkInvariants)
+	return b
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Helper types
 ////////////////////////////////////////////////////////////////////////
@@ -127,6 +153,7 @@ type bucket struct {
 	clock      timeutil.Clock
 	name       string
 	bucketType gcs.BucketType
+	versioning bool
 	mu         syncutil.InvariantMutex
 
 	// The set of extant objects.
@@ -134,6 +161,15 @@ type bucket struct {
 	// INVARIANT: Strictly increasing.
 	objects fakeObjectSlice // GUARDED_BY(mu)
 
+	// The noncurrent generations of objects, in the order in which they were
+	// replaced or deleted. Always empty unless versioning is enabled.
+	noncurrentObjects []fakeObject // GUARDED_BY(mu)
+
+	// The folders of the bucket by name, if it has a hierarchical namespace.
+	//
+	// INVARIANT: For each k, v: v.Name == k and k ends with a slash
+	folders map[string]gcs.Folder // GUARDED_BY(mu)
+
 	// The most recent generation number that was minted. The next object will
 	// receive generation prevGeneration + 1.
 	//
@@ -177,6 +213,13 @@ func (b *bucket) checkInvariants() {
 		}
 	}
 
+	// Make sure folders are indexed by their names, which end with a slash.
+	for k, f := range b.folders {
+		if f.Name != k || !strings.HasSuffix(k, "/") {
+			panic(fmt.Sprintf("Unexpected folder %q for key %q", f.Name, k))
+		}
+	}
+
 	// Make sure prevGeneration is an upper bound for object generation numbers.
 	for _, o := range b.objects {
 		if !(o.metadata.Generation <= b.prevGeneration) {
@@ -216,6 +259,7 @@ func (b *bucket) mintObject(
 		Generation:      b.prevGeneration,
 		MetaGeneration:  1,
 		StorageClass:    "STANDARD",
+		Created:         b.clock.Now(),
 		Updated:         b.clock.Now(),
 	}
 
@@ -334,11 +378,92 @@ func (b *bucket) createObjectLocked(
 
 	// Replace an entry in or add an entry to our list of objects.
 	if existingIndex < len(b.objects) {
+		b.retireLocked(b.objects[existingIndex])
 		b.objects[existingIndex] = fo
 	} else {
 		b.objects = append(b.objects, fo)
 		sort.Sort(b.objects)
 	}
+	b.createParentFoldersLocked(req.Name)
+
+	return
+}
+
+// Create any missing folders containing the object or folder with the given
+// name, if the bucket has a hierarchical namespace.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) createParentFoldersLocked(name string) {
+	if b.bucketType != gcs.Hierarchical {
+		return
+	}
+
+	// Each proper prefix of the name that ends with a slash names a folder.
+	for i := 0; i < len(name)-1; i++ {
+		if name[i] != '/' {
+			continue
+		}
+
+		folderName := name[:i+1]
+		if _, ok := b.folders[folderName]; !ok {
+			b.folders[folderName] = gcs.Folder{
+				Name:           folderName,
+				MetaGeneration: 1,
+				UpdateTime:     b.clock.Now(),
+			}
+		}
+	}
+}
+
+// Return an error unless the bucket has folders.
+func (b *bucket) checkHierarchical() error {
+	if b.bucketType != gcs.Hierarchical {
+		return errors.New("Folders are only supported in hierarchical buckets")
+	}
+
+	return nil
+}
+
+// Retain the given live generation of an object, which is being replaced or
+// deleted, as a noncurrent generation if versioning is enabled.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) retireLocked(o fakeObject) {
+	if !b.versioning {
+		return
+	}
+
+	o.metadata.Deleted = b.clock.Now()
+	b.noncurrentObjects = append(b.noncurrentObjects, o)
+}
+
+// Find the noncurrent generation of the object with the given name.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) findNoncurrentLocked(name string, generation int64) (o fakeObject, ok bool) {
+	for _, n := range b.noncurrentObjects {
+		if n.metadata.Name == name && n.metadata.Generation == generation {
+			return n, true
+		}
+	}
+
+	return
+}
+
+// Return all the live and noncurrent generations of objects, sorted by name
+// and then by generation.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) allGenerationsLocked() (objects fakeObjectSlice) {
+	objects = make(fakeObjectSlice, 0, len(b.objects)+len(b.noncurrentObjects))
+	objects = append(objects, b.objects...)
+	objects = append(objects, b.noncurrentObjects...)
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].metadata.Name != objects[j].metadata.Name {
+			return objects[i].metadata.Name < objects[j].metadata.Name
+		}
+		return objects[i].metadata.Generation < objects[j].metadata.Generation
+	})
 
 	return
 }
@@ -371,12 +496,20 @@ func (b *bucket) newReaderLocked(
 		return
 	}
 
+	r = rangeReader(o.data, req.Range)
+
+	return
+}
+
+// rangeReader returns a reader for the given range of data, or all of it if
+// the range is nil.
+func rangeReader(data []byte, byteRange *gcs.ByteRange) io.Reader {
 	// Extract the requested range.
-	result := o.data
+	result := data
 
-	if req.Range != nil {
-		start := req.Range.Start
-		limit := req.Range.Limit
+	if byteRange != nil {
+		start := byteRange.Start
+		limit := byteRange.Limit
 		l := uint64(len(result))
 
 		if start > limit {
@@ -396,9 +529,7 @@ func (b *bucket) newReaderLocked(
 		result = result[start:limit]
 	}
 
-	r = bytes.NewReader(result)
-
-	return
+	return bytes.NewReader(result)
 }
 
 func minInt(a, b int) int {
@@ -450,6 +581,17 @@ func (b *bucket) ListObjects(
 	// Set up the result object.
 	listing = new(gcs.Listing)
 
+	// Soft delete isn't supported, hence there are no soft-deleted objects.
+	if req.SoftDeleted {
+		return
+	}
+
+	// Find the objects to scan.
+	objects := b.objects
+	if req.Versions {
+		objects = b.allGenerationsLocked()
+	}
+
 	// Handle defaults.
 	maxResults := req.MaxResults
 	if maxResults == 0 {
@@ -463,14 +605,34 @@ func (b *bucket) ListObjects(
 	}
 
 	// Find the range of indexes within the array to scan.
-	indexStart := b.objects.lowerBound(nameStart)
-	prefixLimit := b.objects.prefixUpperBound(req.Prefix)
+	indexStart := objects.lowerBound(nameStart)
+	prefixLimit := objects.prefixUpperBound(req.Prefix)
 	indexLimit := minInt(indexStart+maxResults, prefixLimit)
 
+	// The next scan starts at the first generation of an object, hence don't
+	// split the generations of an object across scans. Leave the object out of
+	// this scan, or if it's the only one, return all of its generations.
+	if indexLimit < prefixLimit &&
+		objects[indexLimit].metadata.Name == objects[indexLimit-1].metadata.Name {
+		split := objects[indexLimit].metadata.Name
+		firstIndex := indexLimit
+		for firstIndex > indexStart && objects[firstIndex-1].metadata.Name == split {
+			firstIndex--
+		}
+
+		if firstIndex > indexStart {
+			indexLimit = firstIndex
+		} else {
+			for indexLimit < prefixLimit && objects[indexLimit].metadata.Name == split {
+				indexLimit++
+			}
+		}
+	}
+
 	// Scan the array.
 	var lastResultWasPrefix bool
 	for i := indexStart; i < indexLimit; i++ {
-		var o fakeObject = b.objects[i]
+		var o fakeObject = objects[i]
 		name := o.metadata.Name
 
 		// Search for a delimiter if necessary.
@@ -531,13 +693,51 @@ func (b *bucket) ListObjects(
 			}
 		} else {
 			// Otherwise, we'll start scanning at the next object.
-			listing.ContinuationToken = b.objects[indexLimit].metadata.Name
+			listing.ContinuationToken = objects[indexLimit].metadata.Name
 		}
 	}
 
+	// Folders are listed as prefixes on request, even if they're empty.
+	if b.bucketType == gcs.Hierarchical &&
+		req.IncludeFoldersAsPrefixes &&
+		req.Delimiter == "/" &&
+		!req.Versions {
+		b.listFoldersLocked(listing, req.Prefix, nameStart)
+	}
+
 	return
 }
 
+// Add the folders that are direct children of the prefix to the collapsed
+// runs of a listing that covers the names from nameStart to its continuation
+// token.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) listFoldersLocked(
+	listing *gcs.Listing,
+	prefix string,
+	nameStart string) {
+	listed := make(map[string]bool)
+	for _, p := range listing.CollapsedRuns {
+		listed[p] = true
+	}
+
+	for name := range b.folders {
+		if !strings.HasPrefix(name, prefix) ||
+			name == prefix ||
+			strings.Contains(name[len(prefix):len(name)-1], "/") ||
+			name < nameStart ||
+			(listing.ContinuationToken != "" && name >= listing.ContinuationToken) ||
+			listed[name] {
+			continue
+		}
+
+		listing.CollapsedRuns = append(listing.CollapsedRuns, name)
+	}
+
+	sort.Strings(listing.CollapsedRuns)
+}
+
 // LOCKS_EXCLUDED(b.mu)
 func (b *bucket) NewReader(
 	ctx context.Context,
@@ -546,6 +746,15 @@ func (b *bucket) NewReader(
 	defer b.mu.Unlock()
 
 	r, _, err := b.newReaderLocked(req)
+
+	// A noncurrent generation may be read as well.
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) && req.Generation != 0 {
+		if o, ok := b.findNoncurrentLocked(req.Name, req.Generation); ok {
+			r, err = rangeReader(o.data, req.Range), nil
+		}
+	}
+
 	if err != nil {
 		return
 	}
@@ -558,10 +767,21 @@ func (b *bucket) NewReader(
 func (b *bucket) CreateObject(
 	ctx context.Context,
 	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Snarf the contents without holding the lock, as they may be streamed by a
+	// writer that uses the bucket meanwhile.
+	contents, err := io.ReadAll(req.Contents)
+	if err != nil {
+		err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+
+	reqCopy := *req
+	reqCopy.Contents = bytes.NewReader(contents)
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	o, err = b.createObjectLocked(req)
+	o, err = b.createObjectLocked(&reqCopy)
 	return
 }
 
@@ -580,7 +800,7 @@ func (b *bucket) CopyObject(
 
 	// Does the object exist?
 	srcIndex := b.objects.find(req.SrcName)
-	if srcIndex == len(b.objects) {
+	if srcIndex == len(b.objects) && req.SrcGeneration == 0 {
 		err = &gcs.NotFoundError{
 			Err: fmt.Errorf("Object %q not found", req.SrcName),
 		}
@@ -588,9 +808,15 @@ func (b *bucket) CopyObject(
 		return
 	}
 
-	// Does it have the correct generation?
-	if req.SrcGeneration != 0 &&
-		b.objects[srcIndex].metadata.Generation != req.SrcGeneration {
+	// Does it have the correct generation? A noncurrent generation may be
+	// copied as well.
+	var src fakeObject
+	if srcIndex < len(b.objects) &&
+		(req.SrcGeneration == 0 || b.objects[srcIndex].metadata.Generation == req.SrcGeneration) {
+		src = b.objects[srcIndex]
+	} else if noncurrent, ok := b.findNoncurrentLocked(req.SrcName, req.SrcGeneration); ok {
+		src = noncurrent
+	} else {
 		err = &gcs.NotFoundError{
 			Err: fmt.Errorf(
 				"Object %s generation %d not found", req.SrcName, req.SrcGeneration),
@@ -602,12 +828,32 @@ func (b *bucket) CopyObject(
 	// Does it have the correct meta-generation?
 	if req.SrcMetaGenerationPrecondition != nil {
 		p := *req.SrcMetaGenerationPrecondition
-		if b.objects[srcIndex].metadata.MetaGeneration != p {
+		if src.metadata.MetaGeneration != p {
 			err = &gcs.PreconditionError{
 				Err: fmt.Errorf(
 					"Object %q has meta-generation %d",
 					req.SrcName,
-					b.objects[srcIndex].metadata.MetaGeneration),
+					src.metadata.MetaGeneration),
+			}
+
+			return
+		}
+	}
+
+	// Does the destination have the correct generation?
+	existingIndex := b.objects.find(req.DstName)
+	if req.DstGenerationPrecondition != nil {
+		var existingGen int64
+		if existingIndex < len(b.objects) {
+			existingGen = b.objects[existingIndex].metadata.Generation
+		}
+
+		if existingGen != *req.DstGenerationPrecondition {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Precondition failed: object %q has generation %v",
+					req.DstName,
+					existingGen),
 			}
 
 			return
@@ -616,7 +862,9 @@ func (b *bucket) CopyObject(
 
 	// Copy it and assign a new generation number, to ensure that the generation
 	// number for the destination name is strictly increasing.
-	dst := b.objects[srcIndex]
+	dst := src
+	dst.metadata.Created = b.clock.Now()
+	dst.metadata.Deleted = time.Time{}
 	dst.metadata.Name = req.DstName
 	dst.metadata.MediaLink = "http://localhost/download/storage/fake/" + req.DstName
 
@@ -624,13 +872,14 @@ func (b *bucket) CopyObject(
 	dst.metadata.Generation = b.prevGeneration
 
 	// Insert into our array.
-	existingIndex := b.objects.find(req.DstName)
 	if existingIndex < len(b.objects) {
+		b.retireLocked(b.objects[existingIndex])
 		b.objects[existingIndex] = dst
 	} else {
 		b.objects = append(b.objects, dst)
 		sort.Sort(b.objects)
 	}
+	b.createParentFoldersLocked(req.DstName)
 
 	o = copyObject(&dst.metadata)
 	return
@@ -862,15 +1111,49 @@ func (b *bucket) DeleteObject(
 	}
 
 	// Remove the object.
+	b.retireLocked(b.objects[index])
 	b.objects = append(b.objects[:index], b.objects[index+1:]...)
 
 	return
 }
 
+// LOCKS_EXCLUDED(b.mu)
 func (b *bucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	// In a hierarchical bucket, only empty folders can be deleted.
+	if b.bucketType == gcs.Hierarchical {
+		if _, ok := b.folders[folderName]; !ok {
+			err = &gcs.NotFoundError{
+				Err: fmt.Errorf("Folder %q not found", folderName),
+			}
+
+			return
+		}
+
+		if b.objects.prefixUpperBound(folderName) > b.objects.lowerBound(folderName) {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf("Folder %q is not empty", folderName),
+			}
+
+			return
+		}
+
+		for name := range b.folders {
+			if name != folderName && strings.HasPrefix(name, folderName) {
+				err = &gcs.PreconditionError{
+					Err: fmt.Errorf("Folder %q is not empty", folderName),
+				}
+
+				return
+			}
+		}
+
+		delete(b.folders, folderName)
+		return
+	}
+
 	// Do we possess the object with the given name?
 	index := b.objects.find(folderName)
 	if index == len(b.objects) {
@@ -882,3 +1165,137 @@ func (b *bucket) DeleteFolder(ctx context.Context, folderName string) (err error
 
 	return
 }
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	if err = b.checkHierarchical(); err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.folders[folderName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Folder %q not found", folderName),
+		}
+
+		return
+	}
+
+	folder = &f
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	if err = b.checkHierarchical(); err != nil {
+		return
+	}
+
+	if err = checkName(folderName); err != nil {
+		return
+	}
+
+	if !strings.HasSuffix(folderName, "/") {
+		err = fmt.Errorf("Invalid folder name %q: must end with a slash", folderName)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.folders[folderName]; ok {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("Folder %q already exists", folderName),
+		}
+
+		return
+	}
+
+	f := gcs.Folder{
+		Name:           folderName,
+		MetaGeneration: 1,
+		UpdateTime:     b.clock.Now(),
+	}
+	b.folders[folderName] = f
+	b.createParentFoldersLocked(folderName)
+
+	folder = &f
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) RenameFolder(
+	ctx context.Context,
+	folderName string,
+	destinationFolderId string) (folder *gcs.Folder, err error) {
+	if err = b.checkHierarchical(); err != nil {
+		return
+	}
+
+	if err = checkName(destinationFolderId); err != nil {
+		return
+	}
+
+	if !strings.HasSuffix(destinationFolderId, "/") {
+		err = fmt.Errorf("Invalid folder name %q: must end with a slash", destinationFolderId)
+		return
+	}
+
+	if strings.HasPrefix(destinationFolderId, folderName) {
+		err = fmt.Errorf("Can't rename folder %q into itself", folderName)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.folders[folderName]; !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Folder %q not found", folderName),
+		}
+
+		return
+	}
+
+	if _, ok := b.folders[destinationFolderId]; ok {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("Folder %q already exists", destinationFolderId),
+		}
+
+		return
+	}
+
+	// Move the folder and the folders within it.
+	now := b.clock.Now()
+	for name, f := range b.folders {
+		if !strings.HasPrefix(name, folderName) {
+			continue
+		}
+
+		delete(b.folders, name)
+		f.Name = destinationFolderId + strings.TrimPrefix(name, folderName)
+		f.MetaGeneration++
+		f.UpdateTime = now
+		b.folders[f.Name] = f
+	}
+
+	// Move the live objects within it, which keep their generations.
+	for i := range b.objects {
+		o := &b.objects[i].metadata
+		if !strings.HasPrefix(o.Name, folderName) {
+			continue
+		}
+
+		o.Name = destinationFolderId + strings.TrimPrefix(o.Name, folderName)
+		o.MediaLink = "http://localhost/download/storage/fake/" + o.Name
+	}
+	sort.Sort(b.objects)
+	b.createParentFoldersLocked(destinationFolderId)
+
+	f := b.folders[destinationFolderId]
+	folder = &f
+	return
+}
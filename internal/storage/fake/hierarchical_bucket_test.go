@@ -0,0 +1,143 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHierarchicalBucket(t *testing.T) gcs.Bucket {
+	t.Helper()
+	clock := &timeutil.SimulatedClock{}
+	clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	return NewHierarchicalFakeBucket(clock, "some_bucket")
+}
+
+func TestHierarchicalBucket_CreateObjectCreatesParentFolders(t *testing.T) {
+	ctx := context.Background()
+	b := newHierarchicalBucket(t)
+	_, err := storageutil.CreateObject(ctx, b, "a/b/c", []byte("taco"))
+	require.NoError(t, err)
+
+	for _, name := range []string{"a/", "a/b/"} {
+		folder, err := b.GetFolder(ctx, name)
+		require.NoError(t, err)
+		assert.Equal(t, name, folder.Name)
+	}
+	_, err = b.GetFolder(ctx, "a/b/c/")
+	var notFoundErr *gcs.NotFoundError
+	assert.ErrorAs(t, err, &notFoundErr)
+	assert.Equal(t, gcs.Hierarchical, b.BucketType())
+}
+
+func TestHierarchicalBucket_CreateFolderTwice(t *testing.T) {
+	ctx := context.Background()
+	b := newHierarchicalBucket(t)
+	_, err := b.CreateFolder(ctx, "a/")
+	require.NoError(t, err)
+
+	_, err = b.CreateFolder(ctx, "a/")
+
+	var preconditionErr *gcs.PreconditionError
+	assert.ErrorAs(t, err, &preconditionErr)
+}
+
+func TestHierarchicalBucket_ListEmptyFolders(t *testing.T) {
+	ctx := context.Background()
+	b := newHierarchicalBucket(t)
+	_, err := b.CreateFolder(ctx, "a/empty/")
+	require.NoError(t, err)
+	_, err = storageutil.CreateObject(ctx, b, "a/full/c", []byte("taco"))
+	require.NoError(t, err)
+
+	listing, err := b.ListObjects(ctx, &gcs.ListObjectsRequest{Prefix: "a/", Delimiter: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/full/"}, listing.CollapsedRuns)
+
+	listing, err = b.ListObjects(ctx, &gcs.ListObjectsRequest{Prefix: "a/", Delimiter: "/", IncludeFoldersAsPrefixes: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/empty/", "a/full/"}, listing.CollapsedRuns)
+}
+
+func TestHierarchicalBucket_RenameFolder(t *testing.T) {
+	ctx := context.Background()
+	b := newHierarchicalBucket(t)
+	o, err := storageutil.CreateObject(ctx, b, "a/b/c", []byte("taco"))
+	require.NoError(t, err)
+	_, err = b.CreateFolder(ctx, "a/empty/")
+	require.NoError(t, err)
+
+	folder, err := b.RenameFolder(ctx, "a/", "x/")
+
+	require.NoError(t, err)
+	assert.Equal(t, "x/", folder.Name)
+	for _, name := range []string{"x/b/", "x/empty/"} {
+		_, err = b.GetFolder(ctx, name)
+		assert.NoError(t, err)
+	}
+	m, _, err := b.StatObject(ctx, &gcs.StatObjectRequest{Name: "x/b/c"})
+	require.NoError(t, err)
+	assert.Equal(t, o.Generation, m.Generation)
+	var notFoundErr *gcs.NotFoundError
+	_, err = b.GetFolder(ctx, "a/")
+	assert.ErrorAs(t, err, &notFoundErr)
+	_, _, err = b.StatObject(ctx, &gcs.StatObjectRequest{Name: "a/b/c"})
+	assert.ErrorAs(t, err, &notFoundErr)
+}
+
+func TestHierarchicalBucket_RenameFolderOntoExistingFolder(t *testing.T) {
+	ctx := context.Background()
+	b := newHierarchicalBucket(t)
+	_, err := b.CreateFolder(ctx, "a/")
+	require.NoError(t, err)
+	_, err = b.CreateFolder(ctx, "x/")
+	require.NoError(t, err)
+
+	_, err = b.RenameFolder(ctx, "a/", "x/")
+
+	var preconditionErr *gcs.PreconditionError
+	assert.ErrorAs(t, err, &preconditionErr)
+}
+
+func TestHierarchicalBucket_DeleteNonEmptyFolder(t *testing.T) {
+	ctx := context.Background()
+	b := newHierarchicalBucket(t)
+	_, err := b.CreateFolder(ctx, "a/b/")
+	require.NoError(t, err)
+
+	err = b.DeleteFolder(ctx, "a/")
+
+	var preconditionErr *gcs.PreconditionError
+	assert.ErrorAs(t, err, &preconditionErr)
+	require.NoError(t, b.DeleteFolder(ctx, "a/b/"))
+	require.NoError(t, b.DeleteFolder(ctx, "a/"))
+}
+
+func TestFlatBucket_HasNoFolders(t *testing.T) {
+	ctx := context.Background()
+	b := NewFakeBucket(&timeutil.SimulatedClock{}, "some_bucket")
+
+	_, err := b.GetFolder(ctx, "a/")
+
+	assert.Error(t, err)
+}
@@ -176,21 +176,15 @@ func interestingNames() (names []string) {
 	// All codepoints in Unicode general categories C* (control and special) and
 	// Z* (space), except for:
 	//
-	//  *  Cn (non-character and reserved), which is not included in unicode.C.
+	//  *  Cn (non-character and reserved), which is large.
 	//  *  Co (private usage), which is large.
 	//  *  Cs (surrages), which is large.
 	//  *  U+000A and U+000D, which are forbidden by the docs.
 	//
+	// The categories are listed rather than using unicode.C, which includes Cn
+	// as of Go 1.27.
 	for r := rune(0); r <= unicode.MaxRune; r++ {
-		if !unicode.In(r, unicode.C) && !unicode.In(r, unicode.Z) {
-			continue
-		}
-
-		if unicode.In(r, unicode.Co) {
-			continue
-		}
-
-		if unicode.In(r, unicode.Cs) {
+		if !unicode.In(r, unicode.Cc, unicode.Cf) && !unicode.In(r, unicode.Z) {
 			continue
 		}
 
@@ -1639,6 +1633,87 @@ func (t *copyTest) SrcMetaGenerationPrecondition_Satisfied() {
 	ExpectEq(nil, err)
 }
 
+func (t *copyTest) DstGenerationPrecondition_Unsatisfied() {
+	var err error
+
+	// Create a source and a destination object.
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+
+	dst, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "bar",
+			Contents: strings.NewReader("burrito"),
+		})
+
+	AssertEq(nil, err)
+
+	// Attempt to copy, with a precondition that the destination doesn't exist.
+	var precond int64
+	req := &gcs.CopyObjectRequest{
+		SrcName:                   "foo",
+		DstName:                   "bar",
+		DstGenerationPrecondition: &precond,
+	}
+
+	_, err = t.bucket.CopyObject(t.ctx, req)
+	AssertThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The destination should be unchanged.
+	m, _, err := t.bucket.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: "bar"})
+
+	AssertEq(nil, err)
+	ExpectEq(dst.Generation, m.Generation)
+}
+
+func (t *copyTest) DstGenerationPrecondition_Satisfied() {
+	var err error
+
+	// Create a source and a destination object.
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+
+	dst, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "bar",
+			Contents: strings.NewReader("burrito"),
+		})
+
+	AssertEq(nil, err)
+
+	// Copy, with a precondition on the destination's generation.
+	req := &gcs.CopyObjectRequest{
+		SrcName:                   "foo",
+		DstName:                   "bar",
+		DstGenerationPrecondition: &dst.Generation,
+	}
+
+	o, err := t.bucket.CopyObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectNe(dst.Generation, o.Generation)
+
+	// The destination should have been overwritten.
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Compose
 ////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,151 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newVersionedBucket(t *testing.T) (gcs.Bucket, *timeutil.SimulatedClock) {
+	t.Helper()
+	clock := &timeutil.SimulatedClock{}
+	clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	return NewVersionedFakeBucket(clock, "some_bucket"), clock
+}
+
+func TestVersionedBucket_ListVersions(t *testing.T) {
+	ctx := context.Background()
+	b, clock := newVersionedBucket(t)
+	o1, err := storageutil.CreateObject(ctx, b, "foo", []byte("taco"))
+	require.NoError(t, err)
+	clock.AdvanceTime(time.Second)
+	o2, err := storageutil.CreateObject(ctx, b, "foo", []byte("burrito"))
+	require.NoError(t, err)
+	_, err = storageutil.CreateObject(ctx, b, "bar", []byte("enchilada"))
+	require.NoError(t, err)
+	require.NoError(t, b.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: "bar"}))
+
+	listing, err := b.ListObjects(ctx, &gcs.ListObjectsRequest{Versions: true})
+
+	require.NoError(t, err)
+	require.Len(t, listing.Objects, 3)
+	assert.Equal(t, "bar", listing.Objects[0].Name)
+	assert.False(t, listing.Objects[0].Deleted.IsZero())
+	assert.Equal(t, o1.Generation, listing.Objects[1].Generation)
+	assert.Equal(t, clock.Now(), listing.Objects[1].Deleted)
+	assert.Equal(t, o2.Generation, listing.Objects[2].Generation)
+	assert.True(t, listing.Objects[2].Deleted.IsZero())
+	// Noncurrent generations are only listed on request.
+	listing, err = b.ListObjects(ctx, &gcs.ListObjectsRequest{})
+	require.NoError(t, err)
+	require.Len(t, listing.Objects, 1)
+	assert.Equal(t, o2.Generation, listing.Objects[0].Generation)
+}
+
+func TestVersionedBucket_ListVersions_Paginated(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newVersionedBucket(t)
+	for _, name := range []string{"a", "a", "b", "b", "c"} {
+		_, err := storageutil.CreateObject(ctx, b, name, []byte(name))
+		require.NoError(t, err)
+	}
+
+	var generations []int64
+	req := &gcs.ListObjectsRequest{Versions: true, MaxResults: 3}
+	for {
+		listing, err := b.ListObjects(ctx, req)
+		require.NoError(t, err)
+		for _, o := range listing.Objects {
+			generations = append(generations, o.Generation)
+		}
+		if listing.ContinuationToken == "" {
+			break
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	// Each generation is listed exactly once.
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, generations)
+}
+
+func TestVersionedBucket_ReadAndCopyNoncurrentGeneration(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newVersionedBucket(t)
+	o1, err := storageutil.CreateObject(ctx, b, "foo", []byte("taco"))
+	require.NoError(t, err)
+	_, err = storageutil.CreateObject(ctx, b, "foo", []byte("burrito"))
+	require.NoError(t, err)
+
+	rc, err := b.NewReader(ctx, &gcs.ReadObjectRequest{
+		Name:       "foo",
+		Generation: o1.Generation,
+		Range:      &gcs.ByteRange{Start: 1, Limit: 3},
+	})
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	n, _ := rc.Read(buf)
+	assert.Equal(t, "ac", string(buf[:n]))
+
+	restored, err := b.CopyObject(ctx, &gcs.CopyObjectRequest{
+		SrcName:       "foo",
+		SrcGeneration: o1.Generation,
+		DstName:       "foo",
+	})
+	require.NoError(t, err)
+	contents, err := storageutil.ReadObject(ctx, b, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "taco", string(contents))
+	assert.True(t, restored.Deleted.IsZero())
+}
+
+func TestFakeBucket_NoVersioning(t *testing.T) {
+	ctx := context.Background()
+	b := NewFakeBucket(&timeutil.SimulatedClock{}, "some_bucket")
+	o1, err := storageutil.CreateObject(ctx, b, "foo", []byte("taco"))
+	require.NoError(t, err)
+	_, err = storageutil.CreateObject(ctx, b, "foo", []byte("burrito"))
+	require.NoError(t, err)
+
+	listing, err := b.ListObjects(ctx, &gcs.ListObjectsRequest{Versions: true})
+	require.NoError(t, err)
+	assert.Len(t, listing.Objects, 1)
+	_, err = b.NewReader(ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o1.Generation})
+	var notFoundErr *gcs.NotFoundError
+	assert.ErrorAs(t, err, &notFoundErr)
+}
+
+func TestVersionedBucket_ListVersions_MoreGenerationsThanMaxResults(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newVersionedBucket(t)
+	for _, name := range []string{"a", "a", "a", "b"} {
+		_, err := storageutil.CreateObject(ctx, b, name, []byte(name))
+		require.NoError(t, err)
+	}
+
+	listing, err := b.ListObjects(ctx, &gcs.ListObjectsRequest{Versions: true, MaxResults: 2})
+
+	// All the generations of the first object are listed at once.
+	require.NoError(t, err)
+	assert.Len(t, listing.Objects, 3)
+	assert.Equal(t, "b", listing.ContinuationToken)
+}
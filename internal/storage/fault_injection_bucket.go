@@ -0,0 +1,378 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// NewFaultInjectionBucket wraps the supplied bucket in a layer that injects
+// the faults described by the supplied config into requests: latency, HTTP 429
+// and 503 errors, precondition errors, and truncated and stalled reads.
+func NewFaultInjectionBucket(
+	cfg config.FaultInjectionConfig,
+	wrapped gcs.Bucket) (b gcs.Bucket, err error) {
+	fb := &faultInjectionBucket{
+		wrapped: wrapped,
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fb.rand = rand.New(rand.NewSource(seed))
+
+	for i, rule := range cfg.Rules {
+		var pattern *regexp.Regexp
+		pattern, err = regexp.Compile(rule.ObjectPattern)
+		if err != nil {
+			err = fmt.Errorf("rule %d: invalid object-pattern: %w", i, err)
+			return
+		}
+
+		fb.rules = append(fb.rules, faultRule{FaultRule: rule, pattern: pattern})
+	}
+
+	b = fb
+	return
+}
+
+type faultRule struct {
+	config.FaultRule
+	pattern *regexp.Regexp
+}
+
+type faultInjectionBucket struct {
+	wrapped gcs.Bucket
+	rules   []faultRule
+
+	mu   sync.Mutex
+	rand *rand.Rand // GUARDED_BY(mu)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Return whether a fault with the given probability should be injected.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *faultInjectionBucket) roll(probability float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rand.Float64() < probability
+}
+
+// Return the rules whose faults should be injected into a request to the
+// given method for the given object name.
+func (b *faultInjectionBucket) firingRules(method string, name string) (rules []*faultRule) {
+	for i := range b.rules {
+		rule := &b.rules[i]
+		if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, method) {
+			continue
+		}
+
+		if !rule.pattern.MatchString(name) || !b.roll(rule.Probability) {
+			continue
+		}
+
+		logger.Tracef("fault-injection: %s(%q): injecting %s", method, name, rule.Fault)
+		rules = append(rules, rule)
+	}
+
+	return
+}
+
+// Sleep for the given duration, unless the context is cancelled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Inject the faults of the rules firing for a request to the given method for
+// the given object name, other than those affecting the reader returned by
+// NewReader. Return the error to fail the request with, if any.
+func (b *faultInjectionBucket) inject(
+	ctx context.Context,
+	method string,
+	name string) (rules []*faultRule, err error) {
+	rules = b.firingRules(method, name)
+	for _, rule := range rules {
+		switch rule.Fault {
+		case config.LatencyFault:
+			err = sleep(ctx, rule.Latency)
+
+		case config.TooManyRequestsFault:
+			err = &googleapi.Error{
+				Code:    http.StatusTooManyRequests,
+				Message: "fault injected: too many requests",
+			}
+
+		case config.ServiceUnavailableFault:
+			err = &googleapi.Error{
+				Code:    http.StatusServiceUnavailable,
+				Message: "fault injected: service unavailable",
+			}
+
+		case config.PreconditionFault:
+			err = &gcs.PreconditionError{
+				Err: errors.New("fault injected: precondition failed"),
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Reader
+////////////////////////////////////////////////////////////////////////
+
+// A reader which truncates or stalls the wrapped stream after a number of
+// bytes.
+type faultInjectionReader struct {
+	ctx     context.Context
+	wrapped io.ReadCloser
+
+	// The number of bytes read so far.
+	offset int64
+
+	// The offset after which to end the stream, or -1 for none.
+	truncateAt int64
+
+	// The stalls yet to happen, in increasing order of offset.
+	stalls []*faultRule
+}
+
+func (r *faultInjectionReader) Read(p []byte) (n int, err error) {
+	// Stall if we've reached the offset of the next stall.
+	for len(r.stalls) > 0 && r.offset >= r.stalls[0].AfterBytes {
+		err = sleep(r.ctx, r.stalls[0].Latency)
+		r.stalls = r.stalls[1:]
+		if err != nil {
+			return
+		}
+	}
+
+	// Don't read past the next stall or the truncation.
+	limit := int64(len(p))
+	if len(r.stalls) > 0 {
+		limit = min(limit, r.stalls[0].AfterBytes-r.offset)
+	}
+	if r.truncateAt >= 0 {
+		if r.offset >= r.truncateAt {
+			err = fmt.Errorf("fault injected: truncated after %d bytes: %w", r.offset, io.ErrUnexpectedEOF)
+			return
+		}
+		limit = min(limit, r.truncateAt-r.offset)
+	}
+
+	n, err = r.wrapped.Read(p[:limit])
+	r.offset += int64(n)
+	return
+}
+
+func (r *faultInjectionReader) Close() error {
+	return r.wrapped.Close()
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *faultInjectionBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *faultInjectionBucket) BucketType() gcs.BucketType {
+	return b.wrapped.BucketType()
+}
+
+func (b *faultInjectionBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rules, err := b.inject(ctx, "NewReader", req.Name)
+	if err != nil {
+		return
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Wrap the reader if any of the faults affect it.
+	r := &faultInjectionReader{ctx: ctx, wrapped: rc, truncateAt: -1}
+	for _, rule := range rules {
+		switch rule.Fault {
+		case config.TruncateFault:
+			if r.truncateAt < 0 || rule.AfterBytes < r.truncateAt {
+				r.truncateAt = rule.AfterBytes
+			}
+
+		case config.StallFault:
+			r.stalls = append(r.stalls, rule)
+		}
+	}
+
+	if r.truncateAt >= 0 || len(r.stalls) > 0 {
+		slices.SortStableFunc(r.stalls, func(a, b *faultRule) int {
+			return cmp.Compare(a.AfterBytes, b.AfterBytes)
+		})
+		rc = r
+	}
+
+	return
+}
+
+func (b *faultInjectionBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if _, err = b.inject(ctx, "CreateObject", req.Name); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	if _, err = b.inject(ctx, "CopyObject", req.DstName); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	if _, err = b.inject(ctx, "ComposeObjects", req.DstName); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, e *gcs.ExtendedObjectAttributes, err error) {
+	if _, err = b.inject(ctx, "StatObject", req.Name); err != nil {
+		return
+	}
+
+	m, e, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	if _, err = b.inject(ctx, "ListObjects", req.Prefix); err != nil {
+		return
+	}
+
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	if _, err = b.inject(ctx, "UpdateObject", req.Name); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	if _, err = b.inject(ctx, "DeleteObject", req.Name); err != nil {
+		return
+	}
+
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *faultInjectionBucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
+	if _, err = b.inject(ctx, "DeleteFolder", folderName); err != nil {
+		return
+	}
+
+	err = b.wrapped.DeleteFolder(ctx, folderName)
+	return
+}
+
+func (b *faultInjectionBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	if _, err = b.inject(ctx, "GetFolder", folderName); err != nil {
+		return
+	}
+
+	folder, err = b.wrapped.GetFolder(ctx, folderName)
+	return
+}
+
+func (b *faultInjectionBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	if _, err = b.inject(ctx, "CreateFolder", folderName); err != nil {
+		return
+	}
+
+	folder, err = b.wrapped.CreateFolder(ctx, folderName)
+	return
+}
+
+func (b *faultInjectionBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	if _, err = b.inject(ctx, "RenameFolder", folderName); err != nil {
+		return
+	}
+
+	folder, err = b.wrapped.RenameFolder(ctx, folderName, destinationFolderId)
+	return
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/config"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/api/googleapi"
+)
+
+type FaultInjectionBucketTest struct {
+	suite.Suite
+	ctx     context.Context
+	wrapped gcs.Bucket
+}
+
+func TestFaultInjectionBucketTestSuite(t *testing.T) {
+	suite.Run(t, new(FaultInjectionBucketTest))
+}
+
+func (t *FaultInjectionBucketTest) SetupTest() {
+	t.ctx = context.Background()
+	t.wrapped = fake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	_, err := storageutil.CreateObject(t.ctx, t.wrapped, "foo", []byte("taco burrito"))
+	require.NoError(t.T(), err)
+}
+
+func (t *FaultInjectionBucketTest) newBucket(rules ...config.FaultRule) gcs.Bucket {
+	b, err := NewFaultInjectionBucket(config.FaultInjectionConfig{Seed: 1, Rules: rules}, t.wrapped)
+	require.NoError(t.T(), err)
+	return b
+}
+
+func (t *FaultInjectionBucketTest) TestHTTPErrors() {
+	b := t.newBucket(
+		config.FaultRule{Methods: []string{"StatObject"}, Probability: 1, Fault: config.TooManyRequestsFault},
+		config.FaultRule{Methods: []string{"ListObjects"}, Probability: 1, Fault: config.ServiceUnavailableFault})
+
+	_, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	var apiErr *googleapi.Error
+	require.True(t.T(), errors.As(err, &apiErr))
+	assert.Equal(t.T(), http.StatusTooManyRequests, apiErr.Code)
+
+	_, err = b.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	require.True(t.T(), errors.As(err, &apiErr))
+	assert.Equal(t.T(), http.StatusServiceUnavailable, apiErr.Code)
+
+	// Other methods are unaffected.
+	_, err = storageutil.ReadObject(t.ctx, b, "foo")
+	assert.NoError(t.T(), err)
+}
+
+func (t *FaultInjectionBucketTest) TestPreconditionError() {
+	b := t.newBucket(config.FaultRule{Probability: 1, Fault: config.PreconditionFault})
+
+	_, err := storageutil.CreateObject(t.ctx, b, "bar", []byte("queso"))
+
+	var preconditionErr *gcs.PreconditionError
+	assert.True(t.T(), errors.As(err, &preconditionErr))
+	_, _, err = t.wrapped.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	var notFoundErr *gcs.NotFoundError
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+}
+
+func (t *FaultInjectionBucketTest) TestObjectPattern() {
+	b := t.newBucket(config.FaultRule{ObjectPattern: "^ba", Probability: 1, Fault: config.ServiceUnavailableFault})
+
+	_, err := storageutil.CreateObject(t.ctx, b, "bar", []byte("queso"))
+	assert.Error(t.T(), err)
+	_, err = storageutil.CreateObject(t.ctx, b, "qux", []byte("queso"))
+	assert.NoError(t.T(), err)
+	_, err = b.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "baz"})
+	assert.Error(t.T(), err)
+}
+
+func (t *FaultInjectionBucketTest) TestProbability() {
+	b := t.newBucket(config.FaultRule{Probability: 0.5, Fault: config.ServiceUnavailableFault})
+
+	failures := 0
+	for i := 0; i < 1000; i++ {
+		if _, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"}); err != nil {
+			failures++
+		}
+	}
+
+	assert.InDelta(t.T(), 500, failures, 100)
+}
+
+func (t *FaultInjectionBucketTest) TestLatency() {
+	b := t.newBucket(config.FaultRule{Probability: 1, Fault: config.LatencyFault, Latency: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	assert.NoError(t.T(), err)
+	assert.GreaterOrEqual(t.T(), time.Since(start), 50*time.Millisecond)
+}
+
+func (t *FaultInjectionBucketTest) TestLatency_Cancelled() {
+	b := t.newBucket(config.FaultRule{Probability: 1, Fault: config.LatencyFault, Latency: time.Hour})
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+
+	_, _, err := b.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	assert.ErrorIs(t.T(), err, context.Canceled)
+}
+
+func (t *FaultInjectionBucketTest) TestTruncate() {
+	b := t.newBucket(config.FaultRule{Probability: 1, Fault: config.TruncateFault, AfterBytes: 4})
+
+	rc, err := b.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	contents, err := io.ReadAll(rc)
+
+	assert.ErrorIs(t.T(), err, io.ErrUnexpectedEOF)
+	assert.Equal(t.T(), "taco", string(contents))
+	assert.NoError(t.T(), rc.Close())
+}
+
+func (t *FaultInjectionBucketTest) TestStall() {
+	b := t.newBucket(config.FaultRule{Probability: 1, Fault: config.StallFault, Latency: 50 * time.Millisecond, AfterBytes: 4})
+
+	rc, err := b.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	buf := make([]byte, 100)
+	n, err := rc.Read(buf)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "taco", string(buf[:n]))
+
+	start := time.Now()
+	rest, err := io.ReadAll(rc)
+
+	assert.NoError(t.T(), err)
+	assert.Equal(t.T(), " burrito", string(rest))
+	assert.GreaterOrEqual(t.T(), time.Since(start), 50*time.Millisecond)
+}
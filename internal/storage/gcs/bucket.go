@@ -142,4 +142,27 @@ type Bucket interface {
 		req *DeleteObjectRequest) error
 
 	DeleteFolder(ctx context.Context, folderName string) error
+
+	// Return the folder with the given name, or *NotFoundError if there is
+	// none. Only buckets with a hierarchical namespace have folders.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/folders/get
+	GetFolder(ctx context.Context, folderName string) (*Folder, error)
+
+	// Create a folder with the given name, failing with *PreconditionError if
+	// it already exists.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/folders/insert
+	CreateFolder(ctx context.Context, folderName string) (*Folder, error)
+
+	// Atomically rename a folder, along with everything in it, to
+	// destinationFolderId, returning the renamed folder. Fails with
+	// *NotFoundError if the folder doesn't exist and with *PreconditionError if
+	// the destination does.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/folders/rename
+	RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (*Folder, error)
 }
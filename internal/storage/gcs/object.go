@@ -44,6 +44,7 @@ type Object struct {
 	Generation      int64
 	MetaGeneration  int64
 	StorageClass    string
+	Created         time.Time
 	Deleted         time.Time
 	Updated         time.Time
 
@@ -100,6 +101,7 @@ type ExtendedObjectAttributes struct {
 	MD5                *[md5.Size]byte // Missing for composite objects
 	MediaLink          string
 	StorageClass       string
+	Created            time.Time
 	Deleted            time.Time
 	ComponentCount     int64
 	ContentDisposition string
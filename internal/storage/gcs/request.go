@@ -282,6 +282,16 @@ type ListObjectsRequest struct {
 	// the current flow, default value will be full and callers can override it
 	// using this param.
 	ProjectionVal Projection
+
+	// If true, list the noncurrent generations of objects in addition to the
+	// live ones, as retained by buckets with object versioning enabled. The
+	// generations of an object are listed in increasing order.
+	Versions bool
+
+	// If true, list only the soft-deleted generations of objects. Note that
+	// the contents of soft-deleted objects can't be read until they are
+	// restored.
+	SoftDeleted bool
 }
 
 // Listing contains a set of objects and delimter-based collapsed runs returned
@@ -319,6 +329,11 @@ type Listing struct {
 	// and deleted concurrently with a single or multiple listing requests may or
 	// may not be returned.
 	ContinuationToken string
+
+	// If true, some of the records in Objects lack attributes which the bucket
+	// doesn't list, such as their metadata, and so mustn't be taken for the
+	// results of StatObject.
+	IncompleteObjects bool
 }
 
 // A request to update the metadata of an object, accepted by
@@ -0,0 +1,1051 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package localdir provides a gcs.Bucket which persists objects in a local
+// directory, for developing against and demonstrating gcsfuse offline.
+//
+// Each object is stored as a pair of files, named after the hex-encoded
+// SHA-256 hash of the object name:
+//
+//	<hash>.json          The metadata of the object, i.e. a gcs.Object encoded
+//	                     as JSON.
+//	<hash>.<generation>  The contents of the object.
+//
+// The directory also holds a lock file, which marks it as a bucket's: a
+// directory that isn't empty is only opened if it has one, and only files
+// named as above, or temporary files, are ever removed from it. A bucket locks
+// the lock file for as long as it is open, so that the directory is used by
+// one bucket, in one process, at a time.
+//
+// Writing the metadata file commits a change to an object. Contents files are
+// never modified once written, so that readers aren't affected by concurrent
+// changes to the object. Files are not synced, so changes survive the process
+// crashing but not necessarily the machine crashing.
+package localdir
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	cacheutil "github.com/googlecloudplatform/gcsfuse/v2/internal/cache/util"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/syncutil"
+	"github.com/jacobsa/timeutil"
+)
+
+// URLScheme is the scheme of bucket URLs which select a bucket backed by a
+// local directory, e.g. "file:///path/to/dir".
+const URLScheme = "file://"
+
+const (
+	metadataFileSuffix = ".json"
+	tmpFilePrefix      = ".tmp-"
+	lockFileName       = ".gcsfuse-bucket.lock"
+)
+
+// The names of the metadata and contents files of objects.
+var objectFileRegexp = regexp.MustCompile(`^[0-9a-f]{64}\.(json|[0-9]+)$`)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseBucketURL returns the directory selected by the given bucket URL, if
+// it has the URLScheme scheme.
+func ParseBucketURL(name string) (dir string, ok bool) {
+	dir, ok = strings.CutPrefix(name, URLScheme)
+	return
+}
+
+// NewBucket returns a bucket with the given name which stores its objects in
+// the given directory, creating the directory if it doesn't exist. Objects
+// stored in the directory by an earlier bucket are loaded. It fails if the
+// directory isn't empty but wasn't created by a bucket, or if another bucket
+// has it open.
+//
+// The bucket implements io.Closer, closing which lets another bucket open the
+// directory. It mustn't be used afterwards.
+func NewBucket(clock timeutil.Clock, name string, dir string) (gcs.Bucket, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("MkdirAll: %w", err)
+	}
+
+	lockFile, err := lockDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("lock %q: %w", dir, err)
+	}
+
+	b := &bucket{clock: clock, name: name, dir: dir, lockFile: lockFile}
+	if err := b.load(); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("load %q: %w", dir, err)
+	}
+
+	b.mu = syncutil.NewInvariantMutex(b.checkInvariants)
+	return b, nil
+}
+
+// Lock the lock file in the directory, creating it if the directory is empty.
+func lockDir(dir string) (*os.File, error) {
+	path := filepath.Join(dir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		var entries []os.DirEntry
+		entries, err = os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("ReadDir: %w", err)
+		}
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("the directory isn't empty, but has no %s, so doesn't belong to a bucket", lockFileName)
+		}
+
+		f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("OpenFile: %w", err)
+	}
+
+	locked, err := cacheutil.TryLockFile(f)
+	if err == nil && !locked {
+		err = errors.New("the directory is in use by another bucket")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+type bucket struct {
+	clock timeutil.Clock
+	name  string
+	dir   string
+	mu    syncutil.InvariantMutex
+
+	// The lock file of the directory, locked until the bucket is closed.
+	lockFile *os.File
+
+	// The metadata of the extant objects.
+	//
+	// INVARIANT: Strictly increasing by name.
+	objects []*gcs.Object // GUARDED_BY(mu)
+
+	// The most recent generation number that was minted.
+	//
+	// INVARIANT: This is an upper bound for generation numbers in objects.
+	prevGeneration int64 // GUARDED_BY(mu)
+}
+
+// A staged file holds the contents of an object before it's committed.
+type staged struct {
+	path   string
+	size   uint64
+	crc32c uint32
+	md5    [md5.Size]byte
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func checkName(name string) (err error) {
+	if len(name) == 0 || len(name) > 1024 {
+		err = errors.New("Invalid object name: length must be in [1, 1024]")
+		return
+	}
+
+	if !utf8.ValidString(name) {
+		err = errors.New("Invalid object name: not valid UTF-8")
+		return
+	}
+
+	for _, r := range name {
+		if r == 0x0a || r == 0x0d {
+			err = errors.New("Invalid object name: must not contain CR or LF")
+			return
+		}
+	}
+
+	return
+}
+
+// Return the smallest string that is lexicographically larger than prefix and
+// does not have prefix as a prefix, or the empty string if there is none.
+func prefixSuccessor(prefix string) string {
+	limit := []byte(prefix)
+	for len(limit) > 0 {
+		b := limit[len(limit)-1]
+		if b != 0xff {
+			limit[len(limit)-1]++
+			break
+		}
+
+		limit = limit[:len(limit)-1]
+	}
+
+	return string(limit)
+}
+
+func copyObject(o *gcs.Object) *gcs.Object {
+	var copy gcs.Object = *o
+	if o.Metadata != nil {
+		copy.Metadata = make(map[string]string)
+		for k, v := range o.Metadata {
+			copy.Metadata[k] = v
+		}
+	}
+	return &copy
+}
+
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) checkInvariants() {
+	for i := 1; i < len(b.objects); i++ {
+		if !(b.objects[i-1].Name < b.objects[i].Name) {
+			panic(fmt.Sprintf(
+				"Object names are not strictly increasing: %v vs. %v",
+				b.objects[i-1].Name,
+				b.objects[i].Name))
+		}
+	}
+
+	for _, o := range b.objects {
+		if !(o.Generation <= b.prevGeneration) {
+			panic(fmt.Sprintf(
+				"Object generation %v exceeds %v",
+				o.Generation,
+				b.prevGeneration))
+		}
+	}
+}
+
+func (b *bucket) fileStem(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(b.dir, hex.EncodeToString(sum[:]))
+}
+
+func (b *bucket) metadataPath(name string) string {
+	return b.fileStem(name) + metadataFileSuffix
+}
+
+func (b *bucket) contentsPath(name string, generation int64) string {
+	return b.fileStem(name) + "." + strconv.FormatInt(generation, 10)
+}
+
+// Load the objects stored in the directory, removing the temporary files and
+// the contents files of generations which are no longer current, e.g. left
+// behind by a crash. Other files are left alone.
+func (b *bucket) load() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("ReadDir: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() || !objectFileRegexp.MatchString(e.Name()) || !strings.HasSuffix(e.Name(), metadataFileSuffix) {
+			continue
+		}
+
+		path := filepath.Join(b.dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("ReadFile: %w", err)
+		}
+
+		o := new(gcs.Object)
+		if err := json.Unmarshal(data, o); err != nil {
+			return fmt.Errorf("decoding %q: %w", path, err)
+		}
+
+		contentsPath := b.contentsPath(o.Name, o.Generation)
+		if _, err := os.Stat(contentsPath); err != nil {
+			return fmt.Errorf("contents of %q: %w", o.Name, err)
+		}
+
+		referenced[path] = true
+		referenced[contentsPath] = true
+		b.objects = append(b.objects, o)
+		b.prevGeneration = max(b.prevGeneration, o.Generation)
+	}
+
+	sort.Slice(b.objects, func(i, j int) bool { return b.objects[i].Name < b.objects[j].Name })
+
+	for _, e := range entries {
+		path := filepath.Join(b.dir, e.Name())
+		ours := strings.HasPrefix(e.Name(), tmpFilePrefix) || objectFileRegexp.MatchString(e.Name())
+		if !e.IsDir() && ours && !referenced[path] {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("Remove: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the index of the object with the given name, or len(b.objects) if
+// there is none.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) findLocked(name string) int {
+	i := b.lowerBoundLocked(name)
+	if i < len(b.objects) && b.objects[i].Name == name {
+		return i
+	}
+
+	return len(b.objects)
+}
+
+// Return the smallest i such that b.objects[i].Name >= name.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) lowerBoundLocked(name string) int {
+	return sort.Search(len(b.objects), func(i int) bool { return b.objects[i].Name >= name })
+}
+
+// Write the contents of r to a new temporary file in the directory.
+func (b *bucket) stage(r io.Reader) (s staged, err error) {
+	f, err := os.CreateTemp(b.dir, tmpFilePrefix)
+	if err != nil {
+		err = fmt.Errorf("CreateTemp: %w", err)
+		return
+	}
+	s.path = f.Name()
+
+	crc := crc32.New(crc32cTable)
+	md5Hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(f, crc, md5Hash), r)
+	closeErr := f.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("Close: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(s.path)
+		return
+	}
+
+	s.size = uint64(n)
+	s.crc32c = crc.Sum32()
+	copy(s.md5[:], md5Hash.Sum(nil))
+	return
+}
+
+// Write the metadata file for the given object, replacing any existing one.
+func (b *bucket) writeMetadata(o *gcs.Object) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("Marshal: %w", err)
+	}
+
+	f, err := os.CreateTemp(b.dir, tmpFilePrefix)
+	if err != nil {
+		return fmt.Errorf("CreateTemp: %w", err)
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), b.metadataPath(o.Name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("writing metadata of %q: %w", o.Name, err)
+	}
+
+	return nil
+}
+
+// Check the generation and meta-generation preconditions for overwriting the
+// object with the given name.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) checkPreconditionsLocked(
+	name string,
+	generationPrecondition *int64,
+	metaGenerationPrecondition *int64) error {
+	var existing *gcs.Object
+	if i := b.findLocked(name); i < len(b.objects) {
+		existing = b.objects[i]
+	}
+
+	if generationPrecondition != nil {
+		if *generationPrecondition == 0 && existing != nil {
+			return &gcs.PreconditionError{
+				Err: errors.New("Precondition failed: object exists"),
+			}
+		}
+
+		if *generationPrecondition > 0 {
+			if existing == nil {
+				return &gcs.PreconditionError{
+					Err: errors.New("Precondition failed: object doesn't exist"),
+				}
+			}
+
+			if existing.Generation != *generationPrecondition {
+				return &gcs.PreconditionError{
+					Err: fmt.Errorf(
+						"Precondition failed: object has generation %v",
+						existing.Generation),
+				}
+			}
+		}
+	}
+
+	if metaGenerationPrecondition != nil {
+		if existing == nil {
+			return &gcs.PreconditionError{
+				Err: errors.New("Precondition failed: object doesn't exist"),
+			}
+		}
+
+		if existing.MetaGeneration != *metaGenerationPrecondition {
+			return &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Precondition failed: object has meta-generation %v",
+					existing.MetaGeneration),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Mint a generation for o, move the file at contentsPath into place as its
+// contents by the given function, and commit it, replacing any existing
+// generation of the object.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) insertLocked(
+	o *gcs.Object,
+	placeContents func(dst string) error) error {
+	generation := b.clock.Now().UnixMicro()
+	if generation <= b.prevGeneration {
+		generation = b.prevGeneration + 1
+	}
+	o.Generation = generation
+	o.MediaLink = "http://localhost/download/storage/localdir/" + o.Name
+
+	contentsPath := b.contentsPath(o.Name, o.Generation)
+	if err := placeContents(contentsPath); err != nil {
+		return err
+	}
+
+	if err := b.writeMetadata(o); err != nil {
+		os.Remove(contentsPath)
+		return err
+	}
+	b.prevGeneration = generation
+
+	// Replace an entry in or add an entry to our list of objects.
+	i := b.lowerBoundLocked(o.Name)
+	if i < len(b.objects) && b.objects[i].Name == o.Name {
+		os.Remove(b.contentsPath(o.Name, b.objects[i].Generation))
+		b.objects[i] = o
+	} else {
+		b.objects = append(b.objects, nil)
+		copy(b.objects[i+1:], b.objects[i:])
+		b.objects[i] = o
+	}
+
+	return nil
+}
+
+// Create an object from the given request and staged contents.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) createObjectLocked(
+	req *gcs.CreateObjectRequest,
+	s staged) (o *gcs.Object, err error) {
+	err = b.checkPreconditionsLocked(req.Name, req.GenerationPrecondition, req.MetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range req.Metadata {
+		metadata[k] = v
+	}
+	if req.Metadata == nil {
+		metadata = nil
+	}
+
+	md5Sum := s.md5
+	crc32c := s.crc32c
+	o = &gcs.Object{
+		Name:            req.Name,
+		ContentType:     req.ContentType,
+		ContentLanguage: req.ContentLanguage,
+		CacheControl:    req.CacheControl,
+		Owner:           "user-localdir",
+		Size:            s.size,
+		ContentEncoding: req.ContentEncoding,
+		ComponentCount:  1,
+		MD5:             &md5Sum,
+		CRC32C:          &crc32c,
+		Metadata:        metadata,
+		MetaGeneration:  1,
+		StorageClass:    "STANDARD",
+		Created:         b.clock.Now(),
+		Updated:         b.clock.Now(),
+	}
+
+	err = b.insertLocked(o, func(dst string) error {
+		return os.Rename(s.path, dst)
+	})
+	if err != nil {
+		return
+	}
+
+	o = copyObject(o)
+	return
+}
+
+// Open the contents of the given generation of the object with the given
+// name, or the live generation if zero.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) openLocked(name string, generation int64) (f *os.File, o *gcs.Object, err error) {
+	i := b.findLocked(name)
+	if i == len(b.objects) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %s not found", name),
+		}
+		return
+	}
+
+	o = b.objects[i]
+	if generation != 0 && generation != o.Generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %s generation %v not found", name, generation),
+		}
+		return
+	}
+
+	f, err = os.Open(b.contentsPath(o.Name, o.Generation))
+	if err != nil {
+		err = fmt.Errorf("Open: %w", err)
+		return
+	}
+
+	return
+}
+
+// A reader for a range of the contents of an object.
+type rangeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+////////////////////////////////////////////////////////////////////////
+// Public interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *bucket) Name() string {
+	return b.name
+}
+
+// Close releases the lock on the directory.
+func (b *bucket) Close() error {
+	return b.lockFile.Close()
+}
+
+func (b *bucket) BucketType() gcs.BucketType {
+	return gcs.NonHierarchical
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	listing = new(gcs.Listing)
+
+	// Noncurrent generations aren't retained, and there are no soft-deleted
+	// objects.
+	if req.SoftDeleted {
+		return
+	}
+
+	maxResults := req.MaxResults
+	if maxResults == 0 {
+		maxResults = 1000
+	}
+
+	// Find where in the space of object names to start.
+	nameStart := req.Prefix
+	if req.ContinuationToken != "" && req.ContinuationToken > nameStart {
+		nameStart = req.ContinuationToken
+	}
+
+	// Find the range of indexes within the array to scan.
+	indexStart := b.lowerBoundLocked(nameStart)
+	prefixLimit := len(b.objects)
+	if successor := prefixSuccessor(req.Prefix); successor != "" {
+		prefixLimit = b.lowerBoundLocked(successor)
+	}
+	indexLimit := min(indexStart+maxResults, prefixLimit)
+
+	// Scan the array.
+	var lastResultWasPrefix bool
+	for i := indexStart; i < indexLimit; i++ {
+		name := b.objects[i].Name
+
+		// Collapse runs of names containing the delimiter after the prefix.
+		if req.Delimiter != "" {
+			nameMinusQueryPrefix := name[len(req.Prefix):]
+			delimiterIndex := strings.Index(nameMinusQueryPrefix, req.Delimiter)
+			if delimiterIndex >= 0 {
+				resultPrefix := name[:len(req.Prefix)+delimiterIndex+len(req.Delimiter)]
+				if len(listing.CollapsedRuns) == 0 ||
+					listing.CollapsedRuns[len(listing.CollapsedRuns)-1] != resultPrefix {
+					listing.CollapsedRuns = append(listing.CollapsedRuns, resultPrefix)
+				}
+
+				isTrailingDelimiter := (delimiterIndex == len(nameMinusQueryPrefix)-1)
+				if !isTrailingDelimiter || !req.IncludeTrailingDelimiter {
+					lastResultWasPrefix = true
+					continue
+				}
+			}
+		}
+
+		lastResultWasPrefix = false
+		listing.Objects = append(listing.Objects, copyObject(b.objects[i]))
+	}
+
+	// Set up a cursor for where to start the next scan if we didn't exhaust the
+	// results, skipping the rest of a collapsed run.
+	if indexLimit < prefixLimit {
+		if lastResultWasPrefix {
+			lastResultPrefix := listing.CollapsedRuns[len(listing.CollapsedRuns)-1]
+			listing.ContinuationToken = prefixSuccessor(lastResultPrefix)
+			if listing.ContinuationToken == "" {
+				err = errors.New("Unexpected empty string from prefixSuccessor")
+				return
+			}
+		} else {
+			listing.ContinuationToken = b.objects[indexLimit].Name
+		}
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	b.mu.Lock()
+	f, o, err := b.openLocked(req.Name, req.Generation)
+	b.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	// Extract the requested range.
+	start, limit := uint64(0), o.Size
+	if req.Range != nil {
+		start, limit = req.Range.Start, min(req.Range.Limit, o.Size)
+		if start > limit {
+			start, limit = 0, 0
+		}
+	}
+
+	if _, err = f.Seek(int64(start), io.SeekStart); err != nil {
+		f.Close()
+		err = fmt.Errorf("Seek: %w", err)
+		return
+	}
+
+	rc = rangeReadCloser{Reader: io.LimitReader(f, int64(limit-start)), Closer: f}
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if err = checkName(req.Name); err != nil {
+		return
+	}
+
+	// Stage the contents without holding the lock.
+	s, err := b.stage(req.Contents)
+	if err != nil {
+		err = fmt.Errorf("stage: %w", err)
+		return
+	}
+	defer os.Remove(s.path)
+
+	// Check the provided checksum and hash, if any.
+	if req.CRC32C != nil && s.crc32c != *req.CRC32C {
+		err = fmt.Errorf("CRC32C mismatch: got 0x%08x, expected 0x%08x", s.crc32c, *req.CRC32C)
+		return
+	}
+
+	if req.MD5 != nil && s.md5 != *req.MD5 {
+		err = fmt.Errorf(
+			"MD5 mismatch: got %s, expected %s",
+			hex.EncodeToString(s.md5[:]),
+			hex.EncodeToString(req.MD5[:]))
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err = b.createObjectLocked(req, s)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = checkName(req.DstName); err != nil {
+		return
+	}
+
+	// Find the source generation.
+	i := b.findLocked(req.SrcName)
+	if i == len(b.objects) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q not found", req.SrcName),
+		}
+		return
+	}
+
+	src := b.objects[i]
+	if req.SrcGeneration != 0 && req.SrcGeneration != src.Generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %s generation %d not found", req.SrcName, req.SrcGeneration),
+		}
+		return
+	}
+
+	if req.SrcMetaGenerationPrecondition != nil &&
+		src.MetaGeneration != *req.SrcMetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("Object %q has meta-generation %d", req.SrcName, src.MetaGeneration),
+		}
+		return
+	}
+
+	if err = b.checkPreconditionsLocked(req.DstName, req.DstGenerationPrecondition, nil); err != nil {
+		return
+	}
+
+	// Contents files are immutable, hence can be shared by hard links.
+	srcPath := b.contentsPath(src.Name, src.Generation)
+	dst := copyObject(src)
+	dst.Name = req.DstName
+	dst.Created = b.clock.Now()
+	err = b.insertLocked(dst, func(dstPath string) error {
+		if err := os.Link(srcPath, dstPath); err == nil {
+			return nil
+		}
+		s, err := b.copyFile(srcPath)
+		if err != nil {
+			return err
+		}
+		return os.Rename(s.path, dstPath)
+	})
+	if err != nil {
+		return
+	}
+
+	o = copyObject(dst)
+	return
+}
+
+// Copy the file at the given path to a staged file.
+func (b *bucket) copyFile(path string) (s staged, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("Open: %w", err)
+		return
+	}
+	defer f.Close()
+
+	return b.stage(f)
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	if err = checkName(req.DstName); err != nil {
+		return
+	}
+
+	// GCS doesn't like too few or too many sources.
+	if len(req.Sources) < 1 {
+		err = errors.New("You must provide at least one source component")
+		return
+	}
+
+	if len(req.Sources) > gcs.MaxSourcesPerComposeRequest {
+		err = errors.New("You have provided too many source components")
+		return
+	}
+
+	// Open all of the source objects, also computing the sum of their component
+	// counts.
+	var srcReaders []io.Reader
+	var dstComponentCount int64
+
+	b.mu.Lock()
+	for _, src := range req.Sources {
+		var f *os.File
+		var srcObject *gcs.Object
+		f, srcObject, err = b.openLocked(src.Name, src.Generation)
+		if err != nil {
+			break
+		}
+		defer f.Close()
+
+		srcReaders = append(srcReaders, f)
+		dstComponentCount += srcObject.ComponentCount
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	// GCS doesn't like the component count to go too high.
+	if dstComponentCount > gcs.MaxComponentCount {
+		err = errors.New("Result would have too many components")
+		return
+	}
+
+	// Stage the concatenated contents without holding the lock.
+	s, err := b.stage(io.MultiReader(srcReaders...))
+	if err != nil {
+		err = fmt.Errorf("stage: %w", err)
+		return
+	}
+	defer os.Remove(s.path)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err = b.createObjectLocked(
+		&gcs.CreateObjectRequest{
+			Name:                       req.DstName,
+			GenerationPrecondition:     req.DstGenerationPrecondition,
+			MetaGenerationPrecondition: req.DstMetaGenerationPrecondition,
+			ContentType:                req.ContentType,
+			Metadata:                   req.Metadata,
+		},
+		s)
+	if err != nil {
+		return
+	}
+
+	// Fix the component count, and emulate the real GCS behavior of not
+	// exporting an MD5 hash for composite objects.
+	dst := b.objects[b.findLocked(req.DstName)]
+	dst.ComponentCount = dstComponentCount
+	dst.MD5 = nil
+	if err = b.writeMetadata(dst); err != nil {
+		return
+	}
+
+	o = copyObject(dst)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, e *gcs.ExtendedObjectAttributes, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.findLocked(req.Name)
+	if i == len(b.objects) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %s not found", req.Name),
+		}
+		return
+	}
+
+	o := copyObject(b.objects[i])
+	m = storageutil.ConvertObjToMinObject(o)
+	if req.ReturnExtendedObjectAttributes {
+		e = storageutil.ConvertObjToExtendedObjectAttributes(o)
+	}
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.findLocked(req.Name)
+	if i == len(b.objects) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %s not found", req.Name),
+		}
+		return
+	}
+
+	if req.Generation != 0 && b.objects[i].Generation != req.Generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q generation %d not found", req.Name, req.Generation),
+		}
+		return
+	}
+
+	if req.MetaGenerationPrecondition != nil &&
+		b.objects[i].MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("Object %q has meta-generation %d", req.Name, b.objects[i].MetaGeneration),
+		}
+		return
+	}
+
+	// Update a copy, which replaces the entry once committed.
+	obj := copyObject(b.objects[i])
+	if req.ContentType != nil {
+		obj.ContentType = *req.ContentType
+	}
+
+	if req.ContentEncoding != nil {
+		obj.ContentEncoding = *req.ContentEncoding
+	}
+
+	if req.ContentLanguage != nil {
+		obj.ContentLanguage = *req.ContentLanguage
+	}
+
+	if req.CacheControl != nil {
+		obj.CacheControl = *req.CacheControl
+	}
+
+	if len(req.Metadata) > 0 {
+		if obj.Metadata == nil {
+			obj.Metadata = make(map[string]string)
+		}
+
+		for k, v := range req.Metadata {
+			if v == nil {
+				delete(obj.Metadata, k)
+				continue
+			}
+
+			obj.Metadata[k] = *v
+		}
+	}
+
+	obj.MetaGeneration++
+	obj.Updated = b.clock.Now()
+
+	if err = b.writeMetadata(obj); err != nil {
+		return
+	}
+	b.objects[i] = obj
+
+	o = copyObject(obj)
+	return
+}
+
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) deleteLocked(i int) error {
+	o := b.objects[i]
+	if err := os.Remove(b.metadataPath(o.Name)); err != nil {
+		return fmt.Errorf("Remove: %w", err)
+	}
+	os.Remove(b.contentsPath(o.Name, o.Generation))
+
+	b.objects = append(b.objects[:i], b.objects[i+1:]...)
+	return nil
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Non-existence, or a different generation, isn't an error.
+	i := b.findLocked(req.Name)
+	if i == len(b.objects) || (req.Generation != 0 && b.objects[i].Generation != req.Generation) {
+		return
+	}
+
+	if req.MetaGenerationPrecondition != nil &&
+		b.objects[i].MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("Object %q has meta-generation %d", req.Name, b.objects[i].MetaGeneration),
+		}
+		return
+	}
+
+	err = b.deleteLocked(i)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.findLocked(folderName)
+	if i == len(b.objects) {
+		return
+	}
+
+	err = b.deleteLocked(i)
+	return
+}
+
+func (b *bucket) GetFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	return nil, errors.New("Folders are only supported in hierarchical buckets")
+}
+
+func (b *bucket) CreateFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	return nil, errors.New("Folders are only supported in hierarchical buckets")
+}
+
+func (b *bucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (*gcs.Folder, error) {
+	return nil, errors.New("Folders are only supported in hierarchical buckets")
+}
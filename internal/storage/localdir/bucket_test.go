@@ -0,0 +1,206 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localdir_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gcstesting "github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake/testing"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/localdir"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+// The directory under which tests create the directories for their buckets.
+var testDir string
+
+func TestBucket(t *testing.T) {
+	testDir = t.TempDir()
+	RunTests(t)
+}
+
+func newClock() *timeutil.SimulatedClock {
+	clock := &timeutil.SimulatedClock{}
+	clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	return clock
+}
+
+func init() {
+	makeDeps := func(ctx context.Context) (deps gcstesting.BucketTestDeps) {
+		dir, err := os.MkdirTemp(testDir, "")
+		AssertEq(nil, err)
+
+		clock := newClock()
+		deps.Clock = clock
+		deps.Bucket, err = localdir.NewBucket(clock, "some_bucket", dir)
+		AssertEq(nil, err)
+		return
+	}
+
+	gcstesting.RegisterBucketTests(makeDeps)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Persistence
+////////////////////////////////////////////////////////////////////////
+
+type PersistenceTest struct {
+	ctx   context.Context
+	clock *timeutil.SimulatedClock
+	dir   string
+
+	// The bucket most recently opened, if any.
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &PersistenceTest{}
+
+func init() { RegisterTestSuite(&PersistenceTest{}) }
+
+func (t *PersistenceTest) SetUp(ti *TestInfo) {
+	var err error
+	t.ctx = ti.Ctx
+	t.clock = newClock()
+	t.dir, err = os.MkdirTemp(testDir, "")
+	AssertEq(nil, err)
+}
+
+// Open the directory as a bucket, closing the bucket opened before, if any.
+func (t *PersistenceTest) open() gcs.Bucket {
+	if t.bucket != nil {
+		AssertEq(nil, t.bucket.(io.Closer).Close())
+		t.bucket = nil
+	}
+
+	var err error
+	t.bucket, err = localdir.NewBucket(t.clock, "some_bucket", t.dir)
+	AssertEq(nil, err)
+	return t.bucket
+}
+
+func (t *PersistenceTest) ObjectsSurviveReopening() {
+	b := t.open()
+	_, err := b.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+		Metadata: map[string]string{"bar": "baz"},
+	})
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, b, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+	_, err = storageutil.CreateObject(t.ctx, b, "dir/qux", []byte("enchilada"))
+	AssertEq(nil, err)
+	_, err = b.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "dir/qux", DstName: "quux"})
+	AssertEq(nil, err)
+	AssertEq(nil, b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "dir/qux"}))
+	before, _, err := storageutil.ListAll(t.ctx, b, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	b = t.open()
+	after, _, err := storageutil.ListAll(t.ctx, b, &gcs.ListObjectsRequest{})
+
+	AssertEq(nil, err)
+	AssertEq(2, len(after))
+	ExpectEq("foo", after[0].Name)
+	ExpectEq("quux", after[1].Name)
+	for i := range after {
+		ExpectEq(before[i].Generation, after[i].Generation)
+		ExpectEq(before[i].MetaGeneration, after[i].MetaGeneration)
+		ExpectEq(*before[i].CRC32C, *after[i].CRC32C)
+		ExpectThat(after[i].Metadata, DeepEquals(before[i].Metadata))
+		ExpectTrue(after[i].Updated.Equal(before[i].Updated))
+	}
+
+	contents, err := storageutil.ReadObject(t.ctx, b, "foo")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+	contents, err = storageutil.ReadObject(t.ctx, b, "quux")
+	AssertEq(nil, err)
+	ExpectEq("enchilada", string(contents))
+}
+
+func (t *PersistenceTest) GenerationsIncreaseAcrossReopening() {
+	o1, err := storageutil.CreateObject(t.ctx, t.open(), "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Even if the clock goes backwards.
+	t.clock.AdvanceTime(-time.Hour)
+	o2, err := storageutil.CreateObject(t.ctx, t.open(), "foo", []byte("burrito"))
+
+	AssertEq(nil, err)
+	ExpectGt(o2.Generation, o1.Generation)
+}
+
+func (t *PersistenceTest) StrayFilesAreRemoved() {
+	_, err := storageutil.CreateObject(t.ctx, t.open(), "foo", []byte("taco"))
+	AssertEq(nil, err)
+	stray := filepath.Join(t.dir, ".tmp-12345")
+	AssertEq(nil, os.WriteFile(stray, []byte("burrito"), 0600))
+
+	t.open()
+
+	_, err = os.Stat(stray)
+	ExpectTrue(os.IsNotExist(err))
+	entries, err := os.ReadDir(t.dir)
+	AssertEq(nil, err)
+	ExpectEq(3, len(entries))
+}
+
+func (t *PersistenceTest) OtherFilesAreKept() {
+	t.open()
+	names := []string{"notes.txt", "foo.json", strings.Repeat("a", 64) + ".json.bak"}
+	for _, name := range names {
+		AssertEq(nil, os.WriteFile(filepath.Join(t.dir, name), []byte("taco"), 0600))
+	}
+
+	b := t.open()
+
+	for _, name := range names {
+		_, err := os.Stat(filepath.Join(t.dir, name))
+		ExpectEq(nil, err)
+	}
+	objects, _, err := storageutil.ListAll(t.ctx, b, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	ExpectEq(0, len(objects))
+}
+
+func (t *PersistenceTest) NonEmptyDirectoryIsRefused() {
+	data := filepath.Join(t.dir, "data.txt")
+	AssertEq(nil, os.WriteFile(data, []byte("taco"), 0600))
+
+	_, err := localdir.NewBucket(t.clock, "some_bucket", t.dir)
+
+	ExpectThat(err, Error(HasSubstr("doesn't belong to a bucket")))
+	contents, err := os.ReadFile(data)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *PersistenceTest) DirectoryInUseIsRefused() {
+	t.open()
+
+	_, err := localdir.NewBucket(t.clock, "some_bucket", t.dir)
+
+	ExpectThat(err, Error(HasSubstr("in use")))
+}
@@ -176,6 +176,93 @@ func (m *mockBucket) DeleteFolder(ctx context.Context, folderName string) (o0 er
 	return
 }
 
+func (m *mockBucket) GetFolder(p0 context.Context, p1 string) (o0 *gcs.Folder, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"GetFolder",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.GetFolder: invalid return values: %v", retVals))
+	}
+
+	// o0 *Folder
+	if retVals[0] != nil {
+		o0 = retVals[0].(*gcs.Folder)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
+func (m *mockBucket) CreateFolder(p0 context.Context, p1 string) (o0 *gcs.Folder, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"CreateFolder",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.CreateFolder: invalid return values: %v", retVals))
+	}
+
+	// o0 *Folder
+	if retVals[0] != nil {
+		o0 = retVals[0].(*gcs.Folder)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
+func (m *mockBucket) RenameFolder(p0 context.Context, p1 string, p2 string) (o0 *gcs.Folder, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"RenameFolder",
+		file,
+		line,
+		[]interface{}{p0, p1, p2})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.RenameFolder: invalid return values: %v", retVals))
+	}
+
+	// o0 *Folder
+	if retVals[0] != nil {
+		o0 = retVals[0].(*gcs.Folder)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
 func (m *mockBucket) ListObjects(p0 context.Context, p1 *gcs.ListObjectsRequest) (o0 *gcs.Listing, o1 error) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
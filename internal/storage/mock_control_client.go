@@ -43,3 +43,27 @@ func (m *MockStorageControlClient) DeleteFolder(ctx context.Context,
 	args := m.Called(ctx, req, opts)
 	return args.Error(0)
 }
+
+// Implement the GetFolder method for the mock.
+func (m *MockStorageControlClient) GetFolder(ctx context.Context,
+	req *controlpb.GetFolderRequest,
+	opts ...gax.CallOption) (*controlpb.Folder, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*controlpb.Folder), args.Error(1)
+}
+
+// Implement the CreateFolder method for the mock.
+func (m *MockStorageControlClient) CreateFolder(ctx context.Context,
+	req *controlpb.CreateFolderRequest,
+	opts ...gax.CallOption) (*controlpb.Folder, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*controlpb.Folder), args.Error(1)
+}
+
+// Implement the RenameFolder method for the mock.
+func (m *MockStorageControlClient) RenameFolder(ctx context.Context,
+	req *controlpb.RenameFolderRequest,
+	opts ...gax.CallOption) (*controlpb.Folder, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*controlpb.Folder), args.Error(1)
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/net/context"
+)
+
+// NewRecordingBucket wraps the supplied bucket in a layer that records every
+// request and its response to w, including the contents of objects read, as
+// a trace that NewReplayingBucket can serve back.
+func NewRecordingBucket(w io.Writer, wrapped gcs.Bucket) (b gcs.Bucket, err error) {
+	rb := &recordingBucket{
+		wrapped: wrapped,
+		encoder: json.NewEncoder(w),
+	}
+
+	err = rb.encoder.Encode(&traceHeader{
+		Bucket:     wrapped.Name(),
+		BucketType: wrapped.BucketType(),
+	})
+	if err != nil {
+		err = fmt.Errorf("writing trace header: %w", err)
+		return
+	}
+
+	b = rb
+	return
+}
+
+type recordingBucket struct {
+	wrapped gcs.Bucket
+	nextSeq atomic.Uint64
+
+	mu      sync.Mutex
+	encoder *json.Encoder // GUARDED_BY(mu)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Start an entry for a request to the given method.
+func (b *recordingBucket) startEntry(method string, req any) *traceEntry {
+	e := &traceEntry{
+		Seq:    b.nextSeq.Add(1) - 1,
+		Method: method,
+	}
+
+	var err error
+	e.Request, err = json.Marshal(req)
+	if err != nil {
+		logger.Errorf("replay: encoding %s request: %v", method, err)
+	}
+
+	return e
+}
+
+// Append the given entry to the trace. Failures are logged rather than
+// returned, so that they don't affect the requests being recorded.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *recordingBucket) write(e *traceEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.encoder.Encode(e); err != nil {
+		logger.Errorf("replay: writing trace entry for %s: %v", e.Method, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Reader
+////////////////////////////////////////////////////////////////////////
+
+// The method of the entries recording the contents read from a reader returned
+// by NewReader, with the same Seq as the NewReader entry.
+const readMethod = "Read"
+
+type recordingReader struct {
+	bucket  *recordingBucket
+	seq     uint64
+	wrapped io.ReadCloser
+}
+
+func (rr *recordingReader) Read(p []byte) (n int, err error) {
+	n, err = rr.wrapped.Read(p)
+	if n > 0 || err != nil {
+		rr.bucket.write(&traceEntry{
+			Seq:       rr.seq,
+			Method:    readMethod,
+			Contents:  p[:n],
+			ReadError: encodeError(err),
+		})
+	}
+
+	return
+}
+
+func (rr *recordingReader) Close() error {
+	return rr.wrapped.Close()
+}
+
+// A reader which computes the size and checksum of the contents read through
+// it.
+type checksummingReader struct {
+	wrapped io.Reader
+	size    int64
+	crc32c  hash.Hash32
+}
+
+func (cr *checksummingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.wrapped.Read(p)
+	cr.size += int64(n)
+	cr.crc32c.Write(p[:n])
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *recordingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *recordingBucket) BucketType() gcs.BucketType {
+	return b.wrapped.BucketType()
+}
+
+func (b *recordingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	e := b.startEntry("NewReader", req)
+	rc, err = b.wrapped.NewReader(ctx, req)
+	e.Error = encodeError(err)
+	b.write(e)
+
+	if err == nil {
+		rc = &recordingReader{bucket: b, seq: e.Seq, wrapped: rc}
+	}
+
+	return
+}
+
+func (b *recordingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Record the contents by their checksum.
+	mReq := new(gcs.CreateObjectRequest)
+	*mReq = *req
+	mReq.Contents = nil
+	e := b.startEntry("CreateObject", mReq)
+
+	cr := &checksummingReader{wrapped: req.Contents, crc32c: crc32.New(crc32cTable)}
+	mReq.Contents = cr
+	o, err = b.wrapped.CreateObject(ctx, mReq)
+
+	// Include any contents the wrapped bucket didn't consume, so that the
+	// checksum doesn't depend on when it failed.
+	io.Copy(io.Discard, cr)
+	crc32c := cr.crc32c.Sum32()
+	e.ContentsSize = cr.size
+	e.ContentsCRC32C = &crc32c
+
+	e.Object = o
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	e := b.startEntry("CopyObject", req)
+	o, err = b.wrapped.CopyObject(ctx, req)
+	e.Object = o
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	e := b.startEntry("ComposeObjects", req)
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	e.Object = o
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, ext *gcs.ExtendedObjectAttributes, err error) {
+	e := b.startEntry("StatObject", req)
+	m, ext, err = b.wrapped.StatObject(ctx, req)
+	e.MinObject = m
+	e.ExtendedAttributes = ext
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	e := b.startEntry("ListObjects", req)
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	e.Listing = listing
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	e := b.startEntry("UpdateObject", req)
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	e.Object = o
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	e := b.startEntry("DeleteObject", req)
+	err = b.wrapped.DeleteObject(ctx, req)
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
+	e := b.startEntry("DeleteFolder", folderName)
+	err = b.wrapped.DeleteFolder(ctx, folderName)
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	e := b.startEntry("GetFolder", folderName)
+	folder, err = b.wrapped.GetFolder(ctx, folderName)
+	e.Folder = folder
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	e := b.startEntry("CreateFolder", folderName)
+	folder, err = b.wrapped.CreateFolder(ctx, folderName)
+	e.Folder = folder
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
+
+func (b *recordingBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	e := b.startEntry("RenameFolder", renameFolderRequest{folderName, destinationFolderId})
+	folder, err = b.wrapped.RenameFolder(ctx, folderName, destinationFolderId)
+	e.Folder = folder
+	e.Error = encodeError(err)
+	b.write(e)
+	return
+}
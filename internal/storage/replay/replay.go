@@ -0,0 +1,397 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/net/context"
+)
+
+// errNotRecorded is the error for a request with no recorded response left to
+// serve.
+var errNotRecorded = errors.New("request not in trace")
+
+// NewReplayingBucket returns a bucket which serves the trace read from r,
+// recorded by a bucket returned by NewRecordingBucket.
+//
+// Each request is answered with the response recorded for an identical
+// request. Identical requests are answered in the order in which they were
+// recorded, but requests which differ may be made in any order, so that the
+// interleaving of concurrent requests doesn't matter. A request for which no
+// response is left fails. Requests are matched regardless of the random names
+// of temporary objects beginning with tmpObjectPrefix, and of the times
+// recorded in custom metadata, so that a workload writing files can be
+// replayed.
+func NewReplayingBucket(r io.Reader, tmpObjectPrefix string) (b gcs.Bucket, err error) {
+	decoder := json.NewDecoder(r)
+
+	var header traceHeader
+	if err = decoder.Decode(&header); err != nil {
+		err = fmt.Errorf("reading trace header: %w", err)
+		return
+	}
+
+	// Read the entries, merging the contents read from readers into the entry
+	// for the corresponding NewReader request.
+	var entries []*traceEntry
+	readers := make(map[uint64]*traceEntry)
+	for {
+		e := new(traceEntry)
+		err = decoder.Decode(e)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("reading trace entry %d: %w", len(entries), err)
+			return
+		}
+
+		if e.Method != readMethod {
+			entries = append(entries, e)
+			if e.Method == "NewReader" {
+				readers[e.Seq] = e
+			}
+			continue
+		}
+
+		reader, ok := readers[e.Seq]
+		if !ok {
+			err = fmt.Errorf("contents read from unknown reader %d", e.Seq)
+			return
+		}
+		reader.Contents = append(reader.Contents, e.Contents...)
+		reader.ReadError = e.ReadError
+	}
+
+	// Queue the responses to each request in the order they were made.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	rb := &replayingBucket{
+		header:          header,
+		tmpObjectPrefix: tmpObjectPrefix,
+		responses:       make(map[string][]*traceEntry),
+	}
+	for _, e := range entries {
+		key := e.key(tmpObjectPrefix)
+		rb.responses[key] = append(rb.responses[key], e)
+	}
+
+	b = rb
+	return
+}
+
+type replayingBucket struct {
+	header          traceHeader
+	tmpObjectPrefix string
+
+	mu sync.Mutex
+
+	// The responses yet to be served, by the key of their request.
+	responses map[string][]*traceEntry // GUARDED_BY(mu)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Return the next response to the given request.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *replayingBucket) next(method string, req any) (e *traceEntry, err error) {
+	request, err := json.Marshal(req)
+	if err != nil {
+		err = fmt.Errorf("encoding %s request: %w", method, err)
+		return
+	}
+
+	e, err = b.nextForKey((&traceEntry{Method: method, Request: request}).key(b.tmpObjectPrefix))
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *replayingBucket) nextForKey(key string) (e *traceEntry, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queue := b.responses[key]
+	if len(queue) == 0 {
+		err = fmt.Errorf("%s: %w", key, errNotRecorded)
+		return
+	}
+
+	e = queue[0]
+	b.responses[key] = queue[1:]
+	return
+}
+
+// Return a copy of the given folder, so that callers can't modify the trace.
+func copyFolder(f *gcs.Folder) *gcs.Folder {
+	if f == nil {
+		return nil
+	}
+
+	var copy gcs.Folder = *f
+	return &copy
+}
+
+// Return a copy of the given object, so that callers can't modify the trace.
+func copyObject(o *gcs.Object) *gcs.Object {
+	if o == nil {
+		return nil
+	}
+
+	var copy gcs.Object = *o
+	if o.Metadata != nil {
+		copy.Metadata = make(map[string]string)
+		for k, v := range o.Metadata {
+			copy.Metadata[k] = v
+		}
+	}
+	return &copy
+}
+
+////////////////////////////////////////////////////////////////////////
+// Reader
+////////////////////////////////////////////////////////////////////////
+
+// A reader which serves recorded contents, then the recorded error which
+// ended the reads.
+type replayingReader struct {
+	contents *bytes.Reader
+	err      *traceError
+}
+
+func (rr *replayingReader) Read(p []byte) (n int, err error) {
+	if rr.contents.Len() > 0 {
+		n, err = rr.contents.Read(p)
+		return
+	}
+
+	// If the recorded reader was closed before any error, reading further
+	// than it did diverges from the trace.
+	if rr.err == nil {
+		err = fmt.Errorf("reading beyond the recorded contents: %w", errNotRecorded)
+		return
+	}
+
+	err = rr.err.decode()
+	return
+}
+
+func (rr *replayingReader) Close() error {
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *replayingBucket) Name() string {
+	return b.header.Bucket
+}
+
+func (b *replayingBucket) BucketType() gcs.BucketType {
+	return b.header.BucketType
+}
+
+func (b *replayingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	e, err := b.next("NewReader", req)
+	if err != nil {
+		return
+	}
+
+	if err = e.Error.decode(); err != nil {
+		return
+	}
+
+	rc = &replayingReader{contents: bytes.NewReader(e.Contents), err: e.ReadError}
+	return
+}
+
+func (b *replayingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Requests are matched by the checksum of their contents.
+	mReq := new(gcs.CreateObjectRequest)
+	*mReq = *req
+	mReq.Contents = nil
+	request, err := json.Marshal(mReq)
+	if err != nil {
+		err = fmt.Errorf("encoding CreateObject request: %w", err)
+		return
+	}
+
+	crc := crc32.New(crc32cTable)
+	size, err := io.Copy(crc, req.Contents)
+	if err != nil {
+		err = fmt.Errorf("reading contents: %w", err)
+		return
+	}
+	crc32c := crc.Sum32()
+
+	e, err := b.nextForKey((&traceEntry{
+		Method:         "CreateObject",
+		Request:        request,
+		ContentsSize:   size,
+		ContentsCRC32C: &crc32c,
+	}).key(b.tmpObjectPrefix))
+	if err != nil {
+		return
+	}
+
+	o, err = copyObject(e.Object), e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	e, err := b.next("CopyObject", req)
+	if err != nil {
+		return
+	}
+
+	o, err = copyObject(e.Object), e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	e, err := b.next("ComposeObjects", req)
+	if err != nil {
+		return
+	}
+
+	o, err = copyObject(e.Object), e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, ext *gcs.ExtendedObjectAttributes, err error) {
+	e, err := b.next("StatObject", req)
+	if err != nil {
+		return
+	}
+
+	if e.MinObject != nil {
+		mCopy := *e.MinObject
+		m = &mCopy
+	}
+	if e.ExtendedAttributes != nil {
+		extCopy := *e.ExtendedAttributes
+		ext = &extCopy
+	}
+	err = e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	e, err := b.next("ListObjects", req)
+	if err != nil {
+		return
+	}
+
+	if e.Listing != nil {
+		listing = &gcs.Listing{
+			CollapsedRuns:     append([]string(nil), e.Listing.CollapsedRuns...),
+			ContinuationToken: e.Listing.ContinuationToken,
+			IncompleteObjects: e.Listing.IncompleteObjects,
+		}
+		for _, o := range e.Listing.Objects {
+			listing.Objects = append(listing.Objects, copyObject(o))
+		}
+	}
+	err = e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	e, err := b.next("UpdateObject", req)
+	if err != nil {
+		return
+	}
+
+	o, err = copyObject(e.Object), e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	e, err := b.next("DeleteObject", req)
+	if err != nil {
+		return
+	}
+
+	err = e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) DeleteFolder(ctx context.Context, folderName string) (err error) {
+	e, err := b.next("DeleteFolder", folderName)
+	if err != nil {
+		return
+	}
+
+	err = e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) GetFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	e, err := b.next("GetFolder", folderName)
+	if err != nil {
+		return
+	}
+
+	folder, err = copyFolder(e.Folder), e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) CreateFolder(ctx context.Context, folderName string) (folder *gcs.Folder, err error) {
+	e, err := b.next("CreateFolder", folderName)
+	if err != nil {
+		return
+	}
+
+	folder, err = copyFolder(e.Folder), e.Error.decode()
+	return
+}
+
+func (b *replayingBucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (folder *gcs.Folder, err error) {
+	e, err := b.next("RenameFolder", renameFolderRequest{folderName, destinationFolderId})
+	if err != nil {
+		return
+	}
+
+	folder, err = copyFolder(e.Folder), e.Error.decode()
+	return
+}
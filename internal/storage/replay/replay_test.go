@@ -0,0 +1,262 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/api/googleapi"
+)
+
+const tmpObjectPrefix = ".gcsfuse_tmp/"
+
+type ReplayTest struct {
+	suite.Suite
+	ctx   context.Context
+	clock timeutil.SimulatedClock
+	trace bytes.Buffer
+
+	// A recording of a fake bucket, into trace.
+	recording gcs.Bucket
+}
+
+func TestReplayTestSuite(t *testing.T) {
+	suite.Run(t, new(ReplayTest))
+}
+
+func (t *ReplayTest) SetupTest() {
+	var err error
+	t.ctx = context.Background()
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.trace.Reset()
+	t.recording, err = NewRecordingBucket(&t.trace, fake.NewFakeBucket(&t.clock, "some_bucket"))
+	require.NoError(t.T(), err)
+}
+
+func (t *ReplayTest) replaying() gcs.Bucket {
+	b, err := NewReplayingBucket(bytes.NewReader(t.trace.Bytes()), tmpObjectPrefix)
+	require.NoError(t.T(), err)
+	return b
+}
+
+// Make a series of requests to the bucket, returning a description of the
+// responses.
+func workload(ctx context.Context, b gcs.Bucket) (log []string) {
+	// Responses are described as JSON, so that the objects they point to are
+	// compared rather than the pointers.
+	logf := func(format string, v ...any) {
+		for i := range v {
+			if _, isErr := v[i].(error); !isErr {
+				j, _ := json.Marshal(v[i])
+				v[i] = string(j)
+			}
+		}
+		log = append(log, fmt.Sprintf(format, v...))
+	}
+	zero := int64(0)
+
+	o, err := storageutil.CreateObject(ctx, b, "foo", []byte("taco"))
+	logf("create foo: %+v %v", o, err)
+	o, err = b.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		Contents:               strings.NewReader("burrito"),
+		GenerationPrecondition: &zero,
+	})
+	logf("create foo again: %+v %T", o, err)
+	_, err = storageutil.CreateObject(ctx, b, "dir/bar", []byte("enchilada"))
+	logf("create dir/bar: %v", err)
+
+	m, ext, err := b.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo", ForceFetchFromGcs: true, ReturnExtendedObjectAttributes: true})
+	logf("stat foo: %+v %+v %v", m, ext, err)
+	_, _, err = b.StatObject(ctx, &gcs.StatObjectRequest{Name: "baz"})
+	logf("stat baz: %T", err)
+
+	contents, err := storageutil.ReadObject(ctx, b, "dir/bar")
+	logf("read dir/bar: %q %v", contents, err)
+	rc, err := b.NewReader(ctx, &gcs.ReadObjectRequest{Name: "dir/bar", Range: &gcs.ByteRange{Start: 1, Limit: 5}})
+	if err == nil {
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(rc, buf)
+		logf("partial read dir/bar: %q %v", buf, err)
+		rc.Close()
+	}
+
+	listing, err := b.ListObjects(ctx, &gcs.ListObjectsRequest{Delimiter: "/"})
+	logf("list: %+v %v", listing, err)
+
+	o, err = b.CopyObject(ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "qux"})
+	logf("copy: %+v %v", o, err)
+	o, err = b.ComposeObjects(ctx, &gcs.ComposeObjectsRequest{
+		DstName: "quux",
+		Sources: []gcs.ComposeSource{{Name: "foo"}, {Name: "dir/bar"}},
+	})
+	logf("compose: %+v %v", o, err)
+	contentType := "text/plain"
+	o, err = b.UpdateObject(ctx, &gcs.UpdateObjectRequest{Name: "quux", ContentType: &contentType})
+	logf("update: %+v %v", o, err)
+	err = b.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: "qux"})
+	logf("delete: %v", err)
+
+	m, _, err = b.StatObject(ctx, &gcs.StatObjectRequest{Name: "qux"})
+	logf("stat qux: %+v %T", m, err)
+	return
+}
+
+func (t *ReplayTest) TestReplayMatchesRecording() {
+	recorded := workload(t.ctx, t.recording)
+
+	replayed := workload(t.ctx, t.replaying())
+
+	assert.Equal(t.T(), recorded, replayed)
+}
+
+func (t *ReplayTest) TestNameAndBucketType() {
+	b := t.replaying()
+
+	assert.Equal(t.T(), "some_bucket", b.Name())
+	assert.Equal(t.T(), t.recording.BucketType(), b.BucketType())
+}
+
+func (t *ReplayTest) TestIdenticalRequestsAnsweredInOrder() {
+	_, err := storageutil.CreateObject(t.ctx, t.recording, "foo", []byte("taco"))
+	require.NoError(t.T(), err)
+	m1, _, err := t.recording.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	_, err = storageutil.CreateObject(t.ctx, t.recording, "foo", []byte("burrito"))
+	require.NoError(t.T(), err)
+	m2, _, err := t.recording.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	b := t.replaying()
+
+	// Requests which differ can be made in another order.
+	m, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), m1.Generation, m.Generation)
+	m, _, err = b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), m2.Generation, m.Generation)
+	_, err = storageutil.CreateObject(t.ctx, b, "foo", []byte("burrito"))
+	assert.NoError(t.T(), err)
+
+	// But they are each answered once.
+	_, _, err = b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	assert.ErrorIs(t.T(), err, errNotRecorded)
+}
+
+func (t *ReplayTest) TestCreateObjectWithDifferentContents() {
+	_, err := storageutil.CreateObject(t.ctx, t.recording, "foo", []byte("taco"))
+	require.NoError(t.T(), err)
+
+	_, err = storageutil.CreateObject(t.ctx, t.replaying(), "foo", []byte("burrito"))
+
+	assert.ErrorIs(t.T(), err, errNotRecorded)
+}
+
+func (t *ReplayTest) TestVolatilePartsOfRequestsIgnored() {
+	// Write out a file by composing a temporary object, as done when appending.
+	write := func(b gcs.Bucket, tmpName string, mtime string) (o *gcs.Object, err error) {
+		tmp, err := storageutil.CreateObject(t.ctx, b, tmpName, []byte("taco"))
+		if err != nil {
+			return
+		}
+		o, err = b.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+			DstName:  "foo",
+			Sources:  []gcs.ComposeSource{{Name: tmpName, Generation: tmp.Generation}},
+			Metadata: map[string]string{"gcsfuse_mtime": mtime, "other": "value"},
+		})
+		if err != nil {
+			return
+		}
+		err = b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: tmpName})
+		return
+	}
+	recorded, err := write(t.recording, tmpObjectPrefix+"0123456789abcdef", "2024-05-01T12:00:00Z")
+	require.NoError(t.T(), err)
+
+	replayed, err := write(t.replaying(), tmpObjectPrefix+"fedcba9876543210", "2024-05-02T08:30:00Z")
+
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), recorded, replayed)
+}
+
+func (t *ReplayTest) TestOtherMetadataNotIgnored() {
+	update := func(b gcs.Bucket, value string) error {
+		_, err := b.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{
+			Name:     "foo",
+			Metadata: map[string]*string{"other": &value},
+		})
+		return err
+	}
+	_, err := storageutil.CreateObject(t.ctx, t.recording, "foo", []byte("taco"))
+	require.NoError(t.T(), err)
+	require.NoError(t.T(), update(t.recording, "taco"))
+	b := t.replaying()
+	_, err = storageutil.CreateObject(t.ctx, b, "foo", []byte("taco"))
+	require.NoError(t.T(), err)
+
+	err = update(b, "burrito")
+
+	assert.ErrorIs(t.T(), err, errNotRecorded)
+}
+
+func (t *ReplayTest) TestReadBeyondRecordedContents() {
+	_, err := storageutil.CreateObject(t.ctx, t.recording, "foo", []byte("taco"))
+	require.NoError(t.T(), err)
+	rc, err := t.recording.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	_, err = io.ReadFull(rc, make([]byte, 2))
+	require.NoError(t.T(), err)
+	rc.Close()
+
+	rc, err = t.replaying().NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	contents, err := io.ReadAll(rc)
+
+	assert.ErrorIs(t.T(), err, errNotRecorded)
+	assert.Equal(t.T(), "ta", string(contents))
+}
+
+func (t *ReplayTest) TestErrorKinds() {
+	roundTrip := func(err error) error { return encodeError(err).decode() }
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+	var apiErr *googleapi.Error
+
+	assert.Equal(t.T(), io.EOF, roundTrip(io.EOF))
+	assert.True(t.T(), errors.As(roundTrip(&gcs.NotFoundError{Err: errors.New("taco")}), &notFoundErr))
+	assert.True(t.T(), errors.As(roundTrip(&gcs.PreconditionError{Err: errors.New("taco")}), &preconditionErr))
+	require.True(t.T(), errors.As(roundTrip(fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusTooManyRequests})), &apiErr))
+	assert.Equal(t.T(), http.StatusTooManyRequests, apiErr.Code)
+	assert.ErrorIs(t.T(), roundTrip(fmt.Errorf("wrapped: %w", context.Canceled)), context.Canceled)
+	assert.ErrorIs(t.T(), roundTrip(fmt.Errorf("wrapped: %w", syscall.EROFS)), syscall.EROFS)
+	assert.EqualError(t.T(), roundTrip(errors.New("taco")), "taco")
+}
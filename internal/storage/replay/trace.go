@@ -0,0 +1,220 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay records the requests to a gcs.Bucket and their responses to
+// a trace, and serves a recorded trace back as a bucket without a network,
+// for reproducing behaviour which depends on the exact responses from GCS.
+//
+// A trace is a file of JSON values: a header describing the bucket, followed
+// by one entry per request.
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// URLScheme is the scheme of bucket URLs which select a bucket replaying the
+// trace at a path, e.g. "replay:///path/to/trace".
+const URLScheme = "replay://"
+
+// ParseBucketURL returns the path of the trace selected by the given bucket
+// URL, if it has the URLScheme scheme.
+func ParseBucketURL(name string) (path string, ok bool) {
+	path, ok = strings.CutPrefix(name, URLScheme)
+	return
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// The first value in a trace.
+type traceHeader struct {
+	Bucket     string
+	BucketType gcs.BucketType
+}
+
+// A request to the bucket and its response.
+type traceEntry struct {
+	// The order in which requests were made.
+	Seq    uint64
+	Method string
+
+	// The request, encoded as JSON. For CreateObject, the contents are
+	// recorded by their size and checksum instead.
+	Request        json.RawMessage
+	ContentsSize   int64   `json:",omitempty"`
+	ContentsCRC32C *uint32 `json:",omitempty"`
+
+	// The response.
+	Object             *gcs.Object                   `json:",omitempty"`
+	MinObject          *gcs.MinObject                `json:",omitempty"`
+	ExtendedAttributes *gcs.ExtendedObjectAttributes `json:",omitempty"`
+	Listing            *gcs.Listing                  `json:",omitempty"`
+	Folder             *gcs.Folder                   `json:",omitempty"`
+	Error              *traceError                   `json:",omitempty"`
+
+	// For NewReader, the bytes read before the reader was closed, and the error
+	// which ended the reads, if any.
+	Contents  []byte      `json:",omitempty"`
+	ReadError *traceError `json:",omitempty"`
+}
+
+// The request of RenameFolder, whose arguments aren't a request type.
+type renameFolderRequest struct {
+	FolderName          string
+	DestinationFolderId string
+}
+
+// The custom metadata keys whose values are wall-clock times, e.g. the
+// gcsx.MtimeMetadataKey set when files are written out.
+var volatileMetadataKeys = map[string]bool{
+	"gcsfuse_mtime": true,
+}
+
+// The key by which replayed requests are matched to recorded ones. The parts
+// of requests which differ between runs of the same workload are normalized:
+// the names of temporary objects, chosen at random with the given prefix, and
+// the values of custom metadata keys holding wall-clock times.
+func (e *traceEntry) key(tmpObjectPrefix string) string {
+	key := e.Method + " " + normalizeRequest(e.Request, tmpObjectPrefix)
+	if e.ContentsCRC32C != nil {
+		key += fmt.Sprintf(" %d/%08x", e.ContentsSize, *e.ContentsCRC32C)
+	}
+	return key
+}
+
+// Return the given request, encoded as JSON, with its volatile parts
+// normalized. See traceEntry.key.
+func normalizeRequest(request json.RawMessage, tmpObjectPrefix string) string {
+	decoder := json.NewDecoder(bytes.NewReader(request))
+	// Keep generations exact, rather than rounding them to float64.
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return string(request)
+	}
+
+	normalized, err := json.Marshal(normalizeValue(v, tmpObjectPrefix))
+	if err != nil {
+		return string(request)
+	}
+	return string(normalized)
+}
+
+func normalizeValue(v any, tmpObjectPrefix string) any {
+	switch v := v.(type) {
+	case string:
+		if tmpObjectPrefix != "" && len(v) > len(tmpObjectPrefix) && strings.HasPrefix(v, tmpObjectPrefix) {
+			return tmpObjectPrefix + "*"
+		}
+	case []any:
+		for i := range v {
+			v[i] = normalizeValue(v[i], tmpObjectPrefix)
+		}
+	case map[string]any:
+		for key, value := range v {
+			if metadata, ok := value.(map[string]any); ok && key == "Metadata" {
+				for metadataKey := range metadata {
+					if volatileMetadataKeys[metadataKey] {
+						metadata[metadataKey] = "*"
+					}
+				}
+			}
+			v[key] = normalizeValue(value, tmpObjectPrefix)
+		}
+	}
+	return v
+}
+
+// An error, encoded so that its type survives the round trip.
+type traceError struct {
+	Kind    string
+	Code    int `json:",omitempty"`
+	Message string
+}
+
+const (
+	notFoundErrorKind     = "not-found"
+	preconditionErrorKind = "precondition"
+	apiErrorKind          = "googleapi"
+	errnoErrorKind        = "errno"
+	eofErrorKind          = "eof"
+	canceledErrorKind     = "canceled"
+	deadlineErrorKind     = "deadline-exceeded"
+	otherErrorKind        = "other"
+)
+
+func encodeError(err error) *traceError {
+	if err == nil {
+		return nil
+	}
+
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+	var apiErr *googleapi.Error
+	var errno syscall.Errno
+	switch {
+	case err == io.EOF:
+		return &traceError{Kind: eofErrorKind, Message: err.Error()}
+	case errors.As(err, &notFoundErr):
+		return &traceError{Kind: notFoundErrorKind, Message: notFoundErr.Err.Error()}
+	case errors.As(err, &preconditionErr):
+		return &traceError{Kind: preconditionErrorKind, Message: preconditionErr.Err.Error()}
+	case errors.As(err, &apiErr):
+		return &traceError{Kind: apiErrorKind, Code: apiErr.Code, Message: apiErr.Message}
+	case errors.As(err, &errno):
+		return &traceError{Kind: errnoErrorKind, Code: int(errno), Message: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &traceError{Kind: canceledErrorKind, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &traceError{Kind: deadlineErrorKind, Message: err.Error()}
+	default:
+		return &traceError{Kind: otherErrorKind, Message: err.Error()}
+	}
+}
+
+func (e *traceError) decode() error {
+	if e == nil {
+		return nil
+	}
+
+	switch e.Kind {
+	case eofErrorKind:
+		return io.EOF
+	case notFoundErrorKind:
+		return &gcs.NotFoundError{Err: errors.New(e.Message)}
+	case preconditionErrorKind:
+		return &gcs.PreconditionError{Err: errors.New(e.Message)}
+	case apiErrorKind:
+		return &googleapi.Error{Code: e.Code, Message: e.Message}
+	case errnoErrorKind:
+		return fmt.Errorf("%s: %w", e.Message, syscall.Errno(e.Code))
+	case canceledErrorKind:
+		return fmt.Errorf("%s: %w", e.Message, context.Canceled)
+	case deadlineErrorKind:
+		return fmt.Errorf("%s: %w", e.Message, context.DeadlineExceeded)
+	default:
+		return errors.New(e.Message)
+	}
+}
@@ -0,0 +1,1583 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/cache/lru"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/jacobsa/syncutil"
+	"github.com/jacobsa/timeutil"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Keys of the S3 user metadata holding the attributes of objects which S3
+	// has no equivalent for. They're hidden from the object's Metadata.
+	s3CRC32CMetadataKey         = "gcsfuse-crc32c"
+	s3CustomTimeMetadataKey     = "gcsfuse-custom-time"
+	s3GenerationMetadataKey     = "gcsfuse-generation"
+	s3MetaGenerationMetadataKey = "gcsfuse-metageneration"
+	s3CreatedMetadataKey        = "gcsfuse-created"
+	s3UpdatedMetadataKey        = "gcsfuse-updated"
+	s3ComponentCountMetadataKey = "gcsfuse-component-count"
+
+	// The limits on the sizes of S3 objects written in a single request, and of
+	// the parts of multipart uploads other than the last.
+	s3MaxPartSize = 5 << 30
+	s3MinPartSize = 5 << 20
+
+	// The number of objects whose attributes the bucket remembers for listings.
+	s3KnownObjectsCount = 1 << 14
+
+	// The number of objects ending in the delimiter of a listing looked up at
+	// once.
+	s3ListHeadParallelism = 16
+
+	s3DefaultStorageClass = "STANDARD"
+	s3Owner               = "user-s3"
+)
+
+var s3CRC32CTable = crc32.MakeTable(crc32.Castagnoli)
+
+// The requests made by s3Bucket, as implemented by *s3.Client.
+type s3API interface {
+	HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CopyObject(ctx context.Context, in *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	UploadPartCopy(ctx context.Context, in *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+}
+
+// s3Bucket is a gcs.Bucket backed by a bucket of an S3-compatible object
+// store, so that the layers above gcs.Bucket can mount it unchanged.
+//
+// S3 has no generations, and keeps times only to the second, so the bucket
+// keeps the generation, meta-generation and times of the objects it writes in
+// their user metadata, as it does their CRC32Cs. Generations are the times in
+// nanoseconds at which they're written, made greater than those of the
+// versions the write replaces or is made from. Metadata is only changed by
+// copying the object over itself, which keeps the generation of the original
+// with the next meta-generation. Any process reading an object therefore sees
+// the same generation.
+//
+// Objects written by other S3 clients have none of those, so their generation
+// is derived from their Last-Modified time with a hash of their version ID,
+// or their ETag in buckets without versioning, in place of the fraction of
+// the second.
+//
+// Listings don't include the user metadata of objects, so the bucket
+// remembers the attributes of the versions it has written or looked up, and
+// lists those. Others are listed with the attributes S3 lists, and the
+// generation derived from their version, which requests for the object accept
+// as well as the generation kept in its metadata. Such listings are marked as
+// having incomplete objects.
+//
+// Preconditions are enforced atomically by making writes and deletes
+// conditional on the object's ETag, which changes with its contents, so a
+// concurrent change to only the metadata of an object goes unnoticed.
+type s3Bucket struct {
+	client s3API
+	name   string
+	clock  timeutil.Clock
+
+	// The directory in which to spool the contents of objects being created,
+	// or the default directory for temporary files if empty.
+	tempDir string
+
+	// The last versions written or looked up of objects, as s3KnownObjects by
+	// name.
+	known *lru.Cache
+
+	mu sync.Mutex
+
+	// The last generation given to an object written by the bucket, so that
+	// writes within the same tick of the clock get different ones.
+	//
+	// GUARDED_BY(mu)
+	lastGeneration int64
+}
+
+func newS3Bucket(
+	client s3API,
+	name string,
+	tempDir string,
+	clock timeutil.Clock) *s3Bucket {
+	return &s3Bucket{
+		client:  client,
+		name:    name,
+		clock:   clock,
+		tempDir: tempDir,
+		known:   lru.NewCache(s3KnownObjectsCount),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Convert an error from the given S3 request to the errors returned by
+// gcs.Bucket.
+func s3Error(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// So that the errors are mapped to errnos, and read, as those from GCS are.
+	apiErr := &googleapi.Error{
+		Code:    respErr.HTTPStatusCode(),
+		Message: fmt.Sprintf("%s: %s", op, respErr.Error()),
+	}
+	switch respErr.HTTPStatusCode() {
+	case http.StatusNotFound:
+		return &gcs.NotFoundError{Err: apiErr}
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return &gcs.PreconditionError{Err: apiErr}
+	default:
+		return apiErr
+	}
+}
+
+// Object names are checked as GCS does, so that they're valid for either.
+func checkS3Name(name string) (err error) {
+	if len(name) == 0 || len(name) > 1024 {
+		err = errors.New("Invalid object name: length must be in [1, 1024]")
+		return
+	}
+
+	if !utf8.ValidString(name) {
+		err = errors.New("Invalid object name: not valid UTF-8")
+		return
+	}
+
+	if strings.ContainsAny(name, "\r\n") {
+		err = errors.New("Invalid object name: must not contain CR or LF")
+	}
+	return
+}
+
+// The Range header for the given bytes. S3 takes the last byte's offset as a
+// signed integer, so larger limits are left open.
+func s3Range(start uint64, limit uint64) string {
+	if limit > math.MaxInt64 {
+		return fmt.Sprintf("bytes=%d-", start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, limit-1)
+}
+
+func s3GenerationNotFound(name string, generation int64) error {
+	return &gcs.NotFoundError{Err: fmt.Errorf("Object %s generation %d not found", name, generation)}
+}
+
+// A version of an object, as S3 identifies it.
+type s3Version struct {
+	// Empty in buckets without versioning.
+	id           string
+	etag         string
+	lastModified time.Time
+}
+
+// Listings give versions in buckets without versioning the ID "null", and
+// lookups none.
+func newS3Version(id *string, etag *string, lastModified *time.Time) s3Version {
+	v := s3Version{
+		id:           aws.ToString(id),
+		etag:         aws.ToString(etag),
+		lastModified: aws.ToTime(lastModified).Truncate(time.Second),
+	}
+	if v.id == "null" {
+		v.id = ""
+	}
+	return v
+}
+
+// The version ID, or the ETag in buckets without versioning.
+func (v s3Version) identity() string {
+	if v.id == "" {
+		return v.etag
+	}
+	return v.id
+}
+
+// The generation of a version not written by the bucket, or listed without
+// the one in its metadata, as any process derives it.
+func (v s3Version) generation() int64 {
+	h := fnv.New32a()
+	h.Write([]byte(v.identity()))
+	return v.lastModified.UnixNano() + int64(h.Sum32())%int64(time.Second)
+}
+
+// Does the given version of the object have the given generation, either the
+// one in its metadata or the one derived from the version?
+func hasGeneration(o *gcs.Object, v s3Version, generation int64) bool {
+	return o.Generation == generation || v.generation() == generation
+}
+
+// The attributes of a version of an object, as last written or looked up by
+// the bucket.
+type s3KnownObject struct {
+	version s3Version
+	object  *gcs.Object
+}
+
+func (k s3KnownObject) Size() uint64 {
+	return 1
+}
+
+// Remember the attributes of the given version of an object, for listings.
+func (b *s3Bucket) remember(o *gcs.Object, v s3Version) {
+	b.known.Insert(o.Name, s3KnownObject{version: v, object: o})
+}
+
+// Return a copy of the remembered attributes of the given version of the named
+// object, or nil if they're not known.
+func (b *s3Bucket) recall(name string, v s3Version) *gcs.Object {
+	k, ok := b.known.LookUp(name).(s3KnownObject)
+	if !ok || k.version != v {
+		return nil
+	}
+
+	o := *k.object
+	o.Metadata = maps.Clone(o.Metadata)
+	return &o
+}
+
+// Return the generation and time with which to write an object, the
+// generation being greater than the given ones.
+func (b *s3Bucket) nextGeneration(older ...int64) (generation int64, now time.Time) {
+	now = b.clock.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	generation = max(now.UnixNano(), b.lastGeneration+1)
+	for _, g := range older {
+		generation = max(generation, g+1)
+	}
+	b.lastGeneration = generation
+	return
+}
+
+// Multiply the vector by the matrix, over GF(2).
+func gf2MatrixTimes(matrix *[32]uint32, vector uint32) (product uint32) {
+	for i := 0; vector != 0; i, vector = i+1, vector>>1 {
+		if vector&1 != 0 {
+			product ^= matrix[i]
+		}
+	}
+	return
+}
+
+func gf2MatrixSquare(square *[32]uint32, matrix *[32]uint32) {
+	for i := range matrix {
+		square[i] = gf2MatrixTimes(matrix, matrix[i])
+	}
+}
+
+// Return the CRC32C of the concatenation of two strings, given theirs and the
+// length of the second, as zlib's crc32_combine does: the first CRC is
+// advanced over as many zero bits as are in the second string, by repeatedly
+// squaring the matrix which advances it over a single one.
+func combineCRC32C(crc1 uint32, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	// The operator advancing the CRC over one zero bit.
+	var even, odd [32]uint32
+	odd[0] = crc32.Castagnoli
+	for i := 1; i < 32; i++ {
+		odd[i] = 1 << (i - 1)
+	}
+
+	// Then over two, and four.
+	gf2MatrixSquare(&even, &odd)
+	gf2MatrixSquare(&odd, &even)
+
+	// Then over a byte, and each power of two bytes, applying those for the
+	// bits set in the length.
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		if len2 >>= 1; len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		if len2 >>= 1; len2 == 0 {
+			break
+		}
+	}
+
+	return crc1 ^ crc2
+}
+
+// The MD5 of an object which wasn't uploaded in parts is its ETag.
+func s3MD5(etag string) *[md5.Size]byte {
+	b, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil || len(b) != md5.Size {
+		return nil
+	}
+
+	var sum [md5.Size]byte
+	copy(sum[:], b)
+	return &sum
+}
+
+// Metadata values must be ASCII, hence others are encoded as by RFC 2047, as
+// S3 does itself.
+func encodeS3Metadata(metadata map[string]string) map[string]string {
+	encoded := make(map[string]string)
+	for k, v := range metadata {
+		encoded[k] = mime.QEncoding.Encode("utf-8", v)
+	}
+	return encoded
+}
+
+// Header names are case-insensitive, and S3 lower-cases them, as does the SDK,
+// but other stores may not.
+func decodeS3Metadata(metadata map[string]string) map[string]string {
+	decoded := make(map[string]string)
+	var decoder mime.WordDecoder
+	for k, v := range metadata {
+		value, err := decoder.DecodeHeader(v)
+		if err != nil {
+			value = v
+		}
+		decoded[strings.ToLower(k)] = value
+	}
+	return decoded
+}
+
+// The attributes of S3 objects common to the responses to HeadObject and
+// GetObject, and those of listed versions.
+type s3Attributes struct {
+	ContentLength      *int64
+	ContentType        *string
+	ContentLanguage    *string
+	ContentEncoding    *string
+	CacheControl       *string
+	ContentDisposition *string
+	ETag               *string
+	LastModified       *time.Time
+	VersionId          *string
+	Metadata           map[string]string
+	StorageClass       string
+}
+
+func (attrs *s3Attributes) version() s3Version {
+	return newS3Version(attrs.VersionId, attrs.ETag, attrs.LastModified)
+}
+
+func (b *s3Bucket) object(name string, attrs *s3Attributes) (o *gcs.Object) {
+	v := attrs.version()
+	o = &gcs.Object{
+		Name:               name,
+		ContentType:        aws.ToString(attrs.ContentType),
+		ContentLanguage:    aws.ToString(attrs.ContentLanguage),
+		CacheControl:       aws.ToString(attrs.CacheControl),
+		Owner:              s3Owner,
+		Size:               uint64(aws.ToInt64(attrs.ContentLength)),
+		ContentEncoding:    aws.ToString(attrs.ContentEncoding),
+		MD5:                s3MD5(v.etag),
+		MediaLink:          "http://localhost/download/storage/s3/" + b.name + "/" + name,
+		Metadata:           decodeS3Metadata(attrs.Metadata),
+		Generation:         v.generation(),
+		MetaGeneration:     1,
+		StorageClass:       attrs.StorageClass,
+		Created:            v.lastModified,
+		Updated:            v.lastModified,
+		ComponentCount:     1,
+		ContentDisposition: aws.ToString(attrs.ContentDisposition),
+	}
+	if o.StorageClass == "" {
+		o.StorageClass = s3DefaultStorageClass
+	}
+
+	// Move the attributes kept in metadata to their fields.
+	take := func(key string) (value string, ok bool) {
+		value, ok = o.Metadata[key]
+		delete(o.Metadata, key)
+		return
+	}
+	takeInt := func(key string, field *int64) {
+		if value, ok := take(key); ok {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				*field = n
+			}
+		}
+	}
+	takeTime := func(key string, field *time.Time) {
+		if value, ok := take(key); ok {
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				*field = t
+			}
+		}
+	}
+	if value, ok := take(s3CRC32CMetadataKey); ok {
+		if crc, err := strconv.ParseUint(value, 10, 32); err == nil {
+			crc32c := uint32(crc)
+			o.CRC32C = &crc32c
+		}
+	}
+	if value, ok := take(s3CustomTimeMetadataKey); ok {
+		o.CustomTime = value
+	}
+	takeInt(s3GenerationMetadataKey, &o.Generation)
+	takeInt(s3MetaGenerationMetadataKey, &o.MetaGeneration)
+	takeInt(s3ComponentCountMetadataKey, &o.ComponentCount)
+	takeTime(s3UpdatedMetadataKey, &o.Updated)
+	o.Created = o.Updated
+	takeTime(s3CreatedMetadataKey, &o.Created)
+	if len(o.Metadata) == 0 {
+		o.Metadata = nil
+	}
+
+	// As in GCS, composite objects have no MD5.
+	if o.ComponentCount > 1 {
+		o.MD5 = nil
+	}
+
+	return
+}
+
+// The attributes with which to write an object.
+type s3WriteAttributes struct {
+	ContentType        string
+	ContentLanguage    string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	CustomTime         string
+	StorageClass       string
+	Metadata           map[string]string
+	CRC32C             *uint32
+	Generation         int64
+	MetaGeneration     int64
+	Created            time.Time
+	Updated            time.Time
+	ComponentCount     int64
+}
+
+// The metadata with which to write an object, including the attributes kept
+// in it.
+func (attrs *s3WriteAttributes) metadata() map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	if attrs.CRC32C != nil {
+		metadata[s3CRC32CMetadataKey] = strconv.FormatUint(uint64(*attrs.CRC32C), 10)
+	}
+	if attrs.CustomTime != "" {
+		metadata[s3CustomTimeMetadataKey] = attrs.CustomTime
+	}
+	metadata[s3GenerationMetadataKey] = strconv.FormatInt(attrs.Generation, 10)
+	if attrs.MetaGeneration > 1 {
+		metadata[s3MetaGenerationMetadataKey] = strconv.FormatInt(attrs.MetaGeneration, 10)
+	}
+	if attrs.ComponentCount > 1 {
+		metadata[s3ComponentCountMetadataKey] = strconv.FormatInt(attrs.ComponentCount, 10)
+	}
+	if !attrs.Created.Equal(attrs.Updated) {
+		metadata[s3CreatedMetadataKey] = attrs.Created.UTC().Format(time.RFC3339Nano)
+	}
+	metadata[s3UpdatedMetadataKey] = attrs.Updated.UTC().Format(time.RFC3339Nano)
+	return encodeS3Metadata(metadata)
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+func (b *s3Bucket) copySource(name string) *string {
+	return aws.String(b.name + "/" + url.PathEscape(name))
+}
+
+// Return the object with the given name, and its version, remembering them for
+// listings.
+func (b *s3Bucket) head(ctx context.Context, name string) (o *gcs.Object, v s3Version, err error) {
+	o, v, err = b.headVersion(ctx, name, "")
+	if err != nil {
+		return
+	}
+
+	b.remember(o, v)
+	return
+}
+
+// Return the given version of the named object, or the latest if the ID is
+// empty.
+func (b *s3Bucket) headVersion(ctx context.Context, name string, versionID string) (o *gcs.Object, v s3Version, err error) {
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(b.name),
+		Key:       aws.String(name),
+		VersionId: optionalString(versionID),
+	})
+	if err != nil {
+		err = s3Error("HeadObject", err)
+		return
+	}
+
+	attrs := &s3Attributes{
+		ContentLength:      out.ContentLength,
+		ContentType:        out.ContentType,
+		ContentLanguage:    out.ContentLanguage,
+		ContentEncoding:    out.ContentEncoding,
+		CacheControl:       out.CacheControl,
+		ContentDisposition: out.ContentDisposition,
+		ETag:               out.ETag,
+		LastModified:       out.LastModified,
+		VersionId:          out.VersionId,
+		Metadata:           out.Metadata,
+		StorageClass:       string(out.StorageClass),
+	}
+	o = b.object(name, attrs)
+	v = attrs.version()
+	return
+}
+
+// Return the version of the named object with the given ID, just written by
+// the bucket, remembering it for listings.
+func (b *s3Bucket) written(ctx context.Context, name string, versionID string) (o *gcs.Object, err error) {
+	o, v, err := b.headVersion(ctx, name, versionID)
+	if err != nil {
+		return
+	}
+
+	b.remember(o, v)
+	return
+}
+
+// The conditions on which to write an object, as the values of the If-Match
+// and If-None-Match headers.
+type s3Conditions struct {
+	ifMatch     *string
+	ifNoneMatch *string
+}
+
+// Set the conditions on a request whose input has no fields for them, such as
+// CopyObject's, which has them only for its source.
+func (c s3Conditions) options(o *s3.Options) {
+	if c.ifMatch != nil {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-Match", *c.ifMatch))
+	}
+	if c.ifNoneMatch != nil {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", *c.ifNoneMatch))
+	}
+}
+
+// Return the conditions making a write to the named object conditional on the
+// given preconditions, and the generation of the object the write replaces,
+// if checking them needed it.
+func (b *s3Bucket) writePreconditions(
+	ctx context.Context,
+	name string,
+	generation *int64,
+	metaGeneration *int64) (cond s3Conditions, replaced int64, err error) {
+	if generation != nil && *generation == 0 {
+		cond.ifNoneMatch = aws.String("*")
+		return
+	}
+
+	if generation == nil && metaGeneration == nil {
+		return
+	}
+
+	o, v, err := b.head(ctx, name)
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		err = &gcs.PreconditionError{Err: errors.New("Precondition failed: object doesn't exist")}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if generation != nil && !hasGeneration(o, v, *generation) {
+		err = &gcs.PreconditionError{Err: fmt.Errorf("object %q has generation %d, not %d", name, o.Generation, *generation)}
+		return
+	}
+	if metaGeneration != nil && *metaGeneration != o.MetaGeneration {
+		err = &gcs.PreconditionError{Err: fmt.Errorf("object %q has meta-generation %d, not %d", name, o.MetaGeneration, *metaGeneration)}
+		return
+	}
+
+	cond.ifMatch = aws.String(v.etag)
+	replaced = o.Generation
+	return
+}
+
+// A range of an object to write as part of another.
+type s3Source struct {
+	name       string
+	etag       string
+	start, end int64
+}
+
+// An in-progress multipart upload to an object.
+type s3MultipartUpload struct {
+	bucket *s3Bucket
+	name   string
+	id     *string
+	parts  []types.CompletedPart
+}
+
+func (b *s3Bucket) startMultipartUpload(
+	ctx context.Context,
+	name string,
+	attrs *s3WriteAttributes) (u *s3MultipartUpload, err error) {
+	out, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(b.name),
+		Key:                aws.String(name),
+		ContentType:        optionalString(attrs.ContentType),
+		ContentLanguage:    optionalString(attrs.ContentLanguage),
+		ContentEncoding:    optionalString(attrs.ContentEncoding),
+		CacheControl:       optionalString(attrs.CacheControl),
+		ContentDisposition: optionalString(attrs.ContentDisposition),
+		StorageClass:       types.StorageClass(attrs.StorageClass),
+		Metadata:           attrs.metadata(),
+	})
+	if err != nil {
+		err = s3Error("CreateMultipartUpload", err)
+		return
+	}
+
+	u = &s3MultipartUpload{bucket: b, name: name, id: out.UploadId}
+	return
+}
+
+func (u *s3MultipartUpload) nextPartNumber() *int32 {
+	return aws.Int32(int32(len(u.parts) + 1))
+}
+
+func (u *s3MultipartUpload) uploadPart(ctx context.Context, body io.ReadSeeker) (err error) {
+	out, err := u.bucket.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(u.bucket.name),
+		Key:        aws.String(u.name),
+		UploadId:   u.id,
+		PartNumber: u.nextPartNumber(),
+		Body:       body,
+	})
+	if err != nil {
+		err = s3Error("UploadPart", err)
+		return
+	}
+
+	u.parts = append(u.parts, types.CompletedPart{ETag: out.ETag, PartNumber: u.nextPartNumber()})
+	return
+}
+
+func (u *s3MultipartUpload) copyPart(ctx context.Context, src s3Source) (err error) {
+	out, err := u.bucket.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+		Bucket:            aws.String(u.bucket.name),
+		Key:               aws.String(u.name),
+		UploadId:          u.id,
+		PartNumber:        u.nextPartNumber(),
+		CopySource:        u.bucket.copySource(src.name),
+		CopySourceIfMatch: aws.String(src.etag),
+		CopySourceRange:   aws.String(s3Range(uint64(src.start), uint64(src.end))),
+	})
+	if err != nil {
+		err = s3Error("UploadPartCopy", err)
+		return
+	}
+
+	u.parts = append(u.parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: u.nextPartNumber()})
+	return
+}
+
+// Complete the upload, returning the ID of the version written.
+func (u *s3MultipartUpload) complete(ctx context.Context, cond s3Conditions) (versionID string, err error) {
+	out, err := u.bucket.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucket.name),
+		Key:             aws.String(u.name),
+		UploadId:        u.id,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+		IfMatch:         cond.ifMatch,
+		IfNoneMatch:     cond.ifNoneMatch,
+	})
+	if err != nil {
+		err = s3Error("CompleteMultipartUpload", err)
+		return
+	}
+
+	versionID = aws.ToString(out.VersionId)
+	return
+}
+
+// Abort the upload, so that S3 frees its parts. Failures are ignored, since
+// there's nothing to do about them.
+func (u *s3MultipartUpload) abort(ctx context.Context) {
+	u.bucket.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.bucket.name),
+		Key:      aws.String(u.name),
+		UploadId: u.id,
+	})
+}
+
+// Read the given source into memory.
+func (b *s3Bucket) readSource(ctx context.Context, src s3Source) (contents []byte, err error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(b.name),
+		Key:     aws.String(src.name),
+		IfMatch: aws.String(src.etag),
+		Range:   aws.String(s3Range(uint64(src.start), uint64(src.end))),
+	})
+	if err != nil {
+		err = s3Error("GetObject", err)
+		return
+	}
+	defer out.Body.Close()
+
+	contents, err = io.ReadAll(out.Body)
+	if err != nil {
+		err = fmt.Errorf("reading %q: %w", src.name, err)
+	}
+	return
+}
+
+// Write the given sources, in order, to the named object with a multipart
+// upload, copying them within S3 where they're large enough to make parts of
+// their own, and reading them into parts otherwise. Return the ID of the
+// version written.
+func (b *s3Bucket) writeMultipart(
+	ctx context.Context,
+	name string,
+	attrs *s3WriteAttributes,
+	sources []s3Source,
+	cond s3Conditions) (versionID string, err error) {
+	u, err := b.startMultipartUpload(ctx, name, attrs)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			u.abort(ctx)
+		}
+	}()
+
+	var buf []byte
+	for i, src := range sources {
+		last := i == len(sources)-1
+		for src.start < src.end {
+			// Copy what's left of the source as a part if it's large enough, or if
+			// it's the last part.
+			if len(buf) == 0 && (src.end-src.start >= s3MinPartSize || last) {
+				part := src
+				part.end = min(src.end, src.start+s3MaxPartSize)
+				if err = u.copyPart(ctx, part); err != nil {
+					return
+				}
+				src.start = part.end
+				continue
+			}
+
+			// Otherwise read enough of it to fill a part.
+			part := src
+			part.end = min(src.end, src.start+s3MinPartSize-int64(len(buf)))
+			var contents []byte
+			if contents, err = b.readSource(ctx, part); err != nil {
+				return
+			}
+			buf = append(buf, contents...)
+			src.start = part.end
+
+			if len(buf) >= s3MinPartSize {
+				if err = u.uploadPart(ctx, bytes.NewReader(buf)); err != nil {
+					return
+				}
+				buf = nil
+			}
+		}
+	}
+
+	// Upload what's left over, or an empty part if there's nothing else.
+	if len(buf) > 0 || len(u.parts) == 0 {
+		if err = u.uploadPart(ctx, bytes.NewReader(buf)); err != nil {
+			return
+		}
+	}
+
+	versionID, err = u.complete(ctx, cond)
+	return
+}
+
+// Write the given sources to the named object, copying them within S3.
+// Return the ID of the version written.
+func (b *s3Bucket) writeFromSources(
+	ctx context.Context,
+	name string,
+	attrs *s3WriteAttributes,
+	sources []s3Source,
+	cond s3Conditions) (versionID string, err error) {
+	// A single source which is small enough can be copied in one request.
+	if len(sources) == 1 && sources[0].start == 0 && sources[0].end <= s3MaxPartSize {
+		var out *s3.CopyObjectOutput
+		out, err = b.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:             aws.String(b.name),
+			Key:                aws.String(name),
+			CopySource:         b.copySource(sources[0].name),
+			CopySourceIfMatch:  aws.String(sources[0].etag),
+			MetadataDirective:  types.MetadataDirectiveReplace,
+			ContentType:        optionalString(attrs.ContentType),
+			ContentLanguage:    optionalString(attrs.ContentLanguage),
+			ContentEncoding:    optionalString(attrs.ContentEncoding),
+			CacheControl:       optionalString(attrs.CacheControl),
+			ContentDisposition: optionalString(attrs.ContentDisposition),
+			StorageClass:       types.StorageClass(attrs.StorageClass),
+			Metadata:           attrs.metadata(),
+		}, cond.options)
+		if err != nil {
+			err = s3Error("CopyObject", err)
+			return
+		}
+
+		versionID = aws.ToString(out.VersionId)
+		return
+	}
+
+	versionID, err = b.writeMultipart(ctx, name, attrs, sources, cond)
+	return
+}
+
+// The attributes with which to rewrite the given object, other than its
+// generation and times.
+func writeAttributes(o *gcs.Object) *s3WriteAttributes {
+	return &s3WriteAttributes{
+		ContentType:        o.ContentType,
+		ContentLanguage:    o.ContentLanguage,
+		ContentEncoding:    o.ContentEncoding,
+		CacheControl:       o.CacheControl,
+		ContentDisposition: o.ContentDisposition,
+		CustomTime:         o.CustomTime,
+		StorageClass:       o.StorageClass,
+		Metadata:           o.Metadata,
+		CRC32C:             o.CRC32C,
+		ComponentCount:     o.ComponentCount,
+	}
+}
+
+// Return the named object and its version, failing with a not found error if
+// it doesn't have the given generation, unless that's zero.
+func (b *s3Bucket) headGeneration(ctx context.Context, name string, generation int64) (o *gcs.Object, v s3Version, err error) {
+	o, v, err = b.head(ctx, name)
+	if err != nil {
+		return
+	}
+
+	if generation != 0 && !hasGeneration(o, v, generation) {
+		err = s3GenerationNotFound(name, generation)
+	}
+	return
+}
+
+// Return the version of the named object with the given generation, as
+// headGeneration does, but looking among the noncurrent versions of the object
+// too, which only buckets with versioning keep.
+func (b *s3Bucket) findGeneration(ctx context.Context, name string, generation int64) (o *gcs.Object, v s3Version, err error) {
+	o, v, err = b.head(ctx, name)
+	if err != nil || generation == 0 || hasGeneration(o, v, generation) {
+		return
+	}
+
+	latest := v
+	if latest.id == "" {
+		err = s3GenerationNotFound(name, generation)
+		return
+	}
+
+	// The versions of an object are listed newest first, before those of any
+	// other object with its name as a prefix. Each is looked up for the
+	// generation kept in its metadata.
+	in := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(b.name),
+		Prefix: aws.String(name),
+	}
+	for {
+		var out *s3.ListObjectVersionsOutput
+		out, err = b.client.ListObjectVersions(ctx, in)
+		if err != nil {
+			err = s3Error("ListObjectVersions", err)
+			return
+		}
+
+		for _, listed := range out.Versions {
+			if aws.ToString(listed.Key) != name {
+				err = s3GenerationNotFound(name, generation)
+				return
+			}
+
+			candidate := newS3Version(listed.VersionId, listed.ETag, listed.LastModified)
+			if candidate.id == latest.id {
+				continue
+			}
+
+			o, v, err = b.headVersion(ctx, name, candidate.id)
+			var notFoundErr *gcs.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				// Deleted since it was listed.
+				continue
+			}
+			if err != nil || hasGeneration(o, v, generation) {
+				return
+			}
+		}
+
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.KeyMarker = out.NextKeyMarker
+		in.VersionIdMarker = out.NextVersionIdMarker
+	}
+
+	o, v, err = nil, s3Version{}, s3GenerationNotFound(name, generation)
+	return
+}
+
+// Read the given range of the given version of the named object, or of the
+// latest if the ID is empty. Return a nil output if the range starts beyond
+// the end of the version.
+func (b *s3Bucket) get(
+	ctx context.Context,
+	name string,
+	versionID string,
+	byteRange *gcs.ByteRange) (out *s3.GetObjectOutput, err error) {
+	in := &s3.GetObjectInput{
+		Bucket:    aws.String(b.name),
+		Key:       aws.String(name),
+		VersionId: optionalString(versionID),
+	}
+	if byteRange != nil {
+		in.Range = aws.String(s3Range(byteRange.Start, byteRange.Limit))
+	}
+
+	out, err = b.client.GetObject(ctx, in)
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable {
+		out, err = nil, nil
+		return
+	}
+
+	err = s3Error("GetObject", err)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *s3Bucket) Name() string {
+	return b.name
+}
+
+func (b *s3Bucket) BucketType() gcs.BucketType {
+	return gcs.NonHierarchical
+}
+
+func (b *s3Bucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	// Read the latest version, unless the range is empty, which S3 rejects.
+	empty := req.Range != nil && req.Range.Limit <= req.Range.Start
+	if !empty {
+		var out *s3.GetObjectOutput
+		if out, err = b.get(ctx, req.Name, "", req.Range); err != nil {
+			return
+		}
+
+		if out != nil {
+			attrs := &s3Attributes{
+				ETag:         out.ETag,
+				LastModified: out.LastModified,
+				VersionId:    out.VersionId,
+				Metadata:     out.Metadata,
+			}
+			if req.Generation == 0 || hasGeneration(b.object(req.Name, attrs), attrs.version(), req.Generation) {
+				rc = out.Body
+				return
+			}
+			out.Body.Close()
+		}
+	}
+
+	// Otherwise look up the version with the generation, which may be
+	// noncurrent, and read that if there's anything in the range.
+	o, v, err := b.findGeneration(ctx, req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	if empty || (req.Range != nil && req.Range.Start >= o.Size) {
+		rc = io.NopCloser(bytes.NewReader(nil))
+		return
+	}
+
+	out, err := b.get(ctx, req.Name, v.id, req.Range)
+	if err != nil {
+		return
+	}
+	if out == nil {
+		rc = io.NopCloser(bytes.NewReader(nil))
+		return
+	}
+
+	rc = out.Body
+	return
+}
+
+func (b *s3Bucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if err = checkS3Name(req.Name); err != nil {
+		return
+	}
+
+	// S3 needs the size of the contents up front, so spool them to a temporary
+	// file, checksumming them on the way.
+	f, err := os.CreateTemp(b.tempDir, "gcsfuse-s3-")
+	if err != nil {
+		err = fmt.Errorf("CreateTemp: %w", err)
+		return
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	crc := crc32.New(s3CRC32CTable)
+	md5Hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(f, crc, md5Hash), req.Contents)
+	if err != nil {
+		err = fmt.Errorf("reading contents: %w", err)
+		return
+	}
+
+	crc32c := crc.Sum32()
+	if req.CRC32C != nil && *req.CRC32C != crc32c {
+		err = fmt.Errorf("CRC32C mismatch: got 0x%08x, expected 0x%08x", crc32c, *req.CRC32C)
+		return
+	}
+	var md5Sum [md5.Size]byte
+	md5Hash.Sum(md5Sum[:0])
+	if req.MD5 != nil && *req.MD5 != md5Sum {
+		err = fmt.Errorf("MD5 mismatch: got %x, expected %x", md5Sum, *req.MD5)
+		return
+	}
+
+	cond, replaced, err := b.writePreconditions(ctx, req.Name, req.GenerationPrecondition, req.MetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	generation, now := b.nextGeneration(replaced)
+	attrs := &s3WriteAttributes{
+		ContentType:        req.ContentType,
+		ContentLanguage:    req.ContentLanguage,
+		ContentEncoding:    req.ContentEncoding,
+		CacheControl:       req.CacheControl,
+		ContentDisposition: req.ContentDisposition,
+		CustomTime:         req.CustomTime,
+		StorageClass:       req.StorageClass,
+		Metadata:           req.Metadata,
+		CRC32C:             &crc32c,
+		Generation:         generation,
+		Created:            now,
+		Updated:            now,
+	}
+
+	var versionID string
+	if size > s3MaxPartSize {
+		versionID, err = b.uploadMultipart(ctx, req.Name, attrs, f, size, cond)
+		if err != nil {
+			return
+		}
+	} else {
+		var out *s3.PutObjectOutput
+		out, err = b.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:             aws.String(b.name),
+			Key:                aws.String(req.Name),
+			Body:               io.NewSectionReader(f, 0, size),
+			ContentLength:      aws.Int64(size),
+			ContentMD5:         aws.String(base64.StdEncoding.EncodeToString(md5Sum[:])),
+			ContentType:        optionalString(attrs.ContentType),
+			ContentLanguage:    optionalString(attrs.ContentLanguage),
+			ContentEncoding:    optionalString(attrs.ContentEncoding),
+			CacheControl:       optionalString(attrs.CacheControl),
+			ContentDisposition: optionalString(attrs.ContentDisposition),
+			StorageClass:       types.StorageClass(attrs.StorageClass),
+			Metadata:           attrs.metadata(),
+			IfMatch:            cond.ifMatch,
+			IfNoneMatch:        cond.ifNoneMatch,
+		})
+		if err != nil {
+			err = s3Error("PutObject", err)
+			return
+		}
+		versionID = aws.ToString(out.VersionId)
+	}
+
+	o, err = b.written(ctx, req.Name, versionID)
+	return
+}
+
+// Upload the contents of the given file, too large for a single request, to
+// the named object in parts. Return the ID of the version written.
+func (b *s3Bucket) uploadMultipart(
+	ctx context.Context,
+	name string,
+	attrs *s3WriteAttributes,
+	f *os.File,
+	size int64,
+	cond s3Conditions) (versionID string, err error) {
+	u, err := b.startMultipartUpload(ctx, name, attrs)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			u.abort(ctx)
+		}
+	}()
+
+	for start := int64(0); start < size; start += s3MaxPartSize {
+		if err = u.uploadPart(ctx, io.NewSectionReader(f, start, min(s3MaxPartSize, size-start))); err != nil {
+			return
+		}
+	}
+
+	versionID, err = u.complete(ctx, cond)
+	return
+}
+
+func (b *s3Bucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	if err = checkS3Name(req.DstName); err != nil {
+		return
+	}
+
+	src, srcVersion, err := b.headGeneration(ctx, req.SrcName, req.SrcGeneration)
+	if err != nil {
+		return
+	}
+
+	if req.SrcMetaGenerationPrecondition != nil && *req.SrcMetaGenerationPrecondition != src.MetaGeneration {
+		err = &gcs.PreconditionError{Err: fmt.Errorf("object %q has meta-generation %d, not %d", req.SrcName, src.MetaGeneration, *req.SrcMetaGenerationPrecondition)}
+		return
+	}
+
+	cond, replaced, err := b.writePreconditions(ctx, req.DstName, req.DstGenerationPrecondition, nil)
+	if err != nil {
+		return
+	}
+
+	attrs := writeAttributes(src)
+	attrs.Generation, attrs.Created = b.nextGeneration(src.Generation, replaced)
+	attrs.Updated = attrs.Created
+	versionID, err := b.writeFromSources(
+		ctx,
+		req.DstName,
+		attrs,
+		[]s3Source{{name: req.SrcName, etag: srcVersion.etag, start: 0, end: int64(src.Size)}},
+		cond)
+	if err != nil {
+		return
+	}
+
+	o, err = b.written(ctx, req.DstName, versionID)
+	return
+}
+
+func (b *s3Bucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	if err = checkS3Name(req.DstName); err != nil {
+		return
+	}
+
+	switch {
+	case len(req.Sources) == 0:
+		err = errors.New("you must provide at least one source component")
+		return
+	case len(req.Sources) > gcs.MaxSourcesPerComposeRequest:
+		err = fmt.Errorf("the number of source components provided (%d) exceeds the maximum (%d)", len(req.Sources), gcs.MaxSourcesPerComposeRequest)
+		return
+	}
+
+	cond, replaced, err := b.writePreconditions(ctx, req.DstName, req.DstGenerationPrecondition, req.DstMetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	// S3 doesn't checksum the composite as a whole, so its CRC32C is combined
+	// from those of the sources, if they all have one.
+	var sources []s3Source
+	older := []int64{replaced}
+	var componentCount int64
+	crc32c := aws.Uint32(0)
+	for _, s := range req.Sources {
+		var src *gcs.Object
+		var v s3Version
+		src, v, err = b.headGeneration(ctx, s.Name, s.Generation)
+		if err != nil {
+			return
+		}
+
+		sources = append(sources, s3Source{name: s.Name, etag: v.etag, start: 0, end: int64(src.Size)})
+		older = append(older, src.Generation)
+		componentCount += src.ComponentCount
+		if crc32c != nil && src.CRC32C != nil {
+			crc32c = aws.Uint32(combineCRC32C(*crc32c, *src.CRC32C, int64(src.Size)))
+		} else {
+			crc32c = nil
+		}
+	}
+
+	if componentCount > gcs.MaxComponentCount {
+		err = fmt.Errorf("the result would have too many components (%d, more than %d)", componentCount, gcs.MaxComponentCount)
+		return
+	}
+
+	attrs := &s3WriteAttributes{
+		ContentType:        req.ContentType,
+		ContentLanguage:    req.ContentLanguage,
+		ContentEncoding:    req.ContentEncoding,
+		CacheControl:       req.CacheControl,
+		ContentDisposition: req.ContentDisposition,
+		CustomTime:         req.CustomTime,
+		StorageClass:       req.StorageClass,
+		Metadata:           req.Metadata,
+		CRC32C:             crc32c,
+		ComponentCount:     componentCount,
+	}
+	attrs.Generation, attrs.Created = b.nextGeneration(older...)
+	attrs.Updated = attrs.Created
+
+	versionID, err := b.writeMultipart(ctx, req.DstName, attrs, sources, cond)
+	if err != nil {
+		return
+	}
+
+	o, err = b.written(ctx, req.DstName, versionID)
+	return
+}
+
+func (b *s3Bucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (m *gcs.MinObject, e *gcs.ExtendedObjectAttributes, err error) {
+	o, _, err := b.head(ctx, req.Name)
+	if err != nil {
+		return
+	}
+
+	m = &gcs.MinObject{
+		Name:            o.Name,
+		Size:            o.Size,
+		Generation:      o.Generation,
+		MetaGeneration:  o.MetaGeneration,
+		Updated:         o.Updated,
+		Metadata:        o.Metadata,
+		ContentEncoding: o.ContentEncoding,
+		CRC32C:          o.CRC32C,
+	}
+	if req.ReturnExtendedObjectAttributes {
+		e = &gcs.ExtendedObjectAttributes{
+			ContentType:        o.ContentType,
+			ContentLanguage:    o.ContentLanguage,
+			CacheControl:       o.CacheControl,
+			Owner:              o.Owner,
+			MD5:                o.MD5,
+			MediaLink:          o.MediaLink,
+			StorageClass:       o.StorageClass,
+			Created:            o.Created,
+			Deleted:            o.Deleted,
+			ComponentCount:     o.ComponentCount,
+			ContentDisposition: o.ContentDisposition,
+			CustomTime:         o.CustomTime,
+			EventBasedHold:     o.EventBasedHold,
+			Acl:                o.Acl,
+		}
+	}
+	return
+}
+
+func (b *s3Bucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	if req.Versions || req.SoftDeleted {
+		err = errors.New("S3 buckets don't support listing noncurrent or soft-deleted objects")
+		return
+	}
+
+	in := &s3.ListObjectVersionsInput{
+		Bucket:    aws.String(b.name),
+		Prefix:    optionalString(req.Prefix),
+		Delimiter: optionalString(req.Delimiter),
+	}
+	if req.ContinuationToken != "" {
+		var markers url.Values
+		if markers, err = url.ParseQuery(req.ContinuationToken); err != nil {
+			err = fmt.Errorf("invalid continuation token %q: %w", req.ContinuationToken, err)
+			return
+		}
+		in.KeyMarker = optionalString(markers.Get("key"))
+		in.VersionIdMarker = optionalString(markers.Get("version"))
+	}
+	if req.MaxResults > 0 {
+		in.MaxKeys = aws.Int32(int32(min(req.MaxResults, math.MaxInt32)))
+	}
+
+	// Versions are listed for their IDs, which object listings don't include.
+	out, err := b.client.ListObjectVersions(ctx, in)
+	if err != nil {
+		err = s3Error("ListObjectVersions", err)
+		return
+	}
+
+	// Objects whose latest versions are delete markers are left out, as are the
+	// noncurrent versions of others. Those whose attributes aren't remembered
+	// are listed with those S3 lists, lacking their metadata and content
+	// headers.
+	listing = &gcs.Listing{}
+	for _, listed := range out.Versions {
+		if !aws.ToBool(listed.IsLatest) {
+			continue
+		}
+
+		name := aws.ToString(listed.Key)
+		v := newS3Version(listed.VersionId, listed.ETag, listed.LastModified)
+		o := b.recall(name, v)
+		if o == nil {
+			o = b.object(name, &s3Attributes{
+				ContentLength: listed.Size,
+				ETag:          listed.ETag,
+				LastModified:  listed.LastModified,
+				VersionId:     listed.VersionId,
+				StorageClass:  string(listed.StorageClass),
+			})
+			listing.IncompleteObjects = true
+		}
+		listing.Objects = append(listing.Objects, o)
+	}
+
+	// S3 only lists objects ending in the delimiter as collapsed runs, so those
+	// are looked up, a request each. Those which don't exist are left out.
+	var runs []string
+	for _, p := range out.CommonPrefixes {
+		prefix := aws.ToString(p.Prefix)
+		listing.CollapsedRuns = append(listing.CollapsedRuns, prefix)
+		if req.IncludeTrailingDelimiter {
+			runs = append(runs, prefix)
+		}
+	}
+
+	runObjects := make([]*gcs.Object, len(runs))
+	bundle := syncutil.NewBundle(ctx)
+	sem := make(chan struct{}, s3ListHeadParallelism)
+	for i, name := range runs {
+		bundle.Add(func(ctx context.Context) error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			o, _, err := b.head(ctx, name)
+			var notFoundErr *gcs.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				return nil
+			}
+			runObjects[i] = o
+			return err
+		})
+	}
+	if err = bundle.Join(); err != nil {
+		return
+	}
+
+	for _, o := range runObjects {
+		if o != nil {
+			listing.Objects = append(listing.Objects, o)
+		}
+	}
+	sort.Slice(listing.Objects, func(i, j int) bool { return listing.Objects[i].Name < listing.Objects[j].Name })
+
+	if aws.ToBool(out.IsTruncated) {
+		listing.ContinuationToken = url.Values{
+			"key":     {aws.ToString(out.NextKeyMarker)},
+			"version": {aws.ToString(out.NextVersionIdMarker)},
+		}.Encode()
+	}
+	return
+}
+
+func (b *s3Bucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	orig, v, err := b.headGeneration(ctx, req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	if req.MetaGenerationPrecondition != nil && *req.MetaGenerationPrecondition != orig.MetaGeneration {
+		err = &gcs.PreconditionError{Err: fmt.Errorf("object %q has meta-generation %d, not %d", req.Name, orig.MetaGeneration, *req.MetaGenerationPrecondition)}
+		return
+	}
+
+	// S3 can only change the metadata of an object by copying it over itself,
+	// on the condition that it's still the same.
+	attrs := writeAttributes(orig)
+	attrs.Generation = orig.Generation
+	attrs.MetaGeneration = orig.MetaGeneration + 1
+	attrs.Created = orig.Created
+	attrs.Updated = b.clock.Now()
+	update := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	update(&attrs.ContentType, req.ContentType)
+	update(&attrs.ContentEncoding, req.ContentEncoding)
+	update(&attrs.ContentLanguage, req.ContentLanguage)
+	update(&attrs.CacheControl, req.CacheControl)
+	attrs.Metadata = maps.Clone(attrs.Metadata)
+	if attrs.Metadata == nil {
+		attrs.Metadata = make(map[string]string)
+	}
+	for k, v := range req.Metadata {
+		if v == nil {
+			delete(attrs.Metadata, k)
+		} else {
+			attrs.Metadata[k] = *v
+		}
+	}
+
+	versionID, err := b.writeFromSources(
+		ctx,
+		req.Name,
+		attrs,
+		[]s3Source{{name: req.Name, etag: v.etag, start: 0, end: int64(orig.Size)}},
+		s3Conditions{ifMatch: aws.String(v.etag)})
+	if err != nil {
+		return
+	}
+
+	o, err = b.written(ctx, req.Name, versionID)
+	return
+}
+
+func (b *s3Bucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	// Delete the version checked, on the condition that it's still the latest.
+	// There's nothing to do if the object or generation doesn't exist.
+	in := &s3.DeleteObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(req.Name),
+	}
+	if req.Generation != 0 || req.MetaGenerationPrecondition != nil {
+		var o *gcs.Object
+		var v s3Version
+		o, v, err = b.headGeneration(ctx, req.Name, req.Generation)
+		var notFoundErr *gcs.NotFoundError
+		if errors.As(err, &notFoundErr) {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if req.MetaGenerationPrecondition != nil && *req.MetaGenerationPrecondition != o.MetaGeneration {
+			err = &gcs.PreconditionError{Err: fmt.Errorf("object %q has meta-generation %d, not %d", req.Name, o.MetaGeneration, *req.MetaGenerationPrecondition)}
+			return
+		}
+
+		in.IfMatch = aws.String(v.etag)
+	}
+
+	_, err = b.client.DeleteObject(ctx, in)
+
+	// Nor if the generation checked has since been replaced.
+	var preconditionErr *gcs.PreconditionError
+	if err = s3Error("DeleteObject", err); errors.As(err, &preconditionErr) && req.Generation != 0 {
+		err = nil
+		return
+	}
+	if err == nil {
+		b.known.Erase(req.Name)
+	}
+	return
+}
+
+func (b *s3Bucket) DeleteFolder(ctx context.Context, folderName string) error {
+	return errors.New("S3 buckets don't have folders")
+}
+
+func (b *s3Bucket) GetFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	return nil, errors.New("S3 buckets don't have folders")
+}
+
+func (b *s3Bucket) CreateFolder(ctx context.Context, folderName string) (*gcs.Folder, error) {
+	return nil, errors.New("S3 buckets don't have folders")
+}
+
+func (b *s3Bucket) RenameFolder(ctx context.Context, folderName string, destinationFolderId string) (*gcs.Folder, error) {
+	return nil, errors.New("S3 buckets don't have folders")
+}
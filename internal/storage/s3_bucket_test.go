@@ -0,0 +1,1255 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	gcstesting "github.com/googlecloudplatform/gcsfuse/v2/internal/storage/fake/testing"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/api/googleapi"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Fake S3
+////////////////////////////////////////////////////////////////////////
+
+// The attributes of S3 objects set when they're written.
+type fakeS3Attributes struct {
+	contentType        *string
+	contentLanguage    *string
+	contentEncoding    *string
+	cacheControl       *string
+	contentDisposition *string
+	storageClass       types.StorageClass
+	metadata           map[string]string
+}
+
+type fakeS3Object struct {
+	fakeS3Attributes
+	contents     []byte
+	etag         string
+	lastModified time.Time
+	versionID    string
+	deleteMarker bool
+}
+
+type fakeS3Upload struct {
+	fakeS3Attributes
+	key   string
+	parts map[int32][]byte
+}
+
+// An in-memory S3 bucket, implementing the requests made by s3Bucket.
+type fakeS3 struct {
+	clock timeutil.Clock
+
+	// Does the bucket have versioning enabled?
+	versioned bool
+
+	mu sync.Mutex
+
+	// The latest versions of the objects which exist, by key.
+	objects map[string]*fakeS3Object
+
+	// Every version of each key, including delete markers, oldest first, if
+	// the bucket has versioning enabled.
+	versions map[string][]*fakeS3Object
+
+	uploads    map[string]*fakeS3Upload
+	nextUpload int
+
+	// The methods called, in order.
+	calls []string
+}
+
+func newFakeS3(clock timeutil.Clock) *fakeS3 {
+	return &fakeS3{
+		clock:    clock,
+		objects:  make(map[string]*fakeS3Object),
+		versions: make(map[string][]*fakeS3Object),
+		uploads:  make(map[string]*fakeS3Upload),
+	}
+}
+
+func fakeS3Error(code string, status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      &smithy.GenericAPIError{Code: code, Message: code},
+		},
+	}
+}
+
+// Return the headers the given options set on a request.
+func fakeS3Headers(ctx context.Context, optFns []func(*s3.Options)) (header http.Header, err error) {
+	var o s3.Options
+	for _, fn := range optFns {
+		fn(&o)
+	}
+
+	stack := middleware.NewStack("fake", smithyhttp.NewStackRequest)
+	for _, fn := range o.APIOptions {
+		if err = fn(stack); err != nil {
+			return
+		}
+	}
+
+	_, _, err = stack.HandleMiddleware(ctx, nil, middleware.HandlerFunc(
+		func(ctx context.Context, in interface{}) (interface{}, middleware.Metadata, error) {
+			header = in.(*smithyhttp.Request).Header
+			return nil, middleware.Metadata{}, nil
+		}))
+	return
+}
+
+// S3 lower-cases the names of the metadata headers.
+func lowerMetadata(metadata map[string]string) map[string]string {
+	lower := make(map[string]string)
+	for k, v := range metadata {
+		lower[strings.ToLower(k)] = v
+	}
+	return lower
+}
+
+func (f *fakeS3) call(method string) {
+	f.calls = append(f.calls, method)
+}
+
+func (f *fakeS3) checkWrite(key string, ifMatch *string, ifNoneMatch *string) error {
+	o, exists := f.objects[key]
+	if aws.ToString(ifNoneMatch) == "*" && exists {
+		return fakeS3Error("PreconditionFailed", http.StatusPreconditionFailed)
+	}
+	if m := aws.ToString(ifMatch); m != "" && (!exists || o.etag != m) {
+		return fakeS3Error("PreconditionFailed", http.StatusPreconditionFailed)
+	}
+	return nil
+}
+
+// Return the given version of the object with the given key, or its latest if
+// the ID is nil.
+func (f *fakeS3) version(key string, versionID *string) (o *fakeS3Object, ok bool) {
+	if versionID == nil {
+		o, ok = f.objects[key]
+		return
+	}
+
+	if !f.versioned {
+		o, ok = f.objects[key]
+		ok = ok && *versionID == "null"
+		return
+	}
+
+	for _, v := range f.versions[key] {
+		if v.versionID == *versionID && !v.deleteMarker {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// The version ID S3 returns for the object, which it doesn't without
+// versioning.
+func (f *fakeS3) versionID(o *fakeS3Object) *string {
+	if !f.versioned {
+		return nil
+	}
+	return aws.String(o.versionID)
+}
+
+func (f *fakeS3) source(copySource string, ifMatch *string) (o *fakeS3Object, err error) {
+	bucketAndKey, err := url.PathUnescape(copySource)
+	if err != nil {
+		return
+	}
+
+	_, key, _ := strings.Cut(bucketAndKey, "/")
+	o, ok := f.objects[key]
+	if !ok {
+		err = fakeS3Error("NoSuchKey", http.StatusNotFound)
+		return
+	}
+	if ifMatch != nil && *ifMatch != o.etag {
+		err = fakeS3Error("PreconditionFailed", http.StatusPreconditionFailed)
+	}
+	return
+}
+
+// Return the given range of the contents, or false if it's unsatisfiable.
+func byteRange(contents []byte, r *string) ([]byte, bool) {
+	if r == nil {
+		return contents, true
+	}
+
+	// The end is left out of open ranges.
+	start, end := 0, len(contents)-1
+	fmt.Sscanf(*r, "bytes=%d-%d", &start, &end)
+	if start >= len(contents) {
+		return nil, false
+	}
+	return contents[start:min(end+1, len(contents))], true
+}
+
+// Read a request body of the given length, if known, into a slice of just
+// that size, since the conformance tests keep many objects around.
+func readBody(body io.Reader, length *int64) ([]byte, error) {
+	if length == nil {
+		return io.ReadAll(body)
+	}
+
+	contents := make([]byte, *length)
+	_, err := io.ReadFull(body, contents)
+	return contents, err
+}
+
+// Add a version of the object with the given key.
+func (f *fakeS3) addVersion(key string, o *fakeS3Object) {
+	o.lastModified = f.clock.Now()
+	o.versionID = "null"
+	if f.versioned {
+		o.versionID = fmt.Sprintf("version-%d", len(f.versions[key]))
+		f.versions[key] = append(f.versions[key], o)
+	}
+}
+
+func (f *fakeS3) put(key string, contents []byte, etag string, attrs fakeS3Attributes) *fakeS3Object {
+	attrs.metadata = lowerMetadata(attrs.metadata)
+	o := &fakeS3Object{
+		fakeS3Attributes: attrs,
+		contents:         contents,
+		etag:             etag,
+	}
+	f.addVersion(key, o)
+	f.objects[key] = o
+	return o
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("HeadObject")
+
+	o, ok := f.version(*in.Key, in.VersionId)
+	if !ok {
+		return nil, fakeS3Error("NotFound", http.StatusNotFound)
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength:      aws.Int64(int64(len(o.contents))),
+		ContentType:        o.contentType,
+		ContentLanguage:    o.contentLanguage,
+		ContentEncoding:    o.contentEncoding,
+		CacheControl:       o.cacheControl,
+		ContentDisposition: o.contentDisposition,
+		StorageClass:       o.storageClass,
+		ETag:               aws.String(o.etag),
+		LastModified:       aws.Time(o.lastModified),
+		Metadata:           o.metadata,
+		VersionId:          f.versionID(o),
+	}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("GetObject")
+
+	o, ok := f.version(*in.Key, in.VersionId)
+	if !ok {
+		return nil, fakeS3Error("NoSuchKey", http.StatusNotFound)
+	}
+	if in.IfMatch != nil && *in.IfMatch != o.etag {
+		return nil, fakeS3Error("PreconditionFailed", http.StatusPreconditionFailed)
+	}
+
+	contents, ok := byteRange(o.contents, in.Range)
+	if !ok {
+		return nil, fakeS3Error("InvalidRange", http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	return &s3.GetObjectOutput{
+		Body:         io.NopCloser(bytes.NewReader(contents)),
+		ETag:         aws.String(o.etag),
+		LastModified: aws.Time(o.lastModified),
+		VersionId:    f.versionID(o),
+		Metadata:     o.metadata,
+	}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("PutObject")
+
+	if err := f.checkWrite(*in.Key, in.IfMatch, in.IfNoneMatch); err != nil {
+		return nil, err
+	}
+
+	contents, err := readBody(in.Body, in.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(contents))
+	o := f.put(*in.Key, contents, etag, fakeS3Attributes{
+		contentType:        in.ContentType,
+		contentLanguage:    in.ContentLanguage,
+		contentEncoding:    in.ContentEncoding,
+		cacheControl:       in.CacheControl,
+		contentDisposition: in.ContentDisposition,
+		storageClass:       in.StorageClass,
+		metadata:           in.Metadata,
+	})
+	return &s3.PutObjectOutput{ETag: aws.String(etag), VersionId: f.versionID(o)}, nil
+}
+
+func (f *fakeS3) CopyObject(ctx context.Context, in *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("CopyObject")
+
+	src, err := f.source(*in.CopySource, in.CopySourceIfMatch)
+	if err != nil {
+		return nil, err
+	}
+	h, err := fakeS3Headers(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkWrite(*in.Key, optionalString(h.Get("If-Match")), optionalString(h.Get("If-None-Match"))); err != nil {
+		return nil, err
+	}
+
+	o := f.put(*in.Key, src.contents, fmt.Sprintf(`"%x"`, md5.Sum(src.contents)), fakeS3Attributes{
+		contentType:        in.ContentType,
+		contentLanguage:    in.ContentLanguage,
+		contentEncoding:    in.ContentEncoding,
+		cacheControl:       in.CacheControl,
+		contentDisposition: in.ContentDisposition,
+		storageClass:       in.StorageClass,
+		metadata:           in.Metadata,
+	})
+	return &s3.CopyObjectOutput{VersionId: f.versionID(o)}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("CreateMultipartUpload")
+
+	id := fmt.Sprintf("upload-%d", f.nextUpload)
+	f.nextUpload++
+	f.uploads[id] = &fakeS3Upload{
+		fakeS3Attributes: fakeS3Attributes{
+			contentType:        in.ContentType,
+			contentLanguage:    in.ContentLanguage,
+			contentEncoding:    in.ContentEncoding,
+			cacheControl:       in.CacheControl,
+			contentDisposition: in.ContentDisposition,
+			storageClass:       in.StorageClass,
+			metadata:           in.Metadata,
+		},
+		key:   *in.Key,
+		parts: make(map[int32][]byte),
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("UploadPart")
+
+	contents, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.uploads[*in.UploadId].parts[*in.PartNumber] = contents
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"%x"`, md5.Sum(contents)))}, nil
+}
+
+func (f *fakeS3) UploadPartCopy(ctx context.Context, in *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("UploadPartCopy")
+
+	src, err := f.source(*in.CopySource, in.CopySourceIfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, ok := byteRange(src.contents, in.CopySourceRange)
+	if !ok {
+		return nil, fakeS3Error("InvalidRange", http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	f.uploads[*in.UploadId].parts[*in.PartNumber] = contents
+	return &s3.UploadPartCopyOutput{
+		CopyPartResult: &types.CopyPartResult{ETag: aws.String(fmt.Sprintf(`"%x"`, md5.Sum(contents)))},
+	}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("CompleteMultipartUpload")
+
+	u := f.uploads[*in.UploadId]
+	if err := f.checkWrite(u.key, in.IfMatch, in.IfNoneMatch); err != nil {
+		return nil, err
+	}
+
+	var contents []byte
+	md5s := md5.New()
+	for i, p := range in.MultipartUpload.Parts {
+		part := u.parts[*p.PartNumber]
+		if i < len(in.MultipartUpload.Parts)-1 && len(part) < s3MinPartSize {
+			return nil, fakeS3Error("EntityTooSmall", http.StatusBadRequest)
+		}
+
+		contents = append(contents, part...)
+		sum := md5.Sum(part)
+		md5s.Write(sum[:])
+	}
+
+	etag := fmt.Sprintf(`"%x-%d"`, md5s.Sum(nil), len(in.MultipartUpload.Parts))
+	o := f.put(u.key, contents, etag, u.fakeS3Attributes)
+	delete(f.uploads, *in.UploadId)
+	return &s3.CompleteMultipartUploadOutput{ETag: aws.String(etag), VersionId: f.versionID(o)}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("AbortMultipartUpload")
+
+	delete(f.uploads, *in.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DeleteObject")
+
+	if err := f.checkWrite(*in.Key, in.IfMatch, nil); err != nil {
+		return nil, err
+	}
+
+	// With versioning, the object is hidden behind a delete marker.
+	if _, ok := f.objects[*in.Key]; ok && f.versioned {
+		f.addVersion(*in.Key, &fakeS3Object{deleteMarker: true})
+	}
+	delete(f.objects, *in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// Return the versions of the object with the given key, newest first.
+func (f *fakeS3) newestVersions(key string) []*fakeS3Object {
+	if !f.versioned {
+		return []*fakeS3Object{f.objects[key]}
+	}
+
+	var versions []*fakeS3Object
+	for i := len(f.versions[key]) - 1; i >= 0; i-- {
+		versions = append(versions, f.versions[key][i])
+	}
+	return versions
+}
+
+func (f *fakeS3) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("ListObjectVersions")
+
+	keys := make(map[string]bool)
+	for k := range f.objects {
+		keys[k] = true
+	}
+	for k := range f.versions {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	out := &s3.ListObjectVersionsOutput{}
+	prefix := aws.ToString(in.Prefix)
+	delimiter := aws.ToString(in.Delimiter)
+	maxKeys := int(aws.ToInt32(in.MaxKeys))
+	keyMarker := aws.ToString(in.KeyMarker)
+	versionIDMarker := aws.ToString(in.VersionIdMarker)
+	lastPrefix := ""
+	count := 0
+	full := func() bool {
+		if maxKeys > 0 && count == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			return true
+		}
+		return false
+	}
+
+keys:
+	for _, k := range sorted {
+		if !strings.HasPrefix(k, prefix) || k < keyMarker || (k == keyMarker && versionIDMarker == "") {
+			continue
+		}
+
+		if i := strings.Index(k[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			p := k[:len(prefix)+i+len(delimiter)]
+			if p != lastPrefix {
+				if full() {
+					break
+				}
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(p)})
+				lastPrefix = p
+				count++
+				out.NextKeyMarker = aws.String(p + "\xff")
+				out.NextVersionIdMarker = nil
+			}
+			continue
+		}
+
+		versions := f.newestVersions(k)
+		for i, v := range versions {
+			// Skip the versions up to the marker.
+			if k == keyMarker {
+				if v.versionID == versionIDMarker {
+					keyMarker = ""
+				}
+				continue
+			}
+
+			if full() {
+				break keys
+			}
+			if v.deleteMarker {
+				out.DeleteMarkers = append(out.DeleteMarkers, types.DeleteMarkerEntry{
+					Key:          aws.String(k),
+					VersionId:    aws.String(v.versionID),
+					IsLatest:     aws.Bool(i == 0),
+					LastModified: aws.Time(v.lastModified),
+				})
+			} else {
+				out.Versions = append(out.Versions, types.ObjectVersion{
+					Key:          aws.String(k),
+					VersionId:    aws.String(v.versionID),
+					IsLatest:     aws.Bool(i == 0),
+					LastModified: aws.Time(v.lastModified),
+					ETag:         aws.String(v.etag),
+					Size:         aws.Int64(int64(len(v.contents))),
+					StorageClass: types.ObjectVersionStorageClass(v.storageClass),
+				})
+			}
+			count++
+			out.NextKeyMarker = aws.String(k)
+			out.NextVersionIdMarker = aws.String(v.versionID)
+		}
+	}
+
+	if !aws.ToBool(out.IsTruncated) {
+		out.NextKeyMarker = nil
+		out.NextVersionIdMarker = nil
+	}
+	return out, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+// Run the tests every gcs.Bucket must pass against a bucket without
+// versioning, since they expect overwritten generations to be gone.
+func TestS3BucketConformance(t *testing.T) {
+	ogletest.RunTests(t)
+}
+
+func init() {
+	// S3 keeps times to the second, so a real clock is used for writes to
+	// happen within the same second, as they do against S3.
+	makeDeps := func(ctx context.Context) (deps gcstesting.BucketTestDeps) {
+		clock := timeutil.RealClock()
+		fake := newFakeS3(clock)
+
+		deps.Clock = clock
+		deps.Bucket = newS3Bucket(fake, "some_bucket", "", clock)
+		deps.BuffersEntireContentsForCreate = true
+		return
+	}
+
+	gcstesting.RegisterBucketTests(makeDeps)
+}
+
+type S3BucketTest struct {
+	suite.Suite
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	fake   *fakeS3
+	bucket gcs.Bucket
+}
+
+func TestS3BucketTestSuite(t *testing.T) {
+	suite.Run(t, new(S3BucketTest))
+}
+
+func (t *S3BucketTest) SetupTest() {
+	t.ctx = context.Background()
+	t.clock.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	t.fake = newFakeS3(&t.clock)
+	t.bucket = newS3Bucket(t.fake, "some_bucket", "", &t.clock)
+}
+
+func (t *S3BucketTest) create(name string, contents []byte) *gcs.Object {
+	o, err := storageutil.CreateObject(t.ctx, t.bucket, name, contents)
+	require.NoError(t.T(), err)
+	return o
+}
+
+func (t *S3BucketTest) TestNameAndBucketType() {
+	assert.Equal(t.T(), "some_bucket", t.bucket.Name())
+	assert.Equal(t.T(), gcs.NonHierarchical, t.bucket.BucketType())
+}
+
+func (t *S3BucketTest) TestCreateObject() {
+	o, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:        "foo",
+		ContentType: "text/plain",
+		CustomTime:  "2024-04-01T00:00:00Z",
+		Metadata:    map[string]string{"gcsfuse_mtime": "2024-04-01", "target": "tácø"},
+		Contents:    strings.NewReader("taco"),
+	})
+
+	require.NoError(t.T(), err)
+	expectedCRC32C := crc32.Checksum([]byte("taco"), s3CRC32CTable)
+	expectedMD5 := md5.Sum([]byte("taco"))
+	assert.Equal(t.T(), "foo", o.Name)
+	assert.Equal(t.T(), uint64(4), o.Size)
+	assert.Equal(t.T(), "text/plain", o.ContentType)
+	assert.Equal(t.T(), "2024-04-01T00:00:00Z", o.CustomTime)
+	assert.Equal(t.T(), map[string]string{"gcsfuse_mtime": "2024-04-01", "target": "tácø"}, o.Metadata)
+	assert.Equal(t.T(), &expectedCRC32C, o.CRC32C)
+	assert.Equal(t.T(), &expectedMD5, o.MD5)
+	assert.NotZero(t.T(), o.Generation)
+	assert.Equal(t.T(), int64(1), o.MetaGeneration)
+	assert.Equal(t.T(), int64(1), o.ComponentCount)
+	assert.Equal(t.T(), "STANDARD", o.StorageClass)
+	assert.Equal(t.T(), t.clock.Now(), o.Updated)
+	// Non-ASCII metadata is encoded for S3.
+	assert.Equal(t.T(), "=?utf-8?q?t=C3=A1c=C3=B8?=", t.fake.objects["foo"].metadata["target"])
+}
+
+func (t *S3BucketTest) TestCreateObject_SpoolsToTempDir() {
+	b := newS3Bucket(t.fake, "some_bucket", "/no/such/dir", &t.clock)
+
+	_, err := b.CreateObject(t.ctx, &gcs.CreateObjectRequest{Name: "foo", Contents: strings.NewReader("taco")})
+
+	assert.ErrorContains(t.T(), err, "/no/such/dir")
+}
+
+func (t *S3BucketTest) TestCreateObject_ChecksumMismatch() {
+	crc32c := uint32(17)
+
+	_, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+		CRC32C:   &crc32c,
+	})
+
+	assert.ErrorContains(t.T(), err, "CRC32C mismatch")
+	assert.NotContains(t.T(), t.fake.objects, "foo")
+}
+
+func (t *S3BucketTest) TestCreateObject_GenerationPreconditions() {
+	o := t.create("foo", []byte("taco"))
+	zero := int64(0)
+	wrong := o.Generation + 1
+	var preconditionErr *gcs.PreconditionError
+
+	_, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{Name: "foo", Contents: strings.NewReader("burrito"), GenerationPrecondition: &zero})
+	assert.True(t.T(), errors.As(err, &preconditionErr))
+	_, err = t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{Name: "foo", Contents: strings.NewReader("burrito"), GenerationPrecondition: &wrong})
+	assert.True(t.T(), errors.As(err, &preconditionErr))
+	_, err = t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{Name: "bar", Contents: strings.NewReader("burrito"), GenerationPrecondition: &wrong})
+	assert.True(t.T(), errors.As(err, &preconditionErr))
+	_, err = t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{Name: "foo", Contents: strings.NewReader("burrito"), GenerationPrecondition: &o.Generation})
+	assert.NoError(t.T(), err)
+	_, err = t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{Name: "bar", Contents: strings.NewReader("burrito"), GenerationPrecondition: &zero})
+	assert.NoError(t.T(), err)
+}
+
+func (t *S3BucketTest) TestGenerationsIncrease() {
+	o1 := t.create("foo", []byte("taco"))
+	t.clock.AdvanceTime(time.Second)
+
+	o2 := t.create("foo", []byte("burrito"))
+
+	assert.Greater(t.T(), o2.Generation, o1.Generation)
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), o2.Generation, m.Generation)
+	// The old generation is gone.
+	_, err = t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o1.Generation})
+	var notFoundErr *gcs.NotFoundError
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "burrito", string(contents))
+}
+
+func (t *S3BucketTest) TestGenerationsIncrease_WithinSecond() {
+	o1 := t.create("foo", []byte("taco"))
+
+	// Even with the same contents, and so ETag.
+	o2, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:                   "foo",
+		Contents:               strings.NewReader("taco"),
+		GenerationPrecondition: &o1.Generation,
+	})
+
+	require.NoError(t.T(), err)
+	assert.Greater(t.T(), o2.Generation, o1.Generation)
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), o2.Generation, m.Generation)
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	require.NoError(t.T(), err)
+	require.Len(t.T(), listing.Objects, 1)
+	assert.Equal(t.T(), o2.Generation, listing.Objects[0].Generation)
+}
+
+func (t *S3BucketTest) TestGenerationsIncrease_Composite() {
+	o1 := t.create("foo", []byte("taco"))
+	o2 := t.create("bar", []byte("burrito"))
+
+	o, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName:                   "foo",
+		DstGenerationPrecondition: &o1.Generation,
+		Sources:                   []gcs.ComposeSource{{Name: "foo"}, {Name: "bar"}},
+	})
+
+	require.NoError(t.T(), err)
+	assert.Greater(t.T(), o.Generation, o1.Generation)
+	assert.Greater(t.T(), o.Generation, o2.Generation)
+}
+
+func (t *S3BucketTest) TestGenerations_Versioned() {
+	t.fake.versioned = true
+	o1 := t.create("foo", []byte("taco"))
+
+	// Versions with the same contents, written within the same second, have
+	// different IDs, and so generations.
+	o2 := t.create("foo", []byte("taco"))
+
+	assert.NotEqual(t.T(), o1.Generation, o2.Generation)
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), o2.Generation, m.Generation)
+	// Another process sees the same generation.
+	other := newS3Bucket(t.fake, "some_bucket", "", &t.clock)
+	m, _, err = other.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), o2.Generation, m.Generation)
+}
+
+func (t *S3BucketTest) TestGenerations_WrittenByOtherClients() {
+	t.fake.versioned = true
+	put := func() int64 {
+		_, err := t.fake.PutObject(t.ctx, &s3.PutObjectInput{
+			Bucket: aws.String("some_bucket"),
+			Key:    aws.String("foo"),
+			Body:   strings.NewReader("taco"),
+		})
+		require.NoError(t.T(), err)
+		m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+		require.NoError(t.T(), err)
+		return m.Generation
+	}
+
+	// Versions without the bucket's metadata, with the same contents and
+	// written within the same second, have different IDs, and so generations,
+	// derived from them.
+	g1 := put()
+	g2 := put()
+
+	assert.NotEqual(t.T(), g1, g2)
+	assert.Equal(t.T(), newS3Version(aws.String(t.fake.objects["foo"].versionID), aws.String(t.fake.objects["foo"].etag), aws.Time(t.clock.Now())).generation(), g2)
+}
+
+func (t *S3BucketTest) TestNewReader_NoncurrentGeneration() {
+	t.fake.versioned = true
+	o1 := t.create("foo", []byte("taco burrito"))
+	t.create("foo", []byte("enchilada"))
+	read := func(r *gcs.ByteRange) string {
+		rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o1.Generation, Range: r})
+		require.NoError(t.T(), err)
+		defer rc.Close()
+		contents, err := io.ReadAll(rc)
+		require.NoError(t.T(), err)
+		return string(contents)
+	}
+
+	// The version S3 keeps is read by its ID, even beyond the end of the latest.
+	assert.Equal(t.T(), "taco burrito", read(nil))
+	assert.Equal(t.T(), "burrito", read(&gcs.ByteRange{Start: 5, Limit: 12}))
+	assert.Equal(t.T(), "", read(&gcs.ByteRange{Start: 12, Limit: 20}))
+	assert.Equal(t.T(), "", read(&gcs.ByteRange{Start: 5, Limit: 5}))
+	// Not so once it's deleted.
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	_, err = t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o1.Generation})
+	var notFoundErr *gcs.NotFoundError
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+}
+
+func (t *S3BucketTest) TestNewReader_OverwrittenWithoutVersioning() {
+	o1 := t.create("foo", []byte("taco"))
+	t.create("foo", []byte("burrito"))
+
+	_, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o1.Generation})
+
+	var notFoundErr *gcs.NotFoundError
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+}
+
+func (t *S3BucketTest) TestNewReader_Ranges() {
+	o := t.create("foo", []byte("taco burrito"))
+	read := func(r *gcs.ByteRange) string {
+		rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o.Generation, Range: r})
+		require.NoError(t.T(), err)
+		defer rc.Close()
+		contents, err := io.ReadAll(rc)
+		require.NoError(t.T(), err)
+		return string(contents)
+	}
+
+	assert.Equal(t.T(), "burrito", read(&gcs.ByteRange{Start: 5, Limit: 12}))
+	assert.Equal(t.T(), "rito", read(&gcs.ByteRange{Start: 8, Limit: 100}))
+	assert.Equal(t.T(), "", read(&gcs.ByteRange{Start: 5, Limit: 5}))
+	assert.Equal(t.T(), "", read(&gcs.ByteRange{Start: 100, Limit: 200}))
+}
+
+func (t *S3BucketTest) TestNotFound() {
+	var notFoundErr *gcs.NotFoundError
+
+	_, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+	_, err = t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+	_, err = t.bucket.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+	_, err = t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{Name: "foo"})
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+}
+
+func (t *S3BucketTest) TestOtherErrors() {
+	b := newS3Bucket(&erroringS3{}, "some_bucket", "", &t.clock)
+
+	_, _, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	var apiErr *googleapi.Error
+	require.True(t.T(), errors.As(err, &apiErr))
+	assert.Equal(t.T(), http.StatusForbidden, apiErr.Code)
+}
+
+func TestCombineCRC32C(t *testing.T) {
+	a := []byte("taco")
+	b := bytes.Repeat([]byte("burrito"), 1000)
+
+	combined := combineCRC32C(crc32.Checksum(a, s3CRC32CTable), crc32.Checksum(b, s3CRC32CTable), int64(len(b)))
+
+	assert.Equal(t, crc32.Checksum(append(a, b...), s3CRC32CTable), combined)
+	assert.Equal(t, crc32.Checksum(a, s3CRC32CTable), combineCRC32C(crc32.Checksum(a, s3CRC32CTable), 0, 0))
+}
+
+type erroringS3 struct {
+	s3API
+}
+
+func (e *erroringS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return nil, fakeS3Error("AccessDenied", http.StatusForbidden)
+}
+
+func (t *S3BucketTest) TestCopyObject() {
+	src, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:        "foo",
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"bar": "baz"},
+		Contents:    strings.NewReader("taco"),
+	})
+	require.NoError(t.T(), err)
+
+	o, err := t.bucket.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "qux", SrcGeneration: src.Generation})
+
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "qux", o.Name)
+	assert.Equal(t.T(), "text/plain", o.ContentType)
+	assert.Equal(t.T(), map[string]string{"bar": "baz"}, o.Metadata)
+	assert.Equal(t.T(), src.CRC32C, o.CRC32C)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "qux")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "taco", string(contents))
+}
+
+func (t *S3BucketTest) TestCopyObject_WrongSrcGeneration() {
+	src := t.create("foo", []byte("taco"))
+
+	_, err := t.bucket.CopyObject(t.ctx, &gcs.CopyObjectRequest{SrcName: "foo", DstName: "qux", SrcGeneration: src.Generation + 1})
+
+	var notFoundErr *gcs.NotFoundError
+	assert.True(t.T(), errors.As(err, &notFoundErr))
+	assert.NotContains(t.T(), t.fake.objects, "qux")
+}
+
+func (t *S3BucketTest) TestComposeObjects_SmallSources() {
+	t.create("foo", []byte("taco"))
+	t.create("bar", []byte("burrito"))
+	zero := int64(0)
+
+	o, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName:                   "qux",
+		DstGenerationPrecondition: &zero,
+		Sources:                   []gcs.ComposeSource{{Name: "foo"}, {Name: "bar"}, {Name: "foo"}},
+		ContentType:               "text/plain",
+	})
+
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), uint64(15), o.Size)
+	assert.Equal(t.T(), "text/plain", o.ContentType)
+	// S3 doesn't checksum the composite, so its CRC32C is combined from those
+	// of the sources.
+	expectedCRC32C := crc32.Checksum([]byte("tacoburritotaco"), s3CRC32CTable)
+	assert.Equal(t.T(), &expectedCRC32C, o.CRC32C)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "qux")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "tacoburritotaco", string(contents))
+	// The sources were read, since they're too small to be parts.
+	assert.NotContains(t.T(), t.fake.calls, "UploadPartCopy")
+}
+
+func (t *S3BucketTest) TestComposeObjects_LargeSources() {
+	large := bytes.Repeat([]byte("x"), s3MinPartSize+1)
+	t.create("foo", large)
+	t.create("bar", []byte("burrito"))
+	t.fake.calls = nil
+
+	o, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName: "foo",
+		Sources: []gcs.ComposeSource{{Name: "foo"}, {Name: "bar"}},
+	})
+
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), uint64(len(large)+7), o.Size)
+	assert.Equal(t.T(), int64(2), o.ComponentCount)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), append(large, "burrito"...), contents)
+	// Both sources were copied within S3.
+	assert.NotContains(t.T(), t.fake.calls[:len(t.fake.calls)-1], "GetObject")
+}
+
+func (t *S3BucketTest) TestComposeObjects_SmallThenLargeSources() {
+	large := bytes.Repeat([]byte("x"), 2*s3MinPartSize)
+	t.create("foo", []byte("taco"))
+	t.create("bar", large)
+	t.create("baz", []byte("burrito"))
+
+	_, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName: "qux",
+		Sources: []gcs.ComposeSource{{Name: "foo"}, {Name: "bar"}, {Name: "baz"}},
+	})
+
+	require.NoError(t.T(), err)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "qux")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), append(append([]byte("taco"), large...), "burrito"...), contents)
+}
+
+func (t *S3BucketTest) TestComposeObjects_PreconditionFailure() {
+	t.create("foo", []byte("taco"))
+	zero := int64(0)
+
+	_, err := t.bucket.ComposeObjects(t.ctx, &gcs.ComposeObjectsRequest{
+		DstName:                   "foo",
+		DstGenerationPrecondition: &zero,
+		Sources:                   []gcs.ComposeSource{{Name: "foo"}, {Name: "foo"}},
+	})
+
+	var preconditionErr *gcs.PreconditionError
+	assert.True(t.T(), errors.As(err, &preconditionErr))
+	assert.Empty(t.T(), t.fake.uploads)
+}
+
+func (t *S3BucketTest) TestUpdateObject() {
+	src, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Metadata: map[string]string{"bar": "baz", "qux": "quux"},
+		Contents: strings.NewReader("taco"),
+	})
+	require.NoError(t.T(), err)
+	contentType := "text/plain"
+	newValue := "burrito"
+
+	o, err := t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{
+		Name:        "foo",
+		Generation:  src.Generation,
+		ContentType: &contentType,
+		Metadata:    map[string]*string{"bar": &newValue, "qux": nil},
+	})
+
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "text/plain", o.ContentType)
+	assert.Equal(t.T(), map[string]string{"bar": "burrito"}, o.Metadata)
+	assert.Equal(t.T(), src.CRC32C, o.CRC32C)
+	// The copy S3 made keeps the generation, with the next meta-generation.
+	assert.Equal(t.T(), src.Generation, o.Generation)
+	assert.Equal(t.T(), int64(2), o.MetaGeneration)
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), src.Generation, m.Generation)
+	assert.Equal(t.T(), int64(2), m.MetaGeneration)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "taco", string(contents))
+	// As does another process.
+	other := newS3Bucket(t.fake, "some_bucket", "", &t.clock)
+	m, _, err = other.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), src.Generation, m.Generation)
+	assert.Equal(t.T(), int64(2), m.MetaGeneration)
+}
+
+func (t *S3BucketTest) TestUpdateObject_MetaGenerationPrecondition() {
+	t.create("foo", []byte("taco"))
+	two := int64(2)
+
+	_, err := t.bucket.UpdateObject(t.ctx, &gcs.UpdateObjectRequest{Name: "foo", MetaGenerationPrecondition: &two})
+
+	var preconditionErr *gcs.PreconditionError
+	assert.True(t.T(), errors.As(err, &preconditionErr))
+}
+
+func (t *S3BucketTest) TestDeleteObject() {
+	o := t.create("foo", []byte("taco"))
+
+	// Deleting a generation which doesn't exist does nothing.
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo", Generation: o.Generation + 1})
+	assert.NoError(t.T(), err)
+	assert.Contains(t.T(), t.fake.objects, "foo")
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo", Generation: o.Generation})
+	assert.NoError(t.T(), err)
+	assert.NotContains(t.T(), t.fake.objects, "foo")
+	// Deleting a missing object isn't an error.
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	assert.NoError(t.T(), err)
+}
+
+// An S3 bucket in which another client writes an object just before the
+// first delete.
+type racingS3 struct {
+	*fakeS3
+	race func()
+}
+
+func (r *racingS3) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	if r.race != nil {
+		r.race()
+		r.race = nil
+	}
+	return r.fakeS3.DeleteObject(ctx, in, optFns...)
+}
+
+func (t *S3BucketTest) TestDeleteObject_ReplacedBeforeDelete() {
+	o := t.create("foo", []byte("taco"))
+	racing := &racingS3{fakeS3: t.fake}
+	racing.race = func() {
+		t.fake.PutObject(t.ctx, &s3.PutObjectInput{Key: aws.String("foo"), Body: strings.NewReader("burrito")})
+	}
+	b := newS3Bucket(racing, "some_bucket", "", &t.clock)
+
+	err := b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo", Generation: o.Generation})
+
+	// The generation was gone by the time of the delete, which left the new one
+	// alone.
+	assert.NoError(t.T(), err)
+	contents, err := storageutil.ReadObject(t.ctx, t.bucket, "foo")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "burrito", string(contents))
+}
+
+func (t *S3BucketTest) TestListObjects() {
+	t.create("a", []byte("taco"))
+	t.create("dir/", nil)
+	t.create("dir/b", []byte("burrito"))
+	t.create("implicit/c", []byte("enchilada"))
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{Delimiter: "/", IncludeTrailingDelimiter: true})
+
+	require.NoError(t.T(), err)
+	var names []string
+	for _, o := range listing.Objects {
+		names = append(names, o.Name)
+	}
+	assert.Equal(t.T(), []string{"a", "dir/"}, names)
+	assert.Equal(t.T(), []string{"dir/", "implicit/"}, listing.CollapsedRuns)
+	assert.Empty(t.T(), listing.ContinuationToken)
+	assert.Equal(t.T(), uint64(4), listing.Objects[0].Size)
+	m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "a"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), m.Generation, listing.Objects[0].Generation)
+}
+
+func (t *S3BucketTest) TestListObjects_IncludesMetadata() {
+	crc32c := crc32.Checksum([]byte("taco"), s3CRC32CTable)
+	o, err := t.bucket.CreateObject(t.ctx, &gcs.CreateObjectRequest{
+		Name:        "foo",
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"gcsfuse_mtime": "2024-04-01"},
+		Contents:    strings.NewReader("taco"),
+	})
+	require.NoError(t.T(), err)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+
+	require.NoError(t.T(), err)
+	require.Len(t.T(), listing.Objects, 1)
+	assert.False(t.T(), listing.IncompleteObjects)
+	assert.Equal(t.T(), o, listing.Objects[0])
+	assert.Equal(t.T(), map[string]string{"gcsfuse_mtime": "2024-04-01"}, listing.Objects[0].Metadata)
+	assert.Equal(t.T(), &crc32c, listing.Objects[0].CRC32C)
+	assert.Equal(t.T(), "text/plain", listing.Objects[0].ContentType)
+}
+
+func (t *S3BucketTest) TestListObjects_WrittenByAnotherProcess() {
+	t.fake.versioned = true
+	t.create("foo", []byte("taco"))
+	t.create("bar", []byte("burrito"))
+	other := newS3Bucket(t.fake, "some_bucket", "", &t.clock)
+	t.fake.calls = nil
+
+	listing, err := other.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+
+	// The objects aren't looked up, and lack their metadata.
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), []string{"ListObjectVersions"}, t.fake.calls)
+	assert.True(t.T(), listing.IncompleteObjects)
+	require.Len(t.T(), listing.Objects, 2)
+	o := listing.Objects[1]
+	assert.Equal(t.T(), "foo", o.Name)
+	assert.Equal(t.T(), uint64(4), o.Size)
+	assert.Nil(t.T(), o.CRC32C)
+	// But the generation listed, derived from the version, is accepted.
+	rc, err := other.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo", Generation: o.Generation})
+	require.NoError(t.T(), err)
+	contents, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "taco", string(contents))
+	err = other.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo", Generation: o.Generation})
+	require.NoError(t.T(), err)
+	assert.NotContains(t.T(), t.fake.objects, "foo")
+	// Once looked up, they're listed in full.
+	_, _, err = other.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	require.NoError(t.T(), err)
+	listing, err = other.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	require.NoError(t.T(), err)
+	assert.False(t.T(), listing.IncompleteObjects)
+	require.Len(t.T(), listing.Objects, 1)
+	assert.NotNil(t.T(), listing.Objects[0].CRC32C)
+}
+
+func (t *S3BucketTest) TestListObjects_Paginated() {
+	t.create("a", []byte("taco"))
+	t.create("b", []byte("burrito"))
+	t.create("c", []byte("enchilada"))
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{MaxResults: 2})
+	require.NoError(t.T(), err)
+	require.Len(t.T(), listing.Objects, 2)
+	require.NotEmpty(t.T(), listing.ContinuationToken)
+	listing, err = t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{MaxResults: 2, ContinuationToken: listing.ContinuationToken})
+
+	require.NoError(t.T(), err)
+	require.Len(t.T(), listing.Objects, 1)
+	assert.Equal(t.T(), "c", listing.Objects[0].Name)
+	assert.Empty(t.T(), listing.ContinuationToken)
+}
+
+func (t *S3BucketTest) TestListObjects_VersionedBucket() {
+	t.fake.versioned = true
+	t.create("a", []byte("taco"))
+	t.create("b", []byte("burrito"))
+	t.create("b", []byte("enchilada"))
+	t.create("c", []byte("fajita"))
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "c"})
+	require.NoError(t.T(), err)
+
+	// Page through the versions one at a time.
+	var names []string
+	var listing *gcs.Listing
+	for listing == nil || listing.ContinuationToken != "" {
+		req := &gcs.ListObjectsRequest{MaxResults: 1}
+		if listing != nil {
+			req.ContinuationToken = listing.ContinuationToken
+		}
+		listing, err = t.bucket.ListObjects(t.ctx, req)
+		require.NoError(t.T(), err)
+		for _, o := range listing.Objects {
+			names = append(names, o.Name)
+			m, _, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: o.Name})
+			require.NoError(t.T(), err)
+			assert.Equal(t.T(), m.Generation, o.Generation)
+			assert.Equal(t.T(), m.Size, o.Size)
+		}
+	}
+
+	// The deleted object and noncurrent versions are left out.
+	assert.Equal(t.T(), []string{"a", "b"}, names)
+}
+
+func (t *S3BucketTest) TestListObjects_Versions() {
+	_, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{Versions: true})
+
+	assert.Error(t.T(), err)
+}
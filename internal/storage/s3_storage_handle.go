@@ -0,0 +1,100 @@
+// Copyright 2024 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
+	"github.com/jacobsa/timeutil"
+)
+
+// The region used if none is configured for the AWS SDK, e.g. with
+// AWS_REGION. Other S3-compatible stores generally ignore it.
+const defaultS3Region = "us-east-1"
+
+type s3StorageClient struct {
+	client  s3API
+	tempDir string
+}
+
+// Create a handle for buckets of the S3-compatible store at the custom
+// endpoint, or of AWS S3 if there's none. Credentials and the region are
+// found as by the AWS CLI, e.g. from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_REGION.
+func newS3StorageHandle(clientConfig *storageutil.StorageClientConfig) (sh StorageHandle, err error) {
+	// The SDK adds the certificates of AWS_CA_BUNDLE to the client's transport,
+	// so it builds it.
+	httpClient := awshttp.NewBuildableClient().
+		WithTransportOptions(func(tr *http.Transport) {
+			tr.MaxConnsPerHost = clientConfig.MaxConnsPerHost
+			tr.MaxIdleConnsPerHost = clientConfig.MaxIdleConnsPerHost
+		}).
+		WithTimeout(clientConfig.HttpClientTimeout)
+
+	cfg, err := config.LoadDefaultConfig(
+		context.Background(),
+		config.WithHTTPClient(httpClient),
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if clientConfig.MaxRetrySleep > 0 {
+					o.MaxBackoff = clientConfig.MaxRetrySleep
+				}
+			})
+		}))
+	if err != nil {
+		err = fmt.Errorf("loading AWS config: %w", err)
+		return
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+
+	if clientConfig.AnonymousAccess {
+		cfg.Credentials = aws.AnonymousCredentials{}
+	}
+
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, awsmiddleware.AddUserAgentKey(clientConfig.UserAgent))
+
+		// Stores other than AWS generally only support path-style URLs, and
+		// only the checksums S3 required before it took CRCs of every upload.
+		if clientConfig.CustomEndpoint != nil {
+			o.BaseEndpoint = aws.String(clientConfig.CustomEndpoint.String())
+			o.UsePathStyle = true
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	})
+
+	sh = &s3StorageClient{client: s3Client, tempDir: clientConfig.TempDir}
+	return
+}
+
+// S3 has no equivalent of billing projects, so billingProject is ignored.
+func (sh *s3StorageClient) BucketHandle(bucketName string, billingProject string) (bh gcs.Bucket) {
+	bh = newS3Bucket(sh.client, bucketName, sh.tempDir, timeutil.RealClock())
+	return
+}
@@ -24,9 +24,11 @@ import (
 	"github.com/googleapis/gax-go/v2"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/logger"
 	mountpkg "github.com/googlecloudplatform/gcsfuse/v2/internal/mount"
+	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/gcs"
 	"github.com/googlecloudplatform/gcsfuse/v2/internal/storage/storageutil"
 	"golang.org/x/net/context"
 	option "google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
@@ -41,12 +43,16 @@ type StorageHandle interface {
 	// to that project rather than to the bucket's owning project.
 	//
 	// A user-project is required for all operations on Requester Pays buckets.
-	BucketHandle(bucketName string, billingProject string) (bh *bucketHandle)
+	BucketHandle(bucketName string, billingProject string) (bh gcs.Bucket)
 }
 
 type storageClient struct {
 	client               *storage.Client
-	storageControlClient *control.StorageControlClient
+	storageControlClient StorageControlClient
+
+	// The JSON API, for the requests the storage client can't make. Nil over
+	// gRPC.
+	rawService *storagev1.Service
 }
 
 // Return clientOpts for both gRPC client and control client.
@@ -111,7 +117,9 @@ func createGRPCClientHandle(ctx context.Context, clientConfig *storageutil.Stora
 	return
 }
 
-func createHTTPClientHandle(ctx context.Context, clientConfig *storageutil.StorageClientConfig) (sc *storage.Client, err error) {
+// createHTTPClientHandle returns the storage client over HTTP, and the JSON
+// API service sharing its HTTP client and endpoint.
+func createHTTPClientHandle(ctx context.Context, clientConfig *storageutil.StorageClientConfig) (sc *storage.Client, rawService *storagev1.Service, err error) {
 	var clientOpts []option.ClientOption
 
 	// Add WithHttpClient option.
@@ -125,24 +133,31 @@ func createHTTPClientHandle(ctx context.Context, clientConfig *storageutil.Stora
 
 		clientOpts = append(clientOpts, option.WithHTTPClient(httpClient))
 	} else {
-		return nil, fmt.Errorf("client-protocol requested is not HTTP1 or HTTP2: %s", clientConfig.ClientProtocol)
+		return nil, nil, fmt.Errorf("client-protocol requested is not HTTP1 or HTTP2: %s", clientConfig.ClientProtocol)
 	}
 
 	if clientConfig.AnonymousAccess {
 		clientOpts = append(clientOpts, option.WithoutAuthentication())
 	}
 
-	// Create client with JSON read flow, if EnableJasonRead flag is set.
-	if clientConfig.ExperimentalEnableJsonRead {
-		clientOpts = append(clientOpts, storage.WithJSONReads())
-	}
-
 	// Add Custom endpoint option.
 	if clientConfig.CustomEndpoint != nil {
 		clientOpts = append(clientOpts, option.WithEndpoint(clientConfig.CustomEndpoint.String()))
 	}
 
-	return storage.NewClient(ctx, clientOpts...)
+	rawService, err = storagev1.NewService(ctx, clientOpts...)
+	if err != nil {
+		err = fmt.Errorf("while creating JSON API service: %w", err)
+		return
+	}
+
+	// Create client with JSON read flow, if EnableJasonRead flag is set.
+	if clientConfig.ExperimentalEnableJsonRead {
+		clientOpts = append(clientOpts, storage.WithJSONReads())
+	}
+
+	sc, err = storage.NewClient(ctx, clientOpts...)
+	return
 }
 
 // NewStorageHandle returns the handle of http or grpc Go storage client based on the
@@ -150,14 +165,19 @@ func createHTTPClientHandle(ctx context.Context, clientConfig *storageutil.Stora
 // Please check out the StorageClientConfig to know about the parameters used in
 // http and gRPC client.
 func NewStorageHandle(ctx context.Context, clientConfig storageutil.StorageClientConfig) (sh StorageHandle, err error) {
+	if clientConfig.ClientProtocol == mountpkg.S3 {
+		return newS3StorageHandle(&clientConfig)
+	}
+
 	var sc *storage.Client
+	var rawService *storagev1.Service
 	// The default protocol for the Go Storage control client's folders API is gRPC.
 	// gcsfuse will initially mirror this behavior due to the client's lack of HTTP support.
 	var controlClient *control.StorageControlClient
 	if clientConfig.ClientProtocol == mountpkg.GRPC {
 		sc, err = createGRPCClientHandle(ctx, &clientConfig)
 	} else if clientConfig.ClientProtocol == mountpkg.HTTP1 || clientConfig.ClientProtocol == mountpkg.HTTP2 {
-		sc, err = createHTTPClientHandle(ctx, &clientConfig)
+		sc, rawService, err = createHTTPClientHandle(ctx, &clientConfig)
 	} else {
 		err = fmt.Errorf("invalid client-protocol requested: %s", clientConfig.ClientProtocol)
 	}
@@ -194,11 +214,16 @@ func NewStorageHandle(ctx context.Context, clientConfig storageutil.StorageClien
 		storage.WithPolicy(storage.RetryAlways),
 		storage.WithErrorFunc(storageutil.ShouldRetry))
 
-	sh = &storageClient{client: sc, storageControlClient: controlClient}
+	handle := &storageClient{client: sc, rawService: rawService}
+	if controlClient != nil {
+		handle.storageControlClient = &controlClientWrapper{controlClient}
+	}
+
+	sh = handle
 	return
 }
 
-func (sh *storageClient) BucketHandle(bucketName string, billingProject string) (bh *bucketHandle) {
+func (sh *storageClient) BucketHandle(bucketName string, billingProject string) (bh gcs.Bucket) {
 	storageBucketHandle := sh.client.Bucket(bucketName)
 
 	if billingProject != "" {
@@ -206,9 +231,11 @@ func (sh *storageClient) BucketHandle(bucketName string, billingProject string)
 	}
 
 	bh = &bucketHandle{
-		bucket:        storageBucketHandle,
-		bucketName:    bucketName,
-		controlClient: sh.storageControlClient,
+		bucket:         storageBucketHandle,
+		bucketName:     bucketName,
+		billingProject: billingProject,
+		controlClient:  sh.storageControlClient,
+		rawService:     sh.rawService,
 	}
 	return
 }
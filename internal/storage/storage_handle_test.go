@@ -51,7 +51,7 @@ func (testSuite *StorageHandleTest) TearDownTest() {
 
 func (testSuite *StorageHandleTest) TestBucketHandleWhenBucketExistsWithEmptyBillingProject() {
 	storageHandle := testSuite.fakeStorage.CreateStorageHandle()
-	bucketHandle := storageHandle.BucketHandle(TestBucketName, "")
+	bucketHandle := storageHandle.BucketHandle(TestBucketName, "").(*bucketHandle)
 
 	assert.NotNil(testSuite.T(), bucketHandle)
 	assert.Equal(testSuite.T(), TestBucketName, bucketHandle.bucketName)
@@ -60,14 +60,14 @@ func (testSuite *StorageHandleTest) TestBucketHandleWhenBucketExistsWithEmptyBil
 
 func (testSuite *StorageHandleTest) TestBucketHandleWhenBucketDoesNotExistWithEmptyBillingProject() {
 	storageHandle := testSuite.fakeStorage.CreateStorageHandle()
-	bucketHandle := storageHandle.BucketHandle(invalidBucketName, "")
+	bucketHandle := storageHandle.BucketHandle(invalidBucketName, "").(*bucketHandle)
 
 	assert.Nil(testSuite.T(), bucketHandle.Bucket)
 }
 
 func (testSuite *StorageHandleTest) TestBucketHandleWhenBucketExistsWithNonEmptyBillingProject() {
 	storageHandle := testSuite.fakeStorage.CreateStorageHandle()
-	bucketHandle := storageHandle.BucketHandle(TestBucketName, projectID)
+	bucketHandle := storageHandle.BucketHandle(TestBucketName, projectID).(*bucketHandle)
 
 	assert.NotNil(testSuite.T(), bucketHandle)
 	assert.Equal(testSuite.T(), TestBucketName, bucketHandle.bucketName)
@@ -76,7 +76,7 @@ func (testSuite *StorageHandleTest) TestBucketHandleWhenBucketExistsWithNonEmpty
 
 func (testSuite *StorageHandleTest) TestBucketHandleWhenBucketDoesNotExistWithNonEmptyBillingProject() {
 	storageHandle := testSuite.fakeStorage.CreateStorageHandle()
-	bucketHandle := storageHandle.BucketHandle(invalidBucketName, projectID)
+	bucketHandle := storageHandle.BucketHandle(invalidBucketName, projectID).(*bucketHandle)
 
 	assert.Nil(testSuite.T(), bucketHandle.Bucket)
 }
@@ -214,10 +214,11 @@ func (testSuite *StorageHandleTest) TestCreateGRPCClientHandle() {
 func (testSuite *StorageHandleTest) TestCreateHTTPClientHandle() {
 	sc := storageutil.GetDefaultStorageClientConfig()
 
-	storageClient, err := createHTTPClientHandle(context.Background(), &sc)
+	storageClient, rawService, err := createHTTPClientHandle(context.Background(), &sc)
 
 	assert.Nil(testSuite.T(), err)
 	assert.NotNil(testSuite.T(), storageClient)
+	assert.NotNil(testSuite.T(), rawService)
 }
 
 func (testSuite *StorageHandleTest) TestNewStorageHandleWithGRPCClientProtocol() {
@@ -245,10 +246,11 @@ func (testSuite *StorageHandleTest) TestCreateHTTPClientHandle_WithGRPCClientPro
 	sc := storageutil.GetDefaultStorageClientConfig()
 	sc.ClientProtocol = mountpkg.GRPC
 
-	storageClient, err := createHTTPClientHandle(context.Background(), &sc)
+	storageClient, rawService, err := createHTTPClientHandle(context.Background(), &sc)
 
 	assert.NotNil(testSuite.T(), err)
 	assert.Nil(testSuite.T(), storageClient)
+	assert.Nil(testSuite.T(), rawService)
 	assert.Contains(testSuite.T(), err.Error(), fmt.Sprintf("client-protocol requested is not HTTP1 or HTTP2: %s", mountpkg.GRPC))
 }
 
@@ -298,3 +300,20 @@ func (testSuite *StorageHandleTest) TestCreateClientOptionForGRPCClient() {
 	assert.Nil(testSuite.T(), err)
 	assert.NotNil(testSuite.T(), clientOption)
 }
+
+func (testSuite *StorageHandleTest) TestNewStorageHandleWithS3ClientProtocol() {
+	url, err := url.Parse("http://localhost:9000")
+	assert.Nil(testSuite.T(), err)
+	sc := storageutil.GetDefaultStorageClientConfig()
+	sc.CustomEndpoint = url
+	sc.ClientProtocol = mountpkg.S3
+	sc.TempDir = "/some/temp/dir"
+
+	sh, err := NewStorageHandle(context.Background(), sc)
+
+	assert.Nil(testSuite.T(), err)
+	bucket, ok := sh.BucketHandle(TestBucketName, projectID).(*s3Bucket)
+	assert.True(testSuite.T(), ok)
+	assert.Equal(testSuite.T(), TestBucketName, bucket.Name())
+	assert.Equal(testSuite.T(), "/some/temp/dir", bucket.tempDir)
+}
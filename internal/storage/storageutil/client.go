@@ -54,6 +54,12 @@ type StorageClientConfig struct {
 	/** Grpc client parameters. */
 	GrpcConnPoolSize int
 
+	/** S3 client parameters. */
+
+	// TempDir is where the contents of objects being written are spooled, as S3
+	// needs their sizes up front. Empty means the default for temporary files.
+	TempDir string
+
 	// Enabling new API flow for HNS bucket.
 	EnableHNS config.EnableHNS
 }
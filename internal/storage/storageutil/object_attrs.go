@@ -16,6 +16,9 @@ package storageutil
 
 import (
 	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -91,6 +94,7 @@ func ObjectAttrsToBucketObject(attrs *storage.ObjectAttrs) *gcs.Object {
 		Generation:         attrs.Generation,
 		MetaGeneration:     attrs.Metageneration,
 		StorageClass:       attrs.StorageClass,
+		Created:            attrs.Created,
 		Deleted:            attrs.Deleted,
 		Updated:            attrs.Updated,
 		ComponentCount:     attrs.ComponentCount,
@@ -101,6 +105,71 @@ func ObjectAttrsToBucketObject(attrs *storage.ObjectAttrs) *gcs.Object {
 	}
 }
 
+// RawObjectToBucketObject converts an object resource of the JSON API, as
+// returned by requests made without the storage client, to *gcs.Object.
+func RawObjectToBucketObject(o *storagev1.Object) (*gcs.Object, error) {
+	var md5Hash [md5.Size]byte
+	if o.Md5Hash != "" {
+		b, err := base64.StdEncoding.DecodeString(o.Md5Hash)
+		if err != nil || len(b) != md5.Size {
+			return nil, fmt.Errorf("malformed md5Hash %q", o.Md5Hash)
+		}
+		copy(md5Hash[:], b)
+	}
+
+	var crc uint32
+	if o.Crc32c != "" {
+		b, err := base64.StdEncoding.DecodeString(o.Crc32c)
+		if err != nil || len(b) != 4 {
+			return nil, fmt.Errorf("malformed crc32c %q", o.Crc32c)
+		}
+		crc = binary.BigEndian.Uint32(b)
+	}
+
+	var owner string
+	if o.Owner != nil {
+		owner = o.Owner.Entity
+	}
+
+	// Times are formatted as RFC 3339, and missing ones are zero.
+	var times [4]time.Time
+	for i, v := range []string{o.TimeCreated, o.TimeDeleted, o.Updated, o.CustomTime} {
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed time %q: %w", v, err)
+		}
+		times[i] = t
+	}
+
+	return &gcs.Object{
+		Name:               o.Name,
+		ContentType:        o.ContentType,
+		ContentLanguage:    o.ContentLanguage,
+		CacheControl:       o.CacheControl,
+		Owner:              owner,
+		Size:               o.Size,
+		ContentEncoding:    o.ContentEncoding,
+		MD5:                &md5Hash,
+		CRC32C:             &crc,
+		MediaLink:          o.MediaLink,
+		Metadata:           o.Metadata,
+		Generation:         o.Generation,
+		MetaGeneration:     o.Metageneration,
+		StorageClass:       o.StorageClass,
+		Created:            times[0],
+		Deleted:            times[1],
+		Updated:            times[2],
+		ComponentCount:     o.ComponentCount,
+		ContentDisposition: o.ContentDisposition,
+		CustomTime:         times[3].Format(time.RFC3339),
+		EventBasedHold:     o.EventBasedHold,
+		Acl:                o.Acl,
+	}, nil
+}
+
 // SetAttrsInWriter - for setting object-attributes filed in storage.Writer object.
 // These attributes will be assigned to the newly created or old object.
 func SetAttrsInWriter(wc *storage.Writer, req *gcs.CreateObjectRequest) *storage.Writer {
@@ -151,6 +220,21 @@ func ConvertObjToMinObject(o *gcs.Object) *gcs.MinObject {
 	}
 }
 
+// ConvertFolderToMinObject returns a record standing for the folder, with which
+// directories backed by folders can be handled like those backed by objects.
+// Folders have no generation, hence the record has none.
+func ConvertFolderToMinObject(f *gcs.Folder) *gcs.MinObject {
+	if f == nil {
+		return nil
+	}
+
+	return &gcs.MinObject{
+		Name:           f.Name,
+		MetaGeneration: f.MetaGeneration,
+		Updated:        f.UpdateTime,
+	}
+}
+
 func ConvertObjToExtendedObjectAttributes(o *gcs.Object) *gcs.ExtendedObjectAttributes {
 	if o == nil {
 		return nil
@@ -164,6 +248,7 @@ func ConvertObjToExtendedObjectAttributes(o *gcs.Object) *gcs.ExtendedObjectAttr
 		MD5:                o.MD5,
 		MediaLink:          o.MediaLink,
 		StorageClass:       o.StorageClass,
+		Created:            o.Created,
 		Deleted:            o.Deleted,
 		ComponentCount:     o.ComponentCount,
 		ContentDisposition: o.ContentDisposition,
@@ -195,6 +280,7 @@ func ConvertMinObjectAndExtendedObjectAttributesToObject(m *gcs.MinObject,
 		CRC32C:             m.CRC32C,
 		MediaLink:          e.MediaLink,
 		StorageClass:       e.StorageClass,
+		Created:            e.Created,
 		Deleted:            e.Deleted,
 		ComponentCount:     e.ComponentCount,
 		ContentDisposition: e.ContentDisposition,
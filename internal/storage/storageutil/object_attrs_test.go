@@ -141,6 +141,47 @@ func (t objectAttrsTest) TestObjectAttrsToBucketObjectMethod() {
 	ExpectEq(object.ComponentCount, attrs.ComponentCount)
 }
 
+func (t objectAttrsTest) TestRawObjectToBucketObjectMethod() {
+	raw := &storagev1.Object{
+		Name:           TestObjectName,
+		ContentType:    "ContentType",
+		Owner:          &storagev1.ObjectOwner{Entity: "Owner"},
+		Size:           16,
+		Md5Hash:        "XrY7u+Ae7tCTyyK7j1rNww==",
+		Crc32c:         "AAAAAQ==",
+		Metadata:       map[string]string{"key": "value"},
+		Generation:     780,
+		Metageneration: 2,
+		TimeCreated:    "2024-06-01T10:00:00Z",
+		Updated:        "2024-06-02T10:00:00.5Z",
+		ComponentCount: 7,
+	}
+
+	object, err := RawObjectToBucketObject(raw)
+
+	AssertEq(nil, err)
+	ExpectEq(TestObjectName, object.Name)
+	ExpectEq("ContentType", object.ContentType)
+	ExpectEq("Owner", object.Owner)
+	ExpectEq(16, object.Size)
+	ExpectEq(md5.Sum([]byte("hello world")), *object.MD5)
+	ExpectEq(1, *object.CRC32C)
+	ExpectEq("value", object.Metadata["key"])
+	ExpectEq(780, object.Generation)
+	ExpectEq(2, object.MetaGeneration)
+	ExpectTrue(object.Created.Equal(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)))
+	ExpectTrue(object.Deleted.IsZero())
+	ExpectTrue(object.Updated.Equal(time.Date(2024, 6, 2, 10, 0, 0, 5e8, time.UTC)))
+	ExpectEq(7, object.ComponentCount)
+	ExpectEq(time.Time{}.Format(time.RFC3339), object.CustomTime)
+}
+
+func (t objectAttrsTest) TestRawObjectToBucketObjectMethodWithMalformedHash() {
+	_, err := RawObjectToBucketObject(&storagev1.Object{Md5Hash: "not base64"})
+
+	ExpectNe(nil, err)
+}
+
 func (t objectAttrsTest) TestConvertObjectAccessControlToACLRuleMethod() {
 	objectAccessControl := &storagev1.ObjectAccessControl{
 		Entity:   "test_entity",
@@ -259,6 +300,31 @@ func (t objectAttrsTest) Test_ConvertObjToMinObject_WithValidObject() {
 	ExpectEq(crc32C, *gcsMinObject.CRC32C)
 }
 
+func (t objectAttrsTest) Test_ConvertFolderToMinObject_WithNilFolder() {
+	var folder *gcs.Folder
+
+	gcsMinObject := ConvertFolderToMinObject(folder)
+
+	ExpectEq(nil, gcsMinObject)
+}
+
+func (t objectAttrsTest) Test_ConvertFolderToMinObject_WithValidFolder() {
+	currentTime := time.Now()
+	folder := gcs.Folder{
+		Name:           "test/",
+		MetaGeneration: 555,
+		UpdateTime:     currentTime,
+	}
+
+	gcsMinObject := ConvertFolderToMinObject(&folder)
+
+	AssertNe(nil, gcsMinObject)
+	ExpectEq("test/", gcsMinObject.Name)
+	ExpectEq(0, gcsMinObject.Generation)
+	ExpectEq(555, gcsMinObject.MetaGeneration)
+	ExpectTrue(currentTime.Equal(gcsMinObject.Updated))
+}
+
 func (t objectAttrsTest) Test_ConvertObjToExtendedObjectAttributes_WithNilObject() {
 	var gcsObject *gcs.Object
 
@@ -278,6 +344,7 @@ func (t objectAttrsTest) Test_ConvertObjToExtendedObjectAttributes_WithValidObje
 		MD5:                attrMd5,
 		MediaLink:          "MediaLink",
 		StorageClass:       "StorageClass",
+		Created:            timeAttr,
 		Deleted:            timeAttr,
 		ComponentCount:     7,
 		ContentDisposition: "ContentDisposition",
@@ -296,6 +363,7 @@ func (t objectAttrsTest) Test_ConvertObjToExtendedObjectAttributes_WithValidObje
 	ExpectEq(gcsObject.MD5, extendedObjAttr.MD5)
 	ExpectEq(gcsObject.MediaLink, extendedObjAttr.MediaLink)
 	ExpectEq(gcsObject.StorageClass, extendedObjAttr.StorageClass)
+	ExpectEq(0, gcsObject.Created.Compare(extendedObjAttr.Created))
 	ExpectEq(0, gcsObject.Deleted.Compare(extendedObjAttr.Deleted))
 	ExpectEq(gcsObject.ComponentCount, extendedObjAttr.ComponentCount)
 	ExpectEq(gcsObject.ContentDisposition, extendedObjAttr.ContentDisposition)
@@ -356,6 +424,7 @@ func (t objectAttrsTest) Test_ConvertObjToExtendedObjectAttributes_WithNonNilMin
 		MD5:                attrMd5,
 		MediaLink:          "MediaLink",
 		StorageClass:       "StorageClass",
+		Created:            timeAttr,
 		Deleted:            timeAttr,
 		ComponentCount:     7,
 		ContentDisposition: "ContentDisposition",
@@ -381,6 +450,7 @@ func (t objectAttrsTest) Test_ConvertObjToExtendedObjectAttributes_WithNonNilMin
 	ExpectEq(gcsObject.MD5, extendedObjAttr.MD5)
 	ExpectEq(gcsObject.MediaLink, extendedObjAttr.MediaLink)
 	ExpectEq(gcsObject.StorageClass, extendedObjAttr.StorageClass)
+	ExpectEq(0, gcsObject.Created.Compare(extendedObjAttr.Created))
 	ExpectEq(0, gcsObject.Deleted.Compare(extendedObjAttr.Deleted))
 	ExpectEq(gcsObject.ComponentCount, extendedObjAttr.ComponentCount)
 	ExpectEq(gcsObject.ContentDisposition, extendedObjAttr.ContentDisposition)
@@ -29,9 +29,10 @@ import (
 const (
 	GCSFUSE_PARENT_PROCESS_DIR = "gcsfuse-parent-process-dir"
 
-	// Constants for read types - Sequential/Random
+	// Constants for read types - Sequential/Random/Parallel
 	Sequential = "Sequential"
 	Random     = "Random"
+	Parallel   = "Parallel"
 
 	MaxMiBsInUint64 uint64 = math.MaxUint64 >> 20
 